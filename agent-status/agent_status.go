@@ -32,9 +32,15 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmetrics"
+	"github.com/aleksclark/crush-modules/clock"
 	"github.com/charmbracelet/crush/plugin"
 )
 
@@ -100,6 +106,21 @@ type StatusFile struct {
 
 	// Token usage.
 	Tokens *TokensInfo `json:"tokens,omitempty"`
+
+	// Per sub-agent usage, present when the subagents plugin publishes metrics.
+	SubAgents map[string]agentmetrics.Usage `json:"subagents,omitempty"`
+
+	// Orchestration context, present while an orchestrator such as the
+	// tempotown plugin is connected.
+	Context *ContextInfo `json:"context,omitempty"`
+}
+
+// ContextInfo describes the agent as the orchestrator it works for knows it.
+type ContextInfo struct {
+	Orchestrator string `json:"orchestrator"`
+	AgentID      string `json:"agent_id,omitempty"`
+	Role         string `json:"role,omitempty"`
+	TaskID       string `json:"task_id,omitempty"`
 }
 
 // ToolsInfo contains tool usage information.
@@ -107,6 +128,12 @@ type ToolsInfo struct {
 	Active *string        `json:"active"`
 	Recent []string       `json:"recent,omitempty"`
 	Counts map[string]int `json:"counts,omitempty"`
+
+	// SubAgent is the delegation path of the sub-agent run started last,
+	// e.g. "crush → code-reviewer", while sub-agents are running.
+	SubAgent string `json:"subagent,omitempty"`
+	// SubAgentCounts are the invocations per sub-agent.
+	SubAgentCounts map[string]int `json:"subagent_counts,omitempty"`
 }
 
 // TokensInfo contains token usage counters.
@@ -135,6 +162,10 @@ type AgentStatusHook struct {
 	instanceID     string
 	statusFilePath string
 	startedAt      int64
+	metrics        *agentmetrics.Collector
+	control        *agentcontrol.Switch
+	link           *agentlink.Link
+	clock          clock.Clock
 
 	mu            sync.RWMutex
 	currentStatus string
@@ -147,6 +178,11 @@ type AgentStatusHook struct {
 
 // NewAgentStatusHook creates a new agent status reporting hook.
 func NewAgentStatusHook(app *plugin.App, cfg Config) (*AgentStatusHook, error) {
+	return newAgentStatusHook(app, cfg, clock.Real)
+}
+
+// newAgentStatusHook creates a hook that reads the time from clk.
+func newAgentStatusHook(app *plugin.App, cfg Config, clk clock.Clock) (*AgentStatusHook, error) {
 	if cfg.UpdateIntervalSeconds <= 0 {
 		cfg.UpdateIntervalSeconds = int(DefaultUpdateInterval.Seconds())
 	}
@@ -161,7 +197,11 @@ func NewAgentStatusHook(app *plugin.App, cfg Config) (*AgentStatusHook, error) {
 		logger:         app.Logger().With("hook", HookName),
 		instanceID:     instanceID,
 		statusFilePath: statusFilePath,
-		startedAt:      time.Now().Unix(),
+		startedAt:      clk.Now().Unix(),
+		metrics:        agentmetrics.Shared(),
+		control:        agentcontrol.Shared(),
+		link:           agentlink.Shared(),
+		clock:          clk,
 		currentStatus:  StatusIdle,
 		recentTools:    make([]string, 0, 10),
 		toolCounts:     make(map[string]int),
@@ -200,8 +240,24 @@ func (h *AgentStatusHook) Start(ctx context.Context) error {
 		events = messages.SubscribeMessages(ctx)
 	}
 
+	// Sub-agent runs starting and finishing, the agent being paused or
+	// resumed, and orchestrator changes also trigger an update.
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	unwatch := h.metrics.Watch(notify)
+	defer unwatch()
+	unwatchControl := h.control.Watch(func(agentcontrol.State) { notify() })
+	defer unwatchControl()
+	unwatchLink := h.link.WatchOrchestrator(func(agentlink.Orchestrator) { notify() })
+	defer unwatchLink()
+
 	// Create ticker for periodic updates.
-	ticker := time.NewTicker(time.Duration(h.cfg.UpdateIntervalSeconds) * time.Second)
+	ticker := h.clock.NewTicker(time.Duration(h.cfg.UpdateIntervalSeconds) * time.Second)
 	defer ticker.Stop()
 
 	h.logger.Info("agent status reporting started",
@@ -213,7 +269,11 @@ func (h *AgentStatusHook) Start(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return h.Stop()
-		case <-ticker.C:
+		case <-ticker.C():
+			if err := h.writeStatusFile(); err != nil {
+				h.logger.Error("failed to write status file", "error", err)
+			}
+		case <-changed:
 			if err := h.writeStatusFile(); err != nil {
 				h.logger.Error("failed to write status file", "error", err)
 			}
@@ -330,6 +390,9 @@ func (h *AgentStatusHook) writeStatusFile() error {
 	status := h.buildStatusFile()
 	h.mu.RUnlock()
 
+	// Let an orchestrator plugin see the status the file shows.
+	h.link.SetStatus(agentlink.Status{Status: status.Status, Error: status.Error})
+
 	data, err := json.MarshalIndent(status, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal status: %w", err)
@@ -358,7 +421,7 @@ func (h *AgentStatusHook) buildStatusFile() StatusFile {
 		Agent:    DefaultAgentType,
 		Instance: h.instanceID,
 		Status:   h.currentStatus,
-		Updated:  time.Now().Unix(),
+		Updated:  h.clock.Now().Unix(),
 		PID:      os.Getpid(),
 		Project:  project,
 		CWD:      cwd,
@@ -369,6 +432,11 @@ func (h *AgentStatusHook) buildStatusFile() StatusFile {
 		sf.Task = h.currentTask
 	}
 
+	// A paused agent reports paused whatever it was doing.
+	if h.control.Paused() {
+		sf.Status = StatusPaused
+	}
+
 	if h.lastError != "" && h.currentStatus == StatusError {
 		sf.Error = h.lastError
 	}
@@ -395,9 +463,49 @@ func (h *AgentStatusHook) buildStatusFile() StatusFile {
 		}
 	}
 
+	if usage := h.metrics.Snapshot(); len(usage) > 0 {
+		sf.SubAgents = usage
+		sf.Tools.SubAgentCounts = make(map[string]int, len(usage))
+		for name, u := range usage {
+			sf.Tools.SubAgentCounts[name] = u.Runs
+		}
+	}
+	sf.Tools.SubAgent = delegationPath(h.metrics.Running())
+
+	if o := h.link.Orchestrator(); o.Name != "" {
+		sf.Context = &ContextInfo{
+			Orchestrator: o.Name,
+			AgentID:      o.AgentID,
+			Role:         o.Role,
+			TaskID:       o.TaskID,
+		}
+	}
+
 	return sf
 }
 
+// delegationPath renders the sub-agent run started last as the chain of
+// agents leading to it, e.g. "crush → planner → coder", or "" when no
+// sub-agent is running.
+func delegationPath(running []agentmetrics.Active) string {
+	if len(running) == 0 {
+		return ""
+	}
+	byAgent := make(map[string]agentmetrics.Active, len(running))
+	for _, a := range running {
+		byAgent[a.Agent] = a
+	}
+
+	last := running[len(running)-1]
+	path := []string{last.Agent}
+	for parent := last.Parent; parent != "" && len(path) <= len(running); parent = byAgent[parent].Parent {
+		path = append(path, parent)
+	}
+	path = append(path, DefaultAgentType)
+	slices.Reverse(path)
+	return strings.Join(path, " → ")
+}
+
 func (h *AgentStatusHook) removeStatusFile() error {
 	if err := os.Remove(h.statusFilePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove status file: %w", err)
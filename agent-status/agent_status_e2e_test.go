@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/aleksclark/crush-modules/testutil"
+	"github.com/aleksclark/crush-modules/testutil/mockllm"
 	"github.com/santhosh-tekuri/jsonschema/v6"
 	"github.com/stretchr/testify/require"
 )
@@ -182,37 +183,14 @@ func TestAgentStatusFileWritten(t *testing.T) {
 	tmpDir := t.TempDir()
 	statusDir := filepath.Join(tmpDir, "agent-status")
 
-	// Create config with agent-status plugin configured.
-	configJSON := `{
-  "providers": {
-    "test": {
-      "type": "openai-compat",
-      "base_url": "http://localhost:9999",
-      "api_key": "test-key"
-    }
-  },
-  "models": {
-    "large": { "provider": "test", "model": "test-model" },
-    "small": { "provider": "test", "model": "test-model" }
-  },
-  "options": {
-    "plugins": {
-      "agent-status": {
-        "status_dir": "` + statusDir + `",
-        "update_interval_seconds": 1
-      }
-    }
-  }
-}`
-
-	// Set up the isolated config environment.
-	configPath := filepath.Join(tmpDir, "config", "crush")
-	require.NoError(t, os.MkdirAll(configPath, 0o755))
-	require.NoError(t, os.WriteFile(filepath.Join(configPath, "crush.json"), []byte(configJSON), 0o644))
-
-	dataPath := filepath.Join(tmpDir, "data", "crush")
-	require.NoError(t, os.MkdirAll(dataPath, 0o755))
-	require.NoError(t, os.WriteFile(filepath.Join(dataPath, "crush.json"), []byte(configJSON), 0o644))
+	// Write the isolated config with the agent-status plugin configured. No
+	// LLM is needed, so the provider points nowhere.
+	mockllm.NewConfig("http://localhost:9999").
+		Plugin("agent-status", map[string]any{
+			"status_dir":              statusDir,
+			"update_interval_seconds": 1,
+		}).
+		Write(t, tmpDir)
 
 	// Start crush with the isolated config.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -280,35 +258,14 @@ func TestAgentStatusSchemaValidation(t *testing.T) {
 	tmpDir := t.TempDir()
 	statusDir := filepath.Join(tmpDir, "agent-status")
 
-	configJSON := `{
-  "providers": {
-    "test": {
-      "type": "openai-compat",
-      "base_url": "http://localhost:9999",
-      "api_key": "test-key"
-    }
-  },
-  "models": {
-    "large": { "provider": "test", "model": "test-model" },
-    "small": { "provider": "test", "model": "test-model" }
-  },
-  "options": {
-    "plugins": {
-      "agent-status": {
-        "status_dir": "` + statusDir + `",
-        "update_interval_seconds": 1
-      }
-    }
-  }
-}`
-
-	configPath := filepath.Join(tmpDir, "config", "crush")
-	require.NoError(t, os.MkdirAll(configPath, 0o755))
-	require.NoError(t, os.WriteFile(filepath.Join(configPath, "crush.json"), []byte(configJSON), 0o644))
-
-	dataPath := filepath.Join(tmpDir, "data", "crush")
-	require.NoError(t, os.MkdirAll(dataPath, 0o755))
-	require.NoError(t, os.WriteFile(filepath.Join(dataPath, "crush.json"), []byte(configJSON), 0o644))
+	// Write the isolated config with the agent-status plugin configured. No
+	// LLM is needed, so the provider points nowhere.
+	mockllm.NewConfig("http://localhost:9999").
+		Plugin("agent-status", map[string]any{
+			"status_dir":              statusDir,
+			"update_interval_seconds": 1,
+		}).
+		Write(t, tmpDir)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -8,6 +8,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmetrics"
+	"github.com/aleksclark/crush-modules/testutil/fakeclock"
+	"github.com/aleksclark/crush-modules/testutil/harness"
 	"github.com/charmbracelet/crush/plugin"
 	"github.com/stretchr/testify/require"
 )
@@ -79,6 +84,70 @@ func TestBuildStatusFile(t *testing.T) {
 	require.Equal(t, 5, sf.Tools.Counts["view"])
 }
 
+func TestBuildStatusFileSubAgents(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewAgentStatusHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+	hook.metrics = agentmetrics.New()
+
+	require.Nil(t, hook.buildStatusFile().SubAgents)
+
+	hook.metrics.Record(agentmetrics.Run{Agent: "reviewer", Tokens: 1200, CostUSD: 0.02})
+	sf := hook.buildStatusFile()
+	require.Equal(t, 1, sf.SubAgents["reviewer"].Runs)
+	require.Equal(t, int64(1200), sf.SubAgents["reviewer"].Tokens)
+	require.Equal(t, map[string]int{"reviewer": 1}, sf.Tools.SubAgentCounts)
+	require.Empty(t, sf.Tools.SubAgent)
+
+	endPlanner := hook.metrics.Begin("planner", "")
+	require.Equal(t, "crush → planner", hook.buildStatusFile().Tools.SubAgent)
+	time.Sleep(time.Millisecond)
+	endCoder := hook.metrics.Begin("coder", "planner")
+	require.Equal(t, "crush → planner → coder", hook.buildStatusFile().Tools.SubAgent)
+
+	endCoder()
+	endPlanner()
+	require.Empty(t, hook.buildStatusFile().Tools.SubAgent)
+}
+
+func TestBuildStatusFilePaused(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewAgentStatusHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	hook.currentStatus = StatusWorking
+
+	hook.control.Pause("supervisor request")
+	require.Equal(t, StatusPaused, hook.buildStatusFile().Status)
+
+	hook.control.Resume()
+	require.Equal(t, StatusWorking, hook.buildStatusFile().Status)
+}
+
+func TestOrchestratorLink(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewAgentStatusHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+	hook.link = agentlink.New()
+	hook.statusFilePath = filepath.Join(t.TempDir(), "crush-"+hook.instanceID+".json")
+	require.Nil(t, hook.buildStatusFile().Context)
+
+	hook.link.SetOrchestrator(agentlink.Orchestrator{Name: "tempotown", AgentID: "agent-1", Role: "coder", TaskID: "task-7"})
+	require.Equal(t, &ContextInfo{Orchestrator: "tempotown", AgentID: "agent-1", Role: "coder", TaskID: "task-7"}, hook.buildStatusFile().Context)
+
+	// The status written is published for the orchestrator.
+	hook.currentStatus = StatusError
+	hook.lastError = "tool failed"
+	require.NoError(t, hook.writeStatusFile())
+	require.Equal(t, agentlink.Status{Status: StatusError, Error: "tool failed"}, hook.link.Status())
+
+	hook.link.SetOrchestrator(agentlink.Orchestrator{})
+	require.Nil(t, hook.buildStatusFile().Context)
+}
+
 func TestWriteStatusFile(t *testing.T) {
 	// Use a temp directory for the status file.
 	tmpDir := t.TempDir()
@@ -364,3 +433,81 @@ func TestHookStartAndStop(t *testing.T) {
 	// File should be removed.
 	require.NoFileExists(t, hook.statusFilePath)
 }
+
+// TestHookFollowsConversation drives the hook with a scripted conversation.
+func TestHookFollowsConversation(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("AGENT_STATUS_DIR", tmpDir)
+
+	h := harness.New(t)
+	h.Session.Set(plugin.SessionInfo{Model: "mock-model", Provider: "mock"})
+	hook, err := NewAgentStatusHook(h.App, Config{UpdateIntervalSeconds: 60})
+	require.NoError(t, err)
+	hook.statusFilePath = filepath.Join(tmpDir, "crush-"+hook.instanceID+".json")
+	h.StartHook(t, hook)
+
+	readStatus := func() StatusFile {
+		var sf StatusFile
+		data, err := os.ReadFile(hook.statusFilePath)
+		if err == nil {
+			_ = json.Unmarshal(data, &sf)
+		}
+		return sf
+	}
+
+	script := harness.NewScript("s1").
+		User("fix the build").
+		Assistant("", harness.ToolCall("call-1", "bash", `{"command":"go build ./..."}`))
+	h.Messages.Send(script.Events()...)
+	require.Eventually(t, func() bool {
+		sf := readStatus()
+		return sf.Tools != nil && sf.Tools.Counts["bash"] == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	sf := readStatus()
+	require.Equal(t, "fix the build", sf.Task)
+	require.Equal(t, "mock-model", sf.Model)
+
+	script = harness.NewScript("s1").
+		ToolError("call-1", "bash", "undefined: foo").
+		Assistant("The build fails on an undefined name.")
+	h.Messages.Send(script.Events()...)
+	require.Eventually(t, func() bool {
+		return readStatus().Status == StatusIdle
+	}, 2*time.Second, 10*time.Millisecond)
+	sf = readStatus()
+	require.Nil(t, sf.Tools.Active)
+	require.Equal(t, []string{"bash"}, sf.Tools.Recent)
+}
+
+func TestHookUpdatesOnInterval(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("AGENT_STATUS_DIR", tmpDir)
+
+	start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(start)
+	h := harness.New(t)
+	hook, err := newAgentStatusHook(h.App, Config{UpdateIntervalSeconds: 10}, clk)
+	require.NoError(t, err)
+	hook.statusFilePath = filepath.Join(tmpDir, "crush-"+hook.instanceID+".json")
+	h.StartHook(t, hook)
+
+	updated := func() int64 {
+		var sf StatusFile
+		data, err := os.ReadFile(hook.statusFilePath)
+		if err == nil {
+			_ = json.Unmarshal(data, &sf)
+		}
+		return sf.Updated
+	}
+
+	require.Eventually(t, func() bool { return updated() == start.Unix() }, 2*time.Second, 10*time.Millisecond)
+
+	clk.BlockUntil(1)
+	clk.Advance(9 * time.Second)
+	require.Never(t, func() bool { return updated() != start.Unix() }, 50*time.Millisecond, 10*time.Millisecond)
+
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		return updated() == start.Add(10*time.Second).Unix()
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,58 @@
+// Package agentcaps lets plugins built into the same binary advertise what
+// the agent can do. Plugins such as subagents provide capabilities; an
+// orchestrator plugin such as tempotown lists them when it registers the
+// agent, instead of relying on a hand-maintained list.
+//
+// Capabilities are strings of the form kind:name, such as
+// "subagent:reviewer" or "model:claude-sonnet".
+package agentcaps
+
+import (
+	"slices"
+	"sync"
+)
+
+// Registry holds the capability providers of each plugin.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]func() []string
+}
+
+// New creates an empty registry.
+func New() *Registry {
+	return &Registry{providers: make(map[string]func() []string)}
+}
+
+// Provide sets the function that lists the capabilities source offers,
+// replacing any set before. It is called on every List, so the
+// capabilities may change over time.
+func (r *Registry) Provide(source string, fn func() []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[source] = fn
+}
+
+// List returns the capabilities of all sources, sorted and without
+// duplicates.
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	providers := make([]func() []string, 0, len(r.providers))
+	for _, fn := range r.providers {
+		providers = append(providers, fn)
+	}
+	r.mu.RUnlock()
+
+	var caps []string
+	for _, fn := range providers {
+		caps = append(caps, fn()...)
+	}
+	slices.Sort(caps)
+	return slices.Compact(caps)
+}
+
+var shared = New()
+
+// Shared returns the process-wide registry.
+func Shared() *Registry {
+	return shared
+}
@@ -0,0 +1,27 @@
+package agentcaps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := New()
+	require.Empty(t, r.List())
+
+	agents := []string{"subagent:reviewer"}
+	r.Provide("subagents", func() []string { return agents })
+	r.Provide("models", func() []string { return []string{"model:sonnet", "model:haiku", "model:sonnet"} })
+	require.Equal(t, []string{"model:haiku", "model:sonnet", "subagent:reviewer"}, r.List())
+
+	// Providers are asked on every List.
+	agents = append(agents, "subagent:coder")
+	require.Contains(t, r.List(), "subagent:coder")
+
+	// Providing again replaces the source.
+	r.Provide("models", func() []string { return nil })
+	require.Equal(t, []string{"subagent:coder", "subagent:reviewer"}, r.List())
+}
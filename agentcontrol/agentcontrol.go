@@ -0,0 +1,101 @@
+// Package agentcontrol lets plugins built into the same binary pause and
+// resume the agent. A supervisor plugin such as tempotown pauses it when
+// told to; plugins that do work on their own schedule, such as
+// periodic-prompts, hold off while it is paused, and reporting plugins such
+// as agent-status show it.
+package agentcontrol
+
+import (
+	"sync"
+	"time"
+)
+
+// State is whether the agent is paused, why, and since when.
+type State struct {
+	Paused bool
+	Reason string
+	Since  time.Time
+}
+
+// Switch holds the paused state and notifies watchers when it changes.
+type Switch struct {
+	mu       sync.RWMutex
+	state    State
+	watchers map[int]func(State)
+	nextID   int
+}
+
+// New creates a switch in the running state.
+func New() *Switch {
+	return &Switch{watchers: make(map[int]func(State))}
+}
+
+// Pause pauses the agent for reason and reports whether it was running.
+func (s *Switch) Pause(reason string) bool {
+	return s.set(State{Paused: true, Reason: reason, Since: time.Now()})
+}
+
+// Resume lets the agent run again and reports whether it was paused.
+func (s *Switch) Resume() bool {
+	return s.set(State{Since: time.Now()})
+}
+
+// set changes the state and notifies watchers, unless it is already paused
+// or running as asked.
+func (s *Switch) set(state State) bool {
+	s.mu.Lock()
+	if s.state.Paused == state.Paused {
+		s.mu.Unlock()
+		return false
+	}
+	s.state = state
+	watchers := make([]func(State), 0, len(s.watchers))
+	for _, fn := range s.watchers {
+		watchers = append(watchers, fn)
+	}
+	s.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(state)
+	}
+	return true
+}
+
+// Paused reports whether the agent is paused.
+func (s *Switch) Paused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state.Paused
+}
+
+// State returns the current state.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Watch calls fn with the new state whenever the agent is paused or
+// resumed. fn runs on the goroutine that changed the state and must not
+// block. The returned func removes the watcher.
+func (s *Switch) Watch(fn func(State)) (unwatch func()) {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.watchers[id] = fn
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		delete(s.watchers, id)
+		s.mu.Unlock()
+	}
+}
+
+var shared = New()
+
+// Shared returns the process-wide switch used to pause the agent across
+// plugins.
+func Shared() *Switch {
+	return shared
+}
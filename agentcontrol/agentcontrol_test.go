@@ -0,0 +1,39 @@
+package agentcontrol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitch(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	require.False(t, s.Paused())
+
+	var seen []State
+	unwatch := s.Watch(func(state State) { seen = append(seen, state) })
+
+	require.True(t, s.Pause("supervisor request"))
+	require.True(t, s.Paused())
+	require.Equal(t, "supervisor request", s.State().Reason)
+	require.False(t, s.State().Since.IsZero())
+
+	// Pausing again changes nothing.
+	require.False(t, s.Pause("again"))
+	require.Equal(t, "supervisor request", s.State().Reason)
+
+	require.True(t, s.Resume())
+	require.False(t, s.Paused())
+	require.Empty(t, s.State().Reason)
+	require.False(t, s.Resume())
+
+	require.Len(t, seen, 2)
+	require.True(t, seen[0].Paused)
+	require.False(t, seen[1].Paused)
+
+	unwatch()
+	s.Pause("")
+	require.Len(t, seen, 2)
+}
@@ -0,0 +1,188 @@
+// Package agentlink keeps the views plugins built into the same binary give
+// of the agent consistent. An orchestrator plugin such as tempotown publishes
+// who the agent is to it; a reporting plugin such as agent-status publishes
+// what the agent is doing. Each watches what the other publishes.
+//
+// Plugins that submit prompts on their own also note where each came from,
+// so that others, such as otlp, can tell them from the user's.
+package agentlink
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxPrompts is how many noted prompts are remembered.
+const maxPrompts = 32
+
+// Orchestrator describes the agent as an orchestrator knows it. The zero
+// value means no orchestrator is connected.
+type Orchestrator struct {
+	// Name is the orchestrator, e.g. "tempotown".
+	Name    string
+	AgentID string
+	Role    string
+	// TaskID is the task being worked on, or "" when idle.
+	TaskID string
+}
+
+// Status is the agent's status as a reporting plugin shows it, e.g.
+// "waiting" or "error", with the error message for the latter.
+type Status struct {
+	Status string
+	Error  string
+}
+
+// Prompt is where a prompt submitted by a plugin came from.
+type Prompt struct {
+	// Origin is the plugin that submitted it, e.g. "tempotown".
+	Origin string
+	// Source is who asked for it through the plugin, e.g. "supervisor".
+	Source string
+	// TaskID is the task it is about, if any.
+	TaskID string
+}
+
+// notedPrompt is a prompt and where it came from.
+type notedPrompt struct {
+	content string
+	prompt  Prompt
+}
+
+// Link holds what each side published and notifies watchers of changes.
+type Link struct {
+	mu           sync.RWMutex
+	orchestrator Orchestrator
+	status       Status
+	prompts      []notedPrompt
+	orchWatchers map[int]func(Orchestrator)
+	statWatchers map[int]func(Status)
+	nextID       int
+}
+
+// New creates a link with nothing published.
+func New() *Link {
+	return &Link{
+		orchWatchers: make(map[int]func(Orchestrator)),
+		statWatchers: make(map[int]func(Status)),
+	}
+}
+
+// SetOrchestrator publishes o, or the zero Orchestrator once disconnected,
+// and notifies watchers if it changed.
+func (l *Link) SetOrchestrator(o Orchestrator) {
+	l.mu.Lock()
+	if l.orchestrator == o {
+		l.mu.Unlock()
+		return
+	}
+	l.orchestrator = o
+	watchers := make([]func(Orchestrator), 0, len(l.orchWatchers))
+	for _, fn := range l.orchWatchers {
+		watchers = append(watchers, fn)
+	}
+	l.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(o)
+	}
+}
+
+// Orchestrator returns what the orchestrator published last.
+func (l *Link) Orchestrator() Orchestrator {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.orchestrator
+}
+
+// SetStatus publishes s and notifies watchers if it changed.
+func (l *Link) SetStatus(s Status) {
+	l.mu.Lock()
+	if l.status == s {
+		l.mu.Unlock()
+		return
+	}
+	l.status = s
+	watchers := make([]func(Status), 0, len(l.statWatchers))
+	for _, fn := range l.statWatchers {
+		watchers = append(watchers, fn)
+	}
+	l.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(s)
+	}
+}
+
+// Status returns the status published last.
+func (l *Link) Status() Status {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.status
+}
+
+// WatchOrchestrator calls fn whenever the orchestrator publishes a change.
+// fn runs on the publishing goroutine and must not block. The returned func
+// removes the watcher.
+func (l *Link) WatchOrchestrator(fn func(Orchestrator)) (unwatch func()) {
+	l.mu.Lock()
+	id := l.nextID
+	l.nextID++
+	l.orchWatchers[id] = fn
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		delete(l.orchWatchers, id)
+		l.mu.Unlock()
+	}
+}
+
+// WatchStatus calls fn whenever the status changes. fn runs on the
+// publishing goroutine and must not block. The returned func removes the
+// watcher.
+func (l *Link) WatchStatus(fn func(Status)) (unwatch func()) {
+	l.mu.Lock()
+	id := l.nextID
+	l.nextID++
+	l.statWatchers[id] = fn
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		delete(l.statWatchers, id)
+		l.mu.Unlock()
+	}
+}
+
+// NotePrompt records that a prompt with content is about to be submitted on
+// behalf of p. Only the latest prompts are remembered.
+func (l *Link) NotePrompt(content string, p Prompt) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prompts = append(l.prompts, notedPrompt{content: strings.TrimSpace(content), prompt: p})
+	if len(l.prompts) > maxPrompts {
+		l.prompts = l.prompts[len(l.prompts)-maxPrompts:]
+	}
+}
+
+// PromptOrigin returns where the user message with content came from, or
+// false when no plugin noted it, as for messages the user typed.
+func (l *Link) PromptOrigin(content string) (Prompt, bool) {
+	content = strings.TrimSpace(content)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.prompts) - 1; i >= 0; i-- {
+		if l.prompts[i].content == content {
+			return l.prompts[i].prompt, true
+		}
+	}
+	return Prompt{}, false
+}
+
+var shared = New()
+
+// Shared returns the process-wide link.
+func Shared() *Link {
+	return shared
+}
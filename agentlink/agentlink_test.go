@@ -0,0 +1,58 @@
+package agentlink
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+	require.Zero(t, l.Orchestrator())
+	require.Zero(t, l.Status())
+
+	var orchestrators []Orchestrator
+	var statuses []Status
+	unwatch := l.WatchOrchestrator(func(o Orchestrator) { orchestrators = append(orchestrators, o) })
+	l.WatchStatus(func(s Status) { statuses = append(statuses, s) })
+
+	o := Orchestrator{Name: "tempotown", AgentID: "agent-1", Role: "coder"}
+	l.SetOrchestrator(o)
+	l.SetOrchestrator(o)
+	require.Equal(t, []Orchestrator{o}, orchestrators)
+	require.Equal(t, o, l.Orchestrator())
+
+	l.SetStatus(Status{Status: "waiting"})
+	l.SetStatus(Status{Status: "error", Error: "boom"})
+	l.SetStatus(Status{Status: "error", Error: "boom"})
+	require.Equal(t, []Status{{Status: "waiting"}, {Status: "error", Error: "boom"}}, statuses)
+	require.Len(t, orchestrators, 1, "status changes do not reach orchestrator watchers")
+
+	unwatch()
+	l.SetOrchestrator(Orchestrator{})
+	require.Len(t, orchestrators, 1)
+	require.Zero(t, l.Orchestrator())
+}
+
+func TestPromptOrigin(t *testing.T) {
+	t.Parallel()
+
+	l := New()
+	_, ok := l.PromptOrigin("Fix the tests.")
+	require.False(t, ok)
+
+	p := Prompt{Origin: "tempotown", Source: "supervisor", TaskID: "task-7"}
+	l.NotePrompt("[Tempotown] Feedback from supervisor:\nFix the tests.\n", p)
+	got, ok := l.PromptOrigin("[Tempotown] Feedback from supervisor:\nFix the tests.")
+	require.True(t, ok)
+	require.Equal(t, p, got)
+
+	// Only the latest prompts are remembered.
+	for range maxPrompts {
+		l.NotePrompt("other", Prompt{Origin: "periodic-prompts"})
+	}
+	_, ok = l.PromptOrigin("[Tempotown] Feedback from supervisor:\nFix the tests.")
+	require.False(t, ok)
+}
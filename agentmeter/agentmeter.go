@@ -0,0 +1,91 @@
+// Package agentmeter lets plugins built into the same binary export metrics
+// without depending on a metrics SDK. Plugins such as tempotown record
+// counters and histograms; an exporting plugin such as otlp subscribes and
+// sends them on.
+//
+// Measurements recorded while nobody subscribes are dropped.
+package agentmeter
+
+import "sync"
+
+// Kind is the kind of instrument a measurement belongs to.
+type Kind int
+
+const (
+	// Counter values are increments of a running total.
+	Counter Kind = iota
+	// Histogram values are samples of a distribution, such as latencies.
+	Histogram
+)
+
+// Measurement is one value recorded for an instrument.
+type Measurement struct {
+	// Name is the instrument, e.g. "tempotown.rpc.duration".
+	Name string
+	Kind Kind
+	// Unit is a UCUM unit such as "ms", or "" for a plain count.
+	Unit  string
+	Value float64
+	// Attrs distinguish series of the instrument, e.g. {"method": "ping"}.
+	Attrs map[string]string
+}
+
+// Meter fans measurements out to subscribers.
+type Meter struct {
+	mu     sync.RWMutex
+	subs   map[int]func(Measurement)
+	nextID int
+}
+
+// New creates a meter without subscribers.
+func New() *Meter {
+	return &Meter{subs: make(map[int]func(Measurement))}
+}
+
+// Add records an increment of n to the counter name.
+func (m *Meter) Add(name string, n int64, attrs map[string]string) {
+	m.record(Measurement{Name: name, Kind: Counter, Value: float64(n), Attrs: attrs})
+}
+
+// Observe records v in the histogram name, measured in unit.
+func (m *Meter) Observe(name, unit string, v float64, attrs map[string]string) {
+	m.record(Measurement{Name: name, Kind: Histogram, Unit: unit, Value: v, Attrs: attrs})
+}
+
+// record passes mm to every subscriber.
+func (m *Meter) record(mm Measurement) {
+	m.mu.RLock()
+	subs := make([]func(Measurement), 0, len(m.subs))
+	for _, fn := range m.subs {
+		subs = append(subs, fn)
+	}
+	m.mu.RUnlock()
+
+	for _, fn := range subs {
+		fn(mm)
+	}
+}
+
+// Subscribe calls fn for every measurement recorded after it returns. fn
+// runs on the recording goroutine and must not block. The returned func
+// removes the subscription.
+func (m *Meter) Subscribe(fn func(Measurement)) (unsubscribe func()) {
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.subs[id] = fn
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		delete(m.subs, id)
+		m.mu.Unlock()
+	}
+}
+
+var shared = New()
+
+// Shared returns the process-wide meter.
+func Shared() *Meter {
+	return shared
+}
@@ -0,0 +1,27 @@
+package agentmeter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeter(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.Add("dropped", 1, nil)
+
+	var got []Measurement
+	unsubscribe := m.Subscribe(func(mm Measurement) { got = append(got, mm) })
+	m.Add("tempotown.connect.attempts", 1, map[string]string{"outcome": "success"})
+	m.Observe("tempotown.rpc.duration", "ms", 12.5, map[string]string{"method": "ping"})
+	require.Equal(t, []Measurement{
+		{Name: "tempotown.connect.attempts", Kind: Counter, Value: 1, Attrs: map[string]string{"outcome": "success"}},
+		{Name: "tempotown.rpc.duration", Kind: Histogram, Unit: "ms", Value: 12.5, Attrs: map[string]string{"method": "ping"}},
+	}, got)
+
+	unsubscribe()
+	m.Add("tempotown.connect.attempts", 1, nil)
+	require.Len(t, got, 2)
+}
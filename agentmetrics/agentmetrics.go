@@ -0,0 +1,192 @@
+// Package agentmetrics aggregates usage per sub-agent so that plugins built
+// into the same binary can report it. Sub-agent plugins mark runs as they
+// start and record them when they finish; reporting plugins such as
+// agent-status and otlp read snapshots or subscribe to changes.
+package agentmetrics
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Run describes one finished sub-agent invocation.
+type Run struct {
+	Agent    string
+	Started  time.Time
+	Duration time.Duration
+	Tokens   int64
+	CostUSD  float64
+	Error    string // Empty when the run succeeded
+
+	// ToolCallID is the ID of the tool call that started the run, when the
+	// run was invoked as a tool. Nested runs carry the outermost call's ID.
+	ToolCallID string
+	// Parent is the sub-agent that delegated the run, empty at the top level.
+	Parent string
+}
+
+// Active is a sub-agent run in progress.
+type Active struct {
+	Agent   string
+	Parent  string // Sub-agent that delegated the run, empty at the top level
+	Started time.Time
+}
+
+// Usage is the accumulated usage of one agent.
+type Usage struct {
+	Runs     int           `json:"runs"`
+	Errors   int           `json:"errors,omitempty"`
+	Tokens   int64         `json:"tokens"`
+	CostUSD  float64       `json:"cost_usd"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Collector accumulates usage per agent and fans runs out to subscribers.
+type Collector struct {
+	mu          sync.RWMutex
+	usage       map[string]Usage
+	active      map[int]Active
+	subscribers map[int]func(Run)
+	watchers    map[int]func()
+	nextID      int
+}
+
+// New creates an empty collector.
+func New() *Collector {
+	return &Collector{
+		usage:       make(map[string]Usage),
+		active:      make(map[int]Active),
+		subscribers: make(map[int]func(Run)),
+		watchers:    make(map[int]func()),
+	}
+}
+
+// Begin marks a run of agent, delegated by parent, as in progress until the
+// returned func is called.
+func (c *Collector) Begin(agent, parent string) (end func()) {
+	c.mu.Lock()
+	id := c.nextID
+	c.nextID++
+	c.active[id] = Active{Agent: agent, Parent: parent, Started: time.Now()}
+	c.mu.Unlock()
+	c.notify()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.active, id)
+			c.mu.Unlock()
+			c.notify()
+		})
+	}
+}
+
+// Running returns the runs in progress, oldest first.
+func (c *Collector) Running() []Active {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	running := make([]Active, 0, len(c.active))
+	for _, a := range c.active {
+		running = append(running, a)
+	}
+	slices.SortFunc(running, func(a, b Active) int { return a.Started.Compare(b.Started) })
+	return running
+}
+
+// Watch calls fn whenever a run begins, ends, or is recorded. fn must not
+// block. The
+// returned func removes the watcher.
+func (c *Collector) Watch(fn func()) (unwatch func()) {
+	c.mu.Lock()
+	id := c.nextID
+	c.nextID++
+	c.watchers[id] = fn
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		delete(c.watchers, id)
+		c.mu.Unlock()
+	}
+}
+
+// notify calls every watcher.
+func (c *Collector) notify() {
+	c.mu.RLock()
+	watchers := make([]func(), 0, len(c.watchers))
+	for _, fn := range c.watchers {
+		watchers = append(watchers, fn)
+	}
+	c.mu.RUnlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+}
+
+// Record adds run to its agent's totals and notifies subscribers.
+func (c *Collector) Record(run Run) {
+	c.mu.Lock()
+	u := c.usage[run.Agent]
+	u.Runs++
+	if run.Error != "" {
+		u.Errors++
+	}
+	u.Tokens += run.Tokens
+	u.CostUSD += run.CostUSD
+	u.Duration += run.Duration
+	c.usage[run.Agent] = u
+
+	subscribers := make([]func(Run), 0, len(c.subscribers))
+	for _, fn := range c.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	c.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(run)
+	}
+	c.notify()
+}
+
+// Get returns the usage of one agent.
+func (c *Collector) Get(agent string) Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage[agent]
+}
+
+// Snapshot returns a copy of the usage of every agent that has run.
+func (c *Collector) Snapshot() map[string]Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.usage)
+}
+
+// Subscribe calls fn for every run recorded after it returns. fn runs on
+// the recording goroutine and must not block. The returned func removes
+// the subscription.
+func (c *Collector) Subscribe(fn func(Run)) (unsubscribe func()) {
+	c.mu.Lock()
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = fn
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		delete(c.subscribers, id)
+		c.mu.Unlock()
+	}
+}
+
+var shared = New()
+
+// Shared returns the process-wide collector used to exchange usage
+// between plugins.
+func Shared() *Collector {
+	return shared
+}
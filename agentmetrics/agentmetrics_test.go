@@ -0,0 +1,63 @@
+package agentmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	c := New()
+	var seen []Run
+	unsubscribe := c.Subscribe(func(run Run) { seen = append(seen, run) })
+
+	c.Record(Run{Agent: "reviewer", Tokens: 100, CostUSD: 0.01, Duration: time.Second})
+	c.Record(Run{Agent: "reviewer", Tokens: 50, Duration: time.Second, Error: "boom"})
+	c.Record(Run{Agent: "writer", Tokens: 10})
+
+	require.Equal(t, Usage{Runs: 2, Errors: 1, Tokens: 150, CostUSD: 0.01, Duration: 2 * time.Second}, c.Get("reviewer"))
+	require.Len(t, c.Snapshot(), 2)
+	require.Len(t, seen, 3)
+
+	unsubscribe()
+	c.Record(Run{Agent: "writer"})
+	require.Len(t, seen, 3)
+	require.Equal(t, 2, c.Get("writer").Runs)
+}
+
+func TestCollectorRunning(t *testing.T) {
+	t.Parallel()
+
+	c := New()
+	changes := 0
+	unwatch := c.Watch(func() { changes++ })
+
+	endPlanner := c.Begin("planner", "")
+	time.Sleep(time.Millisecond)
+	endCoder := c.Begin("coder", "planner")
+	require.Equal(t, []string{"planner", "coder"}, agents(c.Running()))
+	require.Equal(t, "planner", c.Running()[1].Parent)
+
+	endCoder()
+	endCoder()
+	require.Equal(t, []string{"planner"}, agents(c.Running()))
+	c.Record(Run{Agent: "coder"})
+	endPlanner()
+	require.Empty(t, c.Running())
+	require.Equal(t, 5, changes)
+
+	unwatch()
+	c.Begin("planner", "")()
+	require.Equal(t, 5, changes)
+}
+
+func agents(running []Active) []string {
+	names := make([]string, len(running))
+	for i, a := range running {
+		names[i] = a.Agent
+	}
+	return names
+}
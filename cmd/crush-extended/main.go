@@ -6,6 +6,10 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/aleksclark/crush-modules/subagents"
 	"github.com/charmbracelet/crush/cmd/crush"
 
 	// Import plugins - they register themselves via init()
@@ -14,11 +18,19 @@ import (
 	_ "github.com/aleksclark/crush-modules/kuri"
 	_ "github.com/aleksclark/crush-modules/otlp"
 	_ "github.com/aleksclark/crush-modules/periodic-prompts"
-	_ "github.com/aleksclark/crush-modules/subagents"
 	_ "github.com/aleksclark/crush-modules/tavily"
 	_ "github.com/aleksclark/crush-modules/tempotown"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == subagents.ValidateFlag {
+		wd, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(subagents.RunValidate(os.Args[2:], wd, os.Stdout))
+	}
+
 	crush.Execute()
 }
@@ -0,0 +1,140 @@
+// mockllm serves the mock OpenAI-compatible LLM server from testutil/mockllm
+// outside of Go tests, for trying plugins against a scripted provider by
+// hand or in demos.
+//
+// Usage:
+//
+//	mockllm -script scenario.yaml
+//	mockllm -addr 127.0.0.1:8090 -script scenario.yaml -config ~/.config/crush/crush.json
+//	mockllm -recording testdata/session.json -strict
+//
+// The script is a mockllm.Script in YAML or JSON. With -config, a crush.json
+// pointing at the server is written to the given path.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/aleksclark/crush-modules/testutil/mockllm"
+)
+
+func main() {
+	var (
+		addr          string
+		script        string
+		recording     string
+		configPath    string
+		strict        bool
+		estimateUsage bool
+		contextWindow int
+	)
+
+	flag.StringVar(&addr, "addr", "127.0.0.1:8090", "address to listen on")
+	flag.StringVar(&script, "script", "", "scenario file to answer with (YAML or JSON)")
+	flag.StringVar(&recording, "recording", "", "recording to replay, as written by SaveRecording")
+	flag.StringVar(&configPath, "config", "", "write a crush.json using the server to this path")
+	flag.BoolVar(&strict, "strict", false, "reject malformed requests as a strict provider would")
+	flag.BoolVar(&estimateUsage, "estimate-usage", false, "report token usage estimated from the text")
+	flag.IntVar(&contextWindow, "context-window", 0, "fail prompts over this many tokens (0 for no limit)")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "mockllm: ", log.LstdFlags)
+
+	server, err := newServer(script, recording)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if strict {
+		server.StrictMode()
+	}
+	if estimateUsage {
+		server.WithEstimatedUsage()
+	}
+	server.ContextWindow(contextWindow)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String() + "/v1"
+
+	if configPath != "" {
+		if err := writeConfig(configPath, url); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Printf("wrote %s", configPath)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	srv := &http.Server{Handler: logRequests(logger, server.Handler(printfLogger{logger}))}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	logger.Printf("serving on %s", url)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+}
+
+// newServer returns a server answering with the script or recording, or
+// with the default response when neither is given.
+func newServer(script, recording string) (*mockllm.Server, error) {
+	server := mockllm.NewServer()
+	if script != "" && recording != "" {
+		return nil, errors.New("-script and -recording cannot be combined")
+	}
+	if script != "" {
+		if err := server.LoadScript(script); err != nil {
+			return nil, fmt.Errorf("load script: %w", err)
+		}
+	}
+	if recording != "" {
+		if err := server.LoadRecording(recording); err != nil {
+			return nil, fmt.Errorf("load recording: %w", err)
+		}
+	}
+	return server, nil
+}
+
+// writeConfig writes a crush.json using the mock provider at url to path.
+func writeConfig(path, url string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(mockllm.NewConfig(url).JSON()), 0o644)
+}
+
+// logRequests logs the method and path of each request.
+func logRequests(logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Printf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// printfLogger reports what the server would report to a test to a
+// log.Logger.
+type printfLogger struct {
+	*log.Logger
+}
+
+func (l printfLogger) Errorf(format string, args ...any) {
+	l.Printf("error: "+format, args...)
+}
+
+func (l printfLogger) Logf(format string, args ...any) {
+	l.Printf(format, args...)
+}
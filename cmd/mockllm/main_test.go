@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aleksclark/crush-modules/testutil/mockllm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeScript(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "scenario.yaml")
+	require.NoError(t, os.WriteFile(script, []byte(`
+rules:
+  - match: {message_contains: ping}
+    respond: {text: pong}
+`), 0o644))
+
+	server, err := newServer(script, "")
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Handler(nil))
+	t.Cleanup(ts.Close)
+
+	body, err := json.Marshal(mockllm.ChatRequest{
+		Model:    mockllm.LargeModel,
+		Messages: []mockllm.Message{{Role: "user", Content: "ping"}},
+	})
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var chat mockllm.ChatResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&chat))
+	require.Equal(t, "pong", chat.Choices[0].Message.Content)
+
+	_, err = newServer(script, filepath.Join(dir, "recording.json"))
+	require.Error(t, err)
+	_, err = newServer(filepath.Join(dir, "missing.yaml"), "")
+	require.Error(t, err)
+}
+
+func TestWriteConfig(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "crush", "crush.json")
+	require.NoError(t, writeConfig(path, "http://127.0.0.1:8090/v1"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.JSONEq(t, mockllm.NewConfig("http://127.0.0.1:8090/v1").JSON(), string(data))
+}
@@ -0,0 +1,78 @@
+// Package frontmatter parses Markdown files with a leading YAML frontmatter
+// block. It is shared by plugins that load self-describing definitions from
+// disk, such as sub-agents and periodic prompts.
+package frontmatter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Delimiter opens and closes the frontmatter block.
+const Delimiter = "---"
+
+// HasFrontmatter reports whether data starts with a frontmatter delimiter.
+func HasFrontmatter(data []byte) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return strings.TrimSpace(string(line)) == Delimiter
+}
+
+// Split separates YAML frontmatter from the Markdown body.
+// Expects format:
+// ---
+// yaml content
+// ---
+// markdown body
+func Split(data []byte) (frontmatter, body []byte, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	// Find opening ---.
+	if !scanner.Scan() {
+		return nil, nil, fmt.Errorf("empty file")
+	}
+	if strings.TrimSpace(scanner.Text()) != Delimiter {
+		return nil, nil, fmt.Errorf("file must start with ---")
+	}
+
+	// Read frontmatter until closing ---.
+	var fmLines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == Delimiter {
+			break
+		}
+		fmLines = append(fmLines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	frontmatter = []byte(strings.Join(fmLines, "\n"))
+
+	// Rest is markdown body.
+	var bodyLines []string
+	for scanner.Scan() {
+		bodyLines = append(bodyLines, scanner.Text())
+	}
+	body = []byte(strings.Join(bodyLines, "\n"))
+
+	return frontmatter, body, nil
+}
+
+// Parse splits data, unmarshals the frontmatter into v, and returns the
+// trimmed Markdown body.
+func Parse(data []byte, v any) (string, error) {
+	fm, body, err := Split(data)
+	if err != nil {
+		return "", fmt.Errorf("parse frontmatter: %w", err)
+	}
+	if err := yaml.Unmarshal(fm, v); err != nil {
+		return "", fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
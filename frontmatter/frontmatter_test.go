@@ -0,0 +1,57 @@
+package frontmatter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	t.Parallel()
+
+	content := `---
+name: test
+description: test desc
+---
+
+This is the body.
+Multiple lines.`
+
+	fm, body, err := Split([]byte(content))
+	require.NoError(t, err)
+	require.Contains(t, string(fm), "name: test")
+	require.Contains(t, string(body), "This is the body")
+}
+
+func TestSplitErrors(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := Split(nil)
+	require.ErrorContains(t, err, "empty file")
+
+	_, _, err = Split([]byte("Just some text"))
+	require.ErrorContains(t, err, "must start with ---")
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	var v struct {
+		Name     string `yaml:"name"`
+		Schedule string `yaml:"schedule"`
+	}
+	body, err := Parse([]byte("---\nname: hourly\nschedule: \"0 * * * *\"\n---\n\n  Check the build.\n"), &v)
+	require.NoError(t, err)
+	require.Equal(t, "hourly", v.Name)
+	require.Equal(t, "0 * * * *", v.Schedule)
+	require.Equal(t, "Check the build.", body)
+}
+
+func TestHasFrontmatter(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, HasFrontmatter([]byte("---\nname: x\n---\nbody")))
+	require.True(t, HasFrontmatter([]byte("---")))
+	require.False(t, HasFrontmatter([]byte("plain prompt")))
+	require.False(t, HasFrontmatter(nil))
+}
@@ -1,7 +1,8 @@
 // Package otlp provides an OTLP tracing plugin for Crush.
 //
 // The plugin exports traces for chat messages and tool calls to an OTLP-compatible
-// backend (such as Jaeger, Zipkin, or any OpenTelemetry collector).
+// backend (such as Jaeger, Zipkin, or any OpenTelemetry collector), along with
+// the metrics other plugins record, such as tempotown's connection health.
 //
 // Configuration in crush.json:
 //
@@ -30,10 +31,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmeter"
+	"github.com/aleksclark/crush-modules/agentmetrics"
 	"github.com/charmbracelet/crush/plugin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
@@ -58,6 +66,17 @@ const (
 
 	// DefaultToolResultLimit is the max length for tool result attributes.
 	DefaultToolResultLimit = 4000
+
+	// DefaultMetricsInterval is how often metrics are exported, in seconds.
+	DefaultMetricsInterval = 60
+)
+
+// Who initiated a message or session: the user, or a plugin submitting
+// prompts, such as tempotown injecting orchestrator feedback.
+const (
+	initiatedByUser   = "user"
+	startReasonUser   = "user_initiated"
+	startReasonPlugin = "plugin_initiated"
 )
 
 // Config defines the configuration options for the OTLP plugin.
@@ -82,6 +101,13 @@ type Config struct {
 
 	// ToolResultLimit is the max length for tool result attributes (default: 4000).
 	ToolResultLimit int `json:"tool_result_limit,omitempty"`
+
+	// Metrics exports the metrics other plugins record, such as tempotown's
+	// connection health, to the same endpoint (default: true).
+	Metrics *bool `json:"metrics,omitempty"`
+
+	// MetricsIntervalSeconds is how often metrics are exported (default: 60).
+	MetricsIntervalSeconds int `json:"metrics_interval_seconds,omitempty"`
 }
 
 func init() {
@@ -127,6 +153,19 @@ type OTLPHook struct {
 	completedAssistantMessages   map[string]struct{}
 	completedAssistantMessagesMu sync.RWMutex
 
+	// link tells which prompts plugins submitted, and on whose behalf.
+	link *agentlink.Link
+
+	// Metrics recorded by other plugins through measurements, exported by
+	// meterProvider. Instruments are created on first use, guarded by
+	// instrumentsMu.
+	measurements  *agentmeter.Meter
+	meterProvider *sdkmetric.MeterProvider
+	meter         metric.Meter
+	counters      map[string]metric.Int64Counter
+	histograms    map[string]metric.Float64Histogram
+	instrumentsMu sync.Mutex
+
 	// Cached project/git info.
 	projectPath string
 	projectName string
@@ -150,6 +189,9 @@ func NewOTLPHook(app *plugin.App, cfg Config) (*OTLPHook, error) {
 	if cfg.ToolResultLimit == 0 {
 		cfg.ToolResultLimit = DefaultToolResultLimit
 	}
+	if cfg.MetricsIntervalSeconds == 0 {
+		cfg.MetricsIntervalSeconds = DefaultMetricsInterval
+	}
 
 	hook := &OTLPHook{
 		app:                        app,
@@ -158,6 +200,10 @@ func NewOTLPHook(app *plugin.App, cfg Config) (*OTLPHook, error) {
 		sessionContexts:            make(map[string]sessionContext),
 		toolSpans:                  make(map[string]trace.Span),
 		completedAssistantMessages: make(map[string]struct{}),
+		link:                       agentlink.Shared(),
+		measurements:               agentmeter.Shared(),
+		counters:                   make(map[string]metric.Int64Counter),
+		histograms:                 make(map[string]metric.Float64Histogram),
 	}
 
 	// Initialize project info.
@@ -243,6 +289,15 @@ func (h *OTLPHook) Start(ctx context.Context) error {
 	events := messages.SubscribeMessages(ctx)
 	h.logger.Info("OTLP tracing started", "endpoint", h.cfg.Endpoint, "service", h.cfg.ServiceName)
 
+	// Sub-agent runs are reported by the subagents plugin when it publishes metrics.
+	unsubscribe := agentmetrics.Shared().Subscribe(h.recordSubAgentRun)
+	defer unsubscribe()
+
+	if h.meter != nil {
+		unsubscribeMeasurements := h.measurements.Subscribe(h.recordMeasurement)
+		defer unsubscribeMeasurements()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -289,6 +344,12 @@ func (h *OTLPHook) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if h.meterProvider != nil {
+		if err := h.meterProvider.Shutdown(ctx); err != nil {
+			h.logger.Error("failed to shutdown meter provider", "error", err)
+		}
+	}
+
 	if err := h.provider.Shutdown(ctx); err != nil {
 		h.logger.Error("failed to shutdown tracer provider", "error", err)
 		return err
@@ -336,6 +397,12 @@ func (h *OTLPHook) initTracer(ctx context.Context) error {
 	otel.SetTracerProvider(h.provider)
 	h.tracer = h.provider.Tracer("crush.agent")
 
+	if h.metricsEnabled() {
+		if err := h.initMeter(ctx, res); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -353,12 +420,18 @@ func (h *OTLPHook) handleEvent(ctx context.Context, event plugin.MessageEvent) {
 }
 
 func (h *OTLPHook) handleMessageCreated(ctx context.Context, msg plugin.Message) {
+	// A user message may be a prompt a plugin submitted rather than typed.
+	var origin agentlink.Prompt
+	if msg.Role == plugin.MessageRoleUser {
+		origin, _ = h.link.PromptOrigin(msg.Content)
+	}
+
 	// Get or create session context with proper parent-child relationship.
-	sessionCtx := h.getOrCreateSessionContext(ctx, msg.SessionID)
+	sessionCtx := h.getOrCreateSessionContext(ctx, msg.SessionID, origin)
 
 	switch msg.Role {
 	case plugin.MessageRoleUser:
-		h.createUserMessageSpan(sessionCtx, msg)
+		h.createUserMessageSpan(sessionCtx, msg, origin)
 	case plugin.MessageRoleAssistant:
 		// Don't create span on MessageCreated - wait for MessageUpdated when complete.
 		// Streaming responses arrive via updates, so the initial create has no content.
@@ -372,7 +445,7 @@ func (h *OTLPHook) handleMessageUpdated(ctx context.Context, msg plugin.Message)
 		return
 	}
 
-	sessionCtx := h.getOrCreateSessionContext(ctx, msg.SessionID)
+	sessionCtx := h.getOrCreateSessionContext(ctx, msg.SessionID, agentlink.Prompt{})
 
 	// Handle tool calls.
 	for _, tc := range msg.ToolCalls {
@@ -397,7 +470,8 @@ func (h *OTLPHook) handleMessageDeleted(msg plugin.Message) {
 
 // getOrCreateSessionContext returns the context with the session span as parent.
 // This ensures all child spans (messages, tools) are properly linked to the session.
-func (h *OTLPHook) getOrCreateSessionContext(ctx context.Context, sessionID string) context.Context {
+// A session created for a prompt with an origin is marked as plugin initiated.
+func (h *OTLPHook) getOrCreateSessionContext(ctx context.Context, sessionID string, origin agentlink.Prompt) context.Context {
 	h.sessionContextsMu.RLock()
 	sc, exists := h.sessionContexts[sessionID]
 	h.sessionContextsMu.RUnlock()
@@ -425,9 +499,14 @@ func (h *OTLPHook) getOrCreateSessionContext(ctx context.Context, sessionID stri
 		projectName = "unknown"
 	}
 
+	startReason, initiatedBy := startReasonUser, initiatedByUser
+	if origin.Origin != "" {
+		startReason, initiatedBy = startReasonPlugin, origin.Origin
+	}
 	attrs := []attribute.KeyValue{
 		attribute.String("session.id", sessionID),
-		attribute.String("session.start_reason", "user_initiated"),
+		attribute.String("session.start_reason", startReason),
+		attribute.String("session.initiated_by", initiatedBy),
 		attribute.String("agent.name", "crush"),
 		attribute.String("project.path", projectPath),
 		attribute.String("project.name", projectName),
@@ -469,15 +548,139 @@ func (h *OTLPHook) getOrCreateSessionContext(ctx context.Context, sessionID stri
 	return sessionCtx
 }
 
-func (h *OTLPHook) createUserMessageSpan(ctx context.Context, msg plugin.Message) {
-	_, span := h.tracer.Start(ctx, "crush.message.user",
-		trace.WithAttributes(
-			attribute.String("message.id", msg.ID),
-			attribute.String("message.role", string(msg.Role)),
-			attribute.String("session.id", msg.SessionID),
-			attribute.Int("message.content_length", len(msg.Content)),
-		),
+// recordSubAgentRun exports a finished sub-agent run as a span covering the
+// run's duration. Runs started by a tool call that is still being traced
+// become children of that tool's span.
+func (h *OTLPHook) recordSubAgentRun(run agentmetrics.Run) {
+	ctx := context.Background()
+	if run.ToolCallID != "" {
+		h.toolSpansMu.Lock()
+		if parent, ok := h.toolSpans[run.ToolCallID]; ok {
+			ctx = trace.ContextWithSpan(ctx, parent)
+		}
+		h.toolSpansMu.Unlock()
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("subagent.name", run.Agent),
+		attribute.Int64("subagent.tokens", run.Tokens),
+		attribute.Float64("subagent.cost_usd", run.CostUSD),
+		attribute.Int64("subagent.duration_ms", run.Duration.Milliseconds()),
+		attribute.Bool("subagent.is_error", run.Error != ""),
+	}
+	if run.ToolCallID != "" {
+		attrs = append(attrs, attribute.String("tool.id", run.ToolCallID))
+	}
+	if run.Parent != "" {
+		attrs = append(attrs, attribute.String("subagent.parent", run.Parent))
+	}
+
+	_, span := h.tracer.Start(ctx, "crush.subagent."+run.Agent,
+		trace.WithTimestamp(run.Started),
+		trace.WithAttributes(attrs...),
 	)
+	if run.Error != "" {
+		span.SetAttributes(attribute.String("subagent.error", truncateString(run.Error, h.cfg.ToolResultLimit)))
+		span.SetStatus(codes.Error, truncateString(run.Error, h.cfg.ToolResultLimit))
+	}
+	span.End(trace.WithTimestamp(run.Started.Add(run.Duration)))
+}
+
+// metricsEnabled reports whether metrics recorded by other plugins are
+// exported.
+func (h *OTLPHook) metricsEnabled() bool {
+	return h.cfg.Metrics == nil || *h.cfg.Metrics
+}
+
+// initMeter sets up exporting metrics to the configured endpoint every
+// metrics interval, described by res like the traces.
+func (h *OTLPHook) initMeter(ctx context.Context, res *resource.Resource) error {
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(h.cfg.Endpoint)}
+	if h.cfg.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	if len(h.cfg.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(h.cfg.Headers))
+	}
+
+	exporter, err := otlpmetrichttp.New(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+
+	interval := time.Duration(h.cfg.MetricsIntervalSeconds) * time.Second
+	h.meterProvider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(h.meterProvider)
+	h.meter = h.meterProvider.Meter("crush.agent")
+	return nil
+}
+
+// recordMeasurement adds a measurement another plugin recorded to its
+// instrument, creating the instrument the first time its name is seen.
+func (h *OTLPHook) recordMeasurement(m agentmeter.Measurement) {
+	attrs := make([]attribute.KeyValue, 0, len(m.Attrs))
+	for k, v := range m.Attrs {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	opt := metric.WithAttributes(attrs...)
+	ctx := context.Background()
+
+	h.instrumentsMu.Lock()
+	defer h.instrumentsMu.Unlock()
+
+	switch m.Kind {
+	case agentmeter.Counter:
+		counter, ok := h.counters[m.Name]
+		if !ok {
+			var err error
+			counter, err = h.meter.Int64Counter(m.Name, metric.WithUnit(m.Unit))
+			if err != nil {
+				h.logger.Warn("failed to create counter", "name", m.Name, "error", err)
+				return
+			}
+			h.counters[m.Name] = counter
+		}
+		counter.Add(ctx, int64(m.Value), opt)
+	case agentmeter.Histogram:
+		histogram, ok := h.histograms[m.Name]
+		if !ok {
+			var err error
+			histogram, err = h.meter.Float64Histogram(m.Name, metric.WithUnit(m.Unit))
+			if err != nil {
+				h.logger.Warn("failed to create histogram", "name", m.Name, "error", err)
+				return
+			}
+			h.histograms[m.Name] = histogram
+		}
+		histogram.Record(ctx, m.Value, opt)
+	}
+}
+
+func (h *OTLPHook) createUserMessageSpan(ctx context.Context, msg plugin.Message, origin agentlink.Prompt) {
+	initiatedBy := initiatedByUser
+	if origin.Origin != "" {
+		initiatedBy = origin.Origin
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("message.id", msg.ID),
+		attribute.String("message.role", string(msg.Role)),
+		attribute.String("session.id", msg.SessionID),
+		attribute.Int("message.content_length", len(msg.Content)),
+		attribute.String("message.initiated_by", initiatedBy),
+	}
+	// Prompts submitted by a plugin also say who asked for them.
+	if origin.Origin != "" {
+		if origin.Source != "" {
+			attrs = append(attrs, attribute.String("message.prompt_source", origin.Source))
+		}
+		if origin.TaskID != "" {
+			attrs = append(attrs, attribute.String("task.id", origin.TaskID))
+		}
+	}
+	_, span := h.tracer.Start(ctx, "crush.message.user", trace.WithAttributes(attrs...))
 
 	// Add content as attribute (truncated if too long).
 	content := truncateString(msg.Content, h.cfg.ContentLimit)
@@ -3,117 +3,15 @@
 package otlp_test
 
 import (
-	"io"
-	"net/http"
-	"net/http/httptest"
-	"sync"
 	"testing"
 	"time"
 
 	"github.com/aleksclark/crush-modules/testutil"
 	"github.com/aleksclark/crush-modules/testutil/mockllm"
+	"github.com/aleksclark/crush-modules/testutil/mockotlp"
 	"github.com/stretchr/testify/require"
-	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
-	"google.golang.org/protobuf/proto"
 )
 
-// mockOTLPReceiver is a simple OTLP HTTP receiver that captures traces.
-type mockOTLPReceiver struct {
-	mu     sync.Mutex
-	spans  []spanInfo
-	server *httptest.Server
-}
-
-// spanInfo holds basic span information for verification.
-type spanInfo struct {
-	Name       string
-	Attributes map[string]string
-}
-
-func newMockOTLPReceiver(t *testing.T) *mockOTLPReceiver {
-	t.Helper()
-	r := &mockOTLPReceiver{
-		spans: make([]spanInfo, 0),
-	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/traces", r.handleTraces)
-
-	r.server = httptest.NewServer(mux)
-	t.Cleanup(func() { r.server.Close() })
-
-	return r
-}
-
-func (r *mockOTLPReceiver) handleTraces(w http.ResponseWriter, req *http.Request) {
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
-	// Parse the protobuf request.
-	var traceReq tracepb.ExportTraceServiceRequest
-	if err := proto.Unmarshal(body, &traceReq); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Extract span info.
-	r.mu.Lock()
-	for _, rs := range traceReq.ResourceSpans {
-		for _, ss := range rs.ScopeSpans {
-			for _, span := range ss.Spans {
-				info := spanInfo{
-					Name:       span.Name,
-					Attributes: make(map[string]string),
-				}
-				for _, attr := range span.Attributes {
-					if sv := attr.Value.GetStringValue(); sv != "" {
-						info.Attributes[attr.Key] = sv
-					}
-				}
-				r.spans = append(r.spans, info)
-			}
-		}
-	}
-	r.mu.Unlock()
-
-	// Return success response.
-	resp := &tracepb.ExportTraceServiceResponse{}
-	respBytes, _ := proto.Marshal(resp)
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	w.WriteHeader(http.StatusOK)
-	w.Write(respBytes)
-}
-
-func (r *mockOTLPReceiver) URL() string {
-	return r.server.URL
-}
-
-func (r *mockOTLPReceiver) Spans() []spanInfo {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	result := make([]spanInfo, len(r.spans))
-	copy(result, r.spans)
-	return result
-}
-
-func (r *mockOTLPReceiver) WaitForSpans(t *testing.T, minCount int, timeout time.Duration) []spanInfo {
-	t.Helper()
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		spans := r.Spans()
-		if len(spans) >= minCount {
-			return spans
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	t.Fatalf("Timed out waiting for %d spans, got %d", minCount, len(r.Spans()))
-	return nil
-}
-
 // TestOTLPPluginRegistered verifies the otlp hook is registered in the distro.
 func TestOTLPPluginRegistered(t *testing.T) {
 	if testing.Short() {
@@ -144,7 +42,7 @@ func TestOTLPTracesExported(t *testing.T) {
 	testutil.SkipIfE2EDisabled(t)
 
 	// Start mock OTLP receiver.
-	otlpReceiver := newMockOTLPReceiver(t)
+	otlpReceiver := mockotlp.NewReceiver(t)
 
 	// Start mock LLM server with a simple text response.
 	llmServer := mockllm.NewServer()
@@ -152,19 +50,14 @@ func TestOTLPTracesExported(t *testing.T) {
 	llmURL := llmServer.Start(t)
 
 	// Create config with both mock LLM and OTLP settings.
-	config := map[string]any{
-		"options": map[string]any{
-			"plugins": map[string]any{
-				"otlp": map[string]any{
-					"endpoint": otlpReceiver.URL(),
-					"insecure": true,
-				},
-			},
-		},
-	}
-	tmpDir := mockllm.SetupTestEnvWithConfig(t, llmURL, config)
-
-	// Start crush - the config is already written by SetupTestEnvWithConfig.
+	tmpDir := mockllm.NewConfig(llmURL).
+		Plugin("otlp", map[string]any{
+			"endpoint": otlpReceiver.URL(),
+			"insecure": true,
+		}).
+		Setup(t)
+
+	// Start crush - the config is already written by Setup.
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
 
@@ -192,7 +85,7 @@ func TestOTLPTracesExported(t *testing.T) {
 	require.True(t, spanNames["crush.message.assistant"], "Expected assistant message span")
 
 	// Verify user message span has expected attributes.
-	var userSpan *spanInfo
+	var userSpan *mockotlp.Span
 	for i := range spans {
 		if spans[i].Name == "crush.message.user" {
 			userSpan = &spans[i]
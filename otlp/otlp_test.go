@@ -5,8 +5,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmeter"
+	"github.com/aleksclark/crush-modules/agentmetrics"
 	"github.com/charmbracelet/crush/plugin"
 	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestOTLPHookRegistration(t *testing.T) {
@@ -351,3 +358,167 @@ func TestOTLPHookProcessMessages(t *testing.T) {
 		t.Fatal("hook did not stop in time")
 	}
 }
+
+func TestRecordSubAgentRun(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewOTLPHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	hook.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	started := time.Now().Add(-2 * time.Second)
+	hook.recordSubAgentRun(agentmetrics.Run{
+		Agent:    "reviewer",
+		Started:  started,
+		Duration: 1500 * time.Millisecond,
+		Tokens:   800,
+		Error:    "boom",
+	})
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "crush.subagent.reviewer", spans[0].Name)
+	require.Equal(t, 1500*time.Millisecond, spans[0].EndTime.Sub(spans[0].StartTime))
+
+	attrs := make(map[string]any)
+	for _, kv := range spans[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	require.Equal(t, int64(800), attrs["subagent.tokens"])
+	require.Equal(t, true, attrs["subagent.is_error"])
+	require.Equal(t, "boom", attrs["subagent.error"])
+}
+
+func TestRecordSubAgentRunUnderToolCall(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewOTLPHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	hook.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	hook.createToolCallSpan(context.Background(), plugin.ToolCallInfo{ID: "tc-1", Name: "subagent"}, "session-1")
+	hook.recordSubAgentRun(agentmetrics.Run{
+		Agent:      "coder",
+		Started:    time.Now(),
+		Duration:   time.Second,
+		ToolCallID: "tc-1",
+		Parent:     "planner",
+	})
+	hook.endToolCallSpanByID("tc-1")
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	run, tool := spans[0], spans[1]
+	require.Equal(t, "crush.subagent.coder", run.Name)
+	require.Equal(t, "crush.tool.subagent", tool.Name)
+	require.Equal(t, tool.SpanContext.TraceID(), run.SpanContext.TraceID())
+	require.Equal(t, tool.SpanContext.SpanID(), run.Parent.SpanID())
+
+	attrs := make(map[string]any)
+	for _, kv := range run.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	require.Equal(t, "planner", attrs["subagent.parent"])
+	require.Equal(t, "tc-1", attrs["tool.id"])
+}
+
+func TestPromptAttribution(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewOTLPHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+	hook.link = agentlink.New()
+
+	// A recorder keeps the spans ended by Stop after the provider shuts down.
+	recorder := tracetest.NewSpanRecorder()
+	hook.provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	hook.tracer = hook.provider.Tracer("test")
+
+	prompt := "[Tempotown] Feedback from supervisor on task task-7:\nAdd tests."
+	hook.link.NotePrompt(prompt, agentlink.Prompt{Origin: "tempotown", Source: "supervisor", TaskID: "task-7"})
+
+	ctx := context.Background()
+	hook.handleMessageCreated(ctx, plugin.Message{ID: "msg-1", SessionID: "session-1", Role: plugin.MessageRoleUser, Content: prompt})
+	hook.handleMessageCreated(ctx, plugin.Message{ID: "msg-2", SessionID: "session-2", Role: plugin.MessageRoleUser, Content: "Hello"})
+	require.NoError(t, hook.Stop())
+
+	spans := make(map[string]map[string]any)
+	for _, span := range recorder.Ended() {
+		attrs := make(map[string]any)
+		for _, kv := range span.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		key := span.Name() + "/" + attrs["session.id"].(string)
+		spans[key] = attrs
+	}
+
+	injected := spans["crush.message.user/session-1"]
+	require.Equal(t, "tempotown", injected["message.initiated_by"])
+	require.Equal(t, "supervisor", injected["message.prompt_source"])
+	require.Equal(t, "task-7", injected["task.id"])
+	require.Equal(t, "plugin_initiated", spans["crush.session/session-1"]["session.start_reason"])
+	require.Equal(t, "tempotown", spans["crush.session/session-1"]["session.initiated_by"])
+
+	typed := spans["crush.message.user/session-2"]
+	require.Equal(t, "user", typed["message.initiated_by"])
+	require.NotContains(t, typed, "message.prompt_source")
+	require.Equal(t, "user_initiated", spans["crush.session/session-2"]["session.start_reason"])
+}
+
+func TestRecordMeasurement(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewOTLPHook(plugin.NewApp(), Config{})
+	require.NoError(t, err)
+
+	reader := sdkmetric.NewManualReader()
+	hook.meter = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	meter := agentmeter.New()
+	unsubscribe := meter.Subscribe(hook.recordMeasurement)
+	defer unsubscribe()
+	meter.Add("tempotown.connect.attempts", 1, map[string]string{"outcome": "failure"})
+	meter.Add("tempotown.connect.attempts", 1, map[string]string{"outcome": "failure"})
+	meter.Add("tempotown.connect.attempts", 1, map[string]string{"outcome": "success"})
+	meter.Observe("tempotown.rpc.duration", "ms", 12, map[string]string{"method": "report_status"})
+	meter.Observe("tempotown.rpc.duration", "ms", 30, map[string]string{"method": "report_status"})
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	got := make(map[string]metricdata.Metrics)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		got[m.Name] = m
+	}
+
+	attempts := got["tempotown.connect.attempts"].Data.(metricdata.Sum[int64])
+	require.True(t, attempts.IsMonotonic)
+	counts := make(map[string]int64)
+	for _, dp := range attempts.DataPoints {
+		outcome, _ := dp.Attributes.Value("outcome")
+		counts[outcome.AsString()] = dp.Value
+	}
+	require.Equal(t, map[string]int64{"failure": 2, "success": 1}, counts)
+
+	duration := got["tempotown.rpc.duration"]
+	require.Equal(t, "ms", duration.Unit)
+	points := duration.Data.(metricdata.Histogram[float64]).DataPoints
+	require.Len(t, points, 1)
+	require.Equal(t, uint64(2), points[0].Count)
+	require.Equal(t, float64(42), points[0].Sum)
+}
+
+func TestOTLPHookMetricsDisabled(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+	hook, err := NewOTLPHook(plugin.NewApp(), Config{Metrics: &disabled})
+	require.NoError(t, err)
+	require.False(t, hook.metricsEnabled())
+	require.Equal(t, DefaultMetricsInterval, hook.cfg.MetricsIntervalSeconds)
+}
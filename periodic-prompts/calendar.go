@@ -0,0 +1,88 @@
+package periodicprompts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout for one-off skip dates ("2025-12-25").
+const dateLayout = "2006-01-02"
+
+// annualLayout is the layout for skip dates that recur every year ("12-25").
+const annualLayout = "01-02"
+
+// weekdayNames maps accepted weekday spellings to time.Weekday values.
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// weekdayAliases expand to several weekdays at once.
+var weekdayAliases = map[string][]time.Weekday{
+	"business_days": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends":      {time.Saturday, time.Sunday},
+}
+
+// calendarFilter restricts the days a prompt may fire on, on top of its
+// cron schedule.
+type calendarFilter struct {
+	skipDates  map[string]bool       // "2006-01-02" keys.
+	skipAnnual map[string]bool       // "01-02" keys.
+	weekdays   map[time.Weekday]bool // Empty means every day.
+}
+
+// parseCalendar builds the calendar filter for a prompt.
+func parseCalendar(p PromptConfig) (calendarFilter, error) {
+	f := calendarFilter{
+		skipDates:  make(map[string]bool),
+		skipAnnual: make(map[string]bool),
+		weekdays:   make(map[time.Weekday]bool),
+	}
+
+	for _, d := range p.SkipDates {
+		d = strings.TrimSpace(d)
+		if _, err := time.Parse(dateLayout, d); err == nil {
+			f.skipDates[d] = true
+			continue
+		}
+		if _, err := time.Parse(annualLayout, d); err == nil {
+			f.skipAnnual[d] = true
+			continue
+		}
+		return calendarFilter{}, fmt.Errorf("invalid skip date %q (want YYYY-MM-DD or MM-DD)", d)
+	}
+
+	for _, name := range p.Weekdays {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if days, ok := weekdayAliases[name]; ok {
+			for _, day := range days {
+				f.weekdays[day] = true
+			}
+			continue
+		}
+		day, ok := weekdayNames[name]
+		if !ok {
+			return calendarFilter{}, fmt.Errorf("invalid weekday %q", name)
+		}
+		f.weekdays[day] = true
+	}
+
+	return f, nil
+}
+
+// allows reports whether the prompt may fire at t.
+func (f calendarFilter) allows(t time.Time) bool {
+	if f.skipDates[t.Format(dateLayout)] || f.skipAnnual[t.Format(annualLayout)] {
+		return false
+	}
+	if len(f.weekdays) > 0 && !f.weekdays[t.Weekday()] {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package periodicprompts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// responseCapture records the final assistant response of a scheduled run.
+// Responses are only taken once the prompt shows up as a user message, so a
+// prompt skipped by a busy session never picks up the reply of another run.
+type responseCapture struct {
+	mu        sync.Mutex
+	prompt    string
+	pinned    string // Session the prompt is submitted to, if any.
+	sessionID string // Locked to the run's session once known.
+	content   string
+}
+
+// observe updates the capture from a message event.
+func (c *responseCapture) observe(event plugin.MessageEvent) {
+	msg := event.Message
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Lock onto the session in which our prompt shows up as a user message.
+	if c.sessionID == "" {
+		if msg.Role == plugin.MessageRoleUser && strings.TrimSpace(msg.Content) == c.prompt &&
+			(c.pinned == "" || msg.SessionID == c.pinned) {
+			c.sessionID = msg.SessionID
+		}
+		return
+	}
+	if msg.SessionID != c.sessionID || msg.Role != plugin.MessageRoleAssistant {
+		return
+	}
+	if msg.Content != "" {
+		c.content = msg.Content
+	}
+}
+
+// ran reports whether the prompt was seen being run.
+func (c *responseCapture) ran() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID != ""
+}
+
+// result returns the last assistant response seen.
+func (c *responseCapture) result() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.content
+}
+
+// startCapture subscribes to message events for the duration of a run.
+// The returned stop function must be called once the prompt has completed.
+func (h *Hook) startCapture(ctx context.Context, p PromptConfig, prompt string) (*responseCapture, func()) {
+	c := &responseCapture{prompt: prompt, pinned: p.SessionID}
+
+	if h.app == nil || h.app.Messages() == nil {
+		return c, func() {}
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	events := h.app.Messages().SubscribeMessages(watchCtx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for event := range events {
+			c.observe(event)
+		}
+	}()
+
+	return c, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// writeOutput writes a captured response to the prompt's output file.
+func (h *Hook) writeOutput(p PromptConfig, content string, now time.Time) error {
+	path := expandPath(expandOutputTemplate(p.OutputFile, promptName(p), now), h.workingDir())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write output file: %w", err)
+	}
+	h.logger().Info("periodic-prompts: wrote prompt output",
+		"file", p.File,
+		"output_file", path,
+	)
+	return nil
+}
+
+// workingDir returns the app working directory, if any.
+func (h *Hook) workingDir() string {
+	if h.app != nil {
+		return h.app.WorkingDir()
+	}
+	return ""
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// expandOutputTemplate substitutes placeholders in an output path:
+// {{date}} (2006-01-02), {{time}} (150405), {{datetime}}
+// (2006-01-02T150405) and {{name}} (the prompt name, made filename-safe).
+func expandOutputTemplate(tmpl, name string, now time.Time) string {
+	safeName := strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-")
+	return strings.NewReplacer(
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("150405"),
+		"{{datetime}}", now.Format("2006-01-02T150405"),
+		"{{name}}", safeName,
+	).Replace(tmpl)
+}
@@ -0,0 +1,122 @@
+package periodicprompts
+
+import "context"
+
+// Overflow policies applied when max_concurrent prompts are already running.
+const (
+	// OverflowSkip drops a firing when no slot is free.
+	OverflowSkip = "skip"
+	// OverflowQueue waits for a free slot. A prompt is queued at most once;
+	// further firings of the same prompt are skipped while it waits.
+	OverflowQueue = "queue"
+)
+
+// limiter bounds the number of scheduled prompts in flight at once.
+// A nil limiter imposes no limit.
+type limiter struct {
+	slots  chan struct{}
+	policy string
+}
+
+// newLimiter returns a limiter for the given settings, or nil when
+// maxConcurrent is not positive.
+func newLimiter(maxConcurrent int, policy string) *limiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if policy != OverflowQueue {
+		policy = OverflowSkip
+	}
+	return &limiter{
+		slots:  make(chan struct{}, maxConcurrent),
+		policy: policy,
+	}
+}
+
+// tryAcquire takes a slot without blocking.
+func (l *limiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (l *limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by tryAcquire or acquire.
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// runScheduled executes a prompt once a concurrency slot is available,
+// applying the configured overflow policy when none is.
+func (h *Hook) runScheduled(ctx context.Context, idx int, p PromptConfig) {
+	if !h.limiter.tryAcquire() {
+		if h.limiter.policy == OverflowSkip || !h.markQueued(idx) {
+			h.logger().Info("periodic-prompts: concurrency limit reached, skipping",
+				"file", p.File,
+				"max_concurrent", h.cfg.MaxConcurrent,
+			)
+			return
+		}
+
+		h.logger().Info("periodic-prompts: concurrency limit reached, queueing",
+			"file", p.File,
+			"max_concurrent", h.cfg.MaxConcurrent,
+		)
+		acquired := h.limiter.acquire(ctx)
+		h.unmarkQueued(idx)
+		if !acquired {
+			return
+		}
+	}
+	defer h.limiter.release()
+
+	// A supervisor may have paused the agent while the prompt was queued.
+	if h.control != nil && h.control.Paused() {
+		h.logger().Info("periodic-prompts: agent paused, skipping",
+			"file", p.File,
+		)
+		return
+	}
+
+	h.executePrompt(idx, p)
+}
+
+// markQueued records that a prompt is waiting for a slot. It returns false
+// if the prompt was already queued.
+func (h *Hook) markQueued(idx int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.queued[idx] {
+		return false
+	}
+	h.queued[idx] = true
+	return true
+}
+
+// unmarkQueued clears a prompt's queued flag.
+func (h *Hook) unmarkQueued(idx int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.queued, idx)
+}
@@ -0,0 +1,187 @@
+package periodicprompts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aleksclark/crush-modules/frontmatter"
+)
+
+// promptFrontmatter is the YAML header of a self-describing prompt file.
+// It uses the same Markdown+frontmatter layout as sub-agent definitions.
+type promptFrontmatter struct {
+	Name       string   `yaml:"name"`
+	Schedule   string   `yaml:"schedule"`
+	Enabled    *bool    `yaml:"enabled"`
+	Condition  string   `yaml:"condition"`
+	SessionID  string   `yaml:"session_id"`
+	SkipDates  []string `yaml:"skip_dates"`
+	Weekdays   []string `yaml:"weekdays"`
+	OutputFile string   `yaml:"output_file"`
+}
+
+// LoadPromptFile parses a prompt file. Files with YAML frontmatter populate
+// the returned PromptConfig; plain files yield a config with only File set.
+// The returned body is the prompt text with any frontmatter removed.
+func LoadPromptFile(path string) (PromptConfig, string, error) {
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return PromptConfig{}, "", err
+	}
+
+	p := PromptConfig{File: path}
+	if !frontmatter.HasFrontmatter(data) {
+		return p, strings.TrimSpace(string(data)), nil
+	}
+
+	var fm promptFrontmatter
+	body, err := frontmatter.Parse(data, &fm)
+	if err != nil {
+		return PromptConfig{}, "", err
+	}
+
+	p.Name = fm.Name
+	p.Schedule = fm.Schedule
+	p.Enabled = fm.Enabled
+	p.Condition = fm.Condition
+	p.SessionID = fm.SessionID
+	p.SkipDates = fm.SkipDates
+	p.Weekdays = fm.Weekdays
+	p.OutputFile = fm.OutputFile
+	return p, body, nil
+}
+
+// loadPrompt reads the configured prompt file file. A relative path is
+// resolved against workingDir, so validation, scheduling and firing all read
+// the same file whatever the process's current directory.
+func loadPrompt(file, workingDir string) (PromptConfig, string, error) {
+	return LoadPromptFile(expandPath(file, workingDir))
+}
+
+// mergePrompt fills empty fields of p with values from the prompt file's
+// frontmatter. Values set in crush.json always win.
+func mergePrompt(p, fromFile PromptConfig) PromptConfig {
+	if p.Name == "" {
+		p.Name = fromFile.Name
+	}
+	if p.Schedule == "" {
+		p.Schedule = fromFile.Schedule
+	}
+	if p.Enabled == nil {
+		p.Enabled = fromFile.Enabled
+	}
+	if p.Condition == "" {
+		p.Condition = fromFile.Condition
+	}
+	if p.SessionID == "" {
+		p.SessionID = fromFile.SessionID
+	}
+	if len(p.SkipDates) == 0 {
+		p.SkipDates = fromFile.SkipDates
+	}
+	if len(p.Weekdays) == 0 {
+		p.Weekdays = fromFile.Weekdays
+	}
+	if p.OutputFile == "" {
+		p.OutputFile = fromFile.OutputFile
+	}
+	return p
+}
+
+// resolvePrompts merges configured prompts with their file frontmatter and
+// appends self-describing prompts discovered in dirs. Files that cannot be
+// read yet are kept as configured; they are read again when they fire.
+// Unless allowConditions is set, conditions in prompt files are dropped, as
+// anyone who can write to dirs could otherwise run shell commands; the
+// files they were dropped from are returned as ignored.
+func resolvePrompts(configured []PromptConfig, dirs []string, workingDir string, allowConditions bool) (prompts, ignored []PromptConfig) {
+	prompts = make([]PromptConfig, 0, len(configured))
+	seen := make(map[string]bool)
+
+	fileCondition := func(p PromptConfig) PromptConfig {
+		if p.Condition != "" && !allowConditions {
+			ignored = append(ignored, p)
+			p.Condition = ""
+		}
+		return p
+	}
+
+	for _, p := range configured {
+		if fromFile, _, err := loadPrompt(p.File, workingDir); err == nil {
+			if p.Condition == "" {
+				fromFile = fileCondition(fromFile)
+			}
+			p = mergePrompt(p, fromFile)
+		}
+		seen[expandPath(p.File, workingDir)] = true
+		prompts = append(prompts, p)
+	}
+
+	for _, path := range discoverPromptFiles(dirs, workingDir) {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		p, _, err := LoadPromptFile(path)
+		if err != nil || p.Schedule == "" {
+			// Only files that declare their own schedule are picked up.
+			continue
+		}
+		prompts = append(prompts, fileCondition(p))
+	}
+
+	return prompts, ignored
+}
+
+// discoverPromptFiles finds all .md files in the given directories.
+func discoverPromptFiles(dirs []string, workingDir string) []string {
+	var files []string
+	for _, dir := range dirs {
+		expanded := expandPath(dir, workingDir)
+		entries, err := os.ReadDir(expanded)
+		if err != nil {
+			continue // Skip non-existent directories.
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".md") {
+				continue
+			}
+			files = append(files, filepath.Join(expanded, entry.Name()))
+		}
+	}
+	return files
+}
+
+// expandHome expands a leading ~/ to the user's home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// expandPath expands ~ and resolves relative paths against workingDir.
+func expandPath(path, workingDir string) string {
+	path = expandHome(path)
+	if !filepath.IsAbs(path) && workingDir != "" {
+		path = filepath.Join(workingDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// promptName returns the display name for a prompt.
+func promptName(p PromptConfig) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return filepath.Base(p.File)
+}
+
+// isPromptEnabled reports whether a prompt should be scheduled.
+func isPromptEnabled(p PromptConfig) bool {
+	return p.Enabled == nil || *p.Enabled
+}
@@ -5,13 +5,13 @@ package periodicprompts
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"strings"
+	"os/exec"
 	"sync"
+	"time"
 
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/clock"
 	"github.com/charmbracelet/crush/plugin"
 	"github.com/robfig/cron/v3"
 )
@@ -23,6 +23,9 @@ const (
 	// ToolName is the name of the toggle tool.
 	ToolName = "periodic_prompts"
 
+	// ConditionTimeout bounds how long a prompt's condition command may run.
+	ConditionTimeout = 30 * time.Second
+
 	// Description is shown to the LLM.
 	Description = `Controls periodic prompts that run on a cron schedule.
 
@@ -31,6 +34,8 @@ const (
 - Use action "enable" to turn on periodic prompting
 - Use action "disable" to turn off periodic prompting
 - Use action "list" to see all configured periodic prompts
+- Use action "validate" to dry-run every schedule: checks prompt files and
+  calendar filters and shows the next 5 fire times without sending anything
 </usage>
 
 <examples>
@@ -38,6 +43,7 @@ periodic_prompts(action: "status") -> Shows current state
 periodic_prompts(action: "enable") -> Enables periodic prompting
 periodic_prompts(action: "disable") -> Disables periodic prompting
 periodic_prompts(action: "list") -> Lists configured prompts and schedules
+periodic_prompts(action: "validate") -> Validates prompts and previews fire times
 </examples>
 `
 )
@@ -51,6 +57,18 @@ type Config struct {
 	// When true, the scheduler starts enabled without requiring a manual call to
 	// the periodic_prompts tool. Defaults to false.
 	Enabled bool `json:"enabled,omitempty"`
+	// MaxConcurrent caps how many scheduled prompts may be in flight at once.
+	// Zero means no limit.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// OverflowPolicy decides what happens to a firing when MaxConcurrent
+	// prompts are already running: "skip" (default) or "queue".
+	OverflowPolicy string `json:"overflow_policy,omitempty"`
+	// Dirs lists directories scanned for self-describing prompt files.
+	// Every .md file whose frontmatter declares a schedule is added to Prompts.
+	Dirs []string `json:"dirs,omitempty"`
+	// AllowFileConditions runs conditions set in prompt file frontmatter.
+	// By default only conditions set in crush.json are run.
+	AllowFileConditions bool `json:"allow_file_conditions,omitempty"`
 }
 
 // PromptConfig defines a single scheduled prompt.
@@ -65,24 +83,54 @@ type PromptConfig struct {
 	// to the same conversation history rather than opening a new session.
 	// When empty a fresh session is created for each firing.
 	SessionID string `json:"session_id,omitempty"`
+	// Enabled disables an individual prompt when set to false.
+	// Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Condition is a shell command run in the working directory before each
+	// firing. The prompt is skipped unless the command exits with status 0.
+	// A condition in a prompt file's frontmatter needs AllowFileConditions.
+	Condition string `json:"condition,omitempty"`
+	// SkipDates lists dates on which the prompt never fires. Use YYYY-MM-DD
+	// for one-off dates or MM-DD for dates that recur every year.
+	SkipDates []string `json:"skip_dates,omitempty"`
+	// Weekdays restricts firing to the listed days ("mon", "tuesday", ...).
+	// The aliases "business_days" and "weekends" expand to Mon-Fri and
+	// Sat-Sun. When empty the prompt may fire on any day.
+	Weekdays []string `json:"weekdays,omitempty"`
+	// OutputFile, when set, receives the assistant's final response for each
+	// run (supports ~ and the placeholders {{date}}, {{time}}, {{datetime}}
+	// and {{name}}). Relative paths resolve against the working directory.
+	OutputFile string `json:"output_file,omitempty"`
 }
 
 // ToolParams defines the parameters the LLM can pass to the toggle tool.
 type ToolParams struct {
-	// Action is the operation to perform: "status", "enable", "disable", "list".
-	Action string `json:"action" jsonschema:"description=Action to perform: status, enable, disable, or list"`
+	// Action is the operation to perform: "status", "enable", "disable", "list", "validate".
+	Action string `json:"action" jsonschema:"description=Action to perform: status, enable, disable, list, or validate"`
 }
 
 // Hook implements the periodic prompts hook.
 type Hook struct {
 	app     *plugin.App
 	cfg     Config
-	cron    *cron.Cron
 	enabled bool
 	mu      sync.RWMutex
 
+	// clock drives the schedules; tests replace it with a fake clock.
+	clock clock.Clock
+	// cancel stops the schedules started by Start.
+	cancel context.CancelFunc
+
+	// limiter enforces MaxConcurrent; queued tracks prompts waiting on it.
+	limiter *limiter
+	queued  map[int]bool
+
 	// promptSubmitter allows sending prompts to the agent.
 	promptSubmitter plugin.PromptSubmitter
+
+	// control pauses scheduled prompts while another plugin, such as
+	// tempotown, has paused the agent.
+	control *agentcontrol.Switch
 }
 
 func init() {
@@ -110,11 +158,29 @@ var (
 )
 
 // NewHook creates a new periodic prompts hook.
+// Prompt files with YAML frontmatter fill in any fields left empty in cfg.
 func NewHook(app *plugin.App, cfg Config) (*Hook, error) {
+	var workingDir string
+	if app != nil {
+		workingDir = app.WorkingDir()
+	}
+	var ignored []PromptConfig
+	cfg.Prompts, ignored = resolvePrompts(cfg.Prompts, cfg.Dirs, workingDir, cfg.AllowFileConditions)
+
 	h := &Hook{
 		app:     app,
 		cfg:     cfg,
 		enabled: cfg.Enabled,
+		limiter: newLimiter(cfg.MaxConcurrent, cfg.OverflowPolicy),
+		queued:  make(map[int]bool),
+		control: agentcontrol.Shared(),
+		clock:   clock.Real,
+	}
+	for _, p := range ignored {
+		h.logger().Warn("periodic-prompts: ignoring condition from prompt file; set allow_file_conditions to run it",
+			"file", p.File,
+			"condition", p.Condition,
+		)
 	}
 
 	// Store the singleton for tool access.
@@ -138,7 +204,7 @@ func (h *Hook) logger() *slog.Logger {
 	return slog.Default()
 }
 
-// Start begins the cron scheduler.
+// Start schedules the configured prompts and runs until ctx is done.
 func (h *Hook) Start(ctx context.Context) error {
 	// Get the prompt submitter from the app (if available).
 	if h.app != nil {
@@ -148,29 +214,33 @@ func (h *Hook) Start(ctx context.Context) error {
 		}
 	}
 
-	// Create cron scheduler with second precision.
-	h.cron = cron.New(cron.WithParser(cron.NewParser(
-		cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow,
-	)))
+	scheduleCtx, cancel := context.WithCancel(ctx)
+	h.mu.Lock()
+	h.cancel = cancel
+	h.mu.Unlock()
 
 	// Schedule all configured prompts.
 	for i, p := range h.cfg.Prompts {
 		prompt := p // Capture for closure.
 		idx := i
 
-		_, err := h.cron.AddFunc(prompt.Schedule, func() {
-			h.mu.RLock()
-			enabled := h.enabled
-			h.mu.RUnlock()
+		if !isPromptEnabled(prompt) {
+			h.logger().Info("periodic-prompts: prompt disabled, not scheduling",
+				"file", prompt.File,
+			)
+			continue
+		}
 
-			if !enabled {
-				return
-			}
+		calendar, err := parseCalendar(prompt)
+		if err != nil {
+			h.logger().Error("periodic-prompts: invalid calendar filter",
+				"file", prompt.File,
+				"error", err,
+			)
+			continue
+		}
 
-			// Run in a goroutine so the cron scheduler is never blocked by a
-			// long-running agent response.
-			go h.executePrompt(idx, prompt)
-		})
+		schedule, err := scheduleParser.Parse(prompt.Schedule)
 		if err != nil {
 			h.logger().Error("periodic-prompts: invalid schedule",
 				"file", prompt.File,
@@ -180,27 +250,87 @@ func (h *Hook) Start(ctx context.Context) error {
 			continue
 		}
 
+		go h.runSchedule(scheduleCtx, schedule, func(at time.Time) {
+			h.mu.RLock()
+			enabled := h.enabled
+			h.mu.RUnlock()
+
+			if !enabled {
+				return
+			}
+
+			if !calendar.allows(at) {
+				h.logger().Debug("periodic-prompts: skipping excluded date",
+					"file", prompt.File,
+				)
+				return
+			}
+
+			// Run in a goroutine so the schedule is never blocked by a
+			// long-running agent response.
+			go h.runScheduled(ctx, idx, prompt)
+		})
+
 		h.logger().Info("periodic-prompts: scheduled prompt",
 			"file", prompt.File,
 			"schedule", prompt.Schedule,
 		)
 	}
 
-	h.cron.Start()
-
 	// Wait for context cancellation.
 	<-ctx.Done()
 	return h.Stop()
 }
 
-// Stop halts the cron scheduler.
+// Stop halts the schedules.
 func (h *Hook) Stop() error {
-	if h.cron != nil {
-		h.cron.Stop()
+	h.mu.Lock()
+	cancel := h.cancel
+	h.mu.Unlock()
+	if cancel != nil {
+		cancel()
 	}
 	return nil
 }
 
+// runSchedule calls fire with the scheduled time whenever schedule comes
+// due, until ctx is done. Runs missed while the process was suspended are
+// not caught up.
+func (h *Hook) runSchedule(ctx context.Context, schedule cron.Schedule, fire func(at time.Time)) {
+	clk := h.getClock()
+	from := clk.Now()
+	for {
+		next := schedule.Next(from)
+		if next.IsZero() {
+			// The schedule never matches, e.g. "0 0 30 2 *".
+			return
+		}
+		timer := clk.NewTimer(next.Sub(clk.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+			fire(next)
+		}
+		// The timer may fire slightly early by the wall clock; start from the
+		// scheduled time so the same run is not fired twice.
+		from = clk.Now()
+		if from.Before(next) {
+			from = next
+		}
+	}
+}
+
+// getClock returns the hook's clock, which is unset in hooks built without
+// NewHook.
+func (h *Hook) getClock() clock.Clock {
+	if h.clock == nil {
+		return clock.Real
+	}
+	return h.clock
+}
+
 // executePrompt reads and submits a prompt file.
 func (h *Hook) executePrompt(idx int, p PromptConfig) {
 	if h.promptSubmitter == nil {
@@ -219,9 +349,15 @@ func (h *Hook) executePrompt(idx int, p PromptConfig) {
 		return
 	}
 
-	name := p.Name
-	if name == "" {
-		name = filepath.Base(p.File)
+	name := promptName(p)
+	ctx := context.Background()
+
+	if p.Condition != "" && !h.conditionMet(ctx, p.Condition) {
+		h.logger().Info("periodic-prompts: condition not met, skipping",
+			"name", name,
+			"condition", p.Condition,
+		)
+		return
 	}
 
 	h.logger().Info("periodic-prompts: executing scheduled prompt",
@@ -229,47 +365,89 @@ func (h *Hook) executePrompt(idx int, p PromptConfig) {
 		"file", p.File,
 	)
 
-	ctx := context.Background()
+	if p.OutputFile == "" {
+		h.submit(ctx, p, content)
+		return
+	}
 
+	capture, stop := h.startCapture(ctx, p, content)
+	err = h.submit(ctx, p, content)
+	stop()
+	if err != nil {
+		return
+	}
+
+	if !capture.ran() {
+		// A pinned session that is busy skips the prompt without an error.
+		h.logger().Warn("periodic-prompts: prompt did not run, no output written",
+			"file", p.File,
+			"session_id", p.SessionID,
+		)
+		return
+	}
+	result := capture.result()
+	if result == "" {
+		h.logger().Warn("periodic-prompts: no response captured for output file",
+			"file", p.File,
+			"output_file", p.OutputFile,
+		)
+		return
+	}
+	if err := h.writeOutput(p, result, h.getClock().Now()); err != nil {
+		h.logger().Error("periodic-prompts: failed to write prompt output",
+			"file", p.File,
+			"output_file", p.OutputFile,
+			"error", err,
+		)
+	}
+}
+
+// submit sends prompt content to the agent and blocks until the run ends.
+func (h *Hook) submit(ctx context.Context, p PromptConfig, content string) error {
 	if p.SessionID != "" {
 		// Submit to the pinned session so the agent retains conversation history.
 		// SubmitPromptToSession skips silently if the session is busy.
-		if err := h.promptSubmitter.SubmitPromptToSession(ctx, p.SessionID, content); err != nil {
+		err := h.promptSubmitter.SubmitPromptToSession(ctx, p.SessionID, content)
+		if err != nil {
 			h.logger().Error("periodic-prompts: failed to submit prompt to session",
 				"file", p.File,
 				"session_id", p.SessionID,
 				"error", err,
 			)
 		}
-		return
+		return err
 	}
 
 	// No session ID: submit to a fresh session.
-	if err := h.promptSubmitter.SubmitPrompt(ctx, content); err != nil {
+	err := h.promptSubmitter.SubmitPrompt(ctx, content)
+	if err != nil {
 		h.logger().Error("periodic-prompts: failed to submit prompt",
 			"file", p.File,
 			"error", err,
 		)
 	}
+	return err
 }
 
-// readPromptFile reads and returns the content of a prompt file.
+// readPromptFile reads and returns the content of a prompt file, resolving a
+// relative path against the app working directory. Any YAML frontmatter is
+// stripped so only the prompt body is sent.
 func (h *Hook) readPromptFile(path string) (string, error) {
-	// Expand ~ to home directory.
-	if strings.HasPrefix(path, "~/") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", fmt.Errorf("cannot expand ~: %w", err)
-		}
-		path = filepath.Join(home, path[2:])
-	}
+	_, body, err := loadPrompt(path, h.workingDir())
+	return body, err
+}
 
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
+// conditionMet runs a prompt's condition command and reports whether it
+// exited successfully.
+func (h *Hook) conditionMet(ctx context.Context, condition string) bool {
+	ctx, cancel := context.WithTimeout(ctx, ConditionTimeout)
+	defer cancel()
 
-	return strings.TrimSpace(string(content)), nil
+	cmd := exec.CommandContext(ctx, "sh", "-c", condition)
+	if h.app != nil {
+		cmd.Dir = h.app.WorkingDir()
+	}
+	return cmd.Run() == nil
 }
 
 // SetEnabled enables or disables periodic prompting.
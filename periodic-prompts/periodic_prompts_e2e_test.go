@@ -3,7 +3,6 @@
 package periodicprompts_test
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -57,6 +56,7 @@ func TestPeriodicPromptsToolStatus(t *testing.T) {
 		"Expected status response containing 'disabled'")
 
 	mockllm.AssertToolWasCalled(t, server, "periodic_prompts")
+	mockllm.AssertHeader(t, server, "Authorization", "Bearer "+mockllm.MockAPIKey)
 }
 
 // TestPeriodicPromptsToolEnable verifies the LLM can enable periodic prompting.
@@ -129,25 +129,17 @@ func TestPeriodicPromptsToolList(t *testing.T) {
 	server.OnToolResult("periodic_prompts", mockllm.TextResponse("You have 1 configured prompt: Daily Checks."))
 	url := server.Start(t)
 
-	config := map[string]any{
-		"options": map[string]any{
-			"plugins": map[string]any{
-				"periodic-prompts": map[string]any{
-					"prompts": []map[string]any{
-						{
-							"file":     promptFile,
-							"schedule": "0 9 * * *",
-							"name":     "Daily Checks",
-						},
-					},
+	mockllm.NewConfig(url).
+		Plugin("periodic-prompts", map[string]any{
+			"prompts": []map[string]any{
+				{
+					"file":     promptFile,
+					"schedule": "0 9 * * *",
+					"name":     "Daily Checks",
 				},
 			},
-		},
-	}
-
-	configJSON, err := buildConfig(url, config)
-	require.NoError(t, err)
-	writeCrushConfig(t, tmpDir, configJSON)
+		}).
+		Write(t, tmpDir)
 
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
@@ -181,25 +173,17 @@ func TestPeriodicPromptsEnableArmsScheduledPrompts(t *testing.T) {
 	server.OnToolResult("periodic_prompts", mockllm.TextResponse("Periodic prompting enabled. 1 prompt(s) scheduled."))
 	url := server.Start(t)
 
-	config := map[string]any{
-		"options": map[string]any{
-			"plugins": map[string]any{
-				"periodic-prompts": map[string]any{
-					"prompts": []map[string]any{
-						{
-							"file":     promptFile,
-							"schedule": "* * * * *",
-							"name":     "Test Runner",
-						},
-					},
+	mockllm.NewConfig(url).
+		Plugin("periodic-prompts", map[string]any{
+			"prompts": []map[string]any{
+				{
+					"file":     promptFile,
+					"schedule": "* * * * *",
+					"name":     "Test Runner",
 				},
 			},
-		},
-	}
-
-	configJSON, err := buildConfig(url, config)
-	require.NoError(t, err)
-	writeCrushConfig(t, tmpDir, configJSON)
+		}).
+		Write(t, tmpDir)
 
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
@@ -238,23 +222,15 @@ func TestPeriodicPromptsMultiplePromptsConfigured(t *testing.T) {
 	server.OnToolResult("periodic_prompts", mockllm.TextResponse("You have 3 prompts configured: Test Runner, Linter, Status Check."))
 	url := server.Start(t)
 
-	config := map[string]any{
-		"options": map[string]any{
-			"plugins": map[string]any{
-				"periodic-prompts": map[string]any{
-					"prompts": []map[string]any{
-						{"file": prompt1, "schedule": "*/30 * * * *", "name": "Test Runner"},
-						{"file": prompt2, "schedule": "0 * * * *", "name": "Linter"},
-						{"file": prompt3, "schedule": "*/15 * * * *", "name": "Status Check"},
-					},
-				},
+	mockllm.NewConfig(url).
+		Plugin("periodic-prompts", map[string]any{
+			"prompts": []map[string]any{
+				{"file": prompt1, "schedule": "*/30 * * * *", "name": "Test Runner"},
+				{"file": prompt2, "schedule": "0 * * * *", "name": "Linter"},
+				{"file": prompt3, "schedule": "*/15 * * * *", "name": "Status Check"},
 			},
-		},
-	}
-
-	configJSON, err := buildConfig(url, config)
-	require.NoError(t, err)
-	writeCrushConfig(t, tmpDir, configJSON)
+		}).
+		Write(t, tmpDir)
 
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
@@ -286,25 +262,17 @@ func TestPeriodicPromptsInvalidScheduleDoesNotCrash(t *testing.T) {
 	server.Default(mockllm.TextResponse("I'm alive and well."))
 	url := server.Start(t)
 
-	config := map[string]any{
-		"options": map[string]any{
-			"plugins": map[string]any{
-				"periodic-prompts": map[string]any{
-					"prompts": []map[string]any{
-						{
-							"file":     promptFile,
-							"schedule": "not a valid cron expression",
-							"name":     "Bad Schedule",
-						},
-					},
+	mockllm.NewConfig(url).
+		Plugin("periodic-prompts", map[string]any{
+			"prompts": []map[string]any{
+				{
+					"file":     promptFile,
+					"schedule": "not a valid cron expression",
+					"name":     "Bad Schedule",
 				},
 			},
-		},
-	}
-
-	configJSON, err := buildConfig(url, config)
-	require.NoError(t, err)
-	writeCrushConfig(t, tmpDir, configJSON)
+		}).
+		Write(t, tmpDir)
 
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
@@ -355,12 +323,9 @@ func TestPeriodicPromptsDisabledPlugin(t *testing.T) {
 	server.Default(mockllm.TextResponse("Hello! I'm ready to help."))
 	url := server.Start(t)
 
-	config := map[string]any{
-		"options": map[string]any{
-			"disabled_plugins": []string{"periodic-prompts", "periodic_prompts"},
-		},
-	}
-	tmpDir := mockllm.SetupTestEnvWithConfig(t, url, config)
+	tmpDir := mockllm.NewConfig(url).
+		DisablePlugins("periodic-prompts", "periodic_prompts").
+		Setup(t)
 
 	term := testutil.NewIsolatedTerminalWithConfigAndEnv(t, 100, 30, "", tmpDir)
 	defer term.Close()
@@ -372,6 +337,7 @@ func TestPeriodicPromptsDisabledPlugin(t *testing.T) {
 		"Crush should respond normally without periodic-prompts")
 
 	mockllm.AssertToolWasNotCalled(t, server, "periodic_prompts")
+	mockllm.AssertToolNotOffered(t, server, "periodic_prompts")
 }
 
 // TestPeriodicPromptsEnableDisableCycle drives a full enable→status→disable→status cycle
@@ -408,48 +374,3 @@ func TestPeriodicPromptsEnableDisableCycle(t *testing.T) {
 
 	mockllm.AssertToolWasCalled(t, server, "periodic_prompts")
 }
-
-// ── helpers ──────────────────────────────────────────────────────────────────
-
-// buildConfig merges mock LLM provider settings with the provided plugin config
-// and returns JSON suitable for crush.json.
-func buildConfig(serverURL string, extra map[string]any) (string, error) {
-	base := map[string]any{
-		"providers": map[string]any{
-			"mock": map[string]any{
-				"type":     "openai-compat",
-				"base_url": serverURL,
-				"api_key":  "mock-key",
-				"models": []map[string]any{
-					{
-						"id":                   "mock-model",
-						"name":                 "Mock Model",
-						"context_window":       128000,
-						"default_max_tokens":   4096,
-						"can_reason":           false,
-						"supports_attachments": false,
-					},
-				},
-			},
-		},
-		"models": map[string]any{
-			"large": map[string]any{"provider": "mock", "model": "mock-model"},
-			"small": map[string]any{"provider": "mock", "model": "mock-model"},
-		},
-	}
-	for k, v := range extra {
-		base[k] = v
-	}
-	b, err := json.MarshalIndent(base, "", "  ")
-	return string(b), err
-}
-
-// writeCrushConfig writes config JSON to both config and data dirs inside tmpDir.
-func writeCrushConfig(t *testing.T, tmpDir, configJSON string) {
-	t.Helper()
-	for _, sub := range []string{"config/crush", "data/crush"} {
-		dir := filepath.Join(tmpDir, sub)
-		require.NoError(t, os.MkdirAll(dir, 0o755))
-		require.NoError(t, os.WriteFile(filepath.Join(dir, "crush.json"), []byte(configJSON), 0o644))
-	}
-}
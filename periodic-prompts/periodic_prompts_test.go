@@ -2,12 +2,17 @@ package periodicprompts
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"charm.land/fantasy"
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/testutil"
+	"github.com/aleksclark/crush-modules/testutil/fakeclock"
 	"github.com/charmbracelet/crush/plugin"
 	"github.com/stretchr/testify/require"
 )
@@ -76,6 +81,30 @@ func TestReadPromptFileTilde(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestRelativePromptFile(t *testing.T) {
+	t.Parallel()
+
+	// The working dir is not the test's CWD, so a relative file only
+	// resolves if every reader joins it with the working dir.
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "prompts"), 0o755))
+	content := "---\nschedule: \"0 9 * * *\"\n---\nCheck the build."
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "prompts", "build.md"), []byte(content), 0o644))
+	configured := []PromptConfig{{File: "prompts/build.md"}}
+
+	prompts, _ := resolvePrompts(configured, nil, tmpDir, false)
+	require.Len(t, prompts, 1)
+	require.Equal(t, "0 9 * * *", prompts[0].Schedule, "frontmatter is merged")
+
+	results := ValidatePrompts(prompts, tmpDir, time.Now(), 1)
+	require.True(t, results[0].Valid(), results[0].Errors)
+
+	hook := &Hook{app: plugin.NewApp(plugin.WithWorkingDir(tmpDir))}
+	body, err := hook.readPromptFile(prompts[0].File)
+	require.NoError(t, err)
+	require.Equal(t, "Check the build.", body)
+}
+
 func TestGetPrompts(t *testing.T) {
 	t.Parallel()
 
@@ -144,6 +173,11 @@ func TestToolActions(t *testing.T) {
 			action:   "list",
 			contains: "Test",
 		},
+		{
+			name:     "validate",
+			action:   "validate",
+			contains: "1 prompt(s) checked",
+		},
 		{
 			name:     "unknown",
 			action:   "invalid",
@@ -220,6 +254,47 @@ func TestInvalidCronSchedule(t *testing.T) {
 	require.NoError(t, hook.Stop())
 }
 
+func TestScheduleFiresOnClock(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "p.md")
+	require.NoError(t, os.WriteFile(path, []byte("do it"), 0o644))
+
+	sub := &blockingSubmitter{release: make(chan struct{})}
+	close(sub.release)
+	// Friday, 23:54.
+	clk := fakeclock.New(time.Date(2025, 1, 10, 23, 54, 0, 0, time.UTC))
+	hook := &Hook{
+		cfg: Config{Prompts: []PromptConfig{
+			{File: path, Schedule: "*/5 * * * *", Weekdays: []string{"business_days"}},
+		}},
+		enabled:         true,
+		limiter:         newLimiter(0, OverflowSkip),
+		queued:          make(map[int]bool),
+		promptSubmitter: sub,
+		clock:           clk,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- hook.Start(ctx) }()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool { return sub.submissions() == 1 }, time.Second, 10*time.Millisecond)
+
+	// The next run falls on Saturday and is skipped by the calendar.
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Minute)
+	clk.BlockUntil(1)
+	require.Never(t, func() bool { return sub.submissions() > 1 }, 50*time.Millisecond, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Eventually(t, func() bool { return clk.Waiters() == 0 }, time.Second, 10*time.Millisecond)
+}
+
 func TestDialogCreation(t *testing.T) {
 	// Not parallel - modifies global singleton.
 
@@ -325,3 +400,448 @@ func TestDialogToggle(t *testing.T) {
 	require.False(t, d.allEnabled)
 	require.False(t, hook.IsEnabled())
 }
+
+func TestDialogGolden(t *testing.T) {
+	t.Parallel()
+
+	prompts := []PromptConfig{
+		{Name: "standup", Schedule: "0 9 * * 1-5"},
+		{File: "~/.config/crush/prompts/weekly-dependency-audit-report.md", Schedule: "0 8 * * 1"},
+		{Name: "inbox", Schedule: "*/30 * * * *"},
+	}
+	d := &Dialog{
+		hook:          &Hook{enabled: true},
+		prompts:       prompts,
+		enabledStates: []bool{true, true, true},
+		allEnabled:    true,
+		width:         dialogWidth,
+		height:        dialogHeight,
+	}
+	for _, key := range []string{"down", "down", "space"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	testutil.RequireGolden(t, "prompts", d.View())
+
+	empty := &Dialog{hook: &Hook{}, width: dialogWidth, height: dialogHeight}
+	testutil.RequireGolden(t, "empty", empty.View())
+}
+
+func TestLoadPromptFileFrontmatter(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "nightly.md")
+	content := `---
+name: Nightly Tests
+schedule: "0 2 * * *"
+enabled: false
+condition: test -f go.mod
+---
+
+Run all tests and report any failures.`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	p, body, err := LoadPromptFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "Nightly Tests", p.Name)
+	require.Equal(t, "0 2 * * *", p.Schedule)
+	require.NotNil(t, p.Enabled)
+	require.False(t, *p.Enabled)
+	require.Equal(t, "test -f go.mod", p.Condition)
+	require.Equal(t, "Run all tests and report any failures.", body)
+
+	// readPromptFile only returns the body.
+	hook, err := NewHook(nil, Config{})
+	require.NoError(t, err)
+	result, err := hook.readPromptFile(path)
+	require.NoError(t, err)
+	require.Equal(t, body, result)
+}
+
+func TestNewHookMergesFrontmatter(t *testing.T) {
+	// Not parallel - modifies global singleton.
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "status.md")
+	content := "---\nname: From File\nschedule: \"0 * * * *\"\n---\nStatus please."
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	hook, err := NewHook(nil, Config{
+		Prompts: []PromptConfig{{File: path, Name: "From Config"}},
+	})
+	require.NoError(t, err)
+
+	prompts := hook.GetPrompts()
+	require.Len(t, prompts, 1)
+	require.Equal(t, "From Config", prompts[0].Name, "config values take precedence")
+	require.Equal(t, "0 * * * *", prompts[0].Schedule, "empty fields come from frontmatter")
+}
+
+func TestNewHookDiscoversDirs(t *testing.T) {
+	// Not parallel - modifies global singleton.
+
+	tmpDir := t.TempDir()
+	dir := filepath.Join(tmpDir, "prompts")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.md"),
+		[]byte("---\nname: A\nschedule: \"*/5 * * * *\"\n---\nDo A."), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "no-schedule.md"),
+		[]byte("---\nname: B\n---\nDo B."), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plain.md"), []byte("Do C."), 0o644))
+
+	hook, err := NewHook(nil, Config{Dirs: []string{dir}})
+	require.NoError(t, err)
+
+	prompts := hook.GetPrompts()
+	require.Len(t, prompts, 1)
+	require.Equal(t, "A", prompts[0].Name)
+	require.Equal(t, filepath.Join(dir, "a.md"), prompts[0].File)
+}
+
+func TestFileConditionsNeedOptIn(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	content := "---\nschedule: \"0 9 * * *\"\ncondition: touch pwned\n---\nCheck the build."
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "build.md"), []byte(content), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "plain.md"), []byte(content), 0o644))
+	configured := []PromptConfig{
+		{File: "plain.md"},
+		{File: "build.md", Condition: "test -f go.mod"},
+	}
+
+	prompts, ignored := resolvePrompts(configured, []string{tmpDir}, tmpDir, false)
+	require.Len(t, prompts, 2)
+	require.Empty(t, prompts[0].Condition, "condition from the configured prompt's file")
+	require.Equal(t, "test -f go.mod", prompts[1].Condition, "condition from crush.json")
+	require.Len(t, ignored, 1)
+	require.Equal(t, "touch pwned", ignored[0].Condition)
+
+	// Discovered files are ignored the same way.
+	prompts, ignored = resolvePrompts(nil, []string{tmpDir}, tmpDir, false)
+	require.Len(t, prompts, 2)
+	require.Len(t, ignored, 2)
+	for _, p := range prompts {
+		require.Empty(t, p.Condition)
+	}
+
+	prompts, ignored = resolvePrompts(nil, []string{tmpDir}, tmpDir, true)
+	require.Empty(t, ignored)
+	for _, p := range prompts {
+		require.Equal(t, "touch pwned", p.Condition)
+	}
+}
+
+func TestConditionMet(t *testing.T) {
+	t.Parallel()
+
+	hook := &Hook{}
+	require.True(t, hook.conditionMet(context.Background(), "true"))
+	require.False(t, hook.conditionMet(context.Background(), "false"))
+}
+
+func TestCalendarFilter(t *testing.T) {
+	t.Parallel()
+
+	f, err := parseCalendar(PromptConfig{
+		SkipDates: []string{"2025-12-25", "01-01"},
+		Weekdays:  []string{"business_days"},
+	})
+	require.NoError(t, err)
+
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		require.NoError(t, err)
+		return d
+	}
+
+	require.True(t, f.allows(date("2025-12-23")), "Tuesday")
+	require.False(t, f.allows(date("2025-12-25")), "one-off skip date")
+	require.False(t, f.allows(date("2027-01-01")), "annual skip date")
+	require.False(t, f.allows(date("2025-12-27")), "Saturday is not a business day")
+
+	f, err = parseCalendar(PromptConfig{Weekdays: []string{"Sat", "sunday"}})
+	require.NoError(t, err)
+	require.True(t, f.allows(date("2025-12-27")))
+	require.False(t, f.allows(date("2025-12-23")))
+
+	f, err = parseCalendar(PromptConfig{})
+	require.NoError(t, err)
+	require.True(t, f.allows(date("2025-12-25")), "no filter allows every day")
+}
+
+func TestCalendarFilterInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseCalendar(PromptConfig{SkipDates: []string{"Dec 25"}})
+	require.ErrorContains(t, err, "invalid skip date")
+
+	_, err = parseCalendar(PromptConfig{Weekdays: []string{"funday"}})
+	require.ErrorContains(t, err, "invalid weekday")
+}
+
+// blockingSubmitter counts submissions and blocks each one until released.
+type blockingSubmitter struct {
+	mu      sync.Mutex
+	count   int
+	release chan struct{}
+}
+
+func (b *blockingSubmitter) SubmitPrompt(ctx context.Context, _ string) error {
+	b.mu.Lock()
+	b.count++
+	b.mu.Unlock()
+	<-b.release
+	return nil
+}
+
+func (b *blockingSubmitter) SubmitPromptToSession(ctx context.Context, _, prompt string) error {
+	return b.SubmitPrompt(ctx, prompt)
+}
+
+func (b *blockingSubmitter) submissions() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+func TestMaxConcurrentSkip(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "p.md")
+	require.NoError(t, os.WriteFile(path, []byte("do it"), 0o644))
+
+	sub := &blockingSubmitter{release: make(chan struct{})}
+	hook := &Hook{
+		cfg:             Config{MaxConcurrent: 1},
+		limiter:         newLimiter(1, OverflowSkip),
+		queued:          make(map[int]bool),
+		promptSubmitter: sub,
+	}
+	p := PromptConfig{File: path}
+
+	go hook.runScheduled(context.Background(), 0, p)
+	require.Eventually(t, func() bool { return sub.submissions() == 1 }, time.Second, 10*time.Millisecond)
+
+	// A second firing while the first is in flight is dropped.
+	hook.runScheduled(context.Background(), 1, p)
+	require.Equal(t, 1, sub.submissions())
+
+	close(sub.release)
+}
+
+func TestPausedSkipsScheduled(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "p.md")
+	require.NoError(t, os.WriteFile(path, []byte("do it"), 0o644))
+
+	sub := &blockingSubmitter{release: make(chan struct{})}
+	close(sub.release)
+	hook := &Hook{
+		limiter:         newLimiter(0, OverflowSkip),
+		queued:          make(map[int]bool),
+		promptSubmitter: sub,
+		control:         agentcontrol.New(),
+	}
+	p := PromptConfig{File: path}
+
+	hook.control.Pause("supervisor request")
+	hook.runScheduled(context.Background(), 0, p)
+	require.Equal(t, 0, sub.submissions())
+
+	hook.control.Resume()
+	hook.runScheduled(context.Background(), 0, p)
+	require.Equal(t, 1, sub.submissions())
+}
+
+func TestMaxConcurrentQueue(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "p.md")
+	require.NoError(t, os.WriteFile(path, []byte("do it"), 0o644))
+
+	sub := &blockingSubmitter{release: make(chan struct{})}
+	hook := &Hook{
+		cfg:             Config{MaxConcurrent: 1, OverflowPolicy: OverflowQueue},
+		limiter:         newLimiter(1, OverflowQueue),
+		queued:          make(map[int]bool),
+		promptSubmitter: sub,
+	}
+	p := PromptConfig{File: path}
+
+	go hook.runScheduled(context.Background(), 0, p)
+	require.Eventually(t, func() bool { return sub.submissions() == 1 }, time.Second, 10*time.Millisecond)
+
+	// The second firing waits for the first to finish.
+	go hook.runScheduled(context.Background(), 1, p)
+	require.Eventually(t, func() bool {
+		hook.mu.RLock()
+		defer hook.mu.RUnlock()
+		return hook.queued[1]
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, sub.submissions())
+
+	// A repeat firing of an already-queued prompt is skipped.
+	hook.runScheduled(context.Background(), 1, p)
+
+	close(sub.release)
+	require.Eventually(t, func() bool { return sub.submissions() == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func TestExpandOutputTemplate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	got := expandOutputTemplate("~/reports/{{name}}-{{date}}-{{time}}.md", "Run Tests!", now)
+	require.Equal(t, "~/reports/Run-Tests-2025-03-04-050607.md", got)
+	require.Equal(t, "out-2025-03-04T050607.md", expandOutputTemplate("out-{{datetime}}.md", "x", now))
+}
+
+// replyingSubmitter answers each prompt through a message subscriber.
+type replyingSubmitter struct {
+	events chan plugin.MessageEvent
+	reply  string
+}
+
+func (r *replyingSubmitter) SubscribeMessages(ctx context.Context) <-chan plugin.MessageEvent {
+	return r.events
+}
+
+func (r *replyingSubmitter) SubmitPrompt(ctx context.Context, prompt string) error {
+	r.events <- plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{
+		SessionID: "other", Role: plugin.MessageRoleAssistant, Content: "unrelated",
+	}}
+	r.events <- plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{
+		SessionID: "s1", Role: plugin.MessageRoleUser, Content: prompt,
+	}}
+	r.events <- plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+		SessionID: "s1", Role: plugin.MessageRoleAssistant, Content: r.reply,
+	}}
+	close(r.events)
+	return nil
+}
+
+func (r *replyingSubmitter) SubmitPromptToSession(ctx context.Context, _, prompt string) error {
+	return r.SubmitPrompt(ctx, prompt)
+}
+
+func TestOutputFileCapture(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	promptPath := filepath.Join(tmpDir, "report.md")
+	require.NoError(t, os.WriteFile(promptPath, []byte("Summarize the build."), 0o644))
+
+	sub := &replyingSubmitter{events: make(chan plugin.MessageEvent, 10), reply: "All green."}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(tmpDir),
+		plugin.WithMessageSubscriber(sub),
+		plugin.WithPromptSubmitter(sub),
+	)
+	hook := &Hook{app: app, promptSubmitter: sub}
+
+	hook.executePrompt(0, PromptConfig{
+		File:       promptPath,
+		Name:       "report",
+		OutputFile: "reports/{{name}}.md",
+	})
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "reports", "report.md"))
+	require.NoError(t, err)
+	require.Equal(t, "All green.\n", string(data))
+}
+
+// busySubmitter skips prompts to a pinned session, as Crush does while the
+// session is busy, and the session's current run replies.
+type busySubmitter struct {
+	events chan plugin.MessageEvent
+}
+
+func (b *busySubmitter) SubscribeMessages(ctx context.Context) <-chan plugin.MessageEvent {
+	return b.events
+}
+
+func (b *busySubmitter) SubmitPrompt(ctx context.Context, prompt string) error {
+	return errors.New("unexpected submit to a new session")
+}
+
+func (b *busySubmitter) SubmitPromptToSession(ctx context.Context, sessionID, prompt string) error {
+	b.events <- plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+		SessionID: sessionID, Role: plugin.MessageRoleAssistant, Content: "Reply to something else.",
+	}}
+	close(b.events)
+	return nil
+}
+
+func TestOutputFileSkippedSubmit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	promptPath := filepath.Join(tmpDir, "report.md")
+	require.NoError(t, os.WriteFile(promptPath, []byte("Summarize the build."), 0o644))
+
+	sub := &busySubmitter{events: make(chan plugin.MessageEvent, 10)}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(tmpDir),
+		plugin.WithMessageSubscriber(sub),
+		plugin.WithPromptSubmitter(sub),
+	)
+	hook := &Hook{app: app, promptSubmitter: sub}
+
+	hook.executePrompt(0, PromptConfig{
+		File:       promptPath,
+		Name:       "report",
+		SessionID:  "s1",
+		OutputFile: "reports/{{name}}.md",
+	})
+
+	require.NoFileExists(t, filepath.Join(tmpDir, "reports", "report.md"))
+}
+
+func TestValidatePrompts(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	good := filepath.Join(tmpDir, "good.md")
+	require.NoError(t, os.WriteFile(good, []byte("Check the build."), 0o644))
+
+	// Wednesday 2025-12-24 10:00 UTC.
+	now := time.Date(2025, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	results := ValidatePrompts([]PromptConfig{
+		{
+			File:       good,
+			Name:       "daily",
+			Schedule:   "0 9 * * *",
+			SkipDates:  []string{"12-25"},
+			Weekdays:   []string{"business_days"},
+			OutputFile: "out/{{date}}.md",
+		},
+		{File: filepath.Join(tmpDir, "missing.md"), Schedule: "bogus"},
+		{File: good, Schedule: "0 9 * * *", Weekdays: []string{"noday"}},
+	}, tmpDir, now, DefaultPreviewRuns)
+	require.Len(t, results, 3)
+
+	daily := results[0]
+	require.True(t, daily.Valid(), daily.Errors)
+	require.Len(t, daily.NextRuns, DefaultPreviewRuns)
+	// Dec 25 is skipped and the weekend is excluded.
+	require.Equal(t, "2025-12-26", daily.NextRuns[0].Format("2006-01-02"))
+	require.Equal(t, "2025-12-29", daily.NextRuns[1].Format("2006-01-02"))
+	require.Equal(t, filepath.Join(tmpDir, "out", "2025-12-26.md"), daily.OutputFile)
+
+	require.False(t, results[1].Valid())
+	require.Len(t, results[1].Errors, 2, "both prompt file and schedule are reported")
+
+	require.False(t, results[2].Valid())
+	require.Contains(t, results[2].Errors[0], "invalid weekday")
+
+	text := formatValidation(results)
+	require.Contains(t, text, "daily [OK]")
+	require.Contains(t, text, "3 prompt(s) checked, 2 invalid.")
+}
@@ -34,8 +34,10 @@ func NewTool(app *plugin.App) fantasy.AgentTool {
 				return disableAction(hook), nil
 			case "list":
 				return listAction(hook), nil
+			case "validate":
+				return validateAction(hook), nil
 			default:
-				return fantasy.NewTextResponse(fmt.Sprintf("unknown action: %s (valid: status, enable, disable, list)", params.Action)), nil
+				return fantasy.NewTextResponse(fmt.Sprintf("unknown action: %s (valid: status, enable, disable, list, validate)", params.Action)), nil
 			}
 		},
 	)
@@ -91,6 +93,21 @@ func listAction(hook *Hook) fantasy.ToolResponse {
 		if p.SessionID != "" {
 			sb.WriteString(fmt.Sprintf("   Session: %s\n", p.SessionID))
 		}
+		if p.Condition != "" {
+			sb.WriteString(fmt.Sprintf("   Condition: %s\n", p.Condition))
+		}
+		if p.OutputFile != "" {
+			sb.WriteString(fmt.Sprintf("   Output: %s\n", p.OutputFile))
+		}
+		if len(p.Weekdays) > 0 {
+			sb.WriteString(fmt.Sprintf("   Weekdays: %s\n", strings.Join(p.Weekdays, ", ")))
+		}
+		if len(p.SkipDates) > 0 {
+			sb.WriteString(fmt.Sprintf("   Skip dates: %s\n", strings.Join(p.SkipDates, ", ")))
+		}
+		if !isPromptEnabled(p) {
+			sb.WriteString("   Disabled\n")
+		}
 		sb.WriteString("\n")
 	}
 
@@ -102,3 +119,8 @@ func listAction(hook *Hook) fantasy.ToolResponse {
 
 	return fantasy.NewTextResponse(sb.String())
 }
+
+func validateAction(hook *Hook) fantasy.ToolResponse {
+	results := ValidatePrompts(hook.GetPrompts(), hook.workingDir(), hook.getClock().Now(), DefaultPreviewRuns)
+	return fantasy.NewTextResponse(formatValidation(results))
+}
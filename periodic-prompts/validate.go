@@ -0,0 +1,137 @@
+package periodicprompts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultPreviewRuns is how many upcoming fire times validation reports.
+const DefaultPreviewRuns = 5
+
+// maxPreviewSteps bounds the search for fire times that pass the calendar
+// filter, so a filter that excludes every day cannot loop forever.
+const maxPreviewSteps = 10000
+
+// scheduleParser parses the five-field crontab schedules used by prompts.
+var scheduleParser = cron.NewParser(
+	cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow,
+)
+
+// PromptValidation is the dry-run result for a single prompt.
+type PromptValidation struct {
+	Prompt PromptConfig
+	// NextRuns are the upcoming fire times after calendar filtering.
+	NextRuns []time.Time
+	// OutputFile is the output path with placeholders expanded for the
+	// first upcoming run.
+	OutputFile string
+	// Errors lists every problem found; empty means the prompt is valid.
+	Errors []string
+}
+
+// Valid reports whether no problems were found.
+func (v PromptValidation) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// ValidatePrompts parses each prompt's schedule and calendar filter, reads
+// its prompt file, expands its output path, and computes its next n fire
+// times after now. Nothing is submitted to the agent.
+func ValidatePrompts(prompts []PromptConfig, workingDir string, now time.Time, n int) []PromptValidation {
+	results := make([]PromptValidation, 0, len(prompts))
+	for _, p := range prompts {
+		results = append(results, validatePrompt(p, workingDir, now, n))
+	}
+	return results
+}
+
+func validatePrompt(p PromptConfig, workingDir string, now time.Time, n int) PromptValidation {
+	v := PromptValidation{Prompt: p}
+
+	if _, body, err := loadPrompt(p.File, workingDir); err != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("prompt file: %v", err))
+	} else if body == "" {
+		v.Errors = append(v.Errors, "prompt file: empty prompt body")
+	}
+
+	calendar, err := parseCalendar(p)
+	if err != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("calendar: %v", err))
+	}
+
+	if p.Schedule == "" {
+		v.Errors = append(v.Errors, "schedule: missing")
+	} else if schedule, err := scheduleParser.Parse(p.Schedule); err != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("schedule: %v", err))
+	} else {
+		v.NextRuns = nextRuns(schedule, calendar, now, n)
+		if len(v.NextRuns) == 0 {
+			v.Errors = append(v.Errors, "schedule: never fires with the configured calendar filter")
+		}
+	}
+
+	if p.OutputFile != "" {
+		at := now
+		if len(v.NextRuns) > 0 {
+			at = v.NextRuns[0]
+		}
+		v.OutputFile = expandPath(expandOutputTemplate(p.OutputFile, promptName(p), at), workingDir)
+	}
+
+	return v
+}
+
+// nextRuns returns up to n fire times after now that the calendar allows.
+func nextRuns(schedule cron.Schedule, calendar calendarFilter, now time.Time, n int) []time.Time {
+	var runs []time.Time
+	t := now
+	for step := 0; step < maxPreviewSteps && len(runs) < n; step++ {
+		t = schedule.Next(t)
+		if t.IsZero() {
+			break
+		}
+		if calendar.allows(t) {
+			runs = append(runs, t)
+		}
+	}
+	return runs
+}
+
+// formatValidation renders validation results for the tool response.
+func formatValidation(results []PromptValidation) string {
+	if len(results) == 0 {
+		return "No periodic prompts configured."
+	}
+
+	var sb strings.Builder
+	invalid := 0
+	for i, v := range results {
+		status := "OK"
+		if !v.Valid() {
+			status = "INVALID"
+			invalid++
+		}
+		sb.WriteString(fmt.Sprintf("%d. %s [%s]\n", i+1, promptName(v.Prompt), status))
+		sb.WriteString(fmt.Sprintf("   File: %s\n", v.Prompt.File))
+		sb.WriteString(fmt.Sprintf("   Schedule: %s\n", v.Prompt.Schedule))
+		for _, e := range v.Errors {
+			sb.WriteString(fmt.Sprintf("   Error: %s\n", e))
+		}
+		if v.OutputFile != "" {
+			sb.WriteString(fmt.Sprintf("   Output: %s\n", v.OutputFile))
+		}
+		if len(v.NextRuns) > 0 {
+			sb.WriteString("   Next runs:\n")
+			for _, run := range v.NextRuns {
+				sb.WriteString(fmt.Sprintf("     - %s\n", run.Format("Mon 2006-01-02 15:04 MST")))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("%d prompt(s) checked, %d invalid.", len(results), invalid))
+	return sb.String()
+}
@@ -0,0 +1,199 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Approval is the user's answer to a sub-agent asking for a disallowed tool.
+type Approval int
+
+const (
+	// ApprovalDeny refuses the tool call.
+	ApprovalDeny Approval = iota
+	// ApprovalOnce allows this call only.
+	ApprovalOnce
+	// ApprovalAlways allows the call and saves the tool to the agent file.
+	ApprovalAlways
+)
+
+// ApprovalRequest is a pending request from a running sub-agent to use a
+// tool its configuration does not allow.
+type ApprovalRequest struct {
+	ID    int
+	Agent string
+	Tool  string
+
+	reply chan Approval
+}
+
+// approvals queues requests until the user answers them.
+type approvals struct {
+	mu      sync.Mutex
+	pending []*ApprovalRequest
+	nextID  int
+
+	// timeout is how long a request waits for an answer before it is denied.
+	timeout time.Duration
+	// notify tells the user that a request is waiting, or is nil.
+	notify func(title, message string)
+}
+
+func newApprovals(timeout time.Duration) *approvals {
+	return &approvals{timeout: timeout}
+}
+
+// approvalNotifyEnabled reports whether the user is notified of requests.
+func (c Config) approvalNotifyEnabled() bool {
+	return c.ApprovalNotify == nil || *c.ApprovalNotify
+}
+
+// add queues a request for tool on behalf of agent.
+func (a *approvals) add(agent, tool string) *ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextID++
+	req := &ApprovalRequest{ID: a.nextID, Agent: agent, Tool: tool, reply: make(chan Approval, 1)}
+	a.pending = append(a.pending, req)
+	return req
+}
+
+// take removes the request with id from the queue.
+func (a *approvals) take(id int) *ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.pending, func(req *ApprovalRequest) bool { return req.ID == id })
+	if i < 0 {
+		return nil
+	}
+	req := a.pending[i]
+	a.pending = slices.Delete(a.pending, i, i+1)
+	return req
+}
+
+// requestApproval asks the user whether agent may use a disallowed tool,
+// blocking until they answer, ctx ends, or the approval timeout passes. The
+// user is notified, as a sub-agent run gives no other sign that it waits.
+// Runners call it through SubAgentOptions.ApproveTool instead of failing the
+// tool call outright.
+func (r *Registry) requestApproval(ctx context.Context, agent, tool string) bool {
+	tool = NormalizeTool(tool)
+	req := r.approvals.add(agent, tool)
+	r.logger.Info("sub-agent requests a disallowed tool", "agent", agent, "tool", tool)
+	if notify := r.approvals.notify; notify != nil {
+		notify("Crush: sub-agent needs approval",
+			fmt.Sprintf("Sub-agent %s wants to use %s. Answer with /subagents-approve.", agent, tool))
+	}
+
+	timer := time.NewTimer(r.approvals.timeout)
+	defer timer.Stop()
+	select {
+	case decision := <-req.reply:
+		return r.applyApproval(agent, tool, decision)
+	case <-timer.C:
+		if r.approvals.take(req.ID) == nil {
+			// Answered as the timeout passed.
+			return r.applyApproval(agent, tool, <-req.reply)
+		}
+		r.logger.Warn("tool approval timed out, denying", "agent", agent, "tool", tool, "timeout", r.approvals.timeout)
+		return false
+	case <-ctx.Done():
+		r.approvals.take(req.ID)
+		return false
+	}
+}
+
+// applyApproval acts on the user's decision and reports whether the tool
+// call may go ahead.
+func (r *Registry) applyApproval(agent, tool string, decision Approval) bool {
+	if decision == ApprovalAlways {
+		if err := r.AllowTool(agent, tool); err != nil {
+			r.logger.Warn("failed to save tool approval", "agent", agent, "tool", tool, "error", err)
+		}
+	}
+	return decision != ApprovalDeny
+}
+
+// PendingApprovals returns the unanswered approval requests, oldest first.
+func (r *Registry) PendingApprovals() []ApprovalRequest {
+	r.approvals.mu.Lock()
+	defer r.approvals.mu.Unlock()
+	pending := make([]ApprovalRequest, len(r.approvals.pending))
+	for i, req := range r.approvals.pending {
+		pending[i] = *req
+	}
+	return pending
+}
+
+// Resolve answers the approval request with id.
+func (r *Registry) Resolve(id int, decision Approval) error {
+	req := r.approvals.take(id)
+	if req == nil {
+		return fmt.Errorf("approval request not found: %d", id)
+	}
+	req.reply <- decision
+	return nil
+}
+
+// AllowTool permanently allows agent to use tool by editing its file:
+// the tool is dropped from disallowedTools and, when the agent lists its
+// tools, added to tools. Lists inherited through extends are written out
+// in full so the base agent is left unchanged.
+func (r *Registry) AllowTool(name, tool string) error {
+	agent, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("agent not found: %s", name)
+	}
+	if r.isCached(agent.FilePath) {
+		return fmt.Errorf("agent %s comes from a remote source", name)
+	}
+	raw, err := LoadAgentFile(agent.FilePath)
+	if err != nil {
+		return fmt.Errorf("load agent file: %w", err)
+	}
+	tool = NormalizeTool(tool)
+	matches := func(t string) bool { return NormalizeTool(t) == tool }
+
+	tools := []string(raw.ToolsRaw)
+	if tools == nil {
+		tools = agent.Tools
+	}
+	if tools != nil && !slices.ContainsFunc(tools, matches) {
+		tools = append(slices.Clone(tools), tool)
+	}
+	disallowed := []string(raw.DisallowedRaw)
+	if disallowed == nil && slices.ContainsFunc(agent.DisallowedTools, matches) {
+		disallowed = agent.DisallowedTools
+	}
+	if disallowed != nil {
+		disallowed = slices.DeleteFunc(slices.Clone(disallowed), matches)
+		if len(disallowed) == 0 && agent.Extends == "" {
+			disallowed = nil
+		}
+	}
+
+	data, err := os.ReadFile(agent.FilePath)
+	if err != nil {
+		return fmt.Errorf("read agent file: %w", err)
+	}
+	content, err := updateFrontmatter(data, func(mapping *yaml.Node) {
+		setTools(mapping, "tools", tools)
+		setTools(mapping, "disallowedTools", disallowed)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(agent.FilePath, content, 0o644); err != nil {
+		return fmt.Errorf("write agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("allowed tool for sub-agent", "name", name, "tool", tool)
+	return nil
+}
@@ -0,0 +1,70 @@
+package subagents
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultArtifactDir is where result artifacts are written by default.
+const DefaultArtifactDir = ".crush/artifacts"
+
+// artifactSummaryBytes caps the part of an artifact's result returned inline.
+const artifactSummaryBytes = 1000
+
+// usesArtifact reports whether agent's results are saved as artifacts.
+func (r *Registry) usesArtifact(agent *SubAgent) bool {
+	return r.cfg.Artifacts || agent.Artifact
+}
+
+// saveArtifact writes raw, the agent's full reply, to a new file in the
+// artifact directory and returns reply shortened to a summary followed by
+// the artifact's path.
+func (r *Registry) saveArtifact(agent, raw, reply string) (string, error) {
+	dir := ExpandPath(r.cfg.ArtifactDir, r.workingDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create artifact dir: %w", err)
+	}
+
+	base := agent + "-" + time.Now().Format("20060102-150405")
+	var path string
+	for n := 1; ; n++ {
+		path = filepath.Join(dir, base+".md")
+		if n > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d.md", base, n))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("create artifact: %w", err)
+		}
+		_, err = f.WriteString(raw)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", fmt.Errorf("write artifact: %w", err)
+		}
+		break
+	}
+
+	return fmt.Sprintf("%s\n\n[Full result (%d bytes) saved to %s]", summarize(reply), len(raw), r.displayPath(path)), nil
+}
+
+// summarize shortens s to about artifactSummaryBytes, cutting at a line
+// break when there is one.
+func summarize(s string) string {
+	if len(s) <= artifactSummaryBytes {
+		return s
+	}
+	short := truncate(s, artifactSummaryBytes)
+	if i := strings.LastIndex(short, "\n"); i > 0 {
+		short = short[:i]
+	}
+	return strings.TrimSpace(short) + "\n..."
+}
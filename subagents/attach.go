@@ -0,0 +1,146 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxAttachmentFileBytes caps the content injected from a single file.
+	maxAttachmentFileBytes = 64 * 1024
+
+	// maxAttachmentBytes caps the content injected into one run.
+	maxAttachmentBytes = 256 * 1024
+)
+
+// attachmentsKey carries files requested for a run through its context.
+type attachmentsKey struct{}
+
+// WithFiles returns a context whose sub-agent runs also receive the
+// contents of files, which may be paths or globs relative to the working
+// directory.
+func WithFiles(ctx context.Context, files []string) context.Context {
+	if len(files) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, attachmentsKey{}, files)
+}
+
+// checkFiles rejects files requested by the model that name paths outside
+// the working directory outright, so they cannot bypass Crush's permission
+// checks on reads elsewhere.
+func checkFiles(files []string) error {
+	for _, f := range files {
+		if filepath.IsAbs(f) || strings.HasPrefix(f, "~") {
+			return fmt.Errorf("files must be relative to the working directory: %s", f)
+		}
+	}
+	return nil
+}
+
+// filesFrom returns the files attached to ctx by WithFiles.
+func filesFrom(ctx context.Context) []string {
+	files, _ := ctx.Value(attachmentsKey{}).([]string)
+	return files
+}
+
+// attachFiles prepends the contents of the agent's context files and the
+// files requested for this run to prompt.
+func (r *Registry) attachFiles(ctx context.Context, agent *SubAgent, prompt string) string {
+	patterns := append(append([]string(nil), agent.ContextFiles...), filesFrom(ctx)...)
+	if len(patterns) == 0 {
+		return prompt
+	}
+
+	block := r.renderAttachments(patterns)
+	if block == "" {
+		return prompt
+	}
+	return block + "\n\n" + prompt
+}
+
+// renderAttachments reads the files matching patterns, in order and without
+// duplicates, into a <context_files> block. Files beyond the size limits or
+// outside the working directory are truncated or skipped with a note so the
+// sub-agent knows content is missing.
+func (r *Registry) renderAttachments(patterns []string) string {
+	var (
+		sb    strings.Builder
+		total int
+		seen  = make(map[string]bool)
+	)
+	sb.WriteString("<context_files>\n")
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(ExpandPath(pattern, r.workingDir))
+		if err != nil || len(matches) == 0 {
+			r.logger.Warn("context file not found", "pattern", pattern, "error", err)
+			sb.WriteString(fmt.Sprintf("<missing path=%q />\n", pattern))
+			continue
+		}
+		for _, path := range matches {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			name := r.displayPath(path)
+			if !r.inWorkingDir(path) {
+				r.logger.Warn("context file outside the working directory", "path", path)
+				sb.WriteString(fmt.Sprintf("<omitted path=%q reason=\"outside the working directory\" />\n", name))
+				continue
+			}
+			if total >= maxAttachmentBytes {
+				sb.WriteString(fmt.Sprintf("<omitted path=%q reason=\"attachment limit reached\" />\n", name))
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				r.logger.Warn("failed to read context file", "path", path, "error", err)
+				continue
+			}
+
+			content := string(data)
+			limit := min(maxAttachmentFileBytes, maxAttachmentBytes-total)
+			truncated := len(content) > limit
+			if truncated {
+				content = truncate(content, limit)
+			}
+			if !utf8.ValidString(content) {
+				sb.WriteString(fmt.Sprintf("<omitted path=%q reason=\"binary file\" />\n", name))
+				continue
+			}
+			total += len(content)
+
+			sb.WriteString(fmt.Sprintf("<file path=%q>\n%s\n", name, content))
+			if truncated {
+				sb.WriteString(fmt.Sprintf("[truncated: %d of %d bytes shown]\n", len(content), len(data)))
+			}
+			sb.WriteString("</file>\n")
+		}
+	}
+	sb.WriteString("</context_files>")
+	return sb.String()
+}
+
+// inWorkingDir reports whether path, with symlinks resolved, lies inside the
+// working directory.
+func (r *Registry) inWorkingDir(path string) bool {
+	root, err := filepath.Abs(r.workingDir)
+	return err == nil && withinResolved(root, path)
+}
+
+// displayPath shows path relative to the working directory when inside it.
+func (r *Registry) displayPath(path string) string {
+	if rel, err := filepath.Rel(r.workingDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,218 @@
+package subagents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// budgetPollInterval is how often session usage is checked while a
+// budgeted sub-agent is running.
+const budgetPollInterval = 500 * time.Millisecond
+
+// errBudgetExceeded is the cancellation cause when a usage limit is hit.
+var errBudgetExceeded = errors.New("budget exceeded")
+
+// usage is a point-in-time reading of session token and cost totals.
+type usage struct {
+	tokens int64
+	cost   float64
+}
+
+// currentUsage reads the session totals, if the host provides them.
+func (r *Registry) currentUsage() (usage, bool) {
+	sip := r.app.SessionInfo()
+	if sip == nil {
+		return usage{}, false
+	}
+	info := sip.SessionInfo()
+	if info == nil {
+		return usage{}, false
+	}
+	return usage{
+		tokens: info.Tokens.Input + info.Tokens.Output,
+		cost:   info.CostUSD,
+	}, true
+}
+
+// hasBudget reports whether the agent declares any usage limit.
+func (a *SubAgent) hasBudget() bool {
+	return a.MaxTokens > 0 || a.MaxCostUSD > 0
+}
+
+// checkBudget returns an error if the usage consumed since start exceeds
+// the agent's limits.
+func (a *SubAgent) checkBudget(start, now usage) error {
+	if used := now.tokens - start.tokens; a.MaxTokens > 0 && used > int64(a.MaxTokens) {
+		return fmt.Errorf("%w: used %d tokens, limit %d", errBudgetExceeded, used, a.MaxTokens)
+	}
+	if spent := now.cost - start.cost; a.MaxCostUSD > 0 && spent > a.MaxCostUSD {
+		return fmt.Errorf("%w: spent $%.4f, limit $%.4f", errBudgetExceeded, spent, a.MaxCostUSD)
+	}
+	return nil
+}
+
+// withLimits derives a run context enforcing the agent's timeout and usage
+// budget. Usage is measured as the growth of the session totals while the
+// sub-agent runs, which includes any run it overlaps; beginRun keeps other
+// top-level runs out of a budgeted one. The returned finish func stops monitoring and reports the
+// limit that ended the run, if any.
+func (r *Registry) withLimits(ctx context.Context, agent *SubAgent) (context.Context, func() error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	var stopTimer func() bool
+	if agent.Timeout > 0 {
+		timer := time.AfterFunc(agent.Timeout, func() {
+			cancel(fmt.Errorf("timed out after %s", agent.Timeout))
+		})
+		stopTimer = timer.Stop
+	}
+
+	start, ok := r.currentUsage()
+	done := make(chan struct{})
+	if ok && agent.hasBudget() {
+		go func() {
+			ticker := time.NewTicker(budgetPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if now, ok := r.currentUsage(); ok {
+						if err := agent.checkBudget(start, now); err != nil {
+							cancel(err)
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	finish := func() error {
+		close(done)
+		if stopTimer != nil {
+			stopTimer()
+		}
+		err := context.Cause(ctx)
+		if err == nil && ok && agent.hasBudget() {
+			if now, ok := r.currentUsage(); ok {
+				err = agent.checkBudget(start, now)
+			}
+		}
+		cancel(nil)
+		return err
+	}
+
+	return ctx, finish
+}
+
+// meterKey carries the IDs of the metered runs a run is nested in.
+type meterKey struct{}
+
+// usageMeter tracks the runs in progress. The runner reports no usage of its
+// own, so a run's usage is the growth of the session totals while it runs,
+// and that growth belongs to it only if no unrelated run overlapped it.
+// Runs nested in one another are related: a delegation counts towards the
+// run that made it.
+type usageMeter struct {
+	mu        sync.Mutex
+	next      int
+	runs      map[int]*meteredRun
+	exclusive int           // Budgeted top-level runs in progress
+	changed   chan struct{} // Closed and replaced when a run ends
+}
+
+// meteredRun is a run in progress, as tracked by usageMeter.
+type meteredRun struct {
+	id        int
+	ancestors []int
+	exclusive bool
+	shared    bool // Overlapped an unrelated run
+	before    usage
+	hasUsage  bool
+}
+
+// beginRun registers a run of agent with the meter and reads the session
+// totals it starts from. A budgeted top-level run waits until no other run
+// is in progress and holds off new top-level runs until it ends, so its
+// budget is checked against its own usage. Nested runs never wait, since
+// the runs they would wait for include their own ancestors.
+func (r *Registry) beginRun(ctx context.Context, agent *SubAgent) (context.Context, *meteredRun, error) {
+	m := &r.meter
+	ancestors, _ := ctx.Value(meterKey{}).([]int)
+	run := &meteredRun{
+		ancestors: ancestors,
+		exclusive: agent.hasBudget() && len(ancestors) == 0,
+	}
+	for {
+		m.mu.Lock()
+		if len(ancestors) > 0 || m.exclusive == 0 && (!run.exclusive || len(m.runs) == 0) {
+			break
+		}
+		changed := m.changedLocked()
+		m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	defer m.mu.Unlock()
+
+	m.next++
+	run.id = m.next
+	for _, other := range m.runs {
+		if !slices.Contains(ancestors, other.id) {
+			other.shared = true
+			run.shared = true
+		}
+	}
+	if m.runs == nil {
+		m.runs = make(map[int]*meteredRun)
+	}
+	m.runs[run.id] = run
+	if run.exclusive {
+		m.exclusive++
+	}
+	run.before, run.hasUsage = r.currentUsage()
+	return context.WithValue(ctx, meterKey{}, append(slices.Clone(ancestors), run.id)), run, nil
+}
+
+// endRun removes run from the meter and returns the usage it can be
+// charged with. The result is false when the host reports no session totals or an
+// unrelated run overlapped it, leaving its usage unknown.
+func (r *Registry) endRun(run *meteredRun) (usage, bool) {
+	after, hasUsage := r.currentUsage()
+
+	m := &r.meter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.runs, run.id)
+	if run.exclusive {
+		m.exclusive--
+	}
+	if m.changed != nil {
+		close(m.changed)
+		m.changed = nil
+	}
+	if !run.hasUsage || !hasUsage || run.shared {
+		return usage{}, false
+	}
+	return usage{tokens: after.tokens - run.before.tokens, cost: after.cost - run.before.cost}, true
+}
+
+// changedLocked returns the channel closed when the next run ends. m.mu
+// must be held.
+func (m *usageMeter) changedLocked() chan struct{} {
+	if m.changed == nil {
+		m.changed = make(chan struct{})
+	}
+	return m.changed
+}
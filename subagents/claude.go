@@ -0,0 +1,26 @@
+package subagents
+
+import (
+	"strings"
+)
+
+// ClaudeDirs are searched for Claude Code agent definitions when
+// import_claude is enabled.
+var ClaudeDirs = []string{".claude/agents", "~/.claude/agents"}
+
+// LoadClaudeAgentFile parses a Claude Code sub-agent file and maps its model
+// alias and permission mode to Crush equivalents. Tool names are normalized
+// by LoadAgentFile like those of any agent.
+func LoadClaudeAgentFile(path string) (*SubAgent, error) {
+	agent, err := LoadAgentFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.Model = strings.ToLower(agent.Model)
+	if !permissionModes[agent.PermissionMode] {
+		agent.PermissionMode = ""
+	}
+	agent.ClaudeCode = true
+	return agent, nil
+}
@@ -0,0 +1,58 @@
+package subagents
+
+import (
+	"sync"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// reservedCommandIDs are the plugin's own commands, which agents cannot shadow.
+var reservedCommandIDs = map[string]bool{
+	"subagents":         true,
+	"subagents-sync":    true,
+	"subagents-new":     true,
+	"subagents-approve": true,
+	"subagents-test":    true,
+}
+
+var (
+	agentCommandsMu sync.Mutex
+	agentCommands   = make(map[string]bool) // Agent names with a registered command
+)
+
+// registerAgentCommands registers a command for every loaded agent that
+// does not have one yet. Commands cannot be unregistered, so the handler
+// ignores agents that were since removed or disabled.
+func (r *Registry) registerAgentCommands() {
+	agentCommandsMu.Lock()
+	defer agentCommandsMu.Unlock()
+
+	for _, agent := range r.List() {
+		if agentCommands[agent.Name] || reservedCommandIDs[agent.Name] {
+			continue
+		}
+		agentCommands[agent.Name] = true
+		plugin.RegisterCommand(agentCommand(agent), r.agentCommandHandler(agent.Name))
+	}
+}
+
+// agentCommand describes the command that runs agent, invoked as /<name>.
+func agentCommand(agent *SubAgent) plugin.PluginCommand {
+	return plugin.PluginCommand{
+		ID:          agent.Name,
+		Title:       "SubAgent: " + agent.Name,
+		Description: agent.Description,
+	}
+}
+
+// agentCommandHandler opens the run dialog for the named agent.
+func (r *Registry) agentCommandHandler(name string) func(plugin.PluginCommand) plugin.PluginAction {
+	return func(cmd plugin.PluginCommand) plugin.PluginAction {
+		agent, ok := r.Get(name)
+		if !ok || !agent.Enabled {
+			return plugin.NoAction{}
+		}
+		SetSelectedAgent(name)
+		return plugin.OpenDialogAction{DialogID: RunDialogID}
+	}
+}
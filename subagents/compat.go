@@ -0,0 +1,94 @@
+package subagents
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// crushModule is the module path of Crush, whose version agents may require.
+const crushModule = "github.com/charmbracelet/crush"
+
+// crushVersion is the version of Crush this binary was built with, empty
+// when it cannot be told, such as in development builds.
+var crushVersion = buildCrushVersion()
+
+// buildCrushVersion reads the Crush version from the binary's build info.
+func buildCrushVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == crushModule {
+		return releaseVersion(info.Main.Version)
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != crushModule {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		return releaseVersion(dep.Version)
+	}
+	return ""
+}
+
+// releaseVersion returns v unless it is a placeholder or pseudo-version,
+// which say nothing about the features available.
+func releaseVersion(v string) string {
+	if v == "" || v == "(devel)" || strings.HasPrefix(v, "v0.0.0") {
+		return ""
+	}
+	return v
+}
+
+// parseVersion parses a version such as "v0.30.1" or "1.2" into its major,
+// minor, and patch numbers, ignoring any pre-release or build suffix.
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if v == "" || len(fields) > 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// versionLess reports whether version a is older than b. Both must parse.
+func versionLess(a, b string) bool {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return false
+}
+
+// incompatibility describes why agent cannot be expected to work with the
+// running Crush, or returns "" when it can or the version is unknown.
+func (r *Registry) incompatibility(agent *SubAgent) string {
+	if agent.MinCrushVersion == "" || r.crushVersion == "" {
+		return ""
+	}
+	if _, ok := parseVersion(r.crushVersion); !ok {
+		return ""
+	}
+	if versionLess(r.crushVersion, agent.MinCrushVersion) {
+		return fmt.Sprintf("requires Crush %s or newer, running %s", agent.MinCrushVersion, r.crushVersion)
+	}
+	return ""
+}
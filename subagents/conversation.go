@@ -0,0 +1,121 @@
+package subagents
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxConversationTurns caps the earlier exchanges replayed to a
+	// conversation-mode agent; older turns are dropped.
+	maxConversationTurns = 20
+
+	// maxConversations caps the sessions kept in memory; the least recently
+	// used session is evicted first.
+	maxConversations = 64
+)
+
+// conversationTurn is one completed exchange with a sub-agent.
+type conversationTurn struct {
+	prompt string
+	reply  string
+}
+
+// conversation is the persistent context of a conversation-mode agent.
+type conversation struct {
+	id      string
+	agent   string
+	turns   []conversationTurn
+	updated time.Time
+}
+
+// conversations holds the in-memory sessions of conversation-mode agents.
+type conversations struct {
+	mu       sync.Mutex
+	sessions map[string]*conversation
+}
+
+func newConversations() *conversations {
+	return &conversations{sessions: make(map[string]*conversation)}
+}
+
+// open returns the session with id for agent, or starts a new one when id
+// is empty.
+func (c *conversations) open(agent, id string) (*conversation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id != "" {
+		conv, ok := c.sessions[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown sub-agent session: %s", id)
+		}
+		if conv.agent != agent {
+			return nil, fmt.Errorf("session %s belongs to sub-agent %s", id, conv.agent)
+		}
+		return conv, nil
+	}
+
+	if len(c.sessions) >= maxConversations {
+		c.evictOldest()
+	}
+	conv := &conversation{id: newSessionID(), agent: agent, updated: time.Now()}
+	c.sessions[conv.id] = conv
+	return conv, nil
+}
+
+// record appends a completed exchange to the session.
+func (c *conversations) record(conv *conversation, prompt, reply string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv.turns = append(conv.turns, conversationTurn{prompt: prompt, reply: reply})
+	if len(conv.turns) > maxConversationTurns {
+		conv.turns = conv.turns[len(conv.turns)-maxConversationTurns:]
+	}
+	conv.updated = time.Now()
+}
+
+// prompt prepends the earlier exchanges of the session to task.
+func (c *conversations) prompt(conv *conversation, task string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(conv.turns) == 0 {
+		return task
+	}
+
+	var sb strings.Builder
+	sb.WriteString("You are continuing an earlier conversation. Previous exchanges, oldest first:\n\n")
+	sb.WriteString("<previous_turns>\n")
+	for _, turn := range conv.turns {
+		sb.WriteString("<turn>\n<task>\n" + turn.prompt + "\n</task>\n<reply>\n" + turn.reply + "\n</reply>\n</turn>\n")
+	}
+	sb.WriteString("</previous_turns>\n\n")
+	sb.WriteString("Current task:\n\n" + task)
+	return sb.String()
+}
+
+// evictOldest removes the least recently used session. Callers hold c.mu.
+func (c *conversations) evictOldest() {
+	var oldest *conversation
+	for _, conv := range c.sessions {
+		if oldest == nil || conv.updated.Before(oldest.updated) {
+			oldest = conv
+		}
+	}
+	if oldest != nil {
+		delete(c.sessions, oldest.id)
+	}
+}
+
+// newSessionID returns a random session identifier.
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "sa-" + hex.EncodeToString(b)
+}
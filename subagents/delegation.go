@@ -0,0 +1,76 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxDepth is the default number of nested sub-agent runs: the
+// parent's sub-agents may delegate once more, to specialists of their own.
+const DefaultMaxDepth = 2
+
+// delegationKey carries the chain of sub-agents a run is nested in. Runners
+// hand the run's context to the sub-agent's tools, so a nested subagent call
+// sees the agents above it.
+type delegationKey struct{}
+
+// delegationChain returns the names of the sub-agents ctx is running in,
+// outermost first.
+func delegationChain(ctx context.Context) []string {
+	chain, _ := ctx.Value(delegationKey{}).([]string)
+	return chain
+}
+
+// parentAgent returns the sub-agent ctx is running in, empty at the top
+// level.
+func parentAgent(ctx context.Context) string {
+	if chain := delegationChain(ctx); len(chain) > 0 {
+		return chain[len(chain)-1]
+	}
+	return ""
+}
+
+// enter checks that agent may run at the current delegation depth without
+// calling itself again, and returns the context for its run along with the
+// depth it runs at.
+func (r *Registry) enter(ctx context.Context, agent *SubAgent) (context.Context, int, error) {
+	chain := delegationChain(ctx)
+	path := strings.Join(append(slices.Clone(chain), agent.Name), " -> ")
+	if slices.Contains(chain, agent.Name) {
+		return nil, 0, fmt.Errorf("delegation cycle: %s", path)
+	}
+	if len(chain) >= r.cfg.MaxDepth {
+		return nil, 0, fmt.Errorf("delegation depth limit of %d reached: %s", r.cfg.MaxDepth, path)
+	}
+	chain = append(slices.Clone(chain), agent.Name)
+	return context.WithValue(ctx, delegationKey{}, chain), len(chain), nil
+}
+
+// delegationTools returns the disallowed tools for a run at depth. Agents
+// at the depth limit lose the subagent tool, so they don't try to delegate.
+func (r *Registry) delegationTools(agent *SubAgent, depth int) []string {
+	if depth < r.cfg.MaxDepth || slices.Contains(agent.DisallowedTools, ToolName) {
+		return agent.DisallowedTools
+	}
+	return append(slices.Clone(agent.DisallowedTools), ToolName)
+}
+
+// toolCallKey carries the ID of the subagent tool call a run was started by.
+type toolCallKey struct{}
+
+// withToolCall records id as the invoking tool call. A nested call keeps
+// the outermost ID, the one tracing sees in the parent session.
+func withToolCall(ctx context.Context, id string) context.Context {
+	if id == "" || toolCallFrom(ctx) != "" {
+		return ctx
+	}
+	return context.WithValue(ctx, toolCallKey{}, id)
+}
+
+// toolCallFrom returns the tool call ID recorded by withToolCall.
+func toolCallFrom(ctx context.Context) string {
+	id, _ := ctx.Value(toolCallKey{}).(string)
+	return id
+}
@@ -0,0 +1,122 @@
+package subagents
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// ApprovalDialogID is the identifier for the tool approval dialog.
+	ApprovalDialogID = "subagents-approve"
+
+	approvalDialogWidth = 70
+)
+
+// ApprovalDialog answers sub-agent requests for disallowed tools, oldest
+// first.
+type ApprovalDialog struct {
+	registry *Registry
+	status   string
+	width    int
+}
+
+// NewApprovalDialog creates the tool approval dialog.
+func NewApprovalDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	registry := getRegistry()
+	if registry == nil {
+		return nil, fmt.Errorf("subagents registry not initialized")
+	}
+	return newApprovalDialog(registry), nil
+}
+
+func newApprovalDialog(registry *Registry) *ApprovalDialog {
+	return &ApprovalDialog{registry: registry, width: approvalDialogWidth}
+}
+
+func (d *ApprovalDialog) ID() string {
+	return ApprovalDialogID
+}
+
+func (d *ApprovalDialog) Title() string {
+	return "SubAgent Tool Approval"
+}
+
+func (d *ApprovalDialog) Init() error {
+	return nil
+}
+
+func (d *ApprovalDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		if e.Key == "esc" || e.Key == "q" {
+			return true, plugin.NoAction{}, nil
+		}
+		pending := d.registry.PendingApprovals()
+		if len(pending) == 0 {
+			return false, plugin.NoAction{}, nil
+		}
+		req := pending[0]
+
+		var decision Approval
+		switch e.Key {
+		case "o", "enter":
+			decision = ApprovalOnce
+		case "a":
+			decision = ApprovalAlways
+		case "d", "n":
+			decision = ApprovalDeny
+		default:
+			return false, plugin.NoAction{}, nil
+		}
+		if err := d.registry.Resolve(req.ID, decision); err != nil {
+			d.status = err.Error()
+			return false, plugin.NoAction{}, nil
+		}
+		d.status = fmt.Sprintf("%s: %s for %s", approvalVerbs[decision], req.Tool, req.Agent)
+	case plugin.ResizeEvent:
+		d.width = min(approvalDialogWidth, e.Width-10)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+// approvalVerbs describe each decision in the status line.
+var approvalVerbs = map[Approval]string{
+	ApprovalDeny:   "Denied",
+	ApprovalOnce:   "Allowed once",
+	ApprovalAlways: "Always allowed",
+}
+
+func (d *ApprovalDialog) View() string {
+	var sb strings.Builder
+	pending := d.registry.PendingApprovals()
+
+	if len(pending) == 0 {
+		sb.WriteString("No pending tool requests.\n")
+	} else {
+		req := pending[0]
+		sb.WriteString(fmt.Sprintf("Sub-agent %s wants to use %s,\n", req.Agent, req.Tool))
+		sb.WriteString("which its configuration does not allow.\n")
+		if len(pending) > 1 {
+			sb.WriteString(fmt.Sprintf("\n%d more requests waiting.\n", len(pending)-1))
+		}
+	}
+
+	if d.status != "" {
+		sb.WriteString("\n" + d.status + "\n")
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	if len(pending) == 0 {
+		sb.WriteString("Esc: Close")
+	} else {
+		sb.WriteString("o: Allow once  a: Always allow  d: Deny  Esc: Close")
+	}
+	return sb.String()
+}
+
+func (d *ApprovalDialog) Size() (width, height int) {
+	return d.width, strings.Count(d.View(), "\n") + 3
+}
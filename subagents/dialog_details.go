@@ -1,8 +1,10 @@
 package subagents
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/crush/plugin"
 )
@@ -25,13 +27,21 @@ func SetSelectedAgent(name string) {
 
 // DetailsDialog shows details for a specific sub-agent.
 type DetailsDialog struct {
-	registry    *Registry
-	agent       *SubAgent
-	cursor      int // 0=View Prompt, 1=Toggle, 2=Reload, 3=Close
-	showPrompt  bool
-	promptScroll int
-	width       int
-	height      int
+	registry      *Registry
+	agent         *SubAgent
+	cursor        int // 0=View Prompt, 1=History, 2=Edit, 3=Toggle, 4=Reload, 5=Close
+	showPrompt    bool
+	promptScroll  int
+	showHistory   bool
+	history       []RunRecord
+	historyErr    error
+	historyScroll int
+	editing       bool
+	editFields    []newAgentField
+	editCursor    int
+	editErr       string
+	width         int
+	height        int
 }
 
 // NewDetailsDialog creates a new sub-agent details dialog.
@@ -78,6 +88,12 @@ func (d *DetailsDialog) Update(event plugin.DialogEvent) (done bool, action plug
 		if d.showPrompt {
 			return d.updatePromptView(e.Key)
 		}
+		if d.showHistory {
+			return d.updateHistoryView(e.Key)
+		}
+		if d.editing {
+			return d.updateEditView(e.Key)
+		}
 		return d.updateMainView(e.Key)
 	case plugin.ResizeEvent:
 		d.width = min(detailsDialogWidth, e.Width-10)
@@ -93,7 +109,7 @@ func (d *DetailsDialog) updateMainView(key string) (bool, plugin.PluginAction, e
 			d.cursor--
 		}
 	case "right", "l":
-		if d.cursor < 3 {
+		if d.cursor < 5 {
 			d.cursor++
 		}
 	case "enter", " ", "space":
@@ -103,6 +119,10 @@ func (d *DetailsDialog) updateMainView(key string) (bool, plugin.PluginAction, e
 	case "v":
 		d.showPrompt = true
 		d.promptScroll = 0
+	case "tab":
+		d.openHistory()
+	case "e":
+		d.openEdit()
 	case "t":
 		d.toggleAgent()
 	case "r":
@@ -125,21 +145,111 @@ func (d *DetailsDialog) updatePromptView(key string) (bool, plugin.PluginAction,
 	return false, plugin.NoAction{}, nil
 }
 
+func (d *DetailsDialog) updateHistoryView(key string) (bool, plugin.PluginAction, error) {
+	switch key {
+	case "esc", "q", "tab":
+		d.showHistory = false
+	case "up", "k":
+		if d.historyScroll > 0 {
+			d.historyScroll--
+		}
+	case "down", "j":
+		if d.historyScroll < len(d.history)-1 {
+			d.historyScroll++
+		}
+	}
+	return false, plugin.NoAction{}, nil
+}
+
 func (d *DetailsDialog) handleAction() (bool, plugin.PluginAction, error) {
 	switch d.cursor {
 	case 0: // View Prompt
 		d.showPrompt = true
 		d.promptScroll = 0
-	case 1: // Toggle
+	case 1: // History
+		d.openHistory()
+	case 2: // Edit
+		d.openEdit()
+	case 3: // Toggle
 		d.toggleAgent()
-	case 2: // Reload
+	case 4: // Reload
 		d.reloadAgent()
-	case 3: // Close
+	case 5: // Close
 		return true, plugin.NoAction{}, nil
 	}
 	return false, plugin.NoAction{}, nil
 }
 
+// openHistory loads the agent's recent runs and switches to the history tab.
+func (d *DetailsDialog) openHistory() {
+	d.history, d.historyErr = d.registry.history.Recent(d.agent.Name, DefaultHistoryLimit)
+	d.historyScroll = 0
+	d.showHistory = true
+}
+
+func (d *DetailsDialog) updateEditView(key string) (bool, plugin.PluginAction, error) {
+	switch key {
+	case "esc":
+		d.editing = false
+	case "tab", "down":
+		d.editCursor = (d.editCursor + 1) % len(d.editFields)
+	case "shift+tab", "up":
+		d.editCursor = (d.editCursor + len(d.editFields) - 1) % len(d.editFields)
+	case "enter":
+		if d.editCursor < len(d.editFields)-1 {
+			d.editCursor++
+			break
+		}
+		d.saveEdit()
+	case "ctrl+s":
+		d.saveEdit()
+	default:
+		d.editFields[d.editCursor].value = typeKey(d.editFields[d.editCursor].value, key)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+// openEdit switches to the edit form, filled from the agent's file.
+func (d *DetailsDialog) openEdit() {
+	edit, err := editFor(d.agent)
+	d.editErr = ""
+	if err != nil {
+		d.editErr = err.Error()
+	}
+	d.editFields = []newAgentField{
+		{label: "Name", hint: "lowercase-with-hyphens", value: edit.Name},
+		{label: "Description", hint: "when to delegate to this agent", value: edit.Description},
+		{label: "Tools", hint: "comma-separated, empty inherits all", value: edit.Tools},
+		{label: "Model", hint: "sonnet, opus, haiku, or empty to inherit", value: edit.Model},
+		{label: "Permission", hint: "default, acceptEdits, dontAsk, bypassPermissions, plan", value: edit.PermissionMode},
+	}
+	d.editCursor = 0
+	d.editing = true
+}
+
+// saveEdit writes the form back to the agent file and leaves edit mode.
+func (d *DetailsDialog) saveEdit() {
+	edit := AgentEdit{
+		Name:           d.editFields[0].value,
+		Description:    d.editFields[1].value,
+		Tools:          d.editFields[2].value,
+		Model:          d.editFields[3].value,
+		PermissionMode: d.editFields[4].value,
+	}
+	if err := d.registry.UpdateAgent(d.agent.Name, edit); err != nil {
+		d.editErr = err.Error()
+		return
+	}
+
+	name := strings.TrimSpace(edit.Name)
+	SetSelectedAgent(name)
+	if agent, ok := d.registry.Get(name); ok {
+		d.agent = agent
+	}
+	d.editErr = ""
+	d.editing = false
+}
+
 func (d *DetailsDialog) toggleAgent() {
 	d.registry.SetEnabled(d.agent.Name, !d.agent.Enabled)
 }
@@ -157,6 +267,12 @@ func (d *DetailsDialog) View() string {
 	if d.showPrompt {
 		return d.viewPrompt()
 	}
+	if d.showHistory {
+		return d.viewHistory()
+	}
+	if d.editing {
+		return d.viewEdit()
+	}
 	return d.viewDetails()
 }
 
@@ -186,6 +302,22 @@ func (d *DetailsDialog) viewDetails() string {
 	}
 	sb.WriteString(fmt.Sprintf("Tools: %s\n", tools))
 
+	if len(d.agent.Tags) > 0 {
+		sb.WriteString(fmt.Sprintf("Tags: %s\n", strings.Join(d.agent.Tags, ", ")))
+	}
+
+	// Versions.
+	if d.agent.Version != "" {
+		sb.WriteString(fmt.Sprintf("Version: %s\n", d.agent.Version))
+	}
+	if d.agent.MinCrushVersion != "" {
+		requires := d.agent.MinCrushVersion + " or newer"
+		if d.registry.incompatibility(d.agent) != "" {
+			requires += fmt.Sprintf(" (! running %s)", d.registry.crushVersion)
+		}
+		sb.WriteString(fmt.Sprintf("Requires Crush: %s\n", requires))
+	}
+
 	// Permission mode.
 	if d.agent.PermissionMode != "" {
 		sb.WriteString(fmt.Sprintf("Permission Mode: %s\n", d.agent.PermissionMode))
@@ -202,7 +334,7 @@ func (d *DetailsDialog) viewDetails() string {
 	sb.WriteString("\n")
 	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
 
-	buttons := []string{"View Prompt", "Toggle", "Reload", "Close"}
+	buttons := []string{"View Prompt", "History", "Edit", "Toggle", "Reload", "Close"}
 	var btnLine strings.Builder
 	for i, btn := range buttons {
 		if i == d.cursor {
@@ -212,7 +344,38 @@ func (d *DetailsDialog) viewDetails() string {
 		}
 	}
 	sb.WriteString(btnLine.String() + "\n")
-	sb.WriteString("←/→: Select  Enter: Action  v: View  t: Toggle  r: Reload  Esc: Back")
+	sb.WriteString("←/→: Select  Enter: Action  v: View  t: Toggle  r: Reload  Esc: Back\n")
+	sb.WriteString("Tab: History  e: Edit")
+
+	return sb.String()
+}
+
+func (d *DetailsDialog) viewEdit() string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("Edit %s\n\n", shortenPath(d.agent.FilePath)))
+	for i, field := range d.editFields {
+		cursor := "  "
+		value := field.value
+		if i == d.editCursor {
+			cursor = "> "
+			value += "_"
+		}
+		sb.WriteString(fmt.Sprintf("%s%-12s %s\n", cursor, field.label+":", value))
+		sb.WriteString(fmt.Sprintf("  %-12s (%s)\n", "", field.hint))
+	}
+
+	if d.editErr != "" {
+		errLine := "Error: " + d.editErr
+		if len(errLine) > d.width-4 {
+			errLine = errLine[:d.width-7] + "..."
+		}
+		sb.WriteString("\n" + errLine + "\n")
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	sb.WriteString("Tab/↑/↓: Field  Enter: Next/Save  Ctrl+S: Save  Esc: Cancel")
 
 	return sb.String()
 }
@@ -250,6 +413,49 @@ func (d *DetailsDialog) viewPrompt() string {
 	return sb.String()
 }
 
+func (d *DetailsDialog) viewHistory() string {
+	var sb strings.Builder
+
+	sb.WriteString("Recent Runs (↑/↓ to scroll, Esc to close)\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n\n")
+
+	if d.historyErr != nil {
+		sb.WriteString(fmt.Sprintf("Failed to read history: %v\n", d.historyErr))
+		return sb.String()
+	}
+	if len(d.history) == 0 {
+		sb.WriteString("No runs recorded yet.\n")
+		return sb.String()
+	}
+
+	maxLines := d.height - 6
+	endLine := min(d.historyScroll+maxLines, len(d.history))
+	for _, rec := range d.history[d.historyScroll:endLine] {
+		status := "ok"
+		if !rec.Succeeded() {
+			status = "error"
+		}
+		line := fmt.Sprintf("%s  %-5s %8s  %6d tok  %s",
+			rec.Time.Local().Format("01-02 15:04"),
+			status,
+			(time.Duration(rec.DurationMS) * time.Millisecond).Round(100*time.Millisecond),
+			rec.Tokens,
+			strings.ReplaceAll(rec.Prompt, "\n", " "),
+		)
+		if len(line) > d.width-4 {
+			line = line[:d.width-7] + "..."
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	// Scroll indicator.
+	if len(d.history) > maxLines {
+		sb.WriteString(fmt.Sprintf("\n[%d-%d of %d runs]", d.historyScroll+1, endLine, len(d.history)))
+	}
+
+	return sb.String()
+}
+
 func (d *DetailsDialog) Size() (width, height int) {
 	return d.width, d.height
 }
@@ -271,6 +477,22 @@ func init() {
 		return NewDetailsDialog(app)
 	})
 
+	plugin.RegisterDialog(NewDialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewNewAgentDialog(app)
+	})
+
+	plugin.RegisterDialog(RunDialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewRunDialog(app)
+	})
+
+	plugin.RegisterDialog(ApprovalDialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewApprovalDialog(app)
+	})
+
+	plugin.RegisterDialog(FixturesDialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewFixturesDialog(app)
+	})
+
 	// Register the command to open the list dialog.
 	plugin.RegisterCommand(
 		plugin.PluginCommand{
@@ -282,4 +504,59 @@ func init() {
 			return plugin.OpenDialogAction{DialogID: ListDialogID}
 		},
 	)
+
+	// Register the command to refresh remote agent sources.
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "subagents-sync",
+			Title:       "Sync SubAgent Sources",
+			Description: "Fetch remote sub-agent sources and reload agents",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			if registry := getRegistry(); registry != nil {
+				go func() {
+					if err := registry.SyncSources(context.Background()); err != nil {
+						registry.logger.Warn("failed to sync agent sources", "error", err)
+					}
+				}()
+			}
+			return plugin.NoAction{}
+		},
+	)
+
+	// Register the command to scaffold a new agent file.
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "subagents-new",
+			Title:       "New SubAgent",
+			Description: "Create a sub-agent definition file",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			return plugin.OpenDialogAction{DialogID: NewDialogID}
+		},
+	)
+
+	// Register the command to answer sub-agent tool requests.
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "subagents-approve",
+			Title:       "Approve SubAgent Tools",
+			Description: "Answer sub-agent requests for disallowed tools",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			return plugin.OpenDialogAction{DialogID: ApprovalDialogID}
+		},
+	)
+
+	// Register the command to run agent fixtures.
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "subagents-test",
+			Title:       "Test SubAgents",
+			Description: "Run sub-agents against their test fixtures",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			return plugin.OpenDialogAction{DialogID: FixturesDialogID}
+		},
+	)
 }
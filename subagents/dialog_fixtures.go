@@ -0,0 +1,152 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// FixturesDialogID is the identifier for the fixture test dialog.
+	FixturesDialogID = "subagents-test"
+
+	fixturesDialogWidth  = 80
+	fixturesDialogHeight = 24
+)
+
+// FixturesDialog runs every agent's fixtures and shows the report.
+type FixturesDialog struct {
+	registry *Registry
+	scroll   int
+	width    int
+	height   int
+
+	mu      sync.Mutex
+	running bool
+	report  string
+	cancel  context.CancelFunc
+}
+
+// NewFixturesDialog creates the fixture dialog and starts the test run.
+func NewFixturesDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	registry := getRegistry()
+	if registry == nil {
+		return nil, fmt.Errorf("subagents registry not initialized")
+	}
+	d := newFixturesDialog(registry)
+	d.start()
+	return d, nil
+}
+
+func newFixturesDialog(registry *Registry) *FixturesDialog {
+	return &FixturesDialog{
+		registry: registry,
+		width:    fixturesDialogWidth,
+		height:   fixturesDialogHeight,
+	}
+}
+
+func (d *FixturesDialog) ID() string {
+	return FixturesDialogID
+}
+
+func (d *FixturesDialog) Title() string {
+	return "SubAgent Tests"
+}
+
+func (d *FixturesDialog) Init() error {
+	return nil
+}
+
+// start runs all fixtures in the background.
+func (d *FixturesDialog) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.running, d.report, d.cancel = true, "", cancel
+	d.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		results, err := d.registry.RunFixtures(ctx, nil)
+		report := formatFixtureReport(results)
+		if err != nil {
+			report += "\n\nError: " + err.Error()
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.running, d.report, d.cancel = false, report, nil
+	}()
+}
+
+func (d *FixturesDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		d.mu.Lock()
+		running := d.running
+		d.mu.Unlock()
+
+		switch e.Key {
+		case "esc", "q":
+			d.mu.Lock()
+			if d.cancel != nil {
+				d.cancel()
+			}
+			d.mu.Unlock()
+			return true, plugin.NoAction{}, nil
+		case "up", "k":
+			if d.scroll > 0 {
+				d.scroll--
+			}
+		case "down", "j":
+			d.scroll++
+		case "r":
+			if !running {
+				d.scroll = 0
+				d.start()
+			}
+		}
+	case plugin.ResizeEvent:
+		d.width = min(fixturesDialogWidth, e.Width-10)
+		d.height = min(fixturesDialogHeight, e.Height-6)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+func (d *FixturesDialog) View() string {
+	d.mu.Lock()
+	running, report := d.running, d.report
+	d.mu.Unlock()
+
+	var sb strings.Builder
+	if running {
+		sb.WriteString("Running fixtures...\n")
+	} else {
+		lines := strings.Split(report, "\n")
+		maxLines := max(1, d.height-6)
+		d.scroll = min(d.scroll, max(0, len(lines)-maxLines))
+		end := min(d.scroll+maxLines, len(lines))
+		for _, line := range lines[d.scroll:end] {
+			if len(line) > d.width-4 {
+				line = line[:d.width-7] + "..."
+			}
+			sb.WriteString(line + "\n")
+		}
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	if running {
+		sb.WriteString("Esc: Cancel")
+	} else {
+		sb.WriteString("↑/↓: Scroll  r: Run again  Esc: Close")
+	}
+	return sb.String()
+}
+
+func (d *FixturesDialog) Size() (width, height int) {
+	return d.width, d.height
+}
@@ -3,8 +3,10 @@ package subagents
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/crush/plugin"
 )
@@ -15,15 +17,39 @@ const (
 
 	listDialogWidth  = 70
 	listDialogHeight = 24
+
+	// listChromeLines is the height of everything but agent rows and group
+	// headers: title, column header, page indicator, status, and footer.
+	listChromeLines = 13
+)
+
+// Source groups, in display order.
+const (
+	groupProject = "Project"
+	groupHome    = "Home"
+	groupRemote  = "Remote"
+	groupOther   = "Other"
 )
 
-// ListDialog shows all available sub-agents.
+var groupOrder = map[string]int{groupProject: 0, groupHome: 1, groupRemote: 2, groupOther: 3}
+
+// ListDialog shows all available sub-agents, grouped by source and paged.
 type ListDialog struct {
-	registry *Registry
-	agents   []*SubAgent
-	cursor   int
-	width    int
-	height   int
+	registry      *Registry
+	all           []*SubAgent // Every loaded agent
+	agents        []*SubAgent // Agents matching the filter, in display order
+	cursor        int
+	filter        string
+	filtering     bool   // Typing into the filter
+	confirmDelete bool   // Waiting for y/n to delete the current agent
+	tagAction     string // "enable" or "disable" while typing a tag
+	tagInput      string
+	showIssues    bool // Showing validation results
+	issues        []AgentIssue
+	issuesScroll  int
+	status        string // Result of the last duplicate or delete
+	width         int
+	height        int
 }
 
 // NewListDialog creates a new sub-agents list dialog.
@@ -33,19 +59,14 @@ func NewListDialog(app *plugin.App) (plugin.PluginDialog, error) {
 		return nil, fmt.Errorf("subagents registry not initialized")
 	}
 
-	agents := registry.List()
-	// Sort by name for consistent ordering.
-	sort.Slice(agents, func(i, j int) bool {
-		return agents[i].Name < agents[j].Name
-	})
-
-	return &ListDialog{
+	d := &ListDialog{
 		registry: registry,
-		agents:   agents,
 		cursor:   0,
 		width:    listDialogWidth,
 		height:   listDialogHeight,
-	}, nil
+	}
+	d.refresh()
+	return d, nil
 }
 
 func (d *ListDialog) ID() string {
@@ -63,6 +84,28 @@ func (d *ListDialog) Init() error {
 func (d *ListDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
 	switch e := event.(type) {
 	case plugin.KeyEvent:
+		if d.filtering {
+			d.updateFilter(e.Key)
+			return false, plugin.NoAction{}, nil
+		}
+		if d.tagAction != "" {
+			d.updateTagInput(e.Key)
+			return false, plugin.NoAction{}, nil
+		}
+		if d.showIssues {
+			d.updateIssuesView(e.Key)
+			return false, plugin.NoAction{}, nil
+		}
+		if d.confirmDelete {
+			d.confirmDelete = false
+			if e.Key == "y" {
+				d.deleteCurrent()
+			} else {
+				d.status = ""
+			}
+			return false, plugin.NoAction{}, nil
+		}
+
 		switch e.Key {
 		case "up", "k":
 			if d.cursor > 0 {
@@ -72,6 +115,12 @@ func (d *ListDialog) Update(event plugin.DialogEvent) (done bool, action plugin.
 			if d.cursor < len(d.agents)-1 {
 				d.cursor++
 			}
+		case "pgup", "left":
+			d.cursor = max(0, d.cursor-d.pageSize())
+		case "pgdown", "right":
+			d.cursor = max(0, min(len(d.agents)-1, d.cursor+d.pageSize()))
+		case "/":
+			d.filtering = true
 		case "enter":
 			if len(d.agents) > 0 && d.cursor < len(d.agents) {
 				// Set selected agent and open details dialog.
@@ -82,9 +131,37 @@ func (d *ListDialog) Update(event plugin.DialogEvent) (done bool, action plugin.
 			if len(d.agents) > 0 {
 				d.toggleCurrent()
 			}
+		case "n":
+			return false, plugin.OpenDialogAction{DialogID: NewDialogID}, nil
+		case "a":
+			return false, plugin.OpenDialogAction{DialogID: ApprovalDialogID}, nil
 		case "r":
 			d.reloadAll()
-		case "esc", "q":
+		case "v":
+			d.issues = d.registry.Validate()
+			d.issuesScroll = 0
+			d.showIssues = true
+		case "d":
+			d.duplicateCurrent()
+		case "p":
+			d.promoteCurrent()
+		case "+":
+			d.tagAction, d.tagInput, d.status = "enable", "", ""
+		case "-":
+			d.tagAction, d.tagInput, d.status = "disable", "", ""
+		case "x":
+			if len(d.agents) > 0 {
+				d.confirmDelete = true
+				d.status = fmt.Sprintf("Delete %s? y/n", d.agents[d.cursor].Name)
+			}
+		case "esc":
+			if d.filter != "" {
+				d.filter = ""
+				d.applyFilter()
+				break
+			}
+			return true, plugin.NoAction{}, nil
+		case "q":
 			return true, plugin.NoAction{}, nil
 		}
 	case plugin.ResizeEvent:
@@ -101,34 +178,247 @@ func (d *ListDialog) toggleCurrent() {
 	}
 }
 
+// duplicateCurrent copies the current agent and moves the cursor to the copy.
+func (d *ListDialog) duplicateCurrent() {
+	if d.cursor >= len(d.agents) {
+		return
+	}
+	name, err := d.registry.DuplicateAgent(d.agents[d.cursor].Name)
+	if err != nil {
+		d.status = "Error: " + err.Error()
+		return
+	}
+	d.refresh()
+	for i, agent := range d.agents {
+		if agent.Name == name {
+			d.cursor = i
+		}
+	}
+	d.status = "Created " + name
+}
+
+// promoteCurrent copies the current agent into the project's agent dir.
+func (d *ListDialog) promoteCurrent() {
+	if d.cursor >= len(d.agents) {
+		return
+	}
+	name := d.agents[d.cursor].Name
+	path, err := d.registry.PromoteAgent(name)
+	if err != nil {
+		d.status = "Error: " + err.Error()
+		return
+	}
+	d.refresh()
+	for i, agent := range d.agents {
+		if agent.Name == name {
+			d.cursor = i
+		}
+	}
+	d.status = "Promoted " + name + " to " + shortenPath(path)
+}
+
+// deleteCurrent removes the current agent's file.
+func (d *ListDialog) deleteCurrent() {
+	if d.cursor >= len(d.agents) {
+		return
+	}
+	name := d.agents[d.cursor].Name
+	if err := d.registry.DeleteAgent(name); err != nil {
+		d.status = "Error: " + err.Error()
+		return
+	}
+	d.refresh()
+	d.status = "Deleted " + name
+}
+
 func (d *ListDialog) reloadAll() {
 	d.registry.ReloadAll()
-	d.agents = d.registry.List()
-	sort.Slice(d.agents, func(i, j int) bool {
-		return d.agents[i].Name < d.agents[j].Name
+	d.refresh()
+}
+
+func (d *ListDialog) updateIssuesView(key string) {
+	switch key {
+	case "esc", "q", "v":
+		d.showIssues = false
+	case "up", "k":
+		if d.issuesScroll > 0 {
+			d.issuesScroll--
+		}
+	case "down", "j":
+		if d.issuesScroll < len(d.issues)-1 {
+			d.issuesScroll++
+		}
+	}
+}
+
+// updateTagInput edits the tag to enable or disable. Enter applies it to
+// every agent with the tag, Esc cancels.
+func (d *ListDialog) updateTagInput(key string) {
+	switch key {
+	case "enter":
+		action, tag := d.tagAction, strings.TrimSpace(d.tagInput)
+		d.tagAction = ""
+		if tag == "" {
+			return
+		}
+		n, err := d.registry.SetTagEnabled(tag, action == "enable")
+		if err != nil {
+			d.status = "Error: " + err.Error()
+			return
+		}
+		d.status = fmt.Sprintf("%sd %d agent(s) tagged %s", action, n, tag)
+	case "esc":
+		d.tagAction = ""
+	default:
+		d.tagInput = typeKey(d.tagInput, key)
+	}
+}
+
+// updateFilter edits the filter while typing. Enter keeps it, Esc clears it.
+func (d *ListDialog) updateFilter(key string) {
+	switch key {
+	case "enter":
+		d.filtering = false
+	case "esc":
+		d.filtering = false
+		d.filter = ""
+	default:
+		d.filter = typeKey(d.filter, key)
+	}
+	d.applyFilter()
+}
+
+// refresh re-reads the agent list from the registry.
+func (d *ListDialog) refresh() {
+	d.all = d.registry.List()
+	sort.Slice(d.all, func(i, j int) bool {
+		gi, gj := groupOrder[d.registry.sourceGroup(d.all[i])], groupOrder[d.registry.sourceGroup(d.all[j])]
+		if gi != gj {
+			return gi < gj
+		}
+		return d.all[i].Name < d.all[j].Name
 	})
+	d.applyFilter()
+}
+
+// applyFilter selects the agents matching the filter.
+func (d *ListDialog) applyFilter() {
+	d.agents = d.agents[:0]
+	for _, agent := range d.all {
+		if fuzzyMatch(d.filter, agent.Name) || fuzzyMatch(d.filter, agent.Description) || agent.hasAnyTag([]string{d.filter}) {
+			d.agents = append(d.agents, agent)
+		}
+	}
 	if d.cursor >= len(d.agents) {
 		d.cursor = max(0, len(d.agents)-1)
 	}
 }
 
+// pageSize returns how many agents fit on one page, leaving room for up to
+// one header per source group.
+func (d *ListDialog) pageSize() int {
+	return max(3, d.height-listChromeLines-len(groupOrder))
+}
+
+// fuzzyMatch reports whether the characters of pattern appear in s in
+// order, ignoring case.
+func fuzzyMatch(pattern, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// sourceGroup classifies where an agent's file comes from.
+func (r *Registry) sourceGroup(agent *SubAgent) string {
+	return r.sourceGroupOf(agent.FilePath)
+}
+
+// sourceGroupOf classifies where the agent file at path comes from.
+func (r *Registry) sourceGroupOf(path string) string {
+	if r.isCached(path) {
+		return groupRemote
+	}
+	if within(r.workingDir, path) {
+		return groupProject
+	}
+	if home, err := userHomeDir(); err == nil && within(home, path) {
+		return groupHome
+	}
+	return groupOther
+}
+
+// within reports whether path lies inside dir.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func (d *ListDialog) View() string {
+	if d.showIssues {
+		return d.viewIssues()
+	}
+
 	var sb strings.Builder
 
-	sb.WriteString("Manage custom sub-agents\n\n")
+	sb.WriteString("Manage custom sub-agents\n")
+	switch {
+	case d.tagAction != "":
+		line := fmt.Sprintf("%s agents tagged: %s_", d.tagAction, d.tagInput)
+		if tags := d.registry.Tags(); len(tags) > 0 {
+			line += " (" + strings.Join(tags, ", ") + ")"
+		}
+		if len(line) > d.width-4 {
+			line = line[:d.width-7] + "..."
+		}
+		sb.WriteString(strings.ToUpper(line[:1]) + line[1:] + "\n")
+	case d.filtering:
+		sb.WriteString("Filter: " + d.filter + "_\n")
+	case d.filter != "":
+		sb.WriteString(fmt.Sprintf("Filter: %s (%d of %d)\n", d.filter, len(d.agents), len(d.all)))
+	default:
+		if n := len(d.registry.PendingApprovals()); n > 0 {
+			sb.WriteString(fmt.Sprintf("%d tool request(s) waiting for approval, press a to answer\n", n))
+		} else {
+			sb.WriteString("\n")
+		}
+	}
 
-	if len(d.agents) == 0 {
+	if len(d.all) > 0 && len(d.agents) == 0 {
+		sb.WriteString("\n  No sub-agents match the filter.\n")
+	} else if len(d.agents) == 0 {
 		sb.WriteString("  No sub-agents found.\n\n")
 		sb.WriteString("  Create agent files (.md) in:\n")
 		for _, dir := range d.registry.cfg.Dirs {
 			sb.WriteString(fmt.Sprintf("    - %s\n", dir))
 		}
+		sb.WriteString("\n  Press n to create one.\n")
 	} else {
 		// Calculate column widths.
 		maxNameLen := 20
-		maxDirLen := d.width - maxNameLen - 12 // checkbox, spacing, etc.
+		maxDirLen := d.width - maxNameLen - 34 // checkbox, usage, spacing, etc.
+
+		header := fmt.Sprintf("      %-*s %4s %7s %8s  %s", maxNameLen, "NAME", "RUNS", "TOKENS", "COST", "FILE")
+		sb.WriteString(header + "\n")
+
+		pageSize := d.pageSize()
+		page := d.cursor / pageSize
+		start := page * pageSize
+		end := min(start+pageSize, len(d.agents))
+
+		group := ""
+		for i := start; i < end; i++ {
+			agent := d.agents[i]
+			if g := d.registry.sourceGroup(agent); g != group {
+				group = g
+				sb.WriteString(group + "\n")
+			}
 
-		for i, agent := range d.agents {
 			name := agent.Name
 			if len(name) > maxNameLen {
 				name = name[:maxNameLen-3] + "..."
@@ -149,25 +439,86 @@ func (d *ListDialog) View() string {
 			if agent.Enabled {
 				checkboxDisplay = "[x]"
 			}
+			marker := " "
+			if d.registry.incompatibility(agent) != "" {
+				marker = "!"
+			} else if len(d.registry.Shadowed(agent.Name)) > 0 {
+				marker = "*"
+			}
 
-			line := fmt.Sprintf("%s%s %-*s  %s", cursor, checkboxDisplay, maxNameLen, name, dir)
+			usage := d.registry.Usage(agent.Name)
+			line := fmt.Sprintf("%s%s%s%-*s %4d %7s %8s  %s", cursor, checkboxDisplay, marker, maxNameLen, name,
+				usage.Runs, formatTokens(usage.Tokens), fmt.Sprintf("$%.4f", usage.CostUSD), dir)
 			sb.WriteString(line + "\n")
 		}
+
+		if pages := (len(d.agents) + pageSize - 1) / pageSize; pages > 1 {
+			sb.WriteString(fmt.Sprintf("\n[page %d of %d]\n", page+1, pages))
+		}
+	}
+
+	status := d.status
+	if status == "" && d.cursor < len(d.agents) {
+		if reason := d.registry.incompatibility(d.agents[d.cursor]); reason != "" {
+			status = "! " + d.agents[d.cursor].Name + " " + reason
+		} else if shadowed := d.registry.Shadowed(d.agents[d.cursor].Name); len(shadowed) > 0 {
+			status = "* Shadows " + shortenPath(shadowed[0])
+			if len(shadowed) > 1 {
+				status += fmt.Sprintf(" and %d more", len(shadowed)-1)
+			}
+		}
+	}
+	if status != "" {
+		if len(status) > d.width-4 {
+			status = status[:d.width-7] + "..."
+		}
+		sb.WriteString("\n" + status + "\n")
 	}
 
 	// Footer with help.
 	sb.WriteString("\n")
 	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
-	sb.WriteString("↑/↓: Navigate  Enter: Details  Space: Toggle  r: Reload  Esc: Close")
+	if d.filtering {
+		sb.WriteString("Type to filter  Enter: Apply  Esc: Clear")
+	} else if d.tagAction != "" {
+		sb.WriteString("Type a tag  Enter: Apply  Esc: Cancel")
+	} else {
+		sb.WriteString("↑/↓: Navigate  ←/→: Page  Enter: Details  Space: Toggle  r: Reload\n/: Filter  n: New  d: Duplicate  x: Delete  v: Validate  Esc: Close\np: Promote to project  +/-: Enable/disable by tag  a: Approvals")
+	}
 
 	return sb.String()
 }
 
-func (d *ListDialog) Size() (width, height int) {
-	contentHeight := 5 + len(d.agents) // Header + agents + footer
-	if len(d.agents) == 0 {
-		contentHeight = 10 // Space for "no agents" message
+func (d *ListDialog) viewIssues() string {
+	var sb strings.Builder
+
+	sb.WriteString("Validation (↑/↓ to scroll, Esc to close)\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n\n")
+
+	if len(d.issues) == 0 {
+		sb.WriteString("All agent files are valid.\n")
+		return sb.String()
+	}
+
+	maxLines := d.height - 6
+	end := min(d.issuesScroll+maxLines, len(d.issues))
+	for _, issue := range d.issues[d.issuesScroll:end] {
+		issue.Path = shortenPath(issue.Path)
+		line := issue.String()
+		if len(line) > d.width-4 {
+			line = line[:d.width-7] + "..."
+		}
+		sb.WriteString(line + "\n")
 	}
+	if len(d.issues) > maxLines {
+		sb.WriteString(fmt.Sprintf("\n[%d-%d of %d issues]", d.issuesScroll+1, end, len(d.issues)))
+	}
+
+	return sb.String()
+}
+
+func (d *ListDialog) Size() (width, height int) {
+	contentHeight := strings.Count(d.View(), "\n") + 1
 	return d.width, min(contentHeight, d.height)
 }
 
@@ -180,6 +531,18 @@ func (d *ListDialog) GetSelectedAgent() string {
 	return ""
 }
 
+// formatTokens renders a token count compactly, e.g. 950, 12.3k, 1.2M.
+func formatTokens(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1_000)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
+
 // shortenPath replaces home directory with ~ for display.
 func shortenPath(path string) string {
 	home, err := userHomeDir()
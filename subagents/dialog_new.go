@@ -0,0 +1,178 @@
+package subagents
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// NewDialogID is the identifier for the new sub-agent dialog.
+	NewDialogID = "subagents-new"
+
+	newDialogWidth  = 70
+	newDialogHeight = 16
+)
+
+// newAgentField is one editable input in the new agent form.
+type newAgentField struct {
+	label string
+	hint  string
+	value string
+}
+
+// NewAgentDialog prompts for agent metadata and writes a template file.
+type NewAgentDialog struct {
+	registry *Registry
+	fields   []newAgentField
+	cursor   int // Index of the focused field
+	err      string
+	width    int
+	height   int
+}
+
+// NewNewAgentDialog creates a new sub-agent creation dialog.
+func NewNewAgentDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	registry := getRegistry()
+	if registry == nil {
+		return nil, fmt.Errorf("subagents registry not initialized")
+	}
+	return newAgentDialog(registry), nil
+}
+
+func newAgentDialog(registry *Registry) *NewAgentDialog {
+	return &NewAgentDialog{
+		registry: registry,
+		fields: []newAgentField{
+			{label: "Name", hint: "lowercase-with-hyphens"},
+			{label: "Description", hint: "when to delegate to this agent"},
+			{label: "Tools", hint: "comma-separated, empty inherits all"},
+			{label: "Model", hint: "sonnet, opus, haiku, or empty to inherit"},
+		},
+		width:  newDialogWidth,
+		height: newDialogHeight,
+	}
+}
+
+func (d *NewAgentDialog) ID() string {
+	return NewDialogID
+}
+
+func (d *NewAgentDialog) Title() string {
+	return "New SubAgent"
+}
+
+func (d *NewAgentDialog) Init() error {
+	return nil
+}
+
+func (d *NewAgentDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		switch e.Key {
+		case "esc":
+			return true, plugin.NoAction{}, nil
+		case "tab", "down":
+			d.cursor = (d.cursor + 1) % len(d.fields)
+		case "shift+tab", "up":
+			d.cursor = (d.cursor + len(d.fields) - 1) % len(d.fields)
+		case "enter":
+			if d.cursor < len(d.fields)-1 {
+				d.cursor++
+				return false, plugin.NoAction{}, nil
+			}
+			return d.create()
+		case "ctrl+s":
+			return d.create()
+		default:
+			d.fields[d.cursor].value = typeKey(d.fields[d.cursor].value, e.Key)
+		}
+	case plugin.ResizeEvent:
+		d.width = min(newDialogWidth, e.Width-10)
+		d.height = min(newDialogHeight, e.Height-6)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+// typeKey applies a key press to a text input value.
+func typeKey(value, key string) string {
+	switch key {
+	case "backspace":
+		if value != "" {
+			_, size := utf8.DecodeLastRuneInString(value)
+			value = value[:len(value)-size]
+		}
+	case "space":
+		value += " "
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			value += key
+		}
+	}
+	return value
+}
+
+// spec returns the agent spec built from the current field values.
+func (d *NewAgentDialog) spec() NewAgentSpec {
+	return NewAgentSpec{
+		Name:        d.fields[0].value,
+		Description: d.fields[1].value,
+		Tools:       d.fields[2].value,
+		Model:       d.fields[3].value,
+	}
+}
+
+// create writes the agent file and opens its details on success.
+func (d *NewAgentDialog) create() (bool, plugin.PluginAction, error) {
+	spec := d.spec()
+	if _, err := d.registry.CreateAgent(spec); err != nil {
+		d.err = err.Error()
+		return false, plugin.NoAction{}, nil
+	}
+
+	d.err = ""
+	SetSelectedAgent(strings.TrimSpace(spec.Name))
+	return false, plugin.OpenDialogAction{DialogID: DetailsDialogID}, nil
+}
+
+func (d *NewAgentDialog) View() string {
+	var sb strings.Builder
+
+	if dir, ok := d.registry.localDir(); ok {
+		sb.WriteString(fmt.Sprintf("Create an agent file in %s\n\n", dir))
+	} else {
+		sb.WriteString("No local agent dir configured.\n\n")
+	}
+
+	for i, field := range d.fields {
+		cursor := "  "
+		value := field.value
+		if i == d.cursor {
+			cursor = "> "
+			value += "_"
+		}
+		sb.WriteString(fmt.Sprintf("%s%-12s %s\n", cursor, field.label+":", value))
+		sb.WriteString(fmt.Sprintf("  %-12s (%s)\n", "", field.hint))
+	}
+
+	if d.err != "" {
+		errLine := "Error: " + d.err
+		if len(errLine) > d.width-4 {
+			errLine = errLine[:d.width-7] + "..."
+		}
+		sb.WriteString("\n" + errLine + "\n")
+	}
+
+	// Footer with help.
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	sb.WriteString("Tab/↑/↓: Field  Enter: Next/Create  Ctrl+S: Create  Esc: Cancel")
+
+	return sb.String()
+}
+
+func (d *NewAgentDialog) Size() (width, height int) {
+	return d.width, d.height
+}
@@ -0,0 +1,258 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// RunDialogID is the identifier for the sub-agent run dialog.
+	RunDialogID = "subagents-run"
+
+	runDialogWidth  = 80
+	runDialogHeight = 24
+)
+
+// RunDialog prompts for a task and runs the selected agent directly,
+// showing its reply when the run completes. The prompt can be previewed
+// first to see exactly what the agent would receive.
+type RunDialog struct {
+	registry *Registry
+	agent    *SubAgent
+	prompt   string
+	preview  string // Rendered preview while previewing, empty otherwise
+	scroll   int
+	width    int
+	height   int
+
+	mu      sync.Mutex
+	running bool
+	done    bool
+	result  string
+	err     error
+	cancel  context.CancelFunc
+}
+
+// NewRunDialog creates a run dialog for the selected agent.
+func NewRunDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	registry := getRegistry()
+	if registry == nil {
+		return nil, fmt.Errorf("subagents registry not initialized")
+	}
+
+	name := selectedAgentName
+	if name == "" {
+		return nil, fmt.Errorf("no agent selected")
+	}
+
+	agent, ok := registry.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("agent not found: %s", name)
+	}
+	return newRunDialog(registry, agent), nil
+}
+
+func newRunDialog(registry *Registry, agent *SubAgent) *RunDialog {
+	return &RunDialog{
+		registry: registry,
+		agent:    agent,
+		width:    runDialogWidth,
+		height:   runDialogHeight,
+	}
+}
+
+func (d *RunDialog) ID() string {
+	return RunDialogID
+}
+
+func (d *RunDialog) Title() string {
+	return "Run " + d.agent.Name
+}
+
+func (d *RunDialog) Init() error {
+	return nil
+}
+
+func (d *RunDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		d.mu.Lock()
+		running, finished := d.running, d.done
+		d.mu.Unlock()
+
+		switch {
+		case e.Key == "esc":
+			d.mu.Lock()
+			if d.cancel != nil {
+				d.cancel()
+			}
+			d.mu.Unlock()
+			return true, plugin.NoAction{}, nil
+		case running:
+		case finished:
+			d.updateResultView(e.Key)
+		case d.preview != "":
+			d.updatePreviewView(e.Key)
+		default:
+			d.updatePromptInput(e.Key)
+		}
+	case plugin.ResizeEvent:
+		d.width = min(runDialogWidth, e.Width-10)
+		d.height = min(runDialogHeight, e.Height-6)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+func (d *RunDialog) updatePromptInput(key string) {
+	switch key {
+	case "enter":
+		d.start()
+	case "ctrl+p":
+		d.openPreview()
+	default:
+		d.prompt = typeKey(d.prompt, key)
+	}
+}
+
+func (d *RunDialog) updatePreviewView(key string) {
+	switch key {
+	case "up", "k":
+		if d.scroll > 0 {
+			d.scroll--
+		}
+	case "down", "j":
+		d.scroll++
+	case "ctrl+p":
+		d.preview = ""
+		d.scroll = 0
+	case "enter":
+		d.preview = ""
+		d.scroll = 0
+		d.start()
+	}
+}
+
+// openPreview renders what running the current prompt would send.
+func (d *RunDialog) openPreview() {
+	preview, err := d.registry.Preview(context.Background(), d.agent.Name, strings.TrimSpace(d.prompt))
+	if err != nil {
+		preview = "Error: " + err.Error()
+	}
+	d.preview = preview
+	d.scroll = 0
+}
+
+func (d *RunDialog) updateResultView(key string) {
+	switch key {
+	case "up", "k":
+		if d.scroll > 0 {
+			d.scroll--
+		}
+	case "down", "j":
+		d.scroll++
+	case "enter":
+		// Start over with a new prompt.
+		d.mu.Lock()
+		d.done, d.result, d.err = false, "", nil
+		d.mu.Unlock()
+		d.prompt = ""
+		d.scroll = 0
+	}
+}
+
+// start dispatches the run in the background.
+func (d *RunDialog) start() {
+	prompt := strings.TrimSpace(d.prompt)
+	if prompt == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.running = true
+	d.cancel = cancel
+	d.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		result, err := d.registry.Run(ctx, d.agent.Name, prompt)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.running, d.done = false, true
+		d.result, d.err = result, err
+		d.cancel = nil
+	}()
+}
+
+func (d *RunDialog) View() string {
+	d.mu.Lock()
+	running, finished, result, runErr := d.running, d.done, d.result, d.err
+	d.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString(d.agent.Description + "\n\n")
+	sb.WriteString("Prompt: " + d.prompt)
+	if !running && !finished && d.preview == "" {
+		sb.WriteString("_")
+	}
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+
+	footer := "Enter: Run  Ctrl+P: Preview  Esc: Cancel"
+	switch {
+	case running:
+		sb.WriteString("Running...\n")
+		footer = "Esc: Cancel run"
+	case finished && runErr != nil:
+		sb.WriteString("Error: " + runErr.Error() + "\n")
+		footer = "Enter: New prompt  Esc: Close"
+	case finished:
+		sb.WriteString(d.viewResult(result))
+		footer = "↑/↓: Scroll  Enter: New prompt  Esc: Close"
+	case d.preview != "":
+		sb.WriteString(d.viewResult(d.preview))
+		footer = "↑/↓: Scroll  Enter: Run  Ctrl+P: Edit prompt  Esc: Close"
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	sb.WriteString(footer)
+	return sb.String()
+}
+
+// viewResult renders the visible window of the agent's reply.
+func (d *RunDialog) viewResult(result string) string {
+	var sb strings.Builder
+
+	lines := strings.Split(result, "\n")
+	maxLines := max(1, d.height-10)
+
+	startLine := d.scroll
+	if startLine > len(lines)-maxLines {
+		startLine = max(0, len(lines)-maxLines)
+		d.scroll = startLine
+	}
+
+	endLine := min(startLine+maxLines, len(lines))
+	for i := startLine; i < endLine; i++ {
+		line := lines[i]
+		if len(line) > d.width-4 {
+			line = line[:d.width-7] + "..."
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	if len(lines) > maxLines {
+		sb.WriteString(fmt.Sprintf("\n[%d-%d of %d lines]", startLine+1, endLine, len(lines)))
+	}
+	return sb.String()
+}
+
+func (d *RunDialog) Size() (width, height int) {
+	return d.width, d.height
+}
@@ -0,0 +1,213 @@
+package subagents
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aleksclark/crush-modules/frontmatter"
+	"gopkg.in/yaml.v3"
+)
+
+// AgentEdit holds the frontmatter fields editable from the details dialog.
+type AgentEdit struct {
+	Name           string
+	Description    string
+	Tools          string // Comma-separated, empty inherits all
+	Model          string // Empty defaults to inherit
+	PermissionMode string // Empty for the default
+}
+
+// editFor returns the editable fields as written in the agent's file, so
+// values inherited through extends are not copied into it.
+func editFor(agent *SubAgent) (AgentEdit, error) {
+	raw, err := LoadAgentFile(agent.FilePath)
+	if err != nil {
+		return AgentEdit{}, err
+	}
+	return AgentEdit{
+		Name:           raw.Name,
+		Description:    raw.Description,
+		Tools:          strings.Join(raw.ToolsRaw, ", "),
+		Model:          raw.Model,
+		PermissionMode: raw.PermissionMode,
+	}, nil
+}
+
+// UpdateAgent writes edit into the frontmatter of the named agent's file and
+// reloads the registry. Other frontmatter fields, their order, and the body
+// are preserved. An agent that others extend cannot be renamed, as they
+// would lose their base.
+func (r *Registry) UpdateAgent(name string, edit AgentEdit) error {
+	agent, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("agent not found: %s", name)
+	}
+
+	edit.Name = strings.TrimSpace(edit.Name)
+	edit.Description = strings.TrimSpace(edit.Description)
+	edit.Model = strings.TrimSpace(edit.Model)
+	edit.PermissionMode = strings.TrimSpace(edit.PermissionMode)
+	switch {
+	case edit.Name == "":
+		return fmt.Errorf("name is required")
+	case !agentNamePattern.MatchString(edit.Name):
+		return fmt.Errorf("invalid name %q: use lowercase letters, digits, and hyphens", edit.Name)
+	case edit.Description == "":
+		return fmt.Errorf("description is required")
+	case edit.PermissionMode != "" && !permissionModes[edit.PermissionMode]:
+		return fmt.Errorf("invalid permission mode %q", edit.PermissionMode)
+	}
+	if edit.Name != name {
+		if _, exists := r.Get(edit.Name); exists {
+			return fmt.Errorf("agent already exists: %s", edit.Name)
+		}
+		if children := r.extendedBy(name); len(children) > 0 {
+			return fmt.Errorf("cannot rename %s: extended by %s", name, strings.Join(children, ", "))
+		}
+	}
+
+	data, err := os.ReadFile(agent.FilePath)
+	if err != nil {
+		return fmt.Errorf("read agent file: %w", err)
+	}
+	content, err := rewriteFrontmatter(data, edit)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(agent.FilePath, content, 0o644); err != nil {
+		return fmt.Errorf("write agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("updated sub-agent", "name", edit.Name, "path", agent.FilePath)
+	return nil
+}
+
+// extendedBy returns the names of the agents that extend name, sorted.
+func (r *Registry) extendedBy(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var children []string
+	for _, agent := range r.agents {
+		if agent.Extends == name {
+			children = append(children, agent.Name)
+		}
+	}
+	slices.Sort(children)
+	return children
+}
+
+// rewriteFrontmatter applies edit to the frontmatter of an agent file.
+func rewriteFrontmatter(data []byte, edit AgentEdit) ([]byte, error) {
+	return updateFrontmatter(data, func(mapping *yaml.Node) {
+		setScalar(mapping, "name", edit.Name)
+		setScalar(mapping, "description", edit.Description)
+		setTools(mapping, "tools", parseToolList(edit.Tools))
+		setScalar(mapping, "model", edit.Model)
+		setScalar(mapping, "permissionMode", edit.PermissionMode)
+	})
+}
+
+// updateFrontmatter applies update to the frontmatter mapping of an agent
+// file, keeping the body as is.
+func updateFrontmatter(data []byte, update func(mapping *yaml.Node)) ([]byte, error) {
+	fm, body, err := frontmatter.Split(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse frontmatter: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fm, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	mapping := doc.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("frontmatter must be a mapping")
+	}
+
+	update(mapping)
+
+	header, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode frontmatter: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(frontmatter.Delimiter + "\n")
+	sb.Write(header)
+	sb.WriteString(frontmatter.Delimiter + "\n")
+	if len(body) > 0 {
+		sb.Write(body)
+		sb.WriteString("\n")
+	}
+	return []byte(sb.String()), nil
+}
+
+// mappingValue returns the value node for key, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// deleteKey removes key from mapping.
+func deleteKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setScalar sets key to value, appending it when absent and removing it
+// when value is empty.
+func setScalar(mapping *yaml.Node, key, value string) {
+	if value == "" {
+		deleteKey(mapping, key)
+		return
+	}
+	if node := mappingValue(mapping, key); node != nil {
+		*node = yaml.Node{Kind: yaml.ScalarNode, Value: value, LineComment: node.LineComment}
+		return
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+	)
+}
+
+// setTools sets a tool list key, keeping the list style of the original
+// file. A nil list removes the key and an empty one is written as [].
+func setTools(mapping *yaml.Node, key string, tools []string) {
+	node := mappingValue(mapping, key)
+	sequence := node != nil && node.Kind == yaml.SequenceNode
+	if tools == nil || (len(tools) > 0 && !sequence) {
+		setScalar(mapping, key, strings.Join(tools, ", "))
+		return
+	}
+
+	items := make([]*yaml.Node, len(tools))
+	for i, tool := range tools {
+		items[i] = &yaml.Node{Kind: yaml.ScalarNode, Value: tool}
+	}
+	list := yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle, Content: items}
+	switch {
+	case sequence:
+		list.Style, list.LineComment = node.Style, node.LineComment
+		*node = list
+	case node != nil:
+		list.LineComment = node.LineComment
+		*node = list
+	default:
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &list)
+	}
+}
@@ -0,0 +1,38 @@
+package subagents
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// agentDir resolves the directory an agent runs in. Relative cwd values are
+// taken from the project root, so the result does not depend on where the
+// parent agent is working. An empty result leaves the runner's default.
+func (r *Registry) agentDir(agent *SubAgent) (string, error) {
+	if agent.Cwd == "" {
+		return "", nil
+	}
+	dir := ExpandPath(agent.Cwd, r.workingDir)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("sub-agent %s: working directory %s: %w", agent.Name, agent.Cwd, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("sub-agent %s: working directory %s is not a directory", agent.Name, agent.Cwd)
+	}
+	return dir, nil
+}
+
+// envList renders env as sorted KEY=value pairs.
+func envList(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(env))
+	for key, value := range env {
+		list = append(list, key+"="+value)
+	}
+	slices.Sort(list)
+	return list
+}
@@ -0,0 +1,140 @@
+package subagents
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// BasePromptMarker marks where an extending agent's body embeds the base
+// agent's system prompt. Without it the body is appended after the base.
+const BasePromptMarker = "{{base}}"
+
+// inherit fills fields the agent leaves unset from base and composes the
+// system prompts.
+func (a *SubAgent) inherit(base *SubAgent) {
+	a.SystemPrompt = composePrompt(base.SystemPrompt, a.SystemPrompt)
+
+	if a.ToolsRaw == nil {
+		a.Tools = base.Tools
+	}
+	if a.DisallowedRaw == nil {
+		a.DisallowedTools = base.DisallowedTools
+	}
+	if a.Tags == nil {
+		a.Tags = base.Tags
+	}
+	if a.MCPServers == nil {
+		a.MCPServers = base.MCPServers
+	}
+	if a.Model == "" {
+		a.Model = base.Model
+	}
+	if a.Temperature == nil {
+		a.Temperature = base.Temperature
+	}
+	if a.TopP == nil {
+		a.TopP = base.TopP
+	}
+	if a.ReasoningEffort == "" {
+		a.ReasoningEffort = base.ReasoningEffort
+	}
+	if a.PermissionMode == "" {
+		a.PermissionMode = base.PermissionMode
+	}
+	if a.MaxTokens == 0 {
+		a.MaxTokens = base.MaxTokens
+	}
+	if a.MaxCostUSD == 0 {
+		a.MaxCostUSD = base.MaxCostUSD
+	}
+	if a.Timeout == 0 {
+		a.Timeout = base.Timeout
+	}
+	if a.OutputSchema == nil {
+		a.OutputSchema = base.OutputSchema
+	}
+	if a.ContextFiles == nil {
+		a.ContextFiles = base.ContextFiles
+	}
+	if a.PostProcess == nil {
+		a.PostProcess = base.PostProcess
+	}
+	if a.Cwd == "" {
+		a.Cwd = base.Cwd
+	}
+	if len(base.Env) > 0 {
+		env := maps.Clone(base.Env)
+		maps.Copy(env, a.Env)
+		a.Env = env
+	}
+	if !a.Artifact {
+		a.Artifact = base.Artifact
+	}
+	if !a.Conversation {
+		a.Conversation = base.Conversation
+	}
+}
+
+// composePrompt combines a base prompt with an extending agent's body.
+func composePrompt(base, body string) string {
+	switch {
+	case strings.Contains(body, BasePromptMarker):
+		return strings.TrimSpace(strings.ReplaceAll(body, BasePromptMarker, base))
+	case body == "":
+		return base
+	case base == "":
+		return body
+	default:
+		return base + "\n\n" + body
+	}
+}
+
+// resolveExtends applies base agents to every agent that declares extends,
+// following chains of bases. Agents whose base is missing or part of a
+// cycle are returned in failed and left out of the result.
+func resolveExtends(agents map[string]*SubAgent) (resolved map[string]*SubAgent, failed map[string]error) {
+	resolved = make(map[string]*SubAgent, len(agents))
+	failed = make(map[string]error)
+	visiting := make(map[string]bool)
+
+	var resolve func(name string) (*SubAgent, error)
+	resolve = func(name string) (*SubAgent, error) {
+		if agent, ok := resolved[name]; ok {
+			return agent, nil
+		}
+		if err, ok := failed[name]; ok {
+			return nil, err
+		}
+
+		agent, ok := agents[name]
+		if !ok {
+			return nil, fmt.Errorf("base agent not found: %s", name)
+		}
+		if agent.Extends == "" {
+			resolved[name] = agent
+			return agent, nil
+		}
+		if visiting[name] {
+			return nil, fmt.Errorf("extends cycle at %s", name)
+		}
+
+		visiting[name] = true
+		base, err := resolve(agent.Extends)
+		delete(visiting, name)
+		if err != nil {
+			err = fmt.Errorf("extends %s: %w", agent.Extends, err)
+			failed[name] = err
+			return nil, err
+		}
+
+		agent.inherit(base)
+		resolved[name] = agent
+		return agent, nil
+	}
+
+	for name := range agents {
+		_, _ = resolve(name)
+	}
+	return resolved, failed
+}
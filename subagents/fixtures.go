@@ -0,0 +1,229 @@
+package subagents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// TestToolName is the name of the sub-agent fixture test tool.
+	TestToolName = "subagent_test"
+
+	// TestDescription is shown to the LLM.
+	TestDescription = `Run sub-agents against their test fixtures and report which pass.
+
+<usage>
+- agents: Only test these sub-agents (optional, default all agents with fixtures)
+</usage>
+
+<hints>
+- Fixtures are defined under tests in an agent's frontmatter or in a <agent>.tests.yaml file next to it
+- Each fixture runs the agent for real and checks its reply
+</hints>
+`
+
+	// fixtureSuffix names the sidecar fixture file of an agent file.
+	fixtureSuffix = ".tests.yaml"
+)
+
+// TestParams defines the parameters for the fixture test tool.
+type TestParams struct {
+	Agents []string `json:"agents,omitempty" jsonschema:"description=Only test these sub-agents"`
+}
+
+// Fixture is a prompt an agent is expected to answer in a certain way.
+type Fixture struct {
+	Prompt      string     `yaml:"prompt"`
+	Contains    stringList `yaml:"contains"`    // Substrings the reply must include
+	NotContains stringList `yaml:"notContains"` // Substrings the reply must not include
+	Matches     string     `yaml:"matches"`     // Regular expression the reply must match
+}
+
+// stringList accepts either a single string or a YAML list of strings.
+// Unlike toolList, a scalar is kept whole since assertions may contain commas.
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var items []string
+	if err := node.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// check returns the assertions output fails.
+func (f Fixture) check(output string) []string {
+	var failures []string
+	for _, s := range f.Contains {
+		if !strings.Contains(output, s) {
+			failures = append(failures, fmt.Sprintf("missing %q", s))
+		}
+	}
+	for _, s := range f.NotContains {
+		if strings.Contains(output, s) {
+			failures = append(failures, fmt.Sprintf("unexpected %q", s))
+		}
+	}
+	if f.Matches != "" {
+		if re, err := regexp.Compile(f.Matches); err != nil || !re.MatchString(output) {
+			failures = append(failures, fmt.Sprintf("no match for /%s/", f.Matches))
+		}
+	}
+	return failures
+}
+
+// validateFixtures checks that every fixture has a prompt and a valid pattern.
+func validateFixtures(fixtures []Fixture) error {
+	for i, f := range fixtures {
+		if strings.TrimSpace(f.Prompt) == "" {
+			return fmt.Errorf("tests[%d]: prompt is required", i)
+		}
+		if f.Matches != "" {
+			if _, err := regexp.Compile(f.Matches); err != nil {
+				return fmt.Errorf("tests[%d]: invalid matches pattern: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadSidecarFixtures reads the fixtures stored next to an agent file as
+// <name>.tests.yaml. A missing file means no fixtures.
+func loadSidecarFixtures(agentPath string) ([]Fixture, error) {
+	path := strings.TrimSuffix(agentPath, ".md") + fixtureSuffix
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures: %w", err)
+	}
+	var fixtures []Fixture
+	if err := yaml.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// FixtureResult is the outcome of running one fixture.
+type FixtureResult struct {
+	Agent    string
+	Index    int // Position of the fixture within the agent's tests
+	Prompt   string
+	Output   string
+	Failures []string
+	Err      error
+}
+
+// Passed reports whether the run succeeded and every assertion held.
+func (r FixtureResult) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// RunFixtures runs the fixtures of the named agents, or of every agent with
+// fixtures when names is empty, one at a time.
+func (r *Registry) RunFixtures(ctx context.Context, names []string) ([]FixtureResult, error) {
+	var agents []*SubAgent
+	if len(names) == 0 {
+		for _, agent := range r.List() {
+			if len(agent.Tests) > 0 {
+				agents = append(agents, agent)
+			}
+		}
+		slices.SortFunc(agents, func(a, b *SubAgent) int { return strings.Compare(a.Name, b.Name) })
+	} else {
+		for _, name := range uniqueNames(names) {
+			agent, ok := r.Get(name)
+			if !ok {
+				return nil, r.notFound(name)
+			}
+			agents = append(agents, agent)
+		}
+	}
+
+	var results []FixtureResult
+	for _, agent := range agents {
+		for i, fixture := range agent.Tests {
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			res := FixtureResult{Agent: agent.Name, Index: i + 1, Prompt: fixture.Prompt}
+			res.Output, res.Err = r.Run(ctx, agent.Name, fixture.Prompt)
+			if res.Err == nil {
+				res.Failures = fixture.check(res.Output)
+			}
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
+
+// formatFixtureReport renders results as one line per fixture and a total.
+func formatFixtureReport(results []FixtureResult) string {
+	if len(results) == 0 {
+		return "No sub-agent fixtures found."
+	}
+
+	var sb strings.Builder
+	failed := 0
+	for _, res := range results {
+		status := "PASS"
+		if !res.Passed() {
+			status = "FAIL"
+			failed++
+		}
+		sb.WriteString(fmt.Sprintf("%s %s #%d: %s\n", status, res.Agent, res.Index, truncate(res.Prompt, 60)))
+		if res.Err != nil {
+			sb.WriteString(fmt.Sprintf("  error: %v\n", res.Err))
+		}
+		for _, f := range res.Failures {
+			sb.WriteString("  " + f + "\n")
+		}
+	}
+	sb.WriteString(fmt.Sprintf("\n%d passed, %d failed", len(results)-failed, failed))
+	return sb.String()
+}
+
+func testToolFactory(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
+	registry, err := loadRegistry(app)
+	if err != nil {
+		return nil, err
+	}
+	return NewTestTool(registry), nil
+}
+
+// NewTestTool creates the sub-agent fixture test tool.
+func NewTestTool(registry *Registry) fantasy.AgentTool {
+	return fantasy.NewAgentTool(
+		TestToolName,
+		TestDescription,
+		func(ctx context.Context, params TestParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
+			results, err := registry.RunFixtures(ctx, params.Agents)
+			if err != nil && len(results) == 0 {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			report := formatFixtureReport(results)
+			for _, res := range results {
+				if !res.Passed() {
+					return fantasy.NewTextErrorResponse(report), nil
+				}
+			}
+			return fantasy.NewTextResponse(report), nil
+		},
+	)
+}
@@ -0,0 +1,88 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultMaxHandoffs is how many times a run may be handed on to another
+// agent by default.
+const DefaultMaxHandoffs = 3
+
+// handoffPattern matches a handoff directive ending a reply:
+//
+//	<handoff agent="merger">context for the next agent</handoff>
+var handoffPattern = regexp.MustCompile(`(?s)<handoff\s+agent="([^"]+)"\s*>(.*?)</handoff>\s*$`)
+
+// handoff is a request by an agent to continue with another agent.
+type handoff struct {
+	agent   string
+	context string
+}
+
+// parseHandoff splits a trailing handoff directive off output. It returns
+// output unchanged and nil when there is none.
+func parseHandoff(output string) (string, *handoff) {
+	m := handoffPattern.FindStringSubmatchIndex(output)
+	if m == nil {
+		return output, nil
+	}
+	return strings.TrimSpace(output[:m[0]]), &handoff{
+		agent:   strings.TrimSpace(output[m[2]:m[3]]),
+		context: strings.TrimSpace(output[m[4]:m[5]]),
+	}
+}
+
+// maxHandoffs returns the configured hop limit; a negative setting disables
+// handoffs.
+func (r *Registry) maxHandoffs() int {
+	return max(0, r.cfg.MaxHandoffs)
+}
+
+// followHandoffs chains the agents that output, the reply of agent, hands
+// off to: each runs with the context of the directive, or the previous
+// reply when the context is empty, until a reply has no directive, a run
+// fails, or the hop limit is reached. Without a directive output is
+// returned as is; otherwise the result has one section per agent.
+func (r *Registry) followHandoffs(ctx context.Context, agent, output string) string {
+	if r.maxHandoffs() == 0 {
+		return output
+	}
+	reply, next := parseHandoff(output)
+	if next == nil {
+		return output
+	}
+
+	var sb strings.Builder
+	section := func(name, text string) {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(fmt.Sprintf("## %s\n\n%s", name, text))
+	}
+	section(agent, reply)
+
+	for hops := 0; next != nil; hops++ {
+		if hops >= r.maxHandoffs() {
+			sb.WriteString(fmt.Sprintf("\n\n[Handoff to %s stopped: limit of %d handoffs reached]", next.agent, r.maxHandoffs()))
+			break
+		}
+
+		prompt := next.context
+		if prompt == "" {
+			prompt = reply
+		}
+		out, err := r.Run(ctx, next.agent, prompt)
+		if err != nil {
+			section(next.agent, fmt.Sprintf("Error: %v", err))
+			break
+		}
+		r.logger.Debug("sub-agent handoff", "from", agent, "to", next.agent)
+		agent = next.agent
+		reply, next = parseHandoff(out)
+		section(agent, reply)
+	}
+	return sb.String()
+}
@@ -0,0 +1,259 @@
+package subagents
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"charm.land/fantasy"
+	"github.com/aleksclark/crush-modules/agentmetrics"
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// HistoryToolName is the name of the sub-agent history tool.
+	HistoryToolName = "subagent_history"
+
+	// HistoryDescription is shown to the LLM.
+	HistoryDescription = `List recent sub-agent runs, newest first.
+
+<usage>
+- agent: Only show runs of this sub-agent (optional)
+- limit: Maximum number of runs to list (default 20)
+</usage>
+
+<hints>
+- Each run shows its time, duration, token usage, and outcome
+- Use this to check what a sub-agent already did before delegating again
+</hints>
+`
+)
+
+// HistoryParams defines the parameters for the history tool.
+type HistoryParams struct {
+	Agent string `json:"agent,omitempty" jsonschema:"description=Only show runs of this sub-agent"`
+	Limit int    `json:"limit,omitempty" jsonschema:"description=Maximum number of runs to list (default 20)"`
+}
+
+// DefaultHistoryFile is where sub-agent runs are recorded, relative to the
+// working directory, when no history_file is configured.
+const DefaultHistoryFile = ".crush/subagents/history.jsonl"
+
+const (
+	// historyPromptLimit and historyResultLimit cap the stored text per run.
+	historyPromptLimit = 2000
+	historyResultLimit = 4000
+
+	// DefaultHistoryLimit is how many runs are returned when no limit is given.
+	DefaultHistoryLimit = 20
+)
+
+// RunRecord is one persisted sub-agent invocation.
+type RunRecord struct {
+	Time       time.Time `json:"time"`
+	Agent      string    `json:"agent"`
+	Prompt     string    `json:"prompt"`
+	DurationMS int64     `json:"duration_ms"`
+	Tokens     int64     `json:"tokens,omitempty"`
+	CostUSD    float64   `json:"cost_usd,omitempty"`
+	Result     string    `json:"result,omitempty"`
+	Truncated  bool      `json:"truncated,omitempty"` // Result was cut to historyResultLimit
+	Error      string    `json:"error,omitempty"`
+}
+
+// Succeeded reports whether the run completed without error.
+func (rec RunRecord) Succeeded() bool {
+	return rec.Error == ""
+}
+
+// History is an append-only JSON Lines log of sub-agent runs.
+type History struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewHistory creates a history log at path. The file is created on first
+// append.
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// Append writes rec to the log.
+func (h *History) Append(rec RunRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return fmt.Errorf("create history dir: %w", err)
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write history: %w", err)
+	}
+	return f.Close()
+}
+
+// Recent returns up to limit runs, newest first. An empty agent matches
+// all agents. Malformed lines are skipped.
+func (h *History) Recent(agent string, limit int) ([]RunRecord, error) {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if agent != "" && rec.Agent != agent {
+			continue
+		}
+		records = append(records, rec)
+		if len(records) > limit {
+			records = records[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+
+	slices.Reverse(records)
+	return records, nil
+}
+
+// recordRun ends metered and adds it to the registry's metrics and history. Runs
+// whose usage is unknown are recorded without tokens and cost. History
+// failures are logged rather than returned so that history never breaks a run.
+func (r *Registry) recordRun(ctx context.Context, agent, prompt string, started time.Time, metered *meteredRun, result string, runErr error) {
+	used, _ := r.endRun(metered)
+	elapsed := time.Since(started)
+	rec := RunRecord{
+		Time:       started,
+		Agent:      agent,
+		Prompt:     truncate(prompt, historyPromptLimit),
+		DurationMS: elapsed.Milliseconds(),
+		Tokens:     used.tokens,
+		CostUSD:    used.cost,
+	}
+	if runErr != nil {
+		rec.Error = runErr.Error()
+	} else {
+		rec.Result = truncate(result, historyResultLimit)
+		rec.Truncated = len(rec.Result) < len(result)
+	}
+
+	run := agentmetrics.Run{
+		Agent:      agent,
+		Started:    started,
+		Duration:   elapsed,
+		Tokens:     rec.Tokens,
+		CostUSD:    rec.CostUSD,
+		Error:      rec.Error,
+		ToolCallID: toolCallFrom(ctx),
+		Parent:     parentAgent(ctx),
+	}
+	r.metrics.Record(run)
+	if err := r.history.Append(rec); err != nil {
+		r.logger.Warn("failed to record sub-agent run", "agent", agent, "error", err)
+	}
+}
+
+// truncate cuts s to at most limit bytes without splitting a UTF-8 rune.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+func historyToolFactory(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
+	registry, err := loadRegistry(app)
+	if err != nil {
+		return nil, err
+	}
+	return NewHistoryTool(registry), nil
+}
+
+// NewHistoryTool creates the sub-agent history tool.
+func NewHistoryTool(registry *Registry) fantasy.AgentTool {
+	return fantasy.NewAgentTool(
+		HistoryToolName,
+		HistoryDescription,
+		func(ctx context.Context, params HistoryParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
+			records, err := registry.history.Recent(params.Agent, params.Limit)
+			if err != nil {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			if len(records) == 0 {
+				return fantasy.NewTextResponse("No sub-agent runs recorded."), nil
+			}
+
+			var sb strings.Builder
+			for i, rec := range records {
+				if i > 0 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString(formatRunRecord(rec))
+			}
+			return fantasy.NewTextResponse(sb.String()), nil
+		},
+	)
+}
+
+// formatRunRecord renders a run for the history tool.
+func formatRunRecord(rec RunRecord) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## %s at %s (%s", rec.Agent, rec.Time.Format(time.RFC3339), time.Duration(rec.DurationMS)*time.Millisecond))
+	if rec.Tokens > 0 {
+		sb.WriteString(fmt.Sprintf(", %d tokens", rec.Tokens))
+	}
+	if rec.CostUSD > 0 {
+		sb.WriteString(fmt.Sprintf(", $%.4f", rec.CostUSD))
+	}
+	sb.WriteString(")\n\n")
+	sb.WriteString("Prompt: " + rec.Prompt + "\n")
+	if !rec.Succeeded() {
+		sb.WriteString("Error: " + rec.Error + "\n")
+		return sb.String()
+	}
+	sb.WriteString("Result:\n" + rec.Result + "\n")
+	if rec.Truncated {
+		sb.WriteString("[result truncated]\n")
+	}
+	return sb.String()
+}
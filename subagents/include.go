@@ -0,0 +1,84 @@
+package subagents
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aleksclark/crush-modules/frontmatter"
+)
+
+// includePattern matches {{include "path"}} directives in agent bodies.
+var includePattern = regexp.MustCompile(`\{\{\s*include\s+"([^"]+)"\s*\}\}`)
+
+// resolveIncludes replaces include directives in body with the contents of
+// the named files, resolved relative to the directory of path, the file the
+// body came from. When root is set, included files must lie within it.
+// Included files may include others; stack holds the files being expanded
+// so that cycles are reported instead of recursing forever.
+func resolveIncludes(body, path, root string, stack []string) (string, error) {
+	if !strings.Contains(body, "include") {
+		return body, nil
+	}
+	stack = append(stack, path)
+
+	var firstErr error
+	out := includePattern.ReplaceAllStringFunc(body, func(directive string) string {
+		if firstErr != nil {
+			return directive
+		}
+		name := includePattern.FindStringSubmatch(directive)[1]
+		target := ExpandPath(name, filepath.Dir(path))
+		if slices.Contains(stack, target) {
+			firstErr = fmt.Errorf("include cycle: %s", strings.Join(append(displayNames(stack), filepath.Base(target)), " -> "))
+			return directive
+		}
+		if root != "" && !withinResolved(root, target) {
+			firstErr = fmt.Errorf("include %q: outside the agent's source", name)
+			return directive
+		}
+
+		data, err := os.ReadFile(target)
+		if err != nil {
+			firstErr = fmt.Errorf("include %q: %w", name, err)
+			return directive
+		}
+		// Shared partials may be agent files themselves; only their body is used.
+		if _, body, err := frontmatter.Split(data); err == nil {
+			data = body
+		}
+
+		content, err := resolveIncludes(strings.TrimSpace(string(data)), target, root, stack)
+		if err != nil {
+			firstErr = err
+			return directive
+		}
+		return content
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return out, nil
+}
+
+// withinResolved reports whether path lies within dir once symlinks in both
+// are resolved. Paths that cannot be resolved are not within dir.
+func withinResolved(dir, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	return err == nil && within(dir, resolved)
+}
+
+// displayNames returns the base names of paths.
+func displayNames(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = filepath.Base(p)
+	}
+	return names
+}
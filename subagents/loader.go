@@ -1,46 +1,91 @@
 package subagents
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/aleksclark/crush-modules/frontmatter"
 	"gopkg.in/yaml.v3"
 )
 
 // SubAgent represents a loaded sub-agent configuration.
 type SubAgent struct {
-	Name            string   `yaml:"name"`
-	Description     string   `yaml:"description"`
-	Tools           []string `yaml:"-"`          // Parsed from comma-separated string
-	ToolsRaw        string   `yaml:"tools"`      // Raw YAML field
-	DisallowedTools []string `yaml:"-"`          // Parsed from comma-separated string
-	DisallowedRaw   string   `yaml:"disallowedTools"`
-	Model           string   `yaml:"model"`
-	PermissionMode  string   `yaml:"permissionMode"`
-	SystemPrompt    string   `yaml:"-"` // Markdown body
-	FilePath        string   `yaml:"-"` // Source file path
-	Enabled         bool     `yaml:"-"` // Runtime state
+	Name            string            `yaml:"name"`
+	Description     string            `yaml:"description"`
+	Version         string            `yaml:"version"`         // Version of the agent itself
+	MinCrushVersion string            `yaml:"minCrushVersion"` // Oldest Crush release the agent works with
+	Extends         string            `yaml:"extends"`         // Base agent name, resolved by the registry
+	Tools           []string          `yaml:"-"`               // Parsed from ToolsRaw
+	ToolsRaw        toolList          `yaml:"tools"`           // Comma-separated string or YAML list
+	DisallowedTools []string          `yaml:"-"`               // Parsed from DisallowedRaw
+	DisallowedRaw   toolList          `yaml:"disallowedTools"`
+	MCPServers      toolList          `yaml:"mcpServers"` // MCP servers the agent may use, nil for all
+	Model           string            `yaml:"model"`
+	Temperature     *float64          `yaml:"temperature"`     // Sampling temperature, nil for the model default
+	TopP            *float64          `yaml:"topP"`            // Nucleus sampling, nil for the model default
+	ReasoningEffort string            `yaml:"reasoningEffort"` // low, medium, or high
+	PermissionMode  string            `yaml:"permissionMode"`
+	MaxTokens       int               `yaml:"maxTokens"`    // Token budget per run, 0 for none
+	MaxCostUSD      float64           `yaml:"maxCostUsd"`   // Cost budget per run, 0 for none
+	TimeoutRaw      string            `yaml:"timeout"`      // Raw duration, e.g. "5m"
+	Timeout         time.Duration     `yaml:"-"`            // Parsed from TimeoutRaw
+	OutputSchema    outputSchema      `yaml:"outputSchema"` // JSON schema for structured replies
+	Conversation    bool              `yaml:"conversation"` // Keep context across runs in a session
+	Triggers        triggerList       `yaml:"triggers"`     // Patterns that route user messages here
+	ContextFiles    toolList          `yaml:"contextFiles"` // Globs whose contents are attached to every run
+	Cwd             string            `yaml:"cwd"`          // Working directory for runs, relative to the project
+	Env             map[string]string `yaml:"env"`          // Extra environment variables for runs
+	PostProcess     *postProcess      `yaml:"postProcess"`  // Cleanup applied to replies
+	Artifact        bool              `yaml:"artifact"`     // Save full results to files, return summaries
+	Tests           []Fixture         `yaml:"tests"`        // Fixtures run by the subagent_test tool
+	Disabled        bool              `yaml:"disabled"`     // Start disabled until enabled at runtime
+	Tags            toolList          `yaml:"tags"`         // Labels for enabling and disabling agents in bulk
+	SystemPrompt    string            `yaml:"-"`            // Markdown body
+	FilePath        string            `yaml:"-"`            // Source file path
+	Enabled         bool              `yaml:"-"`            // Runtime state
+	ClaudeCode      bool              `yaml:"-"`            // Imported from a Claude Code agent file
+}
+
+// permissionModes are the values accepted for permissionMode.
+var permissionModes = map[string]bool{
+	"default":           true,
+	"acceptEdits":       true,
+	"dontAsk":           true,
+	"bypassPermissions": true,
+	"plan":              true,
+}
+
+// reasoningEfforts are the values accepted for reasoningEffort.
+var reasoningEfforts = map[string]bool{
+	"low":    true,
+	"medium": true,
+	"high":   true,
 }
 
 // LoadAgentFile parses a sub-agent YAML+Markdown file.
 func LoadAgentFile(path string) (*SubAgent, error) {
+	return loadAgentFile(path, "")
+}
+
+// loadAgentFile parses a sub-agent file. When root is set, the file and
+// everything it includes must lie within it.
+func loadAgentFile(path, root string) (*SubAgent, error) {
+	if root != "" && !withinResolved(root, path) {
+		return nil, fmt.Errorf("agent file outside its source: %s", path)
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read file: %w", err)
 	}
 
-	frontmatter, body, err := splitFrontmatter(data)
-	if err != nil {
-		return nil, fmt.Errorf("parse frontmatter: %w", err)
-	}
-
 	var agent SubAgent
-	if err := yaml.Unmarshal(frontmatter, &agent); err != nil {
-		return nil, fmt.Errorf("unmarshal yaml: %w", err)
+	body, err := frontmatter.Parse(data, &agent)
+	if err != nil {
+		return nil, err
 	}
 
 	if agent.Name == "" {
@@ -49,63 +94,88 @@ func LoadAgentFile(path string) (*SubAgent, error) {
 	if agent.Description == "" {
 		return nil, fmt.Errorf("description is required")
 	}
+	if agent.MaxTokens < 0 || agent.MaxCostUSD < 0 {
+		return nil, fmt.Errorf("maxTokens and maxCostUsd must not be negative")
+	}
+	if agent.TimeoutRaw != "" {
+		timeout, err := time.ParseDuration(agent.TimeoutRaw)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", agent.TimeoutRaw)
+		}
+		agent.Timeout = timeout
+	}
+
+	if t := agent.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return nil, fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p := agent.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return nil, fmt.Errorf("topP must be greater than 0 and at most 1")
+	}
+	if agent.ReasoningEffort != "" && !reasoningEfforts[agent.ReasoningEffort] {
+		return nil, fmt.Errorf("invalid reasoningEffort %q", agent.ReasoningEffort)
+	}
+	if agent.MinCrushVersion != "" {
+		if _, ok := parseVersion(agent.MinCrushVersion); !ok {
+			return nil, fmt.Errorf("invalid minCrushVersion %q", agent.MinCrushVersion)
+		}
+	}
+	for key := range agent.Env {
+		if key == "" || strings.ContainsAny(key, "= \t") {
+			return nil, fmt.Errorf("invalid env variable name %q", key)
+		}
+	}
+
+	sidecar, err := loadSidecarFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	agent.Tests = append(agent.Tests, sidecar...)
+	if err := validateFixtures(agent.Tests); err != nil {
+		return nil, err
+	}
 
-	// Parse comma-separated tool lists.
-	agent.Tools = parseToolList(agent.ToolsRaw)
-	agent.DisallowedTools = parseToolList(agent.DisallowedRaw)
-	agent.SystemPrompt = strings.TrimSpace(string(body))
+	agent.Tools = normalizeTools(agent.ToolsRaw)
+	agent.DisallowedTools = normalizeTools(agent.DisallowedRaw)
+	agent.SystemPrompt, err = resolveIncludes(body, path, root, nil)
+	if err != nil {
+		return nil, err
+	}
 	agent.FilePath = path
-	agent.Enabled = true
+	agent.Enabled = !agent.Disabled
 
-	// Default model to inherit.
-	if agent.Model == "" {
+	// Default model to inherit. Extending agents get theirs from the base.
+	if agent.Model == "" && agent.Extends == "" {
 		agent.Model = "inherit"
 	}
 
 	return &agent, nil
 }
 
-// splitFrontmatter separates YAML frontmatter from markdown body.
-// Expects format:
-// ---
-// yaml content
-// ---
-// markdown body
-func splitFrontmatter(data []byte) (frontmatter, body []byte, err error) {
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-
-	// Find opening ---.
-	if !scanner.Scan() {
-		return nil, nil, fmt.Errorf("empty file")
-	}
-	if strings.TrimSpace(scanner.Text()) != "---" {
-		return nil, nil, fmt.Errorf("file must start with ---")
-	}
-
-	// Read frontmatter until closing ---.
-	var fmLines []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "---" {
-			break
-		}
-		fmLines = append(fmLines, line)
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, nil, err
-	}
-
-	frontmatter = []byte(strings.Join(fmLines, "\n"))
+// toolList is a tool list field that accepts either the legacy
+// comma-separated string form or a YAML list.
+type toolList []string
 
-	// Rest is markdown body.
-	var bodyLines []string
-	for scanner.Scan() {
-		bodyLines = append(bodyLines, scanner.Text())
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *toolList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*l = parseToolList(node.Value)
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		tools := make([]string, 0, len(items))
+		for _, item := range items {
+			if t := strings.TrimSpace(item); t != "" {
+				tools = append(tools, t)
+			}
+		}
+		*l = tools
+	default:
+		return fmt.Errorf("line %d: tool list must be a string or a list", node.Line)
 	}
-	body = []byte(strings.Join(bodyLines, "\n"))
-
-	return frontmatter, body, nil
+	return nil
 }
 
 // parseToolList splits a comma-separated tool list into individual tool names.
@@ -137,6 +207,24 @@ func ExpandPath(path, workingDir string) string {
 	return filepath.Clean(path)
 }
 
+// projectFirst orders files so that the project's own agents come before
+// the rest, keeping the configured order otherwise. Where two files define
+// the same name, the earlier one wins, so project agents shadow user ones.
+func projectFirst(files []string, isProject func(string) bool) []string {
+	sorted := slices.Clone(files)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		pa, pb := isProject(a), isProject(b)
+		switch {
+		case pa && !pb:
+			return -1
+		case pb && !pa:
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
 // DiscoverAgentFiles finds all .md files in the given directories.
 func DiscoverAgentFiles(dirs []string, workingDir string) []string {
 	var files []string
@@ -0,0 +1,101 @@
+package subagents
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxSuggestions caps the names offered for an unknown agent.
+const maxSuggestions = 3
+
+// notFound returns the error for an unknown agent name, suggesting the
+// closest enabled agents.
+func (r *Registry) notFound(name string) error {
+	if matches := r.closestAgents(name); len(matches) > 0 {
+		return fmt.Errorf("sub-agent not found: %s (did you mean %s?)", name, strings.Join(matches, ", "))
+	}
+	return fmt.Errorf("sub-agent not found: %s", name)
+}
+
+// correctName returns name when an agent has it, otherwise the single
+// closest enabled agent if correct_names is on and there is exactly one.
+func (r *Registry) correctName(name string) string {
+	if _, ok := r.Get(name); ok || !r.cfg.CorrectNames {
+		return name
+	}
+	matches := r.closestAgents(name)
+	if len(matches) != 1 {
+		return name
+	}
+	r.logger.Debug("corrected sub-agent name", "requested", name, "agent", matches[0])
+	return matches[0]
+}
+
+// closestAgents returns up to maxSuggestions enabled agents whose names are
+// close to name, closest first. Case and the separators -, _, and space are
+// ignored, so "Code_Reviewer" matches "code-reviewer" exactly.
+func (r *Registry) closestAgents(name string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	want := canonicalName(name)
+	limit := max(2, len(want)/3)
+	var candidates []candidate
+	for _, agent := range r.List() {
+		if !agent.Enabled {
+			continue
+		}
+		if d := levenshtein(want, canonicalName(agent.Name)); d <= limit {
+			candidates = append(candidates, candidate{agent.Name, d})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if a.distance != b.distance {
+			return a.distance - b.distance
+		}
+		return strings.Compare(a.name, b.name)
+	})
+
+	var names []string
+	for _, c := range candidates[:min(len(candidates), maxSuggestions)] {
+		// An exact match up to case and separators is the only sensible one.
+		if candidates[0].distance == 0 && c.distance > 0 {
+			break
+		}
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// canonicalName lowercases name and unifies word separators.
+func canonicalName(name string) string {
+	return strings.NewReplacer("_", "-", " ", "-").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
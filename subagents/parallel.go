@@ -0,0 +1,116 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"charm.land/fantasy"
+)
+
+// parallelResult is the outcome of one agent in a fan-out run.
+type parallelResult struct {
+	agent  string
+	output string
+	err    error
+}
+
+// RunParallel dispatches the same prompt to several sub-agents concurrently,
+// bounded by the registry's MaxParallel at the top level, and combines the
+// results into one section per agent in the order the agents were requested.
+// The response is an error only if every agent failed.
+func (r *Registry) RunParallel(ctx context.Context, names []string, prompt string) fantasy.ToolResponse {
+	names = uniqueNames(names)
+	results := make([]parallelResult, len(names))
+
+	// A nested fan-out runs inside an agent that may already hold a slot;
+	// waiting for another could deadlock, so only top-level runs are bounded.
+	bounded := len(delegationChain(ctx)) == 0
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = parallelResult{agent: name}
+
+			if bounded {
+				select {
+				case r.parallel <- struct{}{}:
+					defer func() { <-r.parallel }()
+				case <-ctx.Done():
+					results[i].err = ctx.Err()
+					return
+				}
+			}
+
+			results[i].output, results[i].err = r.Run(ctx, name, prompt)
+		}()
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	failed := 0
+	for i, res := range results {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(fmt.Sprintf("## %s\n\n", res.agent))
+		if res.err != nil {
+			failed++
+			sb.WriteString(fmt.Sprintf("Error: %v", res.err))
+			continue
+		}
+		sb.WriteString(res.output)
+	}
+
+	if failed == len(results) {
+		return fantasy.NewTextErrorResponse(sb.String())
+	}
+	return fantasy.NewTextResponse(sb.String())
+}
+
+// uniqueNames trims names and drops blanks and duplicates, keeping order.
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// acquire waits for one of the registry's MaxConcurrent run slots, failing
+// after the queue timeout. Nested runs skip the limit because their parent
+// already holds a slot and waiting for another could deadlock.
+func (r *Registry) acquire(ctx context.Context, agent string) (release func(), err error) {
+	release = func() { <-r.slots }
+	if len(delegationChain(ctx)) > 0 {
+		return func() {}, nil
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	r.logger.Info("sub-agent queued", "agent", agent, "max_concurrent", cap(r.slots))
+	timer := time.NewTimer(r.queueTimeout)
+	defer timer.Stop()
+	select {
+	case r.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("sub-agent %s: no free run slot after %s (max_concurrent is %d)", agent, r.queueTimeout, cap(r.slots))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !crushnext
+
+package subagents
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// applyRunSettings checks s against a plugin API whose SubAgentOptions has
+// none of its fields. Runs of agents that set any of them fail rather than
+// run with settings other than the agent file asks for. Without
+// ApproveTool, disallowed tools are refused outright.
+func (r *Registry) applyRunSettings(_ *plugin.SubAgentOptions, agent *SubAgent, s runSettings) error {
+	var unsupported []string
+	if s.Temperature != nil {
+		unsupported = append(unsupported, "temperature")
+	}
+	if s.TopP != nil {
+		unsupported = append(unsupported, "topP")
+	}
+	if s.ReasoningEffort != "" {
+		unsupported = append(unsupported, "reasoningEffort")
+	}
+	if s.MCPServers != nil {
+		unsupported = append(unsupported, "mcpServers")
+	}
+	if s.WorkingDir != "" {
+		unsupported = append(unsupported, "cwd")
+	}
+	if len(s.Env) > 0 {
+		unsupported = append(unsupported, "env")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("sub-agent %s: %s not supported by this Crush build (build with -tags crushnext)", agent.Name, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// registeredTools returns no tools, as the published plugin API cannot list
+// those registered by plugins.
+func registeredTools() []string {
+	return nil
+}
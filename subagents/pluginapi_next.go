@@ -0,0 +1,22 @@
+//go:build crushnext
+
+package subagents
+
+import "github.com/charmbracelet/crush/plugin"
+
+// applyRunSettings passes s to the runner through opts.
+func (r *Registry) applyRunSettings(opts *plugin.SubAgentOptions, _ *SubAgent, s runSettings) error {
+	opts.MCPServers = s.MCPServers
+	opts.Temperature = s.Temperature
+	opts.TopP = s.TopP
+	opts.ReasoningEffort = s.ReasoningEffort
+	opts.WorkingDir = s.WorkingDir
+	opts.Env = s.Env
+	opts.ApproveTool = s.ApproveTool
+	return nil
+}
+
+// registeredTools returns the names of the tools registered by plugins.
+func registeredTools() []string {
+	return plugin.RegisteredTools()
+}
@@ -0,0 +1,58 @@
+//go:build crushnext
+
+package subagents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/crush/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSettingsPassedOn(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	workDir := t.TempDir()
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(runner))
+	r := newTestRegistryWithApp(t, app, Config{}, "frontend")
+	agent := r.agents["frontend"]
+	agent.Cwd = "web"
+	agent.Env = map[string]string{"NODE_ENV": "development"}
+	agent.MCPServers = toolList{"github"}
+	agent.Temperature = new(0.2)
+	agent.TopP = new(0.9)
+	agent.ReasoningEffort = "high"
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "web"), 0o755))
+
+	_, err := r.Run(context.Background(), "frontend", "build")
+	require.NoError(t, err)
+	opts := runner.calls[0]
+	require.Equal(t, filepath.Join(workDir, "web"), opts.WorkingDir)
+	require.Equal(t, []string{"NODE_ENV=development"}, opts.Env)
+	require.Equal(t, []string{"github"}, opts.MCPServers)
+	require.Equal(t, 0.2, *opts.Temperature)
+	require.Equal(t, 0.9, *opts.TopP)
+	require.Equal(t, "high", opts.ReasoningEffort)
+	require.NotNil(t, opts.ApproveTool)
+}
+
+func TestValidatePluginTools(t *testing.T) {
+	plugin.RegisterToolWithConfig("jira_search", func(context.Context, *plugin.App) (plugin.Tool, error) {
+		return nil, nil
+	}, nil)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "jira.md"), []byte("---\nname: jira\ndescription: Jira\nmodel: sonnet\ntools: [view, jira_search, subagent_history, Custom, Telepathy]\n---\n"), 0o644))
+
+	issues := ValidateAgents([]string{dir}, dir, knownTools(Config{KnownTools: []string{"Custom"}}))
+	require.Len(t, issues, 1)
+	require.Equal(t, `unknown tool "Telepathy"`, issues[0].Message)
+
+	// Loading warns about the same tools.
+	agent := &SubAgent{Tools: []string{"view", "jira_search", "telepathy"}}
+	require.Equal(t, []string{"telepathy"}, unknownTools(agent, knownTools(Config{})))
+}
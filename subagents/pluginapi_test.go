@@ -0,0 +1,47 @@
+//go:build !crushnext
+
+package subagents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSettingsUnsupported(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{}, "frontend", "tuned")
+	r.agents["frontend"].Env = map[string]string{"CI": "1"}
+	r.agents["frontend"].MCPServers = toolList{}
+	r.agents["tuned"].Temperature = new(0.2)
+
+	_, err := r.Run(context.Background(), "frontend", "build")
+	require.ErrorContains(t, err, "sub-agent frontend: mcpServers, env not supported by this Crush build")
+	require.Empty(t, runner.calls)
+
+	_, err = r.Run(context.Background(), "tuned", "go")
+	require.ErrorContains(t, err, "sub-agent tuned: temperature not supported by this Crush build")
+	require.Empty(t, runner.calls)
+}
+
+func TestValidateUnlistedTools(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "jira.md"), []byte("---\nname: jira\ndescription: Jira\nmodel: sonnet\ntools: [view, jira_search]\n---\n"), 0o644))
+
+	// Without the registered tools, plugin tools must be listed in
+	// known_tools; typos are still caught.
+	issues := ValidateAgents([]string{dir}, dir, knownTools(Config{}))
+	require.Len(t, issues, 1)
+	require.Equal(t, `unknown tool "jira_search"`, issues[0].Message)
+	require.Empty(t, ValidateAgents([]string{dir}, dir, knownTools(Config{KnownTools: []string{"jira_search"}})))
+
+	agent := &SubAgent{Tools: []string{"view", "veiw"}}
+	require.Equal(t, []string{"veiw"}, unknownTools(agent, knownTools(Config{})))
+}
@@ -0,0 +1,93 @@
+package subagents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// thinkingPattern matches chain-of-thought blocks that models wrap in
+// thinking, think, or reasoning tags.
+var thinkingPattern = regexp.MustCompile(`(?is)<(thinking|think|reasoning)>.*?</(thinking|think|reasoning)>`)
+
+// postProcess cleans up a sub-agent's reply before it reaches the parent.
+// Steps run in field order: strip thinking, extract, template, max length.
+type postProcess struct {
+	StripThinking bool               // Remove <thinking> and similar blocks
+	Extract       *regexp.Regexp     // Keep the first match, or its first group
+	Template      *template.Template // Render with .Output and .Agent
+	MaxLength     int                // Truncate to this many bytes, 0 for none
+}
+
+// postProcessSpec is the frontmatter form of postProcess.
+type postProcessSpec struct {
+	StripThinking bool   `yaml:"stripThinking"`
+	Extract       string `yaml:"extract"`
+	Template      string `yaml:"template"`
+	MaxLength     int    `yaml:"maxLength"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *postProcess) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: postProcess must be a mapping", node.Line)
+	}
+	var spec postProcessSpec
+	if err := node.Decode(&spec); err != nil {
+		return err
+	}
+	if spec.MaxLength < 0 {
+		return fmt.Errorf("line %d: postProcess maxLength must not be negative", node.Line)
+	}
+
+	p.StripThinking = spec.StripThinking
+	p.MaxLength = spec.MaxLength
+	if spec.Extract != "" {
+		re, err := regexp.Compile(spec.Extract)
+		if err != nil {
+			return fmt.Errorf("line %d: postProcess extract: %w", node.Line, err)
+		}
+		p.Extract = re
+	}
+	if spec.Template != "" {
+		tmpl, err := template.New("postProcess").Parse(spec.Template)
+		if err != nil {
+			return fmt.Errorf("line %d: postProcess template: %w", node.Line, err)
+		}
+		p.Template = tmpl
+	}
+	return nil
+}
+
+// apply runs the configured steps on agent's output. An extract pattern
+// that does not match leaves the output as it is.
+func (p *postProcess) apply(agent, output string) (string, error) {
+	if p.StripThinking {
+		output = strings.TrimSpace(thinkingPattern.ReplaceAllString(output, ""))
+	}
+	if p.Extract != nil {
+		if m := p.Extract.FindStringSubmatch(output); m != nil {
+			output = m[0]
+			if len(m) > 1 {
+				output = m[1]
+			}
+			output = strings.TrimSpace(output)
+		}
+	}
+	if p.Template != nil {
+		var sb strings.Builder
+		data := struct{ Output, Agent string }{output, agent}
+		if err := p.Template.Execute(&sb, data); err != nil {
+			return "", fmt.Errorf("post-process template: %w", err)
+		}
+		output = sb.String()
+	}
+	if p.MaxLength > 0 && len(output) > p.MaxLength {
+		shown := truncate(output, p.MaxLength)
+		output = shown + fmt.Sprintf("\n[truncated: %d of %d bytes shown]", len(shown), len(output))
+	}
+	return output, nil
+}
@@ -0,0 +1,68 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// Preview renders what a run of the named agent would send to the runner
+// for prompt, without running it: the composed system prompt, the effective
+// tool lists, model, working directory, environment, and the final prompt
+// with attachments and any output schema instructions.
+func (r *Registry) Preview(ctx context.Context, name, prompt string) (string, error) {
+	agent, ok := r.Get(name)
+	if !ok {
+		return "", r.notFound(name)
+	}
+
+	_, opts, settings, err := r.runOptions(ctx, agent, prompt)
+	if err != nil {
+		return "", err
+	}
+	if agent.OutputSchema != nil {
+		opts.Prompt = agent.OutputSchema.prompt(opts.Prompt)
+	}
+	return renderPreview(opts, settings), nil
+}
+
+// renderPreview formats run options for display.
+func renderPreview(opts plugin.SubAgentOptions, settings runSettings) string {
+	list := func(items []string, empty string) string {
+		if len(items) == 0 {
+			return empty
+		}
+		return strings.Join(items, ", ")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Agent: %s\n", opts.Name))
+	sb.WriteString(fmt.Sprintf("Model: %s\n", opts.Model))
+	if settings.Temperature != nil {
+		sb.WriteString(fmt.Sprintf("Temperature: %g\n", *settings.Temperature))
+	}
+	if settings.TopP != nil {
+		sb.WriteString(fmt.Sprintf("Top P: %g\n", *settings.TopP))
+	}
+	if settings.ReasoningEffort != "" {
+		sb.WriteString(fmt.Sprintf("Reasoning effort: %s\n", settings.ReasoningEffort))
+	}
+	sb.WriteString(fmt.Sprintf("Allowed tools: %s\n", list(opts.AllowedTools, "all")))
+	sb.WriteString(fmt.Sprintf("Disallowed tools: %s\n", list(opts.DisallowedTools, "none")))
+	if settings.MCPServers != nil {
+		sb.WriteString(fmt.Sprintf("MCP servers: %s\n", list(settings.MCPServers, "none")))
+	}
+	if settings.WorkingDir != "" {
+		sb.WriteString(fmt.Sprintf("Working directory: %s\n", settings.WorkingDir))
+	}
+	if len(settings.Env) > 0 {
+		sb.WriteString(fmt.Sprintf("Environment: %s\n", strings.Join(settings.Env, " ")))
+	}
+	sb.WriteString("\n=== System prompt ===\n")
+	sb.WriteString(opts.SystemPrompt)
+	sb.WriteString("\n\n=== Prompt ===\n")
+	sb.WriteString(opts.Prompt)
+	return sb.String()
+}
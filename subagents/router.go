@@ -0,0 +1,293 @@
+package subagents
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// RouterHookName is the name of the hook that routes user messages to
+// sub-agents by their triggers.
+const RouterHookName = "subagent-router"
+
+const (
+	// RouteSuggest lists matching agents in the subagent tool description.
+	RouteSuggest = "suggest"
+	// RouteAuto runs matching agents and posts their results to the session.
+	RouteAuto = "auto"
+)
+
+// autoRoutePrefix starts every prompt the router submits, so its own
+// messages are never routed again.
+const autoRoutePrefix = "[subagent-router]"
+
+// RouterQuietPeriod is how long no messages must have been seen in a
+// session before it is considered idle and automatic results are submitted
+// to it.
+const RouterQuietPeriod = 3 * time.Second
+
+// RouterConfig defines configuration options for the router hook.
+type RouterConfig struct {
+	// Mode is RouteSuggest (default) or RouteAuto.
+	Mode string `json:"mode,omitempty"`
+}
+
+// triggerList holds the compiled triggers of an agent. Entries wrapped in
+// slashes, like /deploy(ment)?/, are regular expressions; anything else is
+// a keyword matched case-insensitively as a whole word or phrase.
+type triggerList []*regexp.Regexp
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *triggerList) UnmarshalYAML(node *yaml.Node) error {
+	var items []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		items = []string{node.Value}
+	case yaml.SequenceNode:
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: triggers must be a string or a list", node.Line)
+	}
+
+	triggers := make(triggerList, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		re, err := compileTrigger(item)
+		if err != nil {
+			return fmt.Errorf("line %d: trigger %q: %w", node.Line, item, err)
+		}
+		triggers = append(triggers, re)
+	}
+	*l = triggers
+	return nil
+}
+
+// compileTrigger compiles a regex (/.../) or keyword trigger.
+func compileTrigger(trigger string) (*regexp.Regexp, error) {
+	if len(trigger) > 2 && strings.HasPrefix(trigger, "/") && strings.HasSuffix(trigger, "/") {
+		return regexp.Compile("(?i)" + trigger[1:len(trigger)-1])
+	}
+	return regexp.Compile(`(?i)\b` + regexp.QuoteMeta(trigger) + `\b`)
+}
+
+// matches reports whether any trigger matches text.
+func (l triggerList) matches(text string) bool {
+	for _, re := range l {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTriggers returns the names of enabled agents whose triggers match
+// text, sorted by name.
+func (r *Registry) MatchTriggers(text string) []string {
+	var names []string
+	for _, agent := range r.List() {
+		if agent.Enabled && agent.Triggers.matches(text) {
+			names = append(names, agent.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// setSuggested records the agents matching the latest user message.
+func (r *Registry) setSuggested(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.suggested = names
+}
+
+// Suggested returns the agents matching the latest user message.
+func (r *Registry) Suggested() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.suggested)
+}
+
+// Router watches user messages and routes them to sub-agents whose
+// triggers match.
+type Router struct {
+	app         *plugin.App
+	registry    *Registry
+	mode        string
+	quietPeriod time.Duration
+	wg          sync.WaitGroup
+
+	mu       sync.Mutex
+	activity map[string]time.Time // Session to when it last had a message
+}
+
+func init() {
+	plugin.RegisterHookWithConfig(RouterHookName, func(ctx context.Context, app *plugin.App) (plugin.Hook, error) {
+		var cfg RouterConfig
+		if err := app.LoadConfig(RouterHookName, &cfg); err != nil {
+			return nil, err
+		}
+		registry, err := loadRegistry(app)
+		if err != nil {
+			return nil, err
+		}
+		return NewRouter(app, registry, cfg)
+	}, &RouterConfig{})
+}
+
+// NewRouter creates a router hook for registry.
+func NewRouter(app *plugin.App, registry *Registry, cfg RouterConfig) (*Router, error) {
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = RouteSuggest
+	case RouteSuggest, RouteAuto:
+	default:
+		return nil, fmt.Errorf("invalid routing mode %q: use %q or %q", cfg.Mode, RouteSuggest, RouteAuto)
+	}
+	return &Router{
+		app:         app,
+		registry:    registry,
+		mode:        cfg.Mode,
+		quietPeriod: RouterQuietPeriod,
+		activity:    make(map[string]time.Time),
+	}, nil
+}
+
+// Name returns the hook name.
+func (h *Router) Name() string {
+	return RouterHookName
+}
+
+// Start routes user messages until ctx is cancelled.
+func (h *Router) Start(ctx context.Context) error {
+	messages := h.app.Messages()
+	if messages == nil {
+		return nil
+	}
+
+	events := messages.SubscribeMessages(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return h.Stop()
+		case event, ok := <-events:
+			if !ok {
+				return h.Stop()
+			}
+			h.handleEvent(ctx, event)
+		}
+	}
+}
+
+// Stop waits for automatic runs to finish.
+func (h *Router) Stop() error {
+	h.wg.Wait()
+	return nil
+}
+
+func (h *Router) handleEvent(ctx context.Context, event plugin.MessageEvent) {
+	msg := event.Message
+	h.noteActivity(msg.SessionID)
+	if event.Type != plugin.MessageCreated || msg.Role != plugin.MessageRoleUser {
+		return
+	}
+	if strings.HasPrefix(msg.Content, autoRoutePrefix) {
+		return
+	}
+
+	names := h.registry.MatchTriggers(msg.Content)
+	h.registry.setSuggested(names)
+	if len(names) == 0 {
+		return
+	}
+	h.registry.logger.Info("user message matches sub-agent triggers", "agents", names, "mode", h.mode)
+
+	if h.mode == RouteAuto {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			h.autoRun(ctx, msg.SessionID, names, msg.Content)
+		}()
+	}
+}
+
+// autoRun runs the matched agents on the user's message and submits their
+// results to the session.
+func (h *Router) autoRun(ctx context.Context, sessionID string, names []string, prompt string) {
+	var result string
+	if len(names) == 1 {
+		out, err := h.registry.Run(ctx, names[0], prompt)
+		if err != nil {
+			h.registry.logger.Warn("automatic sub-agent run failed", "agent", names[0], "error", err)
+			return
+		}
+		result = out
+	} else {
+		resp := h.registry.RunParallel(ctx, names, prompt)
+		if resp.IsError {
+			h.registry.logger.Warn("automatic sub-agent runs failed", "agents", names, "error", resp.Content)
+			return
+		}
+		result = resp.Content
+	}
+
+	submitter := h.app.PromptSubmitter()
+	if submitter == nil {
+		return
+	}
+	// The session is usually still answering the message that triggered the
+	// run, and a prompt submitted to a busy session is skipped.
+	if !h.waitIdle(ctx, sessionID) {
+		h.registry.logger.Warn("sub-agent result not submitted before shutdown", "agents", names)
+		return
+	}
+	content := fmt.Sprintf("%s Sub-agent %s ran automatically on the previous message. Its result:\n\n%s",
+		autoRoutePrefix, strings.Join(names, ", "), result)
+	if err := submitter.SubmitPromptToSession(ctx, sessionID, content); err != nil {
+		h.registry.logger.Warn("failed to submit sub-agent result", "agents", names, "error", err)
+	}
+}
+
+// noteActivity records a message in sessionID, which marks it busy for the
+// quiet period.
+func (h *Router) noteActivity(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.activity[sessionID] = time.Now()
+}
+
+// idle reports whether no messages have been seen in sessionID for the
+// quiet period.
+func (h *Router) idle(sessionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Since(h.activity[sessionID]) >= h.quietPeriod
+}
+
+// waitIdle blocks until sessionID is idle, or returns false when ctx is
+// cancelled first.
+func (h *Router) waitIdle(ctx context.Context, sessionID string) bool {
+	ticker := time.NewTicker(h.quietPeriod / 6)
+	defer ticker.Stop()
+	for {
+		if h.idle(sessionID) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package subagents
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// agentNamePattern matches valid agent names: lowercase words joined by hyphens.
+var agentNamePattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// NewAgentSpec describes a sub-agent to scaffold.
+type NewAgentSpec struct {
+	Name        string
+	Description string
+	Tools       string // Comma-separated, empty inherits all
+	Model       string // Empty defaults to inherit
+}
+
+// scaffoldFrontmatter fixes the field order of generated agent files.
+type scaffoldFrontmatter struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Tools       string `yaml:"tools,omitempty"`
+	Model       string `yaml:"model"`
+}
+
+// RenderAgentFile builds a well-formed agent file for spec, with a
+// placeholder system prompt for the user to fill in.
+func RenderAgentFile(spec NewAgentSpec) ([]byte, error) {
+	name := strings.TrimSpace(spec.Name)
+	description := strings.TrimSpace(spec.Description)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if !agentNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid name %q: use lowercase letters, digits, and hyphens", name)
+	}
+	if description == "" {
+		return nil, fmt.Errorf("description is required")
+	}
+
+	model := strings.TrimSpace(spec.Model)
+	if model == "" {
+		model = "inherit"
+	}
+
+	header, err := yaml.Marshal(scaffoldFrontmatter{
+		Name:        name,
+		Description: description,
+		Tools:       strings.Join(parseToolList(spec.Tools), ", "),
+		Model:       model,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode frontmatter: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	sb.Write(header)
+	sb.WriteString("---\n\n")
+	sb.WriteString(fmt.Sprintf("You are %s. %s\n\n", name, description))
+	sb.WriteString("Describe how this agent should approach its task, what to focus on,\n")
+	sb.WriteString("and what its final answer should contain.\n")
+	return []byte(sb.String()), nil
+}
+
+// CreateAgent writes a new agent file into the first configured local
+// directory and reloads the registry. It returns the path of the created file.
+func (r *Registry) CreateAgent(spec NewAgentSpec) (string, error) {
+	content, err := RenderAgentFile(spec)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(spec.Name)
+	if _, exists := r.Get(name); exists {
+		return "", fmt.Errorf("agent already exists: %s", name)
+	}
+
+	local, ok := r.localDir()
+	if !ok {
+		return "", fmt.Errorf("no local agent dir configured")
+	}
+	dir := ExpandPath(local, r.workingDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create agent dir: %w", err)
+	}
+
+	path := filepath.Join(dir, name+".md")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("agent file already exists: %s", path)
+		}
+		return "", fmt.Errorf("create agent file: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return "", fmt.Errorf("write agent file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("created sub-agent", "name", name, "path", path)
+	return path, nil
+}
+
+// DuplicateAgent copies the named agent's file under a new name with a
+// -copy suffix and reloads the registry. The copy is written next to the
+// original, or into the first local directory when the original comes from
+// a remote source. It returns the name of the copy.
+func (r *Registry) DuplicateAgent(name string) (string, error) {
+	agent, ok := r.Get(name)
+	if !ok {
+		return "", fmt.Errorf("agent not found: %s", name)
+	}
+
+	dir := filepath.Dir(agent.FilePath)
+	if r.isCached(agent.FilePath) {
+		local, ok := r.localDir()
+		if !ok {
+			return "", fmt.Errorf("no local agent dir configured")
+		}
+		dir = ExpandPath(local, r.workingDir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", fmt.Errorf("create agent dir: %w", err)
+		}
+	}
+
+	copyName := name + "-copy"
+	for i := 2; ; i++ {
+		_, exists := r.Get(copyName)
+		if _, err := os.Stat(filepath.Join(dir, copyName+".md")); !exists && os.IsNotExist(err) {
+			break
+		}
+		copyName = fmt.Sprintf("%s-copy-%d", name, i)
+	}
+
+	data, err := os.ReadFile(agent.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("read agent file: %w", err)
+	}
+	content, err := updateFrontmatter(data, func(mapping *yaml.Node) {
+		setScalar(mapping, "name", copyName)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, copyName+".md")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return "", fmt.Errorf("write agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("duplicated sub-agent", "name", name, "copy", copyName, "path", path)
+	return copyName, nil
+}
+
+// PromoteAgent copies the named agent's file, unchanged, into the project's
+// agent dir and reloads the registry, so that the project copy takes
+// precedence over the user-level or remote one. Claude Code agents are
+// copied into the project's Claude agent dir. It returns the new file's path.
+func (r *Registry) PromoteAgent(name string) (string, error) {
+	agent, ok := r.Get(name)
+	if !ok {
+		return "", fmt.Errorf("agent not found: %s", name)
+	}
+	if r.sourceGroup(agent) == groupProject {
+		return "", fmt.Errorf("agent %s is already a project agent", name)
+	}
+
+	dirs := r.cfg.Dirs
+	if agent.ClaudeCode {
+		dirs = ClaudeDirs
+	}
+	dir, ok := r.projectDir(dirs)
+	if !ok {
+		return "", fmt.Errorf("no project agent dir configured")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create agent dir: %w", err)
+	}
+
+	data, err := os.ReadFile(agent.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("read agent file: %w", err)
+	}
+	path := filepath.Join(dir, filepath.Base(agent.FilePath))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("agent file already exists: %s", path)
+		}
+		return "", fmt.Errorf("create agent file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("write agent file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("promoted sub-agent to project", "name", name, "from", agent.FilePath, "path", path)
+	return path, nil
+}
+
+// projectDir returns the first of dirs inside the working directory.
+func (r *Registry) projectDir(dirs []string) (string, bool) {
+	for _, dir := range dirs {
+		if _, ok := parseSource(dir); ok {
+			continue
+		}
+		if expanded := ExpandPath(dir, r.workingDir); within(r.workingDir, expanded) {
+			return expanded, true
+		}
+	}
+	return "", false
+}
+
+// DeleteAgent removes the named agent's file and reloads the registry.
+// Agents from remote sources cannot be deleted, as the next sync would
+// restore them.
+func (r *Registry) DeleteAgent(name string) error {
+	agent, ok := r.Get(name)
+	if !ok {
+		return fmt.Errorf("agent not found: %s", name)
+	}
+	if r.isCached(agent.FilePath) {
+		return fmt.Errorf("agent %s comes from a remote source", name)
+	}
+
+	if err := os.Remove(agent.FilePath); err != nil {
+		return fmt.Errorf("delete agent file: %w", err)
+	}
+
+	r.ReloadAll()
+	r.logger.Info("deleted sub-agent", "name", name, "path", agent.FilePath)
+	return nil
+}
@@ -0,0 +1,346 @@
+package subagents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultCacheDir is where remote agent sources are cloned or downloaded.
+const DefaultCacheDir = "~/.crush/agents-cache"
+
+// sourceFetchTimeout bounds each HTTP request made while syncing an index.
+const sourceFetchTimeout = 30 * time.Second
+
+const (
+	sourceGit   = "git"
+	sourceIndex = "index"
+)
+
+// remoteSource is a dirs entry that names a git repository or an HTTPS
+// index of agent files instead of a local directory.
+type remoteSource struct {
+	entry  string // Original dirs entry
+	kind   string // sourceGit or sourceIndex
+	url    string // Clone URL or index URL
+	ref    string // Pinned tag, branch, or commit (git only)
+	subdir string // Directory within the repository holding agents
+
+	// insecure is set for http:// sources, which are only synced with
+	// allow_insecure.
+	insecure bool
+}
+
+// sourceIndexFile is the JSON document served by an HTTPS index source.
+type sourceIndexFile struct {
+	// Agents lists agent file URLs, resolved relative to the index URL.
+	Agents []string `json:"agents"`
+}
+
+// parseSource interprets a dirs entry as a remote source. Entries are
+// remote when they are an https:// URL, or a repository path such as
+// github.com/org/repo[/subdir], optionally pinned with @ref. http:// URLs
+// are remote too, but marked insecure. Anything else is a local directory.
+func parseSource(entry string) (remoteSource, bool) {
+	src := remoteSource{entry: entry}
+	raw := entry
+
+	// A trailing @ref pins the version, unless the @ is part of userinfo.
+	if at := strings.LastIndex(raw, "@"); at > strings.LastIndex(raw, "/") {
+		src.ref = raw[at+1:]
+		raw = raw[:at]
+	}
+
+	if strings.HasPrefix(raw, "https://") || strings.HasPrefix(raw, "http://") {
+		src.url = raw
+		src.insecure = strings.HasPrefix(raw, "http://")
+		src.kind = sourceIndex
+		if strings.HasSuffix(raw, ".git") {
+			src.kind = sourceGit
+		}
+		return src, true
+	}
+
+	parts := strings.Split(raw, "/")
+	if len(parts) < 3 || !strings.Contains(parts[0], ".") || strings.HasPrefix(parts[0], ".") || strings.HasPrefix(parts[0], "~") {
+		return remoteSource{}, false
+	}
+	src.kind = sourceGit
+	src.url = "https://" + strings.Join(parts[:3], "/")
+	if !strings.HasSuffix(src.url, ".git") {
+		src.url += ".git"
+	}
+	src.subdir = filepath.Join(parts[3:]...)
+	return src, true
+}
+
+var unsafeCacheChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cachePath returns where the source is stored under cacheDir.
+func (s remoteSource) cachePath(cacheDir string) string {
+	return filepath.Join(cacheDir, unsafeCacheChars.ReplaceAllString(s.entry, "_"))
+}
+
+// agentDir returns the local directory holding the source's agent files.
+func (s remoteSource) agentDir(cacheDir string) string {
+	return filepath.Join(s.cachePath(cacheDir), s.subdir)
+}
+
+// agentDirs returns the configured dirs with remote sources replaced by
+// their local cache directories.
+func (r *Registry) agentDirs() []string {
+	return agentDirs(r.cfg.Dirs, r.cacheDir)
+}
+
+// agentDirs returns configured with remote sources replaced by their
+// directories under cacheDir.
+func agentDirs(configured []string, cacheDir string) []string {
+	dirs := make([]string, 0, len(configured))
+	for _, dir := range configured {
+		if src, ok := parseSource(dir); ok {
+			dirs = append(dirs, src.agentDir(cacheDir))
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// localDir returns the first configured dir that is not a remote source.
+func (r *Registry) localDir() (string, bool) {
+	for _, dir := range r.cfg.Dirs {
+		if _, ok := parseSource(dir); !ok {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// isCached reports whether path lies within the remote source cache.
+func (r *Registry) isCached(path string) bool {
+	return within(r.cacheDir, path)
+}
+
+// sourceRoot returns the cache directory of the remote source path was
+// fetched into, or "" for local agents.
+func (r *Registry) sourceRoot(path string) string {
+	for _, src := range r.remoteSources() {
+		if dir := src.cachePath(r.cacheDir); within(dir, path) {
+			return dir
+		}
+	}
+	if r.isCached(path) {
+		return r.cacheDir
+	}
+	return ""
+}
+
+// loadFile loads the agent file at path. Agents from remote sources are not
+// trusted like local ones: they may include only files from their own
+// source and their contextFiles are ignored, since both would send local
+// files to the model.
+func (r *Registry) loadFile(path string, claude bool) (*SubAgent, error) {
+	if claude {
+		return LoadClaudeAgentFile(path)
+	}
+	root := r.sourceRoot(path)
+	agent, err := loadAgentFile(path, root)
+	if err != nil || root == "" {
+		return agent, err
+	}
+	if len(agent.ContextFiles) > 0 {
+		r.logger.Warn("ignoring contextFiles of a sub-agent from a remote source", "name", agent.Name, "path", path)
+		agent.ContextFiles = nil
+	}
+	return agent, nil
+}
+
+// remoteSources returns the configured remote sources.
+func (r *Registry) remoteSources() []remoteSource {
+	var sources []remoteSource
+	for _, dir := range r.cfg.Dirs {
+		if src, ok := parseSource(dir); ok {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// SyncSources fetches every remote source into the cache and reloads the
+// registry. Sources that fail keep their previously cached agents.
+func (r *Registry) SyncSources(ctx context.Context) error {
+	sources := r.remoteSources()
+	if len(sources) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, src := range sources {
+		if src.insecure && !r.cfg.AllowInsecure {
+			errs = append(errs, fmt.Errorf("sync %s: http:// sources need allow_insecure", src.entry))
+			continue
+		}
+		var err error
+		dir := src.cachePath(r.cacheDir)
+		switch src.kind {
+		case sourceGit:
+			err = syncGitSource(ctx, src, dir)
+		case sourceIndex:
+			err = syncIndexSource(ctx, src, dir, r.cfg.AllowInsecure)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sync %s: %w", src.entry, err))
+			continue
+		}
+		r.logger.Debug("synced agent source", "source", src.entry, "dir", dir)
+	}
+
+	r.ReloadAll()
+	return errors.Join(errs...)
+}
+
+// syncGitSource clones or updates a repository and checks out its pinned
+// ref, or the remote default branch when unpinned.
+func syncGitSource(ctx context.Context, src remoteSource, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+			return err
+		}
+		if err := runGit(ctx, "", "clone", "--quiet", src.url, dir); err != nil {
+			return err
+		}
+	} else if err := runGit(ctx, dir, "fetch", "--quiet", "--tags", "--force", "origin"); err != nil {
+		return err
+	}
+
+	target := "origin/HEAD"
+	if src.ref != "" {
+		// Prefer the remote branch so a pinned branch tracks upstream.
+		target = src.ref
+		if runGit(ctx, dir, "rev-parse", "--verify", "--quiet", "origin/"+src.ref+"^{commit}") == nil {
+			target = "origin/" + src.ref
+		}
+	}
+	return runGit(ctx, dir, "checkout", "--quiet", "--detach", target)
+}
+
+// runGit runs a git command, including its output in any error.
+func runGit(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// syncIndexSource downloads every agent file listed by an HTTPS index and
+// replaces the cached copies. Nothing is replaced unless all downloads
+// succeed. Files are fetched over plain HTTP only with allowInsecure.
+func syncIndexSource(ctx context.Context, src remoteSource, dir string, allowInsecure bool) error {
+	base, err := url.Parse(src.url)
+	if err != nil {
+		return err
+	}
+
+	data, err := fetchURL(ctx, src.url, allowInsecure)
+	if err != nil {
+		return err
+	}
+	var index sourceIndexFile
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("parse index: %w", err)
+	}
+
+	files := make(map[string][]byte, len(index.Agents))
+	for _, ref := range index.Agents {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return fmt.Errorf("agent %q: %w", ref, err)
+		}
+		name := path.Base(u.Path)
+		if !strings.HasSuffix(name, ".md") {
+			return fmt.Errorf("agent %q: not a .md file", ref)
+		}
+		content, err := fetchURL(ctx, u.String(), allowInsecure)
+		if err != nil {
+			return err
+		}
+		files[name] = content
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.md"))
+	for _, old := range stale {
+		if err := os.Remove(old); err != nil {
+			return err
+		}
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchURL performs a GET request and returns the body of a 200 response.
+// http:// URLs, and redirects to them, are refused unless allowInsecure is
+// set.
+func fetchURL(ctx context.Context, u string, allowInsecure bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sourceFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScheme(req.URL, allowInsecure); err != nil {
+		return nil, err
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkScheme(req.URL, allowInsecure)
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// checkScheme refuses URLs that are not https://, or http:// with
+// allowInsecure.
+func checkScheme(u *url.URL, allowInsecure bool) error {
+	switch {
+	case u.Scheme == "https", u.Scheme == "http" && allowInsecure:
+		return nil
+	case u.Scheme == "http":
+		return fmt.Errorf("GET %s: http:// needs allow_insecure", u)
+	default:
+		return fmt.Errorf("GET %s: unsupported scheme %q", u, u.Scheme)
+	}
+}
@@ -0,0 +1,113 @@
+package subagents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultStateFile is where enabled state set at runtime is kept, relative
+// to the working directory, when no state_file is configured.
+const DefaultStateFile = ".crush/subagents/state.json"
+
+// agentStates persists the enabled state users set on agents, keyed by the
+// agent's file path so that it survives restarts, reloads, and renames of
+// the agent itself. Only states that differ from the agent's default are
+// stored.
+type agentStates struct {
+	mu   sync.Mutex
+	path string
+}
+
+// stateFile is the on-disk form of agentStates.
+type stateFile struct {
+	Enabled map[string]bool `json:"enabled"`
+}
+
+func newAgentStates(path string) *agentStates {
+	return &agentStates{path: path}
+}
+
+// load returns the stored states. A missing or unreadable file means none.
+func (s *agentStates) load() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states, _ := s.read()
+	return states
+}
+
+func (s *agentStates) read() (map[string]bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return map[string]bool{}, err
+	}
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return map[string]bool{}, err
+	}
+	if f.Enabled == nil {
+		f.Enabled = map[string]bool{}
+	}
+	return f.Enabled, nil
+}
+
+// set records that the agent file at file is enabled or not. A state equal
+// to the file's default is removed instead, so that later edits to the
+// disabled field take effect.
+func (s *agentStates) set(file string, enabled, def bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.read()
+	if err != nil {
+		return fmt.Errorf("read agent state: %w", err)
+	}
+	if enabled == def {
+		if _, ok := states[file]; !ok {
+			return nil
+		}
+		delete(states, file)
+	} else {
+		states[file] = enabled
+	}
+
+	data, err := json.MarshalIndent(stateFile{Enabled: states}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write agent state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write agent state: %w", err)
+	}
+	return nil
+}
+
+// defaultEnabled reports whether agent is enabled when the user has not
+// toggled it: not disabled in its frontmatter and, when enabled_tags is
+// configured, tagged with one of them.
+func (r *Registry) defaultEnabled(agent *SubAgent) bool {
+	if agent.Disabled {
+		return false
+	}
+	return len(r.cfg.EnabledTags) == 0 || agent.hasAnyTag(r.cfg.EnabledTags)
+}
+
+// applyState sets agent.Enabled from states, falling back to its default.
+func (r *Registry) applyState(agent *SubAgent, states map[string]bool) {
+	agent.Enabled = r.defaultEnabled(agent)
+	if enabled, ok := states[agent.FilePath]; ok && agent.FilePath != "" {
+		agent.Enabled = enabled
+	}
+}
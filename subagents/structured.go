@@ -0,0 +1,204 @@
+package subagents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// outputSchema is a JSON schema declared in frontmatter, either as a YAML
+// mapping or as a JSON string. Validation supports the type, enum,
+// properties, required, additionalProperties, and items keywords.
+type outputSchema map[string]any
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *outputSchema) UnmarshalYAML(node *yaml.Node) error {
+	var raw any
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+		return nil
+	case node.Kind == yaml.ScalarNode:
+		if err := json.Unmarshal([]byte(node.Value), &raw); err != nil {
+			return fmt.Errorf("line %d: outputSchema is not valid JSON: %w", node.Line, err)
+		}
+	case node.Kind == yaml.MappingNode:
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: outputSchema must be a mapping or a JSON string", node.Line)
+	}
+
+	// Round-trip through JSON so values compare like decoded sub-agent output.
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("line %d: outputSchema: %w", node.Line, err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return fmt.Errorf("line %d: outputSchema must be an object", node.Line)
+	}
+	*s = schema
+	return nil
+}
+
+// parse extracts the JSON value from a sub-agent reply, validates it, and
+// returns it re-encoded as indented JSON.
+func (s outputSchema) parse(reply string) (string, error) {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "", fmt.Errorf("reply is not valid JSON: %w", err)
+	}
+	if err := validateSchema(s, value, "$"); err != nil {
+		return "", err
+	}
+
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// prompt appends output instructions for the schema to the task prompt.
+func (s outputSchema) prompt(task string) string {
+	schema, _ := json.MarshalIndent(map[string]any(s), "", "  ")
+	return fmt.Sprintf("%s\n\nRespond with only a JSON value that conforms to this JSON schema, with no surrounding text:\n\n```json\n%s\n```", task, schema)
+}
+
+// runStructured runs a sub-agent that must reply with JSON matching schema.
+// An invalid reply is retried once with the validation error attached.
+func runStructured(ctx context.Context, runner plugin.SubAgentRunner, opts plugin.SubAgentOptions, schema outputSchema) (string, error) {
+	task := opts.Prompt
+	opts.Prompt = schema.prompt(task)
+	reply, err := runner.RunSubAgent(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	out, verr := schema.parse(reply)
+	if verr == nil {
+		return out, nil
+	}
+
+	opts.Prompt = fmt.Sprintf("%s\n\nYour previous reply was rejected: %v\n\nPrevious reply:\n%s", schema.prompt(task), verr, reply)
+	reply, err = runner.RunSubAgent(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	out, verr = schema.parse(reply)
+	if verr != nil {
+		return "", fmt.Errorf("invalid structured output after retry: %w", verr)
+	}
+	return out, nil
+}
+
+// validateSchema checks value against schema, reporting the first
+// violation with its JSON path.
+func validateSchema(schema map[string]any, value any, path string) error {
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		return fmt.Errorf("%s: expected type %v", path, t)
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if equalJSON(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value not in enum", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				key, _ := name.(string)
+				if _, ok := v[key]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, item := range v {
+			if sub, ok := properties[key].(map[string]any); ok {
+				if err := validateSchema(sub, item, path+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				return fmt.Errorf("%s: unexpected property %q", path, key)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesType reports whether value has the JSON type t, which may be a
+// single type name or a list of names.
+func matchesType(t, value any) bool {
+	if types, ok := t.([]any); ok {
+		for _, name := range types {
+			if matchesType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+// equalJSON compares two decoded JSON values.
+func equalJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"charm.land/fantasy"
+	"github.com/aleksclark/crush-modules/agentcaps"
+	"github.com/aleksclark/crush-modules/agentmetrics"
 	"github.com/charmbracelet/crush/plugin"
 )
 
@@ -19,7 +24,10 @@ const (
 
 <usage>
 - agent: The sub-agent name (e.g., "code-reviewer")
+- agents: Alternatively, a list of sub-agent names to run the same prompt concurrently
 - prompt: The task for the sub-agent to perform
+- session: Continue an earlier conversation with a conversation-mode sub-agent (optional)
+- files: Paths or globs of files whose contents the sub-agent should see (optional)
 
 Use this when you need specialized expertise or want to delegate a focused task.
 Each sub-agent has its own system prompt and tool access.
@@ -29,13 +37,85 @@ Each sub-agent has its own system prompt and tool access.
 - Sub-agents run independently with their own context
 - Sub-agents may have restricted tool access based on their configuration
 - Results are returned as text
+- With agents, results are combined into one section per agent
+- Conversation-mode sub-agents return a session ID; pass it back as session to follow up with the same context
+- Sub-agents may delegate to other sub-agents, up to a depth limit; an agent cannot delegate to one already running above it
+- A sub-agent may hand off to another when it finishes; the reply then has one section per agent in the chain
 </hints>
 `
 )
 
+// DefaultMaxParallel is the default number of sub-agents run concurrently
+// when fanning out a prompt to several agents.
+const DefaultMaxParallel = 4
+
+const (
+	// DefaultMaxConcurrent is the default number of top-level sub-agent runs
+	// in flight at once, across all tool calls.
+	DefaultMaxConcurrent = 4
+
+	// DefaultQueueTimeoutSeconds is how long a run waits for a free slot by
+	// default.
+	DefaultQueueTimeoutSeconds = 120
+
+	// DefaultApprovalTimeoutSeconds is how long a sub-agent waits for the
+	// user to answer a request for a disallowed tool by default.
+	DefaultApprovalTimeoutSeconds = 300
+)
+
 // Config defines configuration options for this plugin.
 type Config struct {
+	// Dirs are local directories or remote sources (git repositories or
+	// HTTPS indexes) to load agents from.
 	Dirs []string `json:"dirs,omitempty"`
+	// CacheDir stores clones and downloads of remote sources.
+	CacheDir string `json:"cache_dir,omitempty"`
+	// AllowInsecure lets dirs name http:// sources, which are fetched
+	// without TLS.
+	AllowInsecure bool `json:"allow_insecure,omitempty"`
+	// ImportClaude also loads Claude Code agents from ClaudeDirs.
+	ImportClaude bool `json:"import_claude,omitempty"`
+	// KnownTools are tool names, beyond the built-in and plugin tools, that
+	// agents may reference without an unknown tool warning.
+	KnownTools []string `json:"known_tools,omitempty"`
+	// MaxParallel caps how many sub-agents run at once for a fan-out call.
+	MaxParallel int `json:"max_parallel,omitempty"`
+	// MaxConcurrent caps how many sub-agents run at once across all calls;
+	// further runs queue for a free slot.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// QueueTimeoutSeconds is how long a queued run waits before failing.
+	QueueTimeoutSeconds int `json:"queue_timeout_seconds,omitempty"`
+	// MaxDepth caps how deeply sub-agents may delegate to other sub-agents.
+	MaxDepth int `json:"max_depth,omitempty"`
+	// MaxHandoffs caps how many times a run is handed on to another agent.
+	// Negative disables handoffs.
+	MaxHandoffs int `json:"max_handoffs,omitempty"`
+	// Watch reloads agents when files in dirs change. Defaults to true.
+	Watch *bool `json:"watch,omitempty"`
+	// HistoryFile records every sub-agent run as JSON Lines.
+	HistoryFile string `json:"history_file,omitempty"`
+	// StateFile keeps agents enabled or disabled at runtime across restarts.
+	StateFile string `json:"state_file,omitempty"`
+	// EnabledTags, when set, enables by default only agents tagged with one
+	// of these tags.
+	EnabledTags []string `json:"enabled_tags,omitempty"`
+	// Artifacts saves every agent's full result to ArtifactDir and returns a
+	// summary with the file's path instead.
+	Artifacts bool `json:"artifacts,omitempty"`
+	// ArtifactDir is where result artifacts are written.
+	ArtifactDir string `json:"artifact_dir,omitempty"`
+	// CorrectNames runs the closest agent when the requested name does not
+	// exist and exactly one agent is a near match.
+	CorrectNames bool `json:"correct_names,omitempty"`
+	// PublishMetrics shares per-agent usage with other plugins, such as
+	// agent-status and otlp, through agentmetrics.Shared.
+	PublishMetrics bool `json:"publish_metrics,omitempty"`
+	// ApprovalTimeoutSeconds is how long a sub-agent waits for the user to
+	// answer a request for a disallowed tool before it is denied.
+	ApprovalTimeoutSeconds int `json:"approval_timeout_seconds,omitempty"`
+	// ApprovalNotify shows a desktop notification when a sub-agent asks for
+	// a disallowed tool. Defaults to true.
+	ApprovalNotify *bool `json:"approval_notify,omitempty"`
 }
 
 // DefaultDirs are searched when no dirs are configured.
@@ -43,18 +123,49 @@ var DefaultDirs = []string{".crush/agents", "~/.crush/agents"}
 
 // SubAgentParams defines the parameters the LLM can pass.
 type SubAgentParams struct {
-	Agent  string `json:"agent" jsonschema:"description=The sub-agent name to invoke"`
-	Prompt string `json:"prompt" jsonschema:"description=The task for the sub-agent to perform"`
+	Agent   string   `json:"agent,omitempty" jsonschema:"description=The sub-agent name to invoke"`
+	Agents  []string `json:"agents,omitempty" jsonschema:"description=Several sub-agent names to run the same prompt concurrently (use instead of agent)"`
+	Prompt  string   `json:"prompt" jsonschema:"description=The task for the sub-agent to perform"`
+	Session string   `json:"session,omitempty" jsonschema:"description=Session ID returned by a conversation-mode sub-agent to continue that conversation"`
+	Files   []string `json:"files,omitempty" jsonschema:"description=Paths or globs, relative to the working directory, of files to include in the sub-agent prompt"`
 }
 
 // Registry manages loaded sub-agents.
 type Registry struct {
 	mu         sync.RWMutex
 	agents     map[string]*SubAgent
+	shadowed   map[string][]string // Agent name to files it shadows
+	loadErrors map[string]error    // Agent file to why it failed to load
 	app        *plugin.App
 	cfg        Config
 	logger     *slog.Logger
 	workingDir string
+	cacheDir   string
+
+	// loadMu serializes LoadAgents, which reads files without holding mu.
+	loadMu sync.Mutex
+
+	// crushVersion is the running Crush version agents are checked against.
+	crushVersion string
+
+	// parallel limits concurrent runs during fan-out.
+	parallel chan struct{}
+	// slots limits concurrent runs across all calls.
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	history       *History
+	meter         usageMeter
+	states        *agentStates
+	metrics       *agentmetrics.Collector
+	conversations *conversations
+	approvals     *approvals
+
+	// commands registers a plugin command per agent on every load.
+	commands bool
+
+	// suggested are the agents whose triggers match the latest user message.
+	suggested []string
 }
 
 var (
@@ -68,51 +179,185 @@ func getRegistry() *Registry {
 
 func init() {
 	plugin.RegisterToolWithConfig(ToolName, toolFactory, &Config{})
+	plugin.RegisterToolWithConfig(HistoryToolName, historyToolFactory, &Config{})
+	plugin.RegisterToolWithConfig(TestToolName, testToolFactory, &Config{})
 }
 
 func toolFactory(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
-	var cfg Config
-	if err := app.LoadConfig(ToolName, &cfg); err != nil {
+	registry, err := loadRegistry(app)
+	if err != nil {
 		return nil, err
 	}
+	return NewSubAgentTool(registry), nil
+}
 
-	if len(cfg.Dirs) == 0 {
-		cfg.Dirs = DefaultDirs
+// loadRegistry initializes the shared registry on first use.
+func loadRegistry(app *plugin.App) (*Registry, error) {
+	var cfg Config
+	if err := app.LoadConfig(ToolName, &cfg); err != nil {
+		return nil, err
 	}
 
 	registryOnce.Do(func() {
-		globalRegistry = &Registry{
-			agents:     make(map[string]*SubAgent),
-			app:        app,
-			cfg:        cfg,
-			logger:     app.Logger().With("plugin", ToolName),
-			workingDir: app.WorkingDir(),
+		globalRegistry = NewRegistry(app, cfg)
+		globalRegistry.commands = true
+		if globalRegistry.cfg.approvalNotifyEnabled() {
+			globalRegistry.approvals.notify = desktopNotify
 		}
 		globalRegistry.LoadAgents()
+		globalRegistry.registerAgentCommands()
+		agentcaps.Shared().Provide(ToolName, globalRegistry.Capabilities)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		app.RegisterCleanup(func() error {
+			cancel()
+			return nil
+		})
+
+		if globalRegistry.cfg.watchEnabled() {
+			go func() {
+				if err := globalRegistry.Watch(ctx); err != nil {
+					globalRegistry.logger.Warn("agent dir watcher stopped", "error", err)
+				}
+			}()
+		}
+
+		// Cached remote agents are loaded above; refresh them in the background.
+		if len(globalRegistry.remoteSources()) > 0 {
+			go func() {
+				if err := globalRegistry.SyncSources(ctx); err != nil {
+					globalRegistry.logger.Warn("failed to sync agent sources", "error", err)
+				}
+			}()
+		}
 	})
 
-	return NewSubAgentTool(globalRegistry), nil
+	return globalRegistry, nil
+}
+
+// NewRegistry creates an empty registry, applying config defaults.
+func NewRegistry(app *plugin.App, cfg Config) *Registry {
+	if len(cfg.Dirs) == 0 {
+		cfg.Dirs = DefaultDirs
+	}
+	if cfg.MaxParallel <= 0 {
+		cfg.MaxParallel = DefaultMaxParallel
+	}
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = DefaultMaxDepth
+	}
+	if cfg.MaxHandoffs == 0 {
+		cfg.MaxHandoffs = DefaultMaxHandoffs
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if cfg.QueueTimeoutSeconds <= 0 {
+		cfg.QueueTimeoutSeconds = DefaultQueueTimeoutSeconds
+	}
+	if cfg.ApprovalTimeoutSeconds <= 0 {
+		cfg.ApprovalTimeoutSeconds = DefaultApprovalTimeoutSeconds
+	}
+	if cfg.HistoryFile == "" {
+		cfg.HistoryFile = DefaultHistoryFile
+	}
+	if cfg.StateFile == "" {
+		cfg.StateFile = DefaultStateFile
+	}
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = DefaultCacheDir
+	}
+	if cfg.ArtifactDir == "" {
+		cfg.ArtifactDir = DefaultArtifactDir
+	}
+	metrics := agentmetrics.New()
+	if cfg.PublishMetrics {
+		metrics = agentmetrics.Shared()
+	}
+
+	return &Registry{
+		agents:        make(map[string]*SubAgent),
+		app:           app,
+		cfg:           cfg,
+		logger:        app.Logger().With("plugin", ToolName),
+		workingDir:    app.WorkingDir(),
+		cacheDir:      ExpandPath(cfg.CacheDir, app.WorkingDir()),
+		crushVersion:  crushVersion,
+		parallel:      make(chan struct{}, cfg.MaxParallel),
+		slots:         make(chan struct{}, cfg.MaxConcurrent),
+		queueTimeout:  time.Duration(cfg.QueueTimeoutSeconds) * time.Second,
+		history:       NewHistory(ExpandPath(cfg.HistoryFile, app.WorkingDir())),
+		states:        newAgentStates(ExpandPath(cfg.StateFile, app.WorkingDir())),
+		metrics:       metrics,
+		conversations: newConversations(),
+		approvals:     newApprovals(time.Duration(cfg.ApprovalTimeoutSeconds) * time.Second),
+	}
 }
 
-// LoadAgents discovers and loads all sub-agent files.
+// LoadAgents discovers and loads all sub-agent files. Files are read
+// without holding the lock and the loaded agents replace the previous ones
+// at once, so runs and lookups during a reload see either set in full.
 func (r *Registry) LoadAgents() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+
+	files := DiscoverAgentFiles(r.agentDirs(), r.workingDir)
+	claude := make(map[string]bool)
+	if r.cfg.ImportClaude {
+		for _, path := range DiscoverAgentFiles(ClaudeDirs, r.workingDir) {
+			claude[path] = true
+			files = append(files, path)
+		}
+	}
 
-	files := DiscoverAgentFiles(r.cfg.Dirs, r.workingDir)
-	for _, path := range files {
-		agent, err := LoadAgentFile(path)
+	loaded := make(map[string]*SubAgent)
+	shadowed := make(map[string][]string)
+	loadErrors := make(map[string]error)
+	isProject := func(path string) bool { return r.sourceGroupOf(path) == groupProject }
+	for _, path := range projectFirst(files, isProject) {
+		agent, err := r.loadFile(path, claude[path])
 		if err != nil {
 			r.logger.Warn("failed to load sub-agent", "path", path, "error", err)
+			loadErrors[path] = err
 			continue
 		}
 
-		// First match wins for duplicate names.
-		if _, exists := r.agents[agent.Name]; !exists {
-			r.agents[agent.Name] = agent
-			r.logger.Debug("loaded sub-agent", "name", agent.Name, "path", path)
+		// Project agents win, then the first match in configured order.
+		if winner, exists := loaded[agent.Name]; exists {
+			shadowed[agent.Name] = append(shadowed[agent.Name], path)
+			r.logger.Debug("sub-agent shadowed", "name", agent.Name, "path", path, "by", winner.FilePath)
+			continue
+		}
+		loaded[agent.Name] = agent
+	}
+
+	resolved, failed := resolveExtends(loaded)
+	for name, err := range failed {
+		r.logger.Warn("failed to load sub-agent", "path", loaded[name].FilePath, "error", err)
+		loadErrors[loaded[name].FilePath] = err
+	}
+	known := knownTools(r.cfg)
+	for name, agent := range resolved {
+		r.logger.Debug("loaded sub-agent", "name", name, "path", agent.FilePath)
+		if unknown := unknownTools(agent, known); len(unknown) > 0 {
+			r.logger.Warn("sub-agent references unknown tools", "name", name, "path", agent.FilePath, "tools", unknown)
+		}
+		if reason := r.incompatibility(agent); reason != "" {
+			r.logger.Warn("sub-agent may not work with this Crush", "name", name, "path", agent.FilePath, "reason", reason)
 		}
 	}
+
+	// Enabled states are read under the lock so none saved by SetEnabled
+	// during the load is lost.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := r.states.load()
+	for _, agent := range resolved {
+		r.applyState(agent, states)
+	}
+	r.agents = resolved
+	r.shadowed = shadowed
+	r.loadErrors = loadErrors
 }
 
 // Get returns a sub-agent by name.
@@ -134,101 +379,316 @@ func (r *Registry) List() []*SubAgent {
 	return agents
 }
 
-// SetEnabled enables or disables a sub-agent.
+// Capabilities lists the enabled sub-agents as "subagent:<name>" and the
+// models they run on as "model:<model>", for agentcaps.
+func (r *Registry) Capabilities() []string {
+	var caps []string
+	for _, agent := range r.List() {
+		if !agent.Enabled {
+			continue
+		}
+		caps = append(caps, "subagent:"+agent.Name)
+		if agent.Model != "" && agent.Model != "inherit" {
+			caps = append(caps, "model:"+agent.Model)
+		}
+	}
+	slices.Sort(caps)
+	return slices.Compact(caps)
+}
+
+// Shadowed returns the files of other agents named name that were not loaded
+// because the loaded agent takes precedence.
+func (r *Registry) Shadowed(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.shadowed[name]
+}
+
+// Usage returns the accumulated usage of a sub-agent since startup.
+func (r *Registry) Usage(name string) agentmetrics.Usage {
+	return r.metrics.Get(name)
+}
+
+// SetEnabled enables or disables a sub-agent. The state is saved under the
+// agent's file path and restored on reload and restart.
 func (r *Registry) SetEnabled(name string, enabled bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if agent, ok := r.agents[name]; ok {
-		agent.Enabled = enabled
+	agent, ok := r.agents[name]
+	if !ok {
+		return
+	}
+	agent.Enabled = enabled
+	if agent.FilePath == "" {
+		return
+	}
+	if err := r.states.set(agent.FilePath, enabled, r.defaultEnabled(agent)); err != nil {
+		r.logger.Warn("failed to save sub-agent state", "name", name, "error", err)
 	}
 }
 
-// ReloadAgent reloads a specific agent from disk.
+// ReloadAgent reloads a specific agent from disk. Every agent is reloaded,
+// so that those extending it pick up its changes, and the error returned is
+// why the agent's file failed to load.
 func (r *Registry) ReloadAgent(name string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	agent, ok := r.agents[name]
+	agent, ok := r.Get(name)
 	if !ok {
 		return fmt.Errorf("agent not found: %s", name)
 	}
 
-	newAgent, err := LoadAgentFile(agent.FilePath)
-	if err != nil {
+	r.ReloadAll()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.loadErrors[agent.FilePath]; err != nil {
 		return err
 	}
-
-	// Preserve enabled state.
-	newAgent.Enabled = agent.Enabled
-	r.agents[name] = newAgent
+	if _, ok := r.agents[name]; !ok {
+		return fmt.Errorf("agent not found after reload: %s", name)
+	}
 	return nil
 }
 
 // ReloadAll reloads all agents from disk.
 func (r *Registry) ReloadAll() {
-	r.mu.Lock()
-	// Preserve enabled states.
-	enabledStates := make(map[string]bool)
-	for name, agent := range r.agents {
-		enabledStates[name] = agent.Enabled
-	}
-	r.agents = make(map[string]*SubAgent)
-	r.mu.Unlock()
-
+	// Enabled states are restored from the state file as agents load.
 	r.LoadAgents()
 
-	// Restore enabled states.
-	r.mu.Lock()
-	for name, enabled := range enabledStates {
-		if agent, ok := r.agents[name]; ok {
-			agent.Enabled = enabled
-		}
+	if r.commands {
+		r.registerAgentCommands()
 	}
-	r.mu.Unlock()
+}
+
+// registryTool rebuilds its description from the registry on every Info
+// call, so agents added or removed at runtime are visible to the LLM.
+type registryTool struct {
+	fantasy.AgentTool
+	registry *Registry
+}
+
+// Info returns the tool info with the current agent list.
+func (t *registryTool) Info() fantasy.ToolInfo {
+	info := t.AgentTool.Info()
+	info.Description = buildDescription(t.registry)
+	return info
 }
 
 // NewSubAgentTool creates the SubAgent tool.
 func NewSubAgentTool(registry *Registry) fantasy.AgentTool {
-	return fantasy.NewAgentTool(
+	tool := fantasy.NewAgentTool(
 		ToolName,
 		buildDescription(registry),
 		func(ctx context.Context, params SubAgentParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
-			if params.Agent == "" {
+			if params.Agent == "" && len(params.Agents) == 0 {
 				return fantasy.NewTextErrorResponse("agent name is required"), nil
 			}
+			if params.Agent != "" && len(params.Agents) > 0 {
+				return fantasy.NewTextErrorResponse("use either agent or agents, not both"), nil
+			}
 			if params.Prompt == "" {
 				return fantasy.NewTextErrorResponse("prompt is required"), nil
 			}
 
-			agent, ok := registry.Get(params.Agent)
-			if !ok {
-				return fantasy.NewTextErrorResponse(fmt.Sprintf("sub-agent not found: %s", params.Agent)), nil
+			if err := checkFiles(params.Files); err != nil {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
 			}
-
-			if !agent.Enabled {
-				return fantasy.NewTextErrorResponse(fmt.Sprintf("sub-agent is disabled: %s", params.Agent)), nil
-			}
-
-			runner := registry.app.SubAgentRunner()
-			if runner == nil {
-				return fantasy.NewTextErrorResponse("sub-agent runner not available"), nil
+			ctx = withToolCall(WithFiles(ctx, params.Files), call.ID)
+			if len(params.Agents) > 0 {
+				for i, name := range params.Agents {
+					params.Agents[i] = registry.correctName(name)
+				}
+				if params.Session != "" {
+					return fantasy.NewTextErrorResponse("session cannot be used with agents"), nil
+				}
+				return registry.RunParallel(ctx, params.Agents, params.Prompt), nil
 			}
 
-			result, err := runner.RunSubAgent(ctx, plugin.SubAgentOptions{
-				Name:            agent.Name,
-				SystemPrompt:    agent.SystemPrompt,
-				Prompt:          params.Prompt,
-				AllowedTools:    agent.Tools,
-				DisallowedTools: agent.DisallowedTools,
-				Model:           agent.Model,
-			})
+			requested := params.Agent
+			params.Agent = registry.correctName(requested)
+			result, session, err := registry.RunSession(ctx, params.Agent, params.Session, params.Prompt)
 			if err != nil {
-				return fantasy.NewTextErrorResponse(fmt.Sprintf("sub-agent execution failed: %v", err)), nil
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			result = registry.followHandoffs(ctx, params.Agent, result)
+			if params.Agent != requested {
+				result += fmt.Sprintf("\n\n[No sub-agent is named %s; ran %s instead]", requested, params.Agent)
+			}
+			if session != "" {
+				result += fmt.Sprintf("\n\n<session>%s</session>", session)
 			}
 
 			return fantasy.NewTextResponse(result), nil
 		},
 	)
+
+	return &registryTool{AgentTool: tool, registry: registry}
+}
+
+// Run executes a single sub-agent by name.
+func (r *Registry) Run(ctx context.Context, name, prompt string) (string, error) {
+	agent, runner, err := r.runnable(name)
+	if err != nil {
+		return "", err
+	}
+
+	started := time.Now()
+	ctx, run, err := r.beginRun(ctx, agent)
+	if err != nil {
+		return "", err
+	}
+	result, err := r.execute(ctx, runner, agent, prompt)
+	r.recordRun(ctx, agent.Name, prompt, started, run, result, err)
+	return result, err
+}
+
+// RunSession executes a sub-agent, continuing the conversation identified
+// by session for conversation-mode agents. An empty session starts a new
+// conversation. The returned session is empty for agents that do not keep
+// conversations.
+func (r *Registry) RunSession(ctx context.Context, name, session, prompt string) (result, sessionID string, err error) {
+	agent, runner, err := r.runnable(name)
+	if err != nil {
+		return "", "", err
+	}
+	if !agent.Conversation {
+		if session != "" {
+			return "", "", fmt.Errorf("sub-agent %s does not keep conversations", name)
+		}
+		result, err = r.Run(ctx, name, prompt)
+		return result, "", err
+	}
+
+	conv, err := r.conversations.open(agent.Name, session)
+	if err != nil {
+		return "", "", err
+	}
+
+	started := time.Now()
+	ctx, run, err := r.beginRun(ctx, agent)
+	if err != nil {
+		return "", conv.id, err
+	}
+	result, err = r.execute(ctx, runner, agent, r.conversations.prompt(conv, prompt))
+	r.recordRun(ctx, agent.Name, prompt, started, run, result, err)
+	if err != nil {
+		return "", conv.id, err
+	}
+	r.conversations.record(conv, prompt, result)
+	return result, conv.id, nil
+}
+
+// runnable returns an enabled agent and the runner to execute it with.
+func (r *Registry) runnable(name string) (*SubAgent, plugin.SubAgentRunner, error) {
+	agent, ok := r.Get(name)
+	if !ok {
+		return nil, nil, r.notFound(name)
+	}
+
+	if !agent.Enabled {
+		return nil, nil, fmt.Errorf("sub-agent is disabled: %s", name)
+	}
+
+	runner := r.app.SubAgentRunner()
+	if runner == nil {
+		return nil, nil, fmt.Errorf("sub-agent runner not available")
+	}
+	return agent, runner, nil
+}
+
+// execute runs agent within its limits.
+func (r *Registry) execute(ctx context.Context, runner plugin.SubAgentRunner, agent *SubAgent, prompt string) (string, error) {
+	release, err := r.acquire(ctx, agent.Name)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// Shown by agent-status while the run is in progress.
+	defer r.metrics.Begin(agent.Name, parentAgent(ctx))()
+
+	ctx, opts, settings, err := r.runOptions(ctx, agent, prompt)
+	if err != nil {
+		return "", err
+	}
+	if err := r.applyRunSettings(&opts, agent, settings); err != nil {
+		return "", err
+	}
+
+	runCtx, finish := r.withLimits(ctx, agent)
+	var result string
+	if agent.OutputSchema != nil {
+		result, err = runStructured(runCtx, runner, opts, agent.OutputSchema)
+	} else {
+		result, err = runner.RunSubAgent(runCtx, opts)
+	}
+	if limitErr := finish(); limitErr != nil {
+		r.logger.Warn("sub-agent stopped by limit", "agent", agent.Name, "reason", limitErr)
+		return "", fmt.Errorf("sub-agent %s stopped: %v", agent.Name, limitErr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sub-agent execution failed: %v", err)
+	}
+	raw := result
+	if agent.PostProcess != nil {
+		if result, err = agent.PostProcess.apply(agent.Name, result); err != nil {
+			return "", err
+		}
+	}
+	if r.usesArtifact(agent) {
+		return r.saveArtifact(agent.Name, raw, result)
+	}
+
+	return result, nil
+}
+
+// runOptions builds what the runner receives for a run of agent, the
+// settings that applyRunSettings adds to it, and the context to run it in.
+func (r *Registry) runOptions(ctx context.Context, agent *SubAgent, prompt string) (context.Context, plugin.SubAgentOptions, runSettings, error) {
+	dir, err := r.agentDir(agent)
+	if err != nil {
+		return nil, plugin.SubAgentOptions{}, runSettings{}, err
+	}
+	ctx, depth, err := r.enter(ctx, agent)
+	if err != nil {
+		return nil, plugin.SubAgentOptions{}, runSettings{}, err
+	}
+	opts := plugin.SubAgentOptions{
+		Name:            agent.Name,
+		SystemPrompt:    agent.SystemPrompt,
+		Prompt:          r.attachFiles(ctx, agent, prompt),
+		AllowedTools:    agent.Tools,
+		DisallowedTools: r.delegationTools(agent, depth),
+		Model:           agent.Model,
+	}
+	settings := runSettings{
+		MCPServers:      agent.MCPServers,
+		Temperature:     agent.Temperature,
+		TopP:            agent.TopP,
+		ReasoningEffort: agent.ReasoningEffort,
+		WorkingDir:      dir,
+		Env:             envList(agent.Env),
+		ApproveTool: func(ctx context.Context, tool string) bool {
+			// Past the depth limit delegation fails regardless of approval.
+			if NormalizeTool(tool) == ToolName && depth >= r.cfg.MaxDepth {
+				return false
+			}
+			return r.requestApproval(ctx, agent.Name, tool)
+		},
+	}
+	return ctx, opts, settings, nil
+}
+
+// runSettings are the per-agent run options that need SubAgentOptions
+// fields beyond the published plugin API. applyRunSettings passes them on
+// when the build has them; see pluginapi_next.go.
+type runSettings struct {
+	MCPServers      []string
+	Temperature     *float64
+	TopP            *float64
+	ReasoningEffort string
+	WorkingDir      string
+	Env             []string
+	ApproveTool     func(ctx context.Context, tool string) bool
 }
 
 // buildDescription creates the tool description with available agents.
@@ -238,12 +698,13 @@ func buildDescription(registry *Registry) string {
 		return Description + "\n<available_agents>\nNo sub-agents configured.\n</available_agents>"
 	}
 
-	var sb fmt.Stringer = &descBuilder{agents: agents}
+	var sb fmt.Stringer = &descBuilder{agents: agents, suggested: registry.Suggested()}
 	return Description + sb.String()
 }
 
 type descBuilder struct {
-	agents []*SubAgent
+	agents    []*SubAgent
+	suggested []string
 }
 
 func (d *descBuilder) String() string {
@@ -255,5 +716,9 @@ func (d *descBuilder) String() string {
 		}
 	}
 	result += "</available_agents>"
+	if len(d.suggested) > 0 {
+		result += "\n<suggested_agents>\nThe user's latest message matches the triggers of: " +
+			strings.Join(d.suggested, ", ") + ". Consider delegating to them.\n</suggested_agents>"
+	}
 	return result
 }
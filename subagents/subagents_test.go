@@ -1,10 +1,27 @@
 package subagents
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"charm.land/fantasy"
+	"github.com/aleksclark/crush-modules/agentmetrics"
+	"github.com/aleksclark/crush-modules/testutil"
+	"github.com/charmbracelet/crush/plugin"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,7 +50,7 @@ Review code changes carefully.`,
 			wantAgent: &SubAgent{
 				Name:           "code-reviewer",
 				Description:    "Expert code reviewer for quality checks",
-				Tools:          []string{"Read", "Grep", "Glob"},
+				Tools:          []string{"view", "grep", "glob"},
 				Model:          "sonnet",
 				PermissionMode: "acceptEdits",
 				SystemPrompt:   "You are a senior code reviewer with expertise in Go and TypeScript.\nReview code changes carefully.",
@@ -68,12 +85,187 @@ You cannot use Bash or Write tools.`,
 			wantAgent: &SubAgent{
 				Name:            "safe-agent",
 				Description:     "Agent with restricted tools",
-				DisallowedTools: []string{"Bash", "Write"},
+				DisallowedTools: []string{"bash", "write"},
 				Model:           "inherit",
 				SystemPrompt:    "You cannot use Bash or Write tools.",
 				Enabled:         true,
 			},
 		},
+		{
+			name: "agent with YAML list tools",
+			content: `---
+name: list-agent
+description: Agent with list syntax
+tools: [Read, Grep]
+disallowedTools:
+  - Bash
+  - " Write "
+---
+
+Use lists.`,
+			wantAgent: &SubAgent{
+				Name:            "list-agent",
+				Description:     "Agent with list syntax",
+				Tools:           []string{"view", "grep"},
+				DisallowedTools: []string{"bash", "write"},
+				Model:           "inherit",
+				SystemPrompt:    "Use lists.",
+				Enabled:         true,
+			},
+		},
+		{
+			name: "invalid tools type",
+			content: `---
+name: bad-tools
+description: Tools as a mapping
+tools:
+  read: true
+---
+
+Body.`,
+			wantErr:     true,
+			errContains: "must be a string or a list",
+		},
+		{
+			name: "agent with limits",
+			content: `---
+name: bounded
+description: Agent with limits
+maxTokens: 5000
+maxCostUsd: 0.25
+timeout: 90s
+---
+
+Stay within budget.`,
+			wantAgent: &SubAgent{
+				Name:         "bounded",
+				Description:  "Agent with limits",
+				Model:        "inherit",
+				MaxTokens:    5000,
+				MaxCostUSD:   0.25,
+				Timeout:      90 * time.Second,
+				SystemPrompt: "Stay within budget.",
+				Enabled:      true,
+			},
+		},
+		{
+			name: "agent with generation parameters",
+			content: `---
+name: brainstormer
+description: Runs hot
+temperature: 1.2
+topP: 0.95
+reasoningEffort: low
+---
+
+Think wide.`,
+			wantAgent: &SubAgent{
+				Name:            "brainstormer",
+				Description:     "Runs hot",
+				Model:           "inherit",
+				Temperature:     new(1.2),
+				TopP:            new(0.95),
+				ReasoningEffort: "low",
+				SystemPrompt:    "Think wide.",
+				Enabled:         true,
+			},
+		},
+		{
+			name: "agent with MCP servers",
+			content: `---
+name: db-analyst
+description: Queries the database
+mcpServers: [postgres-readonly]
+---
+
+Answer with SQL.`,
+			wantAgent: &SubAgent{
+				Name:         "db-analyst",
+				Description:  "Queries the database",
+				Model:        "inherit",
+				MCPServers:   toolList{"postgres-readonly"},
+				SystemPrompt: "Answer with SQL.",
+				Enabled:      true,
+			},
+		},
+		{
+			name: "agent with no MCP servers",
+			content: `---
+name: offline
+description: No MCP access
+mcpServers: []
+---
+
+Work offline.`,
+			wantAgent: &SubAgent{
+				Name:         "offline",
+				Description:  "No MCP access",
+				Model:        "inherit",
+				MCPServers:   toolList{},
+				SystemPrompt: "Work offline.",
+				Enabled:      true,
+			},
+		},
+		{
+			name: "invalid minCrushVersion",
+			content: `---
+name: future
+description: Needs a release
+minCrushVersion: latest
+---
+`,
+			wantErr:     true,
+			errContains: `invalid minCrushVersion "latest"`,
+		},
+		{
+			name: "invalid temperature",
+			content: `---
+name: brainstormer
+description: Runs hot
+temperature: 3
+---
+
+Body.`,
+			wantErr:     true,
+			errContains: "temperature must be between 0 and 2",
+		},
+		{
+			name: "invalid reasoning effort",
+			content: `---
+name: thinker
+description: Thinks
+reasoningEffort: extreme
+---
+
+Body.`,
+			wantErr:     true,
+			errContains: "invalid reasoningEffort",
+		},
+		{
+			name: "invalid timeout",
+			content: `---
+name: bounded
+description: Agent with limits
+timeout: soon
+---
+
+Body.`,
+			wantErr:     true,
+			errContains: "invalid timeout",
+		},
+		{
+			name: "invalid env name",
+			content: `---
+name: frontend
+description: Agent with env
+env:
+  NODE ENV: development
+---
+
+Body.`,
+			wantErr:     true,
+			errContains: "invalid env variable name",
+		},
 		{
 			name: "missing name",
 			content: `---
@@ -139,6 +331,13 @@ Body.`,
 			require.Equal(t, tt.wantAgent.PermissionMode, agent.PermissionMode)
 			require.Equal(t, tt.wantAgent.SystemPrompt, agent.SystemPrompt)
 			require.Equal(t, tt.wantAgent.Enabled, agent.Enabled)
+			require.Equal(t, tt.wantAgent.MaxTokens, agent.MaxTokens)
+			require.Equal(t, tt.wantAgent.MaxCostUSD, agent.MaxCostUSD)
+			require.Equal(t, tt.wantAgent.Timeout, agent.Timeout)
+			require.Equal(t, tt.wantAgent.Temperature, agent.Temperature)
+			require.Equal(t, tt.wantAgent.TopP, agent.TopP)
+			require.Equal(t, tt.wantAgent.ReasoningEffort, agent.ReasoningEffort)
+			require.Equal(t, tt.wantAgent.MCPServers, agent.MCPServers)
 			require.Equal(t, path, agent.FilePath)
 		})
 	}
@@ -245,19 +444,2328 @@ func TestDiscoverAgentFilesNonExistentDir(t *testing.T) {
 	require.Empty(t, files)
 }
 
-func TestSplitFrontmatter(t *testing.T) {
+// fakeRunner records sub-agent runs and replies with a canned result.
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   []plugin.SubAgentOptions
+	fail    map[string]bool
+	replies []string // Returned in call order, when set
+	delay   time.Duration
+	block   bool
+	onRun   func()
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (f *fakeRunner) RunSubAgent(ctx context.Context, opts plugin.SubAgentOptions) (string, error) {
+	n := f.running.Add(1)
+	defer f.running.Add(-1)
+	for {
+		p := f.peak.Load()
+		if n <= p || f.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, opts)
+	call := len(f.calls)
+	f.mu.Unlock()
+
+	if f.onRun != nil {
+		f.onRun()
+	}
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if f.block {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if f.fail[opts.Name] {
+		return "", errors.New("boom")
+	}
+	if call <= len(f.replies) {
+		return f.replies[call-1], nil
+	}
+	return "result from " + opts.Name, nil
+}
+
+// fakeSessionInfo reports session totals that tests can bump.
+type fakeSessionInfo struct {
+	tokens atomic.Int64
+}
+
+func (f *fakeSessionInfo) SessionInfo() *plugin.SessionInfo {
+	return &plugin.SessionInfo{Tokens: plugin.TokenInfo{Output: f.tokens.Load()}}
+}
+
+func newTestRegistry(t *testing.T, runner plugin.SubAgentRunner, cfg Config, names ...string) *Registry {
+	t.Helper()
+	return newTestRegistryWithApp(t, plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(runner),
+	), cfg, names...)
+}
+
+func newTestRegistryWithApp(t *testing.T, app *plugin.App, cfg Config, names ...string) *Registry {
+	t.Helper()
+
+	r := NewRegistry(app, cfg)
+	for _, name := range names {
+		r.agents[name] = &SubAgent{Name: name, Description: name, Enabled: true}
+	}
+	return r
+}
+
+func TestRegistryRun(t *testing.T) {
 	t.Parallel()
 
-	content := `---
-name: test
-description: test desc
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{}, "reviewer")
+	r.agents["off"] = &SubAgent{Name: "off"}
+
+	out, err := r.Run(context.Background(), "reviewer", "check it")
+	require.NoError(t, err)
+	require.Equal(t, "result from reviewer", out)
+	require.Equal(t, "check it", runner.calls[0].Prompt)
+
+	_, err = r.Run(context.Background(), "missing", "x")
+	require.ErrorContains(t, err, "not found")
+
+	_, err = r.Run(context.Background(), "off", "x")
+	require.ErrorContains(t, err, "disabled")
+}
+
+func TestRunParallel(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{fail: map[string]bool{"bad": true}}
+	r := newTestRegistry(t, runner, Config{}, "alpha", "beta", "bad")
+
+	resp := r.RunParallel(context.Background(), []string{"beta", "alpha", "bad", "alpha", " "}, "go")
+	require.False(t, resp.IsError)
+	require.Equal(t, "## beta\n\nresult from beta\n\n## alpha\n\nresult from alpha\n\n## bad\n\nError: sub-agent execution failed: boom", resp.Content)
+	require.Len(t, runner.calls, 3)
+
+	resp = r.RunParallel(context.Background(), []string{"bad", "missing"}, "go")
+	require.True(t, resp.IsError)
+}
+
+func TestRunParallelLimit(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{delay: 20 * time.Millisecond}
+	r := newTestRegistry(t, runner, Config{MaxParallel: 2}, "a", "b", "c", "d", "e")
+
+	resp := r.RunParallel(context.Background(), []string{"a", "b", "c", "d", "e"}, "go")
+	require.False(t, resp.IsError)
+	require.Len(t, runner.calls, 5)
+	require.LessOrEqual(t, runner.peak.Load(), int32(2))
+}
+
+// delegatingRunner runs sub-agents that call the subagent tool themselves,
+// using the run's context as the runner would for the agent's tools.
+type delegatingRunner struct {
+	fakeRunner
+	tool      fantasy.AgentTool
+	delegates map[string]string // Agent name to the tool input it sends
+}
+
+func (d *delegatingRunner) RunSubAgent(ctx context.Context, opts plugin.SubAgentOptions) (string, error) {
+	input, ok := d.delegates[opts.Name]
+	if !ok {
+		return d.fakeRunner.RunSubAgent(ctx, opts)
+	}
+	d.mu.Lock()
+	d.calls = append(d.calls, opts)
+	d.mu.Unlock()
+	resp, err := d.tool.Run(ctx, fantasy.ToolCall{ID: opts.Name, Name: ToolName, Input: input})
+	if err != nil {
+		return "", err
+	}
+	return opts.Name + " got: " + resp.Content, nil
+}
+
+func TestNestedDelegation(t *testing.T) {
+	t.Parallel()
+
+	runner := &delegatingRunner{delegates: map[string]string{
+		"planner": `{"agents":["coder","tester"],"prompt":"build it"}`,
+		"loop":    `{"agent":"looper","prompt":"again"}`,
+		"looper":  `{"agent":"loop","prompt":"again"}`,
+		"deep":    `{"agent":"deeper","prompt":"down"}`,
+		"deeper":  `{"agent":"coder","prompt":"down"}`,
+	}}
+	r := newTestRegistry(t, runner, Config{MaxParallel: 1}, "planner", "coder", "tester", "loop", "looper", "deep", "deeper")
+	runner.tool = NewSubAgentTool(r)
+
+	out, err := r.Run(context.Background(), "planner", "ship")
+	require.NoError(t, err)
+	require.Equal(t, "planner got: ## coder\n\nresult from coder\n\n## tester\n\nresult from tester", out)
+	require.NotContains(t, runner.calls[0].DisallowedTools, ToolName)
+	require.Contains(t, runner.calls[1].DisallowedTools, ToolName)
+
+	r.cfg.MaxDepth = 4
+	out, err = r.Run(context.Background(), "loop", "go")
+	require.NoError(t, err)
+	require.Equal(t, "loop got: looper got: delegation cycle: loop -> looper -> loop", out)
+
+	r.cfg.MaxDepth = 2
+	out, err = r.Run(context.Background(), "deep", "go")
+	require.NoError(t, err)
+	require.Equal(t, "deep got: deeper got: delegation depth limit of 2 reached: deep -> deeper -> coder", out)
+
+	ctx, depth, err := r.enter(context.Background(), r.agents["coder"])
+	require.NoError(t, err)
+	require.Equal(t, 1, depth)
+	require.Equal(t, []string{"coder"}, delegationChain(ctx))
+
+	var (
+		mu   sync.Mutex
+		runs = make(map[string]agentmetrics.Run)
+	)
+	r.metrics.Subscribe(func(run agentmetrics.Run) {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[run.Agent] = run
+	})
+	resp, err := runner.tool.Run(context.Background(), fantasy.ToolCall{ID: "call-1", Name: ToolName, Input: `{"agent":"planner","prompt":"ship"}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError)
+	require.Equal(t, "call-1", runs["planner"].ToolCallID)
+	require.Empty(t, runs["planner"].Parent)
+	require.Equal(t, "call-1", runs["coder"].ToolCallID)
+	require.Equal(t, "planner", runs["coder"].Parent)
+}
+
+func TestRunConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{delay: 50 * time.Millisecond}
+	r := newTestRegistry(t, runner, Config{MaxConcurrent: 1}, "a", "b")
+
+	runBoth := func() []error {
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = r.Run(context.Background(), name, "go")
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+
+	r.queueTimeout = time.Second
+	require.Equal(t, []error{nil, nil}, runBoth())
+	require.Equal(t, int32(1), runner.peak.Load())
+
+	r.queueTimeout = 10 * time.Millisecond
+	errs := slices.DeleteFunc(runBoth(), func(err error) bool { return err == nil })
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "no free run slot after 10ms (max_concurrent is 1)")
+}
+
+func TestSubAgentToolParams(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{}, "alpha", "beta")
+	tool := NewSubAgentTool(r)
+
+	run := func(input string) fantasy.ToolResponse {
+		resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: input})
+		require.NoError(t, err)
+		return resp
+	}
+
+	require.True(t, run(`{"prompt":"x"}`).IsError)
+	require.True(t, run(`{"agent":"alpha","agents":["beta"],"prompt":"x"}`).IsError)
+
+	resp := run(`{"agent":"alpha","prompt":"x"}`)
+	require.False(t, resp.IsError)
+	require.Equal(t, "result from alpha", resp.Content)
+
+	resp = run(`{"agents":["alpha","beta"],"prompt":"x"}`)
+	require.False(t, resp.IsError)
+	require.Contains(t, resp.Content, "## alpha")
+	require.Contains(t, resp.Content, "## beta")
+}
+
+func TestRunSessionConversation(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{}, "pair", "oneshot")
+	r.agents["pair"].Conversation = true
+
+	out, session, err := r.RunSession(context.Background(), "pair", "", "write a parser")
+	require.NoError(t, err)
+	require.Equal(t, "result from pair", out)
+	require.NotEmpty(t, session)
+	require.Equal(t, "write a parser", runner.calls[0].Prompt)
+
+	_, again, err := r.RunSession(context.Background(), "pair", session, "now add tests")
+	require.NoError(t, err)
+	require.Equal(t, session, again)
+	require.Contains(t, runner.calls[1].Prompt, "<task>\nwrite a parser\n</task>")
+	require.Contains(t, runner.calls[1].Prompt, "<reply>\nresult from pair\n</reply>")
+	require.True(t, strings.HasSuffix(runner.calls[1].Prompt, "now add tests"))
+
+	_, _, err = r.RunSession(context.Background(), "pair", "sa-missing", "x")
+	require.ErrorContains(t, err, "unknown sub-agent session")
+	_, _, err = r.RunSession(context.Background(), "oneshot", session, "x")
+	require.ErrorContains(t, err, "does not keep conversations")
+
+	_, none, err := r.RunSession(context.Background(), "oneshot", "", "x")
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	tool := NewSubAgentTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: `{"agent":"pair","session":"` + session + `","prompt":"more"}`})
+	require.NoError(t, err)
+	require.Equal(t, "result from pair\n\n<session>"+session+"</session>", resp.Content)
+}
+
+func TestConversationLimits(t *testing.T) {
+	t.Parallel()
+
+	c := newConversations()
+	first, err := c.open("a", "")
+	require.NoError(t, err)
+	for i := range maxConversationTurns + 5 {
+		c.record(first, fmt.Sprintf("task %d", i), "ok")
+	}
+	require.Len(t, first.turns, maxConversationTurns)
+	require.Equal(t, "task 5", first.turns[0].prompt)
+
+	_, err = c.open("b", first.id)
+	require.ErrorContains(t, err, "belongs to sub-agent a")
+
+	for range maxConversations {
+		_, err := c.open("a", "")
+		require.NoError(t, err)
+	}
+	require.Len(t, c.sessions, maxConversations)
+	_, err = c.open("a", first.id)
+	require.ErrorContains(t, err, "unknown sub-agent session")
+}
+
+func TestLoadAgentFileTriggers(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deploy.md")
+	require.NoError(t, os.WriteFile(path, []byte("---\nname: deployer\ndescription: Deploys\ntriggers: [deploy, \"/roll ?back/\", release notes]\n---\n"), 0o644))
+	agent, err := LoadAgentFile(path)
+	require.NoError(t, err)
+	require.Len(t, agent.Triggers, 3)
+	require.True(t, agent.Triggers.matches("Please DEPLOY to staging"))
+	require.True(t, agent.Triggers.matches("we need a rollback"))
+	require.True(t, agent.Triggers.matches("draft the release notes"))
+	require.False(t, agent.Triggers.matches("redeployment plan"))
+
+	require.NoError(t, os.WriteFile(path, []byte("---\nname: deployer\ndescription: Deploys\ntriggers: \"/(/\"\n---\n"), 0o644))
+	_, err = LoadAgentFile(path)
+	require.ErrorContains(t, err, "trigger")
+}
+
+// fakeMessages feeds message events to hooks and records submitted prompts.
+type fakeMessages struct {
+	events chan plugin.MessageEvent
+
+	mu        sync.Mutex
+	submitted []string
+}
+
+func (f *fakeMessages) SubscribeMessages(ctx context.Context) <-chan plugin.MessageEvent {
+	return f.events
+}
+
+func (f *fakeMessages) SubmitPrompt(ctx context.Context, prompt string) error {
+	return f.SubmitPromptToSession(ctx, "", prompt)
+}
+
+func (f *fakeMessages) SubmitPromptToSession(ctx context.Context, sessionID, prompt string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.submitted = append(f.submitted, prompt)
+	return nil
+}
+
+func (f *fakeMessages) CurrentSessionID() string { return "s1" }
+
+func (f *fakeMessages) IsSessionBusy() bool { return false }
+
+func (f *fakeMessages) prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.submitted)
+}
+
+func userMessage(content string) plugin.MessageEvent {
+	return plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{
+		SessionID: "s1", Role: plugin.MessageRoleUser, Content: content,
+	}}
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []string{RouteSuggest, RouteAuto} {
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+
+			msgs := &fakeMessages{events: make(chan plugin.MessageEvent, 4)}
+			runner := &fakeRunner{}
+			app := plugin.NewApp(
+				plugin.WithWorkingDir(t.TempDir()),
+				plugin.WithSubAgentRunner(runner),
+				plugin.WithMessageSubscriber(msgs),
+				plugin.WithPromptSubmitter(msgs),
+			)
+			r := newTestRegistryWithApp(t, app, Config{}, "deployer", "reviewer")
+			r.agents["deployer"].Triggers = triggerList{regexp.MustCompile(`(?i)\bdeploy\b`)}
+
+			router, err := NewRouter(app, r, RouterConfig{Mode: mode})
+			require.NoError(t, err)
+			router.quietPeriod = 10 * time.Millisecond
+
+			msgs.events <- userMessage(autoRoutePrefix + " deploy result")
+			msgs.events <- userMessage("deploy the app")
+			close(msgs.events)
+			require.NoError(t, router.Start(context.Background()))
+
+			require.Equal(t, []string{"deployer"}, r.Suggested())
+			require.Contains(t, buildDescription(r), "matches the triggers of: deployer")
+
+			if mode == RouteSuggest {
+				require.Empty(t, runner.calls)
+				require.Empty(t, msgs.prompts())
+				return
+			}
+			require.Len(t, runner.calls, 1)
+			require.Equal(t, "deploy the app", runner.calls[0].Prompt)
+			require.Len(t, msgs.prompts(), 1)
+			require.True(t, strings.HasPrefix(msgs.prompts()[0], autoRoutePrefix))
+			require.Contains(t, msgs.prompts()[0], "result from deployer")
+		})
+	}
+
+	_, err := NewRouter(plugin.NewApp(), &Registry{}, RouterConfig{Mode: "always"})
+	require.ErrorContains(t, err, "invalid routing mode")
+}
+
+func TestRouterWaitsForBusySession(t *testing.T) {
+	t.Parallel()
+
+	msgs := &fakeMessages{events: make(chan plugin.MessageEvent)}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(&fakeRunner{}),
+		plugin.WithMessageSubscriber(msgs),
+		plugin.WithPromptSubmitter(msgs),
+	)
+	r := newTestRegistryWithApp(t, app, Config{}, "deployer")
+	r.agents["deployer"].Triggers = triggerList{regexp.MustCompile(`(?i)\bdeploy\b`)}
+
+	router, err := NewRouter(app, r, RouterConfig{Mode: RouteAuto})
+	require.NoError(t, err)
+	router.quietPeriod = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- router.Start(ctx) }()
+
+	// The session keeps answering the message; the result waits for it.
+	msgs.events <- userMessage("deploy the app")
+	reply := plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+		SessionID: "s1", Role: plugin.MessageRoleAssistant, Content: "Deploying",
+	}}
+	for range 10 {
+		msgs.events <- reply
+		time.Sleep(30 * time.Millisecond)
+		require.Empty(t, msgs.prompts())
+	}
+
+	require.Eventually(t, func() bool { return len(msgs.prompts()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	require.Contains(t, msgs.prompts()[0], "result from deployer")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestAttachFiles(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	workDir := t.TempDir()
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(runner))
+	r := newTestRegistryWithApp(t, app, Config{}, "writer")
+	r.agents["writer"].ContextFiles = []string{"docs/*.md"}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "docs", "style.md"), []byte("Use short sentences."), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "big.txt"), []byte(strings.Repeat("x", maxAttachmentFileBytes+10)), 0o644))
+
+	tool := NewSubAgentTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: `{"agent":"writer","prompt":"write docs","files":["big.txt","docs/style.md","missing.go"]}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError)
+
+	prompt := runner.calls[0].Prompt
+	require.True(t, strings.HasPrefix(prompt, "<context_files>\n<file path=\"docs/style.md\">\nUse short sentences.\n</file>\n"))
+	require.Equal(t, 1, strings.Count(prompt, `path="docs/style.md"`))
+	require.Contains(t, prompt, fmt.Sprintf("[truncated: %d of %d bytes shown]", maxAttachmentFileBytes, maxAttachmentFileBytes+10))
+	require.Contains(t, prompt, `<missing path="missing.go" />`)
+	require.True(t, strings.HasSuffix(prompt, "</context_files>\n\nwrite docs"))
+
+	records, err := r.history.Recent("writer", 1)
+	require.NoError(t, err)
+	require.Equal(t, "write docs", records[0].Prompt)
+
+	_, err = r.Run(context.Background(), "writer", "again")
+	require.NoError(t, err)
+	require.NotContains(t, runner.calls[1].Prompt, "big.txt")
+}
+
+func TestAttachFilesConfined(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	root := t.TempDir()
+	workDir := filepath.Join(root, "project")
+	require.NoError(t, os.MkdirAll(workDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret.txt"), []byte("hunter2"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(workDir, "link.txt")))
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(runner))
+	r := newTestRegistryWithApp(t, app, Config{}, "writer")
+	tool := NewSubAgentTool(r)
+
+	for _, file := range []string{filepath.Join(root, "secret.txt"), "~/.ssh/*"} {
+		resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: fmt.Sprintf(`{"agent":"writer","prompt":"p","files":[%q]}`, file)})
+		require.NoError(t, err)
+		require.True(t, resp.IsError, file)
+		require.Contains(t, resp.Content, "relative to the working directory")
+	}
+	require.Empty(t, runner.calls)
+
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: `{"agent":"writer","prompt":"p","files":["../secret.txt","link.txt"]}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError)
+	prompt := runner.calls[0].Prompt
+	require.NotContains(t, prompt, "hunter2")
+	require.Contains(t, prompt, `reason="outside the working directory"`)
+	require.Equal(t, 2, strings.Count(prompt, "<omitted"))
+}
+
+func TestPostProcess(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "summarizer.md")
+	require.NoError(t, os.WriteFile(path, []byte(`---
+name: summarizer
+description: Summarizes
+postProcess:
+  stripThinking: true
+  extract: '(?s)<answer>(.*)</answer>'
+  template: "{{.Agent}} says: {{.Output}}"
+  maxLength: 30
 ---
 
-This is the body.
-Multiple lines.`
+Summarize.`), 0o644))
+	agent, err := LoadAgentFile(path)
+	require.NoError(t, err)
 
-	fm, body, err := splitFrontmatter([]byte(content))
+	out, err := agent.PostProcess.apply(agent.Name, "<thinking>the <answer>draft</answer></thinking>\n<answer>\n Short.\n</answer>")
 	require.NoError(t, err)
-	require.Contains(t, string(fm), "name: test")
-	require.Contains(t, string(body), "This is the body")
+	require.Equal(t, "summarizer says: Short.", out)
+
+	out, err = agent.PostProcess.apply(agent.Name, "no tags but a rather long reply")
+	require.NoError(t, err)
+	require.Equal(t, "summarizer says: no tags but a\n[truncated: 30 of 48 bytes shown]", out)
+
+	runner := &fakeRunner{replies: []string{"<think>hmm</think> done"}}
+	r := newTestRegistry(t, runner, Config{}, "quiet")
+	r.agents["quiet"].PostProcess = &postProcess{StripThinking: true}
+	out, err = r.Run(context.Background(), "quiet", "go")
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+
+	for _, spec := range []string{"extract: '('", "template: '{{.Missing'", "maxLength: -1", "postProcess: yes"} {
+		content := "---\nname: bad\ndescription: Bad\npostProcess:\n  " + spec + "\n---\n"
+		if strings.HasPrefix(spec, "postProcess") {
+			content = "---\nname: bad\ndescription: Bad\n" + spec + "\n---\n"
+		}
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		_, err := LoadAgentFile(path)
+		require.ErrorContains(t, err, "postProcess", spec)
+	}
+}
+
+func TestResultArtifacts(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("finding\n", 200)
+	runner := &fakeRunner{replies: []string{long, long, "short"}}
+	workDir := t.TempDir()
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(runner))
+	r := newTestRegistryWithApp(t, app, Config{}, "auditor", "plain")
+	r.agents["auditor"].Artifact = true
+
+	out, err := r.Run(context.Background(), "auditor", "audit")
+	require.NoError(t, err)
+	summary, footer, ok := strings.Cut(out, "\n\n[Full result (1600 bytes) saved to ")
+	require.True(t, ok, out)
+	require.True(t, strings.HasSuffix(summary, "finding\n..."))
+	require.Less(t, len(summary), artifactSummaryBytes+10)
+
+	path := strings.TrimSuffix(footer, "]")
+	require.True(t, strings.HasPrefix(path, filepath.Join(".crush", "artifacts", "auditor-")), path)
+	data, err := os.ReadFile(filepath.Join(workDir, path))
+	require.NoError(t, err)
+	require.Equal(t, long, string(data))
+
+	out, err = r.Run(context.Background(), "auditor", "again")
+	require.NoError(t, err)
+	require.NotContains(t, out, path+"]")
+
+	out, err = r.Run(context.Background(), "plain", "go")
+	require.NoError(t, err)
+	require.Equal(t, "short", out)
+
+	entries, err := os.ReadDir(filepath.Join(workDir, ".crush", "artifacts"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestHandoffs(t *testing.T) {
+	t.Parallel()
+
+	replies := []string{
+		"Draft ready.\n\n<handoff agent=\"reviewer\">Review the draft</handoff>",
+		"Looks good.\n<handoff agent=\"merger\"></handoff>\n",
+		"Merged.",
+	}
+	runner := &fakeRunner{replies: replies}
+	r := newTestRegistry(t, runner, Config{}, "writer", "reviewer", "merger")
+	tool := NewSubAgentTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: `{"agent":"writer","prompt":"write"}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError)
+	require.Equal(t, "## writer\n\nDraft ready.\n\n## reviewer\n\nLooks good.\n\n## merger\n\nMerged.", resp.Content)
+	require.Len(t, runner.calls, 3)
+	require.Equal(t, "Review the draft", runner.calls[1].Prompt)
+	require.Equal(t, "Looks good.", runner.calls[2].Prompt)
+
+	// The chain stops at the hop limit and at unknown agents.
+	runner = &fakeRunner{replies: replies}
+	r = newTestRegistry(t, runner, Config{MaxHandoffs: 1}, "writer", "reviewer", "merger")
+	out := r.followHandoffs(context.Background(), "writer", replies[0])
+	require.True(t, strings.HasSuffix(out, "Looks good.\n\n[Handoff to merger stopped: limit of 1 handoffs reached]"), out)
+	require.Len(t, runner.calls, 1)
+
+	out = r.followHandoffs(context.Background(), "writer", `Done <handoff agent="ghost">go</handoff>`)
+	require.Equal(t, "## writer\n\nDone\n\n## ghost\n\nError: sub-agent not found: ghost", out)
+
+	r = newTestRegistry(t, &fakeRunner{}, Config{MaxHandoffs: -1}, "writer")
+	require.Equal(t, replies[0], r.followHandoffs(context.Background(), "writer", replies[0]))
+	require.Equal(t, "plain", r.followHandoffs(context.Background(), "writer", "plain"))
+}
+
+func TestAgentNameSuggestions(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 0, levenshtein("abc", "abc"))
+	require.Equal(t, 3, levenshtein("kitten", "sitting"))
+	require.Equal(t, 4, levenshtein("", "four"))
+
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{}, "code-reviewer", "test-a", "test-b", "hidden")
+	r.agents["hidden"].Enabled = false
+
+	_, err := r.Run(context.Background(), "Code_Reviewer", "go")
+	require.EqualError(t, err, "sub-agent not found: Code_Reviewer (did you mean code-reviewer?)")
+	_, err = r.Run(context.Background(), "code-reviwer", "go")
+	require.EqualError(t, err, "sub-agent not found: code-reviwer (did you mean code-reviewer?)")
+	_, err = r.Run(context.Background(), "test-c", "go")
+	require.EqualError(t, err, "sub-agent not found: test-c (did you mean test-a, test-b?)")
+	_, err = r.Run(context.Background(), "hiden", "go")
+	require.EqualError(t, err, "sub-agent not found: hiden")
+
+	// Without correct_names nothing is run.
+	require.Equal(t, "code_reviewer", r.correctName("code_reviewer"))
+	require.Empty(t, runner.calls)
+
+	r = newTestRegistry(t, runner, Config{CorrectNames: true}, "code-reviewer", "test-a", "test-b")
+	require.Equal(t, "test-c", r.correctName("test-c"))
+	tool := NewSubAgentTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: ToolName, Input: `{"agent":"code_reviewer","prompt":"check"}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError)
+	require.Equal(t, "result from code-reviewer\n\n[No sub-agent is named code_reviewer; ran code-reviewer instead]", resp.Content)
+}
+
+func TestRunTimeout(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{block: true}, Config{}, "slow")
+	r.agents["slow"].Timeout = 20 * time.Millisecond
+
+	_, err := r.Run(context.Background(), "slow", "go")
+	require.ErrorContains(t, err, "timed out after 20ms")
+}
+
+func TestRunTokenBudget(t *testing.T) {
+	t.Parallel()
+
+	sip := &fakeSessionInfo{}
+	sip.tokens.Store(1000)
+	runner := &fakeRunner{onRun: func() { sip.tokens.Add(600) }}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(runner),
+		plugin.WithSessionInfoProvider(sip),
+	)
+	r := newTestRegistryWithApp(t, app, Config{}, "greedy", "frugal")
+	r.agents["greedy"].MaxTokens = 500
+	r.agents["frugal"].MaxTokens = 1000
+
+	_, err := r.Run(context.Background(), "greedy", "go")
+	require.ErrorContains(t, err, "budget exceeded: used 600 tokens, limit 500")
+
+	out, err := r.Run(context.Background(), "frugal", "go")
+	require.NoError(t, err)
+	require.Equal(t, "result from frugal", out)
+}
+
+func TestRunBudgetCancelsRunning(t *testing.T) {
+	t.Parallel()
+
+	sip := &fakeSessionInfo{}
+	runner := &fakeRunner{block: true, onRun: func() { sip.tokens.Add(100) }}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(runner),
+		plugin.WithSessionInfoProvider(sip),
+	)
+	r := newTestRegistryWithApp(t, app, Config{}, "runaway")
+	r.agents["runaway"].MaxTokens = 50
+
+	_, err := r.Run(context.Background(), "runaway", "go")
+	require.ErrorContains(t, err, "budget exceeded")
+}
+
+func TestParallelRunUsage(t *testing.T) {
+	t.Parallel()
+
+	sip := &fakeSessionInfo{}
+	runner := &fakeRunner{delay: 50 * time.Millisecond, onRun: func() { sip.tokens.Add(300) }}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(runner),
+		plugin.WithSessionInfoProvider(sip),
+	)
+	r := newTestRegistryWithApp(t, app, Config{}, "alpha", "beta", "capped")
+	r.agents["capped"].MaxTokens = 400
+
+	// The budgeted run has the session to itself, so its siblings' tokens
+	// don't count against it; runs that overlapped are charged nothing
+	// rather than each other's tokens.
+	resp := r.RunParallel(context.Background(), []string{"alpha", "beta", "capped"}, "go")
+	require.False(t, resp.IsError)
+	require.NotContains(t, resp.Content, "budget exceeded")
+	require.Equal(t, int64(300), r.Usage("capped").Tokens)
+	for _, name := range []string{"alpha", "beta"} {
+		require.LessOrEqual(t, r.Usage(name).Tokens, int64(300))
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	tool := NewSubAgentTool(r)
+	require.Contains(t, tool.Info().Description, "No sub-agents configured")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.Watch(ctx) }()
+
+	path := filepath.Join(dir, "watched.md")
+	agentFile := "---\nname: watched\ndescription: Picked up live\n---\n\nBody."
+
+	// Rewrite until the watcher is registered; the interval must exceed
+	// watchDebounce or each write postpones the reload.
+	require.Eventually(t, func() bool {
+		if _, ok := r.Get("watched"); ok {
+			return true
+		}
+		_ = os.WriteFile(path, []byte(agentFile), 0o644)
+		return false
+	}, 5*time.Second, 2*watchDebounce)
+	require.Contains(t, tool.Info().Description, "- watched: Picked up live")
+
+	require.NoError(t, os.Remove(path))
+	require.Eventually(t, func() bool {
+		_, ok := r.Get("watched")
+		return !ok
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestWatchEnabled(t *testing.T) {
+	t.Parallel()
+
+	off := false
+	require.True(t, Config{}.watchEnabled())
+	require.False(t, Config{Watch: &off}.watchEnabled())
+}
+
+func TestRenderAgentFile(t *testing.T) {
+	t.Parallel()
+
+	content, err := RenderAgentFile(NewAgentSpec{
+		Name:        "doc-writer",
+		Description: "Writes docs: READMEs and guides",
+		Tools:       "Read,  Grep,",
+	})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "doc-writer.md")
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	agent, err := LoadAgentFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "doc-writer", agent.Name)
+	require.Equal(t, "Writes docs: READMEs and guides", agent.Description)
+	require.Equal(t, []string{"view", "grep"}, agent.Tools)
+	require.Equal(t, "inherit", agent.Model)
+	require.NotEmpty(t, agent.SystemPrompt)
+
+	_, err = RenderAgentFile(NewAgentSpec{Name: "Bad Name", Description: "x"})
+	require.ErrorContains(t, err, "invalid name")
+	_, err = RenderAgentFile(NewAgentSpec{Name: "ok"})
+	require.ErrorContains(t, err, "description is required")
+}
+
+func TestCreateAgent(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "agents")
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+
+	path, err := r.CreateAgent(NewAgentSpec{Name: "helper", Description: "Helps", Model: "haiku"})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "helper.md"), path)
+
+	agent, ok := r.Get("helper")
+	require.True(t, ok)
+	require.Equal(t, "haiku", agent.Model)
+
+	_, err = r.CreateAgent(NewAgentSpec{Name: "helper", Description: "Again"})
+	require.ErrorContains(t, err, "already exists")
+}
+
+func TestNewAgentDialog(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "agents")
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	d := newAgentDialog(r)
+
+	typeKeys := func(keys ...string) (bool, plugin.PluginAction) {
+		var (
+			done   bool
+			action plugin.PluginAction
+		)
+		for _, key := range keys {
+			var err error
+			done, action, err = d.Update(plugin.KeyEvent{Key: key})
+			require.NoError(t, err)
+		}
+		return done, action
+	}
+
+	typeKeys("q", "a", "x", "backspace", "enter")
+	typeKeys("Q", "A", "space", "b", "o", "t", "enter", "enter")
+	_, action := typeKeys("enter")
+	require.Equal(t, plugin.OpenDialogAction{DialogID: DetailsDialogID}, action)
+
+	agent, ok := r.Get("qa")
+	require.True(t, ok)
+	require.Equal(t, "QA bot", agent.Description)
+
+	_, action = typeKeys("ctrl+s")
+	require.Equal(t, plugin.NoAction{}, action)
+	require.Contains(t, d.View(), "already exists")
+
+	done, _ := typeKeys("esc")
+	require.True(t, done)
+}
+
+func TestAgentCommandHandler(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{}, "review")
+	agent, _ := r.Get("review")
+	require.Equal(t, "review", agentCommand(agent).ID)
+
+	handler := r.agentCommandHandler("review")
+	require.Equal(t, plugin.OpenDialogAction{DialogID: RunDialogID}, handler(agentCommand(agent)))
+
+	r.SetEnabled("review", false)
+	require.Equal(t, plugin.NoAction{}, handler(agentCommand(agent)))
+	require.Equal(t, plugin.NoAction{}, r.agentCommandHandler("missing")(plugin.PluginCommand{}))
+}
+
+func TestEnabledStatePersists(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, "agents")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "review.md"), []byte("---\nname: review\ndescription: Reviews\n---\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "draft.md"), []byte("---\nname: draft\ndescription: Drafts\ndisabled: true\n---\n"), 0o644))
+
+	load := func() *Registry {
+		app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+		r := newTestRegistryWithApp(t, app, Config{Dirs: []string{dir}})
+		r.LoadAgents()
+		return r
+	}
+	enabled := func(r *Registry, name string) bool {
+		agent, ok := r.Get(name)
+		require.True(t, ok)
+		return agent.Enabled
+	}
+
+	r := load()
+	require.True(t, enabled(r, "review"))
+	require.False(t, enabled(r, "draft"))
+
+	r.SetEnabled("review", false)
+	r.SetEnabled("draft", true)
+	r.ReloadAll()
+	require.False(t, enabled(r, "review"))
+	require.True(t, enabled(r, "draft"))
+	require.NoError(t, r.ReloadAgent("review"))
+	require.False(t, enabled(r, "review"))
+
+	r = load()
+	require.False(t, enabled(r, "review"))
+	require.True(t, enabled(r, "draft"))
+
+	// Returning to the frontmatter default drops the stored state.
+	r.SetEnabled("review", true)
+	r.SetEnabled("draft", false)
+	data, err := os.ReadFile(filepath.Join(workDir, DefaultStateFile))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"enabled":{}}`, string(data))
+}
+
+func TestAgentTags(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, "agents")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	files := map[string]string{
+		"review.md": "---\nname: review\ndescription: Reviews\ntags: [core]\n---\n",
+		"probe.md":  "---\nname: probe\ndescription: Probes\ntags: Experimental, db\n---\n",
+		"sketch.md": "---\nname: sketch\ndescription: Sketches\ntags: [experimental]\n---\n",
+		"plain.md":  "---\nname: plain\ndescription: Untagged\n---\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	load := func(cfg Config) *Registry {
+		cfg.Dirs = []string{dir}
+		app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+		r := newTestRegistryWithApp(t, app, cfg)
+		r.LoadAgents()
+		return r
+	}
+	enabled := func(r *Registry) []string {
+		var names []string
+		for _, agent := range r.List() {
+			if agent.Enabled {
+				names = append(names, agent.Name)
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	r := load(Config{})
+	require.Equal(t, []string{"core", "db", "Experimental"}, r.Tags())
+	require.Equal(t, []string{"plain", "probe", "review", "sketch"}, enabled(r))
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	for _, key := range []string{"-", "e", "x", "p", "e", "r", "i", "m", "e", "n", "t", "a", "l"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	require.Contains(t, d.View(), "Disable agents tagged: experimental_ (core, db, Experimental)")
+	_, _, err := d.Update(plugin.KeyEvent{Key: "enter"})
+	require.NoError(t, err)
+	require.Equal(t, "disabled 2 agent(s) tagged experimental", d.status)
+	require.Equal(t, []string{"plain", "review"}, enabled(r))
+
+	_, err = r.SetTagEnabled("missing", true)
+	require.EqualError(t, err, "no agents tagged missing")
+
+	// Only agents with an enabled tag start enabled; toggles still persist.
+	r = load(Config{EnabledTags: []string{"core", "db"}})
+	require.Equal(t, []string{"review"}, enabled(r))
+	n, err := r.SetTagEnabled("db", true)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{"probe", "review"}, enabled(load(Config{EnabledTags: []string{"core", "db"}})))
+}
+
+func TestRunDialog(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{}, "review")
+	agent, _ := r.Get("review")
+	d := newRunDialog(r, agent)
+
+	for _, key := range []string{"enter", "f", "i", "x", "space", "i", "t", "enter"} {
+		done, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+		require.False(t, done)
+	}
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "result from review")
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, "fix it", runner.calls[0].Prompt)
+
+	_, _, err := d.Update(plugin.KeyEvent{Key: "enter"})
+	require.NoError(t, err)
+	require.Contains(t, d.View(), "Prompt: _")
+
+	done, _, err := d.Update(plugin.KeyEvent{Key: "esc"})
+	require.NoError(t, err)
+	require.True(t, done)
+}
+
+func TestRunSettings(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+	r := newTestRegistryWithApp(t, app, Config{}, "frontend", "plain", "lost")
+	r.agents["frontend"].Cwd = "web"
+	r.agents["frontend"].Env = map[string]string{"NODE_ENV": "development", "CI": "1"}
+	r.agents["lost"].Cwd = "missing"
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "web"), 0o755))
+
+	_, _, settings, err := r.runOptions(context.Background(), r.agents["frontend"], "build")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(workDir, "web"), settings.WorkingDir)
+	require.Equal(t, []string{"CI=1", "NODE_ENV=development"}, settings.Env)
+
+	_, _, settings, err = r.runOptions(context.Background(), r.agents["plain"], "go")
+	require.NoError(t, err)
+	require.Empty(t, settings.WorkingDir)
+	require.Nil(t, settings.Env)
+
+	_, _, _, err = r.runOptions(context.Background(), r.agents["lost"], "go")
+	require.ErrorContains(t, err, "sub-agent lost: working directory missing")
+}
+
+func TestPreviewRunSettings(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{MaxDepth: 1}, "review")
+	agent := r.agents["review"]
+	agent.SystemPrompt = "Review the diff."
+	agent.Temperature = new(0.2)
+	agent.Env = map[string]string{"LANG": "C"}
+	agent.MCPServers = toolList{"github"}
+
+	out, err := r.Preview(context.Background(), "review", "check main.go")
+	require.NoError(t, err)
+	require.Contains(t, out, "Temperature: 0.2\n")
+	require.Contains(t, out, "Disallowed tools: subagent\nMCP servers: github\nEnvironment: LANG=C\n")
+}
+
+func TestPreview(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{}
+	r := newTestRegistry(t, runner, Config{MaxDepth: 1}, "review")
+	agent := r.agents["review"]
+	agent.SystemPrompt = "Review the diff."
+	agent.Tools = []string{"view", "grep"}
+	agent.Model = "sonnet"
+
+	out, err := r.Preview(context.Background(), "review", "check main.go")
+	require.NoError(t, err)
+	require.Equal(t, `Agent: review
+Model: sonnet
+Allowed tools: view, grep
+Disallowed tools: subagent
+
+=== System prompt ===
+Review the diff.
+
+=== Prompt ===
+check main.go`, out)
+	require.Empty(t, runner.calls)
+
+	_, err = r.Preview(context.Background(), "missing", "x")
+	require.ErrorContains(t, err, "not found")
+
+	d := newRunDialog(r, agent)
+	for _, key := range []string{"g", "o", "ctrl+p"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	require.Contains(t, d.View(), "=== Prompt ===\ngo")
+	require.Empty(t, runner.calls)
+
+	_, _, err = d.Update(plugin.KeyEvent{Key: "ctrl+p"})
+	require.NoError(t, err)
+	require.Contains(t, d.View(), "Prompt: go_")
+
+	_, _, err = d.Update(plugin.KeyEvent{Key: "ctrl+p"})
+	require.NoError(t, err)
+	_, _, err = d.Update(plugin.KeyEvent{Key: "enter"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "result from review")
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, "go", runner.calls[0].Prompt)
+}
+
+func TestUpdateAgent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reviewer.md")
+	require.NoError(t, os.WriteFile(path, []byte(`---
+name: reviewer
+description: Reviews code
+tools: [Read, Grep]
+timeout: 5m # generous
+permissionMode: plan
+---
+
+Review the diff.
+
+Be thorough.
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.md"), []byte("---\nname: other\ndescription: Other\n---\n"), 0o644))
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	err := r.UpdateAgent("reviewer", AgentEdit{Name: "other", Description: "x"})
+	require.ErrorContains(t, err, "already exists")
+	err = r.UpdateAgent("reviewer", AgentEdit{Name: "reviewer", Description: "x", PermissionMode: "yolo"})
+	require.ErrorContains(t, err, "invalid permission mode")
+
+	require.NoError(t, r.UpdateAgent("reviewer", AgentEdit{
+		Name:        "go-reviewer",
+		Description: "Reviews Go code",
+		Tools:       "view, grep, bash",
+		Model:       "opus",
+	}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `---
+name: go-reviewer
+description: Reviews Go code
+tools: [view, grep, bash]
+timeout: 5m # generous
+model: opus
+---
+
+Review the diff.
+
+Be thorough.
+`, string(data))
+
+	_, ok := r.Get("reviewer")
+	require.False(t, ok)
+	agent, ok := r.Get("go-reviewer")
+	require.True(t, ok)
+	require.Equal(t, []string{"view", "grep", "bash"}, agent.Tools)
+	require.Empty(t, agent.PermissionMode)
+	require.Equal(t, 5*time.Minute, agent.Timeout)
+}
+
+func TestUpdateAgentRenameExtended(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.md":   "---\nname: base\ndescription: Base\nmodel: sonnet\n---\n\nReview carefully.",
+		"go.md":     "---\nname: go-reviewer\ndescription: Go\nextends: base\n---\n",
+		"rust.md":   "---\nname: rust-reviewer\ndescription: Rust\nextends: base\n---\n",
+		"single.md": "---\nname: single\ndescription: Single\nmodel: sonnet\n---\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	err := r.UpdateAgent("base", AgentEdit{Name: "reviewer", Description: "Base", Model: "sonnet"})
+	require.ErrorContains(t, err, "cannot rename base: extended by go-reviewer, rust-reviewer")
+	data, err := os.ReadFile(filepath.Join(dir, "base.md"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "name: base")
+	_, ok := r.Get("go-reviewer")
+	require.True(t, ok)
+
+	// Other edits of an extended agent, and renames of others, still work.
+	require.NoError(t, r.UpdateAgent("base", AgentEdit{Name: "base", Description: "Base", Model: "opus"}))
+	child, ok := r.Get("go-reviewer")
+	require.True(t, ok)
+	require.Equal(t, "opus", child.Model)
+	require.NoError(t, r.UpdateAgent("single", AgentEdit{Name: "solo", Description: "Single", Model: "sonnet"}))
+	_, ok = r.Get("solo")
+	require.True(t, ok)
+}
+func TestDetailsDialogEdit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "qa.md"), []byte("---\nname: qa\ndescription: QA\n---\n\nTest things."), 0o644))
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+	agent, _ := r.Get("qa")
+	d := &DetailsDialog{registry: r, agent: agent, width: detailsDialogWidth, height: detailsDialogHeight}
+
+	for _, key := range []string{"e", "tab", "space", "b", "o", "t", "ctrl+s"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	require.False(t, d.editing)
+	require.Equal(t, "QA bot", d.agent.Description)
+	require.Equal(t, "Test things.", d.agent.SystemPrompt)
+	require.Contains(t, d.View(), "QA bot")
+}
+
+func TestRunDuringReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"qa", "docs", "review"} {
+		content := fmt.Sprintf("---\nname: %s\ndescription: %s\n---\n\nDo %s.\n", name, name, name)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0o644))
+	}
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			r.ReloadAll()
+		}
+	}()
+
+	// Agents stay visible while they are reloaded.
+	for range 100 {
+		_, err := r.Run(context.Background(), "qa", "check")
+		require.NoError(t, err)
+		_, ok := r.Get("docs")
+		require.True(t, ok)
+		r.SetEnabled("review", false)
+		r.SetEnabled("review", true)
+	}
+	r.SetEnabled("review", false)
+	cancel()
+	wg.Wait()
+
+	r.ReloadAll()
+	agent, ok := r.Get("review")
+	require.True(t, ok)
+	require.False(t, agent.Enabled)
+}
+
+func TestToolApproval(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reviewer.md")
+	require.NoError(t, os.WriteFile(path, []byte("---\nname: reviewer\ndescription: Reviews code\ntools: [Read, Grep]\ndisallowedTools: Bash, Write\n---\n\nReview.\n"), 0o644))
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+	d := newApprovalDialog(r)
+	require.Contains(t, d.View(), "No pending tool requests.")
+
+	ask := func(ctx context.Context, tool string) <-chan bool {
+		answer := make(chan bool, 1)
+		go func() { answer <- r.requestApproval(ctx, "reviewer", tool) }()
+		require.Eventually(t, func() bool { return len(r.PendingApprovals()) == 1 }, time.Second, 5*time.Millisecond)
+		return answer
+	}
+
+	answer := ask(context.Background(), "Bash(git diff:*)")
+	require.Contains(t, d.View(), "Sub-agent reviewer wants to use bash")
+	_, _, err := d.Update(plugin.KeyEvent{Key: "d"})
+	require.NoError(t, err)
+	require.False(t, <-answer)
+	require.Contains(t, d.View(), "Denied: bash for reviewer")
+
+	answer = ask(context.Background(), "Bash")
+	_, _, err = d.Update(plugin.KeyEvent{Key: "o"})
+	require.NoError(t, err)
+	require.True(t, <-answer)
+	agent, _ := r.Get("reviewer")
+	require.Equal(t, []string{"bash", "write"}, agent.DisallowedTools)
+
+	answer = ask(context.Background(), "bash")
+	_, _, err = d.Update(plugin.KeyEvent{Key: "a"})
+	require.NoError(t, err)
+	require.True(t, <-answer)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "---\nname: reviewer\ndescription: Reviews code\ntools: [Read, Grep, bash]\ndisallowedTools: Write\n---\n\nReview.\n", string(data))
+	agent, _ = r.Get("reviewer")
+	require.Equal(t, []string{"view", "grep", "bash"}, agent.Tools)
+	require.Equal(t, []string{"write"}, agent.DisallowedTools)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	answer = ask(ctx, "write")
+	cancel()
+	require.False(t, <-answer)
+	require.Empty(t, r.PendingApprovals())
+	require.Error(t, r.Resolve(99, ApprovalOnce))
+}
+
+func TestToolApprovalTimeout(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{}, "reviewer")
+	require.Equal(t, DefaultApprovalTimeoutSeconds*time.Second, r.approvals.timeout)
+	r.approvals.timeout = 20 * time.Millisecond
+	var notified []string
+	r.approvals.notify = func(title, message string) { notified = append(notified, message) }
+
+	// A run without a deadline is denied once the approval timeout passes.
+	answer := make(chan bool, 1)
+	go func() { answer <- r.requestApproval(context.Background(), "reviewer", "Bash") }()
+	select {
+	case approved := <-answer:
+		require.False(t, approved)
+	case <-time.After(5 * time.Second):
+		t.Fatal("approval request outlived its timeout")
+	}
+	require.Empty(t, r.PendingApprovals())
+	require.Equal(t, []string{"Sub-agent reviewer wants to use bash. Answer with /subagents-approve."}, notified)
+}
+
+func TestDuplicateDeleteAgent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "qa.md"), []byte("---\nname: qa\ndescription: QA\n---\n\nTest things."), 0o644))
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	name, err := r.DuplicateAgent("qa")
+	require.NoError(t, err)
+	require.Equal(t, "qa-copy", name)
+	name, err = r.DuplicateAgent("qa")
+	require.NoError(t, err)
+	require.Equal(t, "qa-copy-2", name)
+
+	copied, ok := r.Get("qa-copy")
+	require.True(t, ok)
+	require.Equal(t, filepath.Join(dir, "qa-copy.md"), copied.FilePath)
+	require.Equal(t, "Test things.", copied.SystemPrompt)
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	d.cursor = 1 // qa-copy
+	for _, key := range []string{"x", "n"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	_, ok = r.Get("qa-copy")
+	require.True(t, ok)
+
+	for _, key := range []string{"x", "y"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	_, ok = r.Get("qa-copy")
+	require.False(t, ok)
+	require.NoFileExists(t, filepath.Join(dir, "qa-copy.md"))
+	require.Contains(t, d.View(), "Deleted qa-copy")
+
+	_, _, err = d.Update(plugin.KeyEvent{Key: "d"})
+	require.NoError(t, err)
+	require.Equal(t, "qa-copy-2-copy", d.GetSelectedAgent())
+
+	require.Error(t, r.DeleteAgent("missing"))
+	r.cacheDir = dir
+	require.ErrorContains(t, r.DeleteAgent("qa"), "remote source")
+}
+
+func TestNormalizeTool(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]string{
+		"Read":              "view",
+		" Bash(git diff:*)": "bash",
+		"WebFetch":          "fetch",
+		"TodoWrite":         "todos",
+		"GREP":              "grep",
+		"view":              "view",
+		"mcp_GitHub_list":   "mcp_GitHub_list",
+		"Telepathy":         "telepathy",
+	} {
+		require.Equal(t, want, NormalizeTool(input), input)
+	}
+
+	require.Nil(t, normalizeTools(nil))
+	require.Equal(t, []string{"view", "bash"}, normalizeTools([]string{"Read", "view", "Bash", "Bash(ls:*)"}))
+
+	agent := &SubAgent{Tools: []string{"view", "telepathy", "mcp_x"}, DisallowedTools: []string{"kuri_fetch"}}
+	require.Equal(t, []string{"telepathy", "kuri_fetch"}, unknownTools(agent, BuiltinTools))
+	require.Equal(t, []string{"telepathy"}, unknownTools(agent, append(slices.Clone(BuiltinTools), "kuri_fetch")))
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, fuzzyMatch("", "anything"))
+	require.True(t, fuzzyMatch("sec", "security-audit"))
+	require.True(t, fuzzyMatch("SA", "security-audit"))
+	require.True(t, fuzzyMatch("rvw", "code-reviewer"))
+	require.False(t, fuzzyMatch("wvr", "code-reviewer"))
+}
+
+func TestListDialogFilterAndPages(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, ".crush", "agents")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for i := range 25 {
+		name := fmt.Sprintf("agent-%02d", i)
+		content := fmt.Sprintf("---\nname: %s\ndescription: Generic helper\n---\n", name)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "security-audit.md"), []byte("---\nname: security-audit\ndescription: Finds vulnerabilities\n---\n"), 0o644))
+
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+	r := newTestRegistryWithApp(t, app, Config{Dirs: []string{".crush/agents"}})
+	r.LoadAgents()
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	require.Len(t, d.agents, 26)
+	require.Equal(t, groupProject, r.sourceGroup(d.agents[0]))
+
+	view := d.View()
+	require.Contains(t, view, "Project\n")
+	require.Contains(t, view, fmt.Sprintf("[page 1 of %d]", (26+d.pageSize()-1)/d.pageSize()))
+	require.NotContains(t, view, "security-audit")
+
+	press := func(keys ...string) {
+		for _, key := range keys {
+			_, _, err := d.Update(plugin.KeyEvent{Key: key})
+			require.NoError(t, err)
+		}
+	}
+
+	press("pgdown")
+	require.Equal(t, d.pageSize(), d.cursor)
+	require.Contains(t, d.View(), "[page 2 of")
+
+	press("/", "v", "u", "l", "n", "enter")
+	require.Len(t, d.agents, 1)
+	require.Equal(t, "security-audit", d.GetSelectedAgent())
+	require.Contains(t, d.View(), "Filter: vuln (1 of 26)")
+
+	done, _, err := d.Update(plugin.KeyEvent{Key: "esc"})
+	require.NoError(t, err)
+	require.False(t, done)
+	require.Len(t, d.agents, 26)
+}
+
+func TestListDialogGolden(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	project := filepath.Join(workDir, ".crush", "agents")
+	user := filepath.Join(t.TempDir(), "agents")
+	require.NoError(t, os.MkdirAll(project, 0o755))
+	require.NoError(t, os.MkdirAll(user, 0o755))
+	write := func(dir, name, frontmatter string) {
+		content := fmt.Sprintf("---\nname: %s\ndescription: Helps\n%s---\n", name, frontmatter)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0o644))
+	}
+	write(project, "code-reviewer", "tags: [core]\n")
+	write(project, "security-auditor-for-legacy-services", "")
+	write(user, "writer", "disabled: true\n")
+
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+	r := newTestRegistryWithApp(t, app, Config{Dirs: []string{".crush/agents", user}})
+	r.LoadAgents()
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	testutil.RequireGolden(t, "list", d.View())
+
+	for _, key := range []string{"/", "w", "r", "enter"} {
+		_, _, err := d.Update(plugin.KeyEvent{Key: key})
+		require.NoError(t, err)
+	}
+	testutil.RequireGolden(t, "filtered", d.View())
+}
+
+func TestProjectPrecedenceAndPromote(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	project := filepath.Join(workDir, ".crush", "agents")
+	user := filepath.Join(t.TempDir(), "agents")
+	require.NoError(t, os.MkdirAll(project, 0o755))
+	require.NoError(t, os.MkdirAll(user, 0o755))
+	write := func(dir, name, desc string) {
+		content := fmt.Sprintf("---\nname: %s\ndescription: %s\n---\n", name, desc)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0o644))
+	}
+	write(project, "review", "Project review")
+	write(user, "review", "User review")
+	write(user, "helper", "User helper")
+
+	// The user dir is listed first, but the project copy still wins.
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+	r := newTestRegistryWithApp(t, app, Config{Dirs: []string{user, ".crush/agents"}})
+	r.LoadAgents()
+	agent, ok := r.Get("review")
+	require.True(t, ok)
+	require.Equal(t, "Project review", agent.Description)
+	require.Equal(t, []string{filepath.Join(user, "review.md")}, r.Shadowed("review"))
+	require.Empty(t, r.Shadowed("helper"))
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	require.Equal(t, "review", d.GetSelectedAgent())
+	view := d.View()
+	require.Contains(t, view, "[x]*review")
+	require.Contains(t, view, "* Shadows "+filepath.Join(user, "review.md"))
+
+	_, err := r.PromoteAgent("review")
+	require.EqualError(t, err, "agent review is already a project agent")
+
+	_, _, err = d.Update(plugin.KeyEvent{Key: "down"})
+	require.NoError(t, err)
+	_, _, err = d.Update(plugin.KeyEvent{Key: "p"})
+	require.NoError(t, err)
+	require.Equal(t, "helper", d.GetSelectedAgent())
+	require.Contains(t, d.status, "Promoted helper to ")
+
+	agent, ok = r.Get("helper")
+	require.True(t, ok)
+	require.Equal(t, filepath.Join(project, "helper.md"), agent.FilePath)
+	require.Equal(t, []string{filepath.Join(user, "helper.md")}, r.Shadowed("helper"))
+
+	_, err = r.PromoteAgent("missing")
+	require.EqualError(t, err, "agent not found: missing")
+}
+
+func TestAgentCompatibility(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []string{"v0.30.1", "1.2", "2", "v1.0.0-rc.1"} {
+		_, ok := parseVersion(v)
+		require.True(t, ok, v)
+	}
+	for _, v := range []string{"", "latest", "1.2.3.4", "v1.x"} {
+		_, ok := parseVersion(v)
+		require.False(t, ok, v)
+	}
+	require.True(t, versionLess("v0.30.1", "0.31"))
+	require.False(t, versionLess("v0.31.0", "0.31"))
+	require.False(t, versionLess("1.0.0", "v0.99.9"))
+	require.Empty(t, releaseVersion("v0.0.0-20260101000000-abcdef123456"))
+	require.Empty(t, releaseVersion("(devel)"))
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{}, "future", "current")
+	r.crushVersion = "v0.30.1"
+	r.agents["future"].MinCrushVersion = "0.31"
+	r.agents["current"].MinCrushVersion = "0.30"
+	require.Equal(t, "requires Crush 0.31 or newer, running v0.30.1", r.incompatibility(r.agents["future"]))
+	require.Empty(t, r.incompatibility(r.agents["current"]))
+
+	d := &ListDialog{registry: r, width: listDialogWidth, height: listDialogHeight}
+	d.refresh()
+	require.Equal(t, "current", d.GetSelectedAgent())
+	_, _, err := d.Update(plugin.KeyEvent{Key: "down"})
+	require.NoError(t, err)
+	view := d.View()
+	require.Contains(t, view, "[x]!future")
+	require.Contains(t, view, "! future requires Crush 0.31 or newer")
+
+	// An unknown Crush version never warns.
+	r.crushVersion = ""
+	require.Empty(t, r.incompatibility(r.agents["future"]))
+}
+
+func TestValidateAgents(t *testing.T) {
+	t.Parallel()
+
+	project := t.TempDir()
+	home := t.TempDir()
+	files := map[string]string{
+		filepath.Join(project, "good.md"):     "---\nname: good\ndescription: Good\nmodel: sonnet\ntools: [view, bash, mcp_github_list]\n---\n",
+		filepath.Join(project, "tools.md"):    "---\nname: tools\ndescription: Tools\nmodel: opus\ntools:\n  - view\n  - Telepathy\ncolour: blue\n---\n",
+		filepath.Join(project, "broken.md"):   "---\nname: broken\ndescription: Broken\ntimeout: [1m\n---\n",
+		filepath.Join(project, "child.md"):    "---\nname: child\ndescription: Child\nextends: nobody\n---\n",
+		filepath.Join(project, "sibling.md"):  "---\nname: sibling\ndescription: Sibling\nmodel: sonnet\nextends: nobody\n---\n",
+		filepath.Join(project, "nomodel.md"):  "---\nname: nomodel\ndescription: No model\n---\n",
+		filepath.Join(home, "good.md"):        "---\nname: good\ndescription: Shadowed\nmodel: haiku\n---\n",
+		filepath.Join(home, "bad-timeout.md"): "---\nname: bad-timeout\ndescription: Bad\nmodel: haiku\ntimeout: soon\n---\n",
+	}
+	for path, content := range files {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	var got []string
+	for _, issue := range ValidateAgents([]string{project, home}, project, BuiltinTools) {
+		issue.Path = filepath.Base(filepath.Dir(issue.Path)) + "/" + filepath.Base(issue.Path)
+		got = append(got, issue.String())
+	}
+
+	p, h := filepath.Base(project), filepath.Base(home)
+	require.ElementsMatch(t, []string{
+		p + "/broken.md:3: error: yaml: line 2: did not find expected ',' or ']'",
+		p + "/child.md:4: error: base agent not found: nobody",
+		p + "/sibling.md:5: error: base agent not found: nobody",
+		p + "/nomodel.md:2: warning: no model set, inherits the parent's model",
+		p + "/tools.md:7: warning: unknown tool \"Telepathy\"",
+		p + "/tools.md:8: warning: unknown field \"colour\"",
+		h + "/good.md:2: warning: duplicate name \"good\", shadowed by " + filepath.Join(project, "good.md"),
+		h + "/bad-timeout.md:5: error: invalid timeout \"soon\"",
+	}, got)
+
+	var out strings.Builder
+	require.Equal(t, 1, RunValidate([]string{project, home}, project, &out))
+	require.Contains(t, out.String(), "8 agent files checked: 4 errors, ")
+
+	out.Reset()
+	require.Equal(t, 0, RunValidate([]string{filepath.Join(project, "missing")}, project, &out))
+	require.Contains(t, out.String(), "0 agent files checked")
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	configHome, dataHome := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv("CRUSH_GLOBAL_CONFIG", "")
+	t.Setenv("CRUSH_GLOBAL_DATA", "")
+	project := t.TempDir()
+	write := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write(filepath.Join(configHome, "crush", "crush.json"), `{"options":{"plugins":{"subagent":{"dirs":["global"]}}}}`)
+	write(filepath.Join(dataHome, "crush", "crush.json"), `{"options":{"plugins":{"subagent":{"import_claude":true}}}}`)
+	write(filepath.Join(project, "crush.json"), `{"options":{"plugins":{"subagent":{"dirs":["team/agents"]}}}}`)
+	write(filepath.Join(project, "team", "agents", "deploy.md"), "---\nname: deploy\ndescription: Deploy\nmodel: sonnet\ntimeout: soon\n---\n")
+	write(filepath.Join(project, ".claude", "agents", "review.md"), "---\nname: review\ndescription: Review\nmodel: sonnet\ncolour: blue\n---\n")
+	write(filepath.Join(project, ".crush", "agents", "unused.md"), "---\nname: unused\n---\n")
+
+	// The project's dirs replace the global ones; import_claude from the
+	// data directory carries over.
+	var out strings.Builder
+	require.Equal(t, 1, RunValidate(nil, project, &out))
+	require.Contains(t, out.String(), filepath.Join("team", "agents", "deploy.md")+":5: error: invalid timeout")
+	require.Contains(t, out.String(), filepath.Join(".claude", "agents", "review.md")+":5: warning: unknown field")
+	require.NotContains(t, out.String(), "unused.md")
+
+	// Dirs on the command line replace the configured ones.
+	out.Reset()
+	require.Equal(t, 1, RunValidate([]string{".crush/agents"}, project, &out))
+	require.Contains(t, out.String(), "unused.md")
+	require.NotContains(t, out.String(), "deploy.md")
+
+	write(filepath.Join(project, ".crush.json"), `{"options":`)
+	out.Reset()
+	require.Equal(t, 1, RunValidate(nil, project, &out))
+	require.Contains(t, out.String(), "parse "+filepath.Join(project, ".crush.json"))
+	require.NoError(t, os.Remove(filepath.Join(project, ".crush.json")))
+
+	// Configs in parent directories apply too, with the nearest last.
+	nested := filepath.Join(project, "service")
+	write(filepath.Join(nested, "crush.json"), `{"options":{"plugins":{"subagent":{"import_claude":false}}}}`)
+	write(filepath.Join(nested, "team", "agents", "ops.md"), "---\nname: ops\ndescription: Ops\nmodel: sonnet\ntimeout: soon\n---\n")
+	out.Reset()
+	require.Equal(t, 1, RunValidate(nil, nested, &out))
+	require.Contains(t, out.String(), filepath.Join("team", "agents", "ops.md")+":5: error: invalid timeout")
+	require.Contains(t, out.String(), "1 agent files checked")
+}
+
+func TestLoadAgentFileIncludes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.md":              "---\nname: go-reviewer\ndescription: Go\n---\n\nReview Go code.\n\n{{include \"shared/go-style.md\"}}\n\n{{ include \"shared/tone.md\" }}",
+		"shared/go-style.md": "Prefer small interfaces.\n{{include \"errors.md\"}}\n",
+		"shared/errors.md":   "---\nname: errors\ndescription: Partial with frontmatter\n---\n\nWrap errors with context.",
+		"shared/tone.md":     "Be kind.",
+		"missing.md":         "---\nname: missing\ndescription: Missing\n---\n\n{{include \"shared/nope.md\"}}",
+		"loop.md":            "---\nname: loop\ndescription: Loop\n---\n\n{{include \"shared/a.md\"}}",
+		"shared/a.md":        "A {{include \"b.md\"}}",
+		"shared/b.md":        "B {{include \"a.md\"}}",
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "shared"), 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	agent, err := LoadAgentFile(filepath.Join(dir, "go.md"))
+	require.NoError(t, err)
+	require.Equal(t, "Review Go code.\n\nPrefer small interfaces.\nWrap errors with context.\n\nBe kind.", agent.SystemPrompt)
+
+	_, err = LoadAgentFile(filepath.Join(dir, "missing.md"))
+	require.ErrorContains(t, err, `include "shared/nope.md"`)
+
+	_, err = LoadAgentFile(filepath.Join(dir, "loop.md"))
+	require.EqualError(t, err, "include cycle: loop.md -> a.md -> b.md -> a.md")
+}
+
+func TestLoadAgentFileFixtures(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"greeter.md":         "---\nname: greeter\ndescription: Greets\ntests:\n  - prompt: Say hi\n    contains: hello, world\n---\n\nGreet people.",
+		"greeter.tests.yaml": "- prompt: Say bye\n  notContains: [hello]\n  matches: (?i)bye\n",
+		"empty.md":           "---\nname: empty\ndescription: Empty\ntests:\n  - contains: x\n---\n",
+		"bad.md":             "---\nname: bad\ndescription: Bad\ntests:\n  - prompt: go\n    matches: \"(\"\n---\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	agent, err := LoadAgentFile(filepath.Join(dir, "greeter.md"))
+	require.NoError(t, err)
+	require.Equal(t, []Fixture{
+		{Prompt: "Say hi", Contains: stringList{"hello, world"}},
+		{Prompt: "Say bye", NotContains: stringList{"hello"}, Matches: "(?i)bye"},
+	}, agent.Tests)
+
+	_, err = LoadAgentFile(filepath.Join(dir, "empty.md"))
+	require.ErrorContains(t, err, "tests[0]: prompt is required")
+
+	_, err = LoadAgentFile(filepath.Join(dir, "bad.md"))
+	require.ErrorContains(t, err, "tests[0]: invalid matches pattern")
+}
+
+func TestRunFixtures(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{replies: []string{"hello there", "Goodbye, hello", "done"}}
+	r := newTestRegistry(t, runner, Config{}, "greeter", "worker", "untested")
+	r.agents["greeter"].Tests = []Fixture{
+		{Prompt: "Say hi", Contains: stringList{"hello"}},
+		{Prompt: "Say bye", NotContains: stringList{"hello"}, Matches: "^Good"},
+	}
+	r.agents["worker"].Tests = []Fixture{{Prompt: "Work", Matches: "^finished$"}}
+
+	results, err := r.RunFixtures(context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.True(t, results[0].Passed())
+	require.Equal(t, []string{`unexpected "hello"`}, results[1].Failures)
+	require.Equal(t, []string{"no match for /^finished$/"}, results[2].Failures)
+	require.Len(t, runner.calls, 3)
+
+	report := formatFixtureReport(results)
+	require.Contains(t, report, "PASS greeter #1: Say hi\n")
+	require.Contains(t, report, "FAIL greeter #2: Say bye\n  unexpected \"hello\"\n")
+	require.True(t, strings.HasSuffix(report, "1 passed, 2 failed"), report)
+
+	results, err = r.RunFixtures(context.Background(), []string{"untested"})
+	require.NoError(t, err)
+	require.Empty(t, results)
+	require.Equal(t, "No sub-agent fixtures found.", formatFixtureReport(results))
+
+	_, err = r.RunFixtures(context.Background(), []string{"missing"})
+	require.EqualError(t, err, "sub-agent not found: missing")
+
+	runner = &fakeRunner{fail: map[string]bool{"worker": true}}
+	r = newTestRegistry(t, runner, Config{}, "worker")
+	r.agents["worker"].Tests = []Fixture{{Prompt: "Work"}}
+	tool := NewTestTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "t1", Name: TestToolName, Input: `{}`})
+	require.NoError(t, err)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "FAIL worker #1: Work\n  error: ")
+}
+
+func TestLoadAgentsExtends(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.md":   "---\nname: base-reviewer\ndescription: Base\ntools: Read, Grep\nmodel: sonnet\ntimeout: 1m\ntemperature: 0\ncwd: src\nmcpServers: github\nenv:\n  LANG: C\n  LEVEL: base\n---\n\nReview carefully.",
+		"go.md":     "---\nname: go-reviewer\ndescription: Go\nextends: base-reviewer\nenv:\n  LEVEL: go\n---\n\nFocus on Go idioms.",
+		"sec.md":    "---\nname: sec-reviewer\ndescription: Security\nextends: go-reviewer\ntools: [Read]\nmodel: opus\n---\n\nYou audit security.\n\n{{base}}",
+		"orphan.md": "---\nname: orphan\ndescription: Orphan\nextends: missing\n---\n\nBody.",
+		"loop-a.md": "---\nname: loop-a\ndescription: A\nextends: loop-b\n---\n",
+		"loop-b.md": "---\nname: loop-b\ndescription: B\nextends: loop-a\n---\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	child, ok := r.Get("go-reviewer")
+	require.True(t, ok)
+	require.Equal(t, "Review carefully.\n\nFocus on Go idioms.", child.SystemPrompt)
+	require.Equal(t, []string{"view", "grep"}, child.Tools)
+	require.Equal(t, "sonnet", child.Model)
+	require.Equal(t, time.Minute, child.Timeout)
+	require.Equal(t, "src", child.Cwd)
+	require.Equal(t, new(0.0), child.Temperature)
+	require.Equal(t, map[string]string{"LANG": "C", "LEVEL": "go"}, child.Env)
+	require.Equal(t, toolList{"github"}, child.MCPServers)
+
+	grandchild, ok := r.Get("sec-reviewer")
+	require.True(t, ok)
+	require.Equal(t, "You audit security.\n\nReview carefully.\n\nFocus on Go idioms.", grandchild.SystemPrompt)
+	require.Equal(t, []string{"view"}, grandchild.Tools)
+	require.Equal(t, "opus", grandchild.Model)
+
+	for _, name := range []string{"orphan", "loop-a", "loop-b"} {
+		_, ok := r.Get(name)
+		require.False(t, ok, name)
+	}
+
+	require.NoError(t, r.ReloadAgent("go-reviewer"))
+	child, _ = r.Get("go-reviewer")
+	require.Equal(t, "sonnet", child.Model)
+}
+
+func TestReloadAgentDependents(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	write("base.md", "---\nname: base\ndescription: Base\nmodel: sonnet\n---\n\nReview carefully.")
+	write("child.md", "---\nname: child\ndescription: Child\nextends: base\n---\n\nFocus on Go.")
+
+	app := plugin.NewApp(plugin.WithWorkingDir(t.TempDir()), plugin.WithSubAgentRunner(&fakeRunner{}))
+	r := newTestRegistryWithApp(t, app, Config{Dirs: []string{dir}})
+	r.LoadAgents()
+
+	write("base.md", "---\nname: base\ndescription: Base\nmodel: opus\n---\n\nReview carefully.")
+	require.NoError(t, r.ReloadAgent("base"))
+	child, ok := r.Get("child")
+	require.True(t, ok)
+	require.Equal(t, "opus", child.Model)
+	require.Equal(t, "Review carefully.\n\nFocus on Go.", child.SystemPrompt)
+
+	write("child.md", "---\nname: child\ndescription: Child\nextends: nobody\n---\n")
+	require.ErrorContains(t, r.ReloadAgent("child"), "base agent not found: nobody")
+	_, ok = r.Get("child")
+	require.False(t, ok)
+
+	write("base.md", "---\nname: base\ndescription: Base\ntimeout: soon\n---\n")
+	require.ErrorContains(t, r.ReloadAgent("base"), "timeout")
+	require.ErrorContains(t, r.ReloadAgent("missing"), "agent not found: missing")
+}
+
+func TestLoadClaudeAgentFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reviewer.md")
+	require.NoError(t, os.WriteFile(path, []byte(`---
+name: code-reviewer
+description: Reviews code
+tools: Read, Grep, Glob, Bash(git diff:*), Bash, mcp__github__get_pr
+model: Sonnet
+permissionMode: acceptEdits
+---
+
+Review the diff.`), 0o644))
+
+	agent, err := LoadClaudeAgentFile(path)
+	require.NoError(t, err)
+	require.True(t, agent.ClaudeCode)
+	require.Equal(t, []string{"view", "grep", "glob", "bash", "mcp__github__get_pr"}, agent.Tools)
+	require.Equal(t, "sonnet", agent.Model)
+	require.Equal(t, "acceptEdits", agent.PermissionMode)
+	require.Equal(t, "Review the diff.", agent.SystemPrompt)
+
+	require.NoError(t, os.WriteFile(path, []byte("---\nname: planner\ndescription: Plans\npermissionMode: yolo\n---\n"), 0o644))
+	agent, err = LoadClaudeAgentFile(path)
+	require.NoError(t, err)
+	require.Nil(t, agent.Tools)
+	require.Equal(t, "inherit", agent.Model)
+	require.Empty(t, agent.PermissionMode)
+}
+
+func TestLoadAgentsImportClaude(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	claudeDir := filepath.Join(workDir, ".claude", "agents")
+	crushDir := filepath.Join(workDir, ".crush", "agents")
+	require.NoError(t, os.MkdirAll(claudeDir, 0o755))
+	require.NoError(t, os.MkdirAll(crushDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(claudeDir, "reviewer.md"), []byte("---\nname: reviewer\ndescription: Claude\n---\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(claudeDir, "tester.md"), []byte("---\nname: tester\ndescription: Tests\ntools: Bash, Read\n---\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(crushDir, "reviewer.md"), []byte("---\nname: reviewer\ndescription: Crush\n---\n"), 0o644))
+
+	app := plugin.NewApp(plugin.WithWorkingDir(workDir), plugin.WithSubAgentRunner(&fakeRunner{}))
+
+	r := newTestRegistryWithApp(t, app, Config{Dirs: []string{".crush/agents"}})
+	r.LoadAgents()
+	_, ok := r.Get("tester")
+	require.False(t, ok)
+
+	r = newTestRegistryWithApp(t, app, Config{Dirs: []string{".crush/agents"}, ImportClaude: true})
+	r.LoadAgents()
+	reviewer, ok := r.Get("reviewer")
+	require.True(t, ok)
+	require.Equal(t, "Crush", reviewer.Description)
+	tester, ok := r.Get("tester")
+	require.True(t, ok)
+	require.Equal(t, []string{"bash", "view"}, tester.Tools)
+
+	require.NoError(t, r.ReloadAgent("tester"))
+	tester, _ = r.Get("tester")
+	require.Equal(t, []string{"bash", "view"}, tester.Tools)
+}
+
+func TestLoadAgentFileOutputSchema(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "yaml.md")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`---
+name: triage
+description: Triage
+outputSchema:
+  type: object
+  required: [severity]
+  properties:
+    severity:
+      enum: [low, high]
+---
+
+Triage.`), 0o644))
+	agent, err := LoadAgentFile(yamlPath)
+	require.NoError(t, err)
+	require.Equal(t, "object", agent.OutputSchema["type"])
+
+	jsonPath := filepath.Join(dir, "json.md")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`---
+name: count
+description: Count
+outputSchema: '{"type": "integer"}'
+---
+
+Count.`), 0o644))
+	agent, err = LoadAgentFile(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "integer", agent.OutputSchema["type"])
+
+	badPath := filepath.Join(dir, "bad.md")
+	require.NoError(t, os.WriteFile(badPath, []byte("---\nname: bad\ndescription: Bad\noutputSchema: '{nope'\n---\n"), 0o644))
+	_, err = LoadAgentFile(badPath)
+	require.ErrorContains(t, err, "not valid JSON")
+}
+
+func TestValidateSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := map[string]any{
+		"type":                 "object",
+		"required":             []any{"title", "tags"},
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"title": map[string]any{"type": "string"},
+			"score": map[string]any{"type": "integer"},
+			"level": map[string]any{"enum": []any{"low", "high"}},
+			"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	}
+
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{input: `{"title": "x", "tags": ["a"], "score": 3, "level": "low"}`},
+		{input: `[]`, wantErr: "$: expected type object"},
+		{input: `{"tags": []}`, wantErr: `missing required property "title"`},
+		{input: `{"title": "x", "tags": [1]}`, wantErr: "$.tags[0]: expected type string"},
+		{input: `{"title": "x", "tags": [], "score": 1.5}`, wantErr: "$.score: expected type integer"},
+		{input: `{"title": "x", "tags": [], "level": "mid"}`, wantErr: "$.level: value not in enum"},
+		{input: `{"title": "x", "tags": [], "extra": true}`, wantErr: `unexpected property "extra"`},
+	}
+
+	for _, tt := range tests {
+		var value any
+		require.NoError(t, json.Unmarshal([]byte(tt.input), &value))
+		err := validateSchema(schema, value, "$")
+		if tt.wantErr == "" {
+			require.NoError(t, err, tt.input)
+		} else {
+			require.ErrorContains(t, err, tt.wantErr, tt.input)
+		}
+	}
+}
+
+func TestRunStructuredOutput(t *testing.T) {
+	t.Parallel()
+
+	schema := outputSchema{
+		"type":       "object",
+		"required":   []any{"ok"},
+		"properties": map[string]any{"ok": map[string]any{"type": "boolean"}},
+	}
+
+	runner := &fakeRunner{replies: []string{"Sure! here you go", "```json\n{\"ok\": true}\n```"}}
+	r := newTestRegistry(t, runner, Config{}, "checker")
+	r.agents["checker"].OutputSchema = schema
+
+	out, err := r.Run(context.Background(), "checker", "check")
+	require.NoError(t, err)
+	require.Equal(t, "{\n  \"ok\": true\n}", out)
+	require.Len(t, runner.calls, 2)
+	require.Contains(t, runner.calls[0].Prompt, "conforms to this JSON schema")
+	require.Contains(t, runner.calls[1].Prompt, "Your previous reply was rejected")
+
+	runner = &fakeRunner{replies: []string{"{}", `{"ok": "yes"}`}}
+	r = newTestRegistry(t, runner, Config{}, "checker")
+	r.agents["checker"].OutputSchema = schema
+
+	_, err = r.Run(context.Background(), "checker", "check")
+	require.ErrorContains(t, err, "invalid structured output after retry")
+	require.Len(t, runner.calls, 2)
+}
+
+func TestRunHistory(t *testing.T) {
+	t.Parallel()
+
+	runner := &fakeRunner{
+		fail:    map[string]bool{"bad": true},
+		replies: []string{strings.Repeat("x", historyResultLimit+10)},
+	}
+	r := newTestRegistry(t, runner, Config{}, "good", "bad")
+
+	_, err := r.Run(context.Background(), "good", "first")
+	require.NoError(t, err)
+	_, err = r.Run(context.Background(), "bad", "second")
+	require.Error(t, err)
+	_, err = r.Run(context.Background(), "good", "third")
+	require.NoError(t, err)
+
+	all, err := r.history.Recent("", 0)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	require.Equal(t, "third", all[0].Prompt)
+	require.Equal(t, "first", all[2].Prompt)
+	require.True(t, all[2].Truncated)
+	require.Len(t, all[2].Result, historyResultLimit)
+	require.False(t, all[1].Succeeded())
+	require.Contains(t, all[1].Error, "boom")
+
+	good, err := r.history.Recent("good", 1)
+	require.NoError(t, err)
+	require.Len(t, good, 1)
+	require.Equal(t, "result from good", good[0].Result)
+
+	tool := NewHistoryTool(r)
+	resp, err := tool.Run(context.Background(), fantasy.ToolCall{ID: "1", Name: HistoryToolName, Input: `{"agent":"bad"}`})
+	require.NoError(t, err)
+	require.Contains(t, resp.Content, "## bad at ")
+	require.Contains(t, resp.Content, "Error: sub-agent execution failed: boom")
+}
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "abc", truncate("abc", 5))
+	require.Equal(t, "ab", truncate("abcd", 2))
+	require.Equal(t, "a", truncate("aé", 2))
+}
+
+func TestRegistryUsage(t *testing.T) {
+	t.Parallel()
+
+	sip := &fakeSessionInfo{}
+	runner := &fakeRunner{
+		fail:  map[string]bool{"flaky": true},
+		onRun: func() { sip.tokens.Add(250) },
+	}
+	app := plugin.NewApp(
+		plugin.WithWorkingDir(t.TempDir()),
+		plugin.WithSubAgentRunner(runner),
+		plugin.WithSessionInfoProvider(sip),
+	)
+	r := newTestRegistryWithApp(t, app, Config{}, "steady", "flaky")
+
+	for range 2 {
+		_, err := r.Run(context.Background(), "steady", "go")
+		require.NoError(t, err)
+	}
+	_, err := r.Run(context.Background(), "flaky", "go")
+	require.Error(t, err)
+
+	require.Equal(t, 2, r.Usage("steady").Runs)
+	require.Equal(t, int64(500), r.Usage("steady").Tokens)
+	require.Equal(t, 1, r.Usage("flaky").Errors)
+	require.Zero(t, r.Usage("idle").Runs)
+	require.NotSame(t, agentmetrics.Shared(), r.metrics)
+}
+
+func TestRegistryCapabilities(t *testing.T) {
+	t.Parallel()
+
+	r := newTestRegistry(t, &fakeRunner{}, Config{}, "reviewer")
+	r.agents["reviewer"].Model = "inherit"
+	r.agents["planner"] = &SubAgent{Name: "planner", Model: "claude-opus", Enabled: true}
+	r.agents["off"] = &SubAgent{Name: "off", Model: "gpt-5"}
+
+	require.Equal(t, []string{"model:claude-opus", "subagent:planner", "subagent:reviewer"}, r.Capabilities())
+}
+
+func TestFormatTokens(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "950", formatTokens(950))
+	require.Equal(t, "12.3k", formatTokens(12_345))
+	require.Equal(t, "1.2M", formatTokens(1_234_567))
+}
+
+func TestParseSource(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		entry  string
+		want   remoteSource
+		remote bool
+	}{
+		{entry: ".crush/agents"},
+		{entry: "~/.crush/agents"},
+		{entry: "/abs/agents"},
+		{entry: "agents/shared/team"},
+		{
+			entry:  "github.com/org/agents",
+			want:   remoteSource{kind: sourceGit, url: "https://github.com/org/agents.git"},
+			remote: true,
+		},
+		{
+			entry:  "github.com/org/library/reviewers@v1.2.0",
+			want:   remoteSource{kind: sourceGit, url: "https://github.com/org/library.git", ref: "v1.2.0", subdir: "reviewers"},
+			remote: true,
+		},
+		{
+			entry:  "https://git.example.com/team/agents.git@main",
+			want:   remoteSource{kind: sourceGit, url: "https://git.example.com/team/agents.git", ref: "main"},
+			remote: true,
+		},
+		{
+			entry:  "https://example.com/agents/index.json",
+			want:   remoteSource{kind: sourceIndex, url: "https://example.com/agents/index.json"},
+			remote: true,
+		},
+		{
+			entry:  "http://example.com/agents/index.json",
+			want:   remoteSource{kind: sourceIndex, url: "http://example.com/agents/index.json", insecure: true},
+			remote: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSource(tt.entry)
+		require.Equal(t, tt.remote, ok, tt.entry)
+		if ok {
+			tt.want.entry = tt.entry
+			require.Equal(t, tt.want, got)
+		}
+	}
+}
+
+func TestSyncIndexSource(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"/agents/index.json":  `{"agents": ["reviewer.md", "/shared/writer.md"]}`,
+		"/agents/reviewer.md": "---\nname: reviewer\ndescription: Reviews\n---\n\nReview.",
+		"/shared/writer.md":   "---\nname: writer\ndescription: Writes\n---\n\nWrite.",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		content, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stale.md"), []byte("old"), 0o644))
+
+	src, ok := parseSource(srv.URL + "/agents/index.json")
+	require.True(t, ok)
+	require.NoError(t, syncIndexSource(context.Background(), src, dir, true))
+	require.ElementsMatch(t, []string{
+		filepath.Join(dir, "reviewer.md"),
+		filepath.Join(dir, "writer.md"),
+	}, DiscoverAgentFiles([]string{dir}, ""))
+
+	files["/agents/index.json"] = `{"agents": ["missing.md"]}`
+	require.Error(t, syncIndexSource(context.Background(), src, dir, true))
+	require.FileExists(t, filepath.Join(dir, "reviewer.md"))
+}
+
+func TestInsecureSource(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		if req.URL.Path == "/index.json" {
+			_, _ = w.Write([]byte(`{"agents": ["reviewer.md"]}`))
+			return
+		}
+		_, _ = w.Write([]byte("---\nname: reviewer\ndescription: Reviews\n---\n\nReview."))
+	}))
+	defer srv.Close()
+
+	// http:// sources are refused without allow_insecure.
+	cfg := Config{Dirs: []string{srv.URL + "/index.json"}, CacheDir: t.TempDir()}
+	r := newTestRegistry(t, &fakeRunner{}, cfg)
+	require.ErrorContains(t, r.SyncSources(context.Background()), "http:// sources need allow_insecure")
+	require.Zero(t, requests.Load())
+	_, ok := r.Get("reviewer")
+	require.False(t, ok)
+
+	// So are http:// files and redirects, however the index was reached.
+	src, _ := parseSource(srv.URL + "/index.json")
+	require.ErrorContains(t, syncIndexSource(context.Background(), src, t.TempDir(), false), "http:// needs allow_insecure")
+	require.Zero(t, requests.Load())
+	secure := httptest.NewTLSServer(http.RedirectHandler(srv.URL+"/index.json", http.StatusFound))
+	defer secure.Close()
+	_, err := fetchURL(context.Background(), secure.URL, false)
+	require.Error(t, err)
+	require.Zero(t, requests.Load())
+
+	cfg.AllowInsecure = true
+	r = newTestRegistry(t, &fakeRunner{}, cfg)
+	require.NoError(t, r.SyncSources(context.Background()))
+	_, ok = r.Get("reviewer")
+	require.True(t, ok)
+}
+
+func TestRemoteSourceConfined(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	secret := filepath.Join(root, "secret.txt")
+	require.NoError(t, os.WriteFile(secret, []byte("hunter2"), 0o644))
+
+	cfg := Config{Dirs: []string{"https://example.com/agents/index.json"}, CacheDir: filepath.Join(root, "cache")}
+	src, ok := parseSource(cfg.Dirs[0])
+	require.True(t, ok)
+	dir := src.agentDir(cfg.CacheDir)
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	files := map[string]string{
+		"good.md":    "---\nname: good\ndescription: Good\ncontextFiles: [\"" + secret + "\"]\n---\n\n{{include \"partial.md\"}}",
+		"partial.md": "Be kind.",
+		"up.md":      "---\nname: up\ndescription: Up\n---\n\n{{include \"" + secret + "\"}}",
+		"home.md":    "---\nname: home\ndescription: Home\n---\n\n{{include \"~/.aws/credentials\"}}",
+		"link.md":    "---\nname: link\ndescription: Link\n---\n\n{{include \"leak.txt\"}}",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	require.NoError(t, os.Symlink(secret, filepath.Join(dir, "leak.txt")))
+
+	r := newTestRegistry(t, &fakeRunner{}, cfg)
+	r.LoadAgents()
+
+	good, ok := r.Get("good")
+	require.True(t, ok)
+	require.Equal(t, "Be kind.", good.SystemPrompt)
+	require.Empty(t, good.ContextFiles, "contextFiles of remote agents are ignored")
+	for _, name := range []string{"up", "home", "link"} {
+		_, ok := r.Get(name)
+		require.False(t, ok, "%s includes a file outside its source", name)
+	}
+
+	// Local agents may include files from anywhere.
+	agent, err := LoadAgentFile(filepath.Join(dir, "up.md"))
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", agent.SystemPrompt)
+}
+
+func TestSyncGitSource(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	write := func(description string) {
+		content := "---\nname: shared\ndescription: " + description + "\n---\n\nBody."
+		require.NoError(t, os.WriteFile(filepath.Join(repo, "shared.md"), []byte(content), 0o644))
+	}
+
+	git("init", "--quiet")
+	write("First")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "first")
+	git("tag", "v1")
+	write("Second")
+	git("commit", "--quiet", "-am", "second")
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	load := func() string {
+		agent, err := LoadAgentFile(filepath.Join(dir, "shared.md"))
+		require.NoError(t, err)
+		return agent.Description
+	}
+
+	require.NoError(t, syncGitSource(context.Background(), remoteSource{url: repo, ref: "v1"}, dir))
+	require.Equal(t, "First", load())
+
+	require.NoError(t, syncGitSource(context.Background(), remoteSource{url: repo}, dir))
+	require.Equal(t, "Second", load())
 }
@@ -0,0 +1,57 @@
+package subagents
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// hasAnyTag reports whether the agent carries one of tags, ignoring case.
+func (a *SubAgent) hasAnyTag(tags []string) bool {
+	for _, tag := range a.Tags {
+		if slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns every tag used by a loaded agent, sorted. Tags differing only
+// in case are listed once.
+func (r *Registry) Tags() []string {
+	var tags []string
+	for _, agent := range r.List() {
+		tags = append(tags, agent.Tags...)
+	}
+	slices.SortFunc(tags, func(a, b string) int {
+		if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return slices.CompactFunc(tags, strings.EqualFold)
+}
+
+// SetTagEnabled enables or disables every agent tagged with tag and returns
+// how many agents it changed.
+func (r *Registry) SetTagEnabled(tag string, enabled bool) (int, error) {
+	tag = strings.TrimSpace(tag)
+	var names []string
+	for _, agent := range r.List() {
+		if agent.hasAnyTag([]string{tag}) {
+			names = append(names, agent.Name)
+		}
+	}
+	if len(names) == 0 {
+		return 0, fmt.Errorf("no agents tagged %s", tag)
+	}
+
+	changed := 0
+	for _, name := range names {
+		if agent, ok := r.Get(name); ok && agent.Enabled != enabled {
+			r.SetEnabled(name, enabled)
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,84 @@
+package subagents
+
+import (
+	"slices"
+	"strings"
+)
+
+// BuiltinTools are the tool names Crush provides without plugins.
+var BuiltinTools = []string{
+	"agent", "bash", "diagnostics", "download", "edit", "fetch", "glob",
+	"grep", "ls", "multiedit", "sourcegraph", "todos", "view", "write",
+}
+
+// toolAliases maps lowercased tool names used by other agent formats, such
+// as Claude Code's Read or WebFetch, to Crush tool names.
+var toolAliases = map[string]string{
+	"read":         "view",
+	"readfile":     "view",
+	"read_file":    "view",
+	"cat":          "view",
+	"writefile":    "write",
+	"write_file":   "write",
+	"editfile":     "edit",
+	"edit_file":    "edit",
+	"notebookedit": "edit",
+	"shell":        "bash",
+	"terminal":     "bash",
+	"list":         "ls",
+	"webfetch":     "fetch",
+	"web_fetch":    "fetch",
+	"todowrite":    "todos",
+	"task":         "agent",
+}
+
+// NormalizeTool maps a tool name from an agent file to the Crush tool it
+// refers to. Permission patterns such as Bash(git diff:*) become the bare
+// tool. Names are matched case-insensitively; MCP tools (mcp_ prefix) are
+// returned unchanged.
+func NormalizeTool(name string) string {
+	name, _, _ = strings.Cut(name, "(")
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "mcp_") {
+		return name
+	}
+	name = strings.ToLower(name)
+	if alias, ok := toolAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// normalizeTools normalizes a tool list, dropping duplicates.
+func normalizeTools(tools []string) []string {
+	if tools == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		if name := NormalizeTool(tool); name != "" && !slices.Contains(normalized, name) {
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized
+}
+
+// knownTools returns the tool names agent files are checked against: the
+// built-in tools, those registered by plugins, and those configured in
+// known_tools. Builds that cannot list the registered tools check against
+// the other two, so plugin tools need listing in known_tools there.
+func knownTools(cfg Config) []string {
+	return slices.Concat(BuiltinTools, registeredTools(), normalizeTools(cfg.KnownTools))
+}
+
+// unknownTools returns the tools of agent that are not in known, so
+// restrictions naming them would not take effect.
+func unknownTools(agent *SubAgent, known []string) []string {
+	var unknown []string
+	for _, tool := range slices.Concat(agent.Tools, agent.DisallowedTools) {
+		if !slices.Contains(known, tool) && !strings.HasPrefix(tool, "mcp_") {
+			unknown = append(unknown, tool)
+		}
+	}
+	return unknown
+}
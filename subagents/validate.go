@@ -0,0 +1,336 @@
+package subagents
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aleksclark/crush-modules/frontmatter"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateFlag runs agent validation from the command line instead of
+// starting Crush.
+const ValidateFlag = "--validate-agents"
+
+// AgentIssue is a problem found in an agent file.
+type AgentIssue struct {
+	Path    string
+	Line    int // 1-based line in the file, 0 when unknown
+	Message string
+	Warning bool // Warnings do not stop the agent from loading
+}
+
+// String formats the issue as path:line: severity: message.
+func (i AgentIssue) String() string {
+	severity := "error"
+	if i.Warning {
+		severity = "warning"
+	}
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", i.Path, i.Line, severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Path, severity, i.Message)
+}
+
+// frontmatterKeys are the keys LoadAgentFile understands.
+var frontmatterKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(SubAgent{})
+	for i := range t.NumField() {
+		if tag := t.Field(i).Tag.Get("yaml"); tag != "" && tag != "-" {
+			keys[strings.Split(tag, ",")[0]] = true
+		}
+	}
+	return keys
+}()
+
+// yamlLinePattern finds the line number in YAML decoding errors.
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// ValidateAgents checks every agent file in dirs: frontmatter syntax and
+// fields, tool names against knownTools, models, extends targets, and names
+// shadowed by a project agent or an earlier directory. Tools prefixed with mcp_ are not checked.
+func ValidateAgents(dirs []string, workingDir string, knownTools []string) []AgentIssue {
+	var issues []AgentIssue
+	names := make(map[string]string) // Agent name to the file that defines it
+	agents := make(map[string]*SubAgent)
+	extends := make(map[string]AgentIssue) // Extending agent to the issue if its base is missing
+
+	isProject := func(path string) bool { return within(workingDir, path) }
+	for _, path := range projectFirst(DiscoverAgentFiles(dirs, workingDir), isProject) {
+		fileIssues, agent, keys := validateAgentFile(path, knownTools)
+		issues = append(issues, fileIssues...)
+		if agent == nil {
+			continue
+		}
+
+		if first, ok := names[agent.Name]; ok {
+			issues = append(issues, AgentIssue{
+				Path:    path,
+				Line:    keys["name"],
+				Message: fmt.Sprintf("duplicate name %q, shadowed by %s", agent.Name, first),
+				Warning: true,
+			})
+			continue
+		}
+		names[agent.Name] = path
+		agents[agent.Name] = agent
+		if agent.Extends != "" {
+			extends[agent.Name] = AgentIssue{
+				Path:    path,
+				Line:    keys["extends"],
+				Message: fmt.Sprintf("base agent not found: %s", agent.Extends),
+			}
+		}
+	}
+
+	for name, issue := range extends {
+		if agents[agents[name].Extends] == nil {
+			issues = append(issues, issue)
+		}
+	}
+
+	slices.SortStableFunc(issues, func(a, b AgentIssue) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return issues
+}
+
+// validateAgentFile checks a single file. It returns the parsed agent, if
+// it loads, and the file line of each frontmatter key.
+func validateAgentFile(path string, knownTools []string) ([]AgentIssue, *SubAgent, map[string]int) {
+	issue := func(line int, warning bool, format string, args ...any) AgentIssue {
+		return AgentIssue{Path: path, Line: line, Message: fmt.Sprintf(format, args...), Warning: warning}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []AgentIssue{issue(0, false, "%v", err)}, nil, nil
+	}
+	fm, _, err := frontmatter.Split(data)
+	if err != nil {
+		return []AgentIssue{issue(1, false, "%v", err)}, nil, nil
+	}
+
+	// The frontmatter starts on the second line of the file.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(fm, &doc); err != nil {
+		return []AgentIssue{issue(yamlErrorLine(err), false, "%v", err)}, nil, nil
+	}
+
+	var issues []AgentIssue
+	keys := make(map[string]int)
+	values := make(map[string]*yaml.Node)
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		mapping := doc.Content[0]
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			key := mapping.Content[i]
+			keys[key.Value] = key.Line + 1
+			values[key.Value] = mapping.Content[i+1]
+			if !frontmatterKeys[key.Value] {
+				issues = append(issues, issue(key.Line+1, true, "unknown field %q", key.Value))
+			}
+		}
+	}
+
+	agent, err := LoadAgentFile(path)
+	if err != nil {
+		line := yamlErrorLine(err)
+		if line == 0 {
+			line = fieldLine(err.Error(), keys)
+		}
+		return append(issues, issue(line, false, "%v", err)), nil, keys
+	}
+
+	known := make(map[string]bool, len(knownTools))
+	for _, tool := range knownTools {
+		known[tool] = true
+	}
+	for _, field := range []struct {
+		key   string
+		tools []string
+	}{{"tools", agent.ToolsRaw}, {"disallowedTools", agent.DisallowedRaw}} {
+		for _, tool := range field.tools {
+			if name := NormalizeTool(tool); len(known) > 0 && !known[name] && !strings.HasPrefix(name, "mcp_") {
+				issues = append(issues, issue(toolLine(values[field.key], tool, keys[field.key]), true, "unknown tool %q", tool))
+			}
+		}
+	}
+
+	if _, ok := keys["model"]; !ok && agent.Extends == "" {
+		issues = append(issues, issue(keys["name"], true, "no model set, inherits the parent's model"))
+	}
+	if agent.PermissionMode != "" && !permissionModes[agent.PermissionMode] {
+		issues = append(issues, issue(keys["permissionMode"], false, "invalid permission mode %q", agent.PermissionMode))
+	}
+
+	return issues, agent, keys
+}
+
+// yamlErrorLine converts the frontmatter line in a YAML error to a file line.
+func yamlErrorLine(err error) int {
+	m := yamlLinePattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n + 1
+}
+
+// fieldLine finds the line of the first field a validation error mentions.
+func fieldLine(msg string, keys map[string]int) int {
+	best := 0
+	for key, line := range keys {
+		if strings.Contains(msg, key) && (best == 0 || line < best) {
+			best = line
+		}
+	}
+	return max(best, 1)
+}
+
+// toolLine returns the line of tool within a tools value, or fallback.
+func toolLine(node *yaml.Node, tool string, fallback int) int {
+	if node != nil && node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			if strings.TrimSpace(item.Value) == tool {
+				return item.Line + 1
+			}
+		}
+	}
+	return fallback
+}
+
+// Validate checks the registry's agent files.
+func (r *Registry) Validate() []AgentIssue {
+	return ValidateAgents(validateDirs(r.cfg, r.workingDir), r.workingDir, knownTools(r.cfg))
+}
+
+// validateDirs returns the directories cfg loads agents from, including
+// the cache directories of remote sources and, with import_claude, the
+// Claude Code agent directories.
+func validateDirs(cfg Config, workingDir string) []string {
+	dirs := cfg.Dirs
+	if len(dirs) == 0 {
+		dirs = DefaultDirs
+	}
+	cacheDir := ExpandPath(cmp.Or(cfg.CacheDir, DefaultCacheDir), workingDir)
+	dirs = agentDirs(dirs, cacheDir)
+	if cfg.ImportClaude {
+		dirs = append(dirs, ClaudeDirs...)
+	}
+	return dirs
+}
+
+// RunValidate validates the agents Crush would load in workingDir, going by
+// the plugin config in crush.json, prints the issues to out, and returns the
+// process exit code: 1 if any errors were found, 0 otherwise. Dirs given
+// replace the configured ones.
+func RunValidate(dirs []string, workingDir string, out io.Writer) int {
+	cfg, err := loadConfig(workingDir)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if len(dirs) > 0 {
+		cfg.Dirs = dirs
+		cfg.ImportClaude = false
+	}
+	dirs = validateDirs(cfg, workingDir)
+	issues := ValidateAgents(dirs, workingDir, knownTools(cfg))
+
+	failed := 0
+	for _, issue := range issues {
+		if !issue.Warning {
+			failed++
+		}
+		fmt.Fprintln(out, issue)
+	}
+	files := len(DiscoverAgentFiles(dirs, workingDir))
+	fmt.Fprintf(out, "%d agent files checked: %d errors, %d warnings\n", files, failed, len(issues)-failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// configFiles returns the crush.json files Crush reads in workingDir, in
+// the order they apply. Crush's config loader is internal to Crush, so this
+// follows its lookup: the global config, the config in the global data
+// directory, then crush.json and .crush.json in workingDir and each of its
+// parents, nearest last.
+func configFiles(workingDir string) []string {
+	var project []string
+	for dir := filepath.Clean(workingDir); ; dir = filepath.Dir(dir) {
+		project = append(project, filepath.Join(dir, "crush.json"), filepath.Join(dir, ".crush.json"))
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	slices.Reverse(project)
+	return append([]string{
+		filepath.Join(crushDir("CRUSH_GLOBAL_CONFIG", "XDG_CONFIG_HOME", ".config"), "crush.json"),
+		filepath.Join(crushDir("CRUSH_GLOBAL_DATA", "XDG_DATA_HOME", filepath.Join(".local", "share")), "crush.json"),
+	}, project...)
+}
+
+// crushDir returns a global Crush directory: the one named by override,
+// else crush in the directory named by xdg, else the platform default,
+// home/crush under the user's home directory outside Windows.
+func crushDir(override, xdg, home string) string {
+	if dir := os.Getenv(override); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv(xdg); dir != "" {
+		return filepath.Join(dir, "crush")
+	}
+	userHome, _ := os.UserHomeDir()
+	if runtime.GOOS == "windows" {
+		return filepath.Join(cmp.Or(os.Getenv("LOCALAPPDATA"), filepath.Join(userHome, "AppData", "Local")), "crush")
+	}
+	return filepath.Join(userHome, home, "crush")
+}
+
+// loadConfig reads the plugin config from the crush.json files for
+// workingDir, for use outside a running Crush. Fields set in a later file
+// override those of earlier ones.
+func loadConfig(workingDir string) (Config, error) {
+	var cfg Config
+	for _, path := range configFiles(workingDir) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		var file struct {
+			Options struct {
+				Plugins map[string]json.RawMessage `json:"plugins"`
+			} `json:"options"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return Config{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if raw, ok := file.Options.Plugins[ToolName]; ok {
+			if err := json.Unmarshal(raw, &cfg); err != nil {
+				return Config{}, fmt.Errorf("parse %s: options.plugins.%s: %w", path, ToolName, err)
+			}
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,106 @@
+package subagents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces bursts of file events (editors often write a file
+// several times on save) into a single reload.
+const watchDebounce = 200 * time.Millisecond
+
+// watchEnabled reports whether agent directories should be watched.
+func (c Config) watchEnabled() bool {
+	return c.Watch == nil || *c.Watch
+}
+
+// Watch monitors the configured agent directories, and the subdirectories
+// holding their prompt partials, and reloads the registry when agent files
+// are created, edited, or removed. Directories that do not exist yet are
+// skipped. Watch blocks until ctx is cancelled.
+func (r *Registry) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := r.agentDirs()
+	if r.cfg.ImportClaude {
+		dirs = append(dirs, ClaudeDirs...)
+	}
+
+	watched := 0
+	for _, dir := range dirs {
+		path := ExpandPath(dir, r.workingDir)
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := watcher.Add(path); err != nil {
+			r.logger.Warn("failed to watch agent dir", "dir", path, "error", err)
+			continue
+		}
+		watched++
+
+		// Subdirectories hold shared prompt partials pulled in by include.
+		entries, _ := os.ReadDir(path)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				_ = watcher.Add(filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	if watched == 0 {
+		r.logger.Debug("no agent dirs to watch")
+	}
+
+	var (
+		timer   *time.Timer
+		timerCh <-chan time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !isAgentFileEvent(event) {
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(watchDebounce)
+			} else {
+				timer.Reset(watchDebounce)
+			}
+			timerCh = timer.C
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.logger.Warn("agent dir watch error", "error", err)
+		case <-timerCh:
+			timerCh = nil
+			r.ReloadAll()
+			r.logger.Info("reloaded sub-agents after file change", "count", len(r.List()))
+		}
+	}
+}
+
+// isAgentFileEvent reports whether an event affects an agent definition or
+// its fixtures.
+func isAgentFileEvent(event fsnotify.Event) bool {
+	if !strings.HasSuffix(event.Name, ".md") && !strings.HasSuffix(event.Name, fixtureSuffix) {
+		return false
+	}
+	return event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0
+}
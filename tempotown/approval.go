@@ -0,0 +1,125 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Fallbacks applied when Tempotown cannot answer a permission request.
+const (
+	// ApprovalFallbackAsk asks the user in Crush as usual.
+	ApprovalFallbackAsk = "ask"
+	// ApprovalFallbackDeny refuses the tool call.
+	ApprovalFallbackDeny = "deny"
+)
+
+// defaultApprovalTimeout is how long to wait for a remote decision.
+const defaultApprovalTimeout = 5 * time.Minute
+
+// ApprovalConfig routes Crush's tool permission requests to Tempotown.
+type ApprovalConfig struct {
+	// Enabled sends permission requests to Tempotown with request_approval
+	// so a supervisor or operator can decide for an unattended agent.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// TimeoutSeconds is how long to wait for a decision (default: 300).
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// Fallback is what happens when Tempotown is unreachable or does not
+	// decide in time: ask (default) or deny.
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// approvalTimeout returns the configured wait for a remote decision.
+func (h *TempotownHook) approvalTimeout() time.Duration {
+	if h.cfg.Approval.TimeoutSeconds > 0 {
+		return time.Duration(h.cfg.Approval.TimeoutSeconds) * time.Second
+	}
+	return defaultApprovalTimeout
+}
+
+// approvalFallback returns the configured fallback, defaulting to ask.
+func (h *TempotownHook) approvalFallback() string {
+	if h.cfg.Approval.Fallback == ApprovalFallbackDeny {
+		return ApprovalFallbackDeny
+	}
+	return ApprovalFallbackAsk
+}
+
+// permissionRequest is a tool permission request from Crush.
+type permissionRequest struct {
+	SessionID   string
+	ToolName    string
+	Action      string
+	Description string
+	Path        string
+}
+
+// decidePermission answers a permission request. Edits of files claimed by
+// another agent are refused first with the block claim guard. Otherwise,
+// with approval enabled, it asks Tempotown to approve the tool call and
+// returns its decision. When no decision arrives, the call is refused with
+// the deny fallback; with the ask fallback, or approval off, an error is
+// returned so that Crush asks the user instead.
+func (h *TempotownHook) decidePermission(ctx context.Context, req permissionRequest) (bool, error) {
+	if !h.guardEdit(ctx, req) {
+		return false, nil
+	}
+	if !h.cfg.Approval.Enabled {
+		return false, errLeftToUser
+	}
+
+	approved, err := h.requestApproval(ctx, req)
+	if err == nil {
+		return approved, nil
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	fallback := h.approvalFallback()
+	h.logger.Warn("remote approval unavailable", "tool", req.ToolName, "fallback", fallback, "error", err)
+	if fallback == ApprovalFallbackDeny {
+		return false, nil
+	}
+	return false, err
+}
+
+// requestApproval calls request_approval and waits for the decision, up to
+// the configured timeout.
+func (h *TempotownHook) requestApproval(ctx context.Context, req permissionRequest) (bool, error) {
+	if !h.connected.Load() {
+		return false, errNotConnected
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.approvalTimeout())
+	defer cancel()
+
+	h.logger.Info("requesting remote approval", "tool", req.ToolName, "action", req.Action)
+	result, err := h.callTool(ctx, "request_approval", map[string]any{
+		"agent_id":    h.AgentID(),
+		"task_id":     h.CurrentTask(),
+		"session_id":  req.SessionID,
+		"tool":        req.ToolName,
+		"action":      req.Action,
+		"description": req.Description,
+		"path":        req.Path,
+	})
+	if err != nil {
+		return false, fmt.Errorf("request_approval: %w", err)
+	}
+
+	var decision struct {
+		Approved *bool  `json:"approved"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(result), &decision); err != nil {
+		return false, fmt.Errorf("request_approval: unmarshal result: %w", err)
+	}
+	if decision.Approved == nil {
+		return false, fmt.Errorf("request_approval: no decision")
+	}
+	h.logger.Info("remote approval decided", "tool", req.ToolName, "approved", *decision.Approved, "reason", decision.Reason)
+	return *decision.Approved, nil
+}
@@ -0,0 +1,182 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// Artifact kinds uploaded when a task is completed.
+const (
+	ArtifactDiff         = "git_diff"
+	ArtifactChangedFiles = "changed_files"
+	ArtifactTestOutput   = "test_output"
+)
+
+const (
+	// maxArtifactBytes caps the content of one artifact.
+	maxArtifactBytes = 256 * 1024
+
+	// maxTestRuns is how many test runs per task are kept for upload.
+	maxTestRuns = 5
+)
+
+// testCommandPattern matches bash commands that run a test suite.
+var testCommandPattern = regexp.MustCompile(`\b(go test|gotestsum|(npm|yarn|pnpm|bun)( run)? test|pytest|cargo test|make test|task test|mvn test|gradle test|jest|vitest|rspec)\b`)
+
+// Artifact is a piece of a task's work product uploaded to Tempotown.
+type Artifact struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+}
+
+// testRun is the output of a test command run during a task.
+type testRun struct {
+	command string
+	output  string
+	failed  bool
+}
+
+// uploadArtifacts returns whether artifacts are uploaded on completion.
+func (h *TempotownHook) uploadArtifacts() bool {
+	return h.cfg.UploadArtifacts == nil || *h.cfg.UploadArtifacts
+}
+
+// startArtifacts begins collecting artifacts for a newly accepted task,
+// remembering the commit it started from so that the diff covers work
+// committed during the task too.
+func (h *TempotownHook) startArtifacts() {
+	base := ""
+	if dir := h.workingDir(); dir != "" {
+		base, _ = git(dir, "rev-parse", "HEAD")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.taskBase = base
+	h.testCalls = make(map[string]string)
+	h.testRuns = nil
+}
+
+// noteTestCommands remembers the bash calls that run tests, so that their
+// results can be captured.
+func (h *TempotownHook) noteTestCommands(calls []plugin.ToolCallInfo) {
+	for _, tc := range calls {
+		if tc.Name != "bash" {
+			continue
+		}
+		var input struct {
+			Command string `json:"command"`
+		}
+		if err := json.Unmarshal([]byte(tc.Input), &input); err != nil || !testCommandPattern.MatchString(input.Command) {
+			continue
+		}
+		h.mu.Lock()
+		if h.testCalls != nil {
+			h.testCalls[tc.ID] = input.Command
+		}
+		h.mu.Unlock()
+	}
+}
+
+// noteToolResults captures the output of test commands, keeping the most
+// recent runs.
+func (h *TempotownHook) noteToolResults(results []plugin.ToolResultInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, tr := range results {
+		command, ok := h.testCalls[tr.ToolCallID]
+		if !ok {
+			continue
+		}
+		delete(h.testCalls, tr.ToolCallID)
+		h.testRuns = append(h.testRuns, testRun{command: command, output: tr.Content, failed: tr.IsError})
+		if len(h.testRuns) > maxTestRuns {
+			h.testRuns = h.testRuns[len(h.testRuns)-maxTestRuns:]
+		}
+	}
+}
+
+// collectArtifacts gathers the work product of the current task: the diff
+// and changed files since the task was accepted, and captured test output.
+// Empty artifacts are left out.
+func (h *TempotownHook) collectArtifacts() []Artifact {
+	h.mu.Lock()
+	base := h.taskBase
+	runs := slices.Clone(h.testRuns)
+	h.mu.Unlock()
+
+	var artifacts []Artifact
+	if dir := h.workingDir(); dir != "" && base != "" {
+		if diff, err := git(dir, "diff", base); err == nil && diff != "" {
+			artifacts = append(artifacts, Artifact{Name: "diff", Kind: ArtifactDiff, Content: diff})
+		}
+		changed, _ := git(dir, "diff", "--name-only", base)
+		untracked, _ := git(dir, "ls-files", "--others", "--exclude-standard")
+		if files := strings.TrimSpace(changed + "\n" + untracked); files != "" {
+			artifacts = append(artifacts, Artifact{Name: "changed-files", Kind: ArtifactChangedFiles, Content: files})
+		}
+	}
+	if len(runs) > 0 {
+		var sb strings.Builder
+		for i, run := range runs {
+			if i > 0 {
+				sb.WriteString("\n\n")
+			}
+			sb.WriteString("$ " + run.command + "\n")
+			if run.failed {
+				sb.WriteString("(failed)\n")
+			}
+			sb.WriteString(strings.TrimSpace(run.output))
+		}
+		artifacts = append(artifacts, Artifact{Name: "test-output", Kind: ArtifactTestOutput, Content: sb.String()})
+	}
+
+	for i := range artifacts {
+		if len(artifacts[i].Content) > maxArtifactBytes {
+			artifacts[i].Content = artifacts[i].Content[:maxArtifactBytes] + "\n... (truncated)"
+		}
+	}
+	return artifacts
+}
+
+// uploadTaskArtifacts sends the artifacts of taskID to Tempotown with
+// upload_artifact, one call per artifact.
+func (h *TempotownHook) uploadTaskArtifacts(ctx context.Context, taskID string, artifacts []Artifact) {
+	for _, a := range artifacts {
+		args := map[string]any{
+			"task_id":  taskID,
+			"agent_id": h.AgentID(),
+			"name":     a.Name,
+			"kind":     a.Kind,
+			"content":  a.Content,
+		}
+		if _, err := h.callTool(ctx, "upload_artifact", args); err != nil {
+			h.logger.Warn("failed to upload artifact", "task_id", taskID, "name", a.Name, "error", err)
+			continue
+		}
+		h.logger.Debug("uploaded artifact", "task_id", taskID, "name", a.Name, "bytes", len(a.Content))
+	}
+}
+
+// workingDir returns Crush's working directory, or "" without an app.
+func (h *TempotownHook) workingDir() string {
+	if h.app == nil {
+		return ""
+	}
+	return h.app.WorkingDir()
+}
+
+// git runs a git command in dir and returns its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
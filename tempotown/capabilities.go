@@ -0,0 +1,56 @@
+package tempotown
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// autoCapabilities returns whether capabilities are detected.
+func (h *TempotownHook) autoCapabilities() bool {
+	return h.cfg.AutoCapabilities == nil || *h.cfg.AutoCapabilities
+}
+
+// capabilities returns the capabilities advertised to Tempotown: the
+// detected ones, then the configured ones. A configured entry starting with
+// "-" removes the detected capabilities matching the rest of it as a glob,
+// such as "-tool:*".
+func (h *TempotownHook) capabilities() []string {
+	var caps []string
+	if h.autoCapabilities() {
+		caps = h.detectCapabilities()
+	}
+	for _, c := range h.configuredCapabilities() {
+		if pattern, ok := strings.CutPrefix(c, "-"); ok {
+			caps = slices.DeleteFunc(caps, func(detected string) bool {
+				matched, _ := path.Match(pattern, detected)
+				return matched
+			})
+			continue
+		}
+		if !slices.Contains(caps, c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// detectCapabilities derives capabilities from the plugin tools built into
+// Crush, the capabilities other plugins provide through agentcaps, such as
+// enabled sub-agents, and the model in use.
+func (h *TempotownHook) detectCapabilities() []string {
+	var caps []string
+	for _, name := range registeredTools() {
+		caps = append(caps, "tool:"+name)
+	}
+	caps = append(caps, h.caps.List()...)
+	if h.app != nil {
+		if sip := h.app.SessionInfo(); sip != nil {
+			if info := sip.SessionInfo(); info != nil && info.Model != "" {
+				caps = append(caps, "model:"+info.Model)
+			}
+		}
+	}
+	slices.Sort(caps)
+	return slices.Compact(caps)
+}
@@ -0,0 +1,134 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Claim guard modes, for when the agent is about to edit a file another
+// member of the ensemble has claimed.
+const (
+	// ClaimGuardWarn reports the conflict and lets the edit go ahead.
+	ClaimGuardWarn = "warn"
+	// ClaimGuardBlock refuses the edit.
+	ClaimGuardBlock = "block"
+)
+
+// errLeftToUser is returned by decidePermission when it has nothing to
+// decide, so that Crush asks the user as usual.
+var errLeftToUser = errors.New("no remote decision: left to the user")
+
+// Claim is the outcome of claim_resource.
+type Claim struct {
+	// Claimed is whether this agent holds the resource.
+	Claimed bool `json:"claimed"`
+	// Holder is the agent holding the resource when it was not claimed.
+	Holder string `json:"holder,omitempty"`
+}
+
+// claimGuard returns whether edits are guarded by claims.
+func (h *TempotownHook) claimGuard() bool {
+	return h.cfg.ClaimGuard == ClaimGuardWarn || h.cfg.ClaimGuard == ClaimGuardBlock
+}
+
+// ClaimResource claims resource, such as a file path relative to the
+// working directory, for this agent with claim_resource. A resource held by
+// another agent is not claimed and its holder is returned.
+func (h *TempotownHook) ClaimResource(ctx context.Context, resource string) (Claim, error) {
+	if !h.connected.Load() {
+		return Claim{}, errNotConnected
+	}
+	args := map[string]any{
+		"agent_id": h.AgentID(),
+		"resource": resource,
+	}
+	if taskID := h.CurrentTask(); taskID != "" {
+		args["task_id"] = taskID
+	}
+	result, err := h.callTool(ctx, "claim_resource", args)
+	if err != nil {
+		return Claim{}, fmt.Errorf("claim_resource: %w", err)
+	}
+	var claim Claim
+	if err := json.Unmarshal([]byte(result), &claim); err != nil {
+		return Claim{}, fmt.Errorf("claim_resource: unmarshal result: %w", err)
+	}
+	if claim.Claimed {
+		h.mu.Lock()
+		if !slices.Contains(h.claims, resource) {
+			h.claims = append(h.claims, resource)
+		}
+		h.mu.Unlock()
+	}
+	return claim, nil
+}
+
+// ReleaseResource releases a claim on resource with release_resource.
+func (h *TempotownHook) ReleaseResource(ctx context.Context, resource string) error {
+	if !h.connected.Load() {
+		return errNotConnected
+	}
+	if _, err := h.callTool(ctx, "release_resource", map[string]any{
+		"agent_id": h.AgentID(),
+		"resource": resource,
+	}); err != nil {
+		return fmt.Errorf("release_resource: %w", err)
+	}
+	h.mu.Lock()
+	h.claims = slices.DeleteFunc(h.claims, func(r string) bool { return r == resource })
+	h.mu.Unlock()
+	return nil
+}
+
+// Claims returns the resources this agent holds.
+func (h *TempotownHook) Claims() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.claims)
+}
+
+// releaseClaims releases every resource this agent holds, as when its task
+// is finished.
+func (h *TempotownHook) releaseClaims(ctx context.Context) {
+	for _, resource := range h.Claims() {
+		if err := h.ReleaseResource(ctx, resource); err != nil {
+			h.logger.Warn("failed to release resource", "resource", resource, "error", err)
+		}
+	}
+}
+
+// guardEdit claims the file a file tool is about to edit and reports
+// whether the edit may go ahead. When another agent holds it, the conflict
+// is reported to Tempotown and the edit is refused with the block guard.
+// Edits go ahead when the claim cannot be checked, so an unreachable
+// orchestrator does not stop the agent.
+func (h *TempotownHook) guardEdit(ctx context.Context, req permissionRequest) bool {
+	if !h.claimGuard() || req.Path == "" || !slices.Contains(fileTools, req.ToolName) {
+		return true
+	}
+	resource := h.relativePath(req.Path)
+	if slices.Contains(h.Claims(), resource) {
+		return true
+	}
+
+	claim, err := h.ClaimResource(ctx, resource)
+	if err != nil {
+		h.logger.Debug("could not check claim", "resource", resource, "error", err)
+		return true
+	}
+	if claim.Claimed {
+		return true
+	}
+
+	block := h.cfg.ClaimGuard == ClaimGuardBlock
+	h.logger.Warn("file claimed by another agent", "resource", resource, "holder", claim.Holder, "blocked", block)
+	h.reportStatus("resource_conflict", 0, map[string]any{
+		"resource": resource,
+		"holder":   claim.Holder,
+		"blocked":  block,
+	})
+	return !block
+}
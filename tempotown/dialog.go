@@ -0,0 +1,349 @@
+package tempotown
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// DialogID is the identifier for the Tempotown status dialog.
+	DialogID = "tempotown-status"
+
+	// maxRPCErrors is how many recent RPC errors the status dialog shows.
+	maxRPCErrors = 5
+
+	dialogWidth  = 70
+	dialogHeight = 23
+)
+
+// StatusReport is the last status successfully reported to Tempotown.
+type StatusReport struct {
+	Status   string
+	Progress int
+	At       time.Time
+}
+
+// RPCError is a failed call to Tempotown.
+type RPCError struct {
+	Method string
+	Err    string
+	At     time.Time
+}
+
+// Snapshot is the state of the Tempotown connection at one moment.
+type Snapshot struct {
+	Connected       bool
+	Transport       string
+	Endpoint        string
+	AgentID         string
+	Role            string
+	Task            string
+	Paused          agentcontrol.State
+	LastStatus      StatusReport
+	PendingFeedback int
+	// Queued is how many status reports and task results wait for a
+	// connection.
+	Queued       int
+	RecentErrors []RPCError
+}
+
+// Snapshot returns the current connection state, with the most recent RPC
+// errors first.
+func (h *TempotownHook) Snapshot() Snapshot {
+	paused := h.control.State()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	endpoint := h.cfg.Endpoint
+	switch {
+	case h.cfg.Transport == TransportStdio:
+		endpoint = strings.Join(append([]string{h.cfg.Command}, h.cfg.Args...), " ")
+	case endpoint == EndpointAuto && h.discovered.Port != 0:
+		endpoint = h.discovered.endpoint(h.cfg.Transport)
+	}
+	errs := make([]RPCError, len(h.rpcErrors))
+	for i, e := range h.rpcErrors {
+		errs[len(errs)-1-i] = e
+	}
+	return Snapshot{
+		Connected:       h.connected.Load(),
+		Transport:       h.cfg.Transport,
+		Endpoint:        endpoint,
+		AgentID:         h.agentID,
+		Role:            h.role,
+		Task:            h.currentTask,
+		Paused:          paused,
+		LastStatus:      h.lastStatus,
+		PendingFeedback: len(h.feedbackCh),
+		Queued:          h.queuedCount(),
+		RecentErrors:    errs,
+	}
+}
+
+// recordRPCError keeps err for the status dialog, dropping the oldest once
+// there are more than maxRPCErrors.
+func (h *TempotownHook) recordRPCError(method string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rpcErrors = append(h.rpcErrors, RPCError{Method: method, Err: err.Error(), At: time.Now()})
+	if len(h.rpcErrors) > maxRPCErrors {
+		h.rpcErrors = h.rpcErrors[len(h.rpcErrors)-maxRPCErrors:]
+	}
+}
+
+// Dialog shows the state of the Tempotown connection and lets the user
+// reconnect or re-register the agent, or change its role.
+type Dialog struct {
+	hook   *TempotownHook
+	cursor int // 0=Reconnect, 1=Re-register, 2=Next Role, 3=Reload Role, 4=Close
+	width  int
+	height int
+
+	// notice is the outcome of the last action, set from the goroutine
+	// running it.
+	mu     sync.Mutex
+	notice string
+}
+
+// NewDialog creates a new Tempotown status dialog.
+func NewDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	hook, err := loadHook(app)
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil {
+		return nil, fmt.Errorf("tempotown is not configured: set an endpoint in options.plugins.tempotown")
+	}
+	return newDialog(hook), nil
+}
+
+func newDialog(hook *TempotownHook) *Dialog {
+	return &Dialog{
+		hook:   hook,
+		width:  dialogWidth,
+		height: dialogHeight,
+	}
+}
+
+func (d *Dialog) ID() string {
+	return DialogID
+}
+
+func (d *Dialog) Title() string {
+	return "Tempotown"
+}
+
+func (d *Dialog) Init() error {
+	return nil
+}
+
+func (d *Dialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		switch e.Key {
+		case "left", "h":
+			if d.cursor > 0 {
+				d.cursor--
+			}
+		case "right", "l":
+			if d.cursor < 4 {
+				d.cursor++
+			}
+		case "enter", " ", "space":
+			switch d.cursor {
+			case 0:
+				d.reconnect()
+			case 1:
+				d.reregister()
+			case 2:
+				d.nextRole()
+			case 3:
+				d.reloadRole()
+			case 4:
+				return true, plugin.NoAction{}, nil
+			}
+		case "r":
+			d.reconnect()
+		case "g":
+			d.reregister()
+		case "o":
+			d.nextRole()
+		case "c":
+			d.reloadRole()
+		case "esc", "q":
+			return true, plugin.NoAction{}, nil
+		}
+	case plugin.ResizeEvent:
+		d.width = min(dialogWidth, e.Width-10)
+		d.height = min(dialogHeight, e.Height-6)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+func (d *Dialog) reconnect() {
+	d.hook.Reconnect()
+	d.setNotice("Reconnecting...")
+}
+
+// reregister registers the agent again in the background, since the call
+// can take as long as the request timeout.
+func (d *Dialog) reregister() {
+	d.setNotice("Re-registering...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := d.hook.Reregister(ctx); err != nil {
+			d.setNotice("Re-register failed: " + err.Error())
+			return
+		}
+		d.setNotice("Re-registered as " + d.hook.AgentID() + ".")
+	}()
+}
+
+// nextRole switches the agent to the next of Roles in the background.
+func (d *Dialog) nextRole() {
+	role := d.hook.nextRole()
+	d.setNotice("Switching to " + role + "...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := d.hook.SetRole(ctx, role, nil); err != nil {
+			d.setNotice("Role change failed: " + err.Error())
+			return
+		}
+		d.setNotice("Role is now " + role + ".")
+	}()
+}
+
+// reloadRole applies the configured role and capabilities in the
+// background.
+func (d *Dialog) reloadRole() {
+	d.setNotice("Reloading role...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := d.hook.ReloadRole(ctx); err != nil {
+			d.setNotice("Reload failed: " + err.Error())
+			return
+		}
+		d.setNotice("Role is now " + d.hook.Role() + ".")
+	}()
+}
+
+func (d *Dialog) setNotice(notice string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notice = notice
+}
+
+func (d *Dialog) getNotice() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.notice
+}
+
+func (d *Dialog) View() string {
+	var sb strings.Builder
+	s := d.hook.Snapshot()
+
+	state := "Disconnected"
+	if s.Connected {
+		state = "Connected"
+	}
+	sb.WriteString(fmt.Sprintf("Connection: %s (%s %s)\n", state, s.Transport, truncate(s.Endpoint, 30)))
+	agentID := s.AgentID
+	if agentID == "" {
+		agentID = "(not registered)"
+	}
+	sb.WriteString(fmt.Sprintf("Agent ID: %s\n", agentID))
+	sb.WriteString(fmt.Sprintf("Role: %s\n", s.Role))
+	if s.Task != "" {
+		sb.WriteString(fmt.Sprintf("Task: %s\n", s.Task))
+	}
+	if s.Paused.Paused {
+		paused := "since " + s.Paused.Since.Format("15:04:05")
+		if s.Paused.Reason != "" {
+			paused = s.Paused.Reason + " (" + paused + ")"
+		}
+		sb.WriteString(fmt.Sprintf("Paused: %s\n", truncate(paused, d.width-12)))
+	}
+
+	lastStatus := "none yet"
+	if !s.LastStatus.At.IsZero() {
+		lastStatus = fmt.Sprintf("%s (%d%%) at %s", s.LastStatus.Status, s.LastStatus.Progress, s.LastStatus.At.Format("15:04:05"))
+	}
+	sb.WriteString(fmt.Sprintf("Last Status: %s\n", truncate(lastStatus, d.width-17)))
+	sb.WriteString(fmt.Sprintf("Pending Feedback: %d\n", s.PendingFeedback))
+	if s.Queued > 0 {
+		sb.WriteString(fmt.Sprintf("Queued Reports: %d\n", s.Queued))
+	}
+
+	sb.WriteString("\nRecent Errors:\n")
+	if len(s.RecentErrors) == 0 {
+		sb.WriteString("  none\n")
+	}
+	for _, e := range s.RecentErrors {
+		line := fmt.Sprintf("  %s %s: %s", e.At.Format("15:04:05"), e.Method, e.Err)
+		sb.WriteString(truncate(line, d.width-4) + "\n")
+	}
+
+	if notice := d.getNotice(); notice != "" {
+		sb.WriteString("\n" + truncate(notice, d.width-4) + "\n")
+	}
+
+	// Action buttons.
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+
+	buttons := []string{"Reconnect", "Re-register", "Next Role", "Reload Role", "Close"}
+	var btnLine strings.Builder
+	for i, btn := range buttons {
+		if i == d.cursor {
+			btnLine.WriteString(fmt.Sprintf("[%s]  ", btn))
+		} else {
+			btnLine.WriteString(fmt.Sprintf(" %s   ", btn))
+		}
+	}
+	sb.WriteString(btnLine.String() + "\n")
+	sb.WriteString("←/→: Select  Enter: Action  r: Reconnect  g: Re-register\n")
+	sb.WriteString("o: Next role  c: Reload role from config  Esc: Close")
+
+	return sb.String()
+}
+
+// truncate shortens s to at most n bytes, marking the cut with "...".
+func truncate(s string, n int) string {
+	if len(s) <= n || n < 4 {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+func (d *Dialog) Size() (width, height int) {
+	return d.width, d.height
+}
+
+func init() {
+	// Register the dialog factory.
+	plugin.RegisterDialog(DialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewDialog(app)
+	})
+
+	// Register the command to open the dialog.
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "tempotown",
+			Title:       "Tempotown",
+			Description: "Show the Tempotown connection status",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			return plugin.OpenDialogAction{DialogID: DialogID}
+		},
+	)
+}
@@ -0,0 +1,349 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EndpointAuto as the endpoint discovers the orchestrator on the local
+	// network with mDNS/DNS-SD before every connection attempt.
+	EndpointAuto = "auto"
+
+	// DefaultDiscoveryService is the DNS-SD service type browsed for.
+	DefaultDiscoveryService = "_tempotown._tcp"
+
+	// DiscoveryTimeout is how long to wait for responders.
+	DiscoveryTimeout = 3 * time.Second
+)
+
+// mdnsGroup is where mDNS queries are sent.
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// errNoOrchestrator is returned when no responder announced the service.
+var errNoOrchestrator = errors.New("no Tempotown orchestrator found on the local network")
+
+// DNS record types used by DNS-SD.
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+
+	dnsClassIN = 1
+	// dnsUnicastResponse asks responders to answer the querier directly.
+	dnsUnicastResponse = 1 << 15
+)
+
+// discoveredService is an orchestrator instance found by discovery.
+type discoveredService struct {
+	Instance string
+	// Host is the SRV target, without the trailing dot.
+	Host string
+	// IP is the host's address from the response, if it carried one.
+	IP   net.IP
+	Port uint16
+	// TXT holds the key=value pairs of the TXT record. "path" is appended
+	// to the address for the websocket transport.
+	TXT map[string]string
+}
+
+// endpoint returns the address to connect to over transport.
+func (s discoveredService) endpoint(transport string) string {
+	host := s.Host
+	if s.IP != nil {
+		host = s.IP.String()
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(s.Port)))
+	if transport == TransportWebSocket {
+		addr += s.TXT["path"]
+	}
+	return addr
+}
+
+// discoverEndpoint looks for the orchestrator and remembers where it is for
+// dialing. It is logged when the endpoint changes.
+func (h *TempotownHook) discoverEndpoint(ctx context.Context) error {
+	service := h.cfg.DiscoveryService
+	svc, err := discover(ctx, h.mdnsAddr, service, DiscoveryTimeout)
+	if err != nil {
+		return fmt.Errorf("discover %s: %w", service, err)
+	}
+
+	endpoint := svc.endpoint(h.cfg.Transport)
+	h.mu.Lock()
+	changed := h.discovered.endpoint(h.cfg.Transport) != endpoint
+	h.discovered = svc
+	h.mu.Unlock()
+	if changed {
+		h.logger.Info("discovered Tempotown", "instance", svc.Instance, "endpoint", endpoint)
+	}
+	return nil
+}
+
+// endpoint returns the address to dial: the configured endpoint, or the one
+// discovered last when it is auto.
+func (h *TempotownHook) endpoint() string {
+	if h.cfg.Endpoint != EndpointAuto {
+		return h.cfg.Endpoint
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.discovered.Port == 0 {
+		return ""
+	}
+	return h.discovered.endpoint(h.cfg.Transport)
+}
+
+// serverName returns the name to verify the server's certificate against
+// when dialing a discovered address, or "" to take it from the address.
+func (h *TempotownHook) serverName() string {
+	if h.cfg.Endpoint != EndpointAuto {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.discovered.Host
+}
+
+// discover sends a DNS-SD query for service to addr, normally the mDNS
+// group, and returns the first instance announced with a port within
+// timeout.
+func discover(ctx context.Context, addr *net.UDPAddr, service string, timeout time.Duration) (discoveredService, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return discoveredService{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	name := dnsName(service + ".local")
+	if _, err := conn.WriteToUDP(dnsQuery(name, dnsTypePTR), addr); err != nil {
+		return discoveredService{}, err
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return discoveredService{}, context.Cause(ctx)
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return discoveredService{}, errNoOrchestrator
+			}
+			return discoveredService{}, err
+		}
+		records, err := parseDNSMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		if svc, ok := findService(records, name); ok {
+			return svc, nil
+		}
+	}
+}
+
+// dnsRecord is a resource record of a DNS response. Names are lower case
+// with a trailing dot.
+type dnsRecord struct {
+	Name   string
+	Type   uint16
+	Target string // PTR and SRV
+	Port   uint16 // SRV
+	IP     net.IP // A and AAAA
+	TXT    []string
+}
+
+// findService returns the first instance of service in records with a
+// port, along with its TXT pairs and address when present.
+func findService(records []dnsRecord, service string) (discoveredService, bool) {
+	for _, ptr := range records {
+		if ptr.Type != dnsTypePTR || ptr.Name != service {
+			continue
+		}
+		svc := discoveredService{
+			Instance: strings.TrimSuffix(strings.TrimSuffix(ptr.Target, service), "."),
+			TXT:      make(map[string]string),
+		}
+		for _, rr := range records {
+			if rr.Name != ptr.Target {
+				continue
+			}
+			switch rr.Type {
+			case dnsTypeSRV:
+				svc.Host, svc.Port = rr.Target, rr.Port
+			case dnsTypeTXT:
+				for _, kv := range rr.TXT {
+					k, v, _ := strings.Cut(kv, "=")
+					svc.TXT[strings.ToLower(k)] = v
+				}
+			}
+		}
+		if svc.Port == 0 {
+			continue
+		}
+		for _, rr := range records {
+			if rr.Name != svc.Host || rr.IP == nil {
+				continue
+			}
+			// Prefer IPv4, which the query was sent over.
+			if svc.IP == nil || (svc.IP.To4() == nil && rr.IP.To4() != nil) {
+				svc.IP = rr.IP
+			}
+		}
+		svc.Host = strings.TrimSuffix(svc.Host, ".")
+		return svc, true
+	}
+	return discoveredService{}, false
+}
+
+// dnsName normalizes name to lower case with a trailing dot.
+func dnsName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// dnsQuery builds a query for name of type t, asking for a unicast
+// response.
+func dnsQuery(name string, t uint16) []byte {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	msg = appendDNSName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, t)
+	return binary.BigEndian.AppendUint16(msg, dnsClassIN|dnsUnicastResponse)
+}
+
+// appendDNSName appends name, which ends with a dot, as uncompressed labels.
+func appendDNSName(msg []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// errBadDNSMessage is returned for truncated or malformed DNS messages.
+var errBadDNSMessage = errors.New("malformed DNS message")
+
+// parseDNSMessage returns the answer, authority, and additional records of
+// a DNS response.
+func parseDNSMessage(msg []byte) ([]dnsRecord, error) {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil, errBadDNSMessage
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for range qdcount {
+		_, next, err := readDNSName(msg, off)
+		if err != nil || next+4 > len(msg) {
+			return nil, errBadDNSMessage
+		}
+		off = next + 4
+	}
+
+	records := make([]dnsRecord, 0, rrcount)
+	for range rrcount {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return nil, errBadDNSMessage
+		}
+		rr := dnsRecord{Name: name, Type: binary.BigEndian.Uint16(msg[next:])}
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		end := start + rdlen
+		if end > len(msg) {
+			return nil, errBadDNSMessage
+		}
+		rdata := msg[start:end]
+
+		switch rr.Type {
+		case dnsTypeA, dnsTypeAAAA:
+			if len(rdata) == net.IPv4len || len(rdata) == net.IPv6len {
+				rr.IP = net.IP(append([]byte(nil), rdata...))
+			}
+		case dnsTypePTR:
+			rr.Target, _, err = readDNSName(msg, start)
+		case dnsTypeSRV:
+			if rdlen < 7 {
+				return nil, errBadDNSMessage
+			}
+			rr.Port = binary.BigEndian.Uint16(rdata[4:])
+			rr.Target, _, err = readDNSName(msg, start+6)
+		case dnsTypeTXT:
+			for i := 0; i < len(rdata); {
+				n := int(rdata[i])
+				if i+1+n > len(rdata) {
+					return nil, errBadDNSMessage
+				}
+				if n > 0 {
+					rr.TXT = append(rr.TXT, string(rdata[i+1:i+1+n]))
+				}
+				i += 1 + n
+			}
+		}
+		if err != nil {
+			return nil, errBadDNSMessage
+		}
+		records = append(records, rr)
+		off = end
+	}
+	return records, nil
+}
+
+// readDNSName reads the possibly compressed name at off and returns it in
+// lower case with a trailing dot, along with the offset following it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var sb strings.Builder
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errBadDNSMessage
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if sb.Len() == 0 {
+				sb.WriteByte('.')
+			}
+			return strings.ToLower(sb.String()), next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errBadDNSMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errBadDNSMessage
+			}
+			sb.Write(msg[off+1 : off+1+n])
+			sb.WriteByte('.')
+			off += 1 + n
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package tempotown
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"charm.land/fantasy"
+)
+
+const (
+	// EnsembleToolName is the name of the tool for interacting with the
+	// rest of the ensemble.
+	EnsembleToolName = "tempotown"
+
+	// EnsembleDescription is the ensemble tool description shown to the LLM.
+	EnsembleDescription = `Interact with the Tempotown ensemble of agents you are part of.
+
+<usage>
+- action "feedback": fetch feedback and signals waiting for you
+- action "members": list the agents in the ensemble with their roles and status
+- action "complete": report a task as done; put a summary or JSON object in result
+- action "request_review": ask another agent to review your work; set reviewer to an agent ID or role (default "reviewer") and explain what to look at in message
+- action "claim": claim a resource, such as a file or directory path, so other agents know not to change it; fails with the holder when another agent has it
+- action "release": release a resource you claimed; claims are also released when your task is completed or failed
+task_id defaults to the task you accepted last.
+</usage>
+
+<example>
+tempotown(action="members")
+tempotown(action="request_review", reviewer="reviewer", message="Please check the parser changes.")
+tempotown(action="claim", resource="internal/parser")
+</example>
+`
+)
+
+// Ensemble tool actions.
+const (
+	EnsembleFeedback      = "feedback"
+	EnsembleMembers       = "members"
+	EnsembleComplete      = "complete"
+	EnsembleRequestReview = "request_review"
+	EnsembleClaim         = "claim"
+	EnsembleRelease       = "release"
+)
+
+// DefaultReviewer is who reviews are requested from when no reviewer is
+// given.
+const DefaultReviewer = "reviewer"
+
+// EnsembleParams defines the parameters for the ensemble tool.
+type EnsembleParams struct {
+	Action   string `json:"action" jsonschema:"description=What to do: feedback, members, complete, request_review, claim, or release"`
+	TaskID   string `json:"task_id,omitempty" jsonschema:"description=The task ID (defaults to the task accepted last)"`
+	Result   string `json:"result,omitempty" jsonschema:"description=For complete, a summary or JSON object describing the result"`
+	Reviewer string `json:"reviewer,omitempty" jsonschema:"description=For request_review, the agent ID or role to ask (default: reviewer)"`
+	Message  string `json:"message,omitempty" jsonschema:"description=For request_review, what the reviewer should look at"`
+	Resource string `json:"resource,omitempty" jsonschema:"description=For claim and release, the file or directory path"`
+}
+
+// Member is an agent in the ensemble as listed by Tempotown.
+type Member struct {
+	AgentID     string `json:"agent_id"`
+	Role        string `json:"role"`
+	Status      string `json:"status,omitempty"`
+	CurrentTask string `json:"current_task,omitempty"`
+}
+
+// NewEnsembleTool creates the tool through which the LLM takes part in the
+// ensemble.
+func NewEnsembleTool(h *TempotownHook) fantasy.AgentTool {
+	return fantasy.NewAgentTool(
+		EnsembleToolName,
+		EnsembleDescription,
+		func(ctx context.Context, params EnsembleParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
+			var (
+				text string
+				err  error
+			)
+			switch params.Action {
+			case EnsembleFeedback:
+				text, err = h.pendingFeedback(ctx)
+			case EnsembleMembers:
+				text, err = h.members(ctx)
+			case EnsembleComplete:
+				var taskID string
+				if taskID, err = h.updateTask(ctx, TaskComplete, params.TaskID, params.Result); err == nil {
+					text = fmt.Sprintf("Completed task %s.", taskID)
+				}
+			case EnsembleRequestReview:
+				text, err = h.requestReview(ctx, params.TaskID, params.Reviewer, params.Message)
+			case EnsembleClaim:
+				text, err = h.claim(ctx, params.Resource)
+			case EnsembleRelease:
+				text, err = h.release(ctx, params.Resource)
+			default:
+				err = fmt.Errorf("unknown action %q: use feedback, members, complete, request_review, claim, or release", params.Action)
+			}
+			if err != nil {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			return fantasy.NewTextResponse(text), nil
+		},
+	)
+}
+
+// pendingFeedback fetches the feedback waiting for this agent, formatted as
+// for injection.
+func (h *TempotownHook) pendingFeedback(ctx context.Context) (string, error) {
+	if !h.connected.Load() {
+		return "", errNotConnected
+	}
+	fetched, err := h.fetchFeedback(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get_pending_feedback: %w", err)
+	}
+	var items []FeedbackPayload
+	for _, item := range fetched {
+		if !h.handleControl(ctx, item) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "No pending feedback.", nil
+	}
+	return formatFeedback(items), nil
+}
+
+// members lists the agents in the ensemble, one per line, marking this one.
+func (h *TempotownHook) members(ctx context.Context) (string, error) {
+	agents, err := h.listAgents(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(agents) == 0 {
+		return "No agents are registered.", nil
+	}
+
+	agentID := h.AgentID()
+	var sb strings.Builder
+	for _, m := range agents {
+		sb.WriteString(fmt.Sprintf("- %s (%s)", m.AgentID, m.Role))
+		if m.AgentID == agentID {
+			sb.WriteString(" [you]")
+		}
+		if m.Status != "" {
+			sb.WriteString(": " + m.Status)
+		}
+		if m.CurrentTask != "" {
+			sb.WriteString(fmt.Sprintf(", task %s", m.CurrentTask))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// requestReview asks reviewer, an agent ID or role, to review taskID or the
+// current task.
+func (h *TempotownHook) requestReview(ctx context.Context, taskID, reviewer, message string) (string, error) {
+	if !h.connected.Load() {
+		return "", errNotConnected
+	}
+	if taskID == "" {
+		taskID = h.CurrentTask()
+	}
+	if reviewer == "" {
+		reviewer = DefaultReviewer
+	}
+	args := map[string]any{
+		"agent_id": h.AgentID(),
+		"reviewer": reviewer,
+		"message":  strings.TrimSpace(message),
+	}
+	if taskID != "" {
+		args["task_id"] = taskID
+	}
+	if _, err := h.callTool(ctx, "request_review", args); err != nil {
+		return "", fmt.Errorf("request_review: %w", err)
+	}
+	h.logger.Info("review requested", "reviewer", reviewer, "task_id", taskID)
+	if taskID == "" {
+		return fmt.Sprintf("Requested a review from %s.", reviewer), nil
+	}
+	return fmt.Sprintf("Requested a review of task %s from %s.", taskID, reviewer), nil
+}
+
+// claim claims resource for this agent, failing with its holder when
+// another agent has it.
+func (h *TempotownHook) claim(ctx context.Context, resource string) (string, error) {
+	resource = strings.TrimSpace(resource)
+	if resource == "" {
+		return "", fmt.Errorf("resource is required")
+	}
+	resource = h.relativePath(resource)
+	claim, err := h.ClaimResource(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	if !claim.Claimed {
+		return "", fmt.Errorf("%s is claimed by %s; coordinate with them before changing it", resource, cmp.Or(claim.Holder, "another agent"))
+	}
+	return fmt.Sprintf("Claimed %s.", resource), nil
+}
+
+// release releases a claim on resource.
+func (h *TempotownHook) release(ctx context.Context, resource string) (string, error) {
+	resource = strings.TrimSpace(resource)
+	if resource == "" {
+		return "", fmt.Errorf("resource is required")
+	}
+	resource = h.relativePath(resource)
+	if err := h.ReleaseResource(ctx, resource); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Released %s.", resource), nil
+}
@@ -0,0 +1,281 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// EnsembleStatusToolName is the name of the tool showing the ensemble
+	// as a table.
+	EnsembleStatusToolName = "ensemble_status"
+
+	// EnsembleStatusDescription is the ensemble status tool description
+	// shown to the LLM.
+	EnsembleStatusDescription = `Show what the Tempotown ensemble is doing: every registered agent with its role, status, and current task, as a Markdown table.
+
+<usage>
+- role: only list agents with this role (optional)
+Show the table to the user as is when they ask what the other agents are doing.
+</usage>
+
+<example>
+ensemble_status()
+ensemble_status(role="reviewer")
+</example>
+`
+
+	// EnsembleDialogID is the identifier for the ensemble status dialog.
+	EnsembleDialogID = "tempotown-ensemble"
+)
+
+// EnsembleStatusParams defines the parameters for the ensemble status tool.
+type EnsembleStatusParams struct {
+	Role string `json:"role,omitempty" jsonschema:"description=Only list agents with this role"`
+}
+
+// NewEnsembleStatusTool creates the tool that renders the ensemble as a
+// table.
+func NewEnsembleStatusTool(h *TempotownHook) fantasy.AgentTool {
+	return fantasy.NewAgentTool(
+		EnsembleStatusToolName,
+		EnsembleStatusDescription,
+		func(ctx context.Context, params EnsembleStatusParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
+			members, err := h.listAgents(ctx)
+			if err != nil {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			return fantasy.NewTextResponse(h.ensembleStatus(members, params.Role)), nil
+		},
+	)
+}
+
+// listAgents fetches the agents registered with Tempotown.
+func (h *TempotownHook) listAgents(ctx context.Context) ([]Member, error) {
+	if !h.connected.Load() {
+		return nil, errNotConnected
+	}
+	result, err := h.callTool(ctx, "list_agents", map[string]any{})
+	if err != nil {
+		return nil, fmt.Errorf("list_agents: %w", err)
+	}
+	var list struct {
+		Agents []Member `json:"agents"`
+	}
+	if err := json.Unmarshal([]byte(result), &list); err != nil {
+		return nil, fmt.Errorf("list_agents: unmarshal result: %w", err)
+	}
+	return list.Agents, nil
+}
+
+// ensembleStatus renders the members with role, or all when role is "", as
+// a table, marking this agent.
+func (h *TempotownHook) ensembleStatus(members []Member, role string) string {
+	rows := [][]string{{"Agent", "Role", "Status", "Task"}}
+	agentID := h.AgentID()
+	for _, m := range members {
+		if role != "" && !strings.EqualFold(m.Role, role) {
+			continue
+		}
+		agent := m.AgentID
+		if agent == agentID {
+			agent += " (you)"
+		}
+		rows = append(rows, []string{agent, m.Role, orDash(m.Status), orDash(m.CurrentTask)})
+	}
+	if len(rows) == 1 {
+		if role != "" {
+			return fmt.Sprintf("No %s agents are registered.", role)
+		}
+		return "No agents are registered."
+	}
+	return markdownTable(rows)
+}
+
+// orDash returns s, or "-" for an empty cell.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// markdownTable renders rows, the first being the header, as a Markdown
+// table with padded columns, so that it also reads as plain text.
+func markdownTable(rows [][]string) string {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = strings.ReplaceAll(cell, "|", `\|`)
+			widths[i] = max(widths[i], len(row[i]))
+		}
+	}
+
+	var sb strings.Builder
+	line := func(cells []string) {
+		sb.WriteString("|")
+		for i, cell := range cells {
+			sb.WriteString(" " + cell + strings.Repeat(" ", widths[i]-len(cell)) + " |")
+		}
+		sb.WriteString("\n")
+	}
+	line(rows[0])
+	sep := make([]string, len(widths))
+	for i, w := range widths {
+		sep[i] = strings.Repeat("-", w)
+	}
+	line(sep)
+	for _, row := range rows[1:] {
+		line(row)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// EnsembleDialog shows the ensemble table to the user driving this Crush
+// instance.
+type EnsembleDialog struct {
+	hook   *TempotownHook
+	width  int
+	height int
+
+	// table or err is the outcome of the last fetch, set from the
+	// goroutine running it.
+	mu      sync.Mutex
+	loading bool
+	table   string
+	err     error
+	fetched time.Time
+}
+
+// NewEnsembleDialog creates a new ensemble status dialog.
+func NewEnsembleDialog(app *plugin.App) (plugin.PluginDialog, error) {
+	hook, err := loadHook(app)
+	if err != nil {
+		return nil, err
+	}
+	if hook == nil {
+		return nil, fmt.Errorf("tempotown is not configured: set an endpoint in options.plugins.tempotown")
+	}
+	return newEnsembleDialog(hook), nil
+}
+
+func newEnsembleDialog(hook *TempotownHook) *EnsembleDialog {
+	return &EnsembleDialog{
+		hook:   hook,
+		width:  dialogWidth,
+		height: dialogHeight,
+	}
+}
+
+func (d *EnsembleDialog) ID() string {
+	return EnsembleDialogID
+}
+
+func (d *EnsembleDialog) Title() string {
+	return "Tempotown Ensemble"
+}
+
+func (d *EnsembleDialog) Init() error {
+	d.refresh()
+	return nil
+}
+
+func (d *EnsembleDialog) Update(event plugin.DialogEvent) (done bool, action plugin.PluginAction, err error) {
+	switch e := event.(type) {
+	case plugin.KeyEvent:
+		switch e.Key {
+		case "r":
+			d.refresh()
+		case "esc", "q", "enter":
+			return true, plugin.NoAction{}, nil
+		}
+	case plugin.ResizeEvent:
+		d.width = min(dialogWidth, e.Width-10)
+		d.height = min(dialogHeight, e.Height-6)
+	}
+	return false, plugin.NoAction{}, nil
+}
+
+// refresh fetches the ensemble in the background, since the call can take
+// as long as the request timeout.
+func (d *EnsembleDialog) refresh() {
+	d.mu.Lock()
+	if d.loading {
+		d.mu.Unlock()
+		return
+	}
+	d.loading = true
+	d.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		members, err := d.hook.listAgents(ctx)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.loading = false
+		d.err = err
+		if err == nil {
+			d.table = d.hook.ensembleStatus(members, "")
+			d.fetched = time.Now()
+		}
+	}()
+}
+
+func (d *EnsembleDialog) View() string {
+	d.mu.Lock()
+	loading, table, err, fetched := d.loading, d.table, d.err, d.fetched
+	d.mu.Unlock()
+
+	var sb strings.Builder
+	switch {
+	case err != nil:
+		sb.WriteString(truncate("Error: "+err.Error(), d.width-4) + "\n")
+	case table == "" && loading:
+		sb.WriteString("Loading...\n")
+	case table != "":
+		lines := strings.Split(table, "\n")
+		for i, line := range lines {
+			if i >= d.height-6 {
+				sb.WriteString(fmt.Sprintf("... %d more\n", len(lines)-i))
+				break
+			}
+			sb.WriteString(truncate(line, d.width-4) + "\n")
+		}
+		sb.WriteString(fmt.Sprintf("\nUpdated %s\n", fetched.Format("15:04:05")))
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("─", d.width-4) + "\n")
+	sb.WriteString("r: Refresh  Esc: Close")
+	return sb.String()
+}
+
+func (d *EnsembleDialog) Size() (width, height int) {
+	return d.width, d.height
+}
+
+func init() {
+	plugin.RegisterDialog(EnsembleDialogID, func(app *plugin.App) (plugin.PluginDialog, error) {
+		return NewEnsembleDialog(app)
+	})
+
+	plugin.RegisterCommand(
+		plugin.PluginCommand{
+			ID:          "tempotown-ensemble",
+			Title:       "Tempotown Ensemble",
+			Description: "Show the agents in the Tempotown ensemble and what they are working on",
+		},
+		func(cmd plugin.PluginCommand) plugin.PluginAction {
+			return plugin.OpenDialogAction{DialogID: EnsembleDialogID}
+		},
+	)
+}
@@ -0,0 +1,144 @@
+package tempotown
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// FeedbackQuietPeriod is how long no messages must have been seen before
+// the agent is considered idle and feedback is submitted to it.
+const FeedbackQuietPeriod = 3 * time.Second
+
+// feedbackPrefix starts every prompt made from Tempotown feedback.
+const feedbackPrefix = "[Tempotown]"
+
+// injectFeedback returns whether feedback is submitted to the model.
+func (h *TempotownHook) injectFeedback() bool {
+	return h.cfg.InjectFeedback == nil || *h.cfg.InjectFeedback
+}
+
+// noteActivity records a message event, which marks the agent busy for the
+// quiet period, and the session it belongs to. The first session seen while
+// a task is current is remembered as the task's session.
+func (h *TempotownHook) noteActivity(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastActivity = time.Now()
+	if sessionID == "" {
+		return
+	}
+	h.sessionID = sessionID
+	if h.currentTask != "" && h.taskSessions[h.currentTask] == "" {
+		h.taskSessions[h.currentTask] = sessionID
+	}
+}
+
+// idle reports whether the agent is not paused and no messages have been
+// seen for the quiet period, and returns the session they were last seen in.
+func (h *TempotownHook) idle() (string, bool) {
+	paused := h.control.Paused()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessionID, !paused && time.Since(h.lastActivity) >= h.quietPeriod
+}
+
+// feedbackLoop submits feedback from Tempotown as prompts until ctx is
+// cancelled. Feedback arriving while the agent is busy or paused waits until
+// it is idle and is then submitted together in one prompt.
+func (h *TempotownHook) feedbackLoop(ctx context.Context, submitter plugin.PromptSubmitter) {
+	for {
+		var items []FeedbackPayload
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-h.feedbackCh:
+			items = append(items, item)
+		}
+
+		sessionID, ok := h.waitIdle(ctx)
+		if !ok {
+			return
+		}
+		for drained := false; !drained; {
+			select {
+			case item := <-h.feedbackCh:
+				items = append(items, item)
+			default:
+				drained = true
+			}
+		}
+		h.deliverFeedback(ctx, submitter, sessionID, items)
+	}
+}
+
+// waitIdle blocks until the agent is idle and returns its session, or
+// returns false when ctx is cancelled first.
+func (h *TempotownHook) waitIdle(ctx context.Context) (string, bool) {
+	ticker := time.NewTicker(h.quietPeriod / 6)
+	defer ticker.Stop()
+	for {
+		if sessionID, ok := h.idle(); ok {
+			return sessionID, true
+		}
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-ticker.C:
+		}
+	}
+}
+
+// submitFeedback sends items to sessionID, or to a new session when it is
+// empty.
+func (h *TempotownHook) submitFeedback(ctx context.Context, submitter plugin.PromptSubmitter, sessionID string, items []FeedbackPayload) {
+	source, taskID := feedbackOrigin(items)
+	if err := h.submitPrompt(ctx, submitter, sessionID, formatFeedback(items), source, taskID); err != nil {
+		h.logger.Warn("failed to submit feedback", "items", len(items), "session_id", sessionID, "error", err)
+		return
+	}
+	h.logger.Info("submitted Tempotown feedback", "items", len(items), "session_id", sessionID)
+}
+
+// feedbackOrigin returns who sent items, their sources joined by commas, and
+// the task they are about when they agree on one.
+func feedbackOrigin(items []FeedbackPayload) (source, taskID string) {
+	var sources []string
+	for i, item := range items {
+		if item.Source != "" && !slices.Contains(sources, item.Source) {
+			sources = append(sources, item.Source)
+		}
+		if i == 0 {
+			taskID = item.TaskID
+		} else if item.TaskID != taskID {
+			taskID = ""
+		}
+	}
+	return strings.Join(sources, ","), taskID
+}
+
+// formatFeedback renders feedback as a prompt, one paragraph per item
+// headed by its source and task.
+func formatFeedback(items []FeedbackPayload) string {
+	var sb strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		source := item.Source
+		if source == "" {
+			source = "Tempotown"
+		}
+		sb.WriteString(fmt.Sprintf("%s Feedback from %s", feedbackPrefix, source))
+		if item.TaskID != "" {
+			sb.WriteString(fmt.Sprintf(" on task %s", item.TaskID))
+		}
+		sb.WriteString(":\n")
+		sb.WriteString(strings.TrimSpace(item.Message))
+	}
+	return sb.String()
+}
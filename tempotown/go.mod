@@ -3,13 +3,14 @@ module github.com/aleksclark/crush-modules/tempotown
 go 1.26.2
 
 require (
+	charm.land/fantasy v0.20.0
 	github.com/aleksclark/crush-modules v0.0.0-00010101000000-000000000000
 	github.com/charmbracelet/crush v0.0.0
+	github.com/coder/websocket v1.8.13
 	github.com/stretchr/testify v1.11.1
 )
 
 require (
-	charm.land/fantasy v0.20.0 // indirect
 	github.com/charmbracelet/colorprofile v0.4.3 // indirect
 	github.com/charmbracelet/ultraviolet v0.0.0-20260416155717-489999b90468 // indirect
 	github.com/charmbracelet/x/ansi v0.11.7 // indirect
@@ -0,0 +1,66 @@
+package tempotown
+
+import (
+	"context"
+
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// InitiatedByUser is the initiated_by of work started by a message the user
+// typed. Work started by a prompt a plugin submitted has the plugin's name,
+// such as "tempotown" for feedback and work-queue tasks.
+const InitiatedByUser = "user"
+
+// publishLink tells other plugins, such as agent-status, who the agent is to
+// Tempotown: its agent ID, role, and current task while connected, and
+// nothing otherwise.
+func (h *TempotownHook) publishLink() {
+	if !h.connected.Load() {
+		h.link.SetOrchestrator(agentlink.Orchestrator{})
+		return
+	}
+	h.mu.Lock()
+	o := agentlink.Orchestrator{
+		Name:    HookName,
+		AgentID: h.agentID,
+		Role:    h.role,
+		TaskID:  h.currentTask,
+	}
+	h.mu.Unlock()
+	h.link.SetOrchestrator(o)
+}
+
+// handleLinkStatus reports the agent waiting or failing, as another plugin
+// such as agent-status sees it, to Tempotown.
+func (h *TempotownHook) handleLinkStatus(s agentlink.Status) {
+	switch s.Status {
+	case "waiting":
+		h.reportStatus("waiting", 0, nil)
+	case "error":
+		h.reportStatus("error", 0, map[string]any{"error": s.Error})
+	}
+}
+
+// submitPrompt notes the prompt as coming from Tempotown on behalf of
+// source, so the resulting user message can be told from the user's, and
+// submits it to sessionID, or the current session when it is "".
+func (h *TempotownHook) submitPrompt(ctx context.Context, submitter plugin.PromptSubmitter, sessionID, prompt, source, taskID string) error {
+	h.link.NotePrompt(prompt, agentlink.Prompt{Origin: HookName, Source: source, TaskID: taskID})
+	if sessionID != "" {
+		return submitter.SubmitPromptToSession(ctx, sessionID, prompt)
+	}
+	return submitter.SubmitPrompt(ctx, prompt)
+}
+
+// notePrompt records who initiated the work started by a user message:
+// the user, or the plugin that submitted it.
+func (h *TempotownHook) notePrompt(content string) {
+	origin, ok := h.link.PromptOrigin(content)
+	if !ok {
+		origin = agentlink.Prompt{Origin: InitiatedByUser}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prompt = origin
+}
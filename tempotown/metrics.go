@@ -0,0 +1,51 @@
+package tempotown
+
+import (
+	"errors"
+	"time"
+)
+
+// Connection health metrics, exported by the otlp plugin when it is
+// enabled.
+const (
+	// metricConnectAttempts counts connection attempts by outcome.
+	metricConnectAttempts = "tempotown.connect.attempts"
+	// metricReconnects counts connections re-established after one was
+	// lost or failed.
+	metricReconnects = "tempotown.reconnects"
+	// metricRPCDuration is the round-trip time of requests by method, or
+	// tool name for tool calls, and outcome.
+	metricRPCDuration = "tempotown.rpc.duration"
+	// metricFeedbackReceived counts feedback items by outcome: accepted,
+	// rejected for failing payload security, or malformed.
+	metricFeedbackReceived = "tempotown.feedback.received"
+	// metricStatusReports counts report_status calls by status and outcome.
+	metricStatusReports = "tempotown.status.reports"
+)
+
+// outcome describes how an operation ended for metric attributes.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errRequestTimeout):
+		return "timeout"
+	default:
+		return "failure"
+	}
+}
+
+// countConnect records a connection attempt. Successful attempts after an
+// earlier connection count as reconnects.
+func (h *TempotownHook) countConnect(err error, reconnect bool) {
+	h.meter.Add(metricConnectAttempts, 1, map[string]string{"outcome": outcome(err)})
+	if err == nil && reconnect {
+		h.meter.Add(metricReconnects, 1, nil)
+	}
+}
+
+// observeRPC records how long a request for method took.
+func (h *TempotownHook) observeRPC(method string, started time.Time, err error) {
+	ms := float64(time.Since(started).Microseconds()) / 1000
+	h.meter.Observe(metricRPCDuration, "ms", ms, map[string]string{"method": method, "outcome": outcome(err)})
+}
@@ -0,0 +1,99 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	// FeedbackNotification is the method of the notifications in which a
+	// server pushes feedback, with a FeedbackPayload or {"items": [...]} as
+	// params.
+	FeedbackNotification = "notifications/feedback"
+
+	// FeedbackPushCapability is the experimental server capability that
+	// announces feedback is pushed rather than polled.
+	FeedbackPushCapability = "feedbackNotifications"
+)
+
+// incoming is any JSON-RPC message read from the server: a response, a
+// notification, or a request.
+type incoming struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      interface{}     `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// handleIncoming routes a message from the server.
+func (h *TempotownHook) handleIncoming(ctx context.Context, msg *incoming) {
+	switch {
+	case msg.Method == "":
+		h.handleResponse(&Response{JSONRPC: msg.JSONRPC, ID: msg.ID, Result: msg.Result, Error: msg.Error})
+	case msg.ID == nil:
+		h.handleNotification(ctx, msg.Method, msg.Params)
+	default:
+		h.handleRequest(msg.ID, msg.Method)
+	}
+}
+
+// handleResponse passes a response to the caller waiting for it.
+func (h *TempotownHook) handleResponse(resp *Response) {
+	id, ok := resp.ID.(float64)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if call, exists := h.pending[int64(id)]; exists {
+		call.ch <- resp
+		delete(h.pending, int64(id))
+	}
+}
+
+// handleNotification handles a notification from the server. Pushed
+// feedback is queued like polled feedback; others are ignored.
+func (h *TempotownHook) handleNotification(ctx context.Context, method string, params json.RawMessage) {
+	if method != FeedbackNotification {
+		h.logger.Debug("ignoring notification", "method", method)
+		return
+	}
+
+	var batch struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(params, &batch); err != nil || batch.Items == nil {
+		batch.Items = []json.RawMessage{params}
+	}
+	h.queueFeedback(ctx, h.openFeedback(batch.Items))
+}
+
+// handleRequest answers a request from the server: ping is acknowledged and
+// anything else is not supported.
+func (h *TempotownHook) handleRequest(id any, method string) {
+	resp := Response{JSONRPC: "2.0", ID: id}
+	if method == "ping" {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &Error{Code: -32601, Message: "method not found"}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.encoder != nil {
+		_ = h.send(resp)
+	}
+}
+
+// pushesFeedback reports whether the server's capabilities announce pushed
+// feedback.
+func pushesFeedback(result json.RawMessage) bool {
+	var init InitializeResult
+	if err := json.Unmarshal(result, &init); err != nil {
+		return false
+	}
+	_, ok := init.Capabilities.Experimental[FeedbackPushCapability]
+	return ok
+}
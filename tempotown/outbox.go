@@ -0,0 +1,80 @@
+package tempotown
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// maxQueuedResults bounds the task results waiting for a connection; the
+// oldest is dropped when it is exceeded.
+const maxQueuedResults = 16
+
+// queuedResult is a task result recorded while disconnected.
+type queuedResult struct {
+	tool   string // complete_task or fail_task
+	taskID string
+	extra  map[string]any
+	// then runs once the result is sent, e.g. to upload the task's
+	// artifacts.
+	then func(context.Context)
+}
+
+// isDisconnect reports whether err means a request never reached
+// Tempotown, or its answer was lost with the connection.
+func isDisconnect(err error) bool {
+	return errors.Is(err, errNotConnected) || errors.Is(err, errConnectionLost)
+}
+
+// queueResult keeps a task result to send once connected again. A newer
+// result for the same task replaces the older one.
+func (h *TempotownHook) queueResult(r queuedResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.results = slices.DeleteFunc(h.results, func(q queuedResult) bool { return q.taskID == r.taskID })
+	h.results = append(h.results, r)
+	if len(h.results) > maxQueuedResults {
+		h.logger.Warn("task result queue full, dropping oldest", "task_id", h.results[0].taskID)
+		h.results = h.results[len(h.results)-maxQueuedResults:]
+	}
+	h.logger.Info("Tempotown unreachable, task result queued", "call", r.tool, "task_id", r.taskID)
+}
+
+// flushResults sends the task results queued while disconnected, oldest
+// first. It stops when the connection is gone again, keeping what is left;
+// results Tempotown rejects are dropped.
+func (h *TempotownHook) flushResults(ctx context.Context) {
+	for {
+		h.mu.Lock()
+		if len(h.results) == 0 {
+			h.mu.Unlock()
+			return
+		}
+		r := h.results[0]
+		h.results = h.results[1:]
+		h.mu.Unlock()
+
+		err := h.callTaskTool(ctx, r.tool, r.taskID, r.extra)
+		if isDisconnect(err) {
+			h.mu.Lock()
+			if !slices.ContainsFunc(h.results, func(q queuedResult) bool { return q.taskID == r.taskID }) {
+				h.results = slices.Insert(h.results, 0, r)
+			}
+			h.mu.Unlock()
+			return
+		}
+		if err != nil {
+			h.logger.Warn("failed to send queued task result", "call", r.tool, "task_id", r.taskID, "error", err)
+			continue
+		}
+		if r.then != nil {
+			r.then(ctx)
+		}
+	}
+}
+
+// queuedCount returns how many status reports and task results are waiting
+// to be sent. The caller holds mu.
+func (h *TempotownHook) queuedCount() int {
+	return len(h.statusQueue) + len(h.results)
+}
@@ -0,0 +1,43 @@
+package tempotown
+
+import (
+	"context"
+	"strings"
+)
+
+// Feedback types that pause and resume the agent instead of carrying a
+// message for it.
+const (
+	FeedbackPause  = "pause"
+	FeedbackResume = "resume"
+)
+
+// handleControl pauses or resumes the agent for a pause or resume item and
+// reports whether item was one. While paused, feedback is held back and
+// plugins sharing the switch skip their scheduled work.
+func (h *TempotownHook) handleControl(ctx context.Context, item FeedbackPayload) bool {
+	switch item.Type {
+	case FeedbackPause:
+		reason := strings.TrimSpace(item.Message)
+		if reason == "" && item.Source != "" {
+			reason = "paused by " + item.Source
+		}
+		if h.control.Pause(reason) {
+			h.logger.Info("paused by Tempotown", "source", item.Source, "reason", reason)
+			h.reportStatus("paused", 0, map[string]any{"reason": reason})
+		}
+	case FeedbackResume:
+		if h.control.Resume() {
+			h.logger.Info("resumed by Tempotown", "source", item.Source)
+			h.reportStatus("resumed", 0, nil)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// Paused reports whether the agent is paused.
+func (h *TempotownHook) Paused() bool {
+	return h.control.Paused()
+}
@@ -0,0 +1,14 @@
+//go:build !crushnext
+
+package tempotown
+
+// permissionBrokers reports whether this build can register a permission
+// broker, which remote approval and the claim guard need. The published
+// plugin API has none, so both are unavailable.
+const permissionBrokers = false
+
+// registeredTools returns the tools of this plugin, as the published plugin
+// API cannot list those of others.
+func registeredTools() []string {
+	return []string{TaskToolName, EnsembleToolName, EnsembleStatusToolName}
+}
@@ -0,0 +1,43 @@
+//go:build crushnext
+
+package tempotown
+
+import (
+	"context"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// permissionBrokers reports whether this build can register a permission
+// broker, which remote approval and the claim guard need.
+const permissionBrokers = true
+
+func init() {
+	plugin.RegisterPermissionBrokerWithConfig(HookName, func(ctx context.Context, app *plugin.App) (plugin.PermissionBroker, error) {
+		hook, err := loadHook(app)
+		if err != nil {
+			return nil, err
+		}
+		if hook == nil || (!hook.cfg.Approval.Enabled && !hook.claimGuard()) {
+			// Permission requests stay local.
+			return nil, nil
+		}
+		return hook, nil
+	}, &Config{})
+}
+
+// RequestPermission implements plugin.PermissionBroker.
+func (h *TempotownHook) RequestPermission(ctx context.Context, req plugin.PermissionRequest) (bool, error) {
+	return h.decidePermission(ctx, permissionRequest{
+		SessionID:   req.SessionID,
+		ToolName:    req.ToolName,
+		Action:      req.Action,
+		Description: req.Description,
+		Path:        req.Path,
+	})
+}
+
+// registeredTools returns the names of the tools registered by plugins.
+func registeredTools() []string {
+	return plugin.RegisteredTools()
+}
@@ -0,0 +1,18 @@
+//go:build !crushnext
+
+package tempotown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionBrokersUnsupported(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewTempotownHook(nil, Config{Endpoint: "localhost:9090", ClaimGuard: ClaimGuardBlock})
+	require.ErrorContains(t, err, "not supported by this Crush build")
+	_, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", Approval: ApprovalConfig{Enabled: true}})
+	require.ErrorContains(t, err, "not supported by this Crush build")
+}
@@ -0,0 +1,218 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultStatusInterval is the least time between two activity reports.
+	DefaultStatusInterval = time.Second
+
+	// maxQueuedStatus bounds the status reports waiting to be sent; the
+	// oldest is dropped when it is exceeded.
+	maxQueuedStatus = 32
+)
+
+// statusUpdate is a status report waiting to be sent.
+type statusUpdate struct {
+	status   string
+	progress int
+	details  map[string]any
+	// queuedAt is when the update was queued while disconnected, sent
+	// along as queued_at once connected again.
+	queuedAt time.Time
+}
+
+// key identifies the update for change detection.
+func (u statusUpdate) key() string {
+	details, _ := json.Marshal(u.details)
+	return fmt.Sprintf("%s\x00%d\x00%s", u.status, u.progress, details)
+}
+
+// reportStatus queues a status update for Tempotown. Every update queued
+// this way is sent, in order; use reportActivity for frequent ones. details
+// are sent along with the status context. While disconnected, updates are
+// kept for the next connection, an update replacing the last one queued
+// when it has the same status.
+func (h *TempotownHook) reportStatus(status string, progress int, details map[string]any) {
+	update := statusUpdate{status: status, progress: progress, details: details}
+	h.mu.Lock()
+	if !h.connected.Load() {
+		update.queuedAt = time.Now()
+		if n := len(h.statusQueue); n > 0 && h.statusQueue[n-1].status == status {
+			h.statusQueue = h.statusQueue[:n-1]
+		}
+	}
+	h.statusQueue = append(h.statusQueue, update)
+	if len(h.statusQueue) > maxQueuedStatus {
+		h.statusQueue = h.statusQueue[len(h.statusQueue)-maxQueuedStatus:]
+		h.logger.Debug("status queue full, dropping oldest report")
+	}
+	h.mu.Unlock()
+	h.wakeStatus()
+}
+
+// reportActivity records what the agent is doing, as seen in message
+// events. Activity is coalesced: only the latest update is sent, at most
+// once per status interval, and only when it differs from the last one
+// sent. The latest update made while disconnected is sent on reconnect.
+func (h *TempotownHook) reportActivity(status string, progress int, details map[string]any) {
+	h.mu.Lock()
+	h.statusActivity = &statusUpdate{status: status, progress: progress, details: details}
+	h.mu.Unlock()
+	h.wakeStatus()
+}
+
+// wakeStatus tells the status loop that there is something to send.
+func (h *TempotownHook) wakeStatus() {
+	select {
+	case h.statusWake <- struct{}{}:
+	default:
+	}
+}
+
+// statusLoop sends status reports one at a time until ctx is cancelled or
+// done is closed, so reporting never has more than one call in flight.
+func (h *TempotownHook) statusLoop(ctx context.Context, done <-chan struct{}) {
+	var (
+		lastActivity time.Time
+		timer        *time.Timer
+		timerC       <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	// Report the current activity on a new connection even if unchanged,
+	// after what was queued while disconnected.
+	h.mu.Lock()
+	h.activityKey = ""
+	h.mu.Unlock()
+	h.wakeStatus()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-h.statusWake:
+		case <-timerC:
+			timerC = nil
+		}
+
+		for {
+			update, ok := h.nextQueuedStatus()
+			if !ok {
+				break
+			}
+			if err := h.sendStatus(ctx, update); isDisconnect(err) {
+				h.requeueStatus(update)
+				return
+			}
+		}
+
+		if timerC != nil || !h.activityPending() {
+			continue
+		}
+		if wait := h.statusInterval - time.Since(lastActivity); wait > 0 {
+			timer = time.NewTimer(wait)
+			timerC = timer.C
+			continue
+		}
+		if update, ok := h.takeActivity(); ok {
+			_ = h.sendStatus(ctx, update)
+			lastActivity = time.Now()
+		}
+	}
+}
+
+// nextQueuedStatus removes and returns the oldest queued update.
+func (h *TempotownHook) nextQueuedStatus() (statusUpdate, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.statusQueue) == 0 {
+		return statusUpdate{}, false
+	}
+	update := h.statusQueue[0]
+	h.statusQueue = h.statusQueue[1:]
+	return update, true
+}
+
+// requeueStatus puts back an update that could not be sent for the next
+// connection.
+func (h *TempotownHook) requeueStatus(update statusUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if update.queuedAt.IsZero() {
+		update.queuedAt = time.Now()
+	}
+	h.statusQueue = slices.Insert(h.statusQueue, 0, update)
+	if len(h.statusQueue) > maxQueuedStatus {
+		h.statusQueue = h.statusQueue[len(h.statusQueue)-maxQueuedStatus:]
+	}
+}
+
+// activityPending reports whether an activity update is waiting.
+func (h *TempotownHook) activityPending() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.statusActivity != nil
+}
+
+// takeActivity removes the waiting activity update and returns it, unless
+// it is the same as the last activity sent.
+func (h *TempotownHook) takeActivity() (statusUpdate, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	update := h.statusActivity
+	h.statusActivity = nil
+	if update == nil {
+		return statusUpdate{}, false
+	}
+	key := update.key()
+	if key == h.activityKey {
+		return statusUpdate{}, false
+	}
+	h.activityKey = key
+	return *update, true
+}
+
+// sendStatus sends update with the status context.
+func (h *TempotownHook) sendStatus(ctx context.Context, update statusUpdate) error {
+	all := h.statusContext()
+	maps.Copy(all, update.details)
+	if !update.queuedAt.IsZero() {
+		all["queued_at"] = update.queuedAt.UTC().Format(time.RFC3339)
+	}
+	args := map[string]any{
+		"status":   update.status,
+		"progress": update.progress,
+		"details":  all,
+	}
+	if h.cfg.PayloadSecurity.enabled() {
+		sealed, err := h.cfg.PayloadSecurity.seal(args, "report_status", time.Now())
+		if err != nil {
+			h.logger.Warn("failed to seal status", "status", update.status, "error", err)
+			return err
+		}
+		args = sealed
+	}
+	_, err := h.callTool(ctx, "report_status", args)
+	h.meter.Add(metricStatusReports, 1, map[string]string{"status": update.status, "outcome": outcome(err)})
+	if err != nil {
+		h.logger.Debug("failed to report status", "status", update.status, "error", err)
+		return err
+	}
+	h.mu.Lock()
+	h.lastStatus = StatusReport{Status: update.status, Progress: update.progress, At: time.Now()}
+	h.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,71 @@
+package tempotown
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+)
+
+// Roles are the agent roles Tempotown assigns work by, in the order the
+// status dialog cycles through them.
+var Roles = []string{"coder", "reviewer", "merger", "supervisor"}
+
+// Role returns the role the agent registers with.
+func (h *TempotownHook) Role() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.role
+}
+
+// configuredCapabilities returns the capabilities configured on top of the
+// detected ones.
+func (h *TempotownHook) configuredCapabilities() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.configuredCaps
+}
+
+// SetRole changes the role and configured capabilities the agent registers
+// with, keeping the role when role is "" and the capabilities when
+// capabilities is nil. While connected the agent registers again, so
+// Tempotown assigns work by the new role; the current task and session are
+// kept. Otherwise the change applies from the next connection.
+func (h *TempotownHook) SetRole(ctx context.Context, role string, capabilities []string) error {
+	h.mu.Lock()
+	if role != "" {
+		h.role = role
+	}
+	if capabilities != nil {
+		h.configuredCaps = slices.Clone(capabilities)
+	}
+	role = h.role
+	h.mu.Unlock()
+
+	h.logger.Info("agent role changed", "role", role, "capabilities", h.capabilities())
+	if !h.connected.Load() {
+		h.publishLink()
+		return nil
+	}
+	return h.Reregister(ctx)
+}
+
+// nextRole returns the role following the current one in Roles, or the
+// first role for a role not in the list.
+func (h *TempotownHook) nextRole() string {
+	i := slices.Index(Roles, h.Role())
+	return Roles[(i+1)%len(Roles)]
+}
+
+// ReloadRole reads the role and capabilities from the tempotown options
+// again and applies them with SetRole.
+func (h *TempotownHook) ReloadRole(ctx context.Context) error {
+	if h.app == nil {
+		return errors.New("no configuration to reload")
+	}
+	var cfg Config
+	if err := h.app.LoadConfig(HookName, &cfg); err != nil {
+		return err
+	}
+	return h.SetRole(ctx, cmp.Or(cfg.Role, DefaultRole), append([]string{}, cfg.Capabilities...))
+}
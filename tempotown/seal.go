@@ -0,0 +1,271 @@
+package tempotown
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// sealVersion is the version of the envelope format.
+	sealVersion = 2
+
+	// sealCipher names the cipher of an encrypted envelope.
+	sealCipher = "aes-256-gcm"
+
+	// maxSealAge is how far the timestamp of sealed feedback may be from
+	// the local clock before it is taken for a replay. Within it, replays
+	// are caught by the envelope ID.
+	maxSealAge = 5 * time.Minute
+)
+
+// PayloadSecurityConfig protects feedback and status payloads end to end,
+// for orchestrator links that cross trust boundaries. With a key set,
+// feedback that is not sealed with it is dropped.
+type PayloadSecurityConfig struct {
+	// SigningKey is a secret shared with Tempotown. Status payloads are
+	// signed and feedback is verified with HMAC-SHA256. Environment
+	// variables are expanded.
+	SigningKey string `json:"signing_key,omitempty"`
+
+	// EncryptionKey is a secret shared with Tempotown. Status payloads are
+	// encrypted and feedback is decrypted with AES-256-GCM, keyed by the
+	// secret's SHA-256. Environment variables are expanded.
+	EncryptionKey string `json:"encryption_key,omitempty"`
+}
+
+// enabled reports whether payloads are sealed.
+func (c PayloadSecurityConfig) enabled() bool {
+	return c.SigningKey != "" || c.EncryptionKey != ""
+}
+
+// envelope is a sealed payload, sent in place of the payload as
+// {"sealed": envelope}. ID is random and unique to the envelope, and Method
+// is what the payload was sealed for: the tool it is the arguments of, or
+// FeedbackNotification for feedback items however they are delivered.
+// Payload is the payload's JSON, or with Cipher set the base64 of a random
+// nonce followed by its ciphertext, which authenticates Timestamp, Method
+// and ID as additional data. Signature is the hex HMAC-SHA256 of
+// Timestamp, Method, ID, and Payload, joined by ".".
+type envelope struct {
+	Version   int    `json:"v"`
+	Timestamp int64  `json:"ts"`
+	ID        string `json:"id"`
+	Method    string `json:"method"`
+	Payload   string `json:"payload"`
+	Cipher    string `json:"enc,omitempty"`
+	Signature string `json:"sig,omitempty"`
+}
+
+var (
+	errUnsealed     = errors.New("payload is not sealed")
+	errBadSignature = errors.New("payload signature does not match")
+	errStale        = errors.New("payload timestamp is too far from now")
+	errReplayed     = errors.New("payload was already received")
+)
+
+// seal wraps v, sealed for method, in an envelope as configured.
+func (c PayloadSecurityConfig) seal(v any, method string, now time.Time) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	env := envelope{
+		Version:   sealVersion,
+		Timestamp: now.Unix(),
+		ID:        hex.EncodeToString(id),
+		Method:    method,
+		Payload:   string(data),
+	}
+	if c.EncryptionKey != "" {
+		gcm, err := newGCM(c.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed := gcm.Seal(nonce, nonce, data, env.sealData())
+		env.Payload = base64.StdEncoding.EncodeToString(sealed)
+		env.Cipher = sealCipher
+	}
+	if c.SigningKey != "" {
+		env.Signature = sign(c.SigningKey, env)
+	}
+	return map[string]any{"sealed": env}, nil
+}
+
+// open returns the payload JSON of raw, which must be sealed for method
+// when a key is set and may be a plain payload otherwise. Envelopes are
+// recorded in seen, and one whose ID was seen before is rejected.
+func (c PayloadSecurityConfig) open(raw json.RawMessage, method string, now time.Time, seen *sealLog) (json.RawMessage, error) {
+	var wrapper struct {
+		Sealed *envelope `json:"sealed"`
+	}
+	_ = json.Unmarshal(raw, &wrapper)
+	env := wrapper.Sealed
+	if env == nil {
+		if c.enabled() {
+			return nil, errUnsealed
+		}
+		return raw, nil
+	}
+
+	if !c.enabled() {
+		if env.Cipher != "" {
+			return nil, fmt.Errorf("payload is encrypted but no encryption_key is set")
+		}
+		return json.RawMessage(env.Payload), nil
+	}
+	data, err := c.verify(env, method, now)
+	if err != nil {
+		return nil, err
+	}
+	if !seen.first(env.ID, time.Unix(env.Timestamp, 0), now) {
+		return nil, errReplayed
+	}
+	return data, nil
+}
+
+// verify checks env against the configured keys and returns its payload
+// JSON.
+func (c PayloadSecurityConfig) verify(env *envelope, method string, now time.Time) (json.RawMessage, error) {
+	if env.Version != sealVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	if c.SigningKey != "" {
+		if !hmac.Equal([]byte(env.Signature), []byte(sign(c.SigningKey, *env))) {
+			return nil, errBadSignature
+		}
+	}
+	if env.ID == "" {
+		return nil, fmt.Errorf("payload has no id")
+	}
+	if env.Method != method {
+		return nil, fmt.Errorf("payload was sealed for %q, not %q", env.Method, method)
+	}
+	if now.Sub(time.Unix(env.Timestamp, 0)).Abs() > maxSealAge {
+		return nil, errStale
+	}
+	if env.Cipher == "" {
+		if c.EncryptionKey != "" {
+			return nil, fmt.Errorf("payload is not encrypted")
+		}
+		return json.RawMessage(env.Payload), nil
+	}
+
+	if env.Cipher != sealCipher {
+		return nil, fmt.Errorf("unsupported cipher %q", env.Cipher)
+	}
+	if c.EncryptionKey == "" {
+		return nil, fmt.Errorf("payload is encrypted but no encryption_key is set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+	gcm, err := newGCM(c.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("payload too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, ciphertext, env.sealData())
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: %w", err)
+	}
+	return data, nil
+}
+
+// newGCM returns AES-256-GCM keyed by the SHA-256 of secret.
+func newGCM(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sign returns the hex HMAC-SHA256 of env's sealed data and payload.
+func sign(secret string, env envelope) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(env.sealData())
+	mac.Write([]byte("."))
+	mac.Write([]byte(env.Payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealData is the timestamp, method and ID bound to a sealed payload.
+func (e envelope) sealData() []byte {
+	return []byte(strconv.FormatInt(e.Timestamp, 10) + "." + e.Method + "." + e.ID)
+}
+
+// sealLog remembers the IDs of opened envelopes while their timestamps are
+// within maxSealAge, after which they are rejected as stale anyway.
+type sealLog struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // ID to when it may be forgotten
+}
+
+// first records id, sealed at ts, and reports whether it had not been
+// seen before.
+func (l *sealLog) first(id string, ts, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for seen, until := range l.seen {
+		if now.After(until) {
+			delete(l.seen, seen)
+		}
+	}
+	if _, ok := l.seen[id]; ok {
+		return false
+	}
+	if l.seen == nil {
+		l.seen = make(map[string]time.Time)
+	}
+	l.seen[id] = ts.Add(maxSealAge)
+	return true
+}
+
+// openFeedback opens feedback items as received, dropping those that are not
+// sealed as configured, were received before, or carry neither a message
+// nor a type.
+func (h *TempotownHook) openFeedback(raw []json.RawMessage) []FeedbackPayload {
+	items := make([]FeedbackPayload, 0, len(raw))
+	now := time.Now()
+	for _, r := range raw {
+		data, err := h.cfg.PayloadSecurity.open(r, FeedbackNotification, now, &h.sealLog)
+		if err != nil {
+			h.logger.Warn("rejected feedback", "error", err)
+			h.meter.Add(metricFeedbackReceived, 1, map[string]string{"outcome": "rejected"})
+			continue
+		}
+		var item FeedbackPayload
+		if err := json.Unmarshal(data, &item); err != nil || (item.Message == "" && item.Type == "") {
+			h.logger.Warn("malformed feedback", "item", string(data))
+			h.meter.Add(metricFeedbackReceived, 1, map[string]string{"outcome": "malformed"})
+			continue
+		}
+		h.meter.Add(metricFeedbackReceived, 1, map[string]string{"outcome": "accepted"})
+		items = append(items, item)
+	}
+	return items
+}
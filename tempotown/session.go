@@ -0,0 +1,161 @@
+package tempotown
+
+import (
+	"context"
+	"strings"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// Feedback types that control which Crush session the agent works in. Like
+// other feedback they are applied in order once the agent is idle.
+const (
+	// FeedbackSwitchSession makes session_id the session that later feedback
+	// goes to.
+	FeedbackSwitchSession = "switch_session"
+	// FeedbackNewSession submits the message as the first prompt of a new
+	// session, which then becomes the current one.
+	FeedbackNewSession = "new_session"
+	// FeedbackSummarize summarizes the session targeted by session_id or
+	// task_id, or the current one.
+	FeedbackSummarize = "summarize"
+)
+
+// summarizePrompt asks the model for a summary when Crush cannot summarize a
+// session itself.
+const summarizePrompt = feedbackPrefix + " Summarize this conversation so far: the goal, what has been done, decisions made, and what remains. Be concise."
+
+// sessionSummarizer is implemented by prompt submitters that can summarize
+// a session the way Crush's summarize command does.
+type sessionSummarizer interface {
+	SummarizeSession(ctx context.Context, sessionID string) error
+}
+
+// isSessionCommand reports whether item controls sessions instead of
+// carrying a message for the model.
+func isSessionCommand(item FeedbackPayload) bool {
+	switch item.Type {
+	case FeedbackSwitchSession, FeedbackNewSession, FeedbackSummarize:
+		return true
+	}
+	return false
+}
+
+// SessionForTask returns the Crush session taskID was worked on in, or ""
+// when none has been seen.
+func (h *TempotownHook) SessionForTask(taskID string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.taskSessions[taskID]
+}
+
+// targetSession returns the session item is meant for: its session_id, the
+// session of its task, or current.
+func (h *TempotownHook) targetSession(item FeedbackPayload, current string) string {
+	if item.SessionID != "" {
+		return item.SessionID
+	}
+	if item.TaskID != "" {
+		if sessionID := h.SessionForTask(item.TaskID); sessionID != "" {
+			return sessionID
+		}
+	}
+	return current
+}
+
+// deliverFeedback submits items in order, starting in sessionID. Messages
+// going to the same session are submitted together; session commands are
+// applied between them and may change the session later items go to.
+func (h *TempotownHook) deliverFeedback(ctx context.Context, submitter plugin.PromptSubmitter, sessionID string, items []FeedbackPayload) {
+	var batch []FeedbackPayload
+	batchSession := ""
+	flush := func() {
+		if len(batch) > 0 {
+			h.submitFeedback(ctx, submitter, batchSession, batch)
+			batch = nil
+		}
+	}
+
+	for _, item := range items {
+		if isSessionCommand(item) {
+			flush()
+			sessionID = h.handleSessionCommand(ctx, submitter, sessionID, item)
+			continue
+		}
+		target := h.targetSession(item, sessionID)
+		if len(batch) > 0 && target != batchSession {
+			flush()
+		}
+		batchSession = target
+		batch = append(batch, item)
+	}
+	flush()
+}
+
+// handleSessionCommand applies a session command and returns the session
+// the agent works in afterwards.
+func (h *TempotownHook) handleSessionCommand(ctx context.Context, submitter plugin.PromptSubmitter, sessionID string, item FeedbackPayload) string {
+	switch item.Type {
+	case FeedbackSwitchSession:
+		target := h.targetSession(item, "")
+		if target == "" {
+			h.logger.Warn("switch_session without a session", "source", item.Source, "task_id", item.TaskID)
+			return sessionID
+		}
+		h.setSession(target)
+		h.logger.Info("switched session", "session_id", target, "source", item.Source)
+		h.reportStatus("session_switched", 0, map[string]any{"session_id": target})
+		return target
+
+	case FeedbackNewSession:
+		if strings.TrimSpace(item.Message) == "" {
+			h.logger.Warn("new_session without a message", "source", item.Source)
+			return sessionID
+		}
+		h.setSession("")
+		// The run blocks until it ends, by which time the new session has
+		// been seen.
+		if err := h.submitPrompt(ctx, submitter, "", formatFeedback([]FeedbackPayload{item}), item.Source, item.TaskID); err != nil {
+			h.logger.Warn("failed to start new session", "source", item.Source, "error", err)
+			return sessionID
+		}
+		sessionID = h.CurrentSession()
+		h.logger.Info("started new session", "session_id", sessionID, "source", item.Source)
+		h.reportStatus("session_started", 0, map[string]any{"session_id": sessionID})
+		return sessionID
+
+	case FeedbackSummarize:
+		target := h.targetSession(item, sessionID)
+		if target == "" {
+			h.logger.Warn("summarize without a session", "source", item.Source)
+			return sessionID
+		}
+		var err error
+		if s, ok := submitter.(sessionSummarizer); ok {
+			err = s.SummarizeSession(ctx, target)
+		} else {
+			err = h.submitPrompt(ctx, submitter, target, summarizePrompt, item.Source, item.TaskID)
+		}
+		if err != nil {
+			h.logger.Warn("failed to summarize session", "session_id", target, "error", err)
+			return sessionID
+		}
+		h.logger.Info("summarized session", "session_id", target, "source", item.Source)
+		h.reportStatus("session_summarized", 0, map[string]any{"session_id": target})
+	}
+	return sessionID
+}
+
+// CurrentSession returns the Crush session the agent last worked in.
+func (h *TempotownHook) CurrentSession() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessionID
+}
+
+// setSession makes sessionID the session feedback goes to.
+func (h *TempotownHook) setSession(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessionID = sessionID
+}
@@ -0,0 +1,144 @@
+package tempotown
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// maxRecentFiles is how many recently modified files status reports
+	// list.
+	maxRecentFiles = 10
+
+	// gitBranchTTL is how long the git branch is cached between status
+	// reports.
+	gitBranchTTL = 30 * time.Second
+)
+
+// fileTools are the Crush tools that modify the file in their file_path
+// input.
+var fileTools = []string{"edit", "multiedit", "write"}
+
+// statusContext returns the telemetry sent with every status report: the
+// model, token usage and cost of the session, the git branch, whether the
+// agent is paused, the current task and session, who initiated the work, and
+// the files the agent modified recently. Unknown values are left out.
+func (h *TempotownHook) statusContext() map[string]any {
+	details := map[string]any{}
+	if h.app != nil {
+		if sip := h.app.SessionInfo(); sip != nil {
+			if info := sip.SessionInfo(); info != nil {
+				if info.Model != "" {
+					details["model"] = info.Model
+				}
+				if info.Provider != "" {
+					details["provider"] = info.Provider
+				}
+				details["tokens"] = map[string]any{
+					"input":       info.Tokens.Input,
+					"output":      info.Tokens.Output,
+					"cache_read":  info.Tokens.CacheRead,
+					"cache_write": info.Tokens.CacheWrite,
+				}
+				details["cost_usd"] = info.CostUSD
+			}
+		}
+		if branch := h.gitBranch(h.app.WorkingDir()); branch != "" {
+			details["git_branch"] = branch
+		}
+	}
+
+	if state := h.control.State(); state.Paused {
+		details["paused"] = true
+		if state.Reason != "" {
+			details["pause_reason"] = state.Reason
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.currentTask != "" {
+		details["task_id"] = h.currentTask
+	}
+	if h.sessionID != "" {
+		details["session_id"] = h.sessionID
+	}
+	if h.prompt.Origin != "" {
+		details["initiated_by"] = h.prompt.Origin
+		if h.prompt.Source != "" {
+			details["prompt_source"] = h.prompt.Source
+		}
+	}
+	if len(h.recentFiles) > 0 {
+		details["recent_files"] = slices.Clone(h.recentFiles)
+	}
+	return details
+}
+
+// gitBranch returns the branch checked out in dir, or "" outside a git
+// repository, caching it briefly since status is reported often.
+func (h *TempotownHook) gitBranch(dir string) string {
+	h.mu.Lock()
+	if time.Since(h.gitBranchAt) < gitBranchTTL {
+		defer h.mu.Unlock()
+		return h.branch
+	}
+	h.mu.Unlock()
+
+	branch, _ := git(dir, "rev-parse", "--abbrev-ref", "HEAD")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.branch = branch
+	h.gitBranchAt = time.Now()
+	return branch
+}
+
+// noteToolCalls records the files modified by finished file tool calls,
+// most recent first, relative to the working directory when inside it.
+func (h *TempotownHook) noteToolCalls(calls []plugin.ToolCallInfo) {
+	var files []string
+	for _, tc := range calls {
+		if !tc.Finished || !slices.Contains(fileTools, tc.Name) {
+			continue
+		}
+		var input struct {
+			FilePath string `json:"file_path"`
+		}
+		if err := json.Unmarshal([]byte(tc.Input), &input); err != nil || input.FilePath == "" {
+			continue
+		}
+		files = append(files, h.relativePath(input.FilePath))
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, file := range files {
+		h.recentFiles = slices.DeleteFunc(h.recentFiles, func(f string) bool { return f == file })
+		h.recentFiles = slices.Insert(h.recentFiles, 0, file)
+	}
+	if len(h.recentFiles) > maxRecentFiles {
+		h.recentFiles = h.recentFiles[:maxRecentFiles]
+	}
+}
+
+// relativePath returns path relative to the working directory when it is
+// inside it.
+func (h *TempotownHook) relativePath(path string) string {
+	if h.app == nil || !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(h.app.WorkingDir(), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,253 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"charm.land/fantasy"
+)
+
+const (
+	// TaskToolName is the name of the task lifecycle tool.
+	TaskToolName = "tempotown_task"
+
+	// TaskDescription is the task tool description shown to the LLM.
+	TaskDescription = `Track the Tempotown task you are working on so the orchestrator's workflows know its state.
+
+<usage>
+- action "accept": take on a task assigned to you; task_id is required
+- action "complete": finish a task; put a summary or a JSON object in result
+- action "fail": give up on a task; explain why in result
+task_id defaults to the task accepted last.
+</usage>
+
+<example>
+tempotown_task(action="accept", task_id="task-7")
+tempotown_task(action="complete", result="Added parser tests; all green.")
+</example>
+`
+)
+
+// Task lifecycle actions.
+const (
+	TaskAccept   = "accept"
+	TaskComplete = "complete"
+	TaskFail     = "fail"
+)
+
+// errNotConnected is returned by calls made while disconnected.
+var errNotConnected = errors.New("not connected to Tempotown")
+
+// taskMarkerPattern matches a task outcome written in an assistant message:
+//
+//	<task-complete task="task-7">summary</task-complete>
+//	<task-failed>reason</task-failed>
+//
+// The task attribute is optional and defaults to the current task.
+var taskMarkerPattern = regexp.MustCompile(`(?s)<task-(complete|failed)(?:\s+task="([^"]*)")?\s*>(.*?)</task-(?:complete|failed)>`)
+
+// TaskParams defines the parameters for the task tool.
+type TaskParams struct {
+	Action string `json:"action" jsonschema:"description=What happened to the task: accept, complete, or fail"`
+	TaskID string `json:"task_id,omitempty" jsonschema:"description=The task ID (defaults to the task accepted last)"`
+	Result string `json:"result,omitempty" jsonschema:"description=For complete, a summary or JSON object describing the result; for fail, the reason"`
+}
+
+// NewTaskTool creates the tool through which the LLM reports task progress.
+func NewTaskTool(h *TempotownHook) fantasy.AgentTool {
+	return fantasy.NewAgentTool(
+		TaskToolName,
+		TaskDescription,
+		func(ctx context.Context, params TaskParams, call fantasy.ToolCall) (fantasy.ToolResponse, error) {
+			taskID, err := h.updateTask(ctx, params.Action, params.TaskID, params.Result)
+			if err != nil {
+				return fantasy.NewTextErrorResponse(err.Error()), nil
+			}
+			switch params.Action {
+			case TaskAccept:
+				return fantasy.NewTextResponse(fmt.Sprintf("Accepted task %s.", taskID)), nil
+			case TaskComplete:
+				return fantasy.NewTextResponse(fmt.Sprintf("Completed task %s.", taskID)), nil
+			default:
+				return fantasy.NewTextResponse(fmt.Sprintf("Reported task %s as failed.", taskID)), nil
+			}
+		},
+	)
+}
+
+// updateTask applies a lifecycle action to taskID, or to the current task
+// when taskID is empty, and returns the task it applied to.
+func (h *TempotownHook) updateTask(ctx context.Context, action, taskID, result string) (string, error) {
+	if taskID == "" && action != TaskAccept {
+		taskID = h.CurrentTask()
+	}
+	if taskID == "" {
+		return "", errors.New("task_id is required: no task has been accepted")
+	}
+
+	switch action {
+	case TaskAccept:
+		return taskID, h.acceptTask(ctx, taskID)
+	case TaskComplete:
+		return taskID, h.completeTask(ctx, taskID, result)
+	case TaskFail:
+		return taskID, h.failTask(ctx, taskID, result)
+	default:
+		return "", fmt.Errorf("unknown action %q: use accept, complete, or fail", action)
+	}
+}
+
+// CurrentTask returns the task accepted last and not yet completed or
+// failed, or "" when there is none.
+func (h *TempotownHook) CurrentTask() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.currentTask
+}
+
+// acceptTask tells Tempotown this agent has taken on taskID.
+func (h *TempotownHook) acceptTask(ctx context.Context, taskID string) error {
+	if err := h.callTaskTool(ctx, "accept_task", taskID, nil); err != nil {
+		return err
+	}
+	h.setTask(taskID, "working")
+	h.startArtifacts()
+	return nil
+}
+
+// completeTask reports taskID as done. result is sent as given when it is
+// a JSON object and as its summary otherwise. The task's artifacts are then
+// uploaded in the background. While disconnected, both are queued until
+// the next connection.
+func (h *TempotownHook) completeTask(ctx context.Context, taskID, result string) error {
+	payload := map[string]any{}
+	if err := json.Unmarshal([]byte(result), &payload); err != nil {
+		payload = map[string]any{"summary": strings.TrimSpace(result)}
+	}
+	extra := map[string]any{"result": payload}
+	err := h.callTaskTool(ctx, "complete_task", taskID, extra)
+	if err != nil && !isDisconnect(err) {
+		return err
+	}
+	h.finishTask(taskID)
+	h.releaseClaims(ctx)
+
+	var upload func(context.Context)
+	if h.uploadArtifacts() {
+		// Collect now, before another task can be accepted.
+		artifacts := h.collectArtifacts()
+		upload = func(ctx context.Context) { h.uploadTaskArtifacts(ctx, taskID, artifacts) }
+	}
+	if err != nil {
+		h.queueResult(queuedResult{tool: "complete_task", taskID: taskID, extra: extra, then: upload})
+		return nil
+	}
+	if upload != nil {
+		go upload(context.WithoutCancel(ctx))
+	}
+	return nil
+}
+
+// failTask reports taskID as failed for reason, or queues the report until
+// the next connection while disconnected.
+func (h *TempotownHook) failTask(ctx context.Context, taskID, reason string) error {
+	extra := map[string]any{"reason": strings.TrimSpace(reason)}
+	err := h.callTaskTool(ctx, "fail_task", taskID, extra)
+	if err != nil && !isDisconnect(err) {
+		return err
+	}
+	h.finishTask(taskID)
+	h.releaseClaims(ctx)
+	if err != nil {
+		h.queueResult(queuedResult{tool: "fail_task", taskID: taskID, extra: extra})
+	}
+	return nil
+}
+
+// callTaskTool calls a task lifecycle tool for taskID with extra arguments.
+func (h *TempotownHook) callTaskTool(ctx context.Context, name, taskID string, extra map[string]any) error {
+	if !h.connected.Load() {
+		return errNotConnected
+	}
+	args := map[string]any{
+		"task_id":  taskID,
+		"agent_id": h.AgentID(),
+	}
+	for k, v := range extra {
+		args[k] = v
+	}
+	if _, err := h.callTool(ctx, name, args); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	h.logger.Info("task updated", "call", name, "task_id", taskID)
+	return nil
+}
+
+// setTask records taskID as the current task in phase.
+func (h *TempotownHook) setTask(taskID, phase string) {
+	h.mu.Lock()
+	h.currentTask = taskID
+	h.phase = phase
+	h.mu.Unlock()
+	h.publishLink()
+}
+
+// finishTask clears taskID if it is the current task.
+func (h *TempotownHook) finishTask(taskID string) {
+	h.mu.Lock()
+	if h.currentTask == taskID {
+		h.currentTask = ""
+		h.phase = "idle"
+	}
+	h.mu.Unlock()
+	h.publishLink()
+}
+
+// taskMarker is a task outcome found in an assistant message.
+type taskMarker struct {
+	action string
+	taskID string
+	text   string
+}
+
+// parseTaskMarkers returns the task outcomes written in content.
+func parseTaskMarkers(content string) []taskMarker {
+	var markers []taskMarker
+	for _, m := range taskMarkerPattern.FindAllStringSubmatch(content, -1) {
+		action := TaskComplete
+		if m[1] == "failed" {
+			action = TaskFail
+		}
+		markers = append(markers, taskMarker{action: action, taskID: strings.TrimSpace(m[2]), text: strings.TrimSpace(m[3])})
+	}
+	return markers
+}
+
+// handleTaskMarkers reports the task outcomes in an assistant message once
+// per message, as soon as a complete marker has streamed in.
+func (h *TempotownHook) handleTaskMarkers(ctx context.Context, messageID, content string) {
+	markers := parseTaskMarkers(content)
+	if len(markers) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	if h.markedMessages[messageID] {
+		h.mu.Unlock()
+		return
+	}
+	h.markedMessages[messageID] = true
+	h.mu.Unlock()
+
+	go func() {
+		for _, m := range markers {
+			if _, err := h.updateTask(ctx, m.action, m.taskID, m.text); err != nil {
+				h.logger.Warn("failed to report task marker", "action", m.action, "task_id", m.taskID, "error", err)
+			}
+		}
+	}()
+}
@@ -18,22 +18,41 @@
 //	  }
 //	}
 //
-// Without an endpoint configured, the plugin does nothing.
+// Without an endpoint (or, for the stdio transport, a command) configured,
+// the plugin does nothing.
+//
+// MCP is spoken over raw TCP by default. Set "transport" to "websocket" to
+// reach a hosted orchestrator at a ws:// or wss:// endpoint, or to "stdio"
+// to run a local MCP server given by "command" and "args".
+//
+// Connections beyond localhost should set "tls": true, optionally with a
+// "ca_file" for a private certificate authority, and an "auth_token" that is
+// sent to the orchestrator during the MCP handshake.
 package tempotown
 
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/aleksclark/crush-modules/agentcaps"
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmeter"
 	"github.com/charmbracelet/crush/plugin"
 )
 
@@ -47,33 +66,152 @@ const (
 	// DefaultPollInterval is how often to poll for signals.
 	DefaultPollInterval = 5 * time.Second
 
-	// ReconnectDelay is how long to wait before reconnecting.
+	// ReconnectDelay is how long to wait before the first reconnect attempt.
+	// Each further failed attempt doubles the wait, up to the maximum.
 	ReconnectDelay = 5 * time.Second
+
+	// DefaultMaxReconnectDelay caps the wait between reconnect attempts.
+	DefaultMaxReconnectDelay = 5 * time.Minute
+
+	// reconnectJitter is the fraction by which reconnect waits are randomly
+	// lengthened or shortened, so that agents do not reconnect in lockstep.
+	reconnectJitter = 0.2
+
+	// StableConnectionTime is how long a connection must stay up, unless a
+	// heartbeat is answered sooner, before losing it resets the backoff.
+	// Connections dropped earlier count as failed attempts.
+	StableConnectionTime = time.Minute
+
+	// DialTimeout is how long to wait for a connection to be established.
+	DialTimeout = 10 * time.Second
+
+	// DefaultHeartbeatInterval is how often the server is pinged to check
+	// that the connection is alive.
+	DefaultHeartbeatInterval = 30 * time.Second
+
+	// HeartbeatTimeout is how long a ping may take before the connection is
+	// considered dead.
+	HeartbeatTimeout = 10 * time.Second
+
+	// DefaultRequestTimeout is how long to wait for the response to a
+	// request whose context has no deadline of its own.
+	DefaultRequestTimeout = 30 * time.Second
+)
+
+var (
+	// errRequestTimeout is returned when a request gets no response within
+	// the request timeout.
+	errRequestTimeout = errors.New("request timeout")
+
+	// errConnectionLost is returned for requests still waiting for a
+	// response when the connection drops.
+	errConnectionLost = errors.New("connection to Tempotown lost")
 )
 
 // Config defines the configuration options for the Tempotown plugin.
 type Config struct {
-	// Endpoint is the MCP server address (e.g., "localhost:9090").
-	// REQUIRED: If empty, the plugin is disabled and does not connect.
+	// Endpoint is the MCP server address (e.g., "localhost:9090"), or a
+	// URL such as "wss://tempotown.example.com/mcp" for the websocket
+	// transport. "auto" discovers the server on the local network with
+	// mDNS/DNS-SD. If it and Command are empty, the plugin is disabled.
 	Endpoint string `json:"endpoint,omitempty"`
 
+	// DiscoveryService is the DNS-SD service type browsed for when
+	// Endpoint is "auto" (default: "_tempotown._tcp").
+	DiscoveryService string `json:"discovery_service,omitempty"`
+
+	// Transport is how to reach the server: tcp (default), websocket, or
+	// stdio.
+	Transport string `json:"transport,omitempty"`
+
+	// Command and Args start the MCP server for the stdio transport.
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+
 	// Role is the agent role: coder, reviewer, merger, supervisor.
 	Role string `json:"role,omitempty"`
 
-	// Capabilities is a list of agent capabilities.
+	// Capabilities is a list of agent capabilities, added to the detected
+	// ones. An entry starting with "-" removes detected capabilities
+	// matching the glob after it.
 	Capabilities []string `json:"capabilities,omitempty"`
 
+	// AutoCapabilities detects capabilities from the plugin tools, enabled
+	// sub-agents, and model in use when registering (default: true).
+	AutoCapabilities *bool `json:"auto_capabilities,omitempty"`
+
 	// PollInterval is how often to poll for signals (default: 5s).
 	PollIntervalSeconds int `json:"poll_interval_seconds,omitempty"`
+
+	// ReconnectMaxSeconds caps the backoff between reconnect attempts
+	// (default: 300).
+	ReconnectMaxSeconds int `json:"reconnect_max_seconds,omitempty"`
+
+	// HeartbeatSeconds is how often to ping the server to detect dead
+	// connections (default: 30; negative disables).
+	HeartbeatSeconds int `json:"heartbeat_seconds,omitempty"`
+
+	// StatusIntervalSeconds is the least time between reports of the
+	// agent's activity (default: 1; negative reports every change).
+	StatusIntervalSeconds int `json:"status_interval_seconds,omitempty"`
+
+	// RequestTimeoutSeconds is how long to wait for the response to a
+	// request (default: 30). Calls that need longer, such as
+	// request_approval, set their own deadline.
+	RequestTimeoutSeconds int `json:"request_timeout_seconds,omitempty"`
+
+	// ReconnectMaxRetries is how many failed reconnect attempts in a row are
+	// made before the plugin gives up and stays idle until a reconnect is
+	// requested from the Tempotown dialog (default: 0, never give up).
+	ReconnectMaxRetries int `json:"reconnect_max_retries,omitempty"`
+
+	// TLS encrypts the connection, verifying the server certificate against
+	// the system roots or CAFile.
+	TLS bool `json:"tls,omitempty"`
+
+	// CAFile is a PEM file of certificate authorities to trust instead of the
+	// system roots. Setting it implies TLS.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// AuthToken is sent to the server in the initialize request. Environment
+	// variables such as $TEMPOTOWN_TOKEN are expanded.
+	AuthToken string `json:"auth_token,omitempty"`
+
+	// InjectFeedback submits feedback from Tempotown to the model as prompts
+	// (default: true). Turn it off to consume FeedbackCh elsewhere.
+	InjectFeedback *bool `json:"inject_feedback,omitempty"`
+
+	// WorkQueue makes the agent a headless worker: it pulls tasks with
+	// get_next_task whenever it is idle, runs each as a prompt, and reports
+	// the outcome.
+	WorkQueue bool `json:"work_queue,omitempty"`
+
+	// UploadArtifacts sends the git diff, changed files, and test output of
+	// a task to Tempotown when it is completed (default: true).
+	UploadArtifacts *bool `json:"upload_artifacts,omitempty"`
+
+	// DebugLog is a file that every JSON-RPC message exchanged with
+	// Tempotown is appended to as JSON Lines, with secrets redacted
+	// (e.g., "~/.crush/tempotown-wire.jsonl").
+	DebugLog string `json:"debug_log,omitempty"`
+
+	// Approval routes tool permission requests to Tempotown for unattended
+	// agents.
+	Approval ApprovalConfig `json:"approval,omitempty"`
+
+	// ClaimGuard claims files with claim_resource before they are edited and
+	// either warns ("warn") or refuses the edit ("block") when another agent
+	// holds them (default: off).
+	ClaimGuard string `json:"claim_guard,omitempty"`
+
+	// PayloadSecurity signs and encrypts feedback and status payloads
+	// independently of the transport.
+	PayloadSecurity PayloadSecurityConfig `json:"payload_security,omitempty"`
 }
 
 func init() {
 	plugin.RegisterHookWithConfig(HookName, func(ctx context.Context, app *plugin.App) (plugin.Hook, error) {
-		var cfg Config
-		if err := app.LoadConfig(HookName, &cfg); err != nil {
-			return nil, err
-		}
-		hook, err := NewTempotownHook(app, cfg)
+		hook, err := loadHook(app)
 		if err != nil {
 			return nil, err
 		}
@@ -83,27 +221,182 @@ func init() {
 		}
 		return hook, nil
 	}, &Config{})
+
+	plugin.RegisterToolWithConfig(TaskToolName, func(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
+		hook, err := loadHook(app)
+		if err != nil {
+			return nil, err
+		}
+		if hook == nil {
+			// No endpoint configured - tool is disabled
+			return nil, nil
+		}
+		return NewTaskTool(hook), nil
+	}, &Config{})
+
+	plugin.RegisterToolWithConfig(EnsembleToolName, func(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
+		hook, err := loadHook(app)
+		if err != nil {
+			return nil, err
+		}
+		if hook == nil {
+			// No endpoint configured - tool is disabled
+			return nil, nil
+		}
+		return NewEnsembleTool(hook), nil
+	}, &Config{})
+
+	plugin.RegisterToolWithConfig(EnsembleStatusToolName, func(ctx context.Context, app *plugin.App) (plugin.Tool, error) {
+		hook, err := loadHook(app)
+		if err != nil {
+			return nil, err
+		}
+		if hook == nil {
+			// No endpoint configured - tool is disabled
+			return nil, nil
+		}
+		return NewEnsembleStatusTool(hook), nil
+	}, &Config{})
+}
+
+var (
+	hookOnce     sync.Once
+	hookInstance *TempotownHook
+	hookErr      error
+)
+
+// loadHook creates the hook shared by the hook and tool registrations on
+// first use, so that they use the same connection.
+func loadHook(app *plugin.App) (*TempotownHook, error) {
+	hookOnce.Do(func() {
+		var cfg Config
+		if hookErr = app.LoadConfig(HookName, &cfg); hookErr != nil {
+			return
+		}
+		hookInstance, hookErr = NewTempotownHook(app, cfg)
+	})
+	return hookInstance, hookErr
 }
 
 // TempotownHook implements the plugin.Hook interface for Tempotown integration.
 type TempotownHook struct {
-	app    *plugin.App
-	cfg    Config
-	logger *slog.Logger
+	app       *plugin.App
+	cfg       Config
+	logger    *slog.Logger
+	tlsConfig *tls.Config
 
 	// MCP client state.
 	mu        sync.Mutex
-	conn      net.Conn
+	conn      io.ReadWriteCloser
 	encoder   *json.Encoder
 	decoder   *json.Decoder
 	requestID atomic.Int64
-	pending   map[int64]chan *Response
+	pending   map[int64]pendingCall
 
-	// Agent state.
+	// requestTimeout bounds requests whose context has no deadline.
+	requestTimeout time.Duration
+
+	// wire records the messages sent and received, when debug_log is set.
+	wire *wireTap
+
+	// Agent state. agentID, currentTask, and phase are guarded by mu.
 	agentID     string
 	currentTask string
 	phase       string
-	connected   atomic.Bool
+
+	// Role and capabilities registered with, guarded by mu. They start as
+	// configured and change with SetRole.
+	role           string
+	configuredCaps []string
+	connected      atomic.Bool
+
+	// pushFeedback is set when the server pushes feedback notifications, so
+	// that it need not be polled.
+	pushFeedback atomic.Bool
+
+	// sealLog rejects sealed feedback that was received before.
+	sealLog sealLog
+
+	// Assistant messages whose task markers have been reported, guarded by
+	// mu.
+	markedMessages map[string]bool
+
+	// Crush activity, guarded by mu, used to submit feedback only when the
+	// agent is idle, and the session each task was worked on in.
+	sessionID    string
+	lastActivity time.Time
+	quietPeriod  time.Duration
+	taskSessions map[string]string
+
+	// Work-queue task being run, the session it runs in, and its last reply,
+	// guarded by mu. workQueuePoll is how often the queue is polled.
+	workTask      string
+	workSession   string
+	workReply     string
+	workQueuePoll time.Duration
+
+	// Artifacts of the current task, guarded by mu: the commit it started
+	// from, bash calls running tests by tool call ID, and their output.
+	taskBase  string
+	testCalls map[string]string
+	testRuns  []testRun
+
+	// Resources claimed with claim_resource, guarded by mu.
+	claims []string
+
+	// Task results recorded while disconnected, guarded by mu.
+	results []queuedResult
+
+	// Where the prompt that started the current work came from, guarded by
+	// mu.
+	prompt agentlink.Prompt
+
+	// Status context, guarded by mu.
+	recentFiles []string
+	branch      string
+	gitBranchAt time.Time
+
+	// Status reports waiting for statusLoop, guarded by mu: queued updates,
+	// the latest activity, and the last activity sent. statusInterval is the
+	// least time between activity reports.
+	statusQueue    []statusUpdate
+	statusActivity *statusUpdate
+	activityKey    string
+	statusWake     chan struct{}
+	statusInterval time.Duration
+
+	// Diagnostics shown in the status dialog, guarded by mu.
+	lastStatus StatusReport
+	rpcErrors  []RPCError
+
+	// control pauses and resumes the agent, shared with other plugins.
+	control *agentcontrol.Switch
+
+	// caps lists the capabilities other plugins provide.
+	caps *agentcaps.Registry
+
+	// link shares the agent's identity and status with other plugins.
+	link *agentlink.Link
+
+	// meter records connection health metrics for exporting plugins.
+	meter *agentmeter.Meter
+
+	// Where discovery last found the server when the endpoint is auto,
+	// guarded by mu, and where discovery queries are sent.
+	discovered discoveredService
+	mdnsAddr   *net.UDPAddr
+
+	// reconnectCh wakes the connection loop to reconnect without waiting
+	// out the backoff.
+	reconnectCh chan struct{}
+
+	// Liveness checks; heartbeat is zero when they are disabled.
+	// heartbeatOK is set once a heartbeat of the current connection is
+	// answered.
+	heartbeat        time.Duration
+	heartbeatTimeout time.Duration
+	heartbeatOK      atomic.Bool
+	stableAfter      time.Duration
 
 	// Feedback channel for injecting signals into Crush.
 	feedbackCh chan FeedbackPayload
@@ -111,16 +404,51 @@ type TempotownHook struct {
 
 // NewTempotownHook creates a new Tempotown hook.
 func NewTempotownHook(app *plugin.App, cfg Config) (*TempotownHook, error) {
-	// Endpoint is required - if not configured, the hook is disabled
-	if cfg.Endpoint == "" {
+	// An endpoint or command is required - if neither is configured, the
+	// hook is disabled
+	if cfg.Endpoint == "" && cfg.Command == "" {
 		return nil, nil // Return nil hook to indicate disabled
 	}
+	if cfg.Transport == "" {
+		cfg.Transport = TransportTCP
+	}
+	if err := validateTransport(cfg); err != nil {
+		return nil, err
+	}
 	if cfg.Role == "" {
 		cfg.Role = DefaultRole
 	}
+	if cfg.DiscoveryService == "" {
+		cfg.DiscoveryService = DefaultDiscoveryService
+	}
 	if cfg.PollIntervalSeconds == 0 {
 		cfg.PollIntervalSeconds = int(DefaultPollInterval / time.Second)
 	}
+	if cfg.HeartbeatSeconds == 0 {
+		cfg.HeartbeatSeconds = int(DefaultHeartbeatInterval / time.Second)
+	}
+	if cfg.ReconnectMaxSeconds == 0 {
+		cfg.ReconnectMaxSeconds = int(DefaultMaxReconnectDelay / time.Second)
+	}
+	if cfg.StatusIntervalSeconds == 0 {
+		cfg.StatusIntervalSeconds = int(DefaultStatusInterval / time.Second)
+	}
+	if cfg.RequestTimeoutSeconds <= 0 {
+		cfg.RequestTimeoutSeconds = int(DefaultRequestTimeout / time.Second)
+	}
+	cfg.AuthToken = os.ExpandEnv(cfg.AuthToken)
+	cfg.PayloadSecurity.SigningKey = os.ExpandEnv(cfg.PayloadSecurity.SigningKey)
+	cfg.PayloadSecurity.EncryptionKey = os.ExpandEnv(cfg.PayloadSecurity.EncryptionKey)
+
+	if !permissionBrokers && (cfg.Approval.Enabled || cfg.ClaimGuard == ClaimGuardWarn || cfg.ClaimGuard == ClaimGuardBlock) {
+		// Running without them would let edits and commands through that
+		// the config routes to the ensemble.
+		return nil, errors.New("approval and claim_guard are not supported by this Crush build (build with -tags crushnext)")
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	var logger *slog.Logger
 	if app != nil {
@@ -129,18 +457,92 @@ func NewTempotownHook(app *plugin.App, cfg Config) (*TempotownHook, error) {
 		logger = slog.Default().With("hook", HookName)
 	}
 
+	if cfg.AuthToken != "" && cfg.Transport != TransportStdio && tlsConfig == nil &&
+		!strings.HasPrefix(cfg.Endpoint, "wss://") && !isLoopback(cfg.Endpoint) {
+		logger.Warn("auth token will be sent without TLS", "endpoint", cfg.Endpoint)
+	}
+
 	hook := &TempotownHook{
-		app:        app,
-		cfg:        cfg,
-		logger:     logger,
-		pending:    make(map[int64]chan *Response),
-		feedbackCh: make(chan FeedbackPayload, 10),
-		phase:      "init",
+		app:              app,
+		cfg:              cfg,
+		logger:           logger,
+		tlsConfig:        tlsConfig,
+		quietPeriod:      FeedbackQuietPeriod,
+		workQueuePoll:    time.Duration(cfg.PollIntervalSeconds) * time.Second,
+		heartbeat:        time.Duration(max(0, cfg.HeartbeatSeconds)) * time.Second,
+		heartbeatTimeout: HeartbeatTimeout,
+		stableAfter:      StableConnectionTime,
+		requestTimeout:   time.Duration(cfg.RequestTimeoutSeconds) * time.Second,
+		pending:          make(map[int64]pendingCall),
+		markedMessages:   make(map[string]bool),
+		taskSessions:     make(map[string]string),
+		feedbackCh:       make(chan FeedbackPayload, 10),
+		reconnectCh:      make(chan struct{}, 1),
+		statusWake:       make(chan struct{}, 1),
+		statusInterval:   time.Duration(cfg.StatusIntervalSeconds) * time.Second,
+		control:          agentcontrol.Shared(),
+		caps:             agentcaps.Shared(),
+		link:             agentlink.Shared(),
+		meter:            agentmeter.Shared(),
+		mdnsAddr:         mdnsGroup,
+		role:             cfg.Role,
+		configuredCaps:   cfg.Capabilities,
+		phase:            "init",
+	}
+
+	if cfg.DebugLog != "" {
+		wire, err := openWireTap(cfg.DebugLog, cfg.AuthToken, cfg.PayloadSecurity.SigningKey, cfg.PayloadSecurity.EncryptionKey)
+		if err != nil {
+			logger.Warn("wire log disabled", "error", err)
+		}
+		hook.wire = wire
 	}
 
 	return hook, nil
 }
 
+// newTLSConfig returns the TLS configuration for cfg, or nil when TLS is off.
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	if !cfg.TLS && cfg.CAFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s contains no PEM certificates", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// isLoopback reports whether endpoint, an address or URL, names this
+// machine.
+func isLoopback(endpoint string) bool {
+	var host string
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return false
+		}
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(endpoint); err == nil {
+		host = h
+	} else {
+		host = endpoint
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // Name returns the hook identifier.
 func (h *TempotownHook) Name() string {
 	return HookName
@@ -154,6 +556,29 @@ func (h *TempotownHook) Start(ctx context.Context) error {
 	// Start feedback poll loop.
 	go h.pollFeedbackLoop(ctx)
 
+	// Submit feedback to the model unless another component consumes it.
+	submitter := h.app.PromptSubmitter()
+	if h.injectFeedback() {
+		if submitter != nil {
+			go h.feedbackLoop(ctx, submitter)
+		} else {
+			h.logger.Warn("no prompt submitter available, feedback will not reach the model")
+		}
+	}
+
+	// Pull and run tasks from the work queue.
+	if h.cfg.WorkQueue {
+		if submitter != nil {
+			go h.workQueueLoop(ctx, submitter)
+		} else {
+			h.logger.Warn("no prompt submitter available, work queue disabled")
+		}
+	}
+
+	// Report the agent waiting or failing as other plugins see it.
+	unwatch := h.link.WatchStatus(h.handleLinkStatus)
+	defer unwatch()
+
 	// Start message event handler.
 	messages := h.app.Messages()
 	if messages == nil {
@@ -163,7 +588,7 @@ func (h *TempotownHook) Start(ctx context.Context) error {
 	}
 
 	events := messages.SubscribeMessages(ctx)
-	h.logger.Info("Tempotown hook started", "endpoint", h.cfg.Endpoint, "role", h.cfg.Role)
+	h.logger.Info("Tempotown hook started", "transport", h.cfg.Transport, "endpoint", h.cfg.Endpoint, "role", h.cfg.Role)
 
 	for {
 		select {
@@ -180,6 +605,8 @@ func (h *TempotownHook) Start(ctx context.Context) error {
 
 // Stop gracefully shuts down the hook.
 func (h *TempotownHook) Stop() error {
+	h.link.SetOrchestrator(agentlink.Orchestrator{})
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -188,12 +615,18 @@ func (h *TempotownHook) Stop() error {
 		h.conn = nil
 	}
 	h.connected.Store(false)
+	_ = h.wire.close()
 	h.logger.Info("Tempotown hook stopped")
 	return nil
 }
 
 // connectionLoop manages the connection to the MCP server.
+// Failed attempts back off exponentially, and so do connections that drop
+// before they proved stable; only the first failure after a success is
+// logged as a warning. Reconnect cuts any wait short.
 func (h *TempotownHook) connectionLoop(ctx context.Context) {
+	failures := 0
+	connectedBefore := false
 	for {
 		select {
 		case <-ctx.Done():
@@ -202,15 +635,37 @@ func (h *TempotownHook) connectionLoop(ctx context.Context) {
 		}
 
 		done, err := h.connect(ctx)
+		h.countConnect(err, connectedBefore)
 		if err != nil {
-			h.logger.Warn("failed to connect to Tempotown", "error", err, "endpoint", h.cfg.Endpoint)
+			failures++
+			if h.cfg.ReconnectMaxRetries > 0 && failures > h.cfg.ReconnectMaxRetries {
+				h.logger.Warn("giving up on Tempotown until a reconnect is requested", "attempts", failures, "error", err, "endpoint", h.cfg.Endpoint)
+				select {
+				case <-ctx.Done():
+					return
+				case <-h.reconnectCh:
+					failures = 0
+					continue
+				}
+			}
+			delay := h.reconnectDelay(failures)
+			if failures == 1 {
+				h.logger.Warn("failed to connect to Tempotown", "error", err, "endpoint", h.cfg.Endpoint, "retry_in", delay)
+			} else {
+				h.logger.Debug("failed to connect to Tempotown", "error", err, "endpoint", h.cfg.Endpoint, "attempt", failures, "retry_in", delay)
+			}
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(ReconnectDelay):
+			case <-h.reconnectCh:
+				failures = 0
+				continue
+			case <-time.After(delay):
 				continue
 			}
 		}
+		connectedBefore = true
+		connectedAt := time.Now()
 
 		// Wait for connection to drop.
 		select {
@@ -219,24 +674,57 @@ func (h *TempotownHook) connectionLoop(ctx context.Context) {
 		case <-done:
 		}
 
-		// Connection lost, try to reconnect.
+		// Connection lost, try to reconnect. A server that accepts and then
+		// drops connections keeps backing off.
 		h.connected.Store(false)
-		h.logger.Info("connection lost, reconnecting...")
+		h.publishLink()
+		if h.connectionStable(connectedAt) {
+			failures = 0
+		} else {
+			failures++
+		}
+		delay := h.reconnectDelay(max(failures, 1))
+		h.logger.Info("connection lost, reconnecting...", "retry_in", delay)
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(ReconnectDelay):
+		case <-h.reconnectCh:
+			failures = 0
+		case <-time.After(delay):
 		}
 	}
 }
 
+// connectionStable reports whether the connection made at connectedAt
+// stayed up long enough or answered a heartbeat.
+func (h *TempotownHook) connectionStable(connectedAt time.Time) bool {
+	return h.heartbeatOK.Load() || time.Since(connectedAt) >= h.stableAfter
+}
+
+// reconnectDelay returns how long to wait before the attempt following the
+// given number of consecutive failures.
+func (h *TempotownHook) reconnectDelay(failures int) time.Duration {
+	maxDelay := time.Duration(h.cfg.ReconnectMaxSeconds) * time.Second
+	return backoffDelay(failures, ReconnectDelay, maxDelay, rand.Float64())
+}
+
+// backoffDelay doubles base for every failure after the first, caps it at
+// maxDelay, and applies jitter, where r is uniform in [0, 1).
+func backoffDelay(failures int, base, maxDelay time.Duration, r float64) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	return time.Duration(float64(delay) * (1 + reconnectJitter*(2*r-1)))
+}
+
 // connect establishes connection to the MCP server.
 // Returns a channel that closes when the connection is lost.
 func (h *TempotownHook) connect(ctx context.Context) (<-chan struct{}, error) {
-	dialer := net.Dialer{Timeout: 10 * time.Second}
-	conn, err := dialer.DialContext(ctx, "tcp", h.cfg.Endpoint)
+	conn, err := h.dial(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("dial failed: %w", err)
+		return nil, fmt.Errorf("%s dial failed: %w", h.cfg.Transport, err)
 	}
 
 	h.mu.Lock()
@@ -250,7 +738,11 @@ func (h *TempotownHook) connect(ctx context.Context) (<-chan struct{}, error) {
 	// that expect responses.
 	done := make(chan struct{})
 	go func() {
-		h.readLoop(ctx)
+		h.readLoop(ctx, conn)
+		// Close the dropped connection so that a stdio server is reaped
+		// before the loop starts another.
+		conn.Close()
+		h.failPending(conn)
 		close(done)
 	}()
 
@@ -267,12 +759,22 @@ func (h *TempotownHook) connect(ctx context.Context) (<-chan struct{}, error) {
 	}
 
 	h.connected.Store(true)
-	h.logger.Info("connected to Tempotown", "agent_id", h.agentID)
+	h.heartbeatOK.Store(false)
+	h.publishLink()
+	h.logger.Info("connected to Tempotown", "agent_id", h.AgentID())
+	go h.statusLoop(ctx, done)
+	go h.flushResults(ctx)
+	if h.heartbeat > 0 {
+		go h.heartbeatLoop(ctx, conn, done)
+	}
 	return done, nil
 }
 
-// readLoop reads responses from the server.
-func (h *TempotownHook) readLoop(ctx context.Context) {
+// readLoop reads responses, notifications, and requests from the server.
+// With heartbeats on, a connection that supports read deadlines is dropped
+// when nothing, not even a ping reply, arrives for two heartbeat intervals.
+func (h *TempotownHook) readLoop(ctx context.Context, conn io.ReadWriteCloser) {
+	deadline, _ := conn.(interface{ SetReadDeadline(time.Time) error })
 	for {
 		select {
 		case <-ctx.Done():
@@ -280,26 +782,24 @@ func (h *TempotownHook) readLoop(ctx context.Context) {
 		default:
 		}
 
-		var resp Response
-		if err := h.decoder.Decode(&resp); err != nil {
-			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		if deadline != nil && h.heartbeat > 0 {
+			_ = deadline.SetReadDeadline(time.Now().Add(2*h.heartbeat + h.heartbeatTimeout))
+		}
+		var msg incoming
+		if err := h.decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) {
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				h.logger.Warn("no data from Tempotown, dropping connection")
+				conn.Close()
 				return
 			}
 			h.logger.Error("read error", "error", err)
 			return
 		}
-
-		// Route response to waiting caller.
-		if resp.ID != nil {
-			if id, ok := resp.ID.(float64); ok {
-				h.mu.Lock()
-				if ch, exists := h.pending[int64(id)]; exists {
-					ch <- &resp
-					delete(h.pending, int64(id))
-				}
-				h.mu.Unlock()
-			}
-		}
+		h.wire.record(wireIn, msg)
+		h.handleIncoming(ctx, &msg)
 	}
 }
 
@@ -313,11 +813,19 @@ func (h *TempotownHook) initialize(ctx context.Context) error {
 		},
 		Capabilities: ClientCapability{},
 	}
+	if h.cfg.AuthToken != "" {
+		params.Meta = &InitializeMeta{AuthToken: h.cfg.AuthToken}
+	}
 
-	_, err := h.call(ctx, "initialize", params)
+	resp, err := h.call(ctx, "initialize", params)
 	if err != nil {
 		return err
 	}
+	push := pushesFeedback(resp.Result)
+	h.pushFeedback.Store(push)
+	if push {
+		h.logger.Debug("server pushes feedback, polling disabled")
+	}
 
 	// Send initialized notification.
 	h.sendNotification("initialized", nil)
@@ -327,8 +835,8 @@ func (h *TempotownHook) initialize(ctx context.Context) error {
 // registerAgent registers this Crush instance with Tempotown.
 func (h *TempotownHook) registerAgent(ctx context.Context) error {
 	args := map[string]any{
-		"role":         h.cfg.Role,
-		"capabilities": h.cfg.Capabilities,
+		"role":         h.Role(),
+		"capabilities": h.capabilities(),
 	}
 
 	resp, err := h.callTool(ctx, "register_agent", args)
@@ -340,21 +848,62 @@ func (h *TempotownHook) registerAgent(ctx context.Context) error {
 	var result struct {
 		AgentID string `json:"agent_id"`
 	}
-	if err := json.Unmarshal([]byte(resp), &result); err == nil && result.AgentID != "" {
+	_ = json.Unmarshal([]byte(resp), &result)
+
+	// A task accepted before a reconnect is still being worked on.
+	h.mu.Lock()
+	if result.AgentID != "" {
 		h.agentID = result.AgentID
 	}
-
-	h.phase = "idle"
+	if h.currentTask == "" {
+		h.phase = "idle"
+	}
+	h.mu.Unlock()
 	return nil
 }
 
-// call makes a JSON-RPC call and waits for response.
+// call makes a JSON-RPC call and waits for response. Failures are kept for
+// the status dialog.
 func (h *TempotownHook) call(ctx context.Context, method string, params any) (*Response, error) {
+	started := time.Now()
+	resp, err := h.roundTrip(ctx, method, params)
+	if errors.Is(err, context.Canceled) {
+		return resp, err
+	}
+	if p, ok := params.(ToolCallParams); ok {
+		method = p.Name
+	}
+	h.observeRPC(method, started, err)
+	if err != nil {
+		h.recordRPCError(method, err)
+	}
+	return resp, err
+}
+
+// pendingCall is a request waiting for its response. ch is closed without
+// a response when conn drops.
+type pendingCall struct {
+	ch   chan *Response
+	conn io.ReadWriteCloser
+}
+
+// roundTrip sends a JSON-RPC request and waits for its response until ctx
+// is done. Without a deadline on ctx it waits for the request timeout.
+func (h *TempotownHook) roundTrip(ctx context.Context, method string, params any) (*Response, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, h.requestTimeout, errRequestTimeout)
+		defer cancel()
+	}
 	id := h.requestID.Add(1)
 	ch := make(chan *Response, 1)
 
 	h.mu.Lock()
-	h.pending[id] = ch
+	if h.encoder == nil {
+		h.mu.Unlock()
+		return nil, errNotConnected
+	}
+	h.pending[id] = pendingCall{ch: ch, conn: h.conn}
 	req := Request{
 		JSONRPC: "2.0",
 		ID:      id,
@@ -364,7 +913,7 @@ func (h *TempotownHook) call(ctx context.Context, method string, params any) (*R
 		data, _ := json.Marshal(params)
 		req.Params = data
 	}
-	err := h.encoder.Encode(req)
+	err := h.send(req)
 	h.mu.Unlock()
 
 	if err != nil {
@@ -379,17 +928,28 @@ func (h *TempotownHook) call(ctx context.Context, method string, params any) (*R
 		h.mu.Lock()
 		delete(h.pending, id)
 		h.mu.Unlock()
-		return nil, ctx.Err()
-	case resp := <-ch:
+		return nil, context.Cause(ctx)
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errConnectionLost
+		}
 		if resp.Error != nil {
-			return nil, fmt.Errorf("RPC error %d: %s", resp.Error.Code, resp.Error.Message)
+			return nil, resp.Error
 		}
 		return resp, nil
-	case <-time.After(30 * time.Second):
-		h.mu.Lock()
-		delete(h.pending, id)
-		h.mu.Unlock()
-		return nil, fmt.Errorf("request timeout")
+	}
+}
+
+// failPending fails the requests sent over conn that are still waiting for
+// a response, so that callers do not wait out their timeout after it drops.
+func (h *TempotownHook) failPending(conn io.ReadWriteCloser) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, call := range h.pending {
+		if call.conn == conn {
+			close(call.ch)
+			delete(h.pending, id)
+		}
 	}
 }
 
@@ -441,64 +1001,65 @@ func (h *TempotownHook) sendNotification(method string, params any) {
 		data, _ := json.Marshal(params)
 		notif.Params = data
 	}
-	_ = h.encoder.Encode(notif)
+	_ = h.send(notif)
+}
+
+// send encodes msg on the connection and records it in the wire log. The
+// caller must hold mu.
+func (h *TempotownHook) send(msg any) error {
+	h.wire.record(wireOut, msg)
+	return h.encoder.Encode(msg)
 }
 
 // handleEvent processes message events and reports status.
 func (h *TempotownHook) handleEvent(ctx context.Context, event plugin.MessageEvent) {
+	msg := event.Message
+	h.noteActivity(msg.SessionID)
+	h.noteTaskMessage(msg)
+	if event.Type == plugin.MessageCreated && msg.Role == plugin.MessageRoleUser {
+		h.notePrompt(msg.Content)
+	}
+	switch msg.Role {
+	case plugin.MessageRoleAssistant:
+		h.noteToolCalls(msg.ToolCalls)
+		h.noteTestCommands(msg.ToolCalls)
+	case plugin.MessageRoleTool:
+		h.noteToolResults(msg.ToolResults)
+	}
+
 	if !h.connected.Load() {
 		return
 	}
 
-	msg := event.Message
-
 	switch event.Type {
 	case plugin.MessageCreated:
 		switch msg.Role {
 		case plugin.MessageRoleUser:
-			h.reportStatus(ctx, "processing user input", 0, nil)
+			h.reportActivity("processing user input", 0, nil)
 		case plugin.MessageRoleAssistant:
-			h.reportStatus(ctx, "generating response", 50, nil)
+			h.reportActivity("generating response", 50, nil)
+			h.handleTaskMarkers(ctx, msg.ID, msg.Content)
 		}
 
 	case plugin.MessageUpdated:
 		if msg.Role == plugin.MessageRoleAssistant {
+			h.handleTaskMarkers(ctx, msg.ID, msg.Content)
+
 			// Check for active tool calls.
 			for _, tc := range msg.ToolCalls {
 				if !tc.Finished {
-					h.reportStatus(ctx, fmt.Sprintf("running tool: %s", tc.Name), 50, map[string]any{
+					h.reportActivity(fmt.Sprintf("running tool: %s", tc.Name), 50, map[string]any{
 						"tool":    tc.Name,
 						"tool_id": tc.ID,
 					})
 					return
 				}
 			}
-			h.reportStatus(ctx, "response complete", 100, nil)
+			h.reportActivity("response complete", 100, nil)
 		}
 	}
 }
 
-// reportStatus sends a status update to Tempotown.
-func (h *TempotownHook) reportStatus(ctx context.Context, status string, progress int, details map[string]any) {
-	if !h.connected.Load() {
-		return
-	}
-
-	args := map[string]any{
-		"status":   status,
-		"progress": progress,
-	}
-	if details != nil {
-		args["details"] = details
-	}
-
-	go func() {
-		if _, err := h.callTool(ctx, "report_status", args); err != nil {
-			h.logger.Debug("failed to report status", "error", err)
-		}
-	}()
-}
-
 // pollFeedbackLoop periodically polls for feedback/signals.
 func (h *TempotownHook) pollFeedbackLoop(ctx context.Context) {
 	interval := time.Duration(h.cfg.PollIntervalSeconds) * time.Second
@@ -510,7 +1071,7 @@ func (h *TempotownHook) pollFeedbackLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if h.connected.Load() {
+			if h.connected.Load() && !h.pushFeedback.Load() {
 				h.pollFeedback(ctx)
 			}
 		}
@@ -519,20 +1080,22 @@ func (h *TempotownHook) pollFeedbackLoop(ctx context.Context) {
 
 // pollFeedback checks for pending feedback/signals.
 func (h *TempotownHook) pollFeedback(ctx context.Context) {
-	result, err := h.callTool(ctx, "get_pending_feedback", map[string]any{"limit": 10})
+	items, err := h.fetchFeedback(ctx)
 	if err != nil {
 		h.logger.Debug("failed to poll feedback", "error", err)
 		return
 	}
 
-	var feedback struct {
-		Items []FeedbackPayload `json:"items"`
-	}
-	if err := json.Unmarshal([]byte(result), &feedback); err != nil {
-		return
-	}
+	h.queueFeedback(ctx, items)
+}
 
-	for _, item := range feedback.Items {
+// queueFeedback passes feedback on through the feedback channel, dropping
+// items it has no room for. Pause and resume items are applied instead.
+func (h *TempotownHook) queueFeedback(ctx context.Context, items []FeedbackPayload) {
+	for _, item := range items {
+		if h.handleControl(ctx, item) {
+			continue
+		}
 		select {
 		case h.feedbackCh <- item:
 		default:
@@ -542,8 +1105,24 @@ func (h *TempotownHook) pollFeedback(ctx context.Context) {
 	}
 }
 
+// fetchFeedback takes up to 10 pending feedback items from Tempotown.
+func (h *TempotownHook) fetchFeedback(ctx context.Context) ([]FeedbackPayload, error) {
+	result, err := h.callTool(ctx, "get_pending_feedback", map[string]any{"limit": 10})
+	if err != nil {
+		return nil, err
+	}
+	var feedback struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(result), &feedback); err != nil {
+		return nil, fmt.Errorf("unmarshal feedback: %w", err)
+	}
+	return h.openFeedback(feedback.Items), nil
+}
+
 // FeedbackCh returns the channel for receiving feedback from Tempotown.
-// External components can listen to this to inject signals into the agent.
+// With inject_feedback off, external components can listen to this to
+// handle signals themselves; otherwise the hook submits them as prompts.
 func (h *TempotownHook) FeedbackCh() <-chan FeedbackPayload {
 	return h.feedbackCh
 }
@@ -553,6 +1132,45 @@ func (h *TempotownHook) IsConnected() bool {
 	return h.connected.Load()
 }
 
+// AgentID returns the ID Tempotown assigned this agent when it registered,
+// or "" before then.
+func (h *TempotownHook) AgentID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.agentID
+}
+
+// Reconnect drops the connection, if any, and reconnects without waiting
+// out the backoff, even after the plugin gave up retrying.
+func (h *TempotownHook) Reconnect() {
+	h.mu.Lock()
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.mu.Unlock()
+	h.connected.Store(false)
+	h.publishLink()
+
+	select {
+	case h.reconnectCh <- struct{}{}:
+	default:
+	}
+}
+
+// Reregister registers the agent with Tempotown again over the current
+// connection, for when the orchestrator has lost track of it.
+func (h *TempotownHook) Reregister(ctx context.Context) error {
+	if !h.connected.Load() {
+		return errNotConnected
+	}
+	if err := h.registerAgent(ctx); err != nil {
+		return fmt.Errorf("register_agent: %w", err)
+	}
+	h.publishLink()
+	h.logger.Info("re-registered with Tempotown", "agent_id", h.AgentID())
+	return nil
+}
+
 // MCP Protocol Types (subset needed for client).
 
 // Request is a JSON-RPC request.
@@ -585,11 +1203,21 @@ type Error struct {
 	Data    json.RawMessage `json:"data,omitempty"`
 }
 
+func (e *Error) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
 // InitializeParams is the params for the initialize request.
 type InitializeParams struct {
 	ProtocolVersion string           `json:"protocolVersion"`
 	ClientInfo      Implementation   `json:"clientInfo"`
 	Capabilities    ClientCapability `json:"capabilities"`
+	Meta            *InitializeMeta  `json:"_meta,omitempty"`
+}
+
+// InitializeMeta carries Tempotown extensions to the initialize request.
+type InitializeMeta struct {
+	AuthToken string `json:"authToken,omitempty"`
 }
 
 // Implementation describes a client or server.
@@ -612,6 +1240,19 @@ type RootsCapability struct {
 // SamplingCapability describes sampling capabilities.
 type SamplingCapability struct{}
 
+// InitializeResult is the result of the initialize request.
+type InitializeResult struct {
+	ProtocolVersion string           `json:"protocolVersion"`
+	ServerInfo      Implementation   `json:"serverInfo"`
+	Capabilities    ServerCapability `json:"capabilities"`
+}
+
+// ServerCapability describes server capabilities. Tempotown announces its
+// extensions under Experimental.
+type ServerCapability struct {
+	Experimental map[string]json.RawMessage `json:"experimental,omitempty"`
+}
+
 // ToolCallParams is the params for tools/call.
 type ToolCallParams struct {
 	Name      string          `json:"name"`
@@ -630,10 +1271,15 @@ type Content struct {
 	Text string `json:"text,omitempty"`
 }
 
-// FeedbackPayload is feedback from Tempotown.
+// FeedbackPayload is feedback from Tempotown. Type is empty for feedback
+// meant for the model, FeedbackPause or FeedbackResume, or a session command
+// such as FeedbackSwitchSession. SessionID, or else the session of TaskID,
+// picks the session it goes to.
 type FeedbackPayload struct {
-	Message  string         `json:"message"`
-	Source   string         `json:"source"`
-	TaskID   string         `json:"task_id,omitempty"`
-	Metadata map[string]any `json:"metadata,omitempty"`
+	Type      string         `json:"type,omitempty"`
+	Message   string         `json:"message"`
+	Source    string         `json:"source"`
+	TaskID    string         `json:"task_id,omitempty"`
+	SessionID string         `json:"session_id,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
 }
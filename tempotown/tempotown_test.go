@@ -1,151 +1,137 @@
 package tempotown
 
 import (
-	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"charm.land/fantasy"
+	"github.com/aleksclark/crush-modules/agentcaps"
+	"github.com/aleksclark/crush-modules/agentcontrol"
+	"github.com/aleksclark/crush-modules/agentlink"
+	"github.com/aleksclark/crush-modules/agentmeter"
+	"github.com/aleksclark/crush-modules/testutil/mockmcp"
+	"github.com/charmbracelet/crush/plugin"
 	"github.com/stretchr/testify/require"
 )
 
-// mockMCPServer simulates a Tempotown MCP server for testing.
+// mockMCPServer simulates a Tempotown MCP server for testing: a mockmcp
+// server answering Tempotown's tools.
 type mockMCPServer struct {
-	listener net.Listener
-	handlers map[string]func(json.RawMessage) (any, error)
+	*mockmcp.Server
+
 	mu       sync.Mutex
-	calls    []string
+	feedback []FeedbackPayload
+	tasks    []Task
+	// approval is the request_approval result; without one no decision is
+	// returned.
+	approval string
+	// holders are the agents holding resources; claim_resource fails for
+	// them.
+	holders map[string]string
 }
 
 func newMockMCPServer(t *testing.T) *mockMCPServer {
 	t.Helper()
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	require.NoError(t, err)
-
-	s := &mockMCPServer{
-		listener: listener,
-		handlers: make(map[string]func(json.RawMessage) (any, error)),
-	}
-
-	// Default handlers.
-	s.handlers["initialize"] = func(_ json.RawMessage) (any, error) {
-		return map[string]any{
-			"protocolVersion": "2024-11-05",
-			"serverInfo":      map[string]string{"name": "mock-tempotown", "version": "0.1.0"},
-			"capabilities":    map[string]any{"tools": map[string]bool{"listChanged": true}},
-		}, nil
-	}
-
-	s.handlers["tools/call"] = func(params json.RawMessage) (any, error) {
-		var p ToolCallParams
-		if err := json.Unmarshal(params, &p); err != nil {
-			return nil, err
-		}
-
-		s.mu.Lock()
-		s.calls = append(s.calls, p.Name)
-		s.mu.Unlock()
-
-		switch p.Name {
-		case "register_agent":
-			return map[string]any{
-				"content": []map[string]string{{"type": "text", "text": `{"agent_id":"test-agent-123"}`}},
-			}, nil
-		case "report_status":
-			return map[string]any{
-				"content": []map[string]string{{"type": "text", "text": `{"ok":true}`}},
-			}, nil
-		case "get_pending_feedback":
-			return map[string]any{
-				"content": []map[string]string{{"type": "text", "text": `{"items":[]}`}},
-			}, nil
-		default:
-			return map[string]any{
-				"content": []map[string]string{{"type": "text", "text": `{}`}},
-			}, nil
-		}
-	}
-
-	go s.serve()
-	return s
+	return newTempotownMock(mockmcp.NewServer(t))
 }
 
-func (s *mockMCPServer) serve() {
-	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			return
-		}
-		go s.handleConn(conn)
-	}
+// newMockTLSMCPServer starts a mock server behind TLS and returns it with
+// the path of a PEM file holding its self-signed certificate.
+func newMockTLSMCPServer(t *testing.T) (*mockMCPServer, string) {
+	t.Helper()
+	server, caFile := mockmcp.NewTLSServer(t)
+	return newTempotownMock(server), caFile
 }
 
-func (s *mockMCPServer) handleConn(conn net.Conn) {
-	defer conn.Close()
-	reader := bufio.NewReader(conn)
-	decoder := json.NewDecoder(reader)
-	encoder := json.NewEncoder(conn)
-
-	for {
-		var req Request
-		if err := decoder.Decode(&req); err != nil {
-			return
-		}
+// newMockWebSocketMCPServer starts a mock server that accepts WebSocket
+// connections and returns it with its ws:// URL.
+func newMockWebSocketMCPServer(t *testing.T) (*mockMCPServer, string) {
+	t.Helper()
+	server, url := mockmcp.NewWebSocketServer(t)
+	return newTempotownMock(server), url
+}
 
-		// Notifications have no ID.
-		if req.ID == nil {
-			continue
-		}
+// newTempotownMock installs Tempotown's tools on server.
+func newTempotownMock(server *mockmcp.Server) *mockMCPServer {
+	s := &mockMCPServer{Server: server}
+	server.SetServerInfo("mock-tempotown", "0.1.0")
 
-		handler, ok := s.handlers[req.Method]
-		if !ok {
-			resp := Response{
-				JSONRPC: "2.0",
-				ID:      req.ID,
-				Error:   &Error{Code: -32601, Message: "method not found"},
-			}
-			encoder.Encode(resp)
-			continue
+	server.HandleTool("register_agent", func(json.RawMessage) (any, error) {
+		return mockmcp.TextResult(`{"agent_id":"test-agent-123"}`), nil
+	})
+	server.HandleTool("report_status", func(json.RawMessage) (any, error) {
+		return mockmcp.TextResult(`{"ok":true}`), nil
+	})
+	server.HandleTool("get_pending_feedback", func(json.RawMessage) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		items := append([]FeedbackPayload{}, s.feedback...)
+		s.feedback = nil
+		return mockmcp.JSONResult(map[string]any{"items": items}), nil
+	})
+	server.HandleTool("get_next_task", func(json.RawMessage) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		next := map[string]any{"task": nil}
+		if len(s.tasks) > 0 {
+			next["task"] = s.tasks[0]
+			s.tasks = s.tasks[1:]
 		}
-
-		result, err := handler(req.Params)
-		if err != nil {
-			resp := Response{
-				JSONRPC: "2.0",
-				ID:      req.ID,
-				Error:   &Error{Code: -32000, Message: err.Error()},
-			}
-			encoder.Encode(resp)
-			continue
+		return mockmcp.JSONResult(next), nil
+	})
+	server.HandleTool("request_approval", func(json.RawMessage) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return mockmcp.TextResult(cmp.Or(s.approval, `{}`)), nil
+	})
+	server.HandleTool("claim_resource", func(params json.RawMessage) (any, error) {
+		var args struct {
+			Resource string `json:"resource"`
 		}
-
-		resultJSON, _ := json.Marshal(result)
-		resp := Response{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result:  resultJSON,
+		_ = json.Unmarshal(params, &args)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if holder := s.holders[args.Resource]; holder != "" {
+			return mockmcp.TextResult(fmt.Sprintf(`{"claimed":false,"holder":%q}`, holder)), nil
 		}
-		encoder.Encode(resp)
-	}
-}
-
-func (s *mockMCPServer) addr() string {
-	return s.listener.Addr().String()
+		return mockmcp.TextResult(`{"claimed":true}`), nil
+	})
+	server.HandleTool("list_agents", func(json.RawMessage) (any, error) {
+		return mockmcp.TextResult(`{"agents":[` +
+			`{"agent_id":"test-agent-123","role":"coder","status":"working","current_task":"task-7"},` +
+			`{"agent_id":"agent-2","role":"reviewer","status":"idle"}]}`), nil
+	})
+	server.DefaultTool(func(json.RawMessage) (any, error) {
+		return mockmcp.TextResult(`{}`), nil
+	})
+	return s
 }
 
-func (s *mockMCPServer) close() {
-	s.listener.Close()
+// enablePush announces pushed feedback in the initialize result.
+func (s *mockMCPServer) enablePush() {
+	s.SetCapability("experimental", map[string]any{FeedbackPushCapability: map[string]any{}})
 }
 
-func (s *mockMCPServer) getCalls() []string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	result := make([]string, len(s.calls))
-	copy(result, s.calls)
-	return result
+// getInit returns the params of the last initialize request.
+func (s *mockMCPServer) getInit() InitializeParams {
+	var p InitializeParams
+	_ = json.Unmarshal(s.InitParams(), &p)
+	return p
 }
 
 func TestNewTempotownHook(t *testing.T) {
@@ -188,10 +174,10 @@ func TestConnect(t *testing.T) {
 	t.Parallel()
 
 	server := newMockMCPServer(t)
-	defer server.close()
+	defer server.Close()
 
 	cfg := Config{
-		Endpoint: server.addr(),
+		Endpoint: server.Addr(),
 		Role:     "coder",
 	}
 
@@ -207,10 +193,41 @@ func TestConnect(t *testing.T) {
 	require.Equal(t, "test-agent-123", hook.agentID)
 
 	// Verify register_agent was called.
-	calls := server.getCalls()
+	calls := server.ToolNames()
 	require.Contains(t, calls, "register_agent")
 }
 
+func TestBackoffDelay(t *testing.T) {
+	t.Parallel()
+
+	const base, maxDelay = 5 * time.Second, time.Minute
+	for failures, want := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		4:  40 * time.Second,
+		5:  time.Minute,
+		50: time.Minute,
+	} {
+		require.Equal(t, want, backoffDelay(failures, base, maxDelay, 0.5), "failures=%d", failures)
+	}
+
+	// Jitter stays within 20% either way.
+	require.Equal(t, 4*time.Second, backoffDelay(1, base, maxDelay, 0))
+	require.Less(t, backoffDelay(9, base, maxDelay, 0.9999), 72*time.Second)
+	require.Greater(t, backoffDelay(9, base, maxDelay, 0.9999), 71*time.Second)
+
+	// A cap below the base wins.
+	require.Equal(t, time.Second, backoffDelay(3, base, time.Second, 0.5))
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: "localhost:9090"})
+	require.NoError(t, err)
+	require.Equal(t, int(DefaultMaxReconnectDelay/time.Second), hook.cfg.ReconnectMaxSeconds)
+	for range 20 {
+		require.LessOrEqual(t, hook.reconnectDelay(100), DefaultMaxReconnectDelay*6/5)
+	}
+}
+
 func TestConnectFailure(t *testing.T) {
 	t.Parallel()
 
@@ -229,14 +246,289 @@ func TestConnectFailure(t *testing.T) {
 	require.False(t, hook.IsConnected())
 }
 
+func TestConnectTLS(t *testing.T) {
+	t.Parallel()
+
+	server, caFile := newMockTLSMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{
+		Endpoint:  server.Addr(),
+		TLS:       true,
+		CAFile:    caFile,
+		AuthToken: "s3cret",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.True(t, hook.IsConnected())
+	require.NotNil(t, server.getInit().Meta)
+	require.Equal(t, "s3cret", server.getInit().Meta.AuthToken)
+}
+
+func TestConnectTLSUntrusted(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newMockTLSMCPServer(t)
+	defer server.Close()
+
+	// The self-signed certificate is not in the system roots.
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), TLS: true})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = hook.connect(ctx)
+	require.Error(t, err)
+	require.False(t, hook.IsConnected())
+}
+
+func TestConnectWebSocket(t *testing.T) {
+	t.Parallel()
+
+	server, endpoint := newMockWebSocketMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{
+		Endpoint:  endpoint,
+		Transport: TransportWebSocket,
+		AuthToken: "s3cret",
+	})
+	require.NoError(t, err)
+	defer hook.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.True(t, hook.IsConnected())
+	require.Equal(t, "test-agent-123", hook.agentID)
+
+	_, err = hook.callTool(ctx, "report_status", map[string]any{"status": "testing"})
+	require.NoError(t, err)
+	require.Contains(t, server.ToolNames(), "report_status")
+
+	require.Equal(t, "Bearer s3cret", server.Auth())
+}
+
+// TestStdioServerProcess is not a real test: it is the MCP server that
+// TestConnectStdio runs as a subprocess.
+func TestStdioServerProcess(t *testing.T) {
+	if len(os.Args) == 0 || os.Args[len(os.Args)-1] != "stdio-server" {
+		t.Skip("only run as a subprocess of TestConnectStdio")
+	}
+	server := newTempotownMock(mockmcp.New())
+	// hang_up exits without answering, as a crashing server would.
+	server.Handle("hang_up", func(json.RawMessage) (any, error) {
+		os.Exit(0)
+		return nil, nil
+	})
+	server.ServeConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{os.Stdin, os.Stdout, os.Stdin})
+	os.Exit(0)
+}
+
+func TestConnectStdio(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{
+		Transport: TransportStdio,
+		Command:   os.Args[0],
+		Args:      []string{"-test.run=^TestStdioServerProcess$", "stdio-server"},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, hook)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done, err := hook.connect(ctx)
+	require.NoError(t, err)
+	require.True(t, hook.IsConnected())
+	require.Equal(t, "test-agent-123", hook.agentID)
+
+	require.NoError(t, hook.Stop())
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("read loop should end when the server process is stopped")
+	}
+}
+
+func TestStdioServerReaped(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{
+		Transport: TransportStdio,
+		Command:   os.Args[0],
+		Args:      []string{"-test.run=^TestStdioServerProcess$", "stdio-server"},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done, err := hook.connect(ctx)
+	require.NoError(t, err)
+	hook.mu.Lock()
+	conn := hook.conn.(*stdioConn)
+	hook.mu.Unlock()
+
+	_, err = hook.call(ctx, "hang_up", nil)
+	require.Error(t, err)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("read loop should end when the server process exits")
+	}
+	require.NotNil(t, conn.cmd.ProcessState, "the exited server was not waited for")
+}
+
+func TestTransportConfig(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: "localhost:9090"})
+	require.NoError(t, err)
+	require.Equal(t, TransportTCP, hook.cfg.Transport)
+
+	_, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", Transport: "carrier-pigeon"})
+	require.ErrorContains(t, err, "unknown transport")
+
+	_, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", Transport: TransportStdio})
+	require.ErrorContains(t, err, "requires a command")
+
+	_, err = NewTempotownHook(nil, Config{Command: "tempotown-mcp"})
+	require.ErrorContains(t, err, "requires an endpoint")
+
+	hook, err = NewTempotownHook(nil, Config{Endpoint: "tempotown.example.com/mcp", Transport: TransportWebSocket, TLS: true})
+	require.NoError(t, err)
+	require.Equal(t, "wss://tempotown.example.com/mcp", hook.webSocketURL())
+
+	hook, err = NewTempotownHook(nil, Config{Endpoint: "ws://localhost:9090/mcp", Transport: TransportWebSocket})
+	require.NoError(t, err)
+	require.Equal(t, "ws://localhost:9090/mcp", hook.webSocketURL())
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: "localhost:9090"})
+	require.NoError(t, err)
+	require.Nil(t, hook.tlsConfig)
+
+	_, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	require.ErrorContains(t, err, "read ca_file")
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o644))
+	_, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", CAFile: notPEM})
+	require.ErrorContains(t, err, "no PEM certificates")
+
+	require.True(t, isLoopback("localhost:9090"))
+	require.True(t, isLoopback("127.0.0.1:9090"))
+	require.True(t, isLoopback("[::1]:9090"))
+	require.True(t, isLoopback("ws://localhost:9090/mcp"))
+	require.False(t, isLoopback("tempotown.example.com:9090"))
+	require.False(t, isLoopback("wss://tempotown.example.com/mcp"))
+}
+
+// newMDNSResponder answers DNS-SD queries for service with an instance
+// named instance at addr, like an orchestrator announcing itself over mDNS.
+// A malformed packet and an unrelated announcement are sent first.
+func newMDNSResponder(t *testing.T, service, instance string, addr *net.TCPAddr, txt ...string) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	record := func(msg []byte, name string, typ uint16, rdata []byte) []byte {
+		msg = appendDNSName(msg, name)
+		msg = binary.BigEndian.AppendUint16(msg, typ)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		msg = binary.BigEndian.AppendUint32(msg, 120)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+		return append(msg, rdata...)
+	}
+	announce := func(service, instance string) []byte {
+		msg := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 3}
+		full := instance + "." + service
+		msg = record(msg, service, dnsTypePTR, appendDNSName(nil, full))
+		srv := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, uint16(addr.Port))
+		msg = record(msg, full, dnsTypeSRV, appendDNSName(srv, "orchestrator.local."))
+		var txtData []byte
+		for _, kv := range txt {
+			txtData = append(append(txtData, byte(len(kv))), kv...)
+		}
+		msg = record(msg, full, dnsTypeTXT, txtData)
+		return record(msg, "orchestrator.local.", dnsTypeA, addr.IP.To4())
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !bytes.Contains(buf[:n], []byte(strings.Split(service, ".")[0])) {
+				continue
+			}
+			_, _ = conn.WriteToUDP([]byte{0, 0, 0x84}, from)
+			_, _ = conn.WriteToUDP(announce("_other._tcp.local.", "printer"), from)
+			_, _ = conn.WriteToUDP(announce(service, instance), from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestDiscovery(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+	addr, err := net.ResolveTCPAddr("tcp", server.Addr())
+	require.NoError(t, err)
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: EndpointAuto, HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	require.Equal(t, DefaultDiscoveryService, hook.cfg.DiscoveryService)
+	hook.mdnsAddr = newMDNSResponder(t, "_tempotown._tcp.local.", "lab", addr, "path=/mcp")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.Equal(t, server.Addr(), hook.endpoint())
+	require.Equal(t, server.Addr(), hook.Snapshot().Endpoint)
+	require.Equal(t, "lab", hook.discovered.Instance)
+	require.Equal(t, "orchestrator.local", hook.serverName())
+	require.Equal(t, server.Addr()+"/mcp", hook.discovered.endpoint(TransportWebSocket))
+
+	// Nothing answers for another service.
+	hook, err = NewTempotownHook(nil, Config{Endpoint: EndpointAuto, DiscoveryService: "_crew._tcp"})
+	require.NoError(t, err)
+	hook.mdnsAddr = newMDNSResponder(t, "_tempotown._tcp.local.", "lab", addr)
+	_, err = discover(ctx, hook.mdnsAddr, hook.cfg.DiscoveryService, 200*time.Millisecond)
+	require.ErrorIs(t, err, errNoOrchestrator)
+}
+
 func TestCallTool(t *testing.T) {
 	t.Parallel()
 
 	server := newMockMCPServer(t)
-	defer server.close()
+	defer server.Close()
 
 	cfg := Config{
-		Endpoint: server.addr(),
+		Endpoint: server.Addr(),
 	}
 
 	hook, err := NewTempotownHook(nil, cfg)
@@ -255,7 +547,7 @@ func TestCallTool(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	calls := server.getCalls()
+	calls := server.ToolNames()
 	require.Contains(t, calls, "report_status")
 }
 
@@ -284,3 +576,1544 @@ func TestFeedbackChannel(t *testing.T) {
 		t.Fatal("should receive from channel")
 	}
 }
+
+// recordingSubmitter records the prompts submitted to it.
+type recordingSubmitter struct {
+	mu      sync.Mutex
+	prompts []submittedPrompt
+}
+
+type submittedPrompt struct {
+	sessionID string
+	prompt    string
+	at        time.Time
+}
+
+func (r *recordingSubmitter) SubmitPrompt(ctx context.Context, prompt string) error {
+	return r.SubmitPromptToSession(ctx, "", prompt)
+}
+
+func (r *recordingSubmitter) SubmitPromptToSession(_ context.Context, sessionID, prompt string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts = append(r.prompts, submittedPrompt{sessionID, prompt, time.Now()})
+	return nil
+}
+
+func (r *recordingSubmitter) submitted() []submittedPrompt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]submittedPrompt(nil), r.prompts...)
+}
+
+func TestFormatFeedback(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "[Tempotown] Feedback from supervisor on task task-7:\nAdd tests for the parser.\n\n"+
+		"[Tempotown] Feedback from Tempotown:\nWrap up.",
+		formatFeedback([]FeedbackPayload{
+			{Source: "supervisor", TaskID: "task-7", Message: "Add tests for the parser.\n"},
+			{Message: "Wrap up."},
+		}))
+}
+
+func TestFeedbackInjection(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: "localhost:9090"})
+	require.NoError(t, err)
+	require.True(t, hook.injectFeedback())
+	hook.quietPeriod = 200 * time.Millisecond
+
+	// The agent is busy in a session when feedback arrives.
+	hook.noteActivity("session-1")
+	busyAt := time.Now()
+	hook.feedbackCh <- FeedbackPayload{Source: "supervisor", Message: "first"}
+	hook.feedbackCh <- FeedbackPayload{Source: "reviewer", Message: "second"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	submitter := &recordingSubmitter{}
+	go hook.feedbackLoop(ctx, submitter)
+
+	require.Eventually(t, func() bool { return len(submitter.submitted()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	got := submitter.submitted()[0]
+	require.Equal(t, "session-1", got.sessionID)
+	require.Contains(t, got.prompt, "Feedback from supervisor:\nfirst")
+	require.Contains(t, got.prompt, "Feedback from reviewer:\nsecond")
+	require.GreaterOrEqual(t, got.at.Sub(busyAt), hook.quietPeriod)
+
+	off := false
+	hook, err = NewTempotownHook(nil, Config{Endpoint: "localhost:9090", InjectFeedback: &off})
+	require.NoError(t, err)
+	require.False(t, hook.injectFeedback())
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tool := NewTaskTool(hook)
+	resp, err := tool.Run(ctx, fantasy.ToolCall{ID: "1", Name: TaskToolName, Input: `{"action":"accept","task_id":"task-7"}`})
+	require.NoError(t, err)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "not connected")
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "2", Name: TaskToolName, Input: `{"action":"accept","task_id":"task-7"}`})
+	require.NoError(t, err)
+	require.False(t, resp.IsError, resp.Content)
+	require.Equal(t, "Accepted task task-7.", resp.Content)
+	require.Equal(t, "task-7", hook.CurrentTask())
+	require.Equal(t, map[string]any{"task_id": "task-7", "agent_id": "test-agent-123"}, server.Args("accept_task"))
+
+	// Completing defaults to the current task; plain text becomes a summary.
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "3", Name: TaskToolName, Input: `{"action":"complete","result":"Added parser tests."}`})
+	require.NoError(t, err)
+	require.Equal(t, "Completed task task-7.", resp.Content)
+	require.Empty(t, hook.CurrentTask())
+	require.Equal(t, map[string]any{"summary": "Added parser tests."}, server.Args("complete_task")["result"])
+
+	// A JSON object result is sent as is.
+	_, err = hook.updateTask(ctx, TaskComplete, "task-8", `{"files_changed": 3}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"files_changed": float64(3)}, server.Args("complete_task")["result"])
+
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "4", Name: TaskToolName, Input: `{"action":"fail"}`})
+	require.NoError(t, err)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "no task has been accepted")
+
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "5", Name: TaskToolName, Input: `{"action":"pause","task_id":"task-7"}`})
+	require.NoError(t, err)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "unknown action")
+}
+
+func TestTaskMarkers(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, []taskMarker{
+		{action: TaskComplete, taskID: "task-7", text: "All green."},
+		{action: TaskFail, text: "CI is down."},
+	}, parseTaskMarkers("Done.\n<task-complete task=\"task-7\">All green.</task-complete>\n<task-failed>\nCI is down.\n</task-failed>"))
+	require.Empty(t, parseTaskMarkers("Still working on <task-complete>"))
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	_, err = hook.updateTask(ctx, TaskAccept, "task-9", "")
+	require.NoError(t, err)
+
+	// A marker is reported once even though the message keeps updating.
+	for range 3 {
+		hook.handleTaskMarkers(ctx, "msg-1", "Giving up. <task-failed>flaky CI</task-failed>")
+	}
+	require.Eventually(t, func() bool { return hook.CurrentTask() == "" }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, "flaky CI", server.Args("fail_task")["reason"])
+	require.Equal(t, "task-9", server.Args("fail_task")["task_id"])
+
+	time.Sleep(50 * time.Millisecond)
+	var fails int
+	for _, call := range server.ToolNames() {
+		if call == "fail_task" {
+			fails++
+		}
+	}
+	require.Equal(t, 1, fails)
+}
+
+func TestEnsembleTool(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	tool := NewEnsembleTool(hook)
+	run := func(input string) fantasy.ToolResponse {
+		t.Helper()
+		resp, err := tool.Run(ctx, fantasy.ToolCall{ID: "1", Name: EnsembleToolName, Input: input})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := run(`{"action":"members"}`)
+	require.False(t, resp.IsError, resp.Content)
+	require.Equal(t, "- test-agent-123 (coder) [you]: working, task task-7\n- agent-2 (reviewer): idle", resp.Content)
+
+	require.Equal(t, "No pending feedback.", run(`{"action":"feedback"}`).Content)
+	server.mu.Lock()
+	server.feedback = []FeedbackPayload{{Source: "supervisor", TaskID: "task-7", Message: "Add tests."}}
+	server.mu.Unlock()
+	require.Equal(t, "[Tempotown] Feedback from supervisor on task task-7:\nAdd tests.", run(`{"action":"feedback"}`).Content)
+
+	_, err = hook.updateTask(ctx, TaskAccept, "task-7", "")
+	require.NoError(t, err)
+	resp = run(`{"action":"request_review","message":"Check the parser."}`)
+	require.Equal(t, "Requested a review of task task-7 from reviewer.", resp.Content)
+	require.Equal(t, map[string]any{
+		"agent_id": "test-agent-123",
+		"reviewer": DefaultReviewer,
+		"message":  "Check the parser.",
+		"task_id":  "task-7",
+	}, server.Args("request_review"))
+
+	resp = run(`{"action":"complete","result":"Done."}`)
+	require.Equal(t, "Completed task task-7.", resp.Content)
+	require.Empty(t, hook.CurrentTask())
+
+	resp = run(`{"action":"dance"}`)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "unknown action")
+}
+
+func TestEnsembleStatus(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tool := NewEnsembleStatusTool(hook)
+	run := func(input string) fantasy.ToolResponse {
+		t.Helper()
+		resp, err := tool.Run(ctx, fantasy.ToolCall{ID: "1", Name: EnsembleStatusToolName, Input: input})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := run(`{}`)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "not connected")
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	resp = run(`{}`)
+	require.False(t, resp.IsError, resp.Content)
+	require.Equal(t, ""+
+		"| Agent                | Role     | Status  | Task   |\n"+
+		"| -------------------- | -------- | ------- | ------ |\n"+
+		"| test-agent-123 (you) | coder    | working | task-7 |\n"+
+		"| agent-2              | reviewer | idle    | -      |", resp.Content)
+	require.Equal(t, ""+
+		"| Agent   | Role     | Status | Task |\n"+
+		"| ------- | -------- | ------ | ---- |\n"+
+		"| agent-2 | reviewer | idle   | -    |", run(`{"role":"Reviewer"}`).Content)
+	require.Equal(t, "No merger agents are registered.", run(`{"role":"merger"}`).Content)
+
+	// The dialog shows the same table to the user.
+	d := newEnsembleDialog(hook)
+	require.NoError(t, d.Init())
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "| agent-2              | reviewer | idle    | -      |")
+	}, 2*time.Second, 10*time.Millisecond)
+	done, _, err := d.Update(plugin.KeyEvent{Key: "esc"})
+	require.NoError(t, err)
+	require.True(t, done)
+}
+
+func TestPushedFeedback(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without the capability, feedback is polled.
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.False(t, hook.pushFeedback.Load())
+	require.NoError(t, hook.Stop())
+
+	server.enablePush()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.True(t, hook.pushFeedback.Load())
+
+	server.Broadcast(Notification{JSONRPC: "2.0", Method: FeedbackNotification,
+		Params: json.RawMessage(`{"source":"supervisor","task_id":"task-7","message":"Add tests."}`)})
+	server.Broadcast(Notification{JSONRPC: "2.0", Method: FeedbackNotification,
+		Params: json.RawMessage(`{"items":[{"source":"reviewer","message":"LGTM"},{"source":"merger","message":"Merged"}]}`)})
+	server.Broadcast(Notification{JSONRPC: "2.0", Method: "notifications/progress", Params: json.RawMessage(`{}`)})
+
+	var got []string
+	for range 3 {
+		select {
+		case fb := <-hook.FeedbackCh():
+			got = append(got, fb.Source+": "+fb.Message)
+		case <-ctx.Done():
+			t.Fatal("pushed feedback was not received")
+		}
+	}
+	require.Equal(t, []string{"supervisor: Add tests.", "reviewer: LGTM", "merger: Merged"}, got)
+
+	// Requests from the server are answered.
+	server.Broadcast(Request{JSONRPC: "2.0", ID: 90, Method: "ping"})
+	server.Broadcast(Request{JSONRPC: "2.0", ID: 91, Method: "sampling/createMessage"})
+	require.Eventually(t, func() bool { return len(server.Replies()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	replies := server.Replies()
+	require.Equal(t, float64(90), replies[0].ID)
+	require.Nil(t, replies[0].Error)
+	require.JSONEq(t, `{}`, string(replies[0].Result))
+	require.Equal(t, float64(91), replies[1].ID)
+	require.Equal(t, -32601, replies[1].Error.Code)
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	require.Equal(t, DefaultHeartbeatInterval, hook.heartbeat)
+	hook.heartbeat = 50 * time.Millisecond
+	hook.heartbeatTimeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done, err := hook.connect(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return server.Pings() >= 3
+	}, 2*time.Second, 10*time.Millisecond)
+	require.True(t, hook.IsConnected())
+
+	// A server that stops answering is detected and the connection dropped.
+	server.Stall(true)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection was not detected")
+	}
+	require.False(t, hook.IsConnected())
+
+	off, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	require.Zero(t, off.heartbeat)
+}
+
+func TestHeartbeatErrorReply(t *testing.T) {
+	t.Parallel()
+
+	// A server that does not support ping still answers, so it is alive.
+	server := newMockMCPServer(t)
+	server.Handle("ping", nil)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	hook.heartbeat = 20 * time.Millisecond
+	hook.heartbeatTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done, err := hook.connect(ctx)
+	require.NoError(t, err)
+	select {
+	case <-done:
+		t.Fatal("connection dropped although the server answered")
+	case <-time.After(300 * time.Millisecond):
+	}
+	require.True(t, hook.IsConnected())
+	require.NoError(t, hook.Stop())
+}
+
+func TestConnectionStable(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without heartbeats only the time up counts.
+	quiet, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	require.Equal(t, StableConnectionTime, quiet.stableAfter)
+	_, err = quiet.connect(ctx)
+	require.NoError(t, err)
+	require.False(t, quiet.connectionStable(time.Now()))
+	require.True(t, quiet.connectionStable(time.Now().Add(-StableConnectionTime)))
+	require.NoError(t, quiet.Stop())
+
+	// An answered heartbeat proves the connection before that.
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	hook.heartbeat = 20 * time.Millisecond
+	hook.heartbeatTimeout = 50 * time.Millisecond
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	connectedAt := time.Now()
+	require.Eventually(t, func() bool {
+		return hook.connectionStable(connectedAt)
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, hook.Stop())
+
+	// A connection dropped before any heartbeat was answered is a failed
+	// attempt, so the backoff keeps growing.
+	flaky, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	flaky.heartbeat = 50 * time.Millisecond
+	flaky.heartbeatTimeout = 50 * time.Millisecond
+	done, err := flaky.connect(ctx)
+	require.NoError(t, err)
+	connectedAt = time.Now()
+	server.Stall(true)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection was not detected")
+	}
+	require.False(t, flaky.connectionStable(connectedAt))
+}
+
+type fakeSessionInfo struct {
+	info plugin.SessionInfo
+}
+
+func (f *fakeSessionInfo) SessionInfo() *plugin.SessionInfo {
+	return &f.info
+}
+
+func TestStatusContext(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	dir := t.TempDir()
+	sip := &fakeSessionInfo{info: plugin.SessionInfo{
+		Model:    "claude-sonnet",
+		Provider: "anthropic",
+		CostUSD:  0.25,
+		Tokens:   plugin.TokenInfo{Input: 1200, Output: 300, CacheRead: 50},
+	}}
+	app := plugin.NewApp(plugin.WithWorkingDir(dir), plugin.WithSessionInfoProvider(sip))
+	hook, err := NewTempotownHook(app, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+
+	edit := func(name, path string, finished bool) plugin.ToolCallInfo {
+		input, _ := json.Marshal(map[string]string{"file_path": path})
+		return plugin.ToolCallInfo{ID: path, Name: name, Input: string(input), Finished: finished}
+	}
+	hook.noteToolCalls([]plugin.ToolCallInfo{
+		edit("edit", filepath.Join(dir, "main.go"), true),
+		edit("write", "/elsewhere/notes.md", true),
+		edit("edit", filepath.Join(dir, "pending.go"), false),
+		edit("view", filepath.Join(dir, "read.go"), true),
+	})
+	hook.noteToolCalls([]plugin.ToolCallInfo{edit("multiedit", filepath.Join(dir, "main.go"), true)})
+	hook.setTask("task-3", "working")
+
+	details := hook.statusContext()
+	require.Equal(t, "claude-sonnet", details["model"])
+	require.Equal(t, "anthropic", details["provider"])
+	require.Equal(t, 0.25, details["cost_usd"])
+	require.Equal(t, map[string]any{
+		"input":       int64(1200),
+		"output":      int64(300),
+		"cache_read":  int64(50),
+		"cache_write": int64(0),
+	}, details["tokens"])
+	require.Equal(t, "task-3", details["task_id"])
+	require.Equal(t, []string{"main.go", "/elsewhere/notes.md"}, details["recent_files"])
+	require.NotContains(t, details, "git_branch")
+
+	// Only the most recent files are kept.
+	for i := range maxRecentFiles + 2 {
+		hook.noteToolCalls([]plugin.ToolCallInfo{edit("write", filepath.Join(dir, fmt.Sprintf("f%d.go", i)), true)})
+	}
+	files := hook.statusContext()["recent_files"].([]string)
+	require.Len(t, files, maxRecentFiles)
+	require.Equal(t, "f11.go", files[0])
+
+	// Status reports carry the context along with their own details.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	hook.reportStatus("running tool: edit", 50, map[string]any{"tool": "edit"})
+	require.Eventually(t, func() bool {
+		return server.Args("report_status") != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	reported := server.Args("report_status")["details"].(map[string]any)
+	require.Equal(t, "edit", reported["tool"])
+	require.Equal(t, "claude-sonnet", reported["model"])
+	require.Equal(t, "task-3", reported["task_id"])
+	require.Equal(t, float64(1200), reported["tokens"].(map[string]any)["input"])
+}
+
+func TestGitBranch(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	out, err := exec.Command("git", "-C", dir, "init", "-q", "-b", "feature/status").CombinedOutput()
+	require.NoError(t, err, string(out))
+	out, err = exec.Command("git", "-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	hook, err := NewTempotownHook(plugin.NewApp(plugin.WithWorkingDir(dir)), Config{Endpoint: "localhost:1"})
+	require.NoError(t, err)
+	require.Equal(t, "feature/status", hook.statusContext()["git_branch"])
+
+	// The branch is cached between reports.
+	out, err = exec.Command("git", "-C", dir, "checkout", "-q", "-b", "other").CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Equal(t, "feature/status", hook.statusContext()["git_branch"])
+}
+
+func TestStatusDialog(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), Role: "reviewer"})
+	require.NoError(t, err)
+
+	d := newDialog(hook)
+	view := d.View()
+	require.Contains(t, view, "Connection: Disconnected (tcp "+server.Addr()+")")
+	require.Contains(t, view, "Agent ID: (not registered)")
+	require.Contains(t, view, "Last Status: none yet")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go hook.connectionLoop(ctx)
+	require.Eventually(t, hook.IsConnected, 2*time.Second, 10*time.Millisecond)
+
+	hook.reportStatus("generating response", 50, nil)
+	_, err = hook.call(ctx, "bogus", nil)
+	require.Error(t, err)
+	hook.queueFeedback(ctx, []FeedbackPayload{{Message: "check the tests", Source: "reviewer"}})
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "Last Status: generating response (50%)")
+	}, 2*time.Second, 10*time.Millisecond)
+	view = d.View()
+	require.Contains(t, view, "Connection: Connected")
+	require.Contains(t, view, "Agent ID: test-agent-123")
+	require.Contains(t, view, "Role: reviewer")
+	require.Contains(t, view, "Pending Feedback: 1")
+	require.Contains(t, view, "bogus: RPC error -32601: method not found")
+
+	registrations := func() int {
+		n := 0
+		for _, call := range server.ToolNames() {
+			if call == "register_agent" {
+				n++
+			}
+		}
+		return n
+	}
+	require.Equal(t, 1, registrations())
+
+	// Re-register over the same connection.
+	_, _, err = d.Update(plugin.KeyEvent{Key: "g"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "Re-registered as test-agent-123.")
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, registrations())
+
+	// Reconnecting skips the reconnect delay.
+	_, _, err = d.Update(plugin.KeyEvent{Key: "r"})
+	require.NoError(t, err)
+	require.Contains(t, d.View(), "Reconnecting...")
+	require.Eventually(t, func() bool {
+		return registrations() == 3 && hook.IsConnected()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	done, _, err := d.Update(plugin.KeyEvent{Key: "esc"})
+	require.NoError(t, err)
+	require.True(t, done)
+}
+
+func TestSetRole(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	off := false
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1, AutoCapabilities: &off, Capabilities: []string{"go"}})
+	require.NoError(t, err)
+	hook.link = agentlink.New()
+
+	// Before connecting, the change applies to the first registration.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, hook.SetRole(ctx, "reviewer", nil))
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "reviewer", server.Args("register_agent")["role"])
+	require.NoError(t, hook.acceptTask(ctx, "task-7"))
+
+	// The dialog cycles to the next role and registers again, keeping the
+	// task.
+	d := newDialog(hook)
+	_, _, err = d.Update(plugin.KeyEvent{Key: "o"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(d.View(), "Role is now merger.")
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, "merger", server.Args("register_agent")["role"])
+	require.Equal(t, "task-7", hook.Snapshot().Task)
+	require.Equal(t, "merger", hook.link.Orchestrator().Role)
+
+	require.NoError(t, hook.SetRole(ctx, "", []string{"go", "gpu"}))
+	require.Equal(t, "merger", server.Args("register_agent")["role"])
+	require.Equal(t, []any{"go", "gpu"}, server.Args("register_agent")["capabilities"])
+
+	require.NoError(t, hook.SetRole(ctx, "supervisor", nil))
+	require.Equal(t, "coder", hook.nextRole(), "cycling wraps around")
+	require.ErrorContains(t, hook.ReloadRole(ctx), "no configuration")
+}
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	hook.quietPeriod = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	submitter := &recordingSubmitter{}
+	go hook.feedbackLoop(ctx, submitter)
+
+	reported := func(status string) func() bool {
+		return func() bool {
+			args := server.Args("report_status")
+			return args != nil && args["status"] == status
+		}
+	}
+
+	// A pause signal without a message is pushed like any feedback.
+	server.Broadcast(Notification{JSONRPC: "2.0", Method: FeedbackNotification,
+		Params: json.RawMessage(`{"type":"pause","source":"supervisor"}`)})
+	require.Eventually(t, hook.Paused, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, reported("paused"), 2*time.Second, 10*time.Millisecond)
+	details := server.Args("report_status")["details"].(map[string]any)
+	require.Equal(t, "paused by supervisor", details["reason"])
+	require.Equal(t, true, details["paused"])
+	require.Contains(t, newDialog(hook).View(), "Paused: paused by supervisor")
+
+	// Feedback is held back while paused.
+	hook.queueFeedback(ctx, []FeedbackPayload{{Source: "reviewer", Message: "Add tests."}})
+	time.Sleep(4 * hook.quietPeriod)
+	require.Empty(t, submitter.submitted())
+
+	hook.queueFeedback(ctx, []FeedbackPayload{{Type: FeedbackResume, Source: "supervisor"}})
+	require.False(t, hook.Paused())
+	require.Eventually(t, func() bool { return len(submitter.submitted()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	require.Contains(t, submitter.submitted()[0].prompt, "Feedback from reviewer:\nAdd tests.")
+	require.Eventually(t, reported("resumed"), 2*time.Second, 10*time.Millisecond)
+	require.NotContains(t, hook.statusContext(), "paused")
+}
+
+// taskSubmitter runs each prompt by calling run, standing in for an agent
+// run that emits message events before it ends.
+type taskSubmitter struct {
+	recordingSubmitter
+	run func(prompt string) error
+}
+
+func (s *taskSubmitter) SubmitPrompt(ctx context.Context, prompt string) error {
+	_ = s.recordingSubmitter.SubmitPrompt(ctx, prompt)
+	return s.run(prompt)
+}
+
+func TestWorkQueue(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+	server.tasks = []Task{
+		{TaskID: "task-1", Title: "Fix the parser", Description: "The parser drops trailing commas."},
+		{TaskID: "task-2", Description: "Run the linter."},
+	}
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), WorkQueue: true})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	hook.quietPeriod = 0
+	hook.workQueuePoll = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	submitter := &taskSubmitter{}
+	submitter.run = func(prompt string) error {
+		if strings.Contains(prompt, "task-2") {
+			return errors.New("provider unavailable")
+		}
+		hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{
+			ID: "m1", Role: plugin.MessageRoleUser, SessionID: "session-9", Content: prompt,
+		}})
+		hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+			ID: "m2", Role: plugin.MessageRoleAssistant, SessionID: "session-9", Content: "Fixed trailing commas.",
+		}})
+		return nil
+	}
+	go hook.workQueueLoop(ctx, submitter)
+
+	require.Eventually(t, func() bool { return server.Args("fail_task") != nil }, 2*time.Second, 10*time.Millisecond)
+	prompts := submitter.submitted()
+	require.Len(t, prompts, 2)
+	require.True(t, strings.HasPrefix(prompts[0].prompt, "[Tempotown] Task task-1: Fix the parser\n\nThe parser drops trailing commas."))
+	require.Contains(t, prompts[0].prompt, TaskToolName)
+
+	require.Equal(t, map[string]any{"summary": "Fixed trailing commas.", "session_id": "session-9"},
+		server.Args("complete_task")["result"])
+	require.Equal(t, "task-1", server.Args("complete_task")["task_id"])
+	require.Equal(t, []string{"register_agent", "get_next_task", "accept_task", "complete_task", "get_next_task", "accept_task", "fail_task"},
+		slices.DeleteFunc(server.ToolNames(), func(c string) bool { return c == "report_status" })[:7])
+	require.Equal(t, map[string]any{"task_id": "task-2", "agent_id": "test-agent-123", "reason": "provider unavailable"},
+		server.Args("fail_task"))
+	require.Equal(t, "coder", server.Args("get_next_task")["role"])
+	require.Eventually(t, func() bool { return hook.CurrentTask() == "" }, time.Second, 10*time.Millisecond)
+
+	// Nothing is pulled while paused.
+	hook.control.Pause("")
+	server.mu.Lock()
+	server.tasks = []Task{{TaskID: "task-3", Description: "Later."}}
+	server.mu.Unlock()
+	time.Sleep(5 * hook.workQueuePoll)
+	require.Len(t, submitter.submitted(), 2)
+}
+
+func TestArtifactUpload(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	run("init", "-q")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "parser.go"), []byte("package parser\n"), 0o644))
+	run("add", ".")
+	run("commit", "-q", "-m", "init")
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(plugin.NewApp(plugin.WithWorkingDir(dir)), Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.NoError(t, hook.acceptTask(ctx, "task-5"))
+
+	// Work committed during the task and left uncommitted both count.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lexer.go"), []byte("package parser\n"), 0o644))
+	run("add", "lexer.go")
+	run("commit", "-q", "-m", "add lexer")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "parser.go"), []byte("package parser\n\n// Parse parses.\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("todo\n"), 0o644))
+
+	hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+		ID: "m1", Role: plugin.MessageRoleAssistant, ToolCalls: []plugin.ToolCallInfo{
+			{ID: "c1", Name: "bash", Input: `{"command":"go test ./..."}`, Finished: true},
+			{ID: "c2", Name: "bash", Input: `{"command":"ls"}`, Finished: true},
+		},
+	}})
+	hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{
+		ID: "m2", Role: plugin.MessageRoleTool, ToolResults: []plugin.ToolResultInfo{
+			{ToolCallID: "c1", Name: "bash", Content: "ok  \tparser\t0.01s\n"},
+			{ToolCallID: "c2", Name: "bash", Content: "parser.go\n"},
+		},
+	}})
+
+	require.NoError(t, hook.completeTask(ctx, "task-5", "Added a lexer."))
+	require.Eventually(t, func() bool { return len(server.AllArgs("upload_artifact")) == 3 }, 2*time.Second, 10*time.Millisecond)
+
+	uploads := map[string]map[string]any{}
+	for _, args := range server.AllArgs("upload_artifact") {
+		require.Equal(t, "task-5", args["task_id"])
+		require.Equal(t, "test-agent-123", args["agent_id"])
+		uploads[args["kind"].(string)] = args
+	}
+	diff := uploads[ArtifactDiff]["content"].(string)
+	require.Contains(t, diff, "+++ b/lexer.go")
+	require.Contains(t, diff, "+// Parse parses.")
+	require.Equal(t, "lexer.go\nparser.go\nnotes.txt", uploads[ArtifactChangedFiles]["content"])
+	require.Equal(t, "$ go test ./...\nok  \tparser\t0.01s", uploads[ArtifactTestOutput]["content"])
+
+	// Nothing is uploaded when turned off.
+	off := false
+	hook.cfg.UploadArtifacts = &off
+	require.NoError(t, hook.acceptTask(ctx, "task-6"))
+	require.NoError(t, hook.completeTask(ctx, "task-6", "Nothing to do."))
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, server.AllArgs("upload_artifact"), 3)
+}
+
+func TestRequestPermission(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(plugin.NewApp(), Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	// Set after creation so the decision is tested in builds without
+	// permission brokers too.
+	hook.cfg.Approval = ApprovalConfig{Enabled: true, TimeoutSeconds: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := permissionRequest{SessionID: "s1", ToolName: "bash", Action: "execute", Description: "rm -rf build"}
+
+	// Disconnected, the ask fallback hands the request back to Crush.
+	_, err = hook.decidePermission(ctx, req)
+	require.ErrorIs(t, err, errNotConnected)
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.NoError(t, hook.acceptTask(ctx, "task-3"))
+
+	server.mu.Lock()
+	server.approval = `{"approved":true}`
+	server.mu.Unlock()
+	approved, err := hook.decidePermission(ctx, req)
+	require.NoError(t, err)
+	require.True(t, approved)
+
+	args := server.AllArgs("request_approval")
+	require.Len(t, args, 1)
+	require.Equal(t, "test-agent-123", args[0]["agent_id"])
+	require.Equal(t, "task-3", args[0]["task_id"])
+	require.Equal(t, "s1", args[0]["session_id"])
+	require.Equal(t, "bash", args[0]["tool"])
+	require.Equal(t, "rm -rf build", args[0]["description"])
+
+	server.mu.Lock()
+	server.approval = `{"approved":false,"reason":"not in this repo"}`
+	server.mu.Unlock()
+	approved, err = hook.decidePermission(ctx, req)
+	require.NoError(t, err)
+	require.False(t, approved)
+
+	// Without a decision the deny fallback refuses the call.
+	server.mu.Lock()
+	server.approval = ""
+	server.mu.Unlock()
+	_, err = hook.decidePermission(ctx, req)
+	require.Error(t, err)
+	hook.cfg.Approval.Fallback = ApprovalFallbackDeny
+	approved, err = hook.decidePermission(ctx, req)
+	require.NoError(t, err)
+	require.False(t, approved)
+
+	// A server that never answers times out into the fallback.
+	server.Stall(true)
+	start := time.Now()
+	approved, err = hook.decidePermission(ctx, req)
+	require.NoError(t, err)
+	require.False(t, approved)
+	require.Less(t, time.Since(start), 3*time.Second)
+}
+
+type summarizingSubmitter struct {
+	recordingSubmitter
+	summarized []string
+}
+
+func (s *summarizingSubmitter) SummarizeSession(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summarized = append(s.summarized, sessionID)
+	return nil
+}
+
+func TestSessionControl(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr()})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	// The first session seen during a task is mapped to it and reported.
+	require.NoError(t, hook.acceptTask(ctx, "task-1"))
+	hook.noteActivity("s1")
+	hook.noteActivity("s2")
+	require.Equal(t, "s1", hook.SessionForTask("task-1"))
+	require.Equal(t, "s2", hook.CurrentSession())
+	require.Equal(t, "s2", hook.statusContext()["session_id"])
+
+	submitter := &taskSubmitter{run: func(string) error {
+		hook.noteActivity("s3")
+		return nil
+	}}
+	hook.deliverFeedback(ctx, submitter, hook.CurrentSession(), []FeedbackPayload{
+		{Message: "Looks good.", Source: "reviewer"},
+		{Message: "Rename the helper.", Source: "reviewer", TaskID: "task-1"},
+		{Message: "Also this.", Source: "reviewer", SessionID: "s1"},
+		{Type: FeedbackSwitchSession, SessionID: "s1"},
+		{Message: "Thanks.", Source: "merger"},
+		{Type: FeedbackNewSession, Message: "Start on the docs.", Source: "supervisor"},
+		{Message: "Use the style guide.", Source: "supervisor"},
+		{Type: FeedbackSummarize, TaskID: "task-1"},
+	})
+
+	prompts := submitter.submitted()
+	require.Len(t, prompts, 6)
+	require.Equal(t, "s2", prompts[0].sessionID)
+	require.Contains(t, prompts[0].prompt, "Looks good.")
+	require.Equal(t, "s1", prompts[1].sessionID)
+	require.Contains(t, prompts[1].prompt, "Rename the helper.")
+	require.Contains(t, prompts[1].prompt, "Also this.")
+	require.Equal(t, "s1", prompts[2].sessionID)
+	require.Contains(t, prompts[2].prompt, "Thanks.")
+	require.Empty(t, prompts[3].sessionID)
+	require.Contains(t, prompts[3].prompt, "Start on the docs.")
+	require.Equal(t, "s3", prompts[4].sessionID)
+	require.Contains(t, prompts[4].prompt, "Use the style guide.")
+	require.Equal(t, "s1", prompts[5].sessionID)
+	require.Equal(t, summarizePrompt, prompts[5].prompt)
+	require.Equal(t, "s3", hook.CurrentSession())
+
+	require.Eventually(t, func() bool {
+		var statuses []string
+		for _, args := range server.AllArgs("report_status") {
+			statuses = append(statuses, args["status"].(string))
+		}
+		return slices.Contains(statuses, "session_switched") &&
+			slices.Contains(statuses, "session_started") &&
+			slices.Contains(statuses, "session_summarized")
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Crush's own summarization is used when available.
+	summarizer := &summarizingSubmitter{}
+	hook.deliverFeedback(ctx, summarizer, "s3", []FeedbackPayload{{Type: FeedbackSummarize}})
+	require.Equal(t, []string{"s3"}, summarizer.summarized)
+	require.Empty(t, summarizer.submitted())
+}
+
+func TestPendingCalls(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1, RequestTimeoutSeconds: 60})
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, hook.requestTimeout)
+	done, err := hook.connect(context.Background())
+	require.NoError(t, err)
+
+	server.Stall(true)
+
+	// A deadline on the context bounds a single call.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = hook.callTool(ctx, "report_status", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Without one, the request timeout applies.
+	hook.requestTimeout = 50 * time.Millisecond
+	_, err = hook.callTool(context.Background(), "report_status", nil)
+	require.ErrorIs(t, err, errRequestTimeout)
+
+	// Calls still waiting when the connection drops fail right away.
+	hook.requestTimeout = time.Minute
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := hook.callTool(context.Background(), "get_pending_feedback", nil)
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool {
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		return len(hook.pending) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	hook.mu.Lock()
+	hook.conn.Close()
+	hook.mu.Unlock()
+	for range 2 {
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, errConnectionLost)
+		case <-time.After(time.Second):
+			t.Fatal("pending call not failed on disconnect")
+		}
+	}
+	<-done
+	hook.mu.Lock()
+	require.Empty(t, hook.pending)
+	hook.mu.Unlock()
+}
+
+func TestWireLog(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "logs", "wire.jsonl")
+	hook, err := NewTempotownHook(nil, Config{
+		Endpoint:         server.Addr(),
+		AuthToken:        "s3cret-token",
+		HeartbeatSeconds: -1,
+		DebugLog:         path,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	_, err = hook.callTool(ctx, "report_status", map[string]any{"status": "working", "note": "uses s3cret-token", "password": "hunter2"})
+	require.NoError(t, err)
+	require.NoError(t, hook.Stop())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "s3cret-token")
+	require.NotContains(t, string(data), "hunter2")
+
+	var entries []wireEntry
+	for line := range strings.Lines(string(data)) {
+		var entry wireEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.False(t, entry.Time.IsZero())
+		entries = append(entries, entry)
+	}
+	// initialize, initialized, register_agent, and report_status, each
+	// answered but the notification.
+	require.Len(t, entries, 7)
+	require.Equal(t, wireOut, entries[0].Dir)
+	require.Contains(t, string(entries[0].Message), `"authToken":"[REDACTED]"`)
+	require.Equal(t, wireIn, entries[1].Dir)
+	require.Contains(t, string(entries[1].Message), `"result"`)
+	require.Contains(t, string(entries[2].Message), `"method":"initialized"`)
+	require.Contains(t, string(entries[5].Message), `"note":"uses [REDACTED]"`)
+	require.Contains(t, string(entries[5].Message), `"password":"[REDACTED]"`)
+}
+
+func TestStatusCoalescing(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	require.Equal(t, DefaultStatusInterval, hook.statusInterval)
+	hook.control = agentcontrol.New()
+	hook.statusInterval = 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	statuses := func() []string {
+		var statuses []string
+		for _, args := range server.AllArgs("report_status") {
+			statuses = append(statuses, args["status"].(string))
+		}
+		return statuses
+	}
+
+	// A streamed response is reported once it settles, not once per update.
+	hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{ID: "m1", Role: plugin.MessageRoleAssistant}})
+	for i := range 100 {
+		hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{
+			ID: "m1", Role: plugin.MessageRoleAssistant, Content: strings.Repeat("x", i),
+		}})
+	}
+	require.Eventually(t, func() bool {
+		return slices.Contains(statuses(), "response complete")
+	}, 2*time.Second, 10*time.Millisecond)
+	reported := statuses()
+	require.LessOrEqual(t, len(reported), 2)
+	require.Equal(t, "response complete", reported[len(reported)-1])
+
+	// Unchanged activity is not reported again.
+	hook.handleEvent(ctx, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: plugin.Message{ID: "m1", Role: plugin.MessageRoleAssistant}})
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, reported, statuses())
+
+	// Queued reports are all sent, in order, without waiting.
+	hook.reportStatus("paused", 0, nil)
+	hook.reportStatus("resumed", 0, nil)
+	require.Eventually(t, func() bool { return len(statuses()) == len(reported)+2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"paused", "resumed"}, statuses()[len(reported):])
+}
+
+func TestOfflineQueue(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	hook.link = agentlink.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// While disconnected, reports are queued, repeated statuses coalesced,
+	// and task results kept with the task finished locally.
+	hook.reportStatus("paused", 0, map[string]any{"reason": "lunch"})
+	hook.reportStatus("paused", 0, map[string]any{"reason": "meeting"})
+	hook.reportStatus("resumed", 0, nil)
+	hook.setTask("task-7", "working")
+	require.NoError(t, hook.completeTask(ctx, "task-7", "Added tests."))
+	require.NoError(t, hook.failTask(ctx, "task-8", "first attempt"))
+	require.NoError(t, hook.failTask(ctx, "task-8", "gave up"))
+	require.Empty(t, hook.CurrentTask())
+	require.Equal(t, 4, hook.Snapshot().Queued)
+	require.Contains(t, newDialog(hook).View(), "Queued Reports: 4")
+
+	// All of it is sent once connected.
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return server.Args("fail_task") != nil && len(server.AllArgs("report_status")) >= 2
+	}, 2*time.Second, 10*time.Millisecond)
+	reports := server.AllArgs("report_status")
+	require.Equal(t, "paused", reports[0]["status"])
+	require.Equal(t, "meeting", reports[0]["details"].(map[string]any)["reason"])
+	require.Contains(t, reports[0]["details"], "queued_at")
+	require.Equal(t, "resumed", reports[1]["status"])
+
+	completed := server.Args("complete_task")
+	require.Equal(t, "task-7", completed["task_id"])
+	require.Equal(t, "test-agent-123", completed["agent_id"])
+	require.Equal(t, map[string]any{"summary": "Added tests."}, completed["result"])
+	require.Len(t, server.AllArgs("fail_task"), 1)
+	require.Equal(t, "gave up", server.Args("fail_task")["reason"])
+	require.Eventually(t, func() bool { return hook.Snapshot().Queued == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestCapabilities(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	app := plugin.NewApp(plugin.WithSessionInfoProvider(&fakeSessionInfo{info: plugin.SessionInfo{Model: "claude-sonnet"}}))
+	hook, err := NewTempotownHook(app, Config{
+		Endpoint:     server.Addr(),
+		Capabilities: []string{"code", "-tool:" + EnsembleToolName, "-subagent:draft-*"},
+	})
+	require.NoError(t, err)
+	hook.caps = agentcaps.New()
+	hook.caps.Provide("subagents", func() []string {
+		return []string{"subagent:reviewer", "subagent:draft-writer"}
+	})
+
+	// Detected capabilities come first, then the configured additions.
+	caps := hook.capabilities()
+	require.Contains(t, caps, "tool:"+TaskToolName)
+	require.NotContains(t, caps, "tool:"+EnsembleToolName)
+	require.Contains(t, caps, "subagent:reviewer")
+	require.NotContains(t, caps, "subagent:draft-writer")
+	require.Contains(t, caps, "model:claude-sonnet")
+	require.Equal(t, "code", caps[len(caps)-1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+	registered := server.Args("register_agent")["capabilities"].([]any)
+	require.Len(t, registered, len(caps))
+
+	// Without detection only the configured list is sent.
+	off := false
+	hook.cfg.AutoCapabilities = &off
+	require.Equal(t, []string{"code"}, hook.capabilities())
+}
+
+func TestAgentLink(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), Role: "reviewer", HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	hook.link = agentlink.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	want := agentlink.Orchestrator{Name: HookName, AgentID: "test-agent-123", Role: "reviewer"}
+	require.Equal(t, want, hook.link.Orchestrator())
+
+	require.NoError(t, hook.acceptTask(ctx, "task-7"))
+	want.TaskID = "task-7"
+	require.Equal(t, want, hook.link.Orchestrator())
+	require.NoError(t, hook.failTask(ctx, "task-7", "gave up"))
+	want.TaskID = ""
+	require.Equal(t, want, hook.link.Orchestrator())
+
+	// Waiting and errors seen by other plugins are reported; other statuses
+	// are covered by the hook's own activity reports.
+	unwatch := hook.link.WatchStatus(hook.handleLinkStatus)
+	defer unwatch()
+	hook.link.SetStatus(agentlink.Status{Status: "thinking"})
+	hook.link.SetStatus(agentlink.Status{Status: "error", Error: "tool failed"})
+	require.Eventually(t, func() bool {
+		return server.Args("report_status")["status"] == "error"
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Len(t, server.AllArgs("report_status"), 1)
+	require.Equal(t, map[string]any{"error": "tool failed"}, server.Args("report_status")["details"])
+
+	hook.Reconnect()
+	require.Zero(t, hook.link.Orchestrator())
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1})
+	require.NoError(t, err)
+	hook.meter = agentmeter.New()
+	var mu sync.Mutex
+	counts := make(map[string]float64)
+	hook.meter.Subscribe(func(m agentmeter.Measurement) {
+		key := m.Name
+		for _, k := range []string{"outcome", "method", "status"} {
+			if v, ok := m.Attrs[k]; ok {
+				key += " " + k + "=" + v
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if m.Kind == agentmeter.Histogram {
+			counts[key]++
+			return
+		}
+		counts[key] += m.Value
+	})
+	count := func(key string) float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[key]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go hook.connectionLoop(ctx)
+	require.Eventually(t, hook.IsConnected, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(1), count("tempotown.connect.attempts outcome=success"))
+	require.Equal(t, float64(1), count("tempotown.rpc.duration outcome=success method=initialize"))
+	require.Equal(t, float64(1), count("tempotown.rpc.duration outcome=success method=register_agent"))
+	require.Zero(t, count("tempotown.reconnects"))
+
+	hook.sendStatus(ctx, statusUpdate{status: "working", progress: 10})
+	require.Equal(t, float64(1), count("tempotown.status.reports outcome=success status=working"))
+
+	_, err = hook.call(ctx, "bogus", nil)
+	require.Error(t, err)
+	require.Equal(t, float64(1), count("tempotown.rpc.duration outcome=failure method=bogus"))
+
+	hook.openFeedback([]json.RawMessage{
+		json.RawMessage(`{"message":"Add tests.","source":"supervisor"}`),
+		json.RawMessage(`{"source":"supervisor"}`),
+	})
+	require.Equal(t, float64(1), count("tempotown.feedback.received outcome=accepted"))
+	require.Equal(t, float64(1), count("tempotown.feedback.received outcome=malformed"))
+
+	hook.Reconnect()
+	require.Eventually(t, func() bool { return count("tempotown.reconnects") == 1 }, 2*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(2), count("tempotown.connect.attempts outcome=success"))
+}
+
+func TestPayloadSecurity(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	for _, sec := range []PayloadSecurityConfig{
+		{SigningKey: "sign-secret"},
+		{EncryptionKey: "enc-secret"},
+		{SigningKey: "sign-secret", EncryptionKey: "enc-secret"},
+	} {
+		sealed, err := sec.seal(map[string]any{"message": "Add tests."}, FeedbackNotification, now)
+		require.NoError(t, err)
+		raw, err := json.Marshal(sealed)
+		require.NoError(t, err)
+		require.Equal(t, sec.EncryptionKey != "", !strings.Contains(string(raw), "Add tests."))
+
+		_, err = sec.open(raw, "report_status", now, &sealLog{})
+		require.Error(t, err, "sealed for another method")
+
+		seen := &sealLog{}
+		data, err := sec.open(raw, FeedbackNotification, now, seen)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"message":"Add tests."}`, string(data))
+		_, err = sec.open(raw, FeedbackNotification, now.Add(time.Minute), seen)
+		require.ErrorIs(t, err, errReplayed)
+
+		_, err = sec.open(raw, FeedbackNotification, now.Add(maxSealAge+time.Minute), &sealLog{})
+		require.ErrorIs(t, err, errStale)
+		_, err = sec.open(json.RawMessage(`{"message":"Add tests."}`), FeedbackNotification, now, &sealLog{})
+		require.ErrorIs(t, err, errUnsealed)
+		_, err = PayloadSecurityConfig{SigningKey: "other", EncryptionKey: "other"}.open(raw, FeedbackNotification, now, &sealLog{})
+		require.Error(t, err)
+	}
+
+	// A tampered payload or method fails the signature.
+	sec := PayloadSecurityConfig{SigningKey: "sign-secret"}
+	sealed, err := sec.seal(map[string]any{"message": "Add tests."}, FeedbackNotification, now)
+	require.NoError(t, err)
+	for _, tamper := range []func(*envelope){
+		func(env *envelope) { env.Payload = `{"message":"Delete everything."}` },
+		func(env *envelope) { env.Method = "report_status" },
+		func(env *envelope) { env.ID = "fresh" },
+	} {
+		env := sealed["sealed"].(envelope)
+		tamper(&env)
+		raw, err := json.Marshal(map[string]any{"sealed": env})
+		require.NoError(t, err)
+		_, err = sec.open(raw, env.Method, now, &sealLog{})
+		require.ErrorIs(t, err, errBadSignature)
+	}
+
+	// IDs are forgotten once they would be rejected as stale.
+	seen := &sealLog{}
+	require.True(t, seen.first("a", now, now))
+	require.False(t, seen.first("a", now, now.Add(maxSealAge)))
+	require.True(t, seen.first("b", now, now.Add(maxSealAge+time.Second)))
+	require.NotContains(t, seen.seen, "a")
+
+	// Without keys, plain payloads pass through.
+	data, err := PayloadSecurityConfig{}.open(json.RawMessage(`{"message":"hi"}`), FeedbackNotification, now, &sealLog{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"message":"hi"}`, string(data))
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+	server.enablePush()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: server.Addr(), HeartbeatSeconds: -1, PayloadSecurity: sec})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	// Status is sent sealed.
+	hook.reportStatus("paused", 0, nil)
+	require.Eventually(t, func() bool { return server.Args("report_status") != nil }, 2*time.Second, 10*time.Millisecond)
+	args := server.Args("report_status")
+	require.Contains(t, args, "sealed")
+	require.NotContains(t, args, "status")
+	raw, err := json.Marshal(args)
+	require.NoError(t, err)
+	data, err = sec.open(raw, "report_status", time.Now(), &sealLog{})
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"status":"paused"`)
+
+	// Only sealed feedback is accepted, once.
+	sealed, err = sec.seal(FeedbackPayload{Source: "supervisor", Message: "Add tests."}, FeedbackNotification, time.Now())
+	require.NoError(t, err)
+	params, err := json.Marshal(map[string]any{"items": []any{
+		map[string]any{"source": "intruder", "message": "Delete everything."},
+		sealed,
+		sealed,
+	}})
+	require.NoError(t, err)
+	server.Broadcast(Notification{JSONRPC: "2.0", Method: FeedbackNotification, Params: params})
+	select {
+	case fb := <-hook.FeedbackCh():
+		require.Equal(t, "supervisor", fb.Source)
+	case <-ctx.Done():
+		t.Fatal("sealed feedback was not received")
+	}
+	require.Empty(t, hook.FeedbackCh())
+}
+
+func TestClaimGuard(t *testing.T) {
+	t.Parallel()
+
+	server := newMockMCPServer(t)
+	defer server.Close()
+	server.mu.Lock()
+	server.holders = map[string]string{"internal/parser/parse.go": "agent-2"}
+	server.mu.Unlock()
+
+	dir := t.TempDir()
+	hook, err := NewTempotownHook(plugin.NewApp(plugin.WithWorkingDir(dir)), Config{
+		Endpoint:         server.Addr(),
+		HeartbeatSeconds: -1,
+	})
+	require.NoError(t, err)
+	hook.cfg.ClaimGuard = ClaimGuardBlock
+	hook.control = agentcontrol.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	edit := func(path string) permissionRequest {
+		return permissionRequest{ToolName: "edit", Action: "write", Path: filepath.Join(dir, path)}
+	}
+	claims := func() int { return len(server.AllArgs("claim_resource")) }
+
+	// Disconnected, edits are left to the user as usual.
+	approved, err := hook.decidePermission(ctx, edit("main.go"))
+	require.ErrorIs(t, err, errLeftToUser)
+	require.False(t, approved)
+
+	_, err = hook.connect(ctx)
+	require.NoError(t, err)
+
+	// A free file is claimed once.
+	_, err = hook.decidePermission(ctx, edit("main.go"))
+	require.ErrorIs(t, err, errLeftToUser)
+	_, err = hook.decidePermission(ctx, edit("main.go"))
+	require.ErrorIs(t, err, errLeftToUser)
+	require.Equal(t, 1, claims())
+	require.Equal(t, []string{"main.go"}, hook.Claims())
+	require.Equal(t, "main.go", server.Args("claim_resource")["resource"])
+
+	// A file held by another agent is refused and reported.
+	approved, err = hook.decidePermission(ctx, edit("internal/parser/parse.go"))
+	require.NoError(t, err)
+	require.False(t, approved)
+	require.Eventually(t, func() bool {
+		return server.Args("report_status")["status"] == "resource_conflict"
+	}, 2*time.Second, 10*time.Millisecond)
+	details := server.Args("report_status")["details"].(map[string]any)
+	require.Equal(t, "internal/parser/parse.go", details["resource"])
+	require.Equal(t, "agent-2", details["holder"])
+
+	// With the warn guard it goes ahead.
+	hook.cfg.ClaimGuard = ClaimGuardWarn
+	_, err = hook.decidePermission(ctx, edit("internal/parser/parse.go"))
+	require.ErrorIs(t, err, errLeftToUser)
+
+	// Other tools are not guarded.
+	before := claims()
+	_, err = hook.decidePermission(ctx, permissionRequest{ToolName: "bash", Path: dir})
+	require.ErrorIs(t, err, errLeftToUser)
+	require.Equal(t, before, claims())
+
+	// The ensemble tool claims and releases explicitly.
+	tool := NewEnsembleTool(hook)
+	resp, err := tool.Run(ctx, fantasy.ToolCall{ID: "1", Name: EnsembleToolName, Input: `{"action":"claim","resource":"internal/parser/parse.go"}`})
+	require.NoError(t, err)
+	require.True(t, resp.IsError)
+	require.Contains(t, resp.Content, "claimed by agent-2")
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "2", Name: EnsembleToolName, Input: `{"action":"claim","resource":"docs"}`})
+	require.NoError(t, err)
+	require.Equal(t, "Claimed docs.", resp.Content)
+	resp, err = tool.Run(ctx, fantasy.ToolCall{ID: "3", Name: EnsembleToolName, Input: `{"action":"release","resource":"docs"}`})
+	require.NoError(t, err)
+	require.Equal(t, "Released docs.", resp.Content)
+	require.Equal(t, []string{"main.go"}, hook.Claims())
+
+	// Finishing the task releases what is left.
+	require.NoError(t, hook.acceptTask(ctx, "task-7"))
+	require.NoError(t, hook.completeTask(ctx, "task-7", "done"))
+	require.Empty(t, hook.Claims())
+	require.Equal(t, map[string]any{"agent_id": "test-agent-123", "resource": "main.go"}, server.Args("release_resource"))
+}
+
+func TestPromptAttribution(t *testing.T) {
+	t.Parallel()
+
+	hook, err := NewTempotownHook(nil, Config{Endpoint: "localhost:1"})
+	require.NoError(t, err)
+	hook.control = agentcontrol.New()
+	hook.link = agentlink.New()
+	ctx := context.Background()
+
+	items := []FeedbackPayload{
+		{Source: "supervisor", TaskID: "task-7", Message: "Add tests."},
+		{Source: "reviewer", TaskID: "task-7", Message: "Rename the parser."},
+	}
+	submitter := &recordingSubmitter{}
+	hook.submitFeedback(ctx, submitter, "session-1", items)
+	prompt := submitter.prompts[0].prompt
+	require.True(t, strings.HasPrefix(prompt, feedbackPrefix))
+
+	origin, ok := hook.link.PromptOrigin(prompt)
+	require.True(t, ok)
+	require.Equal(t, agentlink.Prompt{Origin: HookName, Source: "supervisor,reviewer", TaskID: "task-7"}, origin)
+
+	created := func(content string) plugin.MessageEvent {
+		return plugin.MessageEvent{Type: plugin.MessageCreated, Message: plugin.Message{Role: plugin.MessageRoleUser, Content: content}}
+	}
+	hook.handleEvent(ctx, created(prompt))
+	details := hook.statusContext()
+	require.Equal(t, HookName, details["initiated_by"])
+	require.Equal(t, "supervisor,reviewer", details["prompt_source"])
+
+	hook.handleEvent(ctx, created("Now fix the build."))
+	details = hook.statusContext()
+	require.Equal(t, InitiatedByUser, details["initiated_by"])
+	require.NotContains(t, details, "prompt_source")
+}
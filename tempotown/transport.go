@@ -0,0 +1,201 @@
+package tempotown
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Transports the plugin can speak MCP over. Every transport carries the same
+// newline-delimited JSON-RPC messages.
+const (
+	// TransportTCP connects to Endpoint over plain TCP or TLS.
+	TransportTCP = "tcp"
+
+	// TransportWebSocket connects to Endpoint, a ws:// or wss:// URL, and
+	// sends each message as a WebSocket text message.
+	TransportWebSocket = "websocket"
+
+	// TransportStdio runs Command and talks to it over stdin and stdout.
+	TransportStdio = "stdio"
+)
+
+// validateTransport checks the transport settings of cfg.
+func validateTransport(cfg Config) error {
+	switch cfg.Transport {
+	case TransportTCP, TransportWebSocket:
+		if cfg.Endpoint == "" {
+			return fmt.Errorf("%s transport requires an endpoint", cfg.Transport)
+		}
+	case TransportStdio:
+		if cfg.Command == "" {
+			return fmt.Errorf("stdio transport requires a command")
+		}
+	default:
+		return fmt.Errorf("unknown transport %q (want tcp, websocket, or stdio)", cfg.Transport)
+	}
+	return nil
+}
+
+// dial opens the connection to the MCP server over the configured
+// transport.
+func (h *TempotownHook) dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	if h.cfg.Endpoint == EndpointAuto && h.cfg.Transport != TransportStdio {
+		if err := h.discoverEndpoint(ctx); err != nil {
+			return nil, err
+		}
+	}
+	switch h.cfg.Transport {
+	case TransportWebSocket:
+		return h.dialWebSocket(ctx)
+	case TransportStdio:
+		return h.startStdio(ctx)
+	default:
+		return h.dialTCP(ctx)
+	}
+}
+
+// dialTCP connects over TCP, with TLS when configured. The server name to
+// verify is taken from the endpoint, or the discovered host name.
+func (h *TempotownHook) dialTCP(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	if h.tlsConfig == nil {
+		return dialer.DialContext(ctx, "tcp", h.endpoint())
+	}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: h.dialTLSConfig()}
+	return tlsDialer.DialContext(ctx, "tcp", h.endpoint())
+}
+
+// dialTLSConfig returns the TLS config for the next connection, verifying
+// a discovered server by its host name rather than its address.
+func (h *TempotownHook) dialTLSConfig() *tls.Config {
+	name := h.serverName()
+	if name == "" || h.tlsConfig.ServerName != "" {
+		return h.tlsConfig
+	}
+	cfg := h.tlsConfig.Clone()
+	cfg.ServerName = name
+	return cfg
+}
+
+// dialWebSocket connects to the WebSocket endpoint, sending the auth token
+// as a bearer token in the handshake as well.
+func (h *TempotownHook) dialWebSocket(ctx context.Context) (net.Conn, error) {
+	opts := &websocket.DialOptions{HTTPHeader: http.Header{}}
+	if h.cfg.AuthToken != "" {
+		opts.HTTPHeader.Set("Authorization", "Bearer "+h.cfg.AuthToken)
+	}
+	if h.tlsConfig != nil {
+		opts.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: h.dialTLSConfig()}}
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, DialTimeout)
+	defer cancel()
+	c, _, err := websocket.Dial(dialCtx, h.webSocketURL(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NetConn(ctx, c, websocket.MessageText), nil
+}
+
+// webSocketURL returns the endpoint as a URL, choosing ws or wss by the tls
+// setting when it has no scheme.
+func (h *TempotownHook) webSocketURL() string {
+	endpoint := h.endpoint()
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	if h.tlsConfig != nil {
+		return "wss://" + endpoint
+	}
+	return "ws://" + endpoint
+}
+
+// startStdio runs the configured command as a local MCP server. The process
+// is killed when the connection is closed or ctx ends.
+func (h *TempotownHook) startStdio(ctx context.Context) (io.ReadWriteCloser, error) {
+	cmd := exec.CommandContext(ctx, h.cfg.Command, h.cfg.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", h.cfg.Command, err)
+	}
+	return &stdioConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// stdioConn is a connection to a subprocess over its standard streams.
+type stdioConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	once   sync.Once
+}
+
+func (c *stdioConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *stdioConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close closes stdin, stops the process, and waits for it to exit.
+func (c *stdioConn) Close() error {
+	c.once.Do(func() {
+		c.stdin.Close()
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+	})
+	return nil
+}
+
+// heartbeatLoop pings the server every heartbeat interval until done is
+// closed. When a ping gets no reply in time, conn is closed so that the
+// connection loop reconnects; an error reply still shows the server is
+// there. The first reply marks the connection as stable.
+func (h *TempotownHook) heartbeatLoop(ctx context.Context, conn io.Closer, done <-chan struct{}) {
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, h.heartbeatTimeout)
+		_, err := h.call(pingCtx, "ping", nil)
+		cancel()
+		var rpcErr *Error
+		if err == nil || errors.As(err, &rpcErr) {
+			h.heartbeatOK.Store(true)
+			continue
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		h.logger.Warn("Tempotown heartbeat failed, reconnecting", "error", err)
+		h.connected.Store(false)
+		conn.Close()
+		return
+	}
+}
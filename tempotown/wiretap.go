@@ -0,0 +1,140 @@
+package tempotown
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of recorded messages.
+const (
+	wireOut = "out"
+	wireIn  = "in"
+)
+
+// redacted replaces secrets in the wire log.
+const redacted = "[REDACTED]"
+
+// secretKeys are the object keys whose values are never written to the wire
+// log, compared case-insensitively.
+var secretKeys = []string{"authtoken", "auth_token", "authorization", "token", "password", "secret", "api_key", "apikey"}
+
+// wireEntry is one line of the wire log.
+type wireEntry struct {
+	Time    time.Time       `json:"time"`
+	Dir     string          `json:"dir"`
+	Message json.RawMessage `json:"message"`
+}
+
+// wireTap appends every JSON-RPC message exchanged with Tempotown to a JSON
+// Lines file, for debugging the integration without a packet capture. A nil
+// wireTap records nothing.
+type wireTap struct {
+	mu      sync.Mutex
+	file    *os.File
+	secrets []string
+}
+
+// openWireTap opens path for appending, creating it and its directory if
+// needed. Occurrences of secrets in message strings are redacted.
+func openWireTap(path string, secrets ...string) (*wireTap, error) {
+	path = expandHome(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create debug_log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open debug_log: %w", err)
+	}
+	tap := &wireTap{file: file}
+	for _, s := range secrets {
+		if s != "" {
+			tap.secrets = append(tap.secrets, s)
+		}
+	}
+	return tap, nil
+}
+
+// record writes msg, sent or received as dir, with secrets redacted.
+func (w *wireTap) record(dir string, msg any) {
+	if w == nil {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return
+	}
+	data, err = json.Marshal(w.redact(v))
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(wireEntry{Time: time.Now(), Dir: dir, Message: data})
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = w.file.Write(append(line, '\n'))
+}
+
+// redact returns v with the values of secret keys and occurrences of known
+// secrets replaced.
+func (w *wireTap) redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if isSecretKey(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = w.redact(val)
+		}
+		return v
+	case []any:
+		for i, val := range v {
+			v[i] = w.redact(val)
+		}
+		return v
+	case string:
+		for _, s := range w.secrets {
+			v = strings.ReplaceAll(v, s, redacted)
+		}
+		return v
+	}
+	return v
+}
+
+// close closes the log file.
+func (w *wireTap) close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// isSecretKey reports whether values under key are secrets.
+func isSecretKey(key string) bool {
+	return slices.Contains(secretKeys, strings.ToLower(key))
+}
+
+// expandHome expands a leading ~/ to the user's home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,153 @@
+package tempotown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// maxTaskSummary caps the length of the final reply sent as the summary of a
+// work-queue task.
+const maxTaskSummary = 2000
+
+// workQueueSource is the prompt source of work-queue tasks.
+const workQueueSource = "work_queue"
+
+// Task is a unit of work handed out by Tempotown's work queue.
+type Task struct {
+	TaskID      string         `json:"task_id"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+}
+
+// workQueueLoop pulls tasks from Tempotown and runs them one at a time until
+// ctx is cancelled. A task is only pulled while the agent is connected, not
+// paused, idle, and not already working on a task.
+func (h *TempotownHook) workQueueLoop(ctx context.Context, submitter plugin.PromptSubmitter) {
+	ticker := time.NewTicker(h.workQueuePoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !h.connected.Load() || h.CurrentTask() != "" {
+			continue
+		}
+		if _, ok := h.idle(); !ok {
+			continue
+		}
+		task, err := h.nextTask(ctx)
+		if err != nil {
+			h.logger.Debug("failed to get next task", "error", err)
+			continue
+		}
+		if task != nil {
+			h.runTask(ctx, submitter, *task)
+		}
+	}
+}
+
+// nextTask asks Tempotown for the next task for this agent, returning nil
+// when the queue is empty.
+func (h *TempotownHook) nextTask(ctx context.Context) (*Task, error) {
+	result, err := h.callTool(ctx, "get_next_task", map[string]any{
+		"agent_id":     h.AgentID(),
+		"role":         h.Role(),
+		"capabilities": h.capabilities(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get_next_task: %w", err)
+	}
+	var next struct {
+		Task *Task `json:"task"`
+	}
+	if err := json.Unmarshal([]byte(result), &next); err != nil {
+		return nil, fmt.Errorf("get_next_task: unmarshal result: %w", err)
+	}
+	if next.Task == nil || next.Task.TaskID == "" {
+		return nil, nil
+	}
+	return next.Task, nil
+}
+
+// runTask accepts task, submits it as a prompt, and waits for the run to
+// end. Unless the model reported the outcome itself, the task is then
+// completed with the final reply as its summary, or failed when the prompt
+// could not be run.
+func (h *TempotownHook) runTask(ctx context.Context, submitter plugin.PromptSubmitter, task Task) {
+	if err := h.acceptTask(ctx, task.TaskID); err != nil {
+		h.logger.Warn("failed to accept task", "task_id", task.TaskID, "error", err)
+		return
+	}
+	h.mu.Lock()
+	h.workTask = task.TaskID
+	h.workSession = ""
+	h.workReply = ""
+	h.mu.Unlock()
+
+	h.logger.Info("running task from work queue", "task_id", task.TaskID)
+	runErr := h.submitPrompt(ctx, submitter, "", formatTask(task), workQueueSource, task.TaskID)
+
+	h.mu.Lock()
+	sessionID, reply := h.workSession, h.workReply
+	h.workTask = ""
+	h.mu.Unlock()
+
+	if h.CurrentTask() != task.TaskID {
+		// Completed or failed by the model with the task tool or a marker.
+		return
+	}
+	var err error
+	if runErr != nil {
+		err = h.failTask(ctx, task.TaskID, runErr.Error())
+	} else {
+		result, _ := json.Marshal(map[string]any{
+			"summary":    truncate(strings.TrimSpace(reply), maxTaskSummary),
+			"session_id": sessionID,
+		})
+		err = h.completeTask(ctx, task.TaskID, string(result))
+	}
+	if err != nil {
+		h.logger.Warn("failed to report work queue task", "task_id", task.TaskID, "error", err)
+	}
+}
+
+// noteTaskMessage tracks the session a work-queue task runs in, the first
+// one seen after it was submitted, and the last assistant reply in it.
+func (h *TempotownHook) noteTaskMessage(msg plugin.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.workTask == "" || msg.SessionID == "" {
+		return
+	}
+	if h.workSession == "" {
+		h.workSession = msg.SessionID
+	}
+	if msg.SessionID == h.workSession && msg.Role == plugin.MessageRoleAssistant && msg.Content != "" {
+		h.workReply = msg.Content
+	}
+}
+
+// formatTask renders task as a prompt that tells the model how to report
+// its outcome.
+func formatTask(task Task) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s Task %s", feedbackPrefix, task.TaskID))
+	if task.Title != "" {
+		sb.WriteString(": " + task.Title)
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(strings.TrimSpace(task.Description))
+	sb.WriteString("\n\nWhen you are done, report the outcome with the " + TaskToolName +
+		" tool (action complete or fail). If you do not, your final reply is sent as the summary.")
+	return sb.String()
+}
@@ -0,0 +1,231 @@
+// Package fakeclock provides a clock.Clock that only moves when the test
+// advances it, so schedules, intervals, and timeouts can be tested without
+// sleeping.
+//
+// Basic usage:
+//
+//	clk := fakeclock.New(time.Date(2025, 1, 6, 8, 59, 0, 0, time.UTC))
+//	hook.clock = clk
+//	go hook.Start(ctx)
+//
+//	clk.BlockUntil(1)        // the hook is waiting on a timer
+//	clk.Advance(time.Minute) // fires it
+package fakeclock
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aleksclark/crush-modules/clock"
+)
+
+// Clock is a fake clock.Clock.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	// changed is closed and replaced whenever waiters change.
+	changed chan struct{}
+}
+
+// waiter is a pending timer or ticker.
+type waiter struct {
+	at     time.Time
+	period time.Duration // Zero for timers.
+	ch     chan time.Time
+}
+
+var _ clock.Clock = (*Clock)(nil)
+
+// New returns a fake clock set to now.
+func New(now time.Time) *Clock {
+	return &Clock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once it is advanced
+// by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires once the clock is advanced by d.
+func (c *Clock) NewTimer(d time.Duration) clock.Timer {
+	w := &waiter{ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return &timer{clock: c, w: w}
+}
+
+// NewTicker returns a ticker that ticks every time the clock is advanced by
+// another d.
+func (c *Clock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("fakeclock: non-positive interval for NewTicker")
+	}
+	w := &waiter{period: d, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return &ticker{clock: c, w: w}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker due
+// on the way in time order. Like time.Ticker, a ticker whose tick has not
+// been received drops the next ones. Timers created in response to a firing
+// are not fired by the same call; advance in steps, with BlockUntil in
+// between, to drive a loop that re-arms a timer.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		w := c.next()
+		if w == nil || w.at.After(end) {
+			break
+		}
+		c.now = w.at
+		select {
+		case w.ch <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+	}
+	c.now = end
+	c.notify()
+}
+
+// Set moves the clock to t, firing what is due as Advance does. Setting it
+// back in time fires nothing.
+func (c *Clock) Set(t time.Time) {
+	if d := t.Sub(c.Now()); d > 0 {
+		c.Advance(d)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, so a
+// test can advance the clock only once the code under test is waiting.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// schedule arms w to fire after d; a timer fires at once when d is not
+// positive. c.mu must be held.
+func (c *Clock) schedule(w *waiter, d time.Duration) {
+	if d <= 0 && w.period == 0 {
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+		c.remove(w)
+		return
+	}
+	w.at = c.now.Add(d)
+	if !slices.Contains(c.waiters, w) {
+		c.waiters = append(c.waiters, w)
+	}
+	c.notify()
+}
+
+// remove disarms w and reports whether it was armed. c.mu must be held.
+func (c *Clock) remove(w *waiter) bool {
+	i := slices.Index(c.waiters, w)
+	if i < 0 {
+		return false
+	}
+	c.waiters = slices.Delete(c.waiters, i, i+1)
+	c.notify()
+	return true
+}
+
+// next returns the waiter due first. c.mu must be held.
+func (c *Clock) next() *waiter {
+	var first *waiter
+	for _, w := range c.waiters {
+		if first == nil || w.at.Before(first.at) {
+			first = w
+		}
+	}
+	return first
+}
+
+// notify wakes BlockUntil. c.mu must be held.
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type timer struct {
+	clock *Clock
+	w     *waiter
+}
+
+func (t *timer) C() <-chan time.Time { return t.w.ch }
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t.w)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := slices.Contains(t.clock.waiters, t.w)
+	t.clock.schedule(t.w, d)
+	return active
+}
+
+type ticker struct {
+	clock *Clock
+	w     *waiter
+}
+
+func (t *ticker) C() <-chan time.Time { return t.w.ch }
+
+func (t *ticker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.remove(t.w)
+}
+
+func (t *ticker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("fakeclock: non-positive interval for Ticker.Reset")
+	}
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.w.period = d
+	t.clock.schedule(t.w, d)
+}
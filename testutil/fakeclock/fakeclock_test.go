@@ -0,0 +1,122 @@
+package fakeclock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+
+// received returns the value on ch, if any, without blocking.
+func received(ch <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-ch:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestTimer(t *testing.T) {
+	t.Parallel()
+
+	c := New(start)
+	timer := c.NewTimer(time.Minute)
+	require.Equal(t, 1, c.Waiters())
+
+	c.Advance(59 * time.Second)
+	_, ok := received(timer.C())
+	require.False(t, ok)
+
+	c.Advance(time.Second)
+	at, ok := received(timer.C())
+	require.True(t, ok)
+	require.Equal(t, start.Add(time.Minute), at)
+	require.Zero(t, c.Waiters())
+	require.False(t, timer.Stop())
+
+	require.False(t, timer.Reset(time.Hour))
+	require.True(t, timer.Stop())
+	c.Advance(2 * time.Hour)
+	_, ok = received(timer.C())
+	require.False(t, ok)
+
+	_, ok = received(c.After(0))
+	require.True(t, ok)
+}
+
+func TestTicker(t *testing.T) {
+	t.Parallel()
+
+	c := New(start)
+	ticker := c.NewTicker(10 * time.Second)
+
+	c.Advance(10 * time.Second)
+	at, ok := received(ticker.C())
+	require.True(t, ok)
+	require.Equal(t, start.Add(10*time.Second), at)
+
+	// Unreceived ticks are dropped, keeping the first.
+	c.Advance(time.Minute)
+	at, ok = received(ticker.C())
+	require.True(t, ok)
+	require.Equal(t, start.Add(20*time.Second), at)
+	_, ok = received(ticker.C())
+	require.False(t, ok)
+	require.Equal(t, start.Add(70*time.Second), c.Now())
+
+	ticker.Reset(time.Hour)
+	c.Advance(time.Minute)
+	_, ok = received(ticker.C())
+	require.False(t, ok)
+
+	ticker.Stop()
+	require.Zero(t, c.Waiters())
+}
+
+func TestAdvanceFiresInOrder(t *testing.T) {
+	t.Parallel()
+
+	c := New(start)
+	late := c.NewTimer(2 * time.Minute)
+	early := c.NewTimer(time.Minute)
+	c.Advance(time.Hour)
+
+	at, _ := received(early.C())
+	require.Equal(t, start.Add(time.Minute), at)
+	at, _ = received(late.C())
+	require.Equal(t, start.Add(2*time.Minute), at)
+	require.Equal(t, start.Add(time.Hour), c.Now())
+}
+
+func TestBlockUntil(t *testing.T) {
+	t.Parallel()
+
+	c := New(start)
+	done := make(chan time.Time)
+	go func() {
+		done <- <-c.After(time.Minute)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), <-done)
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	c := New(start)
+	timer := c.NewTimer(time.Hour)
+	c.Set(start.Add(-time.Hour))
+	require.Equal(t, start.Add(-time.Hour), c.Now())
+
+	c.Set(start.Add(30 * time.Minute))
+	_, ok := received(timer.C())
+	require.False(t, ok)
+	c.Set(start.Add(time.Hour))
+	_, ok = received(timer.C())
+	require.True(t, ok)
+}
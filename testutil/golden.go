@@ -0,0 +1,144 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/x/vttest"
+	"github.com/stretchr/testify/require"
+)
+
+// Normalizer rewrites volatile parts of captured output, such as timestamps
+// and IDs, so it can be compared against a golden file.
+type Normalizer func(string) string
+
+// Replace returns a Normalizer that replaces every match of pattern with
+// repl, which may refer to submatches as in regexp.ReplaceAllString.
+func Replace(pattern, repl string) Normalizer {
+	re := regexp.MustCompile(pattern)
+	return func(s string) string {
+		return re.ReplaceAllString(s, repl)
+	}
+}
+
+// ReplaceText returns a Normalizer that replaces every occurrence of old,
+// such as a temporary directory, with repl.
+func ReplaceText(old, repl string) Normalizer {
+	return func(s string) string {
+		if old == "" {
+			return s
+		}
+		return strings.ReplaceAll(s, old, repl)
+	}
+}
+
+var hexID = regexp.MustCompile(`\b[0-9a-f]{8,}\b`)
+
+var (
+	// NormalizeTimestamps replaces dates, RFC 3339 timestamps, and clock
+	// times with seconds with <TIME>.
+	NormalizeTimestamps = Replace(
+		`\b\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)?\b|\b\d{2}:\d{2}:\d{2}\b`,
+		"<TIME>",
+	)
+
+	// NormalizeUUIDs replaces UUIDs with <UUID>.
+	NormalizeUUIDs = Replace(
+		`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`,
+		"<UUID>",
+	)
+
+	// NormalizeHexIDs replaces lowercase hex IDs of eight or more digits,
+	// such as instance IDs and hashes, with <ID>. Plain numbers are kept.
+	NormalizeHexIDs Normalizer = func(s string) string {
+		return hexID.ReplaceAllStringFunc(s, func(id string) string {
+			if strings.Trim(id, "0123456789") == "" {
+				return id
+			}
+			return "<ID>"
+		})
+	}
+
+	// NormalizeDurations replaces Go-formatted durations such as 1.5s and
+	// 2m30s with <DURATION>. It is not a default because plain text like
+	// "5m" in a schedule description is often meant literally.
+	NormalizeDurations = Replace(`\b(?:\d+(?:\.\d+)?(?:h|m|s|ms|µs|us|ns))+\b`, "<DURATION>")
+)
+
+// DefaultNormalizers are applied by Normalize and the golden file helpers
+// before any normalizers the test passes.
+var DefaultNormalizers = []Normalizer{NormalizeUUIDs, NormalizeTimestamps, NormalizeHexIDs}
+
+// Normalize applies DefaultNormalizers and then extra to text, and drops
+// trailing spaces, which terminals pad lines with.
+func Normalize(text string, extra ...Normalizer) string {
+	for _, n := range DefaultNormalizers {
+		text = n(text)
+	}
+	for _, n := range extra {
+		text = n(text)
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RequireGolden compares normalized output, such as a dialog's View, against
+// testdata/<test name>_<name>.golden. Use -update flag to regenerate it.
+func RequireGolden(t *testing.T, name, got string, extra ...Normalizer) {
+	t.Helper()
+	requireGoldenFile(t, filepath.Join("testdata", t.Name()+"_"+name+".golden"), Normalize(got, extra...))
+}
+
+// StableSnapshot waits until the terminal has stopped changing for settle
+// and returns the final snapshot, so golden comparisons do not catch a
+// half-drawn screen. It fails the test if the screen is still changing after
+// timeout.
+func StableSnapshot(t *testing.T, term *vttest.Terminal, settle, timeout time.Duration) vttest.Snapshot {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	snap := term.Snapshot()
+	text := SnapshotText(snap)
+	since := time.Now()
+	for time.Since(since) < settle {
+		if time.Now().After(deadline) {
+			t.Fatalf("Terminal did not settle within %s", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+		snap = term.Snapshot()
+		if current := SnapshotText(snap); current != text {
+			text = current
+			since = time.Now()
+		}
+	}
+	return snap
+}
+
+// requireGoldenFile compares got against the golden file at fp, or writes
+// it with -update.
+func requireGoldenFile(t *testing.T, fp, got string) {
+	t.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatalf("Failed to create testdata dir: %v", err)
+		}
+		if err := os.WriteFile(fp, []byte(got), 0o644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatalf("Failed to read golden file %s: %v (run with -update to create)", fp, err)
+	}
+
+	require.Equal(t, string(expected), got, "Golden file %s mismatch (run with -update to regenerate)", fp)
+}
@@ -0,0 +1,43 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		extra []Normalizer
+		want  string
+	}{
+		{"timestamp", "updated 2025-01-06T09:00:00.123Z", nil, "updated <TIME>"},
+		{"offset", "at 2025-01-06 09:00:00+02:00.", nil, "at <TIME>."},
+		{"date", "report-2025-01-06.md", nil, "report-<TIME>.md"},
+		{"clock time", "[09:41:07] started", nil, "[<TIME>] started"},
+		{"uuid", "session 3f2c1a9e-8b7d-4c6e-9f01-23456789abcd", nil, "session <UUID>"},
+		{"hex id", "crush-1a2b3c4d.json", nil, "crush-<ID>.json"},
+		{"plain number", "12345678 tokens", nil, "12345678 tokens"},
+		{"trailing spaces", "a   \nb ", nil, "a\nb"},
+		{"duration", "took 1m30.5s", []Normalizer{NormalizeDurations}, "took <DURATION>"},
+		{"text", "/tmp/TestX/001/agents", []Normalizer{ReplaceText("/tmp/TestX/001", "<DIR>")}, "<DIR>/agents"},
+		{"pattern", "pid 4242", []Normalizer{Replace(`pid \d+`, "pid <PID>")}, "pid <PID>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, Normalize(tt.input, tt.extra...))
+		})
+	}
+}
+
+func TestRequireGolden(t *testing.T) {
+	t.Parallel()
+
+	RequireGolden(t, "view", "Agent 1a2b3c4d   \nStarted 2025-01-06T09:00:00Z\n")
+}
@@ -0,0 +1,275 @@
+package harness
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// subscriberBuffer is the number of events a subscriber can fall behind
+// before Send blocks.
+const subscriberBuffer = 256
+
+// Messages is a plugin.MessageSubscriber fed by the test. Every subscriber
+// receives every event sent after it subscribed; events sent before anyone
+// subscribed go to the first subscriber.
+type Messages struct {
+	mu      sync.Mutex
+	subs    []*subscriber
+	pending []plugin.MessageEvent
+	count   int
+}
+
+// subscriber is one call to SubscribeMessages.
+type subscriber struct {
+	ctx context.Context
+
+	mu     sync.Mutex
+	ch     chan plugin.MessageEvent
+	closed bool
+}
+
+// NewMessages returns a message stream without subscribers.
+func NewMessages() *Messages {
+	return &Messages{}
+}
+
+// SubscribeMessages implements plugin.MessageSubscriber. The channel is
+// closed when ctx is done.
+func (m *Messages) SubscribeMessages(ctx context.Context) <-chan plugin.MessageEvent {
+	sub := &subscriber{ctx: ctx, ch: make(chan plugin.MessageEvent, subscriberBuffer)}
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = nil
+	for _, e := range pending {
+		sub.ch <- e
+	}
+	m.subs = append(m.subs, sub)
+	m.count++
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		m.subs = slices.DeleteFunc(m.subs, func(s *subscriber) bool { return s == sub })
+		m.mu.Unlock()
+
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		sub.closed = true
+		close(sub.ch)
+	}()
+	return sub.ch
+}
+
+// Send delivers events to every current subscriber, in order.
+func (m *Messages) Send(events ...plugin.MessageEvent) {
+	m.mu.Lock()
+	subs := slices.Clone(m.subs)
+	if len(subs) == 0 {
+		m.pending = append(m.pending, events...)
+	}
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.send(events)
+	}
+}
+
+// Subscribers returns the number of calls to SubscribeMessages so far.
+func (m *Messages) Subscribers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.count
+}
+
+func (s *subscriber) send(events []plugin.MessageEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range events {
+		if s.closed {
+			return
+		}
+		select {
+		case s.ch <- e:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Prompt is a prompt submitted by a plugin.
+type Prompt struct {
+	// SessionID is empty for prompts to the current session.
+	SessionID string
+	Text      string
+	At        time.Time
+}
+
+// Prompts is a plugin.PromptSubmitter that records prompts.
+type Prompts struct {
+	mu        sync.Mutex
+	prompts   []Prompt
+	sessionID string
+	busy      bool
+	err       error
+}
+
+// NewPrompts returns a submitter whose current session is "session-1".
+func NewPrompts() *Prompts {
+	return &Prompts{sessionID: "session-1"}
+}
+
+// SubmitPrompt implements plugin.PromptSubmitter.
+func (p *Prompts) SubmitPrompt(ctx context.Context, prompt string) error {
+	return p.SubmitPromptToSession(ctx, "", prompt)
+}
+
+// SubmitPromptToSession implements plugin.PromptSubmitter.
+func (p *Prompts) SubmitPromptToSession(_ context.Context, sessionID, prompt string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.prompts = append(p.prompts, Prompt{SessionID: sessionID, Text: prompt, At: time.Now()})
+	return nil
+}
+
+// CurrentSessionID implements plugin.PromptSubmitter.
+func (p *Prompts) CurrentSessionID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sessionID
+}
+
+// IsSessionBusy implements plugin.PromptSubmitter.
+func (p *Prompts) IsSessionBusy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.busy
+}
+
+// SetSession sets the current session.
+func (p *Prompts) SetSession(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessionID = sessionID
+}
+
+// SetBusy sets whether the current session is busy.
+func (p *Prompts) SetBusy(busy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy = busy
+}
+
+// Fail makes submitting prompts fail with err, or succeed again when nil.
+func (p *Prompts) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// All returns the prompts submitted so far.
+func (p *Prompts) All() []Prompt {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.prompts)
+}
+
+// Texts returns the text of the prompts submitted so far.
+func (p *Prompts) Texts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	texts := make([]string, len(p.prompts))
+	for i, prompt := range p.prompts {
+		texts[i] = prompt.Text
+	}
+	return texts
+}
+
+// WaitFor waits until at least n prompts are submitted and returns them,
+// failing the test on timeout.
+func (p *Prompts) WaitFor(t testing.TB, n int, timeout time.Duration) []Prompt {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for len(p.All()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("harness: timed out waiting for %d prompts, got %q", n, p.Texts())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p.All()
+}
+
+// Session is a plugin.SessionInfoProvider reporting what the test sets.
+type Session struct {
+	mu   sync.Mutex
+	info plugin.SessionInfo
+}
+
+// SessionInfo implements plugin.SessionInfoProvider.
+func (s *Session) SessionInfo() *plugin.SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := s.info
+	return &info
+}
+
+// Set replaces the session info.
+func (s *Session) Set(info plugin.SessionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+}
+
+// Update changes the session info in place, e.g. to add tokens.
+func (s *Session) Update(fn func(info *plugin.SessionInfo)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.info)
+}
+
+// RunFunc answers a sub-agent run.
+type RunFunc func(ctx context.Context, opts plugin.SubAgentOptions) (string, error)
+
+// Runner is a plugin.SubAgentRunner that records runs. Without a reply
+// function, runs return "result from <name>".
+type Runner struct {
+	mu    sync.Mutex
+	runs  []plugin.SubAgentOptions
+	reply RunFunc
+}
+
+// RunSubAgent implements plugin.SubAgentRunner.
+func (r *Runner) RunSubAgent(ctx context.Context, opts plugin.SubAgentOptions) (string, error) {
+	r.mu.Lock()
+	r.runs = append(r.runs, opts)
+	reply := r.reply
+	r.mu.Unlock()
+
+	if reply != nil {
+		return reply(ctx, opts)
+	}
+	return "result from " + opts.Name, nil
+}
+
+// Reply answers runs with fn.
+func (r *Runner) Reply(fn RunFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reply = fn
+}
+
+// Runs returns the options of every run so far.
+func (r *Runner) Runs() []plugin.SubAgentOptions {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.runs)
+}
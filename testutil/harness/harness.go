@@ -0,0 +1,166 @@
+// Package harness runs plugin hooks headlessly, without launching the Crush
+// binary.
+//
+// It builds a plugin.App backed by fakes: a message stream the test feeds
+// with scripted events, a prompt submitter that records prompts, session
+// info the test controls, and a sub-agent runner with canned replies.
+//
+// Basic usage:
+//
+//	h := harness.New(t, harness.WithConfig("agent-status", map[string]any{"update_interval_seconds": 1}))
+//	hook, err := NewAgentStatusHook(h.App, Config{})
+//	require.NoError(t, err)
+//	h.StartHook(t, hook)
+//
+//	h.Messages.Send(harness.NewScript("s1").User("hello").Assistant("Hi!").Events()...)
+//	require.Eventually(t, func() bool { ... }, time.Second, 10*time.Millisecond)
+package harness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+const (
+	// subscribeTimeout is how long StartHook waits for a hook to subscribe
+	// to messages.
+	subscribeTimeout = time.Second
+	// stopTimeout is how long a hook may take to return from Start after it
+	// is stopped.
+	stopTimeout = 5 * time.Second
+)
+
+// Harness is a plugin.App wired to fakes.
+type Harness struct {
+	App      *plugin.App
+	Messages *Messages
+	Prompts  *Prompts
+	Session  *Session
+	Runner   *Runner
+}
+
+// config collects the options of New.
+type config struct {
+	workingDir string
+	plugins    map[string]map[string]any
+	appOptions []plugin.AppOption
+}
+
+// Option configures a Harness.
+type Option func(*config)
+
+// WithWorkingDir sets the working directory of the app. The default is a
+// temporary directory.
+func WithWorkingDir(dir string) Option {
+	return func(c *config) {
+		c.workingDir = dir
+	}
+}
+
+// WithConfig sets the configuration of the named plugin, as it would appear
+// under options.plugins in crush.json.
+func WithConfig(name string, cfg map[string]any) Option {
+	return func(c *config) {
+		c.plugins[name] = cfg
+	}
+}
+
+// WithAppOptions adds options to the app, e.g. to replace one of the fakes.
+// They are applied after the harness's own.
+func WithAppOptions(opts ...plugin.AppOption) Option {
+	return func(c *config) {
+		c.appOptions = append(c.appOptions, opts...)
+	}
+}
+
+// New returns a harness with fresh fakes.
+func New(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+	cfg := &config{plugins: make(map[string]map[string]any)}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.workingDir == "" {
+		cfg.workingDir = t.TempDir()
+	}
+
+	h := &Harness{
+		Messages: NewMessages(),
+		Prompts:  NewPrompts(),
+		Session:  &Session{},
+		Runner:   &Runner{},
+	}
+	appOpts := []plugin.AppOption{
+		plugin.WithWorkingDir(cfg.workingDir),
+		plugin.WithMessageSubscriber(h.Messages),
+		plugin.WithPromptSubmitter(h.Prompts),
+		plugin.WithSessionInfoProvider(h.Session),
+		plugin.WithSubAgentRunner(h.Runner),
+	}
+	if len(cfg.plugins) > 0 {
+		appOpts = append(appOpts, plugin.WithPluginConfig(cfg.plugins))
+	}
+	h.App = plugin.NewApp(append(appOpts, cfg.appOptions...)...)
+	return h
+}
+
+// NewHook creates the registered hook with the given name from the app.
+func (h *Harness) NewHook(t testing.TB, name string) plugin.Hook {
+	t.Helper()
+	factory, ok := plugin.GetHookFactory(name)
+	if !ok {
+		t.Fatalf("harness: no hook registered as %q", name)
+	}
+	hook, err := factory(context.Background(), h.App)
+	if err != nil {
+		t.Fatalf("harness: create hook %q: %v", name, err)
+	}
+	return hook
+}
+
+// StartHook runs hook.Start in the background and waits until the hook
+// subscribes to messages, so that events sent afterwards reach it, or for a
+// second for hooks that do not subscribe. It fails the test if Start fails.
+// The hook is stopped when the test ends.
+func (h *Harness) StartHook(t testing.TB, hook plugin.Hook) {
+	t.Helper()
+	subscribed := h.Messages.Subscribers()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Start(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := hook.Stop(); err != nil {
+			t.Errorf("harness: stop hook %q: %v", hook.Name(), err)
+		}
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+			t.Errorf("harness: hook %q did not return from Start after being stopped", hook.Name())
+		}
+	})
+
+	deadline := time.After(subscribeTimeout)
+	started := done
+	for h.Messages.Subscribers() == subscribed {
+		select {
+		case err := <-started:
+			done <- err // For the cleanup.
+			if err != nil {
+				t.Fatalf("harness: start hook %q: %v", hook.Name(), err)
+			}
+			// Start may have left the subscription to a goroutine.
+			started = nil
+		case <-deadline:
+			// The hook does not subscribe to messages.
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHook records the messages it sees and submits a prompt for every user
+// message, like a hook reacting to the conversation.
+type echoHook struct {
+	app *plugin.App
+
+	mu   sync.Mutex
+	seen []plugin.MessageEvent
+}
+
+func (h *echoHook) Name() string { return "echo" }
+
+func (h *echoHook) Start(ctx context.Context) error {
+	events := h.app.Messages().SubscribeMessages(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			h.mu.Lock()
+			h.seen = append(h.seen, e)
+			h.mu.Unlock()
+			if e.Type == plugin.MessageCreated && e.Message.Role == plugin.MessageRoleUser {
+				_ = h.app.PromptSubmitter().SubmitPromptToSession(ctx, e.Message.SessionID, "echo: "+e.Message.Content)
+			}
+		}
+	}
+}
+
+func (h *echoHook) Stop() error { return nil }
+
+func (h *echoHook) events() []plugin.MessageEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.seen)
+}
+
+func TestStartHook(t *testing.T) {
+	t.Parallel()
+
+	h := New(t)
+	hook := &echoHook{app: h.App}
+	h.StartHook(t, hook)
+	require.Equal(t, 1, h.Messages.Subscribers())
+
+	script := NewScript("s1").User("hello").Assistant("Hi!")
+	h.Messages.Send(script.Events()...)
+
+	prompts := h.Prompts.WaitFor(t, 1, time.Second)
+	require.Equal(t, "s1", prompts[0].SessionID)
+	require.Equal(t, "echo: hello", prompts[0].Text)
+	require.Eventually(t, func() bool { return len(hook.events()) == 3 }, time.Second, 10*time.Millisecond)
+}
+
+func TestMessagesBeforeSubscribe(t *testing.T) {
+	t.Parallel()
+
+	m := NewMessages()
+	m.Send(NewScript("s1").User("early").Events()...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := m.SubscribeMessages(ctx)
+	e := <-events
+	require.Equal(t, "early", e.Message.Content)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, ok := <-events
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// Sending after the subscriber left does not block.
+	m.Send(NewScript("s1").User("late").Events()...)
+}
+
+func TestScript(t *testing.T) {
+	t.Parallel()
+
+	events := NewScript("s1").
+		User("list the files").
+		Assistant("", ToolCall("call-1", "ls", `{"path":"."}`)).
+		ToolError("call-1", "ls", "permission denied").
+		Streaming("There ", "are none.").
+		Events()
+	require.Len(t, events, 8)
+
+	require.Equal(t, plugin.MessageCreated, events[0].Type)
+	require.Equal(t, plugin.MessageRoleUser, events[0].Message.Role)
+
+	require.Equal(t, plugin.MessageCreated, events[1].Type)
+	require.Empty(t, events[1].Message.ToolCalls)
+	require.Equal(t, plugin.MessageUpdated, events[2].Type)
+	require.Equal(t, events[1].Message.ID, events[2].Message.ID)
+	require.False(t, events[2].Message.ToolCalls[0].Finished)
+	require.True(t, events[3].Message.ToolCalls[0].Finished)
+
+	require.Equal(t, plugin.MessageRoleTool, events[4].Message.Role)
+	require.True(t, events[4].Message.ToolResults[0].IsError)
+
+	require.Equal(t, "There ", events[6].Message.Content)
+	require.Equal(t, "There are none.", events[7].Message.Content)
+	require.Equal(t, events[5].Message.ID, events[7].Message.ID)
+}
+
+func TestPrompts(t *testing.T) {
+	t.Parallel()
+
+	h := New(t)
+	submitter := h.App.PromptSubmitter()
+	require.NoError(t, submitter.SubmitPrompt(context.Background(), "one"))
+	require.Equal(t, "session-1", h.Prompts.CurrentSessionID())
+
+	h.Prompts.Fail(errors.New("busy"))
+	require.Error(t, submitter.SubmitPrompt(context.Background(), "two"))
+	h.Prompts.Fail(nil)
+
+	h.Prompts.SetSession("s2")
+	h.Prompts.SetBusy(true)
+	require.Equal(t, "s2", h.Prompts.CurrentSessionID())
+	require.True(t, h.Prompts.IsSessionBusy())
+	require.Equal(t, []string{"one"}, h.Prompts.Texts())
+}
+
+func TestSessionAndRunner(t *testing.T) {
+	t.Parallel()
+
+	h := New(t)
+	h.Session.Set(plugin.SessionInfo{Model: "mock-model"})
+	h.Session.Update(func(info *plugin.SessionInfo) { info.Tokens.Output += 10 })
+	info := h.App.SessionInfo().SessionInfo()
+	require.Equal(t, "mock-model", info.Model)
+	require.Equal(t, int64(10), info.Tokens.Output)
+
+	runner := h.App.SubAgentRunner()
+	result, err := runner.RunSubAgent(context.Background(), plugin.SubAgentOptions{Name: "reviewer"})
+	require.NoError(t, err)
+	require.Equal(t, "result from reviewer", result)
+
+	h.Runner.Reply(func(_ context.Context, opts plugin.SubAgentOptions) (string, error) {
+		return "", errors.New("no " + opts.Name)
+	})
+	_, err = runner.RunSubAgent(context.Background(), plugin.SubAgentOptions{Name: "coder"})
+	require.EqualError(t, err, "no coder")
+	require.Len(t, h.Runner.Runs(), 2)
+}
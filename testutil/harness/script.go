@@ -0,0 +1,113 @@
+package harness
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/crush/plugin"
+)
+
+// Script builds the message events of a conversation in one session the
+// way Crush emits them: a user message is created complete, an assistant
+// message is created empty and then updated as it streams and runs its
+// tools, and tool results arrive in a tool message.
+//
+//	events := harness.NewScript("s1").
+//		User("list the files").
+//		Assistant("", harness.ToolCall("call-1", "ls", `{"path":"."}`)).
+//		ToolResult("call-1", "ls", "main.go").
+//		Assistant("There is one file.").
+//		Events()
+type Script struct {
+	sessionID string
+	events    []plugin.MessageEvent
+	next      int
+}
+
+// NewScript starts a script in the given session.
+func NewScript(sessionID string) *Script {
+	return &Script{sessionID: sessionID}
+}
+
+// ToolCall returns a tool call for Assistant.
+func ToolCall(id, name, input string) plugin.ToolCallInfo {
+	return plugin.ToolCallInfo{ID: id, Name: name, Input: input, Finished: true}
+}
+
+// User adds a user message.
+func (s *Script) User(content string) *Script {
+	s.events = append(s.events, plugin.MessageEvent{
+		Type:    plugin.MessageCreated,
+		Message: s.message(plugin.MessageRoleUser, content),
+	})
+	return s
+}
+
+// Assistant adds an assistant message: created empty, updated with its
+// tool calls running if it has any, and updated with its content and tool
+// calls as given.
+func (s *Script) Assistant(content string, toolCalls ...plugin.ToolCallInfo) *Script {
+	msg := s.message(plugin.MessageRoleAssistant, "")
+	s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageCreated, Message: msg})
+	msg.Content = content
+	if len(toolCalls) > 0 {
+		msg.ToolCalls = make([]plugin.ToolCallInfo, len(toolCalls))
+		for i, tc := range toolCalls {
+			tc.Finished = false
+			msg.ToolCalls[i] = tc
+		}
+		s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: msg})
+	}
+	msg.ToolCalls = toolCalls
+	s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: msg})
+	return s
+}
+
+// Streaming adds an assistant message created empty and updated once per
+// chunk with the content so far, like a streamed response.
+func (s *Script) Streaming(chunks ...string) *Script {
+	msg := s.message(plugin.MessageRoleAssistant, "")
+	s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageCreated, Message: msg})
+	for _, chunk := range chunks {
+		msg.Content += chunk
+		s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageUpdated, Message: msg})
+	}
+	return s
+}
+
+// ToolResult adds a tool message with the result of a tool call.
+func (s *Script) ToolResult(toolCallID, name, content string) *Script {
+	return s.toolResult(plugin.ToolResultInfo{ToolCallID: toolCallID, Name: name, Content: content})
+}
+
+// ToolError adds a tool message with a failed tool call.
+func (s *Script) ToolError(toolCallID, name, content string) *Script {
+	return s.toolResult(plugin.ToolResultInfo{ToolCallID: toolCallID, Name: name, Content: content, IsError: true})
+}
+
+func (s *Script) toolResult(result plugin.ToolResultInfo) *Script {
+	msg := s.message(plugin.MessageRoleTool, "")
+	msg.ToolResults = []plugin.ToolResultInfo{result}
+	s.events = append(s.events, plugin.MessageEvent{Type: plugin.MessageCreated, Message: msg})
+	return s
+}
+
+// Event adds an arbitrary event.
+func (s *Script) Event(e plugin.MessageEvent) *Script {
+	s.events = append(s.events, e)
+	return s
+}
+
+// Events returns the events added so far.
+func (s *Script) Events() []plugin.MessageEvent {
+	return append([]plugin.MessageEvent(nil), s.events...)
+}
+
+func (s *Script) message(role plugin.MessageRole, content string) plugin.Message {
+	s.next++
+	return plugin.Message{
+		ID:        fmt.Sprintf("%s-msg-%d", s.sessionID, s.next),
+		SessionID: s.sessionID,
+		Role:      role,
+		Content:   content,
+	}
+}
@@ -0,0 +1,128 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// imageTokens is roughly what an image costs, as for a low detail image.
+const imageTokens = 85
+
+// ContentPart is one part of multi-part message content.
+type ContentPart struct {
+	Type     string    `json:"type"` // text or image_url
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL is an image attached to a message, by URL or as a data URL with
+// the base64 encoded image.
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// MediaType returns the media type of a data URL, e.g. image/png, or "" for
+// other URLs.
+func (u ImageURL) MediaType() string {
+	rest, ok := strings.CutPrefix(u.URL, "data:")
+	if !ok {
+		return ""
+	}
+	mediaType, _, _ := strings.Cut(rest, ";")
+	mediaType, _, _ = strings.Cut(mediaType, ",")
+	return mediaType
+}
+
+// TextPart creates a text content part.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// ImagePart creates an image content part.
+func ImagePart(url string) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
+}
+
+// Images returns the images attached to the message.
+func (m Message) Images() []ImageURL {
+	var images []ImageURL
+	for _, p := range m.Parts {
+		if p.ImageURL != nil {
+			images = append(images, *p.ImageURL)
+		}
+	}
+	return images
+}
+
+// message is Message without its JSON methods.
+type message Message
+
+// MarshalJSON sends Parts as the content when set.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message(m), m.Parts})
+}
+
+// UnmarshalJSON accepts the content as a string or a list of parts.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var msg struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	*m = Message(msg.message)
+
+	content := msg.Content
+	if len(content) == 0 || string(content) == "null" {
+		return nil
+	}
+	if content[0] == '"' {
+		return json.Unmarshal(content, &m.Content)
+	}
+	if err := json.Unmarshal(content, &m.Parts); err != nil {
+		return err
+	}
+	var texts []string
+	for _, p := range m.Parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// HasImageAttachment returns true if any message has an image attached.
+func HasImageAttachment() MatchFunc {
+	return func(req ChatRequest) bool {
+		for _, msg := range req.Messages {
+			if len(msg.Images()) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HasImageMediaType returns true if any message has an image of the media
+// type, e.g. image/png, attached as a data URL.
+func HasImageMediaType(mediaType string) MatchFunc {
+	return func(req ChatRequest) bool {
+		for _, msg := range req.Messages {
+			for _, img := range msg.Images() {
+				if strings.EqualFold(img.MediaType(), mediaType) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,102 @@
+package mockllm
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// barrierTimeout is how long a Barrier holds a response before giving up
+// on the missing requests and releasing everything it holds.
+const barrierTimeout = 30 * time.Second
+
+// Barrier holds responses until n requests are waiting on it and then
+// releases them together, so a test can tell requests were in flight at the
+// same time, e.g. the sub-agents of a parallel fan-out:
+//
+//	barrier := mockllm.NewBarrier(3)
+//	server.OnMessage("subtask", mockllm.WithBarrier(barrier, mockllm.TextResponse("done")))
+//
+// If fewer than n requests arrive, the barrier releases them after 30
+// seconds and Arrived tells how many came.
+type Barrier struct {
+	n       int
+	mu      sync.Mutex
+	arrived int
+	release chan struct{}
+	once    sync.Once
+}
+
+// NewBarrier returns a barrier for n requests.
+func NewBarrier(n int) *Barrier {
+	return &Barrier{n: n, release: make(chan struct{})}
+}
+
+// WithBarrier holds resp at b until it is released. Latency set with
+// WithLatency is added after the release, so held responses can be made to
+// complete in a chosen order.
+func WithBarrier(b *Barrier, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.barrier = b
+		return r
+	}
+}
+
+// Arrived returns the number of requests that have reached the barrier.
+func (b *Barrier) Arrived() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.arrived
+}
+
+// Release lets every held and later request through without waiting for
+// the rest.
+func (b *Barrier) Release() {
+	b.once.Do(func() { close(b.release) })
+}
+
+// wait holds r until the barrier is released and reports whether the client
+// is still waiting for the response.
+func (b *Barrier) wait(r *http.Request) bool {
+	b.mu.Lock()
+	b.arrived++
+	if b.arrived >= b.n {
+		b.Release()
+	}
+	b.mu.Unlock()
+
+	t := time.NewTimer(barrierTimeout)
+	defer t.Stop()
+	select {
+	case <-b.release:
+		return true
+	case <-t.C:
+		b.Release()
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// MaxInFlight returns the most chat completion requests the server was
+// answering at the same time.
+func (s *Server) MaxInFlight() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxInFlight
+}
+
+// startRequest counts a request as in flight until the returned function is
+// called.
+func (s *Server) startRequest() func() {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,218 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+)
+
+const (
+	// MockProvider is the name of the provider pointing at the mock server.
+	MockProvider = "mock"
+	// MockAPIKey is the API key of the mock provider, sent by Crush as
+	// "Authorization: Bearer mock-key".
+	MockAPIKey = "mock-key"
+)
+
+// Config builds a crush.json for tests. It is marshaled with encoding/json,
+// so paths, prompts, and URLs in it need no quoting or escaping.
+//
+//	tmpDir := mockllm.NewConfig(url).
+//		Plugin("agent-status", map[string]any{"status_dir": statusDir}).
+//		DisablePlugins("ping").
+//		Setup(t)
+type Config struct {
+	Providers map[string]Provider
+	// Models selects the model for each size, "large" and "small".
+	Models          map[string]ModelSelection
+	Plugins         map[string]any
+	DisabledPlugins []string
+	// Options holds other entries of "options".
+	Options map[string]any
+	// Extra holds other top-level entries, such as "mcp". They replace the
+	// entries built from the fields above.
+	Extra map[string]any
+}
+
+// Provider is an LLM provider in crush.json.
+type Provider struct {
+	Type    string  `json:"type"`
+	BaseURL string  `json:"base_url"`
+	APIKey  string  `json:"api_key"`
+	Models  []Model `json:"models,omitempty"`
+	// ExtraHeaders are sent with every request to the provider.
+	ExtraHeaders map[string]string `json:"extra_headers,omitempty"`
+}
+
+// Model is a model offered by a provider.
+type Model struct {
+	ID                  string  `json:"id"`
+	Name                string  `json:"name"`
+	CostPer1MIn         float64 `json:"cost_per_1m_in"`
+	CostPer1MOut        float64 `json:"cost_per_1m_out"`
+	ContextWindow       int64   `json:"context_window"`
+	DefaultMaxTokens    int64   `json:"default_max_tokens"`
+	CanReason           bool    `json:"can_reason"`
+	SupportsAttachments bool    `json:"supports_attachments"`
+}
+
+// ModelSelection picks a provider's model for a model size.
+type ModelSelection struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// NewConfig returns a config using the mock server at serverURL for the
+// large and small models.
+func NewConfig(serverURL string) *Config {
+	return &Config{
+		Providers: map[string]Provider{
+			MockProvider: {
+				Type:    "openai-compat",
+				BaseURL: serverURL,
+				APIKey:  MockAPIKey,
+				Models: []Model{
+					mockModel(LargeModel, "Mock Model"),
+					mockModel(SmallModel, "Mock Small Model"),
+				},
+			},
+		},
+		Models: map[string]ModelSelection{
+			"large": {Provider: MockProvider, Model: LargeModel},
+			"small": {Provider: MockProvider, Model: SmallModel},
+		},
+	}
+}
+
+// mockModel returns the provider config of a mock model.
+func mockModel(id, name string) Model {
+	return Model{
+		ID:               id,
+		Name:             name,
+		ContextWindow:    128000,
+		DefaultMaxTokens: 4096,
+	}
+}
+
+// WithPricing prices the mock models so that Crush reports session costs.
+func (c *Config) WithPricing(pricing Pricing) *Config {
+	provider := c.Providers[MockProvider]
+	models := make([]Model, len(provider.Models))
+	for i, m := range provider.Models {
+		m.CostPer1MIn = pricing.CostPer1MIn
+		m.CostPer1MOut = pricing.CostPer1MOut
+		models[i] = m
+	}
+	provider.Models = models
+	return c.Provider(MockProvider, provider)
+}
+
+// Provider adds or replaces a provider.
+func (c *Config) Provider(name string, provider Provider) *Config {
+	if c.Providers == nil {
+		c.Providers = make(map[string]Provider)
+	}
+	c.Providers[name] = provider
+	return c
+}
+
+// Model selects the model of a provider for a model size, "large" or
+// "small".
+func (c *Config) Model(size, provider, model string) *Config {
+	if c.Models == nil {
+		c.Models = make(map[string]ModelSelection)
+	}
+	c.Models[size] = ModelSelection{Provider: provider, Model: model}
+	return c
+}
+
+// Plugin sets the options of a plugin, as they appear under
+// options.plugins.
+func (c *Config) Plugin(name string, cfg any) *Config {
+	if c.Plugins == nil {
+		c.Plugins = make(map[string]any)
+	}
+	c.Plugins[name] = cfg
+	return c
+}
+
+// DisablePlugins adds plugins to options.disabled_plugins.
+func (c *Config) DisablePlugins(names ...string) *Config {
+	c.DisabledPlugins = append(c.DisabledPlugins, names...)
+	return c
+}
+
+// Option sets another entry of "options".
+func (c *Config) Option(key string, value any) *Config {
+	if c.Options == nil {
+		c.Options = make(map[string]any)
+	}
+	c.Options[key] = value
+	return c
+}
+
+// Set sets a top-level entry, replacing any built from the other fields.
+func (c *Config) Set(key string, value any) *Config {
+	if c.Extra == nil {
+		c.Extra = make(map[string]any)
+	}
+	c.Extra[key] = value
+	return c
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	options := maps.Clone(c.Options)
+	if options == nil {
+		options = make(map[string]any)
+	}
+	if len(c.Plugins) > 0 {
+		options["plugins"] = c.Plugins
+	}
+	if len(c.DisabledPlugins) > 0 {
+		options["disabled_plugins"] = c.DisabledPlugins
+	}
+
+	out := make(map[string]any)
+	if len(c.Providers) > 0 {
+		out["providers"] = c.Providers
+	}
+	if len(c.Models) > 0 {
+		out["models"] = c.Models
+	}
+	if len(options) > 0 {
+		out["options"] = options
+	}
+	maps.Copy(out, c.Extra)
+	return json.Marshal(out)
+}
+
+// JSON returns the config as indented JSON. It panics if a plugin config
+// cannot be marshaled.
+func (c *Config) JSON() string {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// Write writes the config into dir the way the isolated terminals expect
+// it, as dir/config/crush/crush.json and dir/data/crush/crush.json.
+func (c *Config) Write(t *testing.T, dir string) {
+	t.Helper()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	writeConfigFiles(t, dir, data)
+}
+
+// Setup writes the config into a new temporary directory and returns it,
+// for use with testutil.NewIsolatedTerminalWithConfigAndEnv.
+func (c *Config) Setup(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	c.Write(t, dir)
+	return dir
+}
@@ -0,0 +1,177 @@
+package mockllm
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// DefaultEmbeddingDimensions is the embedding size unless the request asks
+// for another, as for text-embedding-3-small.
+const DefaultEmbeddingDimensions = 1536
+
+// EmbeddingRequest represents an OpenAI embeddings request. Input is a
+// string or a list of strings.
+type EmbeddingRequest struct {
+	Model          string `json:"model"`
+	Input          any    `json:"input"`
+	Dimensions     int    `json:"dimensions,omitempty"`
+	EncodingFormat string `json:"encoding_format,omitempty"` // float or base64
+}
+
+// Inputs returns the texts to embed.
+func (r EmbeddingRequest) Inputs() []string {
+	switch v := r.Input.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		inputs := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				inputs = append(inputs, s)
+			}
+		}
+		return inputs
+	}
+	return nil
+}
+
+// EmbeddingResponse represents an OpenAI embeddings response.
+type EmbeddingResponse struct {
+	Object string      `json:"object"`
+	Data   []Embedding `json:"data"`
+	Model  string      `json:"model"`
+	Usage  Usage       `json:"usage"`
+}
+
+// Embedding is the embedding of one input: a list of floats, or a base64
+// string of little-endian float32s when requested.
+type Embedding struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"`
+}
+
+// EmbedFunc returns the embedding of text with dims dimensions.
+type EmbedFunc func(text string, dims int) []float32
+
+// HashEmbedding is the default EmbedFunc. It hashes the words of text into
+// a unit vector, so the same text always gets the same embedding and texts
+// sharing words are closer than unrelated ones, which is enough for
+// retrieval tests.
+func HashEmbedding(text string, dims int) []float32 {
+	vec := make([]float32, dims)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		sign := float32(1)
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vec[sum%uint64(dims)] += sign
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vec
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec
+}
+
+// CosineSimilarity returns the cosine similarity of two embeddings.
+func CosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// Embeddings replaces how the embeddings endpoint computes embeddings.
+func (s *Server) Embeddings(embed EmbedFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embed = embed
+	return s
+}
+
+// EmbeddingRequests returns all captured embeddings requests.
+func (s *Server) EmbeddingRequests() []EmbeddingRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]EmbeddingRequest{}, s.embeddingRequests...)
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	var req EmbeddingRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.embeddingRequests = append(s.embeddingRequests, req)
+	embed := s.embed
+	s.mu.Unlock()
+	if embed == nil {
+		embed = HashEmbedding
+	}
+
+	inputs := req.Inputs()
+	if len(inputs) == 0 {
+		s.sendFault(w, r, &fault{status: http.StatusBadRequest, message: "input must be a string or a list of strings"}, false)
+		return
+	}
+	dims := req.Dimensions
+	if dims <= 0 {
+		dims = DefaultEmbeddingDimensions
+	}
+
+	resp := EmbeddingResponse{Object: "list", Model: req.Model}
+	for i, input := range inputs {
+		vec := embed(input, dims)
+		var embedding any = vec
+		if req.EncodingFormat == "base64" {
+			buf := make([]byte, 0, 4*len(vec))
+			for _, v := range vec {
+				buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+			}
+			embedding = base64.StdEncoding.EncodeToString(buf)
+		}
+		resp.Data = append(resp.Data, Embedding{Object: "embedding", Index: i, Embedding: embedding})
+		resp.Usage.PromptTokens += EstimateTokens(input)
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil && s.t != nil {
+		s.t.Logf("mockllm: failed to encode embeddings: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package mockllm
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Fault builders simulate provider failures, so tests can exercise retry
+// and error handling. They are ResponseFuncs like the other builders and
+// can be used with any handler or in a Sequence.
+
+// fault is a simulated failure sent instead of a response.
+type fault struct {
+	// status is the HTTP status code, 200 when only the body is broken.
+	status     int
+	retryAfter time.Duration
+	// delay holds the request before responding.
+	delay time.Duration
+	// malformed sends a body that is not valid JSON.
+	malformed bool
+	// message is the error message, the status text when empty.
+	message string
+	// code is the error code, the status code when empty.
+	code string
+}
+
+// HTTPError creates a response failing with the HTTP status code and an
+// OpenAI-style error body. An optional retryAfter is sent in the
+// Retry-After and retry-after-ms headers, as rate limited providers do.
+func HTTPError(status int, retryAfter ...time.Duration) func(req *ChatRequest) *ChatResponse {
+	f := &fault{status: status}
+	if len(retryAfter) > 0 {
+		f.retryAfter = retryAfter[0]
+	}
+	return faultResponse(f)
+}
+
+// TimeoutResponse creates a response that holds the request for d, or until
+// the client gives up, and then fails with 504 Gateway Timeout. Use a d
+// longer than the client's timeout to simulate a provider that hangs.
+func TimeoutResponse(d time.Duration) func(req *ChatRequest) *ChatResponse {
+	return faultResponse(&fault{status: http.StatusGatewayTimeout, delay: d})
+}
+
+// MalformedJSONResponse creates a response that succeeds with a body that is
+// not valid JSON. Streamed, it sends a chunk that is not valid JSON.
+func MalformedJSONResponse() func(req *ChatRequest) *ChatResponse {
+	return faultResponse(&fault{status: http.StatusOK, malformed: true})
+}
+
+// ContextWindowExceeded creates a response failing with 400 and the error
+// OpenAI sends when the prompt does not fit a context window of limit
+// tokens, so compaction and summarization can be tested. Server.ContextWindow
+// sends it for every request over the limit; for a single handler, pair it
+// with PromptTokensOver:
+//
+//	server.On(mockllm.PromptTokensOver(8000), mockllm.ContextWindowExceeded(8000))
+func ContextWindowExceeded(limit int) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		return faultResponse(&fault{
+			status: http.StatusBadRequest,
+			message: fmt.Sprintf("This model's maximum context length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.",
+				limit, promptTokens(req)),
+			code: "context_length_exceeded",
+		})(req)
+	}
+}
+
+// ContextWindow makes the server fail every request whose estimated prompt
+// is more than limit tokens with ContextWindowExceeded, before model routes,
+// sequences, and handlers are consulted. A limit of 0 turns it off.
+func (s *Server) ContextWindow(limit int) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.contextWindow = limit
+	return s
+}
+
+// FailNTimesThen creates a response that fails the first n requests with
+// 500 Internal Server Error and then returns resp.
+func FailNTimesThen(n int, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return FailNTimesWith(n, HTTPError(http.StatusInternalServerError), resp)
+}
+
+// FailNTimesWith creates a response that returns fail for the first n
+// requests and then resp, e.g. to recover after being rate limited.
+func FailNTimesWith(n int, fail, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	var calls atomic.Int64
+	return func(req *ChatRequest) *ChatResponse {
+		if calls.Add(1) <= int64(n) {
+			return fail(req)
+		}
+		return resp(req)
+	}
+}
+
+func faultResponse(f *fault) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		resp := NewResponse(req.Model, req.opts...)
+		resp.fault = f
+		return resp
+	}
+}
+
+// errorType returns the OpenAI error type for an HTTP status code.
+func errorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+func (s *Server) sendFault(w http.ResponseWriter, r *http.Request, f *fault, stream bool) {
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if f.malformed {
+		if stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"id\": \"chatcmpl-\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `{"id": "chatcmpl-`)
+		return
+	}
+
+	if f.retryAfter > 0 {
+		seconds := (f.retryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+		w.Header().Set("retry-after-ms", strconv.FormatInt(f.retryAfter.Milliseconds(), 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(f.status)
+	body := APIErrorBody{Error: APIError{
+		Message: cmp.Or(f.message, http.StatusText(f.status)),
+		Type:    errorType(f.status),
+		Code:    cmp.Or(f.code, strconv.Itoa(f.status)),
+	}}
+	if err := json.NewEncoder(w).Encode(body); err != nil && s.t != nil {
+		s.t.Logf("mockllm: failed to encode error: %v", err)
+	}
+}
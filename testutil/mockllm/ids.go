@@ -0,0 +1,98 @@
+package mockllm
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// IDGenerator generates response and tool call IDs from a seed, so the same
+// conversation gets the same IDs on every run.
+type IDGenerator struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewIDGenerator returns a generator seeded with seed.
+func NewIDGenerator(seed uint64) *IDGenerator {
+	return &IDGenerator{rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Next returns the next ID.
+func (g *IDGenerator) Next() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return fmt.Sprintf("%016x", g.rng.Uint64())
+}
+
+// ResponseOption configures the IDs and timestamp of NewResponse.
+type ResponseOption func(*responseOptions)
+
+type responseOptions struct {
+	ids     *IDGenerator
+	created time.Time
+}
+
+// SeededIDs takes the response and tool call IDs from ids instead of the
+// current time.
+func SeededIDs(ids *IDGenerator) ResponseOption {
+	return func(o *responseOptions) { o.ids = ids }
+}
+
+// FrozenTime sets the created timestamp to t instead of the current time.
+func FrozenTime(t time.Time) ResponseOption {
+	return func(o *responseOptions) { o.created = t }
+}
+
+func newResponseOptions(opts []ResponseOption) responseOptions {
+	var o responseOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o responseOptions) id() string {
+	if o.ids != nil {
+		return o.ids.Next()
+	}
+	return randomID()
+}
+
+func (o responseOptions) createdAt() int64 {
+	if o.created.IsZero() {
+		return time.Now().Unix()
+	}
+	return o.created.Unix()
+}
+
+// SeedIDs makes the server generate response and tool call IDs from seed,
+// so recordings and golden files are stable across runs. It applies to the
+// built-in response builders and to NewResponse(req.Model, req.Options()...).
+func (s *Server) SeedIDs(seed uint64) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responseOpts = append(s.responseOpts, SeededIDs(NewIDGenerator(seed)))
+	return s
+}
+
+// FreezeTime makes the server report t as the created time of every
+// response.
+func (s *Server) FreezeTime(t time.Time) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responseOpts = append(s.responseOpts, FrozenTime(t))
+	return s
+}
+
+// Options returns the options the server answers the request with, for
+// custom ResponseFuncs building their response with NewResponse.
+func (r *ChatRequest) Options() []ResponseOption {
+	return r.opts
+}
+
+// toolCallID returns a new tool call ID for a response to r.
+func (r *ChatRequest) toolCallID() string {
+	return "call_" + newResponseOptions(r.opts).id()
+}
@@ -0,0 +1,148 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled subset of JSONPath: $, .name, ['name'], [n], [*],
+// and .*.
+type jsonPath []pathStep
+
+// pathStep selects object members or array elements; a wildcard selects
+// all of them.
+type pathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// compileJSONPath parses path.
+func compileJSONPath(path string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", path)
+	}
+	var steps jsonPath
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("jsonpath %q: empty member name", path)
+			case "*":
+				steps = append(steps, pathStep{wildcard: true})
+			default:
+				steps = append(steps, pathStep{name: name})
+			}
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q: unclosed [", path)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case sel == "*":
+				steps = append(steps, pathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				steps = append(steps, pathStep{name: sel[1 : len(sel)-1]})
+			default:
+				n, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("jsonpath %q: bad selector [%s]", path, sel)
+				}
+				steps = append(steps, pathStep{index: n, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", path, rest[0])
+		}
+	}
+	return steps, nil
+}
+
+// mustCompileJSONPath is like compileJSONPath but panics on a bad path.
+func mustCompileJSONPath(path string) jsonPath {
+	p, err := compileJSONPath(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// eval returns the values path selects in doc, a decoded JSON document.
+func (p jsonPath) eval(doc any) []any {
+	values := []any{doc}
+	for _, step := range p {
+		var next []any
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, member := range v {
+						next = append(next, member)
+					}
+				} else if member, ok := v[step.name]; ok && !step.isIndex {
+					next = append(next, member)
+				}
+			case []any:
+				switch {
+				case step.wildcard:
+					next = append(next, v...)
+				case step.isIndex:
+					i := step.index
+					if i < 0 {
+						i += len(v)
+					}
+					if i >= 0 && i < len(v) {
+						next = append(next, v[i])
+					}
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// requestDocument returns the request body as decoded JSON: the body as
+// sent when the server received it, so that fields ChatRequest does not
+// model can be matched too.
+func requestDocument(req ChatRequest) any {
+	data := req.raw
+	if data == nil {
+		var err error
+		if data, err = json.Marshal(req); err != nil {
+			return nil
+		}
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// normalizeJSON returns v as it reads back from JSON, so that values
+// compare equal regardless of their Go types.
+func normalizeJSON(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
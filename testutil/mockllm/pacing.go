@@ -0,0 +1,172 @@
+package mockllm
+
+import (
+	"net/http"
+	"time"
+)
+
+// Streaming pace used unless the server or the response sets another.
+const (
+	DefaultChunkSize  = 20
+	DefaultChunkDelay = 10 * time.Millisecond
+)
+
+// pacing controls how fast a response is sent. Unset fields fall back to
+// the server's pacing, and then to the defaults.
+type pacing struct {
+	// latency holds the response before its first byte.
+	latency *time.Duration
+	// chunkSize is the number of content bytes per stream chunk.
+	chunkSize int
+	// chunkDelay is the pause after each stream chunk.
+	chunkDelay *time.Duration
+	// splitToolArgs streams tool call arguments in fragments of chunkSize
+	// bytes instead of a single delta.
+	splitToolArgs bool
+	// framing controls how stream events are written.
+	framing *SSEFraming
+}
+
+// over returns p with the fields unset in p taken from base.
+func (p pacing) over(base pacing) pacing {
+	if p.latency == nil {
+		p.latency = base.latency
+	}
+	if p.chunkSize == 0 {
+		p.chunkSize = base.chunkSize
+	}
+	if p.chunkDelay == nil {
+		p.chunkDelay = base.chunkDelay
+	}
+	p.splitToolArgs = p.splitToolArgs || base.splitToolArgs
+	if p.framing == nil {
+		p.framing = base.framing
+	}
+	return p
+}
+
+func (p pacing) latencyOrZero() time.Duration {
+	if p.latency == nil {
+		return 0
+	}
+	return *p.latency
+}
+
+func (p pacing) framingOrZero() SSEFraming {
+	if p.framing == nil {
+		return SSEFraming{}
+	}
+	return *p.framing
+}
+
+func (p pacing) chunkSizeOrDefault() int {
+	if p.chunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return p.chunkSize
+}
+
+func (p pacing) chunkDelayOrDefault() time.Duration {
+	if p.chunkDelay == nil {
+		return DefaultChunkDelay
+	}
+	return *p.chunkDelay
+}
+
+// WithLatency delays resp by d before anything is sent, simulating a slow
+// time to first token.
+func WithLatency(d time.Duration, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.pacing.latency = &d
+		return r
+	}
+}
+
+// WithChunkSize streams the content of resp n bytes per chunk.
+func WithChunkSize(n int, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.pacing.chunkSize = n
+		return r
+	}
+}
+
+// WithChunkDelay pauses for d after each stream chunk of resp. A d of zero
+// streams as fast as possible.
+func WithChunkDelay(d time.Duration, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.pacing.chunkDelay = &d
+		return r
+	}
+}
+
+// WithSplitToolArguments streams the tool call arguments of resp in
+// fragments of the chunk size, as real providers do, so that consumers see
+// tool calls before their input is complete.
+func WithSplitToolArguments(resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.pacing.splitToolArgs = true
+		return r
+	}
+}
+
+// WithLatency delays every response by d unless the response sets its own
+// latency.
+func (s *Server) WithLatency(d time.Duration) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing.latency = &d
+	return s
+}
+
+// WithChunkSize streams responses n content bytes per chunk unless the
+// response sets its own size.
+func (s *Server) WithChunkSize(n int) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing.chunkSize = n
+	return s
+}
+
+// WithChunkDelay pauses for d after each stream chunk unless the response
+// sets its own delay.
+func (s *Server) WithChunkDelay(d time.Duration) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing.chunkDelay = &d
+	return s
+}
+
+// WithSplitToolArguments streams the tool call arguments of every response
+// in fragments of the chunk size.
+func (s *Server) WithSplitToolArguments() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing.splitToolArgs = true
+	return s
+}
+
+// pacingFor returns the pacing of resp with the server's pacing applied.
+func (s *Server) pacingFor(resp *ChatResponse) pacing {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return resp.pacing.over(s.pacing)
+}
+
+// wait pauses for d, returning false if the client went away meanwhile.
+func wait(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return r.Context().Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
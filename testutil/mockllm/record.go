@@ -0,0 +1,239 @@
+package mockllm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Environment variables switching ReplayOrRecord to recording.
+const (
+	RecordBaseURLEnv = "MOCKLLM_RECORD_BASE_URL"
+	RecordAPIKeyEnv  = "MOCKLLM_RECORD_API_KEY"
+)
+
+// Redacted replaces secrets in recordings.
+const Redacted = "[REDACTED]"
+
+// proxyTimeout bounds a proxied request.
+const proxyTimeout = 5 * time.Minute
+
+// secretPatterns match credentials that could end up in prompts or replies.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]{16,}`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{20,}`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+}
+
+// Interaction is a request proxied to a real provider and its response.
+type Interaction struct {
+	Request  ChatRequest  `json:"request"`
+	Response ChatResponse `json:"response"`
+}
+
+// proxy is the provider unmatched requests are forwarded to.
+type proxy struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// ProxyTo forwards requests no handler or sequence step answers to the
+// OpenAI-compatible provider at baseURL, e.g. https://api.openai.com/v1,
+// and records them for SaveRecording. Streaming requests are sent upstream
+// without streaming and streamed back from the complete response.
+func (s *Server) ProxyTo(baseURL, apiKey string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proxy = &proxy{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: proxyTimeout},
+	}
+	return s
+}
+
+// Recording returns the interactions proxied so far, with secrets scrubbed.
+func (s *Server) Recording() []Interaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Interaction{}, s.recorded...)
+}
+
+// SaveRecording writes the interactions proxied so far to a JSON fixture
+// for LoadRecording.
+func (s *Server) SaveRecording(path string) error {
+	data, err := json.MarshalIndent(s.Recording(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadRecording replays a fixture written by SaveRecording: its responses
+// are returned in the recorded order, like Sequence.
+func (s *Server) LoadRecording(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var recording []Interaction
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	responses := make([]ResponseFunc, 0, len(recording))
+	for _, in := range recording {
+		responses = append(responses, func(req *ChatRequest) *ChatResponse {
+			resp := in.Response
+			resp.usageSet = resp.Usage != nil
+			return &resp
+		})
+	}
+	s.Sequence(responses...)
+	return nil
+}
+
+// ReplayOrRecord replays the fixture at path, or, when RecordBaseURLEnv is
+// set, records a new one from that provider with the key in
+// RecordAPIKeyEnv, saved when the test ends:
+//
+//	MOCKLLM_RECORD_BASE_URL=https://api.openai.com/v1 MOCKLLM_RECORD_API_KEY=sk-... go test ./...
+func ReplayOrRecord(t *testing.T, server *Server, path string) {
+	t.Helper()
+
+	baseURL := os.Getenv(RecordBaseURLEnv)
+	if baseURL == "" {
+		if err := server.LoadRecording(path); err != nil {
+			t.Fatalf("Failed to load recording: %v", err)
+		}
+		return
+	}
+
+	server.ProxyTo(baseURL, os.Getenv(RecordAPIKeyEnv))
+	t.Cleanup(func() {
+		if err := server.SaveRecording(path); err != nil {
+			t.Errorf("Failed to save recording: %v", err)
+		}
+	})
+}
+
+// proxyRequest forwards the raw request body to the provider and records
+// the outcome. Failures are answered with an HTTP error.
+func (s *Server) proxyRequest(ctx context.Context, body []byte, req *ChatRequest) *ChatResponse {
+	s.mu.RLock()
+	p := s.proxy
+	s.mu.RUnlock()
+
+	resp, err := p.forward(ctx, body)
+	if err != nil {
+		if s.t != nil {
+			s.t.Logf("mockllm: proxy: %v", err)
+		}
+		var status statusError
+		if errors.As(err, &status) {
+			return HTTPError(int(status))(req)
+		}
+		return HTTPError(http.StatusBadGateway)(req)
+	}
+
+	in := Interaction{Request: *req, Response: *resp}
+	in.scrub(p.apiKey)
+	s.mu.Lock()
+	s.recorded = append(s.recorded, in)
+	s.mu.Unlock()
+	return resp
+}
+
+// statusError is an unsuccessful HTTP status from the provider.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("provider returned %d %s", int(e), http.StatusText(int(e)))
+}
+
+// forward sends body to the provider without streaming and decodes the
+// response.
+func (p *proxy) forward(ctx context.Context, body []byte) (*ChatResponse, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	delete(raw, "stream")
+	delete(raw, "stream_options")
+	body, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, httpResp.Body)
+		return nil, statusError(httpResp.StatusCode)
+	}
+
+	var resp ChatResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decode provider response: %w", err)
+	}
+	resp.usageSet = resp.Usage != nil
+	return &resp, nil
+}
+
+// scrub redacts the API key and anything looking like a credential in the
+// messages and tool calls of the interaction.
+func (in *Interaction) scrub(apiKey string) {
+	redact := func(s string) string {
+		if apiKey != "" {
+			s = strings.ReplaceAll(s, apiKey, Redacted)
+		}
+		for _, re := range secretPatterns {
+			s = re.ReplaceAllString(s, Redacted)
+		}
+		return s
+	}
+	redactMessage := func(m *Message) {
+		m.Content = redact(m.Content)
+		for i := range m.ToolCalls {
+			m.ToolCalls[i].Function.Arguments = redact(m.ToolCalls[i].Function.Arguments)
+		}
+	}
+
+	in.Request.Messages = append([]Message{}, in.Request.Messages...)
+	for i := range in.Request.Messages {
+		in.Request.Messages[i].ToolCalls = append([]ToolCall(nil), in.Request.Messages[i].ToolCalls...)
+		redactMessage(&in.Request.Messages[i])
+	}
+	in.Response.Choices = append([]Choice{}, in.Response.Choices...)
+	for i := range in.Response.Choices {
+		msg := &in.Response.Choices[i].Message
+		msg.ToolCalls = append([]ToolCall(nil), msg.ToolCalls...)
+		redactMessage(msg)
+	}
+}
@@ -2,6 +2,8 @@ package mockllm
 
 import (
 	"encoding/json"
+	"reflect"
+	"regexp"
 	"strings"
 )
 
@@ -10,7 +12,7 @@ import (
 // TextResponse creates a simple text response.
 func TextResponse(content string) func(req *ChatRequest) *ChatResponse {
 	return func(req *ChatRequest) *ChatResponse {
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
@@ -38,13 +40,13 @@ func ToolCallResponse(toolName string, arguments any) func(req *ChatRequest) *Ch
 			}
 		}
 
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
 				Role: "assistant",
 				ToolCalls: []ToolCall{{
-					ID:   "call_" + randomID(),
+					ID:   req.toolCallID(),
 					Type: "function",
 					Function: FunctionCall{
 						Name:      toolName,
@@ -75,7 +77,7 @@ func MultiToolCallResponse(calls ...ToolCallSpec) func(req *ChatRequest) *ChatRe
 				}
 			}
 			toolCalls = append(toolCalls, ToolCall{
-				ID:   "call_" + randomID(),
+				ID:   req.toolCallID(),
 				Type: "function",
 				Function: FunctionCall{
 					Name:      call.Name,
@@ -84,7 +86,7 @@ func MultiToolCallResponse(calls ...ToolCallSpec) func(req *ChatRequest) *ChatRe
 			})
 		}
 
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
@@ -118,14 +120,14 @@ func TextAndToolResponse(content, toolName string, arguments any) func(req *Chat
 			}
 		}
 
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
 				Role:    "assistant",
 				Content: content,
 				ToolCalls: []ToolCall{{
-					ID:   "call_" + randomID(),
+					ID:   req.toolCallID(),
 					Type: "function",
 					Function: FunctionCall{
 						Name:      toolName,
@@ -139,10 +141,35 @@ func TextAndToolResponse(content, toolName string, arguments any) func(req *Chat
 	}
 }
 
+// ReasoningResponse creates a text response preceded by the model's
+// reasoning, as sent by reasoning-capable providers.
+func ReasoningResponse(reasoning, content string) func(req *ChatRequest) *ChatResponse {
+	return WithReasoning(reasoning, TextResponse(content))
+}
+
+// WithReasoning adds reasoning to resp, e.g. to think before calling a tool.
+// Its tokens are reported as reasoning tokens of the completion.
+func WithReasoning(reasoning string, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		if len(r.Choices) == 0 {
+			return r
+		}
+		r.Choices[0].Message.ReasoningContent = reasoning
+		if r.Usage != nil && !r.usageSet {
+			tokens := EstimateTokens(reasoning)
+			r.Usage.CompletionTokens += tokens
+			r.Usage.TotalTokens += tokens
+			r.Usage.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: tokens}
+		}
+		return r
+	}
+}
+
 // ErrorResponse creates a response with an error message.
 func ErrorResponse(errorMessage string) func(req *ChatRequest) *ChatResponse {
 	return func(req *ChatRequest) *ChatResponse {
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
@@ -158,7 +185,7 @@ func ErrorResponse(errorMessage string) func(req *ChatRequest) *ChatResponse {
 // EmptyResponse creates a response with no content (edge case testing).
 func EmptyResponse() func(req *ChatRequest) *ChatResponse {
 	return func(req *ChatRequest) *ChatResponse {
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index:        0,
 			Message:      Message{Role: "assistant"},
@@ -180,7 +207,7 @@ func EchoResponse(prefix string) func(req *ChatRequest) *ChatResponse {
 			}
 		}
 
-		resp := NewResponse(req.Model)
+		resp := NewResponse(req.Model, req.opts...)
 		resp.Choices = []Choice{{
 			Index: 0,
 			Message: Message{
@@ -278,6 +305,59 @@ func MessageCount(n int) MatchFunc {
 	}
 }
 
+// MessageMatchesRegex returns true if the last user message matches the
+// regular expression. It panics if pattern does not compile.
+func MessageMatchesRegex(pattern string) MatchFunc {
+	re := regexp.MustCompile(pattern)
+	return func(req ChatRequest) bool {
+		for i := len(req.Messages) - 1; i >= 0; i-- {
+			if req.Messages[i].Role == "user" {
+				return re.MatchString(req.Messages[i].Content)
+			}
+		}
+		return false
+	}
+}
+
+// ToolDefinitionPresent returns true if the request offers the model a tool
+// with the given name.
+func ToolDefinitionPresent(toolName string) MatchFunc {
+	return func(req ChatRequest) bool {
+		for _, tool := range req.Tools {
+			if tool.Function.Name == toolName {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// BodyJSONPath returns true if any value the JSONPath selects in the request
+// body equals want, compared as JSON. Paths support $, .name, ['name'], [n],
+// [*], and .*, e.g. "$.tools[*].function.name". It panics if path does not
+// compile.
+func BodyJSONPath(path string, want any) MatchFunc {
+	p := mustCompileJSONPath(path)
+	want = normalizeJSON(want)
+	return func(req ChatRequest) bool {
+		for _, v := range p.eval(requestDocument(req)) {
+			if reflect.DeepEqual(v, want) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// BodyJSONPathExists returns true if the JSONPath selects anything in the
+// request body. It panics if path does not compile.
+func BodyJSONPathExists(path string) MatchFunc {
+	p := mustCompileJSONPath(path)
+	return func(req ChatRequest) bool {
+		return len(p.eval(requestDocument(req))) > 0
+	}
+}
+
 // Always returns true for any request.
 func Always() MatchFunc {
 	return func(req ChatRequest) bool {
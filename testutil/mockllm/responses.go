@@ -0,0 +1,468 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+// The server also answers the OpenAI Responses API at /v1/responses. A
+// Responses request is converted to a ChatRequest, so handlers, matchers,
+// scenarios, and assertions work the same on both APIs, and the
+// ChatResponse found for it is sent back as output items. Instructions
+// become a system message, function calls assistant tool calls, and
+// function call outputs tool results named after their call.
+
+// ResponsesRequest is a request to the Responses API.
+type ResponsesRequest struct {
+	Model           string          `json:"model"`
+	Instructions    string          `json:"instructions,omitempty"`
+	Input           ResponsesInput  `json:"input"`
+	Tools           []ResponsesTool `json:"tools,omitempty"`
+	Stream          bool            `json:"stream,omitempty"`
+	MaxOutputTokens int             `json:"max_output_tokens,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+}
+
+// ResponsesInput is the input of a Responses request. A plain string is
+// read as a single user message.
+type ResponsesInput []ResponsesItem
+
+// UnmarshalJSON accepts the input as a string or a list of items.
+func (in *ResponsesInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*in = ResponsesInput{{Type: "message", Role: "user", Content: ResponsesContentList{{Type: "input_text", Text: text}}}}
+		return nil
+	}
+	var items []ResponsesItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*in = items
+	return nil
+}
+
+// ResponsesItem is an input or output item: a message, a function call, a
+// function call output, or reasoning.
+type ResponsesItem struct {
+	Type    string               `json:"type,omitempty"` // message when empty
+	ID      string               `json:"id,omitempty"`
+	Status  string               `json:"status,omitempty"`
+	Role    string               `json:"role,omitempty"`
+	Content ResponsesContentList `json:"content,omitempty"`
+
+	// Function calls and their outputs.
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    string `json:"output,omitempty"`
+
+	// Summary is the reasoning summary of a reasoning item.
+	Summary []ResponsesContent `json:"summary,omitempty"`
+}
+
+// ResponsesContent is a content part: input_text, input_image, output_text,
+// or summary_text.
+type ResponsesContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// ResponsesContentList is the content of a message item. A plain string is
+// read as a single text part.
+type ResponsesContentList []ResponsesContent
+
+// UnmarshalJSON accepts the content as a string or a list of parts.
+func (c *ResponsesContentList) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = ResponsesContentList{{Type: "input_text", Text: text}}
+		return nil
+	}
+	var parts []ResponsesContent
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	*c = parts
+	return nil
+}
+
+// ResponsesTool is a function tool offered in a Responses request. Unlike
+// in chat completions, the function is not nested.
+type ResponsesTool struct {
+	Type        string `json:"type"` // function
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+// ResponsesResponse is a response of the Responses API.
+type ResponsesResponse struct {
+	ID                string             `json:"id"`
+	Object            string             `json:"object"` // response
+	CreatedAt         int64              `json:"created_at"`
+	Status            string             `json:"status"` // completed, incomplete, or in_progress
+	IncompleteDetails *IncompleteDetails `json:"incomplete_details,omitempty"`
+	Model             string             `json:"model"`
+	Output            []ResponsesItem    `json:"output"`
+	Usage             *ResponsesUsage    `json:"usage,omitempty"`
+}
+
+// IncompleteDetails says why a response is incomplete.
+type IncompleteDetails struct {
+	Reason string `json:"reason"` // max_output_tokens
+}
+
+// ResponsesUsage is the token usage of a Responses API response.
+type ResponsesUsage struct {
+	InputTokens         int                           `json:"input_tokens"`
+	OutputTokens        int                           `json:"output_tokens"`
+	TotalTokens         int                           `json:"total_tokens"`
+	OutputTokensDetails *ResponsesOutputTokensDetails `json:"output_tokens_details,omitempty"`
+}
+
+// ResponsesOutputTokensDetails breaks down the output tokens.
+type ResponsesOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// OutputText returns the text of the message items of resp.
+func (r ResponsesResponse) OutputText() string {
+	var sb strings.Builder
+	for _, item := range r.Output {
+		if item.Type != "message" {
+			continue
+		}
+		for _, c := range item.Content {
+			if c.Type == "output_text" {
+				sb.WriteString(c.Text)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func (s *Server) handleResponses(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var rreq ResponsesRequest
+	if err := json.Unmarshal(body, &rreq); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req := rreq.chatRequest()
+	req.raw = body
+
+	// Upstream providers are proxied with chat completions.
+	chatBody, err := json.Marshal(req)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	s.answer(w, r, chatBody, &req, responsesAPI)
+}
+
+// chatRequest converts the request to a chat completions request.
+func (rr ResponsesRequest) chatRequest() ChatRequest {
+	req := ChatRequest{
+		Model:       rr.Model,
+		Stream:      rr.Stream,
+		MaxTokens:   rr.MaxOutputTokens,
+		Temperature: rr.Temperature,
+		TopP:        rr.TopP,
+	}
+	for _, tool := range rr.Tools {
+		req.Tools = append(req.Tools, Tool{
+			Type:     "function",
+			Function: Function{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	if rr.Instructions != "" {
+		req.Messages = append(req.Messages, Message{Role: "system", Content: rr.Instructions})
+	}
+
+	names := make(map[string]string)
+	for _, item := range rr.Input {
+		switch item.Type {
+		case "function_call":
+			names[item.CallID] = item.Name
+			call := ToolCall{ID: item.CallID, Type: "function", Function: FunctionCall{Name: item.Name, Arguments: item.Arguments}}
+			// Calls following an assistant message belong to its turn.
+			if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == "assistant" {
+				req.Messages[n-1].ToolCalls = append(req.Messages[n-1].ToolCalls, call)
+			} else {
+				req.Messages = append(req.Messages, Message{Role: "assistant", ToolCalls: []ToolCall{call}})
+			}
+		case "function_call_output":
+			req.Messages = append(req.Messages, Message{Role: "tool", Name: names[item.CallID], ToolCallID: item.CallID, Content: item.Output})
+		case "", "message":
+			req.Messages = append(req.Messages, item.chatMessage())
+		}
+	}
+	return req
+}
+
+// chatMessage converts a message item to a chat message.
+func (item ResponsesItem) chatMessage() Message {
+	msg := Message{Role: item.Role}
+	var texts []string
+	hasImage := false
+	for _, c := range item.Content {
+		switch c.Type {
+		case "input_image":
+			hasImage = true
+		default:
+			texts = append(texts, c.Text)
+		}
+	}
+	msg.Content = strings.Join(texts, "\n")
+	if hasImage {
+		for _, c := range item.Content {
+			if c.Type == "input_image" {
+				msg.Parts = append(msg.Parts, ImagePart(c.ImageURL))
+			} else {
+				msg.Parts = append(msg.Parts, TextPart(c.Text))
+			}
+		}
+	}
+	return msg
+}
+
+// toResponsesResponse converts a chat response to a Responses API
+// response. Item IDs are derived from the response ID, so they are stable
+// when the IDs are seeded.
+func toResponsesResponse(resp *ChatResponse) *ResponsesResponse {
+	base := strings.TrimPrefix(resp.ID, "chatcmpl-")
+	out := &ResponsesResponse{
+		ID:        "resp_" + base,
+		Object:    "response",
+		CreatedAt: resp.Created,
+		Status:    "completed",
+		Model:     resp.Model,
+		Output:    []ResponsesItem{},
+	}
+	if resp.Usage != nil {
+		out.Usage = &ResponsesUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		}
+		if d := resp.Usage.CompletionTokensDetails; d != nil {
+			out.Usage.OutputTokensDetails = &ResponsesOutputTokensDetails{ReasoningTokens: d.ReasoningTokens}
+		}
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if choice.FinishReason == "length" {
+		out.Status = "incomplete"
+		out.IncompleteDetails = &IncompleteDetails{Reason: "max_output_tokens"}
+	}
+	itemID := func(prefix string) string {
+		return fmt.Sprintf("%s_%s_%d", prefix, base, len(out.Output))
+	}
+	msg := choice.Message
+	if msg.ReasoningContent != "" {
+		out.Output = append(out.Output, ResponsesItem{
+			Type:    "reasoning",
+			ID:      itemID("rs"),
+			Summary: []ResponsesContent{{Type: "summary_text", Text: msg.ReasoningContent}},
+		})
+	}
+	if msg.Content != "" {
+		out.Output = append(out.Output, ResponsesItem{
+			Type:    "message",
+			ID:      itemID("msg"),
+			Status:  "completed",
+			Role:    "assistant",
+			Content: ResponsesContentList{{Type: "output_text", Text: msg.Content}},
+		})
+	}
+	for _, tc := range msg.ToolCalls {
+		out.Output = append(out.Output, ResponsesItem{
+			Type:      "function_call",
+			ID:        itemID("fc"),
+			Status:    "completed",
+			CallID:    tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: tc.Function.Arguments,
+		})
+	}
+	return out
+}
+
+func (s *Server) sendResponsesJSON(w http.ResponseWriter, resp *ChatResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(toResponsesResponse(resp)); err != nil && s.t != nil {
+		s.t.Logf("mockllm: failed to encode response: %v", err)
+	}
+}
+
+// sendResponsesStream streams resp as Responses API events: the response
+// is created, each output item is added, filled in by deltas, and done, and
+// the response is completed.
+func (s *Server) sendResponsesStream(w http.ResponseWriter, r *http.Request, resp *ChatResponse, pace pacing) {
+	sw, ok := startStream(w, r, pace)
+	if !ok {
+		return
+	}
+
+	final := toResponsesResponse(resp)
+	seq := 0
+	emit := func(typ string, fields map[string]any) bool {
+		fields["type"] = typ
+		fields["sequence_number"] = seq
+		seq++
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return true
+		}
+		sw.event(typ, string(data))
+		return wait(r, pace.chunkDelayOrDefault())
+	}
+	pieces := func(s string, size int) []string {
+		var out []string
+		for i := 0; i < len(s); i += size {
+			out = append(out, s[i:min(i+size, len(s))])
+		}
+		return out
+	}
+	size := pace.chunkSizeOrDefault()
+
+	started := *final
+	started.Status = "in_progress"
+	started.IncompleteDetails = nil
+	started.Output = []ResponsesItem{}
+	started.Usage = nil
+	if !emit("response.created", map[string]any{"response": started}) ||
+		!emit("response.in_progress", map[string]any{"response": started}) {
+		return
+	}
+
+	for i, item := range final.Output {
+		added := item
+		switch item.Type {
+		case "reasoning":
+			added.Summary = []ResponsesContent{}
+		case "message":
+			added.Status = "in_progress"
+			added.Content = ResponsesContentList{}
+		case "function_call":
+			added.Status = "in_progress"
+			added.Arguments = ""
+		}
+		if !emit("response.output_item.added", map[string]any{"output_index": i, "item": added}) {
+			return
+		}
+
+		switch item.Type {
+		case "reasoning":
+			text := item.Summary[0].Text
+			ids := map[string]any{"item_id": item.ID, "output_index": i, "summary_index": 0}
+			if !emit("response.reasoning_summary_part.added", withField(ids, "part", ResponsesContent{Type: "summary_text"})) {
+				return
+			}
+			for _, delta := range pieces(text, size) {
+				if !emit("response.reasoning_summary_text.delta", withField(ids, "delta", delta)) {
+					return
+				}
+			}
+			if !emit("response.reasoning_summary_text.done", withField(ids, "text", text)) ||
+				!emit("response.reasoning_summary_part.done", withField(ids, "part", item.Summary[0])) {
+				return
+			}
+		case "message":
+			text := item.Content[0].Text
+			ids := map[string]any{"item_id": item.ID, "output_index": i, "content_index": 0}
+			if !emit("response.content_part.added", withField(ids, "part", ResponsesContent{Type: "output_text"})) {
+				return
+			}
+			for _, delta := range pieces(text, size) {
+				if !emit("response.output_text.delta", withField(ids, "delta", delta)) {
+					return
+				}
+			}
+			if !emit("response.output_text.done", withField(ids, "text", text)) ||
+				!emit("response.content_part.done", withField(ids, "part", item.Content[0])) {
+				return
+			}
+		case "function_call":
+			ids := map[string]any{"item_id": item.ID, "output_index": i}
+			deltas := []string{item.Arguments}
+			if pace.splitToolArgs {
+				deltas = pieces(item.Arguments, size)
+			}
+			for _, delta := range deltas {
+				if !emit("response.function_call_arguments.delta", withField(ids, "delta", delta)) {
+					return
+				}
+			}
+			if !emit("response.function_call_arguments.done", withField(ids, "arguments", item.Arguments)) {
+				return
+			}
+		}
+
+		if !emit("response.output_item.done", map[string]any{"output_index": i, "item": item}) {
+			return
+		}
+	}
+
+	done := "response.completed"
+	if final.Status == "incomplete" {
+		done = "response.incomplete"
+	}
+	emit(done, map[string]any{"response": final})
+}
+
+// withField returns a copy of fields with key set to value.
+func withField(fields map[string]any, key string, value any) map[string]any {
+	out := maps.Clone(fields)
+	out[key] = value
+	return out
+}
+
+// ParseResponsesStream parses a Responses API event stream and returns the
+// response of its response.completed or response.incomplete event, and the
+// text of its output_text deltas.
+func ParseResponsesStream(r io.Reader) (*ResponsesResponse, string, error) {
+	events, err := parseSSEData(r)
+	if err != nil {
+		return nil, "", err
+	}
+	var (
+		final *ResponsesResponse
+		text  strings.Builder
+	)
+	for _, data := range events {
+		var ev struct {
+			Type     string             `json:"type"`
+			Delta    string             `json:"delta"`
+			Response *ResponsesResponse `json:"response"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return nil, "", fmt.Errorf("failed to parse event: %w", err)
+		}
+		switch ev.Type {
+		case "response.output_text.delta":
+			text.WriteString(ev.Delta)
+		case "response.completed", "response.incomplete":
+			final = ev.Response
+		}
+	}
+	if final == nil {
+		return nil, "", fmt.Errorf("stream ended without a completed response")
+	}
+	return final, text.String(), nil
+}
@@ -0,0 +1,47 @@
+package mockllm
+
+import "sync"
+
+// Model IDs the test config gives the large and small model roles, so that
+// requests can be routed by role with ForModel.
+const (
+	LargeModel = "mock-model"
+	SmallModel = "mock-small-model"
+)
+
+// ForModel answers every request for model with respond, ahead of the
+// sequence and handlers, e.g. to script the small model's summaries apart
+// from the main agent's conversation. Use SequenceResponse to answer a
+// model's requests in order.
+func (s *Server) ForModel(model string, respond ResponseFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.models == nil {
+		s.models = make(map[string]ResponseFunc)
+	}
+	s.models[model] = respond
+	return s
+}
+
+// ModelIs returns true if the request is for the model.
+func ModelIs(model string) MatchFunc {
+	return func(req ChatRequest) bool {
+		return req.Model == model
+	}
+}
+
+// SequenceResponse creates a response returning responses in order, one
+// per request, repeating the last once they are used up.
+func SequenceResponse(responses ...ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	var mu sync.Mutex
+	next := 0
+	return func(req *ChatRequest) *ChatResponse {
+		mu.Lock()
+		respond := responses[next]
+		if next < len(responses)-1 {
+			next++
+		}
+		mu.Unlock()
+		return respond(req)
+	}
+}
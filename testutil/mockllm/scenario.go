@@ -0,0 +1,186 @@
+package mockllm
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Scenario answers requests from a state machine: each state has
+// transitions, tried in the order added, that answer a matching request and
+// move to the next state. Flows that branch, like plan, run tools, handle
+// an error, retry, and finish, need no global sequence:
+//
+//	sc := mockllm.NewScenario("plan")
+//	sc.In("plan").
+//		On(mockllm.MessageContains("refactor"), mockllm.ToolCallResponse("bash", cmd), "run")
+//	sc.In("run").
+//		On(mockllm.LastToolResultContains("error"), mockllm.ToolCallResponse("bash", cmd), "run").
+//		Otherwise(mockllm.TextResponse("Done."), "done")
+//	server.Scenario(sc)
+//
+// A request that no transition of the current state matches is answered by
+// the server's other handlers, or fails the test when Strict is set.
+type Scenario struct {
+	mu      sync.Mutex
+	state   string
+	visited []string
+	states  map[string]*ScenarioState
+	strict  bool
+	// unmatched describes the requests a strict scenario had no transition
+	// for.
+	unmatched []string
+}
+
+// ScenarioState is a state of a Scenario, returned by In to add its
+// transitions.
+type ScenarioState struct {
+	transitions []transition
+	otherwise   *transition
+}
+
+// transition answers a request with respond and moves the scenario to next.
+type transition struct {
+	match   MatchFunc
+	respond ResponseFunc
+	next    string
+}
+
+// NewScenario returns a scenario starting in state initial.
+func NewScenario(initial string) *Scenario {
+	return &Scenario{
+		state:   initial,
+		visited: []string{initial},
+		states:  make(map[string]*ScenarioState),
+	}
+}
+
+// In returns the state named name, adding it if needed.
+func (sc *Scenario) In(name string) *ScenarioState {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st, ok := sc.states[name]
+	if !ok {
+		st = &ScenarioState{}
+		sc.states[name] = st
+	}
+	return st
+}
+
+// Strict makes requests that the current state does not answer fail the
+// test with 500 instead of falling through to the server's other handlers.
+// The test fails when it ends, or at AssertScenarioMatched.
+func (sc *Scenario) Strict() *Scenario {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.strict = true
+	return sc
+}
+
+// State returns the current state.
+func (sc *Scenario) State() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.state
+}
+
+// Visited returns the states the scenario has been in, in order, starting
+// with the initial state. A transition to the same state is listed again.
+func (sc *Scenario) Visited() []string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return append([]string{}, sc.visited...)
+}
+
+// Unmatched describes the requests a strict scenario had no transition for,
+// in the order they came.
+func (sc *Scenario) Unmatched() []string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return append([]string{}, sc.unmatched...)
+}
+
+// On answers requests matching match in this state with respond and moves
+// to next.
+func (st *ScenarioState) On(match MatchFunc, respond ResponseFunc, next string) *ScenarioState {
+	st.transitions = append(st.transitions, transition{match, respond, next})
+	return st
+}
+
+// Otherwise answers requests that none of the state's transitions match
+// with respond and moves to next.
+func (st *ScenarioState) Otherwise(respond ResponseFunc, next string) *ScenarioState {
+	st.otherwise = &transition{Always(), respond, next}
+	return st
+}
+
+// find returns the transition of the current state for req, or nil.
+// sc.mu must be held.
+func (sc *Scenario) find(req ChatRequest) *transition {
+	st, ok := sc.states[sc.state]
+	if !ok {
+		return nil
+	}
+	for i := range st.transitions {
+		if st.transitions[i].match(req) {
+			return &st.transitions[i]
+		}
+	}
+	return st.otherwise
+}
+
+// Scenario answers requests from sc ahead of the handlers added before it.
+func (s *Server) Scenario(sc *Scenario) *Server {
+	s.mu.Lock()
+	s.scenarios = append(s.scenarios, sc)
+	s.mu.Unlock()
+
+	match := func(req ChatRequest) bool {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		return sc.strict || sc.find(req) != nil
+	}
+	respond := func(req *ChatRequest) *ChatResponse {
+		sc.mu.Lock()
+		tr := sc.find(*req)
+		if tr == nil {
+			msg := fmt.Sprintf("mockllm: scenario has no transition from %q for the request", sc.state)
+			sc.unmatched = append(sc.unmatched, msg)
+			sc.mu.Unlock()
+			s.reportNow(msg)
+			return HTTPError(http.StatusInternalServerError)(req)
+		}
+		sc.state = tr.next
+		sc.visited = append(sc.visited, tr.next)
+		sc.mu.Unlock()
+		return tr.respond(req)
+	}
+	return s.On(match, respond)
+}
+
+// reportScenarios fails t for the requests the server's strict scenarios
+// had no transition for.
+func (s *Server) reportScenarios(t Logger) {
+	s.mu.RLock()
+	scenarios := slices.Clone(s.scenarios)
+	s.mu.RUnlock()
+	for _, sc := range scenarios {
+		for _, msg := range sc.Unmatched() {
+			t.Errorf("%s", msg)
+		}
+	}
+}
+
+// LastToolResultContains returns true if the last message is a tool result
+// containing the text, e.g. an error the model is to react to.
+func LastToolResultContains(text string) MatchFunc {
+	return func(req ChatRequest) bool {
+		if len(req.Messages) == 0 {
+			return false
+		}
+		last := req.Messages[len(req.Messages)-1]
+		return last.Role == "tool" && strings.Contains(strings.ToLower(last.Content), strings.ToLower(text))
+	}
+}
@@ -0,0 +1,255 @@
+package mockllm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Script is a conversation loaded from a YAML or JSON fixture:
+//
+//	steps:
+//	  - expect: {message_contains: "list files"}
+//	    respond: {tool_call: {name: ls, arguments: {path: "."}}}
+//	  - expect: {tool_result: ls}
+//	    respond: {text: "Here are the files.", latency: 200ms}
+//	rules:
+//	  - match: {message_contains: ping}
+//	    respond: {text: pong}
+//	default: {text: "I don't know how to respond to that."}
+//
+// Steps answer requests in order, like Sequence. A request not matching the
+// step's expect fails the test and is answered with 500. Rules answer like
+// handlers added with On, later rules winning, once there are no steps left.
+type Script struct {
+	Steps   []ScriptStep `yaml:"steps" json:"steps"`
+	Rules   []ScriptRule `yaml:"rules" json:"rules"`
+	Default *ScriptReply `yaml:"default" json:"default"`
+}
+
+// ScriptStep is the expected request and the reply of one step in a script.
+type ScriptStep struct {
+	Expect  ScriptMatch `yaml:"expect" json:"expect"`
+	Respond ScriptReply `yaml:"respond" json:"respond"`
+}
+
+// ScriptRule answers requests matching Match with Respond.
+type ScriptRule struct {
+	Match   ScriptMatch `yaml:"match" json:"match"`
+	Respond ScriptReply `yaml:"respond" json:"respond"`
+}
+
+// ScriptMatch matches requests; all set fields must match, and an empty
+// match matches any request.
+type ScriptMatch struct {
+	Model                string `yaml:"model" json:"model"`
+	MessageContains      string `yaml:"message_contains" json:"message_contains"`
+	MessageEquals        string `yaml:"message_equals" json:"message_equals"`
+	MessageMatches       string `yaml:"message_matches" json:"message_matches"`
+	ToolResult           string `yaml:"tool_result" json:"tool_result"`
+	ToolCall             string `yaml:"tool_call" json:"tool_call"`
+	SystemPromptContains string `yaml:"system_prompt_contains" json:"system_prompt_contains"`
+	MessageCount         int    `yaml:"message_count" json:"message_count"`
+	ToolDefinition       string `yaml:"tool_definition" json:"tool_definition"`
+}
+
+// ScriptReply describes a response. Text and tool calls can be combined;
+// the other kinds stand alone.
+type ScriptReply struct {
+	Text      string         `yaml:"text" json:"text"`
+	Reasoning string         `yaml:"reasoning" json:"reasoning"`
+	ToolCall  *ToolCallSpec  `yaml:"tool_call" json:"tool_call"`
+	ToolCalls []ToolCallSpec `yaml:"tool_calls" json:"tool_calls"`
+	Echo      *string        `yaml:"echo" json:"echo"`
+	Error     string         `yaml:"error" json:"error"`
+	Empty     bool           `yaml:"empty" json:"empty"`
+
+	// Faults, see HTTPError, TimeoutResponse, and MalformedJSONResponse.
+	HTTPError     int           `yaml:"http_error" json:"http_error"`
+	RetryAfter    time.Duration `yaml:"retry_after" json:"retry_after"`
+	Timeout       time.Duration `yaml:"timeout" json:"timeout"`
+	MalformedJSON bool          `yaml:"malformed_json" json:"malformed_json"`
+
+	// Pacing, see WithLatency, WithChunkSize, WithChunkDelay, and
+	// WithSplitToolArguments.
+	Latency            *time.Duration `yaml:"latency" json:"latency"`
+	ChunkSize          int            `yaml:"chunk_size" json:"chunk_size"`
+	ChunkDelay         *time.Duration `yaml:"chunk_delay" json:"chunk_delay"`
+	SplitToolArguments bool           `yaml:"split_tool_arguments" json:"split_tool_arguments"`
+}
+
+// LoadScript reads a script from a YAML or JSON file and applies it to the
+// server, replacing its sequence and adding its rules.
+func (s *Server) LoadScript(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	script, err := ParseScript(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return s.ApplyScript(script)
+}
+
+// ParseScript parses a script from YAML or JSON.
+func ParseScript(data []byte) (*Script, error) {
+	var script Script
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("parse script: %w", err)
+	}
+	return &script, nil
+}
+
+// ApplyScript applies script to the server, replacing its sequence and
+// adding its rules.
+func (s *Server) ApplyScript(script *Script) error {
+	steps := make([]ResponseFunc, 0, len(script.Steps))
+	for i, step := range script.Steps {
+		respond, err := step.Respond.build()
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		steps = append(steps, s.expect(i+1, step.Expect.matcher(), respond))
+	}
+	for i, rule := range script.Rules {
+		respond, err := rule.Respond.build()
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		s.On(rule.Match.matcher(), respond)
+	}
+	if script.Default != nil {
+		respond, err := script.Default.build()
+		if err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+		s.Default(respond)
+	}
+	if len(steps) > 0 {
+		s.Sequence(steps...)
+	}
+	return nil
+}
+
+// expect answers with respond if the request matches, and otherwise fails
+// the test.
+func (s *Server) expect(step int, match MatchFunc, respond ResponseFunc) ResponseFunc {
+	return func(req *ChatRequest) *ChatResponse {
+		if match(*req) {
+			return respond(req)
+		}
+		if s.t != nil {
+			s.t.Errorf("mockllm: request %d does not match the script", step)
+		}
+		return HTTPError(http.StatusInternalServerError)(req)
+	}
+}
+
+func (m ScriptMatch) matcher() MatchFunc {
+	var matchers []MatchFunc
+	if m.Model != "" {
+		matchers = append(matchers, ModelIs(m.Model))
+	}
+	if m.MessageContains != "" {
+		matchers = append(matchers, MessageContains(m.MessageContains))
+	}
+	if m.MessageEquals != "" {
+		matchers = append(matchers, MessageEquals(m.MessageEquals))
+	}
+	if m.MessageMatches != "" {
+		matchers = append(matchers, MessageMatchesRegex(m.MessageMatches))
+	}
+	if m.ToolResult != "" {
+		matchers = append(matchers, HasToolResult(m.ToolResult))
+	}
+	if m.ToolCall != "" {
+		matchers = append(matchers, HasToolCall(m.ToolCall))
+	}
+	if m.SystemPromptContains != "" {
+		matchers = append(matchers, SystemPromptContains(m.SystemPromptContains))
+	}
+	if m.MessageCount > 0 {
+		matchers = append(matchers, MessageCount(m.MessageCount))
+	}
+	if m.ToolDefinition != "" {
+		matchers = append(matchers, ToolDefinitionPresent(m.ToolDefinition))
+	}
+	return And(matchers...)
+}
+
+// build returns the response described by r.
+func (r ScriptReply) build() (ResponseFunc, error) {
+	calls := r.ToolCalls
+	if r.ToolCall != nil {
+		calls = append([]ToolCallSpec{*r.ToolCall}, calls...)
+	}
+
+	var respond ResponseFunc
+	kinds := 0
+	set := func(f ResponseFunc) {
+		respond = f
+		kinds++
+	}
+	switch {
+	case len(calls) > 0:
+		set(textAndToolCalls(r.Text, calls))
+	case r.Text != "":
+		set(TextResponse(r.Text))
+	}
+	if r.Echo != nil {
+		set(EchoResponse(*r.Echo))
+	}
+	if r.Error != "" {
+		set(ErrorResponse(r.Error))
+	}
+	if r.Empty {
+		set(EmptyResponse())
+	}
+	if r.HTTPError != 0 {
+		set(HTTPError(r.HTTPError, r.RetryAfter))
+	}
+	if r.Timeout > 0 {
+		set(TimeoutResponse(r.Timeout))
+	}
+	if r.MalformedJSON {
+		set(MalformedJSONResponse())
+	}
+	switch {
+	case kinds == 0:
+		return nil, errors.New("respond: no response given")
+	case kinds > 1:
+		return nil, errors.New("respond: more than one kind of response given")
+	}
+
+	if r.Reasoning != "" {
+		respond = WithReasoning(r.Reasoning, respond)
+	}
+	if r.Latency != nil {
+		respond = WithLatency(*r.Latency, respond)
+	}
+	if r.ChunkSize > 0 {
+		respond = WithChunkSize(r.ChunkSize, respond)
+	}
+	if r.ChunkDelay != nil {
+		respond = WithChunkDelay(*r.ChunkDelay, respond)
+	}
+	if r.SplitToolArguments {
+		respond = WithSplitToolArguments(respond)
+	}
+	return respond, nil
+}
+
+// textAndToolCalls creates a response with optional text and tool calls.
+func textAndToolCalls(content string, calls []ToolCallSpec) ResponseFunc {
+	tools := MultiToolCallResponse(calls...)
+	return func(req *ChatRequest) *ChatResponse {
+		resp := tools(req)
+		resp.Choices[0].Message.Content = content
+		return resp
+	}
+}
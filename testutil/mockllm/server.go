@@ -1,7 +1,9 @@
 // Package mockllm provides a mock LLM server for E2E testing.
 //
 // It implements an OpenAI-compatible chat completions API that can be configured
-// to return specific responses based on message patterns or sequences.
+// to return specific responses based on message patterns or sequences, the
+// Responses API answered by the same handlers, and an embeddings API
+// returning deterministic pseudo-embeddings.
 //
 // Basic usage:
 //
@@ -22,11 +24,13 @@ package mockllm
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -37,26 +41,61 @@ import (
 type Server struct {
 	httpServer *httptest.Server
 	mu         sync.RWMutex
-	t          *testing.T
+	t          Logger
+	// inTest is set by Start. Failures found while answering a request
+	// are then recorded and reported from the test's cleanup, since the
+	// handler goroutine may outlive the test.
+	inTest bool
 
 	// Response handlers.
 	handlers       []handler
 	defaultHandler ResponseFunc
 	callSequence   []ResponseFunc
 	callIndex      int
+	models         map[string]ResponseFunc
+	pacing         pacing
+	estimateUsage  bool
+	strict         bool
+	contextWindow  int
+	inFlight       int
+	maxInFlight    int
+	responseOpts   []ResponseOption
+
+	// Proxying of unmatched requests, see ProxyTo.
+	proxy    *proxy
+	recorded []Interaction
+
+	// Embeddings endpoint.
+	embed EmbedFunc
+
+	// Scenarios answering requests, whose failures are reported when the
+	// test ends.
+	scenarios []*Scenario
 
 	// Request logging.
-	requests []Request
+	requests          []Request
+	embeddingRequests []EmbeddingRequest
 }
 
 // Request represents a captured request to the mock server.
 type Request struct {
 	Method    string
 	Path      string
+	Header    http.Header
 	Body      ChatRequest
 	Timestamp time.Time
 }
 
+// ToolNames returns the names of the tools the request offered the model, in
+// the order they were sent.
+func (r Request) ToolNames() []string {
+	names := make([]string, 0, len(r.Body.Tools))
+	for _, tool := range r.Body.Tools {
+		names = append(names, tool.Function.Name)
+	}
+	return names
+}
+
 // handler matches requests and returns responses.
 type handler struct {
 	matcher MatchFunc
@@ -81,11 +120,37 @@ func NewServer() *Server {
 // Start starts the HTTP server and returns its URL.
 func (s *Server) Start(t *testing.T) string {
 	s.t = t
+	s.inTest = true
 	s.httpServer = httptest.NewServer(http.HandlerFunc(s.handleRequest))
 	t.Cleanup(s.Close)
+	t.Cleanup(func() { s.reportScenarios(t) })
 	return s.httpServer.URL
 }
 
+// Logger receives what a Server reports to its test, such as requests not
+// matching a script and malformed requests in strict mode. *testing.T is a
+// Logger.
+type Logger interface {
+	Errorf(format string, args ...any)
+	Logf(format string, args ...any)
+}
+
+// Handler returns the server's HTTP handler, for serving it outside a test
+// as cmd/mockllm does. What the server would report to a test goes to log,
+// which may be nil.
+func (s *Server) Handler(log Logger) http.Handler {
+	s.t = log
+	return http.HandlerFunc(s.handleRequest)
+}
+
+// reportNow reports msg to the logger given to Handler. Servers started by
+// a test leave it to be reported when the test ends.
+func (s *Server) reportNow(msg string) {
+	if s.t != nil && !s.inTest {
+		s.t.Errorf("%s", msg)
+	}
+}
+
 // Close shuts down the server.
 func (s *Server) Close() {
 	if s.httpServer != nil {
@@ -123,9 +188,18 @@ func (s *Server) Reset() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.handlers = nil
+	s.models = nil
 	s.callSequence = nil
 	s.callIndex = 0
 	s.requests = nil
+	s.embeddingRequests = nil
+	s.pacing = pacing{}
+	s.estimateUsage = false
+	s.strict = false
+	s.contextWindow = 0
+	s.maxInFlight = s.inFlight
+	s.responseOpts = nil
+	s.recorded = nil
 }
 
 // OnMessage adds a handler that matches when the last user message contains the text.
@@ -170,7 +244,17 @@ func (s *Server) Default(respond ResponseFunc) *Server {
 }
 
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	// Only handle chat completions endpoint.
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/embeddings") {
+		s.handleEmbeddings(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/responses") {
+		s.handleResponses(w, r)
+		return
+	}
+
+	// Otherwise only handle chat completions endpoint.
 	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
@@ -187,23 +271,66 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	req.raw = body
+	s.answer(w, r, body, &req, chatCompletionsAPI)
+}
 
+// api is the API a request came in on, which decides how it is answered.
+type api int
+
+const (
+	chatCompletionsAPI api = iota
+	responsesAPI
+)
+
+// answer logs req, finds its response, and sends it in the format of api.
+// body is forwarded when the request is proxied.
+func (s *Server) answer(w http.ResponseWriter, r *http.Request, body []byte, req *ChatRequest, api api) {
 	// Log the request.
 	s.mu.Lock()
+	req.opts = slices.Clone(s.responseOpts)
 	s.requests = append(s.requests, Request{
 		Method:    r.Method,
 		Path:      r.URL.Path,
-		Body:      req,
+		Header:    r.Header.Clone(),
+		Body:      *req,
 		Timestamp: time.Now(),
 	})
 	s.mu.Unlock()
+	defer s.startRequest()()
 
-	// Find a handler.
-	resp := s.findResponse(&req)
+	if resp := s.validate(req); resp != nil {
+		s.sendFault(w, r, resp.fault, req.Stream)
+		return
+	}
 
-	// Check if streaming is requested.
-	if req.Stream {
-		s.sendStreamResponse(w, resp)
+	// Find a handler.
+	resp := s.findResponse(req)
+	if resp == nil {
+		resp = s.proxyRequest(r.Context(), body, req)
+	}
+	s.applyUsage(req, resp)
+	if resp.barrier != nil && !resp.barrier.wait(r) {
+		return
+	}
+	pace := s.pacingFor(resp)
+	if req.Stream && resp.fault == nil {
+		if api == responsesAPI {
+			s.sendResponsesStream(w, r, resp, pace)
+		} else {
+			s.sendStreamResponse(w, r, resp, pace)
+		}
+		return
+	}
+	if !wait(r, pace.latencyOrZero()) {
+		return
+	}
+	if resp.fault != nil {
+		s.sendFault(w, r, resp.fault, req.Stream)
+		return
+	}
+	if api == responsesAPI {
+		s.sendResponsesJSON(w, resp)
 	} else {
 		s.sendJSONResponse(w, resp)
 	}
@@ -213,6 +340,15 @@ func (s *Server) findResponse(req *ChatRequest) *ChatResponse {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.contextWindow > 0 && promptTokens(req) > s.contextWindow {
+		return ContextWindowExceeded(s.contextWindow)(req)
+	}
+
+	// Routes by model come before everything else.
+	if respond, ok := s.models[req.Model]; ok {
+		return respond(req)
+	}
+
 	// Check sequence first.
 	if len(s.callSequence) > 0 {
 		if s.callIndex < len(s.callSequence) {
@@ -221,7 +357,7 @@ func (s *Server) findResponse(req *ChatRequest) *ChatResponse {
 			return resp
 		}
 		// Sequence exhausted, use default.
-		return s.defaultHandler(req)
+		return s.unmatched(req)
 	}
 
 	// Check handlers in reverse order (last added wins).
@@ -232,6 +368,15 @@ func (s *Server) findResponse(req *ChatRequest) *ChatResponse {
 		}
 	}
 
+	return s.unmatched(req)
+}
+
+// unmatched answers a request no handler matched with the default, or
+// returns nil when it is to be proxied. The caller holds mu.
+func (s *Server) unmatched(req *ChatRequest) *ChatResponse {
+	if s.proxy != nil {
+		return nil
+	}
 	return s.defaultHandler(req)
 }
 
@@ -242,36 +387,33 @@ func (s *Server) sendJSONResponse(w http.ResponseWriter, resp *ChatResponse) {
 	}
 }
 
-func (s *Server) sendStreamResponse(w http.ResponseWriter, resp *ChatResponse) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
+// sendStreamResponse streams resp after its latency.
+func (s *Server) sendStreamResponse(w http.ResponseWriter, r *http.Request, resp *ChatResponse, pace pacing) {
+	sw, ok := startStream(w, r, pace)
 	if !ok {
-		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
 	// Convert response to stream chunks.
-	chunks := responseToStreamChunks(resp)
+	chunks := responseToStreamChunks(resp, pace)
 	for _, chunk := range chunks {
 		data, err := json.Marshal(chunk)
 		if err != nil {
 			continue
 		}
-		fmt.Fprintf(w, "data: %s\n\n", data)
-		flusher.Flush()
-		time.Sleep(10 * time.Millisecond) // Simulate realistic streaming
+		sw.data(string(data))
+		if !wait(r, pace.chunkDelayOrDefault()) {
+			return
+		}
 	}
 
 	// Send done marker.
-	fmt.Fprint(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	sw.data("[DONE]")
 }
 
-func responseToStreamChunks(resp *ChatResponse) []StreamChunk {
+func responseToStreamChunks(resp *ChatResponse, pace pacing) []StreamChunk {
 	var chunks []StreamChunk
+	size := pace.chunkSizeOrDefault()
 
 	if len(resp.Choices) == 0 {
 		return chunks
@@ -279,12 +421,28 @@ func responseToStreamChunks(resp *ChatResponse) []StreamChunk {
 
 	choice := resp.Choices[0]
 
+	// Stream the reasoning before the answer, as providers do.
+	reasoning := choice.Message.ReasoningContent
+	for i := 0; i < len(reasoning); i += size {
+		end := min(i+size, len(reasoning))
+		chunks = append(chunks, StreamChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Model:   resp.Model,
+			Created: resp.Created,
+			Choices: []StreamChoice{{
+				Index: 0,
+				Delta: Delta{ReasoningContent: reasoning[i:end]},
+			}},
+		})
+	}
+
 	// If there's content, stream it character by character (or in small chunks).
 	if choice.Message.Content != "" {
 		content := choice.Message.Content
-		// Stream in chunks of ~20 chars for realistic simulation.
-		for i := 0; i < len(content); i += 20 {
-			end := i + 20
+		// Stream in chunks of size bytes.
+		for i := 0; i < len(content); i += size {
+			end := i + size
 			if end > len(content) {
 				end = len(content)
 			}
@@ -302,28 +460,32 @@ func responseToStreamChunks(resp *ChatResponse) []StreamChunk {
 	}
 
 	// Stream tool calls.
-	for _, tc := range choice.Message.ToolCalls {
-		// Tool call start.
-		chunks = append(chunks, StreamChunk{
-			ID:      resp.ID,
-			Object:  "chat.completion.chunk",
-			Model:   resp.Model,
-			Created: resp.Created,
-			Choices: []StreamChoice{{
-				Index: 0,
-				Delta: Delta{
-					ToolCalls: []ToolCallDelta{{
-						Index: 0,
-						ID:    tc.ID,
-						Type:  tc.Type,
-						Function: FunctionDelta{
-							Name:      tc.Function.Name,
-							Arguments: tc.Function.Arguments,
-						},
-					}},
-				},
-			}},
-		})
+	if pace.splitToolArgs {
+		chunks = append(chunks, splitToolCallChunks(resp, choice.Message.ToolCalls, size)...)
+	} else {
+		for _, tc := range choice.Message.ToolCalls {
+			// Tool call start.
+			chunks = append(chunks, StreamChunk{
+				ID:      resp.ID,
+				Object:  "chat.completion.chunk",
+				Model:   resp.Model,
+				Created: resp.Created,
+				Choices: []StreamChoice{{
+					Index: 0,
+					Delta: Delta{
+						ToolCalls: []ToolCallDelta{{
+							Index: 0,
+							ID:    tc.ID,
+							Type:  tc.Type,
+							Function: FunctionDelta{
+								Name:      tc.Function.Name,
+								Arguments: tc.Function.Arguments,
+							},
+						}},
+					},
+				}},
+			})
+		}
 	}
 
 	// Final chunk with finish reason.
@@ -351,28 +513,103 @@ func responseToStreamChunks(resp *ChatResponse) []StreamChunk {
 	return chunks
 }
 
+// splitToolCallChunks streams each tool call as real providers do: a first
+// delta with the ID and name, then the arguments in fragments of size bytes
+// carrying only the tool call's index.
+func splitToolCallChunks(resp *ChatResponse, toolCalls []ToolCall, size int) []StreamChunk {
+	var chunks []StreamChunk
+	chunk := func(delta ToolCallDelta) StreamChunk {
+		return StreamChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Model:   resp.Model,
+			Created: resp.Created,
+			Choices: []StreamChoice{{
+				Index: 0,
+				Delta: Delta{ToolCalls: []ToolCallDelta{delta}},
+			}},
+		}
+	}
+	for i, tc := range toolCalls {
+		chunks = append(chunks, chunk(ToolCallDelta{
+			Index:    i,
+			ID:       tc.ID,
+			Type:     tc.Type,
+			Function: FunctionDelta{Name: tc.Function.Name},
+		}))
+		args := tc.Function.Arguments
+		for start := 0; start < len(args); start += size {
+			end := min(start+size, len(args))
+			chunks = append(chunks, chunk(ToolCallDelta{
+				Index:    i,
+				Function: FunctionDelta{Arguments: args[start:end]},
+			}))
+		}
+	}
+	return chunks
+}
+
 // ParseSSEStream parses an SSE stream and returns chunks.
 // Useful for testing streaming responses.
 func ParseSSEStream(r io.Reader) ([]StreamChunk, error) {
+	events, err := parseSSEData(r)
+	if err != nil {
+		return nil, err
+	}
 	var chunks []StreamChunk
-	scanner := bufio.NewScanner(r)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-		data := strings.TrimPrefix(line, "data: ")
+	for _, data := range events {
 		if data == "[DONE]" {
 			break
 		}
-
 		var chunk StreamChunk
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			return nil, fmt.Errorf("failed to parse chunk: %w", err)
 		}
 		chunks = append(chunks, chunk)
 	}
+	return chunks, nil
+}
+
+// parseSSEData returns the data of each event of an SSE stream. Events
+// are one data line each, as the mock and OpenAI send them.
+func parseSSEData(r io.Reader) ([]string, error) {
+	var events []string
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanSSELines)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		events = append(events, strings.TrimPrefix(data, " "))
+	}
+	return events, scanner.Err()
+}
 
-	return chunks, scanner.Err()
+// scanSSELines is a bufio.SplitFunc for lines ending in "\r\n", "\n", or
+// "\r", the line endings SSE allows.
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A "\r" may be followed by "\n" in the next read.
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
 }
@@ -2,10 +2,19 @@ package mockllm
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -231,6 +240,62 @@ func TestServerStreaming(t *testing.T) {
 	require.Equal(t, "Hello streaming world!", content)
 }
 
+func TestSSEFraming(t *testing.T) {
+	t.Parallel()
+
+	stream := func(t *testing.T, server *Server) string {
+		t.Helper()
+		url := server.Start(t)
+		body, err := json.Marshal(ChatRequest{Model: "test-model", Stream: true})
+		require.NoError(t, err)
+		resp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+	content := func(t *testing.T, raw string) string {
+		t.Helper()
+		chunks, err := ParseSSEStream(strings.NewReader(raw))
+		require.NoError(t, err)
+		var sb strings.Builder
+		for _, chunk := range chunks {
+			if len(chunk.Choices) > 0 {
+				sb.WriteString(chunk.Choices[0].Delta.Content)
+			}
+		}
+		return sb.String()
+	}
+
+	for _, eol := range []string{"\n", "\r\n", "\r"} {
+		t.Run(fmt.Sprintf("line ending %q", eol), func(t *testing.T) {
+			t.Parallel()
+			server := NewServer().WithChunkDelay(0)
+			server.OnAny(WithSSEFraming(SSEFraming{Comments: true, LineEnding: eol, WriteSize: 7},
+				TextResponse("Hello framed world!")))
+
+			raw := stream(t, server)
+			require.True(t, strings.HasPrefix(raw, ": keep-alive"+eol+eol+"data: {"))
+			require.True(t, strings.HasSuffix(raw, "data: [DONE]"+eol+eol))
+			require.Equal(t, "Hello framed world!", content(t, raw))
+		})
+	}
+
+	t.Run("keep-alive while held", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer().WithSSEFraming(SSEFraming{KeepAlive: 40 * time.Millisecond})
+		server.OnAny(WithLatency(150*time.Millisecond, TextResponse("late")))
+
+		raw := stream(t, server)
+		before, _, ok := strings.Cut(raw, "data: ")
+		require.True(t, ok)
+		require.GreaterOrEqual(t, strings.Count(before, ": keep-alive\n\n"), 3)
+		require.Equal(t, "late", content(t, raw))
+	})
+}
+
 func TestMatcherCombinators(t *testing.T) {
 	t.Parallel()
 
@@ -396,3 +461,1091 @@ func TestTestConfig(t *testing.T) {
 	mock := providers["mock"].(map[string]any)
 	require.Equal(t, ts.URL, mock["base_url"])
 }
+
+func TestServerFaults(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, client *http.Client, url string, stream bool) *http.Response {
+		t.Helper()
+		body, err := json.Marshal(ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "hi"}},
+			Stream:   stream,
+		})
+		require.NoError(t, err)
+		resp, err := client.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("http error", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(HTTPError(http.StatusTooManyRequests, 1500*time.Millisecond))
+		url := server.Start(t)
+
+		resp := post(t, http.DefaultClient, url, false)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, "2", resp.Header.Get("Retry-After"))
+		require.Equal(t, "1500", resp.Header.Get("retry-after-ms"))
+
+		var body APIErrorBody
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		require.Equal(t, "rate_limit_error", body.Error.Type)
+		require.Equal(t, "429", body.Error.Code)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(TimeoutResponse(time.Minute))
+		url := server.Start(t)
+
+		client := &http.Client{Timeout: 50 * time.Millisecond}
+		body, err := json.Marshal(ChatRequest{Model: "test-model"})
+		require.NoError(t, err)
+		_, err = client.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+		require.Error(t, err)
+
+		server.OnAny(TimeoutResponse(10 * time.Millisecond))
+		resp := post(t, http.DefaultClient, url, false)
+		require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	})
+
+	t.Run("malformed json", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(MalformedJSONResponse())
+		url := server.Start(t)
+
+		resp := post(t, http.DefaultClient, url, false)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var chatResp ChatResponse
+		require.Error(t, json.NewDecoder(resp.Body).Decode(&chatResp))
+
+		resp = post(t, http.DefaultClient, url, true)
+		_, err := ParseSSEStream(resp.Body)
+		require.Error(t, err)
+	})
+
+	t.Run("fail n times then", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(FailNTimesThen(2, TextResponse("recovered")))
+		url := server.Start(t)
+
+		for range 2 {
+			resp := post(t, http.DefaultClient, url, false)
+			require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		}
+		resp := sendChatRequest(t, url, ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		})
+		require.Equal(t, "recovered", resp.Choices[0].Message.Content)
+		AssertRequestCount(t, server, 3)
+	})
+}
+
+func TestServerPacing(t *testing.T) {
+	t.Parallel()
+
+	stream := func(t *testing.T, url string) ([]StreamChunk, time.Duration) {
+		t.Helper()
+		body, err := json.Marshal(ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "hi"}},
+			Stream:   true,
+		})
+		require.NoError(t, err)
+		started := time.Now()
+		resp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		firstByte := time.Since(started)
+		chunks, err := ParseSSEStream(resp.Body)
+		require.NoError(t, err)
+		return chunks, firstByte
+	}
+
+	t.Run("server default", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer().WithLatency(100 * time.Millisecond).WithChunkSize(4).WithChunkDelay(0)
+		server.OnAny(TextResponse("Hello world!"))
+		url := server.Start(t)
+
+		chunks, firstByte := stream(t, url)
+		require.GreaterOrEqual(t, firstByte, 100*time.Millisecond)
+		// Three content chunks and the finish chunk.
+		require.Len(t, chunks, 4)
+		require.Equal(t, "Hell", chunks[0].Choices[0].Delta.Content)
+	})
+
+	t.Run("response overrides", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer().WithLatency(time.Minute)
+		server.OnAny(WithLatency(0, WithChunkSize(6, WithChunkDelay(50*time.Millisecond, TextResponse("Hello world!")))))
+		url := server.Start(t)
+
+		started := time.Now()
+		chunks, _ := stream(t, url)
+		require.Len(t, chunks, 3)
+		require.Equal(t, "Hello ", chunks[0].Choices[0].Delta.Content)
+		require.GreaterOrEqual(t, time.Since(started), 150*time.Millisecond)
+	})
+
+	t.Run("non-streaming latency", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(WithLatency(50*time.Millisecond, TextResponse("slow")))
+		url := server.Start(t)
+
+		started := time.Now()
+		resp := sendChatRequest(t, url, ChatRequest{Model: "test-model"})
+		require.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+		require.Equal(t, "slow", resp.Choices[0].Message.Content)
+	})
+}
+
+func TestServerSplitToolArguments(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer().WithChunkSize(8).WithChunkDelay(0)
+	server.OnAny(WithSplitToolArguments(MultiToolCallResponse(
+		ToolCallSpec{Name: "view", Arguments: map[string]any{"file_path": "main.go"}},
+		ToolCallSpec{Name: "ping", Arguments: map[string]any{}},
+	)))
+	url := server.Start(t)
+
+	body, err := json.Marshal(ChatRequest{Model: "test-model", Stream: true})
+	require.NoError(t, err)
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	chunks, err := ParseSSEStream(resp.Body)
+	require.NoError(t, err)
+
+	names := map[int]string{}
+	args := map[int]string{}
+	fragments := map[int]int{}
+	for _, chunk := range chunks {
+		for _, tc := range chunk.Choices[0].Delta.ToolCalls {
+			if tc.Function.Name != "" {
+				require.NotEmpty(t, tc.ID)
+				require.Empty(t, tc.Function.Arguments)
+				names[tc.Index] = tc.Function.Name
+				continue
+			}
+			require.Empty(t, tc.ID)
+			require.LessOrEqual(t, len(tc.Function.Arguments), 8)
+			args[tc.Index] += tc.Function.Arguments
+			fragments[tc.Index]++
+		}
+	}
+
+	require.Equal(t, map[int]string{0: "view", 1: "ping"}, names)
+	require.JSONEq(t, `{"file_path":"main.go"}`, args[0])
+	require.Greater(t, fragments[0], 1)
+	require.JSONEq(t, `{}`, args[1])
+}
+
+func TestServerLoadScript(t *testing.T) {
+	t.Parallel()
+
+	t.Run("yaml", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		require.NoError(t, server.LoadScript("testdata/conversation.yaml"))
+		url := server.Start(t)
+
+		resp := sendChatRequest(t, url, ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "please list files"}},
+		})
+		msg := resp.Choices[0].Message
+		require.Equal(t, "Let me look.", msg.Content)
+		require.Len(t, msg.ToolCalls, 1)
+		require.Equal(t, "ls", msg.ToolCalls[0].Function.Name)
+		require.JSONEq(t, `{"path":"."}`, msg.ToolCalls[0].Function.Arguments)
+
+		resp = sendChatRequest(t, url, ChatRequest{
+			Model: "test-model",
+			Messages: []Message{
+				{Role: "user", Content: "please list files"},
+				{Role: "assistant", ToolCalls: msg.ToolCalls},
+				{Role: "tool", Name: "ls", ToolCallID: msg.ToolCalls[0].ID, Content: "a.go\nb.go"},
+			},
+		})
+		require.Equal(t, "There are two files.", resp.Choices[0].Message.Content)
+
+		// The steps are used up, so the default answers.
+		resp = sendChatRequest(t, url, ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "ping"}},
+		})
+		require.Equal(t, "Error: unexpected request", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		require.NoError(t, server.LoadScript("testdata/retry.json"))
+		url := server.Start(t)
+
+		body, err := json.Marshal(ChatRequest{Model: "test-model"})
+		require.NoError(t, err)
+		httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		httpResp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, httpResp.StatusCode)
+		require.Equal(t, "100", httpResp.Header.Get("retry-after-ms"))
+
+		resp := sendChatRequest(t, url, ChatRequest{Model: "test-model"})
+		require.Equal(t, "Recovered", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		t.Parallel()
+		script, err := ParseScript([]byte(`
+rules:
+  - respond: {echo: "You said: "}
+  - match: {message_contains: ping}
+    respond: {text: pong}
+`))
+		require.NoError(t, err)
+		server := NewServer()
+		require.NoError(t, server.ApplyScript(script))
+		url := server.Start(t)
+
+		resp := sendChatRequest(t, url, ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "ping"}},
+		})
+		require.Equal(t, "pong", resp.Choices[0].Message.Content)
+		resp = sendChatRequest(t, url, ChatRequest{
+			Model:    "test-model",
+			Messages: []Message{{Role: "user", Content: "hello"}},
+		})
+		require.Equal(t, "You said: hello", resp.Choices[0].Message.Content)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		for _, src := range []string{
+			`steps: [{respond: {}}]`,
+			`steps: [{respond: {text: hi, empty: true}}]`,
+			`rules: [{respond: {timeout: soon}}]`,
+		} {
+			script, err := ParseScript([]byte(src))
+			if err == nil {
+				err = NewServer().ApplyScript(script)
+			}
+			require.Error(t, err, src)
+		}
+		require.Error(t, NewServer().LoadScript("testdata/missing.yaml"))
+	})
+}
+
+func TestServerRecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	const apiKey = "sk-test-0123456789abcdefghij"
+	upstream := NewServer()
+	upstream.OnMessage("key", TextResponse("Your key is "+apiKey+"."))
+	upstream.OnMessage("busy", HTTPError(http.StatusTooManyRequests))
+	upstreamURL := upstream.Start(t)
+
+	server := NewServer().ProxyTo(upstreamURL+"/v1", apiKey)
+	server.OnMessage("local", TextResponse("answered locally"))
+	url := server.Start(t)
+
+	resp := sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "local"}},
+	})
+	require.Equal(t, "answered locally", resp.Choices[0].Message.Content)
+
+	// Unmatched requests reach the provider, streamed or not.
+	resp = sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "what is my key? " + apiKey}},
+	})
+	require.Equal(t, "Your key is "+apiKey+".", resp.Choices[0].Message.Content)
+	require.False(t, upstream.LastRequest().Body.Stream)
+
+	body, err := json.Marshal(ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "busy"}},
+	})
+	require.NoError(t, err)
+	httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	httpResp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, httpResp.StatusCode)
+
+	// Only the successful proxied request is recorded, without the key.
+	recording := server.Recording()
+	require.Len(t, recording, 1)
+	require.Equal(t, "what is my key? "+Redacted, recording[0].Request.Messages[0].Content)
+	require.Equal(t, "Your key is "+Redacted+".", recording[0].Response.Choices[0].Message.Content)
+
+	path := t.TempDir() + "/recording.json"
+	require.NoError(t, server.SaveRecording(path))
+
+	replay := NewServer()
+	require.NoError(t, replay.LoadRecording(path))
+	replayURL := replay.Start(t)
+	resp = sendChatRequest(t, replayURL, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "anything"}},
+	})
+	require.Equal(t, "Your key is "+Redacted+".", resp.Choices[0].Message.Content)
+}
+
+func TestServerUsage(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer().WithEstimatedUsage()
+	server.OnMessage("fixed", WithUsage(1200, 300, TextResponse("ok")))
+	server.OnMessage("estimate", TextResponse("sixteen chars!!!"))
+	url := server.Start(t)
+
+	resp := sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "fixed"}},
+	})
+	require.Equal(t, &Usage{PromptTokens: 1200, CompletionTokens: 300, TotalTokens: 1500}, resp.Usage)
+
+	resp = sendChatRequest(t, url, ChatRequest{
+		Model: "test-model",
+		Messages: []Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: "estimate"},
+		},
+	})
+	require.Equal(t, &Usage{PromptTokens: 7 + 2, CompletionTokens: 4, TotalTokens: 13}, resp.Usage)
+
+	pricing := Pricing{CostPer1MIn: 3, CostPer1MOut: 15}
+	require.InDelta(t, 0.0081, pricing.Cost(Usage{PromptTokens: 1200, CompletionTokens: 300}), 1e-9)
+
+	var config map[string]any
+	require.NoError(t, json.Unmarshal([]byte(TestConfigWithPricing(url, pricing)), &config))
+	model := config["providers"].(map[string]any)["mock"].(map[string]any)["models"].([]any)[0].(map[string]any)
+	require.Equal(t, 3.0, model["cost_per_1m_in"])
+	require.Equal(t, 15.0, model["cost_per_1m_out"])
+}
+
+func TestConfigBuilder(t *testing.T) {
+	t.Parallel()
+
+	statusDir := `C:\Users\o"brien\status`
+	cfg := NewConfig("http://127.0.0.1:1234").
+		Plugin("agent-status", map[string]any{"status_dir": statusDir}).
+		Plugin("periodic-prompts", map[string]any{"enabled": true}).
+		DisablePlugins("ping", "tavily").
+		Option("debug", true).
+		Model("small", MockProvider, LargeModel)
+
+	var config struct {
+		Providers map[string]Provider       `json:"providers"`
+		Models    map[string]ModelSelection `json:"models"`
+		Options   struct {
+			Debug           bool                      `json:"debug"`
+			DisabledPlugins []string                  `json:"disabled_plugins"`
+			Plugins         map[string]map[string]any `json:"plugins"`
+		} `json:"options"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(cfg.JSON()), &config))
+	require.Equal(t, "http://127.0.0.1:1234", config.Providers[MockProvider].BaseURL)
+	require.Equal(t, ModelSelection{Provider: MockProvider, Model: LargeModel}, config.Models["small"])
+	require.True(t, config.Options.Debug)
+	require.Equal(t, []string{"ping", "tavily"}, config.Options.DisabledPlugins)
+	require.Equal(t, statusDir, config.Options.Plugins["agent-status"]["status_dir"])
+	require.Equal(t, true, config.Options.Plugins["periodic-prompts"]["enabled"])
+
+	dir := cfg.Setup(t)
+	for _, sub := range []string{"config", "data"} {
+		data, err := os.ReadFile(filepath.Join(dir, sub, "crush", "crush.json"))
+		require.NoError(t, err)
+		require.JSONEq(t, cfg.JSON(), string(data))
+	}
+
+	cfg.Set("options", map[string]any{"plugins": map[string]any{}})
+	require.NotContains(t, cfg.JSON(), "disabled_plugins")
+}
+
+func TestRequestHeaders(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	url := server.Start(t)
+
+	body, err := json.Marshal(ChatRequest{Model: "test-model"})
+	require.NoError(t, err)
+	for range 2 {
+		req, err := http.NewRequest(http.MethodPost, url+"/v1/chat/completions", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+MockAPIKey)
+		req.Header.Set("X-Tenant", "acme")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	last := server.LastRequest()
+	require.NotNil(t, last)
+	require.Equal(t, "acme", last.Header.Get("X-Tenant"))
+	AssertHeader(t, server, "Authorization", "Bearer "+MockAPIKey)
+	AssertHeader(t, server, "X-Tenant", "acme")
+}
+
+func TestToolOffered(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	url := server.Start(t)
+
+	sendChatRequest(t, url, ChatRequest{
+		Model: "test-model",
+		Tools: []Tool{
+			{Type: "function", Function: Function{Name: "view"}},
+			{Type: "function", Function: Function{Name: "ping"}},
+		},
+	})
+	sendChatRequest(t, url, ChatRequest{Model: "test-model"})
+
+	require.Equal(t, []string{"view", "ping"}, server.Requests()[0].ToolNames())
+	require.Empty(t, server.LastRequest().ToolNames())
+	AssertToolOffered(t, server, "ping")
+	AssertToolNotOffered(t, server, "bash")
+}
+
+func TestContextWindowExceeded(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	server.OnAny(TextResponse("fits"))
+	server.On(PromptTokensOver(10), ContextWindowExceeded(10))
+	url := server.Start(t)
+
+	resp := sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "short"}},
+	})
+	require.Equal(t, "fits", resp.Choices[0].Message.Content)
+
+	body, err := json.Marshal(ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: strings.Repeat("long ", 20)}},
+	})
+	require.NoError(t, err)
+	httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, httpResp.StatusCode)
+
+	var apiErr APIErrorBody
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&apiErr))
+	require.Equal(t, "context_length_exceeded", apiErr.Error.Code)
+	require.Equal(t, "invalid_request_error", apiErr.Error.Type)
+	require.Contains(t, apiErr.Error.Message, "maximum context length is 10 tokens")
+	require.Contains(t, apiErr.Error.Message, "resulted in 25 tokens")
+
+	server.Reset()
+	server.Sequence(TextResponse("summary"))
+	server.ContextWindow(10)
+	httpResp, err = http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, httpResp.StatusCode)
+	resp = sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "short"}},
+	})
+	require.Equal(t, "summary", resp.Choices[0].Message.Content)
+}
+
+func TestBarrier(t *testing.T) {
+	t.Parallel()
+
+	barrier := NewBarrier(3)
+	server := NewServer()
+	server.OnAny(WithBarrier(barrier, TextResponse("done")))
+	server.OnMessage("slow", WithLatency(100*time.Millisecond, WithBarrier(barrier, TextResponse("slow"))))
+	url := server.Start(t)
+
+	var (
+		mu       sync.Mutex
+		finished []string
+		wg       sync.WaitGroup
+	)
+	for _, content := range []string{"slow", "fast", "fast"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := sendChatRequest(t, url, ChatRequest{
+				Model:    "test-model",
+				Messages: []Message{{Role: "user", Content: content}},
+			})
+			mu.Lock()
+			finished = append(finished, resp.Choices[0].Message.Content)
+			mu.Unlock()
+		}()
+	}
+
+	require.Eventually(t, func() bool { return barrier.Arrived() == 3 }, 5*time.Second, 10*time.Millisecond)
+	wg.Wait()
+	require.Equal(t, []string{"done", "done", "slow"}, finished)
+	AssertConcurrentRequests(t, server, 3)
+
+	released := NewBarrier(2)
+	released.Release()
+	server.Reset()
+	server.OnAny(WithBarrier(released, TextResponse("through")))
+	resp := sendChatRequest(t, url, ChatRequest{Model: "test-model"})
+	require.Equal(t, "through", resp.Choices[0].Message.Content)
+}
+
+func TestAssertToolResultOrder(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	url := server.Start(t)
+
+	sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{
+		{Role: "tool", Name: "agent_b", Content: "b"},
+		{Role: "tool", Name: "view", Content: "file"},
+	}})
+	sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{
+		{Role: "tool", Name: "agent_b", Content: "b"},
+		{Role: "tool", Name: "agent_a", Content: "a"},
+	}})
+
+	AssertToolResultOrder(t, server, "agent_b", "agent_a")
+}
+
+func TestSeededIDs(t *testing.T) {
+	t.Parallel()
+
+	frozen := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	run := func(seed uint64) []string {
+		server := NewServer().SeedIDs(seed).FreezeTime(frozen)
+		server.Sequence(
+			ToolCallResponse("view", map[string]any{"file_path": "a.go"}),
+			TextResponse("done"),
+		)
+		url := server.Start(t)
+
+		body, err := json.Marshal(ChatRequest{Model: "test-model"})
+		require.NoError(t, err)
+		var bodies []string
+		for range 2 {
+			resp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			bodies = append(bodies, string(data))
+		}
+		return bodies
+	}
+
+	first := run(42)
+	require.Equal(t, first, run(42))
+	require.NotEqual(t, first, run(7))
+	require.Contains(t, first[0], fmt.Sprintf(`"created":%d`, frozen.Unix()))
+
+	ids := NewIDGenerator(1)
+	resp := NewResponse("m", SeededIDs(ids), FrozenTime(frozen))
+	require.Equal(t, frozen.Unix(), resp.Created)
+	require.Equal(t, "chatcmpl-mock-"+NewIDGenerator(1).Next(), resp.ID)
+}
+
+func TestScenario(t *testing.T) {
+	t.Parallel()
+
+	bash := map[string]any{"command": "go test ./..."}
+	sc := NewScenario("plan")
+	sc.In("plan").
+		On(MessageContains("fix the tests"), ToolCallResponse("bash", bash), "run")
+	sc.In("run").
+		On(LastToolResultContains("FAIL"), ToolCallResponse("bash", bash), "run").
+		Otherwise(TextResponse("All tests pass."), "done")
+
+	server := NewServer()
+	server.Default(TextResponse("fallback"))
+	server.Scenario(sc)
+	url := server.Start(t)
+
+	user := Message{Role: "user", Content: "please fix the tests"}
+	call := Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "bash", Arguments: "{}"}}}}
+	result := func(content string) Message {
+		return Message{Role: "tool", Name: "bash", ToolCallID: "call_1", Content: content}
+	}
+
+	resp := sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{{Role: "user", Content: "hello"}}})
+	require.Equal(t, "fallback", resp.Choices[0].Message.Content)
+	require.Equal(t, "plan", sc.State())
+
+	resp = sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{user}})
+	require.Equal(t, "bash", resp.Choices[0].Message.ToolCalls[0].Function.Name)
+
+	resp = sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{user, call, result("FAIL: TestX")}})
+	require.Equal(t, "bash", resp.Choices[0].Message.ToolCalls[0].Function.Name)
+
+	resp = sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{user, call, result("FAIL: TestX"), call, result("ok")}})
+	require.Equal(t, "All tests pass.", resp.Choices[0].Message.Content)
+	require.Equal(t, []string{"plan", "run", "run", "done"}, sc.Visited())
+
+	resp = sendChatRequest(t, url, ChatRequest{Model: "test-model", Messages: []Message{user}})
+	require.Equal(t, "fallback", resp.Choices[0].Message.Content)
+}
+
+func TestScenarioStrict(t *testing.T) {
+	t.Parallel()
+
+	sc := NewScenario("start").Strict()
+	sc.In("start").On(MessageContains("go"), TextResponse("went"), "end")
+
+	server := NewServer()
+	server.Scenario(sc)
+	log := &recordingLogger{}
+	ts := httptest.NewServer(server.Handler(log))
+	t.Cleanup(ts.Close)
+
+	body, err := json.Marshal(ChatRequest{Model: "test-model", Messages: []Message{{Role: "user", Content: "stop"}}})
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Len(t, log.errors, 1)
+	require.Contains(t, log.errors[0], `no transition from "start"`)
+	require.Equal(t, log.errors, sc.Unmatched())
+}
+
+func TestScenarioStrictInTest(t *testing.T) {
+	t.Parallel()
+
+	sc := NewScenario("start").Strict()
+	server := NewServer()
+	server.Scenario(sc)
+	log := &recordingLogger{}
+	ts := httptest.NewServer(server.Handler(log))
+	t.Cleanup(ts.Close)
+	// As after Start: nothing is reported from the handler goroutine.
+	server.inTest = true
+
+	body, err := json.Marshal(ChatRequest{Model: "test-model", Messages: []Message{{Role: "user", Content: "stop"}}})
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Empty(t, log.errors)
+	require.Len(t, sc.Unmatched(), 1)
+
+	// The cleanup registered by Start reports what was recorded.
+	server.reportScenarios(log)
+	require.Len(t, log.errors, 1)
+	require.Contains(t, log.errors[0], `no transition from "start"`)
+}
+
+// recordingLogger is a Logger keeping the errors reported to it.
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Errorf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Logf(string, ...any) {}
+
+func TestResponsesAPI(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, url string, req any) *http.Response {
+		t.Helper()
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		resp, err := http.Post(url+"/v1/responses", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("tool round trip", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnMessage("list", ToolCallResponse("ls", map[string]any{"path": "."}))
+		server.OnToolResult("ls", TextResponse("Two files."))
+		url := server.Start(t)
+
+		resp := post(t, url, map[string]any{
+			"model":        "test-model",
+			"instructions": "Be brief.",
+			"input":        "list the files",
+			"tools":        []map[string]any{{"type": "function", "name": "ls"}},
+		})
+		var out ResponsesResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		require.Equal(t, "response", out.Object)
+		require.Equal(t, "completed", out.Status)
+		require.Len(t, out.Output, 1)
+		call := out.Output[0]
+		require.Equal(t, "function_call", call.Type)
+		require.Equal(t, "ls", call.Name)
+		require.JSONEq(t, `{"path":"."}`, call.Arguments)
+		require.Equal(t, 150, out.Usage.TotalTokens)
+
+		last := server.LastRequest()
+		require.Equal(t, "/v1/responses", last.Path)
+		require.Equal(t, []string{"ls"}, last.ToolNames())
+		require.Equal(t, "system", last.Body.Messages[0].Role)
+
+		resp = post(t, url, map[string]any{
+			"model": "test-model",
+			"input": []map[string]any{
+				{"role": "user", "content": "list the files"},
+				{"type": "function_call", "call_id": call.CallID, "name": "ls", "arguments": call.Arguments},
+				{"type": "function_call_output", "call_id": call.CallID, "output": "a.go\nb.go"},
+			},
+		})
+		out = ResponsesResponse{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+		require.Equal(t, "Two files.", out.OutputText())
+		AssertToolWasCalled(t, server, "ls")
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer().WithChunkDelay(0).WithChunkSize(4)
+		server.OnAny(ReasoningResponse("Let me think.", "Streamed answer."))
+		url := server.Start(t)
+
+		resp := post(t, url, map[string]any{"model": "test-model", "input": "hi", "stream": true})
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		raw, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(raw), "event: response.created\ndata: {"))
+		require.Contains(t, string(raw), "event: response.reasoning_summary_text.delta\n")
+
+		final, text, err := ParseResponsesStream(bytes.NewReader(raw))
+		require.NoError(t, err)
+		require.Equal(t, "Streamed answer.", text)
+		require.Equal(t, text, final.OutputText())
+		require.Equal(t, []string{"reasoning", "message"}, []string{final.Output[0].Type, final.Output[1].Type})
+	})
+
+	t.Run("faults", func(t *testing.T) {
+		t.Parallel()
+		server := NewServer()
+		server.OnAny(HTTPError(http.StatusTooManyRequests))
+		url := server.Start(t)
+
+		resp := post(t, url, map[string]any{"model": "test-model", "input": "hi", "stream": true})
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+}
+
+func TestValidateRequest(t *testing.T) {
+	t.Parallel()
+
+	call := ToolCall{ID: "call_1", Type: "function", Function: FunctionCall{Name: "view", Arguments: `{"file_path":"a.go"}`}}
+	valid := func() ChatRequest {
+		return ChatRequest{
+			Model: "mock-model",
+			Tools: []Tool{{Type: "function", Function: Function{
+				Name: "view",
+				Parameters: map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"file_path": map[string]any{"type": "string"}},
+					"required":   []string{"file_path"},
+				},
+			}}},
+			Messages: []Message{
+				{Role: "system", Content: "You are Crush."},
+				{Role: "user", Content: "show a.go"},
+				{Role: "assistant", ToolCalls: []ToolCall{call}},
+				{Role: "tool", ToolCallID: "call_1", Content: "package a"},
+				{Role: "assistant", Content: "Here it is."},
+			},
+		}
+	}
+	require.NoError(t, ValidateRequest(valid()))
+
+	tests := []struct {
+		name   string
+		modify func(req *ChatRequest)
+		want   string
+	}{
+		{"missing model", func(req *ChatRequest) { req.Model = "" }, "model: missing"},
+		{"bad tool name", func(req *ChatRequest) { req.Tools[0].Function.Name = "view file" }, `tools[0].function.name: want ^[a-zA-Z0-9_-]{1,64}$, got "view file"`},
+		{"duplicate tool", func(req *ChatRequest) { req.Tools = append(req.Tools, req.Tools[0]) }, `tools[1].function.name: "view" already defined by tools[0]`},
+		{"schema not an object", func(req *ChatRequest) { req.Tools[0].Function.Parameters = map[string]any{"type": "string"} }, `tools[0].function.parameters.type: want "object", got string`},
+		{"unknown required", func(req *ChatRequest) {
+			req.Tools[0].Function.Parameters.(map[string]any)["required"] = []string{"path"}
+		}, "tools[0].function.parameters.required: path is not a property"},
+		{"unknown tool_call_id", func(req *ChatRequest) { req.Messages[3].ToolCallID = "call_2" }, `messages[3].tool_call_id: "call_2" does not match a tool call of the preceding assistant message` +
+			"\n" + `messages[4]: tool calls "call_1" (view) have no result before this assistant message`},
+		{"unanswered call", func(req *ChatRequest) { req.Messages = slices.Delete(req.Messages, 3, 4) }, `messages[3]: tool calls "call_1" (view) have no result before this assistant message`},
+		{"answered twice", func(req *ChatRequest) { req.Messages = slices.Insert(req.Messages, 4, req.Messages[3]) }, `messages[4].tool_call_id: "call_1" already answered by messages[3]`},
+		{"bad arguments", func(req *ChatRequest) {
+			req.Messages[2].ToolCalls = []ToolCall{call}
+			req.Messages[2].ToolCalls[0].Function.Arguments = `{"file_path":`
+		}, `messages[2].tool_calls[0].function.arguments: not valid JSON: "{\"file_path\":"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := valid()
+			tt.modify(&req)
+			err := ValidateRequest(req)
+			require.Error(t, err)
+			require.Equal(t, tt.want, err.Error())
+		})
+	}
+}
+
+func TestServerStrictMode(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer().StrictMode()
+	server.OnAny(TextResponse("valid"))
+	url := server.Start(t)
+
+	resp := sendChatRequest(t, url, ChatRequest{
+		Model:    "mock-model",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+	require.Equal(t, "valid", resp.Choices[0].Message.Content)
+}
+
+func TestStructuredMatchers(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{
+		"model": "mock-model",
+		"messages": [{"role": "user", "content": "Fix issue #42 please"}],
+		"tools": [
+			{"type": "function", "function": {"name": "view"}},
+			{"type": "function", "function": {"name": "subagent", "parameters": {"type": "object"}}}
+		],
+		"reasoning_effort": "high"
+	}`)
+	var req ChatRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	req.raw = body
+
+	require.True(t, BodyJSONPath("$.tools[*].function.name", "subagent")(req))
+	require.False(t, BodyJSONPath("$.tools[*].function.name", "bash")(req))
+	require.True(t, BodyJSONPath("$.tools[1].function.parameters", map[string]any{"type": "object"})(req))
+	require.True(t, BodyJSONPath("$['reasoning_effort']", "high")(req))
+	require.True(t, BodyJSONPath("$.messages[-1].role", "user")(req))
+	require.True(t, BodyJSONPathExists("$.tools[1].function.parameters.type")(req))
+	require.False(t, BodyJSONPathExists("$.tools[0].function.parameters")(req))
+
+	require.True(t, MessageMatchesRegex(`#\d+`)(req))
+	require.False(t, MessageMatchesRegex(`^fix`)(req))
+	require.True(t, ToolDefinitionPresent("view")(req))
+	require.False(t, ToolDefinitionPresent("bash")(req))
+
+	// Without the raw body, the path is evaluated on the modeled fields.
+	req.raw = nil
+	require.True(t, BodyJSONPath("$.tools[*].function.name", "subagent")(req))
+	require.False(t, BodyJSONPathExists("$.reasoning_effort")(req))
+
+	require.Panics(t, func() { BodyJSONPath("tools", "x") })
+	require.Panics(t, func() { BodyJSONPath("$.tools[", "x") })
+
+	// Through the server, matchers see the body as sent.
+	server := NewServer()
+	server.On(BodyJSONPath("$.reasoning_effort", "high"), TextResponse("thinking hard"))
+	url := server.Start(t)
+	httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	var resp ChatResponse
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&resp))
+	require.Equal(t, "thinking hard", resp.Choices[0].Message.Content)
+}
+
+func TestServerReasoning(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer().WithChunkSize(10).WithChunkDelay(0)
+	server.OnMessage("plain", ReasoningResponse("The user wants a greeting.", "Hello!"))
+	server.OnMessage("tool", WithReasoning("I should look at the file.", ToolCallResponse("view", map[string]any{"file_path": "a.go"})))
+	url := server.Start(t)
+
+	resp := sendChatRequest(t, url, ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "plain"}},
+	})
+	msg := resp.Choices[0].Message
+	require.Equal(t, "The user wants a greeting.", msg.ReasoningContent)
+	require.Equal(t, "Hello!", msg.Content)
+	require.Equal(t, 7, resp.Usage.CompletionTokensDetails.ReasoningTokens)
+	require.Equal(t, 57, resp.Usage.CompletionTokens)
+
+	body, err := json.Marshal(ChatRequest{
+		Model:    "test-model",
+		Messages: []Message{{Role: "user", Content: "tool"}},
+		Stream:   true,
+	})
+	require.NoError(t, err)
+	httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	chunks, err := ParseSSEStream(httpResp.Body)
+	require.NoError(t, err)
+
+	// The reasoning is streamed in fragments before the tool call.
+	var reasoning string
+	for i, chunk := range chunks {
+		delta := chunk.Choices[0].Delta
+		if delta.ReasoningContent != "" {
+			reasoning += delta.ReasoningContent
+			continue
+		}
+		require.Len(t, delta.ToolCalls, 1, "chunk %d", i)
+		require.Equal(t, "I should look at the file.", reasoning)
+		require.Equal(t, 3, i)
+		break
+	}
+}
+
+func TestServerForModel(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	server.ForModel(SmallModel, SequenceResponse(TextResponse("Session title"), TextResponse("Summary")))
+	server.Sequence(TextResponse("first"), TextResponse("second"))
+	url := server.Start(t)
+
+	send := func(model string) string {
+		resp := sendChatRequest(t, url, ChatRequest{
+			Model:    model,
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		})
+		return resp.Choices[0].Message.Content
+	}
+
+	// The small model's requests don't use up the main sequence.
+	require.Equal(t, "Session title", send(SmallModel))
+	require.Equal(t, "first", send(LargeModel))
+	require.Equal(t, "Summary", send(SmallModel))
+	require.Equal(t, "second", send(LargeModel))
+	require.Equal(t, "Summary", send(SmallModel))
+
+	require.True(t, ModelIs(SmallModel)(ChatRequest{Model: SmallModel}))
+	require.False(t, ModelIs(SmallModel)(ChatRequest{Model: LargeModel}))
+}
+
+func TestServerEmbeddings(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer()
+	url := server.Start(t)
+
+	embed := func(t *testing.T, req EmbeddingRequest) EmbeddingResponse {
+		t.Helper()
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpResp, err := http.Post(url+"/v1/embeddings", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer httpResp.Body.Close()
+		require.Equal(t, http.StatusOK, httpResp.StatusCode)
+		var resp EmbeddingResponse
+		require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&resp))
+		return resp
+	}
+	vector := func(e Embedding) []float32 {
+		var v []float32
+		for _, f := range e.Embedding.([]any) {
+			v = append(v, float32(f.(float64)))
+		}
+		return v
+	}
+
+	resp := embed(t, EmbeddingRequest{
+		Model: "mock-embedding",
+		Input: []string{"the plugin loads its config", "plugin config loading", "bananas are yellow"},
+	})
+	require.Len(t, resp.Data, 3)
+	require.Len(t, vector(resp.Data[0]), DefaultEmbeddingDimensions)
+	require.Equal(t, HashEmbedding("the plugin loads its config", DefaultEmbeddingDimensions), vector(resp.Data[0]))
+	related := CosineSimilarity(vector(resp.Data[0]), vector(resp.Data[1]))
+	unrelated := CosineSimilarity(vector(resp.Data[0]), vector(resp.Data[2]))
+	require.Greater(t, related, unrelated)
+	require.Positive(t, resp.Usage.PromptTokens)
+
+	resp = embed(t, EmbeddingRequest{Model: "mock-embedding", Input: "hello", Dimensions: 8, EncodingFormat: "base64"})
+	raw, err := base64.StdEncoding.DecodeString(resp.Data[0].Embedding.(string))
+	require.NoError(t, err)
+	require.Len(t, raw, 8*4)
+
+	server.Embeddings(func(text string, dims int) []float32 { return []float32{1, 0} })
+	resp = embed(t, EmbeddingRequest{Model: "mock-embedding", Input: "anything"})
+	require.Equal(t, []float32{1, 0}, vector(resp.Data[0]))
+
+	require.Len(t, server.EmbeddingRequests(), 3)
+	require.Empty(t, server.Requests())
+}
+
+func TestServerImageAttachments(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer().StrictMode()
+	server.On(HasImageMediaType("image/png"), TextResponse("a PNG"))
+	url := server.Start(t)
+
+	body := []byte(`{
+		"model": "mock-model",
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "What is in"},
+			{"type": "text", "text": "this picture?"},
+			{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo=", "detail": "low"}}
+		]}]
+	}`)
+	httpResp, err := http.Post(url+"/v1/chat/completions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	var resp ChatResponse
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&resp))
+	require.Equal(t, "a PNG", resp.Choices[0].Message.Content)
+
+	req := server.LastRequest().Body
+	msg := req.Messages[0]
+	require.Equal(t, "What is in\nthis picture?", msg.Content)
+	require.Len(t, msg.Images(), 1)
+	require.Equal(t, "image/png", msg.Images()[0].MediaType())
+	require.True(t, HasImageAttachment()(req))
+	require.True(t, MessageContains("picture")(req))
+	require.False(t, HasImageMediaType("image/jpeg")(req))
+
+	// Parts round-trip as a list, plain content as a string.
+	data, err := json.Marshal([]Message{
+		{Role: "user", Parts: []ContentPart{TextPart("look"), ImagePart("https://example.com/cat.jpg")}},
+		{Role: "user", Content: "plain"},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"role": "user", "content": [
+			{"type": "text", "text": "look"},
+			{"type": "image_url", "image_url": {"url": "https://example.com/cat.jpg"}}
+		]},
+		{"role": "user", "content": "plain"}
+	]`, string(data))
+	var msgs []Message
+	require.NoError(t, json.Unmarshal(data, &msgs))
+	require.Equal(t, "look", msgs[0].Content)
+	require.Empty(t, msgs[0].Images()[0].MediaType())
+	require.Equal(t, "plain", msgs[1].Content)
+	require.False(t, HasImageAttachment()(ChatRequest{Messages: msgs[1:]}))
+}
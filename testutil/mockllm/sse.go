@@ -0,0 +1,136 @@
+package mockllm
+
+import (
+	"cmp"
+	"net/http"
+	"time"
+)
+
+// SSEFraming controls how stream events are put on the wire. The zero value
+// sends each event in one write with "\n" line endings and no comments.
+// Real providers vary on all three, and SSE parsers have to cope.
+type SSEFraming struct {
+	// KeepAlive sends a ": keep-alive" comment right away and then every
+	// KeepAlive while the response is held by its latency, as providers do
+	// while the model is thinking. Zero sends none.
+	KeepAlive time.Duration
+	// Comments sends a ": keep-alive" comment before every event.
+	Comments bool
+	// LineEnding ends each line with "\n" (the default), "\r\n", or "\r".
+	LineEnding string
+	// WriteSize sends events in writes of at most this many bytes, each
+	// flushed on its own, so events arrive split across TCP packets. Zero
+	// writes each event at once.
+	WriteSize int
+}
+
+// WithSSEFraming streams resp with framing f.
+func WithSSEFraming(f SSEFraming, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.pacing.framing = &f
+		return r
+	}
+}
+
+// WithSSEFraming streams every response with framing f unless the response
+// sets its own.
+func (s *Server) WithSSEFraming(f SSEFraming) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pacing.framing = &f
+	return s
+}
+
+// sseWriter writes stream events with the framing of a response.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	framing SSEFraming
+}
+
+// startStream waits out the latency of a streamed response and sends the
+// stream headers, and reports whether the client is still waiting. With a
+// keep-alive framing the headers go out at once and comments are sent while
+// it waits.
+func startStream(w http.ResponseWriter, r *http.Request, pace pacing) (*sseWriter, bool) {
+	framing := pace.framingOrZero()
+	if framing.KeepAlive <= 0 && !wait(r, pace.latencyOrZero()) {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+	sw := &sseWriter{w: w, flusher: flusher, framing: framing}
+
+	if framing.KeepAlive > 0 && !sw.holdWithKeepAlive(r, pace.latencyOrZero()) {
+		return nil, false
+	}
+	return sw, true
+}
+
+// event sends payload as a data event named name, as the Responses API
+// does.
+func (sw *sseWriter) event(name, payload string) {
+	if sw.framing.Comments {
+		sw.comment()
+	}
+	sw.send("event: " + name + sw.eol() + "data: " + payload + sw.eol() + sw.eol())
+}
+
+// data sends payload as a data event.
+func (sw *sseWriter) data(payload string) {
+	if sw.framing.Comments {
+		sw.comment()
+	}
+	sw.send("data: " + payload + sw.eol() + sw.eol())
+}
+
+// comment sends a keep-alive comment.
+func (sw *sseWriter) comment() {
+	sw.send(": keep-alive" + sw.eol() + sw.eol())
+}
+
+// holdWithKeepAlive sends keep-alive comments until d has passed, and
+// reports whether the client is still waiting.
+func (sw *sseWriter) holdWithKeepAlive(r *http.Request, d time.Duration) bool {
+	sw.comment()
+	end := time.Now().Add(d)
+	for {
+		left := time.Until(end)
+		if left <= 0 {
+			return r.Context().Err() == nil
+		}
+		if !wait(r, min(left, sw.framing.KeepAlive)) {
+			return false
+		}
+		if time.Until(end) > 0 {
+			sw.comment()
+		}
+	}
+}
+
+func (sw *sseWriter) eol() string {
+	return cmp.Or(sw.framing.LineEnding, "\n")
+}
+
+func (sw *sseWriter) send(event string) {
+	size := sw.framing.WriteSize
+	if size <= 0 {
+		size = len(event)
+	}
+	for i := 0; i < len(event); i += size {
+		end := min(i+size, len(event))
+		if _, err := sw.w.Write([]byte(event[i:end])); err != nil {
+			return
+		}
+		sw.flusher.Flush()
+	}
+}
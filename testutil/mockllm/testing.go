@@ -1,114 +1,55 @@
 package mockllm
 
 import (
-	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
 // TestConfig creates config JSON that points to the mock server.
 func TestConfig(serverURL string) string {
-	return `{
-  "providers": {
-    "mock": {
-      "type": "openai-compat",
-      "base_url": "` + serverURL + `",
-      "api_key": "mock-key",
-      "models": [
-        {
-          "id": "mock-model",
-          "name": "Mock Model",
-          "context_window": 128000,
-          "default_max_tokens": 4096,
-          "can_reason": false,
-          "supports_attachments": false
-        }
-      ]
-    }
-  },
-  "models": {
-    "large": { "provider": "mock", "model": "mock-model" },
-    "small": { "provider": "mock", "model": "mock-model" }
-  }
-}`
+	return NewConfig(serverURL).JSON()
+}
+
+// TestConfigWithPricing creates config JSON that points to the mock server,
+// with the mock model priced so that Crush reports session costs.
+func TestConfigWithPricing(serverURL string, pricing Pricing) string {
+	return NewConfig(serverURL).WithPricing(pricing).JSON()
 }
 
 // SetupTestEnv creates an isolated test environment with the mock LLM server.
 // Returns the tmpDir for use with NewIsolatedTerminalWithConfigAndEnv.
 func SetupTestEnv(t *testing.T, serverURL string) string {
 	t.Helper()
+	return NewConfig(serverURL).Setup(t)
+}
 
-	tmpDir := t.TempDir()
-
-	// Create config directory and write config file.
-	configPath := filepath.Join(tmpDir, "config", "crush")
-	if err := os.MkdirAll(configPath, 0o755); err != nil {
-		t.Fatalf("Failed to create config dir: %v", err)
-	}
-	configFile := filepath.Join(configPath, "crush.json")
-	if err := os.WriteFile(configFile, []byte(TestConfig(serverURL)), 0o644); err != nil {
-		t.Fatalf("Failed to write config: %v", err)
-	}
-
-	// Create data directory and write data config file.
-	// This is required to skip the onboarding flow.
-	dataPath := filepath.Join(tmpDir, "data", "crush")
-	if err := os.MkdirAll(dataPath, 0o755); err != nil {
-		t.Fatalf("Failed to create data dir: %v", err)
-	}
-	dataFile := filepath.Join(dataPath, "crush.json")
-	if err := os.WriteFile(dataFile, []byte(TestConfig(serverURL)), 0o644); err != nil {
-		t.Fatalf("Failed to write data config: %v", err)
-	}
-
-	return tmpDir
+// SetupTestEnvWithPricing creates an isolated test environment with the mock
+// LLM server and the mock model priced as given.
+func SetupTestEnvWithPricing(t *testing.T, serverURL string, pricing Pricing) string {
+	t.Helper()
+	return NewConfig(serverURL).WithPricing(pricing).Setup(t)
 }
 
 // SetupTestEnvWithConfig creates an isolated test environment with custom config.
-// Merges the provided config with mock LLM settings.
+// Top-level keys of additionalConfig replace the mock LLM settings; prefer
+// building a Config, which merges plugin options.
 func SetupTestEnvWithConfig(t *testing.T, serverURL string, additionalConfig map[string]any) string {
 	t.Helper()
-
-	tmpDir := t.TempDir()
-
-	// Build the config.
-	config := map[string]any{
-		"providers": map[string]any{
-			"mock": map[string]any{
-				"type":     "openai-compat",
-				"base_url": serverURL,
-				"api_key":  "mock-key",
-				"models": []map[string]any{
-					{
-						"id":                 "mock-model",
-						"name":               "Mock Model",
-						"context_window":     128000,
-						"default_max_tokens": 4096,
-						"can_reason":         false,
-						"supports_attachments": false,
-					},
-				},
-			},
-		},
-		"models": map[string]any{
-			"large": map[string]any{"provider": "mock", "model": "mock-model"},
-			"small": map[string]any{"provider": "mock", "model": "mock-model"},
-		},
-	}
-
-	// Merge additional config.
+	cfg := NewConfig(serverURL)
 	for k, v := range additionalConfig {
-		config[k] = v
+		cfg.Set(k, v)
 	}
+	return cfg.Setup(t)
+}
 
-	configJSON, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		t.Fatalf("Failed to marshal config: %v", err)
-	}
+// writeConfigFiles writes the config and data config files into dir.
+func writeConfigFiles(t *testing.T, dir string, configJSON []byte) {
+	t.Helper()
 
 	// Create config directory and write config file.
-	configPath := filepath.Join(tmpDir, "config", "crush")
+	configPath := filepath.Join(dir, "config", "crush")
 	if err := os.MkdirAll(configPath, 0o755); err != nil {
 		t.Fatalf("Failed to create config dir: %v", err)
 	}
@@ -118,7 +59,8 @@ func SetupTestEnvWithConfig(t *testing.T, serverURL string, additionalConfig map
 	}
 
 	// Create data directory and write data config file.
-	dataPath := filepath.Join(tmpDir, "data", "crush")
+	// This is required to skip the onboarding flow.
+	dataPath := filepath.Join(dir, "data", "crush")
 	if err := os.MkdirAll(dataPath, 0o755); err != nil {
 		t.Fatalf("Failed to create data dir: %v", err)
 	}
@@ -126,8 +68,6 @@ func SetupTestEnvWithConfig(t *testing.T, serverURL string, additionalConfig map
 	if err := os.WriteFile(dataFile, configJSON, 0o644); err != nil {
 		t.Fatalf("Failed to write data config: %v", err)
 	}
-
-	return tmpDir
 }
 
 // Conversation is a helper for building multi-turn conversations in tests.
@@ -176,6 +116,28 @@ func AssertRequestCount(t *testing.T, server *Server, expected int) {
 	}
 }
 
+// AssertHeader checks that every request carried the header with the value
+// want, e.g. "Authorization" with "Bearer "+MockAPIKey.
+func AssertHeader(t *testing.T, server *Server, name, want string) {
+	t.Helper()
+	requests := server.Requests()
+	if len(requests) == 0 {
+		t.Error("No requests made")
+		return
+	}
+	for i, req := range requests {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			t.Errorf("Request %d has no %s header", i, name)
+			return
+		}
+		if !slices.Contains(values, want) {
+			t.Errorf("Request %d has %s header %q, want %q", i, name, values, want)
+			return
+		}
+	}
+}
+
 // AssertLastMessageContains checks if the last user message contains the text.
 func AssertLastMessageContains(t *testing.T, server *Server, text string) {
 	t.Helper()
@@ -230,6 +192,72 @@ func AssertToolWasNotCalled(t *testing.T, server *Server, toolName string) {
 	}
 }
 
+// AssertToolOffered checks that at least one request offered the model the
+// tool, e.g. that a plugin's tool is registered.
+func AssertToolOffered(t *testing.T, server *Server, toolName string) {
+	t.Helper()
+	requests := server.Requests()
+	for _, req := range requests {
+		if slices.Contains(req.ToolNames(), toolName) {
+			return
+		}
+	}
+	if len(requests) == 0 {
+		t.Errorf("Tool %q was not offered: no requests made", toolName)
+		return
+	}
+	t.Errorf("Tool %q was not offered, last request offered %q", toolName, requests[len(requests)-1].ToolNames())
+}
+
+// AssertToolNotOffered checks that no request offered the model the tool,
+// e.g. that a disabled plugin's tool or one a sub-agent may not use is kept
+// from the model.
+func AssertToolNotOffered(t *testing.T, server *Server, toolName string) {
+	t.Helper()
+	for i, req := range server.Requests() {
+		if slices.Contains(req.ToolNames(), toolName) {
+			t.Errorf("Tool %q was offered unexpectedly in request %d", toolName, i)
+			return
+		}
+	}
+}
+
+// AssertToolResultOrder checks that the results of the named tools were
+// first sent back to the model in the given order. Results of other tools
+// are ignored.
+func AssertToolResultOrder(t *testing.T, server *Server, toolNames ...string) {
+	t.Helper()
+	var order []string
+	for _, req := range server.Requests() {
+		for _, msg := range req.Body.Messages {
+			if msg.Role == "tool" && slices.Contains(toolNames, msg.Name) && !slices.Contains(order, msg.Name) {
+				order = append(order, msg.Name)
+			}
+		}
+	}
+	if !slices.Equal(order, toolNames) {
+		t.Errorf("Expected tool results in order %q, got %q", toolNames, order)
+	}
+}
+
+// AssertScenarioMatched checks that a strict scenario had a transition for
+// every request, e.g. before a test's later steps depend on its state.
+func AssertScenarioMatched(t *testing.T, sc *Scenario) {
+	t.Helper()
+	for _, msg := range sc.Unmatched() {
+		t.Errorf("%s", msg)
+	}
+}
+
+// AssertConcurrentRequests checks that at least n requests were in flight
+// at the same time.
+func AssertConcurrentRequests(t *testing.T, server *Server, n int) {
+	t.Helper()
+	if actual := server.MaxInFlight(); actual < n {
+		t.Errorf("Expected %d concurrent requests, got at most %d", n, actual)
+	}
+}
+
 func containsIgnoreCase(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
 		findIgnoreCase(s, substr) >= 0)
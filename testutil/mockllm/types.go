@@ -9,23 +9,33 @@ import (
 
 // ChatRequest represents an OpenAI chat completion request.
 type ChatRequest struct {
-	Model       string        `json:"model"`
-	Messages    []Message     `json:"messages"`
-	Tools       []Tool        `json:"tools,omitempty"`
-	ToolChoice  any           `json:"tool_choice,omitempty"`
-	Stream      bool          `json:"stream,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature *float64      `json:"temperature,omitempty"`
-	TopP        *float64      `json:"top_p,omitempty"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Tools       []Tool    `json:"tools,omitempty"`
+	ToolChoice  any       `json:"tool_choice,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+
+	// raw is the request body as received.
+	raw []byte
+	// opts are the server's options for responses to the request.
+	opts []ResponseOption
 }
 
 // Message represents a chat message.
 type Message struct {
-	Role       string     `json:"role"` // system, user, assistant, tool
-	Content    string     `json:"content,omitempty"`
-	Name       string     `json:"name,omitempty"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Role             string     `json:"role"` // system, user, assistant, tool
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"` // thinking before the answer
+	Name             string     `json:"name,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string     `json:"tool_call_id,omitempty"`
+
+	// Parts is the content when sent as a list of parts, e.g. with images.
+	// Content then holds its text parts, joined by newlines.
+	Parts []ContentPart `json:"-"`
 }
 
 // ToolCall represents a tool invocation.
@@ -62,6 +72,15 @@ type ChatResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   *Usage   `json:"usage,omitempty"`
+
+	// fault, when set, replaces the response with a simulated failure.
+	fault *fault
+	// pacing controls how fast the response is sent.
+	pacing pacing
+	// barrier, when set, holds the response until it is released.
+	barrier *Barrier
+	// usageSet is true when Usage was given rather than defaulted.
+	usageSet bool
 }
 
 // Choice represents a completion choice.
@@ -71,11 +90,31 @@ type Choice struct {
 	FinishReason string  `json:"finish_reason,omitempty"`
 }
 
+// APIErrorBody is the body of an OpenAI error response.
+type APIErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a request failed.
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
 // Usage represents token usage information.
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// CompletionTokensDetails breaks down the completion tokens.
+type CompletionTokensDetails struct {
+	// ReasoningTokens are the completion tokens spent on reasoning.
+	ReasoningTokens int `json:"reasoning_tokens"`
 }
 
 // StreamChunk represents a streaming response chunk.
@@ -97,9 +136,10 @@ type StreamChoice struct {
 
 // Delta represents incremental content in a stream.
 type Delta struct {
-	Role      string          `json:"role,omitempty"`
-	Content   string          `json:"content,omitempty"`
-	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+	Role             string          `json:"role,omitempty"`
+	Content          string          `json:"content,omitempty"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
 }
 
 // ToolCallDelta represents incremental tool call data.
@@ -116,12 +156,14 @@ type FunctionDelta struct {
 	Arguments string `json:"arguments,omitempty"`
 }
 
-// NewResponse creates a new chat response with defaults.
-func NewResponse(model string) *ChatResponse {
+// NewResponse creates a new chat response with defaults. Its ID and
+// created time come from the clock unless opts say otherwise.
+func NewResponse(model string, opts ...ResponseOption) *ChatResponse {
+	o := newResponseOptions(opts)
 	return &ChatResponse{
-		ID:      "chatcmpl-mock-" + randomID(),
+		ID:      "chatcmpl-mock-" + o.id(),
 		Object:  "chat.completion",
-		Created: time.Now().Unix(),
+		Created: o.createdAt(),
 		Model:   model,
 		Choices: []Choice{},
 		Usage: &Usage{
@@ -0,0 +1,123 @@
+package mockllm
+
+// Token usage and pricing, so token and cost fields get meaningful numbers
+// instead of the 100/50 every response reports by default.
+
+// charsPerToken is the rough ratio used to estimate token counts.
+const charsPerToken = 4
+
+// Pricing is the cost of a model in USD per million tokens, as Crush reads
+// it from the model configuration.
+type Pricing struct {
+	CostPer1MIn  float64
+	CostPer1MOut float64
+}
+
+// Cost returns what usage costs in USD at these prices.
+func (p Pricing) Cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*p.CostPer1MIn + float64(usage.CompletionTokens)*p.CostPer1MOut) / 1e6
+}
+
+// EstimateTokens returns an approximate token count for s, at least 1 for a
+// non-empty s.
+func EstimateTokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	return max(1, (len(s)+charsPerToken-1)/charsPerToken)
+}
+
+// WithUsage reports the given token counts for resp.
+func WithUsage(promptTokens, completionTokens int, resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.Usage = &Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
+		r.usageSet = true
+		return r
+	}
+}
+
+// WithEstimatedUsage reports token counts for resp estimated from the size
+// of the request and the response.
+func WithEstimatedUsage(resp ResponseFunc) func(req *ChatRequest) *ChatResponse {
+	return func(req *ChatRequest) *ChatResponse {
+		r := resp(req)
+		r.Usage = estimateUsage(req, r)
+		r.usageSet = true
+		return r
+	}
+}
+
+// WithEstimatedUsage estimates the token counts of every response that does
+// not set its own usage.
+func (s *Server) WithEstimatedUsage() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.estimateUsage = true
+	return s
+}
+
+// applyUsage estimates the usage of resp if the server is configured to and
+// resp has none of its own.
+func (s *Server) applyUsage(req *ChatRequest, resp *ChatResponse) {
+	s.mu.RLock()
+	estimate := s.estimateUsage
+	s.mu.RUnlock()
+	if estimate && !resp.usageSet {
+		resp.Usage = estimateUsage(req, resp)
+	}
+}
+
+// estimateUsage counts the messages and tool definitions of req as prompt
+// tokens and the content and tool calls of resp as completion tokens.
+func estimateUsage(req *ChatRequest, resp *ChatResponse) *Usage {
+	prompt := promptTokens(req)
+
+	var completion, reasoning int
+	for _, c := range resp.Choices {
+		completion += messageTokens(c.Message)
+		reasoning += EstimateTokens(c.Message.ReasoningContent)
+	}
+	usage := &Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion + reasoning,
+		TotalTokens:      prompt + completion + reasoning,
+	}
+	if reasoning > 0 {
+		usage.CompletionTokensDetails = &CompletionTokensDetails{ReasoningTokens: reasoning}
+	}
+	return usage
+}
+
+// promptTokens estimates the tokens of the messages and tool definitions of
+// req.
+func promptTokens(req *ChatRequest) int {
+	var n int
+	for _, m := range req.Messages {
+		n += messageTokens(m)
+	}
+	for _, t := range req.Tools {
+		n += EstimateTokens(t.Function.Name) + EstimateTokens(t.Function.Description)
+	}
+	return n
+}
+
+// PromptTokensOver returns true if the estimated prompt of the request, its
+// messages and tool definitions, is more than limit tokens.
+func PromptTokensOver(limit int) MatchFunc {
+	return func(req ChatRequest) bool {
+		return promptTokens(&req) > limit
+	}
+}
+
+func messageTokens(m Message) int {
+	n := EstimateTokens(m.Content) + imageTokens*len(m.Images())
+	for _, tc := range m.ToolCalls {
+		n += EstimateTokens(tc.Function.Name) + EstimateTokens(tc.Function.Arguments)
+	}
+	return n
+}
@@ -0,0 +1,210 @@
+package mockllm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// toolNamePattern is what OpenAI accepts as a function name.
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// roles are the message roles OpenAI-compatible providers accept.
+var roles = map[string]bool{
+	"system":    true,
+	"developer": true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// StrictMode validates every request as a strict provider would. A
+// malformed request fails the test, listing each problem with the part of
+// the request it is in, and is answered with 400 Bad Request.
+func (s *Server) StrictMode() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strict = true
+	return s
+}
+
+// validate checks req in strict mode and returns the error response for a
+// malformed one, or nil.
+func (s *Server) validate(req *ChatRequest) *ChatResponse {
+	s.mu.RLock()
+	strict := s.strict
+	s.mu.RUnlock()
+	if !strict {
+		return nil
+	}
+	err := ValidateRequest(*req)
+	if err == nil {
+		return nil
+	}
+	if s.t != nil {
+		body, _ := json.MarshalIndent(req, "", "  ")
+		s.t.Errorf("mockllm: malformed request:\n%v\nrequest:\n%s", err, body)
+	}
+	resp := NewResponse(req.Model, req.opts...)
+	resp.fault = &fault{status: http.StatusBadRequest, message: err.Error()}
+	return resp
+}
+
+// ValidateRequest checks req against the rules of the OpenAI chat
+// completions API: required fields, tool definitions, and tool results
+// answering earlier tool calls. Every problem found is reported as
+// "path: problem".
+func ValidateRequest(req ChatRequest) error {
+	var errs []error
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	if req.Model == "" {
+		fail("model", "missing")
+	}
+	if len(req.Messages) == 0 {
+		fail("messages", "empty")
+	}
+
+	names := make(map[string]int)
+	for i, tool := range req.Tools {
+		path := fmt.Sprintf("tools[%d]", i)
+		if tool.Type != "function" {
+			fail(path+".type", "want %q, got %q", "function", tool.Type)
+		}
+		name := tool.Function.Name
+		if !toolNamePattern.MatchString(name) {
+			fail(path+".function.name", "want %s, got %q", toolNamePattern, name)
+		}
+		if j, ok := names[name]; ok {
+			fail(path+".function.name", "%q already defined by tools[%d]", name, j)
+		}
+		names[name] = i
+		if tool.Function.Parameters != nil {
+			validateSchema(path+".function.parameters", tool.Function.Parameters, fail)
+		}
+	}
+
+	// pending holds the tool calls of the last assistant message that have
+	// no result yet, by ID.
+	var pending map[string]string
+	answered := make(map[string]int)
+	for i, m := range req.Messages {
+		path := fmt.Sprintf("messages[%d]", i)
+		if !roles[m.Role] {
+			fail(path+".role", "unknown role %q", m.Role)
+		}
+		if m.Role != "tool" && len(pending) > 0 {
+			fail(path, "tool calls %s have no result before this %s message", quoteIDs(pending), m.Role)
+			pending = nil
+		}
+
+		switch m.Role {
+		case "assistant":
+			if m.Content == "" && len(m.ToolCalls) == 0 {
+				fail(path, "assistant message has neither content nor tool_calls")
+			}
+			pending = make(map[string]string)
+			for j, tc := range m.ToolCalls {
+				tcPath := fmt.Sprintf("%s.tool_calls[%d]", path, j)
+				if tc.ID == "" {
+					fail(tcPath+".id", "missing")
+				} else {
+					pending[tc.ID] = tc.Function.Name
+				}
+				if tc.Type != "function" {
+					fail(tcPath+".type", "want %q, got %q", "function", tc.Type)
+				}
+				if tc.Function.Name == "" {
+					fail(tcPath+".function.name", "missing")
+				}
+				if !json.Valid([]byte(tc.Function.Arguments)) {
+					fail(tcPath+".function.arguments", "not valid JSON: %q", tc.Function.Arguments)
+				}
+			}
+		case "tool":
+			if len(m.ToolCalls) > 0 {
+				fail(path+".tool_calls", "set on a tool message")
+			}
+			switch id := m.ToolCallID; {
+			case id == "":
+				fail(path+".tool_call_id", "missing")
+			case answered[id] > 0:
+				fail(path+".tool_call_id", "%q already answered by messages[%d]", id, answered[id]-1)
+			case !hasKey(pending, id):
+				fail(path+".tool_call_id", "%q does not match a tool call of the preceding assistant message", id)
+			default:
+				delete(pending, id)
+				answered[id] = i + 1
+			}
+		default:
+			if len(m.ToolCalls) > 0 {
+				fail(path+".tool_calls", "set on a %s message", m.Role)
+			}
+			if m.ToolCallID != "" {
+				fail(path+".tool_call_id", "set on a %s message", m.Role)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		fail("messages", "tool calls %s have no result", quoteIDs(pending))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateSchema checks that a tool's parameters are a JSON schema for an
+// object.
+func validateSchema(path string, params any, fail func(path, format string, args ...any)) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		fail(path, "not JSON: %v", err)
+		return
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		fail(path, "want a JSON object, got %s", data)
+		return
+	}
+	if t, _ := schema["type"].(string); t != "object" {
+		fail(path+".type", "want %q, got %v", "object", schema["type"])
+	}
+	if props, ok := schema["properties"]; ok {
+		if _, ok := props.(map[string]any); !ok {
+			fail(path+".properties", "want a JSON object, got %v", props)
+		}
+	}
+	if req, ok := schema["required"]; ok {
+		list, ok := req.([]any)
+		if !ok {
+			fail(path+".required", "want an array, got %v", req)
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range list {
+			if s, ok := name.(string); !ok || !hasKey(props, s) {
+				fail(path+".required", "%v is not a property", name)
+			}
+		}
+	}
+}
+
+func hasKey[V any](m map[string]V, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// quoteIDs lists the IDs of pending tool calls with their tool names.
+func quoteIDs(pending map[string]string) string {
+	ids := make([]string, 0, len(pending))
+	for id, name := range pending {
+		ids = append(ids, fmt.Sprintf("%q (%s)", id, name))
+	}
+	slices.Sort(ids)
+	return strings.Join(ids, ", ")
+}
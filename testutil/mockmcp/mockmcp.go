@@ -0,0 +1,643 @@
+// Package mockmcp provides a mock MCP server for testing plugins that speak
+// MCP to an external service.
+//
+// It answers newline-delimited JSON-RPC 2.0 over TCP, TLS, WebSocket, or any
+// connection handed to ServeConn (e.g. stdio), records tool calls, and can
+// push notifications and inject faults.
+//
+// Basic usage:
+//
+//	server := mockmcp.NewServer(t)
+//	server.HandleTool("register_agent", func(args json.RawMessage) (any, error) {
+//		return mockmcp.TextResult(`{"agent_id":"agent-1"}`), nil
+//	})
+//	// Point the plugin at server.Addr()
+//	require.Eventually(t, func() bool { return slices.Contains(server.ToolNames(), "register_agent") }, ...)
+package mockmcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// ProtocolVersion is the MCP protocol version the server answers with.
+const ProtocolVersion = "2024-11-05"
+
+// JSON-RPC error codes.
+const (
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServerError    = -32000
+)
+
+// Request is a JSON-RPC request, or a notification when ID is nil.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      any             `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      any             `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error. Handlers return one to answer with its code;
+// other errors are answered with CodeServerError.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// incoming is any message from a client: a request, a notification, or a
+// response to a request sent with Request.
+type incoming struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      any             `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// HandlerFunc answers a JSON-RPC method with a result to encode.
+type HandlerFunc func(params json.RawMessage) (any, error)
+
+// ToolFunc answers a tools/call of one tool, usually with TextResult.
+type ToolFunc func(args json.RawMessage) (any, error)
+
+// Call is a recorded tools/call.
+type Call struct {
+	Tool string
+	Args json.RawMessage
+	Time time.Time
+}
+
+// TextResult is a tool result with a single text content item.
+func TextResult(text string) map[string]any {
+	return map[string]any{
+		"content": []map[string]string{{"type": "text", "text": text}},
+	}
+}
+
+// JSONResult is a tool result with v encoded as JSON in a text content item.
+func JSONResult(v any) map[string]any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ErrorResult(err.Error())
+	}
+	return TextResult(string(data))
+}
+
+// ErrorResult is a tool result reporting a tool error.
+func ErrorResult(message string) map[string]any {
+	result := TextResult(message)
+	result["isError"] = true
+	return result
+}
+
+// Server is a mock MCP server.
+type Server struct {
+	listener net.Listener
+	closing  sync.Once
+
+	mu           sync.Mutex
+	handlers     map[string]HandlerFunc
+	tools        map[string]ToolFunc
+	defaultTool  ToolFunc
+	serverInfo   map[string]string
+	capabilities map[string]any
+
+	calls      []Call
+	initParams json.RawMessage
+	auth       string
+	pings      int
+	replies    []Response
+	accepted   int
+
+	// Faults.
+	stalled     bool
+	latency     time.Duration
+	toolLatency map[string]time.Duration
+
+	sendMu sync.Mutex
+	conns  map[*conn]struct{}
+	nextID int
+}
+
+// conn is a client connection.
+type conn struct {
+	rwc     io.ReadWriteCloser
+	encoder *json.Encoder
+}
+
+// New returns a server that only handles the connections passed to
+// ServeConn.
+func New() *Server {
+	s := &Server{
+		handlers:     make(map[string]HandlerFunc),
+		tools:        make(map[string]ToolFunc),
+		toolLatency:  make(map[string]time.Duration),
+		conns:        make(map[*conn]struct{}),
+		serverInfo:   map[string]string{"name": "mockmcp", "version": "0.1.0"},
+		capabilities: map[string]any{"tools": map[string]bool{"listChanged": true}},
+	}
+	s.handlers["initialize"] = s.initialize
+	s.handlers["ping"] = func(json.RawMessage) (any, error) {
+		s.mu.Lock()
+		s.pings++
+		s.mu.Unlock()
+		return map[string]any{}, nil
+	}
+	s.handlers["tools/list"] = s.listTools
+	s.handlers["tools/call"] = s.callTool
+	return s
+}
+
+// NewServer starts a server on a local TCP port. It is closed when the test
+// ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mockmcp: listen: %v", err)
+	}
+	return serve(t, listener)
+}
+
+// NewTLSServer starts a server behind TLS with a self-signed certificate for
+// 127.0.0.1 and returns it with the path of a PEM file holding the
+// certificate, to trust as a CA.
+func NewTLSServer(t testing.TB) (*Server, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("mockmcp: generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("mockmcp: create certificate: %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("mockmcp: write certificate: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("mockmcp: listen: %v", err)
+	}
+	return serve(t, listener), caFile
+}
+
+// NewWebSocketServer starts a server accepting WebSocket connections, one
+// JSON-RPC message per text message, and returns it with its ws:// URL.
+func NewWebSocketServer(t testing.TB) (*Server, string) {
+	t.Helper()
+	s := New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.auth = r.Header.Get("Authorization")
+		s.mu.Unlock()
+		s.ServeConn(websocket.NetConn(context.Background(), c, websocket.MessageText))
+	}))
+	t.Cleanup(func() {
+		s.DisconnectAll()
+		srv.Close()
+	})
+	return s, "ws" + srv.URL[len("http"):] + "/mcp"
+}
+
+func serve(t testing.TB, listener net.Listener) *Server {
+	s := New()
+	s.listener = listener
+	t.Cleanup(s.Close)
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConn(c)
+		}
+	}()
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops listening and drops all connections.
+func (s *Server) Close() {
+	s.closing.Do(func() {
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.DisconnectAll()
+	})
+}
+
+// Handle answers method with handler, replacing the default handler for
+// initialize, ping, tools/list, or tools/call. A nil handler makes the
+// method unknown.
+func (s *Server) Handle(method string, handler HandlerFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handler == nil {
+		delete(s.handlers, method)
+	} else {
+		s.handlers[method] = handler
+	}
+	return s
+}
+
+// HandleTool answers tools/call of the named tool with fn.
+func (s *Server) HandleTool(name string, fn ToolFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tools[name] = fn
+	return s
+}
+
+// DefaultTool answers tools/call of tools without their own handler. Without
+// one, unknown tools are answered with CodeInvalidParams.
+func (s *Server) DefaultTool(fn ToolFunc) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultTool = fn
+	return s
+}
+
+// SetServerInfo sets the name and version answered to initialize.
+func (s *Server) SetServerInfo(name, version string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serverInfo = map[string]string{"name": name, "version": version}
+	return s
+}
+
+// SetCapability sets a server capability answered to initialize, e.g.
+// "experimental"; a nil value removes it.
+func (s *Server) SetCapability(name string, value any) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == nil {
+		delete(s.capabilities, name)
+	} else {
+		s.capabilities[name] = value
+	}
+	return s
+}
+
+// Stall makes the server read requests without answering them, like a
+// half-open connection, until called with false.
+func (s *Server) Stall(stalled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stalled = stalled
+}
+
+// SetLatency delays every answer by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetToolLatency delays the answers to calls of the named tool by d, on top
+// of the latency of every answer.
+func (s *Server) SetToolLatency(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.toolLatency[name] = d
+}
+
+// DisconnectAll closes every client connection, as if the server went away.
+// The server keeps accepting new connections.
+func (s *Server) DisconnectAll() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	for c := range s.conns {
+		c.rwc.Close()
+	}
+}
+
+// Connections returns the number of open client connections.
+func (s *Server) Connections() int {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return len(s.conns)
+}
+
+// Connected reports whether any client has connected.
+func (s *Server) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted > 0
+}
+
+// Broadcast sends v, e.g. a Request or a notification, to every client.
+func (s *Server) Broadcast(v any) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	for c := range s.conns {
+		_ = c.encoder.Encode(v)
+	}
+}
+
+// Notify sends a notification to every client.
+func (s *Server) Notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	s.Broadcast(Request{JSONRPC: "2.0", Method: method, Params: data})
+	return nil
+}
+
+// Request sends a request to every client; their answers are recorded in
+// Replies. It returns the request ID.
+func (s *Server) Request(method string, params any) (int, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	s.nextID++
+	id := 1_000_000 + s.nextID
+	s.mu.Unlock()
+	s.Broadcast(Request{JSONRPC: "2.0", ID: id, Method: method, Params: data})
+	return id, nil
+}
+
+// Replies returns the clients' responses to requests sent to them.
+func (s *Server) Replies() []Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Response(nil), s.replies...)
+}
+
+// InitParams returns the params of the last initialize request.
+func (s *Server) InitParams() json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.initParams
+}
+
+// Auth returns the Authorization header of the last WebSocket connection.
+func (s *Server) Auth() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth
+}
+
+// Pings returns the number of ping requests answered.
+func (s *Server) Pings() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pings
+}
+
+// Calls returns the recorded tool calls.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// ToolNames returns the names of the called tools, in call order.
+func (s *Server) ToolNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(s.calls))
+	for i, c := range s.calls {
+		names[i] = c.Tool
+	}
+	return names
+}
+
+// Args returns the arguments of the last call to the named tool, or nil.
+func (s *Server) Args(name string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.calls) - 1; i >= 0; i-- {
+		if s.calls[i].Tool == name {
+			var args map[string]any
+			_ = json.Unmarshal(s.calls[i].Args, &args)
+			return args
+		}
+	}
+	return nil
+}
+
+// AllArgs returns the arguments of every call to the named tool.
+func (s *Server) AllArgs(name string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []map[string]any
+	for _, c := range s.calls {
+		if c.Tool == name {
+			var args map[string]any
+			_ = json.Unmarshal(c.Args, &args)
+			all = append(all, args)
+		}
+	}
+	return all
+}
+
+// ClearCalls forgets the recorded tool calls.
+func (s *Server) ClearCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initParams = params
+	capabilities := make(map[string]any, len(s.capabilities))
+	for k, v := range s.capabilities {
+		capabilities[k] = v
+	}
+	return map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"serverInfo":      s.serverInfo,
+		"capabilities":    capabilities,
+	}, nil
+}
+
+func (s *Server) listTools(json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tools := make([]map[string]any, 0, len(s.tools))
+	for name := range s.tools {
+		tools = append(tools, map[string]any{
+			"name":        name,
+			"inputSchema": map[string]any{"type": "object"},
+		})
+	}
+	return map[string]any{"tools": tools}, nil
+}
+
+func (s *Server) callTool(params json.RawMessage) (any, error) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Tool: p.Name, Args: p.Arguments, Time: time.Now()})
+	fn, ok := s.tools[p.Name]
+	if !ok {
+		fn = s.defaultTool
+	}
+	delay := s.toolLatency[p.Name]
+	s.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fn == nil {
+		return nil, &Error{Code: CodeInvalidParams, Message: "unknown tool: " + p.Name}
+	}
+	return fn(p.Arguments)
+}
+
+// ServeConn answers the requests read from rwc until it is closed.
+func (s *Server) ServeConn(rwc io.ReadWriteCloser) {
+	c := &conn{rwc: rwc, encoder: json.NewEncoder(rwc)}
+	s.sendMu.Lock()
+	s.conns[c] = struct{}{}
+	s.sendMu.Unlock()
+	s.mu.Lock()
+	s.accepted++
+	s.mu.Unlock()
+	defer func() {
+		s.sendMu.Lock()
+		delete(s.conns, c)
+		s.sendMu.Unlock()
+		rwc.Close()
+	}()
+
+	decoder := json.NewDecoder(bufio.NewReader(rwc))
+	for {
+		var msg incoming
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+
+		// Responses to requests from the server have no method.
+		if msg.Method == "" {
+			s.mu.Lock()
+			s.replies = append(s.replies, Response{JSONRPC: msg.JSONRPC, ID: msg.ID, Result: msg.Result, Error: msg.Error})
+			s.mu.Unlock()
+			continue
+		}
+
+		// Notifications have no ID.
+		if msg.ID == nil {
+			continue
+		}
+
+		s.mu.Lock()
+		stalled, latency := s.stalled, s.latency
+		handler := s.handlers[msg.Method]
+		s.mu.Unlock()
+		if stalled {
+			continue
+		}
+
+		// Answer in order, so calls are recorded in the order they were
+		// sent and a slow answer holds up the connection like a busy server.
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		s.send(c, s.answer(msg, handler))
+	}
+}
+
+// answer runs handler for the request.
+func (s *Server) answer(req incoming, handler HandlerFunc) Response {
+	resp := Response{JSONRPC: "2.0", ID: req.ID}
+	if handler == nil {
+		resp.Error = &Error{Code: CodeMethodNotFound, Message: "method not found"}
+		return resp
+	}
+	result, err := handler(req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	resp.Result, err = json.Marshal(result)
+	if err != nil {
+		resp.Error = &Error{Code: CodeServerError, Message: err.Error()}
+	}
+	return resp
+}
+
+func (s *Server) send(c *conn, v any) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if _, ok := s.conns[c]; ok {
+		_ = c.encoder.Encode(v)
+	}
+}
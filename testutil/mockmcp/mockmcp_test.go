@@ -0,0 +1,205 @@
+package mockmcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// client is a minimal line-delimited JSON-RPC client.
+type client struct {
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &client{conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(bufio.NewReader(conn))}
+}
+
+func (c *client) call(t *testing.T, id int, method string, params any) incoming {
+	t.Helper()
+	data, err := json.Marshal(params)
+	require.NoError(t, err)
+	require.NoError(t, c.encoder.Encode(Request{JSONRPC: "2.0", ID: id, Method: method, Params: data}))
+	return c.read(t)
+}
+
+func (c *client) read(t *testing.T) incoming {
+	t.Helper()
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg incoming
+	require.NoError(t, c.decoder.Decode(&msg))
+	return msg
+}
+
+func TestInitialize(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(t).SetServerInfo("tempotown", "1.0.0").SetCapability("experimental", map[string]any{"push": map[string]any{}})
+	c := dial(t, s)
+
+	resp := c.call(t, 1, "initialize", map[string]any{"clientInfo": map[string]string{"name": "crush"}})
+	require.Nil(t, resp.Error)
+
+	var result struct {
+		ProtocolVersion string            `json:"protocolVersion"`
+		ServerInfo      map[string]string `json:"serverInfo"`
+		Capabilities    map[string]any    `json:"capabilities"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	require.Equal(t, ProtocolVersion, result.ProtocolVersion)
+	require.Equal(t, "tempotown", result.ServerInfo["name"])
+	require.Contains(t, result.Capabilities, "experimental")
+	require.Contains(t, result.Capabilities, "tools")
+	require.JSONEq(t, `{"clientInfo":{"name":"crush"}}`, string(s.InitParams()))
+	require.True(t, s.Connected())
+}
+
+func TestToolCalls(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(t)
+	s.HandleTool("echo", func(args json.RawMessage) (any, error) {
+		return TextResult(string(args)), nil
+	})
+	s.HandleTool("fail", func(json.RawMessage) (any, error) {
+		return nil, errors.New("boom")
+	})
+	c := dial(t, s)
+
+	resp := c.call(t, 1, "tools/call", map[string]any{"name": "echo", "arguments": map[string]any{"n": 1}})
+	require.Nil(t, resp.Error)
+	require.JSONEq(t, `{"content":[{"type":"text","text":"{\"n\":1}"}]}`, string(resp.Result))
+
+	resp = c.call(t, 2, "tools/call", map[string]any{"name": "fail"})
+	require.NotNil(t, resp.Error)
+	require.Equal(t, CodeServerError, resp.Error.Code)
+	require.Equal(t, "boom", resp.Error.Message)
+
+	resp = c.call(t, 3, "tools/call", map[string]any{"name": "missing"})
+	require.Equal(t, CodeInvalidParams, resp.Error.Code)
+
+	s.DefaultTool(func(json.RawMessage) (any, error) { return JSONResult(map[string]bool{"ok": true}), nil })
+	resp = c.call(t, 4, "tools/call", map[string]any{"name": "missing", "arguments": map[string]any{"n": 2}})
+	require.Nil(t, resp.Error)
+
+	require.Equal(t, []string{"echo", "fail", "missing", "missing"}, s.ToolNames())
+	require.Equal(t, map[string]any{"n": float64(1)}, s.Args("echo"))
+	require.Equal(t, map[string]any{"n": float64(2)}, s.Args("missing"))
+	require.Len(t, s.AllArgs("missing"), 2)
+	require.Nil(t, s.Args("other"))
+
+	s.ClearCalls()
+	require.Empty(t, s.Calls())
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(t)
+	c := dial(t, s)
+
+	resp := c.call(t, 1, "ping", nil)
+	require.Nil(t, resp.Error)
+	require.Equal(t, 1, s.Pings())
+
+	s.Handle("ping", nil)
+	resp = c.call(t, 2, "ping", nil)
+	require.Equal(t, CodeMethodNotFound, resp.Error.Code)
+
+	s.Handle("custom/method", func(json.RawMessage) (any, error) {
+		return nil, &Error{Code: -32001, Message: "custom"}
+	})
+	resp = c.call(t, 3, "custom/method", nil)
+	require.Equal(t, -32001, resp.Error.Code)
+}
+
+func TestNotifyAndReplies(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(t)
+	c := dial(t, s)
+	c.call(t, 1, "ping", nil)
+
+	require.NoError(t, s.Notify("notifications/message", map[string]string{"text": "hi"}))
+	msg := c.read(t)
+	require.Equal(t, "notifications/message", msg.Method)
+	require.Nil(t, msg.ID)
+
+	id, err := s.Request("sampling/createMessage", map[string]any{})
+	require.NoError(t, err)
+	msg = c.read(t)
+	require.Equal(t, "sampling/createMessage", msg.Method)
+	require.NoError(t, c.encoder.Encode(Response{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage(`{"ok":true}`)}))
+
+	require.Eventually(t, func() bool { return len(s.Replies()) == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(id), s.Replies()[0].ID)
+}
+
+func TestFaults(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stall", func(t *testing.T) {
+		t.Parallel()
+		s := NewServer(t)
+		c := dial(t, s)
+		s.Stall(true)
+		require.NoError(t, c.encoder.Encode(Request{JSONRPC: "2.0", ID: 1, Method: "ping"}))
+		require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		var msg incoming
+		require.Error(t, c.decoder.Decode(&msg))
+		require.Zero(t, s.Pings())
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		t.Parallel()
+		s := NewServer(t)
+		s.HandleTool("slow", func(json.RawMessage) (any, error) { return TextResult("done"), nil })
+		s.SetToolLatency("slow", 50*time.Millisecond)
+		c := dial(t, s)
+		start := time.Now()
+		c.call(t, 1, "tools/call", map[string]any{"name": "slow"})
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		t.Parallel()
+		s := NewServer(t)
+		c := dial(t, s)
+		c.call(t, 1, "ping", nil)
+		require.Equal(t, 1, s.Connections())
+
+		s.DisconnectAll()
+		require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(time.Second)))
+		var msg incoming
+		require.Error(t, c.decoder.Decode(&msg))
+		require.Eventually(t, func() bool { return s.Connections() == 0 }, time.Second, 10*time.Millisecond)
+
+		// The server still accepts new connections.
+		c = dial(t, s)
+		require.Nil(t, c.call(t, 2, "ping", nil).Error)
+	})
+}
+
+func TestServeConn(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	server, clientConn := net.Pipe()
+	go s.ServeConn(server)
+	t.Cleanup(func() { clientConn.Close() })
+
+	c := &client{conn: clientConn, encoder: json.NewEncoder(clientConn), decoder: json.NewDecoder(clientConn)}
+	require.Nil(t, c.call(t, 1, "ping", nil).Error)
+	require.Empty(t, s.Addr())
+}
@@ -0,0 +1,525 @@
+// Package mockotlp provides a mock OTLP/HTTP receiver for testing plugins
+// that export telemetry.
+//
+// It accepts traces, metrics, and logs on the standard /v1/traces,
+// /v1/metrics, and /v1/logs paths, encoded as protobuf or JSON and
+// optionally gzipped, and flattens them into spans, metric points, and log
+// records that are easy to assert on.
+//
+// Basic usage:
+//
+//	receiver := mockotlp.NewReceiver(t)
+//	// Point the exporter at receiver.URL()
+//	span := receiver.WaitForSpan(t, "crush.message.user", 5*time.Second)
+//	require.Equal(t, "user", span.Attributes["message.role"])
+package mockotlp
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// pollInterval is how often the WaitFor helpers check for new telemetry.
+const pollInterval = 50 * time.Millisecond
+
+// Span is an exported span with its resource and scope.
+type Span struct {
+	Name         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Kind         string
+	Start        time.Time
+	End          time.Time
+	// StatusCode is e.g. STATUS_CODE_ERROR.
+	StatusCode    string
+	StatusMessage string
+	Attributes    map[string]string
+	Events        []Event
+	Resource      map[string]string
+	Scope         string
+}
+
+// Duration returns how long the span lasted.
+func (s Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Event is an event recorded on a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]string
+}
+
+// Point is a data point of an exported metric. Sums and gauges set Value;
+// histograms and summaries set Count and Sum.
+type Point struct {
+	Metric      string
+	Description string
+	Unit        string
+	// Type is gauge, sum, histogram, exponential_histogram, or summary.
+	Type       string
+	Value      float64
+	Count      uint64
+	Sum        float64
+	Time       time.Time
+	Attributes map[string]string
+	Resource   map[string]string
+	Scope      string
+}
+
+// LogRecord is an exported log record.
+type LogRecord struct {
+	Body string
+	// Severity is the severity text, e.g. INFO.
+	Severity       string
+	SeverityNumber int32
+	Time           time.Time
+	TraceID        string
+	SpanID         string
+	Attributes     map[string]string
+	Resource       map[string]string
+	Scope          string
+}
+
+// Receiver is a mock OTLP/HTTP receiver.
+type Receiver struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	spans  []Span
+	points []Point
+	logs   []LogRecord
+}
+
+// NewReceiver starts a receiver. It is closed when the test ends.
+func NewReceiver(t testing.TB) *Receiver {
+	t.Helper()
+	r := &Receiver{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", r.handleTraces)
+	mux.HandleFunc("/v1/metrics", r.handleMetrics)
+	mux.HandleFunc("/v1/logs", r.handleLogs)
+
+	r.server = httptest.NewServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// URL returns the base URL of the receiver, to use as the OTLP endpoint.
+func (r *Receiver) URL() string {
+	return r.server.URL
+}
+
+// Spans returns all received spans.
+func (r *Receiver) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Span(nil), r.spans...)
+}
+
+// SpansNamed returns the received spans with the given name.
+func (r *Receiver) SpansNamed(name string) []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []Span
+	for _, s := range r.spans {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Children returns the received spans whose parent is span.
+func (r *Receiver) Children(span Span) []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []Span
+	for _, s := range r.spans {
+		if s.TraceID == span.TraceID && s.ParentSpanID == span.SpanID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Points returns all received metric data points.
+func (r *Receiver) Points() []Point {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Point(nil), r.points...)
+}
+
+// MetricPoints returns the received data points of the named metric.
+func (r *Receiver) MetricPoints(name string) []Point {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var points []Point
+	for _, p := range r.points {
+		if p.Metric == name {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// Logs returns all received log records.
+func (r *Receiver) Logs() []LogRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]LogRecord(nil), r.logs...)
+}
+
+// Reset forgets all received telemetry.
+func (r *Receiver) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+	r.points = nil
+	r.logs = nil
+}
+
+// WaitForSpans waits until at least minCount spans are received and returns
+// them, failing the test on timeout.
+func (r *Receiver) WaitForSpans(t testing.TB, minCount int, timeout time.Duration) []Span {
+	t.Helper()
+	if !waitFor(timeout, func() bool { return len(r.Spans()) >= minCount }) {
+		t.Fatalf("mockotlp: timed out waiting for %d spans, got %d", minCount, len(r.Spans()))
+	}
+	return r.Spans()
+}
+
+// WaitForSpan waits until a span with the given name is received and
+// returns the first one, failing the test on timeout.
+func (r *Receiver) WaitForSpan(t testing.TB, name string, timeout time.Duration) Span {
+	t.Helper()
+	if !waitFor(timeout, func() bool { return len(r.SpansNamed(name)) > 0 }) {
+		t.Fatalf("mockotlp: timed out waiting for span %q, got %v", name, spanNames(r.Spans()))
+	}
+	return r.SpansNamed(name)[0]
+}
+
+// WaitForMetric waits until data points of the named metric are received
+// and returns them, failing the test on timeout.
+func (r *Receiver) WaitForMetric(t testing.TB, name string, timeout time.Duration) []Point {
+	t.Helper()
+	if !waitFor(timeout, func() bool { return len(r.MetricPoints(name)) > 0 }) {
+		t.Fatalf("mockotlp: timed out waiting for metric %q", name)
+	}
+	return r.MetricPoints(name)
+}
+
+// WaitForLogs waits until at least minCount log records are received and
+// returns them, failing the test on timeout.
+func (r *Receiver) WaitForLogs(t testing.TB, minCount int, timeout time.Duration) []LogRecord {
+	t.Helper()
+	if !waitFor(timeout, func() bool { return len(r.Logs()) >= minCount }) {
+		t.Fatalf("mockotlp: timed out waiting for %d log records, got %d", minCount, len(r.Logs()))
+	}
+	return r.Logs()
+}
+
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func spanNames(spans []Span) []string {
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func (r *Receiver) handleTraces(w http.ResponseWriter, req *http.Request) {
+	var msg tracepb.ExportTraceServiceRequest
+	if !decode(w, req, &msg) {
+		return
+	}
+
+	var spans []Span
+	for _, rs := range msg.ResourceSpans {
+		resource := attributes(rs.GetResource().GetAttributes())
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span := Span{
+					Name:          s.Name,
+					TraceID:       hex.EncodeToString(s.TraceId),
+					SpanID:        hex.EncodeToString(s.SpanId),
+					ParentSpanID:  hex.EncodeToString(s.ParentSpanId),
+					Kind:          s.Kind.String(),
+					Start:         unixNano(s.StartTimeUnixNano),
+					End:           unixNano(s.EndTimeUnixNano),
+					StatusCode:    s.GetStatus().GetCode().String(),
+					StatusMessage: s.GetStatus().GetMessage(),
+					Attributes:    attributes(s.Attributes),
+					Resource:      resource,
+					Scope:         ss.GetScope().GetName(),
+				}
+				for _, e := range s.Events {
+					span.Events = append(span.Events, Event{
+						Name:       e.Name,
+						Time:       unixNano(e.TimeUnixNano),
+						Attributes: attributes(e.Attributes),
+					})
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.spans = append(r.spans, spans...)
+	r.mu.Unlock()
+	encode(w, req, &tracepb.ExportTraceServiceResponse{})
+}
+
+func (r *Receiver) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	var msg metricspb.ExportMetricsServiceRequest
+	if !decode(w, req, &msg) {
+		return
+	}
+
+	var points []Point
+	for _, rm := range msg.ResourceMetrics {
+		resource := attributes(rm.GetResource().GetAttributes())
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				base := Point{
+					Metric:      m.Name,
+					Description: m.Description,
+					Unit:        m.Unit,
+					Resource:    resource,
+					Scope:       sm.GetScope().GetName(),
+				}
+				points = append(points, metricPoints(base, m)...)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.points = append(r.points, points...)
+	r.mu.Unlock()
+	encode(w, req, &metricspb.ExportMetricsServiceResponse{})
+}
+
+// metricPoints flattens the data points of m onto base.
+func metricPoints(base Point, m *metricpb.Metric) []Point {
+	var points []Point
+	number := func(typ string, dps []*metricpb.NumberDataPoint) {
+		for _, dp := range dps {
+			p := base
+			p.Type = typ
+			p.Time = unixNano(dp.TimeUnixNano)
+			p.Attributes = attributes(dp.Attributes)
+			switch v := dp.Value.(type) {
+			case *metricpb.NumberDataPoint_AsDouble:
+				p.Value = v.AsDouble
+			case *metricpb.NumberDataPoint_AsInt:
+				p.Value = float64(v.AsInt)
+			}
+			points = append(points, p)
+		}
+	}
+
+	switch data := m.Data.(type) {
+	case *metricpb.Metric_Gauge:
+		number("gauge", data.Gauge.DataPoints)
+	case *metricpb.Metric_Sum:
+		number("sum", data.Sum.DataPoints)
+	case *metricpb.Metric_Histogram:
+		for _, dp := range data.Histogram.DataPoints {
+			p := base
+			p.Type = "histogram"
+			p.Time = unixNano(dp.TimeUnixNano)
+			p.Attributes = attributes(dp.Attributes)
+			p.Count = dp.Count
+			p.Sum = dp.GetSum()
+			points = append(points, p)
+		}
+	case *metricpb.Metric_ExponentialHistogram:
+		for _, dp := range data.ExponentialHistogram.DataPoints {
+			p := base
+			p.Type = "exponential_histogram"
+			p.Time = unixNano(dp.TimeUnixNano)
+			p.Attributes = attributes(dp.Attributes)
+			p.Count = dp.Count
+			p.Sum = dp.GetSum()
+			points = append(points, p)
+		}
+	case *metricpb.Metric_Summary:
+		for _, dp := range data.Summary.DataPoints {
+			p := base
+			p.Type = "summary"
+			p.Time = unixNano(dp.TimeUnixNano)
+			p.Attributes = attributes(dp.Attributes)
+			p.Count = dp.Count
+			p.Sum = dp.Sum
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func (r *Receiver) handleLogs(w http.ResponseWriter, req *http.Request) {
+	var msg logspb.ExportLogsServiceRequest
+	if !decode(w, req, &msg) {
+		return
+	}
+
+	var logs []LogRecord
+	for _, rl := range msg.ResourceLogs {
+		resource := attributes(rl.GetResource().GetAttributes())
+		for _, sl := range rl.ScopeLogs {
+			for _, l := range sl.LogRecords {
+				logs = append(logs, LogRecord{
+					Body:           valueString(l.Body),
+					Severity:       l.SeverityText,
+					SeverityNumber: int32(l.SeverityNumber),
+					Time:           unixNano(l.TimeUnixNano),
+					TraceID:        hex.EncodeToString(l.TraceId),
+					SpanID:         hex.EncodeToString(l.SpanId),
+					Attributes:     attributes(l.Attributes),
+					Resource:       resource,
+					Scope:          sl.GetScope().GetName(),
+				})
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.logs = append(r.logs, logs...)
+	r.mu.Unlock()
+	encode(w, req, &logspb.ExportLogsServiceResponse{})
+}
+
+// isJSON reports whether req uses the JSON encoding of OTLP/HTTP rather
+// than protobuf.
+func isJSON(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+}
+
+// decode reads the request body into msg, answering with 400 Bad Request
+// when it cannot.
+func decode(w http.ResponseWriter, req *http.Request, msg proto.Message) bool {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		defer gz.Close()
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if isJSON(req) {
+		err = protojson.Unmarshal(data, msg)
+	} else {
+		err = proto.Unmarshal(data, msg)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// encode answers with msg in the encoding of the request.
+func encode(w http.ResponseWriter, req *http.Request, msg proto.Message) {
+	var data []byte
+	if isJSON(req) {
+		data, _ = protojson.Marshal(msg)
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		data, _ = proto.Marshal(msg)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// attributes flattens attrs into strings, so that assertions do not depend
+// on the attribute types.
+func attributes(attrs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = valueString(kv.Value)
+	}
+	return m
+}
+
+// valueString formats v: strings as is, other scalars as with strconv, and
+// arrays and maps in brackets.
+func valueString(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return hex.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		items := make([]string, len(v.ArrayValue.GetValues()))
+		for i, item := range v.ArrayValue.GetValues() {
+			items[i] = valueString(item)
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	case *commonpb.AnyValue_KvlistValue:
+		items := make([]string, len(v.KvlistValue.GetValues()))
+		for i, kv := range v.KvlistValue.GetValues() {
+			items[i] = fmt.Sprintf("%s=%s", kv.Key, valueString(kv.Value))
+		}
+		return "{" + strings.Join(items, ",") + "}"
+	}
+	return ""
+}
+
+func unixNano(ns uint64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns))
+}
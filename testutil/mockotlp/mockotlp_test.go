@@ -0,0 +1,200 @@
+package mockotlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	otlplogs "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	otlptrace "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func str(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func integer(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+var resource = &resourcepb.Resource{Attributes: []*commonpb.KeyValue{str("service.name", "crush")}}
+
+func post(t *testing.T, url, contentType string, body []byte, gzipped bool) *http.Response {
+	t.Helper()
+	if gzipped {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTraces(t *testing.T) {
+	t.Parallel()
+
+	receiver := NewReceiver(t)
+	start := time.Unix(1700000000, 0)
+	msg := &tracepb.ExportTraceServiceRequest{ResourceSpans: []*otlptrace.ResourceSpans{{
+		Resource: resource,
+		ScopeSpans: []*otlptrace.ScopeSpans{{
+			Scope: &commonpb.InstrumentationScope{Name: "crush"},
+			Spans: []*otlptrace.Span{
+				{
+					Name:              "crush.session",
+					TraceId:           bytes.Repeat([]byte{1}, 16),
+					SpanId:            bytes.Repeat([]byte{2}, 8),
+					StartTimeUnixNano: uint64(start.UnixNano()),
+					EndTimeUnixNano:   uint64(start.Add(time.Second).UnixNano()),
+				},
+				{
+					Name:         "crush.tool",
+					TraceId:      bytes.Repeat([]byte{1}, 16),
+					SpanId:       bytes.Repeat([]byte{3}, 8),
+					ParentSpanId: bytes.Repeat([]byte{2}, 8),
+					Kind:         otlptrace.Span_SPAN_KIND_INTERNAL,
+					Attributes:   []*commonpb.KeyValue{str("tool.name", "bash"), integer("tool.exit_code", 2)},
+					Status:       &otlptrace.Status{Code: otlptrace.Status_STATUS_CODE_ERROR, Message: "failed"},
+					Events:       []*otlptrace.Span_Event{{Name: "retry"}},
+				},
+			},
+		}},
+	}}}
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	resp := post(t, receiver.URL()+"/v1/traces", "application/x-protobuf", data, false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/x-protobuf", resp.Header.Get("Content-Type"))
+
+	spans := receiver.WaitForSpans(t, 2, time.Second)
+	require.Len(t, spans, 2)
+	session := receiver.WaitForSpan(t, "crush.session", time.Second)
+	require.Equal(t, time.Second, session.Duration())
+	require.Equal(t, "crush", session.Resource["service.name"])
+	require.Equal(t, "crush", session.Scope)
+
+	children := receiver.Children(session)
+	require.Len(t, children, 1)
+	tool := children[0]
+	require.Equal(t, "bash", tool.Attributes["tool.name"])
+	require.Equal(t, "2", tool.Attributes["tool.exit_code"])
+	require.Equal(t, "STATUS_CODE_ERROR", tool.StatusCode)
+	require.Equal(t, "failed", tool.StatusMessage)
+	require.Equal(t, "SPAN_KIND_INTERNAL", tool.Kind)
+	require.Equal(t, "retry", tool.Events[0].Name)
+
+	receiver.Reset()
+	require.Empty(t, receiver.Spans())
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	receiver := NewReceiver(t)
+	msg := &metricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricpb.ResourceMetrics{{
+		Resource: resource,
+		ScopeMetrics: []*metricpb.ScopeMetrics{{
+			Metrics: []*metricpb.Metric{
+				{
+					Name: "crush.tokens",
+					Unit: "{token}",
+					Data: &metricpb.Metric_Sum{Sum: &metricpb.Sum{DataPoints: []*metricpb.NumberDataPoint{
+						{Attributes: []*commonpb.KeyValue{str("direction", "in")}, Value: &metricpb.NumberDataPoint_AsInt{AsInt: 120}},
+					}}},
+				},
+				{
+					Name: "crush.tool.duration",
+					Data: &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{DataPoints: []*metricpb.HistogramDataPoint{
+						{Count: 3, Sum: proto.Float64(1.5)},
+					}}},
+				},
+			},
+		}},
+	}}}
+	data, err := protojson.Marshal(msg)
+	require.NoError(t, err)
+
+	resp := post(t, receiver.URL()+"/v1/metrics", "application/json", data, false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	tokens := receiver.WaitForMetric(t, "crush.tokens", time.Second)
+	require.Len(t, tokens, 1)
+	require.Equal(t, "sum", tokens[0].Type)
+	require.Equal(t, float64(120), tokens[0].Value)
+	require.Equal(t, "in", tokens[0].Attributes["direction"])
+	require.Equal(t, "{token}", tokens[0].Unit)
+
+	duration := receiver.MetricPoints("crush.tool.duration")
+	require.Len(t, duration, 1)
+	require.Equal(t, "histogram", duration[0].Type)
+	require.Equal(t, uint64(3), duration[0].Count)
+	require.Equal(t, 1.5, duration[0].Sum)
+	require.Len(t, receiver.Points(), 2)
+}
+
+func TestLogs(t *testing.T) {
+	t.Parallel()
+
+	receiver := NewReceiver(t)
+	msg := &logspb.ExportLogsServiceRequest{ResourceLogs: []*otlplogs.ResourceLogs{{
+		Resource: resource,
+		ScopeLogs: []*otlplogs.ScopeLogs{{
+			LogRecords: []*otlplogs.LogRecord{{
+				Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "tool failed"}},
+				SeverityText:   "ERROR",
+				SeverityNumber: otlplogs.SeverityNumber_SEVERITY_NUMBER_ERROR,
+				Attributes:     []*commonpb.KeyValue{str("tool.name", "bash")},
+			}},
+		}},
+	}}}
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	resp := post(t, receiver.URL()+"/v1/logs", "application/x-protobuf", data, true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	logs := receiver.WaitForLogs(t, 1, time.Second)
+	require.Equal(t, "tool failed", logs[0].Body)
+	require.Equal(t, "ERROR", logs[0].Severity)
+	require.Equal(t, int32(17), logs[0].SeverityNumber)
+	require.Equal(t, "bash", logs[0].Attributes["tool.name"])
+	require.Equal(t, "crush", logs[0].Resource["service.name"])
+}
+
+func TestBadRequest(t *testing.T) {
+	t.Parallel()
+
+	receiver := NewReceiver(t)
+	resp := post(t, receiver.URL()+"/v1/traces", "application/x-protobuf", []byte("not protobuf"), false)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err := http.Get(receiver.URL() + "/v1/traces")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	require.Empty(t, receiver.Spans())
+}
@@ -308,29 +308,12 @@ func RequireSnapshot(t *testing.T, term *vttest.Terminal, name string) {
 
 // RequireTextSnapshot compares just the text content against a golden file.
 // This is more lenient than full snapshot comparison - ignores colors/styles.
-func RequireTextSnapshot(t *testing.T, term *vttest.Terminal, name string) {
+// The text is normalized as by Normalize, with extra applied last.
+func RequireTextSnapshot(t *testing.T, term *vttest.Terminal, name string, extra ...Normalizer) {
 	t.Helper()
 
-	snap := term.Snapshot()
-	text := SnapshotText(snap)
-	fp := filepath.Join("testdata", t.Name()+"_"+name+".txt")
-
-	if *update {
-		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
-			t.Fatalf("Failed to create testdata dir: %v", err)
-		}
-		if err := os.WriteFile(fp, []byte(text), 0o644); err != nil {
-			t.Fatalf("Failed to write snapshot: %v", err)
-		}
-		return
-	}
-
-	expected, err := os.ReadFile(fp)
-	if err != nil {
-		t.Fatalf("Failed to read snapshot file %s: %v (run with -update to create)", fp, err)
-	}
-
-	require.Equal(t, string(expected), text, "Text snapshot mismatch for %s", name)
+	text := Normalize(SnapshotText(term.Snapshot()), extra...)
+	requireGoldenFile(t, filepath.Join("testdata", t.Name()+"_"+name+".txt"), text)
 }
 
 // AssertCursorPosition checks the cursor is at the expected position.
@@ -0,0 +1,114 @@
+package testutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/x/vttest"
+)
+
+const (
+	// pollInterval is how often the WaitFor helpers take a snapshot.
+	pollInterval = 100 * time.Millisecond
+	// failureSnapshots is how many distinct screens the WaitFor helpers dump
+	// when they time out, oldest first.
+	failureSnapshots = 3
+)
+
+// WaitForRegex waits for the terminal text to match pattern and returns the
+// match and its submatches. On timeout it fails the test and dumps the last
+// few screens.
+func WaitForRegex(t *testing.T, term *vttest.Terminal, pattern string, timeout time.Duration) []string {
+	t.Helper()
+	re := regexp.MustCompile(pattern)
+	var match []string
+	waitFor(t, screenText(term), timeout, fmt.Sprintf("text matching %q", pattern), func(text string) string {
+		match = re.FindStringSubmatch(text)
+		if match == nil {
+			return "no match"
+		}
+		return ""
+	})
+	return match
+}
+
+// WaitForAll waits for the terminal to show every one of texts at once. On
+// timeout it fails the test, naming the texts missing from the last screen,
+// and dumps the last few screens.
+func WaitForAll(t *testing.T, term *vttest.Terminal, texts []string, timeout time.Duration) {
+	t.Helper()
+	waitFor(t, screenText(term), timeout, fmt.Sprintf("%q", texts), func(text string) string {
+		var missing []string
+		for _, want := range texts {
+			if !strings.Contains(text, want) {
+				missing = append(missing, want)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Sprintf("missing %q", missing)
+		}
+		return ""
+	})
+}
+
+// WaitForAbsence waits for text to disappear from the terminal, e.g. a
+// dialog to close or a spinner to stop. On timeout it fails the test and
+// dumps the last few screens.
+func WaitForAbsence(t *testing.T, term *vttest.Terminal, text string, timeout time.Duration) {
+	t.Helper()
+	waitFor(t, screenText(term), timeout, fmt.Sprintf("%q to disappear", text), func(screen string) string {
+		if strings.Contains(screen, text) {
+			return "still shown"
+		}
+		return ""
+	})
+}
+
+// screenText returns a function taking a snapshot of term as text.
+func screenText(term *vttest.Terminal) func() string {
+	return func() string {
+		return SnapshotText(term.Snapshot())
+	}
+}
+
+// waitFor polls screen until check returns an empty reason for its text. On
+// timeout it fails the test with the last reason and the last few distinct
+// screens.
+func waitFor(t testing.TB, screen func() string, timeout time.Duration, what string, check func(text string) string) {
+	t.Helper()
+
+	type shown struct {
+		text string
+		at   time.Duration
+	}
+	var recent []shown
+
+	start := time.Now()
+	for {
+		text := screen()
+		reason := check(text)
+		if reason == "" {
+			return
+		}
+		if len(recent) == 0 || recent[len(recent)-1].text != text {
+			recent = append(recent, shown{text: text, at: time.Since(start)})
+			if len(recent) > failureSnapshots {
+				recent = recent[1:]
+			}
+		}
+
+		if time.Since(start) >= timeout {
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "Timed out after %s waiting for %s: %s", timeout, what, reason)
+			for i, s := range recent {
+				fmt.Fprintf(&sb, "\n--- screen %d of %d, at %s ---\n%s", i+1, len(recent), s.at.Round(time.Millisecond), s.text)
+			}
+			t.Fatal(sb.String())
+			return
+		}
+		time.Sleep(pollInterval)
+	}
+}
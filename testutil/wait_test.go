@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fatalRecorder records Fatal instead of stopping the test.
+type fatalRecorder struct {
+	testing.TB
+	msg string
+}
+
+func (r *fatalRecorder) Helper() {}
+
+func (r *fatalRecorder) Fatal(args ...any) {
+	r.msg = fmt.Sprint(args...)
+}
+
+// screens returns a screen function showing frames in turn, then the last
+// one forever.
+func screens(frames ...string) func() string {
+	var mu sync.Mutex
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		frame := frames[0]
+		if len(frames) > 1 {
+			frames = frames[1:]
+		}
+		return frame
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	t.Parallel()
+
+	rec := &fatalRecorder{TB: t}
+	var seen []string
+	waitFor(rec, screens("loading", "loading", "> ready"), time.Second, "ready", func(text string) string {
+		seen = append(seen, text)
+		if text != "> ready" {
+			return "not ready"
+		}
+		return ""
+	})
+	require.Empty(t, rec.msg)
+	require.Equal(t, []string{"loading", "loading", "> ready"}, seen)
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	t.Parallel()
+
+	rec := &fatalRecorder{TB: t}
+	frames := screens("alpha", "beta", "gamma", "delta", "delta")
+	waitFor(rec, frames, 500*time.Millisecond, `"done"`, func(string) string { return `missing ["done"]` })
+
+	require.Contains(t, rec.msg, `Timed out after 500ms waiting for "done": missing ["done"]`)
+	// Only the last distinct screens are dumped.
+	require.NotContains(t, rec.msg, "alpha")
+	require.Regexp(t, `--- screen 1 of 3, at \d+ms ---\nbeta\n`, rec.msg)
+	require.Regexp(t, `--- screen 3 of 3, at \d+ms ---\ndelta$`, rec.msg)
+}